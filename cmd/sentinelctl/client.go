@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"agent-sentinel/internal/admin"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/ratelimit"
+)
+
+// client is a thin wrapper over the proxy's admin API.
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func (c *client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.baseURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return apiError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func apiError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error.Message != "" {
+		return fmt.Errorf("admin API: %s (HTTP %d)", body.Error.Message, resp.StatusCode)
+	}
+	return fmt.Errorf("admin API: HTTP %d", resp.StatusCode)
+}
+
+func (c *client) ListTenants(ctx context.Context) ([]admin.TenantSpend, error) {
+	var out struct {
+		Tenants []admin.TenantSpend `json:"tenants"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/tenants", nil, &out)
+	return out.Tenants, err
+}
+
+func (c *client) GetTenant(ctx context.Context, tenantID string) (admin.TenantSpend, error) {
+	var out admin.TenantSpend
+	err := c.do(ctx, http.MethodGet, "/admin/tenants/"+url.PathEscape(tenantID), nil, &out)
+	return out, err
+}
+
+func (c *client) SetLimit(ctx context.Context, tenantID string, limit float64) (admin.TenantSpend, error) {
+	var out admin.TenantSpend
+	err := c.do(ctx, http.MethodPut, "/admin/tenants/"+url.PathEscape(tenantID)+"/limit", map[string]any{"limit": limit}, &out)
+	return out, err
+}
+
+func (c *client) PurgeTenant(ctx context.Context, tenantID string) error {
+	return c.do(ctx, http.MethodDelete, "/admin/tenants/"+url.PathEscape(tenantID), nil, nil)
+}
+
+func (c *client) GetCredits(ctx context.Context, tenantID string) (admin.TenantCredits, error) {
+	var out admin.TenantCredits
+	err := c.do(ctx, http.MethodGet, "/admin/tenants/"+url.PathEscape(tenantID)+"/credits", nil, &out)
+	return out, err
+}
+
+func (c *client) TopUpCredits(ctx context.Context, tenantID string, amount float64) (admin.TenantCredits, error) {
+	var out admin.TenantCredits
+	err := c.do(ctx, http.MethodPost, "/admin/tenants/"+url.PathEscape(tenantID)+"/credits", map[string]any{"amount": amount}, &out)
+	return out, err
+}
+
+func (c *client) ShadowMode(ctx context.Context) (bool, error) {
+	var out struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/shadow-mode", nil, &out)
+	return out.Enabled, err
+}
+
+func (c *client) SetShadowMode(ctx context.Context, enabled bool) error {
+	return c.do(ctx, http.MethodPut, "/admin/shadow-mode", map[string]any{"enabled": enabled}, nil)
+}
+
+func (c *client) PendingSettlements(ctx context.Context) ([]ratelimit.PendingSettlement, error) {
+	var out struct {
+		Pending []ratelimit.PendingSettlement `json:"pending"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/settlements/pending", nil, &out)
+	return out.Pending, err
+}
+
+func (c *client) Snapshot(ctx context.Context) (*ratelimit.Snapshot, error) {
+	var out ratelimit.Snapshot
+	if err := c.do(ctx, http.MethodGet, "/admin/snapshot", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *client) RestoreSnapshot(ctx context.Context, snap *ratelimit.Snapshot) (int, error) {
+	var out struct {
+		Restored int `json:"restored"`
+	}
+	err := c.do(ctx, http.MethodPost, "/admin/snapshot/restore", snap, &out)
+	return out.Restored, err
+}
+
+func (c *client) RecentEvents(ctx context.Context, eventType string, limit int) ([]events.Event, error) {
+	q := url.Values{}
+	if eventType != "" {
+		q.Set("type", eventType)
+	}
+	if limit > 0 {
+		q.Set("limit", fmt.Sprint(limit))
+	}
+	var out struct {
+		Events []events.Event `json:"events"`
+	}
+	err := c.do(ctx, http.MethodGet, "/admin/events?"+q.Encode(), nil, &out)
+	return out.Events, err
+}
+
+// TailEvents streams events from the SSE endpoint, calling fn for each until ctx
+// is cancelled or the server closes the stream.
+func (c *client) TailEvents(ctx context.Context, eventType string, fn func(events.Event)) error {
+	path := "/admin/events/stream"
+	if eventType != "" {
+		path += "?type=" + url.QueryEscape(eventType)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.baseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	// The stream is long-lived; don't apply the client-wide timeout.
+	streamClient := *c.http
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return apiError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev events.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			continue
+		}
+		fn(ev)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,345 @@
+// Command sentinelctl is a CLI for the Agent Sentinel admin API.
+//
+//	sentinelctl [-addr URL] [-token TOKEN] <command> [args]
+//
+// The admin URL and token default to SENTINEL_ADMIN_URL and ADMIN_TOKEN.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"agent-sentinel/internal/admin"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/ratelimit"
+)
+
+const usage = `Usage: sentinelctl [flags] <command> [args]
+
+Commands:
+  tenants                      List tenants with spend in the current window
+  spend <tenant>               Show spend, limit and remaining budget for a tenant
+  set-limit <tenant> <amount>  Set a tenant's hourly spend limit (USD)
+  purge <tenant> -yes          Delete a tenant's spend and custom limit
+  credits <tenant>             Show a tenant's prepaid credit balance
+  top-up <tenant> <amount>     Add prepaid credits (negative amounts deduct)
+  shadow [on|off]              Show or toggle shadow mode (log denials, don't enforce)
+  pending                      List failed cost adjustments/refunds awaiting retry
+  snapshot [-out FILE]         Export every tenant's spend, limit and credits as JSON
+  restore -yes <FILE>          Restore a snapshot, replacing the listed tenants' state
+  events [-type T] [-limit N] [-f]
+                               Print recent events as JSON lines; -f tails new ones
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sentinelctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("SENTINEL_ADMIN_URL", "http://localhost:9090"), "admin API base URL")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin API bearer token")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	output := fs.String("o", "table", "output format for tenants/spend/pending: table or json")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c := &client{baseURL: *addr, token: *token, http: &http.Client{Timeout: *timeout}}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+
+	var err error
+	switch cmd {
+	case "tenants":
+		err = cmdTenants(ctx, c, *output, stdout)
+	case "spend":
+		err = cmdSpend(ctx, c, rest, *output, stdout)
+	case "set-limit":
+		err = cmdSetLimit(ctx, c, rest, stdout)
+	case "purge":
+		err = cmdPurge(ctx, c, rest, stdout, stderr)
+	case "credits":
+		err = cmdCredits(ctx, c, rest, stdout)
+	case "top-up":
+		err = cmdTopUp(ctx, c, rest, stdout)
+	case "shadow":
+		err = cmdShadow(ctx, c, rest, stdout)
+	case "events":
+		err = cmdEvents(ctx, c, rest, stdout, stderr)
+	case "pending":
+		err = cmdPending(ctx, c, *output, stdout)
+	case "snapshot":
+		err = cmdSnapshot(ctx, c, rest, stdout, stderr)
+	case "restore":
+		err = cmdRestore(ctx, c, rest, stdout, stderr)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr):
+		fmt.Fprintln(stderr, "sentinelctl:", err)
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, "sentinelctl:", err)
+		return 1
+	}
+	return 0
+}
+
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func cmdTenants(ctx context.Context, c *client, output string, w io.Writer) error {
+	tenants, err := c.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	return printTenants(w, output, tenants)
+}
+
+func cmdSpend(ctx context.Context, c *client, args []string, output string, w io.Writer) error {
+	if len(args) != 1 {
+		return usageError("usage: spend <tenant>")
+	}
+	t, err := c.GetTenant(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printTenants(w, output, []admin.TenantSpend{t})
+}
+
+func cmdSetLimit(ctx context.Context, c *client, args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return usageError("usage: set-limit <tenant> <amount>")
+	}
+	limit, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || limit < 0 {
+		return usageError(fmt.Sprintf("invalid amount %q", args[1]))
+	}
+	t, err := c.SetLimit(ctx, args[0], limit)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s limit set to $%.2f (spend $%.4f)\n", t.TenantID, t.Limit, t.Spend)
+	return nil
+}
+
+func cmdPurge(ctx context.Context, c *client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	yes := fs.Bool("yes", false, "confirm deletion")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return usageError("usage: purge <tenant> -yes")
+	}
+	if !*yes {
+		return usageError("refusing to purge without -yes")
+	}
+	tenantID := fs.Arg(0)
+	if err := c.PurgeTenant(ctx, tenantID); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s purged\n", tenantID)
+	return nil
+}
+
+func cmdCredits(ctx context.Context, c *client, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return usageError("usage: credits <tenant>")
+	}
+	credits, err := c.GetCredits(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s balance $%.2f\n", credits.TenantID, credits.Balance)
+	return nil
+}
+
+func cmdTopUp(ctx context.Context, c *client, args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return usageError("usage: top-up <tenant> <amount>")
+	}
+	amount, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || amount == 0 {
+		return usageError(fmt.Sprintf("invalid amount %q", args[1]))
+	}
+	credits, err := c.TopUpCredits(ctx, args[0], amount)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s balance $%.2f\n", credits.TenantID, credits.Balance)
+	return nil
+}
+
+func cmdShadow(ctx context.Context, c *client, args []string, w io.Writer) error {
+	switch {
+	case len(args) == 0:
+		enabled, err := c.ShadowMode(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "shadow mode %s\n", onOff(enabled))
+		return nil
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		enabled := args[0] == "on"
+		if err := c.SetShadowMode(ctx, enabled); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "shadow mode %s\n", onOff(enabled))
+		return nil
+	}
+	return usageError("usage: shadow [on|off]")
+}
+
+func cmdEvents(ctx context.Context, c *client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	eventType := fs.String("type", "", "filter by event type (rate_limit_denied, loop_detected, loop_bypass, admin_action, provider_operation)")
+	limit := fs.Int("limit", 50, "number of recent events")
+	follow := fs.Bool("f", false, "keep streaming new events")
+	if err := fs.Parse(args); err != nil {
+		return usageError("usage: events [-type T] [-limit N] [-f]")
+	}
+
+	enc := json.NewEncoder(stdout)
+	recent, err := c.RecentEvents(ctx, *eventType, *limit)
+	if err != nil {
+		return err
+	}
+	// The API returns newest first; print oldest first so -f output reads in order.
+	for i := len(recent) - 1; i >= 0; i-- {
+		_ = enc.Encode(recent[i])
+	}
+	if !*follow {
+		return nil
+	}
+
+	var lastID uint64
+	if len(recent) > 0 {
+		lastID = recent[0].ID
+	}
+	return c.TailEvents(ctx, *eventType, func(ev events.Event) {
+		if ev.ID > lastID {
+			_ = enc.Encode(ev)
+		}
+	})
+}
+
+func cmdPending(ctx context.Context, c *client, output string, w io.Writer) error {
+	pending, err := c.PendingSettlements(ctx)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return json.NewEncoder(w).Encode(pending)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OP\tTENANT\tESTIMATE\tACTUAL\tATTEMPTS\tFAILED_AT\tLAST_ERROR")
+	for _, p := range pending {
+		fmt.Fprintf(tw, "%s\t%s\t%.4f\t%.4f\t%d\t%s\t%s\n", p.Op, p.TenantID, p.Estimate, p.Actual, p.Attempts, p.FailedAt.Format(time.RFC3339), p.LastError)
+	}
+	return tw.Flush()
+}
+
+func cmdSnapshot(ctx context.Context, c *client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := fs.String("out", "", "write the snapshot to FILE instead of stdout")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return usageError("usage: snapshot [-out FILE]")
+	}
+	snap, err := c.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return json.NewEncoder(stdout).Encode(snap)
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d tenants written to %s\n", len(snap.Tenants), *out)
+	return nil
+}
+
+func cmdRestore(ctx context.Context, c *client, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	yes := fs.Bool("yes", false, "confirm overwriting the listed tenants")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return usageError("usage: restore -yes <FILE>")
+	}
+	if !*yes {
+		return usageError("refusing to restore without -yes")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var snap ratelimit.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	restored, err := c.RestoreSnapshot(ctx, &snap)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d tenants restored from snapshot taken %s\n", restored, snap.TakenAt.Format(time.RFC3339))
+	return nil
+}
+
+func printTenants(w io.Writer, output string, tenants []admin.TenantSpend) error {
+	if output == "json" {
+		return json.NewEncoder(w).Encode(tenants)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tSPEND\tLIMIT\tREMAINING\tOVERDRAFT")
+	for _, t := range tenants {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.2f\t%.4f\t%.4f\n", t.TenantID, t.Spend, t.Limit, t.Remaining, t.Overdraft)
+	}
+	return tw.Flush()
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
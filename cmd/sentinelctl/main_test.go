@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/admin"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/ratelimit"
+)
+
+type fakeStore struct {
+	spend   map[string]float64
+	limit   map[string]float64
+	credits map[string]float64
+	pending []ratelimit.PendingSettlement
+	shadow  bool
+}
+
+func (f *fakeStore) ListTenants(ctx context.Context) ([]string, error) {
+	var ids []string
+	for id := range f.spend {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+func (f *fakeStore) GetSpend(ctx context.Context, tenantID string) (float64, error) {
+	return f.spend[tenantID], nil
+}
+func (f *fakeStore) GetLimit(ctx context.Context, tenantID string) (float64, error) {
+	return f.limit[tenantID], nil
+}
+func (f *fakeStore) SetLimit(ctx context.Context, tenantID string, limit float64) error {
+	f.limit[tenantID] = limit
+	return nil
+}
+func (f *fakeStore) PurgeTenant(ctx context.Context, tenantID string) error {
+	delete(f.spend, tenantID)
+	delete(f.limit, tenantID)
+	return nil
+}
+func (f *fakeStore) ShadowMode(ctx context.Context) (bool, error) { return f.shadow, nil }
+func (f *fakeStore) SetShadowMode(ctx context.Context, enabled bool) error {
+	f.shadow = enabled
+	return nil
+}
+
+func (f *fakeStore) GetCredits(ctx context.Context, tenantID string) (float64, error) {
+	return f.credits[tenantID], nil
+}
+func (f *fakeStore) TopUpCredits(ctx context.Context, tenantID string, amount float64) (float64, error) {
+	f.credits[tenantID] += amount
+	return f.credits[tenantID], nil
+}
+
+func (f *fakeStore) PendingSettlements(ctx context.Context, limit int) ([]ratelimit.PendingSettlement, error) {
+	return f.pending, nil
+}
+
+func (f *fakeStore) QueryUsage(ctx context.Context, q ratelimit.UsageQuery) ([]ratelimit.UsageRecord, string, error) {
+	return nil, "", nil
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *fakeStore, *events.Recorder) {
+	t.Helper()
+	store := &fakeStore{spend: map[string]float64{"acme": 2.5}, limit: map[string]float64{"acme": 10}, credits: map[string]float64{}}
+	recorder := events.NewRecorder(10)
+	server := httptest.NewServer(admin.NewHandler(store, recorder, admin.Options{Token: "secret"}))
+	t.Cleanup(server.Close)
+	return server, store, recorder
+}
+
+func runCLI(t *testing.T, addr string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"-addr", addr, "-token", "secret"}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSpendAndSetLimit(t *testing.T) {
+	server, store, _ := newTestServer(t)
+
+	code, out, _ := runCLI(t, server.URL, "spend", "acme")
+	if code != 0 || !strings.Contains(out, "acme") || !strings.Contains(out, "7.5000") {
+		t.Fatalf("unexpected spend output (code %d): %s", code, out)
+	}
+
+	code, _, errOut := runCLI(t, server.URL, "set-limit", "acme", "25")
+	if code != 0 {
+		t.Fatalf("set-limit failed: %s", errOut)
+	}
+	if store.limit["acme"] != 25 {
+		t.Fatalf("expected limit 25, got %v", store.limit["acme"])
+	}
+
+	if code, _, _ := runCLI(t, server.URL, "set-limit", "acme", "lots"); code != 2 {
+		t.Fatalf("expected usage error for invalid amount, got %d", code)
+	}
+}
+
+func TestPurgeRequiresConfirmation(t *testing.T) {
+	server, store, _ := newTestServer(t)
+
+	if code, _, _ := runCLI(t, server.URL, "purge", "acme"); code != 2 {
+		t.Fatalf("expected usage error without -yes, got %d", code)
+	}
+	if _, ok := store.spend["acme"]; !ok {
+		t.Fatalf("tenant should not be purged without -yes")
+	}
+	if code, _, errOut := runCLI(t, server.URL, "purge", "-yes", "acme"); code != 0 {
+		t.Fatalf("purge failed: %s", errOut)
+	}
+	if _, ok := store.spend["acme"]; ok {
+		t.Fatalf("expected tenant to be purged")
+	}
+}
+
+func TestShadowToggle(t *testing.T) {
+	server, store, _ := newTestServer(t)
+
+	if code, out, _ := runCLI(t, server.URL, "shadow", "on"); code != 0 || !strings.Contains(out, "on") {
+		t.Fatalf("unexpected shadow output (code %d): %s", code, out)
+	}
+	if !store.shadow {
+		t.Fatalf("expected shadow mode enabled")
+	}
+}
+
+func TestPendingListsSettlements(t *testing.T) {
+	server, store, _ := newTestServer(t)
+	store.pending = []ratelimit.PendingSettlement{{Op: ratelimit.OpRefundEstimate, TenantID: "acme", Estimate: 1.25, Attempts: 3, LastError: "i/o timeout"}}
+
+	code, out, _ := runCLI(t, server.URL, "pending")
+	if code != 0 || !strings.Contains(out, "refund_estimate") || !strings.Contains(out, "i/o timeout") {
+		t.Fatalf("unexpected pending output (code %d): %s", code, out)
+	}
+}
+
+func TestEventsPrintsOldestFirst(t *testing.T) {
+	server, _, recorder := newTestServer(t)
+	recorder.Record(events.TypeRateLimitDenied, "first", nil)
+	recorder.Record(events.TypeRateLimitDenied, "second", nil)
+
+	code, out, _ := runCLI(t, server.URL, "events", "-type", events.TypeRateLimitDenied)
+	if code != 0 {
+		t.Fatalf("events failed with code %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "first") || !strings.Contains(lines[1], "second") {
+		t.Fatalf("unexpected events output: %s", out)
+	}
+}
+
+func TestBadTokenFails(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-addr", server.URL, "-token", "wrong", "tenants"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "unauthorized") {
+		t.Fatalf("expected auth failure, got code %d: %s", code, stderr.String())
+	}
+}
+
+func TestCreditsTopUp(t *testing.T) {
+	server, store, _ := newTestServer(t)
+
+	if code, out, errOut := runCLI(t, server.URL, "top-up", "acme", "40"); code != 0 || !strings.Contains(out, "40.00") {
+		t.Fatalf("unexpected top-up result (code %d): %s %s", code, out, errOut)
+	}
+	if store.credits["acme"] != 40 {
+		t.Fatalf("expected balance 40, got %v", store.credits["acme"])
+	}
+	if code, out, _ := runCLI(t, server.URL, "credits", "acme"); code != 0 || !strings.Contains(out, "40.00") {
+		t.Fatalf("unexpected credits output (code %d): %s", code, out)
+	}
+}
+
+type snapshotStore struct {
+	fakeStore
+	restored *ratelimit.Snapshot
+}
+
+func (s *snapshotStore) Snapshot(ctx context.Context) (*ratelimit.Snapshot, error) {
+	limit := s.limit["acme"]
+	return &ratelimit.Snapshot{
+		Format:        ratelimit.SnapshotFormat,
+		SchemaVersion: ratelimit.SchemaVersion(),
+		Tenants:       []ratelimit.TenantSnapshot{{TenantID: "acme", Limit: &limit}},
+	}, nil
+}
+
+func (s *snapshotStore) Restore(ctx context.Context, snap *ratelimit.Snapshot) (int, error) {
+	s.restored = snap
+	return len(snap.Tenants), nil
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	store := &snapshotStore{fakeStore: fakeStore{limit: map[string]float64{"acme": 10}}}
+	server := httptest.NewServer(admin.NewHandler(store, events.NewRecorder(10), admin.Options{Token: "secret"}))
+	t.Cleanup(server.Close)
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+
+	if code, out, errOut := runCLI(t, server.URL, "snapshot", "-out", file); code != 0 || !strings.Contains(out, "1 tenants") {
+		t.Fatalf("snapshot failed (code %d): %s %s", code, out, errOut)
+	}
+	if code, _, _ := runCLI(t, server.URL, "restore", file); code != 2 {
+		t.Fatalf("expected usage error without -yes, got %d", code)
+	}
+	if store.restored != nil {
+		t.Fatal("snapshot should not be restored without -yes")
+	}
+	if code, out, errOut := runCLI(t, server.URL, "restore", "-yes", file); code != 0 || !strings.Contains(out, "1 tenants restored") {
+		t.Fatalf("restore failed (code %d): %s %s", code, out, errOut)
+	}
+	if store.restored == nil || *store.restored.Tenants[0].Limit != 10 {
+		t.Fatalf("expected the snapshot to be restored, got %+v", store.restored)
+	}
+}
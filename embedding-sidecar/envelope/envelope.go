@@ -0,0 +1,253 @@
+// Package envelope encrypts tenant data at rest with envelope encryption. Each
+// tenant's data is sealed with its own AES-256-GCM data key, and data keys are
+// only stored wrapped (encrypted) by a master key. Deleting a tenant's wrapped
+// data key makes everything sealed with it unreadable.
+//
+// It is shared by the proxy (usage log) and the embedding sidecar (stored
+// prompts).
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"embedding-sidecar/redisconf"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sealedPrefix marks sealed values so plaintext written before encryption was
+// enabled can still be read.
+const sealedPrefix = "enc:v1:"
+
+// dataKeyPrefix namespaces wrapped data keys in Redis.
+const dataKeyPrefix = "dek:"
+
+const keySize = 32
+
+// ErrMalformed is returned for sealed values that cannot be decoded.
+var ErrMalformed = errors.New("envelope: malformed sealed value")
+
+// Wrapper encrypts and decrypts data keys with a master key.
+type Wrapper interface {
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// LocalWrapper wraps data keys with a master key held in process memory.
+type LocalWrapper struct {
+	aead cipher.AEAD
+}
+
+// NewLocalWrapper returns a wrapper for a 32-byte AES-256 master key.
+func NewLocalWrapper(masterKey []byte) (*LocalWrapper, error) {
+	if len(masterKey) != keySize {
+		return nil, fmt.Errorf("envelope: master key must be %d bytes, got %d", keySize, len(masterKey))
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalWrapper{aead: aead}, nil
+}
+
+func (w *LocalWrapper) Wrap(_ context.Context, dataKey []byte) ([]byte, error) {
+	return seal(w.aead, dataKey, nil)
+}
+
+func (w *LocalWrapper) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	return open(w.aead, wrapped, nil)
+}
+
+// KeyStore persists wrapped data keys.
+type KeyStore interface {
+	// LoadOrStore returns the tenant's wrapped data key, storing wrapped first
+	// when there is none. Concurrent callers all get the same key.
+	LoadOrStore(ctx context.Context, tenantID string, wrapped []byte) ([]byte, error)
+	// Delete removes the tenant's wrapped data key.
+	Delete(ctx context.Context, tenantID string) error
+}
+
+// RedisKeyStore keeps wrapped data keys in Redis under dek:{tenant}, without
+// expiry.
+type RedisKeyStore struct {
+	client    redis.UniversalClient
+	namespace string
+}
+
+// NewRedisKeyStore returns a key store backed by client, keeping its keys in
+// namespace (see redisconf.Key).
+func NewRedisKeyStore(client redis.UniversalClient, namespace string) *RedisKeyStore {
+	return &RedisKeyStore{client: client, namespace: namespace}
+}
+
+func (s *RedisKeyStore) key(tenantID string) string {
+	return redisconf.Key(s.namespace, dataKeyPrefix+tenantID)
+}
+
+func (s *RedisKeyStore) LoadOrStore(ctx context.Context, tenantID string, wrapped []byte) ([]byte, error) {
+	key := s.key(tenantID)
+	if err := s.client.SetNX(ctx, key, wrapped, 0).Err(); err != nil {
+		return nil, err
+	}
+	return s.client.Get(ctx, key).Bytes()
+}
+
+func (s *RedisKeyStore) Delete(ctx context.Context, tenantID string) error {
+	return s.client.Del(ctx, s.key(tenantID)).Err()
+}
+
+// Keyring seals and opens tenant data, caching unwrapped data keys. A nil
+// Keyring passes data through unencrypted. Safe for concurrent use.
+type Keyring struct {
+	wrapper Wrapper
+	store   KeyStore
+
+	mu   sync.Mutex
+	keys map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring wrapping data keys with wrapper and persisting
+// them in store.
+func NewKeyring(wrapper Wrapper, store KeyStore) *Keyring {
+	return &Keyring{wrapper: wrapper, store: store, keys: make(map[string]cipher.AEAD)}
+}
+
+// FromEnv returns a keyring using the base64 ENCRYPTION_MASTER_KEY (32 bytes,
+// e.g. from `openssl rand -base64 32`) and data keys stored in client under
+// namespace, or nil when the variable is unset.
+func FromEnv(client redis.UniversalClient, namespace string) (*Keyring, error) {
+	encoded := strings.TrimSpace(os.Getenv("ENCRYPTION_MASTER_KEY"))
+	if encoded == "" {
+		return nil, nil
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: ENCRYPTION_MASTER_KEY is not base64: %w", err)
+	}
+	wrapper, err := NewLocalWrapper(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(wrapper, NewRedisKeyStore(client, namespace)), nil
+}
+
+// Seal encrypts plaintext with the tenant's data key, creating the key on first
+// use. The tenant ID is authenticated, so a value cannot be opened as another
+// tenant's.
+func (k *Keyring) Seal(ctx context.Context, tenantID, plaintext string) (string, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	aead, err := k.dataKey(ctx, tenantID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(plaintext), []byte(tenantID))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal. Values that were never sealed are
+// returned unchanged.
+func (k *Keyring) Open(ctx context.Context, tenantID, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if k == nil {
+		return "", errors.New("envelope: value is sealed but encryption is not configured")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	aead, err := k.dataKey(ctx, tenantID)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, sealed, []byte(tenantID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Forget deletes the tenant's data key, making its sealed data unreadable.
+func (k *Keyring) Forget(ctx context.Context, tenantID string) error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	delete(k.keys, tenantID)
+	k.mu.Unlock()
+	return k.store.Delete(ctx, tenantID)
+}
+
+func (k *Keyring) dataKey(ctx context.Context, tenantID string) (cipher.AEAD, error) {
+	k.mu.Lock()
+	aead, ok := k.keys[tenantID]
+	k.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	fresh := make([]byte, keySize)
+	if _, err := rand.Read(fresh); err != nil {
+		return nil, err
+	}
+	wrapped, err := k.wrapper.Wrap(ctx, fresh)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: wrap data key: %w", err)
+	}
+	stored, err := k.store.LoadOrStore(ctx, tenantID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: load data key: %w", err)
+	}
+	dataKey, err := k.wrapper.Unwrap(ctx, stored)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: unwrap data key: %w", err)
+	}
+	if aead, err = newAEAD(dataKey); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[tenantID] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
@@ -0,0 +1,88 @@
+package envelope
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (s *memoryKeyStore) LoadOrStore(_ context.Context, tenantID string, wrapped []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[tenantID]; ok {
+		return existing, nil
+	}
+	s.keys[tenantID] = wrapped
+	return wrapped, nil
+}
+
+func (s *memoryKeyStore) Delete(_ context.Context, tenantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, tenantID)
+	return nil
+}
+
+func newTestKeyring(t *testing.T, store KeyStore) *Keyring {
+	t.Helper()
+	wrapper, err := NewLocalWrapper([]byte(strings.Repeat("k", keySize)))
+	if err != nil {
+		t.Fatalf("NewLocalWrapper: %v", err)
+	}
+	return NewKeyring(wrapper, store)
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryKeyStore{keys: map[string][]byte{}}
+	k := newTestKeyring(t, store)
+
+	sealed, err := k.Seal(ctx, "acme", "summarize the incident report")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "incident") {
+		t.Fatalf("unexpected sealed value %q", sealed)
+	}
+
+	// A fresh keyring (another replica) unwraps the stored data key.
+	got, err := newTestKeyring(t, store).Open(ctx, "acme", sealed)
+	if err != nil || got != "summarize the incident report" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, err := k.Open(ctx, "other", sealed); err == nil {
+		t.Fatal("expected another tenant's key to fail")
+	}
+	if got, err := k.Open(ctx, "acme", "written before encryption"); err != nil || got != "written before encryption" {
+		t.Fatalf("expected plaintext passthrough, got %q, %v", got, err)
+	}
+}
+
+func TestForgetShredsTenantData(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryKeyStore{keys: map[string][]byte{}}
+	k := newTestKeyring(t, store)
+	sealed, _ := k.Seal(ctx, "acme", "secret")
+	if err := k.Forget(ctx, "acme"); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if _, err := k.Open(ctx, "acme", sealed); err == nil {
+		t.Fatal("expected data sealed with a forgotten key to be unreadable")
+	}
+}
+
+func TestNilKeyringPassesThrough(t *testing.T) {
+	var k *Keyring
+	if got, _ := k.Seal(context.Background(), "acme", "plain"); got != "plain" {
+		t.Fatalf("expected passthrough, got %q", got)
+	}
+	if _, err := k.Open(context.Background(), "acme", sealedPrefix+"AAAA"); err == nil {
+		t.Fatal("expected sealed value without a keyring to fail")
+	}
+}
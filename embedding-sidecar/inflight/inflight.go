@@ -0,0 +1,110 @@
+// Package inflight runs background work with bounded concurrency and tracks
+// it to completion, so a shutdown can wait for in-flight Redis writes and
+// count the ones it gives up on.
+//
+// It is shared by the proxy (async cost adjustments, usage records) and the
+// embedding sidecar (embedding writes and pruning).
+package inflight
+
+import (
+	"context"
+	"sync"
+)
+
+// Group tracks work from the moment it is handed over until it finishes,
+// including work still waiting for a concurrency slot or sitting in a queue.
+// A nil *Group runs work untracked.
+type Group struct {
+	sem chan struct{} // nil: unbounded
+
+	mu      sync.Mutex
+	pending int64
+	idle    chan struct{} // closed when pending drops to zero
+}
+
+// NewGroup returns a Group running at most limit functions at once; 0 or
+// less means no limit.
+func NewGroup(limit int) *Group {
+	g := &Group{}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g
+}
+
+// Go runs fn in a new goroutine once a slot is free, tracked until it
+// returns.
+func (g *Group) Go(fn func()) {
+	if g == nil {
+		go fn()
+		return
+	}
+	done := g.Begin()
+	go func() {
+		defer done()
+		if g.sem != nil {
+			g.sem <- struct{}{}
+			defer func() { <-g.sem }()
+		}
+		fn()
+	}()
+}
+
+// Begin tracks work run by the caller, for instance handed to a worker
+// through a queue. The returned function ends it and must be called exactly
+// once, also when the work is dropped.
+func (g *Group) Begin() (done func()) {
+	if g == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	if g.pending == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.pending++
+	g.mu.Unlock()
+	var once sync.Once
+	return func() { once.Do(g.end) }
+}
+
+func (g *Group) end() {
+	g.mu.Lock()
+	g.pending--
+	if g.pending == 0 {
+		close(g.idle)
+	}
+	g.mu.Unlock()
+}
+
+// Pending returns the work queued or running.
+func (g *Group) Pending() int64 {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pending
+}
+
+// Wait blocks until all tracked work finishes or ctx expires, and returns
+// how much is still pending (0 when fully drained). At shutdown, that is the
+// work lost.
+func (g *Group) Wait(ctx context.Context) int {
+	if g == nil {
+		return 0
+	}
+	for {
+		g.mu.Lock()
+		n, ch := g.pending, g.idle
+		g.mu.Unlock()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ch:
+			// Work started while draining reopens idle; check again.
+		case <-ctx.Done():
+			return int(g.Pending())
+		}
+	}
+}
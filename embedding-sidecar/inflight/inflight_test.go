@@ -0,0 +1,69 @@
+package inflight
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupBoundsConcurrency(t *testing.T) {
+	g := NewGroup(2)
+	var running, peak atomic.Int32
+	for range 6 {
+		g.Go(func() {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	if pending := g.Pending(); pending != 6 {
+		t.Fatalf("expected work waiting for a slot to count as pending, got %d", pending)
+	}
+	if lost := g.Wait(context.Background()); lost != 0 {
+		t.Fatalf("expected a full drain, got %d pending", lost)
+	}
+	if peak.Load() > 2 {
+		t.Fatalf("expected at most 2 concurrent, saw %d", peak.Load())
+	}
+}
+
+func TestGroupWaitCountsLostWork(t *testing.T) {
+	g := NewGroup(0)
+	release := make(chan struct{})
+	g.Go(func() { <-release })
+	done := g.Begin()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if lost := g.Wait(ctx); lost != 2 {
+		t.Fatalf("expected 2 pending at the deadline, got %d", lost)
+	}
+
+	done()
+	done() // ending twice is harmless
+	close(release)
+	if lost := g.Wait(context.Background()); lost != 0 {
+		t.Fatalf("expected a full drain, got %d pending", lost)
+	}
+	if pending := g.Pending(); pending != 0 {
+		t.Fatalf("expected nothing pending, got %d", pending)
+	}
+}
+
+func TestNilGroupRunsUntracked(t *testing.T) {
+	var g *Group
+	ran := make(chan struct{})
+	g.Go(func() { close(ran) })
+	<-ran
+	g.Begin()()
+	if g.Pending() != 0 || g.Wait(context.Background()) != 0 {
+		t.Fatalf("expected a nil group to track nothing")
+	}
+}
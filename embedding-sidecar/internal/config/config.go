@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"embedding-sidecar/redisconf"
 )
 
 type Config struct {
@@ -14,25 +17,102 @@ type Config struct {
 	EmbeddingTTL        time.Duration
 	EmbeddingModelPath  string
 	EmbeddingVocabPath  string
-	EmbeddingDim        int
+	// EmbeddingDim is the model's output dimension; 0 reads it from the model.
+	EmbeddingDim int
+	// EmbeddingIndexDim is the Redis index dimension; 0 uses the model's.
+	EmbeddingIndexDim   int
+	EmbeddingProjection string
+	EmbeddingPCAPath    string
+	// WarmupIterations and WarmupTexts configure the embeddings computed
+	// before the sidecar reports SERVING.
+	WarmupIterations int
+	WarmupTexts      []string
+	// Embedder is the embedding backend: "onnx" or "hashing".
+	Embedder string
+	// ONNXRuntimeLibrary is the ONNX Runtime shared library; empty searches
+	// the loader path and the usual install directories.
+	ONNXRuntimeLibrary  string
+	EmbeddingProvider   string
+	EmbeddingDeviceID   int
+	IntraOpThreads      int
+	InterOpThreads      int
+	EmbeddingNormalize  bool
+	DistanceMetric      string
 	EmbeddingOutputName string
 	GRPCTimeout         time.Duration
 	EmbeddingRedisURL   string
+	// Redis tunes the vector store client (TLS, ACL user, pool, timeouts).
+	Redis     redisconf.Settings
+	StoreMode string
+	// StoreWorkers, StoreQueueSize and StoreQueuePolicy size the background
+	// writer used in async store mode; StoreFlushTimeout bounds how long
+	// shutdown waits for queued writes.
+	StoreWorkers      int
+	StoreQueueSize    int
+	StoreQueuePolicy  string
+	StoreFlushTimeout time.Duration
+	// TouchOnHit refreshes the TTL of matched embeddings to HitTTL (0 uses
+	// EmbeddingTTL).
+	TouchOnHit bool
+	HitTTL     time.Duration
+	// Calibration* configure the threshold calibration job: how often it
+	// runs, the flag rate it targets, the per-tenant sample window, the
+	// bounds of its recommendations, and whether (and by how much per run)
+	// it applies them. Zero values use the detector defaults.
+	CalibrationEnabled      bool
+	CalibrationInterval     time.Duration
+	CalibrationTargetRate   float64
+	CalibrationWindow       int
+	CalibrationMinSamples   int
+	CalibrationMinThreshold float64
+	CalibrationMaxThreshold float64
+	CalibrationAutoApply    bool
+	CalibrationMaxStep      float64
 }
 
 func Load() Config {
 	return Config{
-		UDSPath:             getEnv("UDS_PATH", "/tmp/embedding-sidecar.sock"),
-		RedisURL:            getEnv("REDIS_URL", "redis://localhost:6379"),
-		EmbeddingRedisURL:   getEnv("EMBEDDING_REDIS_URL", getEnv("REDIS_URL", "redis://localhost:6379")),
-		SimilarityThreshold: getEnvFloat("LOOP_SIMILARITY_THRESHOLD", 0.95),
-		HistorySize:         getEnvInt("LOOP_HISTORY_SIZE", 5),
-		EmbeddingTTL:        time.Duration(getEnvInt("LOOP_EMBEDDING_TTL", 3600)) * time.Second,
-		EmbeddingModelPath:  getEnv("LOOP_EMBEDDING_MODEL_PATH", "models/all-MiniLM-L6-v2.onnx"),
-		EmbeddingVocabPath:  getEnv("LOOP_EMBEDDING_VOCAB_PATH", "models/vocab.txt"),
-		EmbeddingDim:        getEnvInt("LOOP_EMBEDDING_DIM", 384),
-		EmbeddingOutputName: getEnv("LOOP_EMBEDDING_OUTPUT_NAME", "last_hidden_state"),
-		GRPCTimeout:         time.Duration(getEnvInt("LOOP_EMBEDDING_SIDECAR_TIMEOUT_MS", 50)) * time.Millisecond,
+		UDSPath:                 getEnv("UDS_PATH", "/tmp/embedding-sidecar.sock"),
+		RedisURL:                getEnv("REDIS_URL", "redis://localhost:6379"),
+		EmbeddingRedisURL:       getEnv("EMBEDDING_REDIS_URL", getEnv("REDIS_URL", "redis://localhost:6379")),
+		SimilarityThreshold:     getEnvFloat("LOOP_SIMILARITY_THRESHOLD", 0.95),
+		HistorySize:             getEnvInt("LOOP_HISTORY_SIZE", 5),
+		EmbeddingTTL:            time.Duration(getEnvInt("LOOP_EMBEDDING_TTL", 3600)) * time.Second,
+		EmbeddingModelPath:      getEnv("LOOP_EMBEDDING_MODEL_PATH", "models/all-MiniLM-L6-v2.onnx"),
+		EmbeddingVocabPath:      getEnv("LOOP_EMBEDDING_VOCAB_PATH", "models/vocab.txt"),
+		EmbeddingDim:            getEnvInt("LOOP_EMBEDDING_DIM", 0),
+		EmbeddingIndexDim:       getEnvInt("LOOP_EMBEDDING_INDEX_DIM", 0),
+		EmbeddingProjection:     getEnv("LOOP_EMBEDDING_PROJECTION", "none"),
+		EmbeddingPCAPath:        getEnv("LOOP_EMBEDDING_PCA_PATH", ""),
+		Embedder:                getEnv("LOOP_EMBEDDER", "onnx"),
+		WarmupIterations:        getEnvInt("LOOP_EMBEDDING_WARMUP_ITERATIONS", 1),
+		WarmupTexts:             getEnvList("LOOP_EMBEDDING_WARMUP_TEXTS", "|"),
+		ONNXRuntimeLibrary:      getEnv("ONNXRUNTIME_LIB_PATH", ""),
+		EmbeddingProvider:       getEnv("LOOP_EMBEDDING_PROVIDER", "cpu"),
+		EmbeddingDeviceID:       getEnvInt("LOOP_EMBEDDING_DEVICE_ID", 0),
+		IntraOpThreads:          getEnvInt("LOOP_EMBEDDING_INTRA_OP_THREADS", 0),
+		InterOpThreads:          getEnvInt("LOOP_EMBEDDING_INTER_OP_THREADS", 0),
+		EmbeddingNormalize:      getEnvBool("LOOP_EMBEDDING_NORMALIZE", false),
+		DistanceMetric:          getEnv("LOOP_INDEX_DISTANCE_METRIC", "COSINE"),
+		EmbeddingOutputName:     getEnv("LOOP_EMBEDDING_OUTPUT_NAME", "last_hidden_state"),
+		GRPCTimeout:             time.Duration(getEnvInt("LOOP_EMBEDDING_SIDECAR_TIMEOUT_MS", 50)) * time.Millisecond,
+		StoreMode:               getEnv("LOOP_STORE_MODE", "async"),
+		StoreWorkers:            getEnvInt("LOOP_STORE_WORKERS", 8),
+		StoreQueueSize:          getEnvInt("LOOP_STORE_QUEUE_SIZE", 1024),
+		StoreQueuePolicy:        getEnv("LOOP_STORE_QUEUE_POLICY", "drop_oldest"),
+		StoreFlushTimeout:       time.Duration(getEnvInt("LOOP_STORE_FLUSH_TIMEOUT_MS", 5000)) * time.Millisecond,
+		TouchOnHit:              getEnvBool("LOOP_EMBEDDING_TOUCH_ON_HIT", false),
+		HitTTL:                  time.Duration(getEnvInt("LOOP_EMBEDDING_HIT_TTL", 0)) * time.Second,
+		CalibrationEnabled:      getEnvBool("LOOP_CALIBRATION_ENABLED", false),
+		CalibrationInterval:     time.Duration(getEnvInt("LOOP_CALIBRATION_INTERVAL", 300)) * time.Second,
+		CalibrationTargetRate:   getEnvFloat("LOOP_CALIBRATION_TARGET_FPR", 0.01),
+		CalibrationWindow:       getEnvInt("LOOP_CALIBRATION_WINDOW", 1000),
+		CalibrationMinSamples:   getEnvInt("LOOP_CALIBRATION_MIN_SAMPLES", 200),
+		CalibrationMinThreshold: getEnvFloat("LOOP_CALIBRATION_MIN_THRESHOLD", 0.85),
+		CalibrationMaxThreshold: getEnvFloat("LOOP_CALIBRATION_MAX_THRESHOLD", 0.99),
+		CalibrationAutoApply:    getEnvBool("LOOP_CALIBRATION_AUTO_APPLY", false),
+		CalibrationMaxStep:      getEnvFloat("LOOP_CALIBRATION_MAX_STEP", 0.02),
+		Redis:                   redisconf.FromEnv("EMBEDDING_REDIS_", "REDIS_"),
 	}
 }
 
@@ -43,6 +123,17 @@ func getEnv(key, defaultVal string) string {
 	return defaultVal
 }
 
+// getEnvList splits the value at key on sep, dropping empty entries.
+func getEnvList(key, sep string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func getEnvInt(key string, defaultVal int) int {
 	if v, ok := os.LookupEnv(key); ok && v != "" {
 		if parsed, err := strconv.Atoi(v); err == nil {
@@ -52,6 +143,15 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvBool(key string, defaultVal bool) bool {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			return parsed
+		}
+	}
+	return defaultVal
+}
+
 func getEnvFloat(key string, defaultVal float64) float64 {
 	if v, ok := os.LookupEnv(key); ok && v != "" {
 		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
@@ -10,6 +10,15 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.UDSPath == "" || cfg.RedisURL == "" || cfg.EmbeddingModelPath == "" {
 		t.Fatalf("expected defaults, got %+v", cfg)
 	}
+	if cfg.StoreMode != "async" {
+		t.Fatalf("expected default store mode async, got %q", cfg.StoreMode)
+	}
+	if cfg.Embedder != "onnx" || cfg.ONNXRuntimeLibrary != "" {
+		t.Fatalf("expected onnx embedder with a searched runtime library, got %q, %q", cfg.Embedder, cfg.ONNXRuntimeLibrary)
+	}
+	if cfg.WarmupIterations != 1 || cfg.WarmupTexts != nil {
+		t.Fatalf("expected one default warmup, got %d %v", cfg.WarmupIterations, cfg.WarmupTexts)
+	}
 	if cfg.EmbeddingTTL != time.Hour {
 		t.Fatalf("expected default ttl 1h, got %v", cfg.EmbeddingTTL)
 	}
@@ -27,6 +36,31 @@ func TestLoadOverrides(t *testing.T) {
 	t.Setenv("LOOP_EMBEDDING_DIM", "123")
 	t.Setenv("LOOP_EMBEDDING_OUTPUT_NAME", "out")
 	t.Setenv("LOOP_EMBEDDING_SIDECAR_TIMEOUT_MS", "250")
+	t.Setenv("LOOP_STORE_MODE", "sync")
+	t.Setenv("LOOP_STORE_WORKERS", "2")
+	t.Setenv("LOOP_STORE_QUEUE_SIZE", "16")
+	t.Setenv("LOOP_STORE_QUEUE_POLICY", "drop_newest")
+	t.Setenv("LOOP_STORE_FLUSH_TIMEOUT_MS", "750")
+	t.Setenv("LOOP_EMBEDDING_TOUCH_ON_HIT", "true")
+	t.Setenv("LOOP_EMBEDDING_HIT_TTL", "86400")
+	t.Setenv("LOOP_EMBEDDING_INDEX_DIM", "64")
+	t.Setenv("LOOP_EMBEDDING_PROJECTION", "pca")
+	t.Setenv("LOOP_EMBEDDING_PCA_PATH", "pca.json")
+	t.Setenv("ONNXRUNTIME_LIB_PATH", "/opt/ort/libonnxruntime.so")
+	t.Setenv("LOOP_EMBEDDER", "hashing")
+	t.Setenv("LOOP_EMBEDDING_WARMUP_ITERATIONS", "3")
+	t.Setenv("LOOP_EMBEDDING_WARMUP_TEXTS", "short prompt| a much longer prompt with tool output ||")
+	t.Setenv("LOOP_EMBEDDING_PROVIDER", "cuda")
+	t.Setenv("LOOP_EMBEDDING_DEVICE_ID", "1")
+	t.Setenv("LOOP_EMBEDDING_INTRA_OP_THREADS", "4")
+	t.Setenv("LOOP_EMBEDDING_INTER_OP_THREADS", "2")
+	t.Setenv("LOOP_EMBEDDING_NORMALIZE", "true")
+	t.Setenv("LOOP_INDEX_DISTANCE_METRIC", "IP")
+	t.Setenv("EMBEDDING_REDIS_POOL_SIZE", "32")
+	t.Setenv("LOOP_CALIBRATION_ENABLED", "true")
+	t.Setenv("LOOP_CALIBRATION_INTERVAL", "60")
+	t.Setenv("LOOP_CALIBRATION_TARGET_FPR", "0.05")
+	t.Setenv("LOOP_CALIBRATION_AUTO_APPLY", "true")
 
 	cfg := Load()
 
@@ -40,7 +74,27 @@ func TestLoadOverrides(t *testing.T) {
 		cfg.EmbeddingVocabPath != "vocab" ||
 		cfg.EmbeddingDim != 123 ||
 		cfg.EmbeddingOutputName != "out" ||
-		cfg.GRPCTimeout != 250*time.Millisecond {
+		cfg.GRPCTimeout != 250*time.Millisecond ||
+		cfg.StoreMode != "sync" ||
+		cfg.StoreWorkers != 2 || cfg.StoreQueueSize != 16 || cfg.StoreQueuePolicy != "drop_newest" ||
+		cfg.StoreFlushTimeout != 750*time.Millisecond ||
+		!cfg.TouchOnHit || cfg.HitTTL != 24*time.Hour ||
+		!cfg.CalibrationEnabled || cfg.CalibrationInterval != time.Minute || cfg.CalibrationTargetRate != 0.05 ||
+		!cfg.CalibrationAutoApply || cfg.CalibrationWindow != 1000 ||
+		cfg.EmbeddingIndexDim != 64 ||
+		cfg.EmbeddingProjection != "pca" ||
+		cfg.EmbeddingPCAPath != "pca.json" ||
+		cfg.ONNXRuntimeLibrary != "/opt/ort/libonnxruntime.so" ||
+		cfg.Embedder != "hashing" ||
+		cfg.WarmupIterations != 3 ||
+		len(cfg.WarmupTexts) != 2 || cfg.WarmupTexts[1] != "a much longer prompt with tool output" ||
+		cfg.EmbeddingProvider != "cuda" ||
+		cfg.EmbeddingDeviceID != 1 ||
+		cfg.IntraOpThreads != 4 ||
+		cfg.InterOpThreads != 2 ||
+		!cfg.EmbeddingNormalize ||
+		cfg.DistanceMetric != "IP" ||
+		cfg.Redis.PoolSize != 32 {
 		t.Fatalf("overrides not applied: %+v", cfg)
 	}
 }
@@ -57,4 +111,8 @@ func TestGetEnvFallback(t *testing.T) {
 	if v := getEnvFloat("FLOAT_KEY", 1.5); v != 1.5 {
 		t.Fatalf("expected float fallback, got %v", v)
 	}
+	t.Setenv("BOOL_KEY", "not-bool")
+	if v := getEnvBool("BOOL_KEY", true); !v {
+		t.Fatalf("expected bool fallback, got %v", v)
+	}
 }
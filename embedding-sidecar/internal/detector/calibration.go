@@ -0,0 +1,293 @@
+package detector
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"embedding-sidecar/internal/telemetry"
+)
+
+// Calibration defaults.
+const (
+	DefaultCalibrationInterval   = 5 * time.Minute
+	DefaultCalibrationTargetRate = 0.01
+	DefaultCalibrationWindow     = 1000
+	DefaultCalibrationMinSamples = 200
+	DefaultCalibrationMin        = 0.85
+	DefaultCalibrationMax        = 0.99
+	DefaultCalibrationMaxStep    = 0.02
+	// maxCalibrationTenants bounds the sampled tenants; samples of the least
+	// recently checked tenant are dropped first.
+	maxCalibrationTenants = 10000
+)
+
+// CalibrationOptions configures a Calibrator. Zero values use the defaults.
+type CalibrationOptions struct {
+	// Interval is how often the job recalibrates.
+	Interval time.Duration
+	// TargetRate is the false-positive rate to hold: the share of a tenant's
+	// checks flagged as loops. Loops are rare next to ordinary traffic, so
+	// nearly every flag of a tenant's sampled checks is counted as a false
+	// positive; the estimate errs on the side of a higher threshold.
+	TargetRate float64
+	// Window is the number of recent checks sampled per tenant, and
+	// MinSamples the fewest a tenant needs to be calibrated.
+	Window     int
+	MinSamples int
+	// Min and Max bound recommended thresholds.
+	Min float64
+	Max float64
+	// AutoApply makes checks use the recommendation instead of only
+	// reporting it, moving a tenant's threshold by at most MaxStep per run.
+	AutoApply bool
+	MaxStep   float64
+}
+
+// TenantCalibration is the result of a tenant's latest calibration run.
+type TenantCalibration struct {
+	TenantID             string
+	Samples              int
+	CurrentThreshold     float64
+	RecommendedThreshold float64
+	CurrentFlagRate      float64
+	RecommendedFlagRate  float64
+	P50, P95, P99        float64
+	Applied              bool
+	CalibratedAt         time.Time
+}
+
+// similaritySamples is a ring of a tenant's most recent max similarities.
+type similaritySamples struct {
+	values []float64
+	next   int
+	seen   time.Time
+}
+
+// Calibrator samples the max similarity of every loop check per tenant and
+// periodically recommends the threshold that flags TargetRate of them, so a
+// tenant whose prompts are naturally repetitive is not flagged constantly and
+// one with varied prompts is not under-protected. With AutoApply, the
+// recommendation becomes the tenant's threshold. Samples and thresholds live
+// in memory, so each replica calibrates on its own traffic. Safe for
+// concurrent use; a nil *Calibrator samples nothing and applies nothing.
+type Calibrator struct {
+	opts     CalibrationOptions
+	fallback float64
+	now      func() time.Time
+
+	mu         sync.Mutex
+	samples    map[string]*similaritySamples
+	thresholds map[string]float64
+	results    map[string]TenantCalibration
+}
+
+// NewCalibrator returns a Calibrator for a detector whose configured
+// threshold is fallback.
+func NewCalibrator(fallback float64, opts CalibrationOptions) *Calibrator {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultCalibrationInterval
+	}
+	if opts.TargetRate <= 0 || opts.TargetRate >= 1 {
+		opts.TargetRate = DefaultCalibrationTargetRate
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultCalibrationWindow
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = DefaultCalibrationMinSamples
+	}
+	opts.MinSamples = min(opts.MinSamples, opts.Window)
+	if opts.Min <= 0 {
+		opts.Min = DefaultCalibrationMin
+	}
+	if opts.Max <= 0 || opts.Max > 1 {
+		opts.Max = DefaultCalibrationMax
+	}
+	if opts.Min > opts.Max {
+		opts.Min = opts.Max
+	}
+	if opts.MaxStep <= 0 {
+		opts.MaxStep = DefaultCalibrationMaxStep
+	}
+	return &Calibrator{
+		opts:       opts,
+		fallback:   fallback,
+		now:        time.Now,
+		samples:    make(map[string]*similaritySamples),
+		thresholds: make(map[string]float64),
+		results:    make(map[string]TenantCalibration),
+	}
+}
+
+// Options returns the options in effect, defaults filled in.
+func (c *Calibrator) Options() CalibrationOptions {
+	return c.opts
+}
+
+// Threshold returns the tenant's calibrated threshold when one was applied,
+// otherwise fallback.
+func (c *Calibrator) Threshold(tenantID string, fallback float64) float64 {
+	if c == nil {
+		return fallback
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.thresholds[tenantID]; ok {
+		return t
+	}
+	return fallback
+}
+
+// Observe samples a check's max similarity.
+func (c *Calibrator) Observe(tenantID string, similarity float64) {
+	if c == nil || tenantID == "" {
+		return
+	}
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.samples[tenantID]
+	if !ok {
+		c.evictLocked()
+		s = &similaritySamples{values: make([]float64, 0, c.opts.Window)}
+		c.samples[tenantID] = s
+	}
+	s.seen = now
+	if len(s.values) < c.opts.Window {
+		s.values = append(s.values, similarity)
+		return
+	}
+	s.values[s.next] = similarity
+	s.next = (s.next + 1) % len(s.values)
+}
+
+// Run recalibrates every Interval until ctx is done.
+func (c *Calibrator) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Calibrate(ctx)
+		}
+	}
+}
+
+// Calibrate recommends a threshold for every tenant with enough samples and,
+// with AutoApply, moves the tenant's threshold toward it.
+func (c *Calibrator) Calibrate(ctx context.Context) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tenantID, s := range c.samples {
+		if len(s.values) < c.opts.MinSamples {
+			continue
+		}
+		sorted := slices.Clone(s.values)
+		sort.Float64s(sorted)
+
+		current, applied := c.thresholds[tenantID]
+		if !applied {
+			current = c.fallback
+		}
+		recommended := c.recommend(sorted)
+		result := TenantCalibration{
+			TenantID:             tenantID,
+			Samples:              len(sorted),
+			CurrentThreshold:     current,
+			RecommendedThreshold: recommended,
+			CurrentFlagRate:      flagRate(sorted, current),
+			RecommendedFlagRate:  flagRate(sorted, recommended),
+			P50:                  percentile(sorted, 0.50),
+			P95:                  percentile(sorted, 0.95),
+			P99:                  percentile(sorted, 0.99),
+			Applied:              applied && current == recommended,
+			CalibratedAt:         now,
+		}
+		if c.opts.AutoApply && current != recommended {
+			next := recommended
+			if math.Abs(recommended-current) > c.opts.MaxStep {
+				next = current + math.Copysign(c.opts.MaxStep, recommended-current)
+			}
+			c.thresholds[tenantID] = next
+			direction := "raise"
+			if next < current {
+				direction = "lower"
+			}
+			telemetry.RecordThresholdAdjustment(ctx, tenantID, direction)
+			slog.InfoContext(ctx, "calibrated loop threshold applied", "tenant_id", tenantID,
+				"from", current, "to", next, "recommended", recommended, "samples", len(sorted))
+			result.CurrentThreshold = next
+			result.CurrentFlagRate = flagRate(sorted, next)
+			result.Applied = next == recommended
+		}
+		telemetry.RecordCalibration(ctx, tenantID, result.CurrentThreshold, recommended, result.CurrentFlagRate, result.RecommendedFlagRate)
+		c.results[tenantID] = result
+	}
+}
+
+// Results returns the latest calibration of tenantID, or of every calibrated
+// tenant when it is empty, ordered by tenant.
+func (c *Calibrator) Results(tenantID string) []TenantCalibration {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []TenantCalibration
+	for id, result := range c.results {
+		if tenantID == "" || id == tenantID {
+			out = append(out, result)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TenantID < out[j].TenantID })
+	return out
+}
+
+// recommend returns the lowest threshold that flags (is exceeded by) at most
+// TargetRate of the sorted samples, within [Min, Max].
+func (c *Calibrator) recommend(sorted []float64) float64 {
+	allowed := int(math.Floor(float64(len(sorted)) * c.opts.TargetRate))
+	t := sorted[len(sorted)-1-min(allowed, len(sorted)-1)]
+	// Round up to three decimals so it reads like a configured threshold.
+	t = math.Ceil(t*1000-1e-9) / 1000
+	return max(c.opts.Min, min(c.opts.Max, t))
+}
+
+// evictLocked drops the least recently checked tenant, which falls back to
+// the configured threshold, when the sample map is full. c.mu must be held.
+func (c *Calibrator) evictLocked() {
+	if len(c.samples) < maxCalibrationTenants {
+		return
+	}
+	var oldest string
+	var oldestSeen time.Time
+	for id, s := range c.samples {
+		if oldest == "" || s.seen.Before(oldestSeen) {
+			oldest, oldestSeen = id, s.seen
+		}
+	}
+	delete(c.samples, oldest)
+	delete(c.results, oldest)
+	delete(c.thresholds, oldest)
+}
+
+// flagRate is the share of sorted samples above threshold, as a loop check
+// compares them.
+func flagRate(sorted []float64, threshold float64) float64 {
+	above := len(sorted) - sort.Search(len(sorted), func(i int) bool { return sorted[i] > threshold })
+	return float64(above) / float64(len(sorted))
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted samples.
+func percentile(sorted []float64, p float64) float64 {
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, min(idx, len(sorted)-1))]
+}
@@ -0,0 +1,89 @@
+package detector
+
+import (
+	"context"
+	"testing"
+
+	"embedding-sidecar/internal/store"
+)
+
+// observeSpread samples n similarities spread evenly over [0.5, 1).
+func observeSpread(c *Calibrator, tenantID string, n int) {
+	for i := range n {
+		c.Observe(tenantID, 0.5+0.5*float64(i)/float64(n))
+	}
+}
+
+func TestCalibratorRecommendsTargetRate(t *testing.T) {
+	c := NewCalibrator(0.95, CalibrationOptions{TargetRate: 0.02, Window: 100, MinSamples: 50, Min: 0.5, Max: 0.999})
+	observeSpread(c, "repetitive", 100)
+	c.Observe("quiet", 0.3) // too few samples to calibrate
+
+	c.Calibrate(context.Background())
+	results := c.Results("")
+	if len(results) != 1 || results[0].TenantID != "repetitive" {
+		t.Fatalf("expected only the sampled tenant calibrated, got %+v", results)
+	}
+	r := results[0]
+	// 0.95 flags the top 9 of 100 samples; 0.985 flags the top 2.
+	if r.CurrentFlagRate != 0.09 || r.RecommendedThreshold != 0.985 || r.RecommendedFlagRate != 0.02 {
+		t.Fatalf("unexpected calibration %+v", r)
+	}
+	if r.Applied || c.Threshold("repetitive", 0.95) != 0.95 {
+		t.Fatalf("a recommendation must not apply without AutoApply: %+v", r)
+	}
+	if r.P50 != 0.745 || r.Samples != 100 {
+		t.Fatalf("unexpected distribution %+v", r)
+	}
+}
+
+func TestCalibratorAutoApplyStepsTowardRecommendation(t *testing.T) {
+	c := NewCalibrator(0.95, CalibrationOptions{TargetRate: 0.02, Window: 100, MinSamples: 50, Min: 0.5, Max: 0.999, AutoApply: true, MaxStep: 0.03})
+	observeSpread(c, "t", 100)
+
+	c.Calibrate(context.Background())
+	if got := c.Threshold("t", 0.95); got < 0.979 || got > 0.981 {
+		t.Fatalf("expected the threshold raised by one step to 0.98, got %v", got)
+	}
+	c.Calibrate(context.Background())
+	r := c.Results("t")[0]
+	if got := c.Threshold("t", 0.95); got != r.RecommendedThreshold || !r.Applied {
+		t.Fatalf("expected the recommendation applied on the second run, got %v (%+v)", got, r)
+	}
+}
+
+func TestCalibratorWindowKeepsRecentSamples(t *testing.T) {
+	c := NewCalibrator(0.95, CalibrationOptions{Window: 10, MinSamples: 10, Min: 0.1})
+	for range 10 {
+		c.Observe("t", 0.99)
+	}
+	for range 10 {
+		c.Observe("t", 0.2)
+	}
+	c.Calibrate(context.Background())
+	if r := c.Results("t")[0]; r.P99 != 0.2 || r.RecommendedThreshold != 0.2 {
+		t.Fatalf("expected only the latest samples to count, got %+v", r)
+	}
+}
+
+func TestDetectorUsesCalibratedThreshold(t *testing.T) {
+	st := &fakeStore{records: []store.EmbeddingRecord{{Similarity: 0.96, Prompt: "prev"}}}
+	d := NewDetector(st, fakeEmbedder{vec: []float32{0.1}}, 0.95, 5)
+	c := NewCalibrator(0.95, CalibrationOptions{Window: 10, MinSamples: 10, AutoApply: true, MaxStep: 0.5, TargetRate: 0.1})
+	d.SetCalibrator(c)
+
+	for range 10 {
+		if _, err := d.CheckLoop(context.Background(), "t", "prompt"); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	c.Calibrate(context.Background())
+	res, err := d.CheckLoop(context.Background(), "t", "prompt")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if res.LoopDetected {
+		t.Fatalf("expected the calibrated threshold (%v) to clear a similarity every check has", c.Threshold("t", 0.95))
+	}
+	waitForStore(t, st)
+}
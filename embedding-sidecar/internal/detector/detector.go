@@ -3,6 +3,7 @@ package detector
 import (
 	"context"
 	"log/slog"
+	"sync"
 
 	"embedding-sidecar/internal/embedder"
 	"embedding-sidecar/internal/store"
@@ -17,11 +18,28 @@ type Store interface {
 	StoreEmbedding(ctx context.Context, tenantID, prompt string, embedding []float32) error
 }
 
+// Store modes control when a checked prompt's embedding is written.
+const (
+	// StoreAsync stores in the background after responding (lowest latency).
+	// A prompt sent right after another may be checked before the first is
+	// stored and miss it.
+	StoreAsync = "async"
+	// StoreSync stores before responding, so the next check of the tenant is
+	// guaranteed to see this prompt, at the cost of one Redis write per check.
+	StoreSync = "sync"
+)
+
 type Detector struct {
 	store               Store
 	embedder            embedder.Embedding
 	similarityThreshold float64
 	limit               int
+	storeMode           string
+	touchOnHit          bool
+	calibrator          *Calibrator
+
+	writerOnce sync.Once
+	writer     *Writer
 }
 
 type LoopResult struct {
@@ -36,12 +54,62 @@ func NewDetector(store Store, embedder embedder.Embedding, similarityThreshold f
 		embedder:            embedder,
 		similarityThreshold: similarityThreshold,
 		limit:               limit,
+		storeMode:           StoreAsync,
+	}
+}
+
+// SetStoreMode selects StoreAsync or StoreSync; unknown modes fall back to
+// StoreAsync.
+func (d *Detector) SetStoreMode(mode string) {
+	if mode != StoreSync {
+		mode = StoreAsync
+	}
+	d.storeMode = mode
+}
+
+// SetTouchOnHit makes a loop check refresh the TTL of the stored embeddings
+// it matched above the threshold, when the store supports it (Toucher). The
+// refresh runs in the background on the Writer.
+func (d *Detector) SetTouchOnHit(touch bool) {
+	d.touchOnHit = touch
+}
+
+// SetCalibrator samples every check's max similarity in c and uses the
+// thresholds it applies per tenant.
+func (d *Detector) SetCalibrator(c *Calibrator) {
+	d.calibrator = c
+}
+
+// Calibrator returns the Calibrator set with SetCalibrator, or nil.
+func (d *Detector) Calibrator() *Calibrator {
+	return d.calibrator
+}
+
+// SetWriter sets the Writer that stores embeddings in StoreAsync mode and
+// runs TTL refreshes. Without one, the first background write starts a
+// Writer with the default options.
+func (d *Detector) SetWriter(w *Writer) {
+	d.writer = w
+}
+
+// Close flushes queued async stores, waiting until ctx expires, and returns
+// how many were lost.
+func (d *Detector) Close(ctx context.Context) int {
+	if d.writer == nil {
+		return 0
 	}
+	return d.writer.Close(ctx)
+}
+
+// StoreMode returns the store mode in effect.
+func (d *Detector) StoreMode() string {
+	return d.storeMode
 }
 
 func (d *Detector) CheckLoop(ctx context.Context, tenantID, prompt string) (LoopResult, error) {
 	ctx, span := telemetry.StartSpan(ctx, "detector.check_loop",
 		attribute.String("tenant.id", tenantID),
+		attribute.String("loop.store_mode", d.storeMode),
 	)
 	defer span.End()
 	resultMetric := "unknown"
@@ -49,7 +117,7 @@ func (d *Detector) CheckLoop(ctx context.Context, tenantID, prompt string) (Loop
 		telemetry.RecordLoopCheck(ctx, resultMetric, tenantID)
 	}()
 
-	embedding, err := d.embedder.Compute(prompt)
+	embedding, err := d.embedder.Compute(ctx, prompt)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -77,15 +145,35 @@ func (d *Detector) CheckLoop(ctx context.Context, tenantID, prompt string) (Loop
 		}
 	}
 
-	// Store the new embedding asynchronously to keep latency low.
-	go func() {
-		if err := d.store.StoreEmbedding(context.Background(), tenantID, prompt, embedding); err != nil {
-			slog.Warn("failed to store embedding", "error", err)
+	// Store after searching so a prompt is never matched against itself. In
+	// sync mode a failed store is logged but still returns the check result;
+	// the write outlives a cancelled caller either way.
+	if d.storeMode == StoreSync {
+		if err := d.store.StoreEmbedding(context.WithoutCancel(ctx), tenantID, prompt, embedding); err != nil {
+			span.RecordError(err)
+			slog.WarnContext(ctx, "failed to store embedding", "error", err)
 		}
-	}()
+	} else {
+		d.backgroundWriter().Enqueue(backgroundCtx(ctx), tenantID, prompt, embedding)
+	}
+
+	threshold := d.calibrator.Threshold(tenantID, d.similarityThreshold)
+	d.calibrator.Observe(tenantID, maxSim)
+
+	if d.touchOnHit {
+		var hits []string
+		for _, rec := range records {
+			if rec.Similarity > threshold && rec.Key != "" {
+				hits = append(hits, rec.Key)
+			}
+		}
+		if len(hits) > 0 {
+			d.backgroundWriter().EnqueueTouch(backgroundCtx(ctx), tenantID, hits)
+		}
+	}
 
 	result := LoopResult{
-		LoopDetected:  maxSim > d.similarityThreshold,
+		LoopDetected:  maxSim > threshold,
 		MaxSimilarity: maxSim,
 		SimilarPrompt: similarPrompt,
 	}
@@ -97,6 +185,22 @@ func (d *Detector) CheckLoop(ctx context.Context, tenantID, prompt string) (Loop
 	span.SetAttributes(
 		attribute.Bool("loop.detected", result.LoopDetected),
 		attribute.Float64("loop.max_similarity", result.MaxSimilarity),
+		attribute.Float64("loop.threshold", threshold),
 	)
 	return result, nil
 }
+
+// backgroundWriter returns the Writer, starting a default one on first use.
+func (d *Detector) backgroundWriter() *Writer {
+	d.writerOnce.Do(func() {
+		if d.writer == nil {
+			d.writer = NewWriter(d.store, WriterOptions{})
+		}
+	})
+	return d.writer
+}
+
+// backgroundCtx keeps the request ID of ctx, not the caller's cancellation.
+func backgroundCtx(ctx context.Context) context.Context {
+	return telemetry.WithRequestID(context.Background(), telemetry.RequestID(ctx))
+}
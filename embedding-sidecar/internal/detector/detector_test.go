@@ -15,7 +15,7 @@ type fakeEmbedder struct {
 	err error
 }
 
-func (f fakeEmbedder) Compute(string) ([]float32, error) {
+func (f fakeEmbedder) Compute(context.Context, string) ([]float32, error) {
 	return f.vec, f.err
 }
 
@@ -88,6 +88,75 @@ func TestDetectorPropagatesErrors(t *testing.T) {
 	}
 }
 
+// recordingStore is an in-memory store whose searches see every stored prompt,
+// with a fixed similarity, like back-to-back identical prompts.
+type recordingStore struct {
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (r *recordingStore) SearchSimilarEmbeddings(ctx context.Context, tenantID string, queryEmbedding []float32, limit int) ([]store.EmbeddingRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []store.EmbeddingRecord
+	for _, p := range r.prompts {
+		records = append(records, store.EmbeddingRecord{Prompt: p, Similarity: 0.99})
+	}
+	return records, nil
+}
+
+func (r *recordingStore) StoreEmbedding(ctx context.Context, tenantID, prompt string, embedding []float32) error {
+	time.Sleep(5 * time.Millisecond) // a Redis round trip
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts = append(r.prompts, prompt)
+	return nil
+}
+
+func TestDetectorSyncStoreDetectsBackToBackPrompts(t *testing.T) {
+	rs := &recordingStore{}
+	d := NewDetector(rs, fakeEmbedder{vec: []float32{0.1}}, 0.95, 5)
+	d.SetStoreMode(StoreSync)
+
+	first, err := d.CheckLoop(context.Background(), "tenant", "retry the build")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if first.LoopDetected {
+		t.Fatalf("first prompt must not match itself: %+v", first)
+	}
+	second, err := d.CheckLoop(context.Background(), "tenant", "retry the build")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !second.LoopDetected || second.SimilarPrompt != "retry the build" {
+		t.Fatalf("expected immediate repeat to be detected, got %+v", second)
+	}
+}
+
+func TestDetectorSyncStoreIgnoresCancellationAndErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := &fakeStore{storeErr: errors.New("store fail")}
+	d := NewDetector(fs, fakeEmbedder{vec: []float32{0.1}}, 0.95, 5)
+	d.SetStoreMode(StoreSync)
+	cancel()
+
+	if _, err := d.CheckLoop(ctx, "tenant", "prompt"); err != nil {
+		t.Fatalf("store failure should not fail the check: %v", err)
+	}
+	if fs.storeCalls != 1 {
+		t.Fatalf("expected store before returning, got %d calls", fs.storeCalls)
+	}
+}
+
+func TestSetStoreModeFallsBackToAsync(t *testing.T) {
+	d := NewDetector(&fakeStore{}, fakeEmbedder{}, 0.95, 5)
+	d.SetStoreMode("bogus")
+	if d.storeMode != StoreAsync {
+		t.Fatalf("expected async fallback, got %q", d.storeMode)
+	}
+}
+
 func waitForStore(t *testing.T, fs *fakeStore) {
 	t.Helper()
 	deadline := time.Now().Add(200 * time.Millisecond)
@@ -0,0 +1,164 @@
+package detector
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"embedding-sidecar/inflight"
+	"embedding-sidecar/internal/telemetry"
+)
+
+// Queue policies decide what a full store queue gives up.
+const (
+	// DropOldest discards the longest-waiting write to make room. Recent
+	// prompts are the ones the next checks compare against, so this keeps
+	// detection closest to current traffic.
+	DropOldest = "drop_oldest"
+	// DropNewest discards the write being queued.
+	DropNewest = "drop_newest"
+)
+
+// Writer defaults.
+const (
+	DefaultStoreWorkers   = 8
+	DefaultStoreQueueSize = 1024
+)
+
+// WriterOptions configures a Writer. Zero values use the defaults.
+type WriterOptions struct {
+	Workers   int
+	QueueSize int
+	// Policy is DropOldest or DropNewest; unknown policies use DropOldest.
+	Policy string
+	// Group tracks every write from Enqueue until it is stored or dropped, so
+	// one Wait covers these and the sidecar's other Redis writes. Nil gives
+	// the Writer a group of its own.
+	Group *inflight.Group
+}
+
+// Toucher is implemented by stores that can refresh the TTL of matched
+// embeddings (see Detector.SetTouchOnHit).
+type Toucher interface {
+	TouchEmbeddings(ctx context.Context, tenantID string, keys []string) error
+}
+
+type storeJob struct {
+	ctx       context.Context
+	tenantID  string
+	prompt    string
+	embedding []float32
+	// touch, when set, makes this a TTL refresh of these keys instead.
+	touch []string
+	done  func()
+}
+
+// Writer stores embeddings in the background on a fixed set of workers fed by
+// a bounded queue, so a burst of loop checks cannot pile up goroutines and
+// Redis connections. When the queue is full, a write is dropped per the
+// policy and counted in sidecar.store_queue.dropped.
+type Writer struct {
+	store  Store
+	policy string
+	queue  chan storeJob
+	group  *inflight.Group
+
+	mu     sync.Mutex // serializes enqueues with Close
+	closed bool
+}
+
+// NewWriter starts a Writer's workers.
+func NewWriter(store Store, opts WriterOptions) *Writer {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultStoreWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultStoreQueueSize
+	}
+	if opts.Policy != DropNewest {
+		opts.Policy = DropOldest
+	}
+	if opts.Group == nil {
+		opts.Group = inflight.NewGroup(0)
+	}
+	w := &Writer{store: store, policy: opts.Policy, queue: make(chan storeJob, opts.QueueSize), group: opts.Group}
+	for range opts.Workers {
+		go w.work()
+	}
+	return w
+}
+
+func (w *Writer) work() {
+	for job := range w.queue {
+		if job.touch != nil {
+			if toucher, ok := w.store.(Toucher); ok {
+				if err := toucher.TouchEmbeddings(job.ctx, job.tenantID, job.touch); err != nil {
+					slog.WarnContext(job.ctx, "failed to refresh embedding ttl", "error", err)
+				}
+			}
+		} else if err := w.store.StoreEmbedding(job.ctx, job.tenantID, job.prompt, job.embedding); err != nil {
+			slog.WarnContext(job.ctx, "failed to store embedding", "error", err)
+		}
+		job.done()
+	}
+}
+
+// Enqueue queues a write and reports whether it was accepted. A full queue
+// drops per the policy; a closed Writer drops the write.
+func (w *Writer) Enqueue(ctx context.Context, tenantID, prompt string, embedding []float32) bool {
+	return w.enqueue(storeJob{ctx: ctx, tenantID: tenantID, prompt: prompt, embedding: embedding})
+}
+
+// EnqueueTouch queues a TTL refresh of matched embedding keys, under the same
+// bound and policy as stores. Stores that are not a Toucher ignore it.
+func (w *Writer) EnqueueTouch(ctx context.Context, tenantID string, keys []string) bool {
+	return w.enqueue(storeJob{ctx: ctx, tenantID: tenantID, touch: keys})
+}
+
+func (w *Writer) enqueue(job storeJob) bool {
+	ctx, tenantID := job.ctx, job.tenantID
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		telemetry.RecordStoreDropped(ctx, "closed", tenantID)
+		return false
+	}
+	job.done = w.group.Begin()
+	select {
+	case w.queue <- job:
+		return true
+	default:
+	}
+	if w.policy == DropNewest {
+		job.done()
+		telemetry.RecordStoreDropped(ctx, DropNewest, tenantID)
+		return false
+	}
+	// Workers may empty a slot meanwhile; either way there is room after this.
+	select {
+	case oldest := <-w.queue:
+		oldest.done()
+		telemetry.RecordStoreDropped(oldest.ctx, DropOldest, oldest.tenantID)
+	default:
+	}
+	w.queue <- job
+	return true
+}
+
+// Depth returns the writes waiting for a worker.
+func (w *Writer) Depth() int64 {
+	return int64(len(w.queue))
+}
+
+// Close stops accepting writes and waits for the tracked ones (queued,
+// being stored, and anything else sharing the group) to finish, or for ctx
+// to expire. It returns how many were still pending then, which are lost.
+func (w *Writer) Close(ctx context.Context) int {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	return w.group.Wait(ctx)
+}
@@ -0,0 +1,157 @@
+package detector
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"embedding-sidecar/internal/store"
+)
+
+// blockingStore holds every write until release is closed and records the
+// prompts stored.
+type blockingStore struct {
+	release chan struct{}
+	mu      sync.Mutex
+	stored  []string
+}
+
+func (b *blockingStore) SearchSimilarEmbeddings(context.Context, string, []float32, int) ([]store.EmbeddingRecord, error) {
+	return nil, nil
+}
+
+func (b *blockingStore) StoreEmbedding(_ context.Context, _, prompt string, _ []float32) error {
+	<-b.release
+	b.mu.Lock()
+	b.stored = append(b.stored, prompt)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *blockingStore) prompts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Sorted(slices.Values(b.stored))
+}
+
+// fillWriter queues "busy" (taken by the one worker, which blocks) and then
+// the given prompts.
+func fillWriter(t *testing.T, w *Writer, prompts ...string) {
+	t.Helper()
+	w.Enqueue(context.Background(), "t1", "busy", nil)
+	deadline := time.Now().Add(time.Second)
+	for w.Depth() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker did not pick up the first write")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, p := range prompts {
+		w.Enqueue(context.Background(), "t1", p, nil)
+	}
+}
+
+func TestWriterDropPolicies(t *testing.T) {
+	cases := []struct {
+		policy string
+		want   []string
+	}{
+		{policy: DropOldest, want: []string{"busy", "c", "d"}},
+		{policy: DropNewest, want: []string{"a", "b", "busy"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			bs := &blockingStore{release: make(chan struct{})}
+			w := NewWriter(bs, WriterOptions{Workers: 1, QueueSize: 2, Policy: tc.policy})
+			fillWriter(t, w, "a", "b", "c", "d")
+			if depth := w.Depth(); depth != 2 {
+				t.Fatalf("expected a full queue of 2, got %d", depth)
+			}
+			close(bs.release)
+			if lost := w.Close(context.Background()); lost != 0 {
+				t.Fatalf("expected a full flush, lost %d", lost)
+			}
+			if got := bs.prompts(); !slices.Equal(got, tc.want) {
+				t.Fatalf("stored %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriterCloseCountsLostWrites(t *testing.T) {
+	bs := &blockingStore{release: make(chan struct{})}
+	w := NewWriter(bs, WriterOptions{Workers: 1, QueueSize: 4})
+	fillWriter(t, w, "a", "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// "busy" is still being stored, "a" and "b" still queued.
+	if lost := w.Close(ctx); lost != 3 {
+		t.Fatalf("expected 3 pending writes lost, got %d", lost)
+	}
+	if w.Enqueue(context.Background(), "t1", "late", nil) {
+		t.Fatalf("expected a closed writer to refuse writes")
+	}
+	close(bs.release)
+}
+
+func TestDetectorAsyncUsesWriter(t *testing.T) {
+	fs := &fakeStore{}
+	d := NewDetector(fs, fakeEmbedder{vec: []float32{0.1}}, 0.95, 5)
+	d.SetWriter(NewWriter(fs, WriterOptions{Workers: 1}))
+	for range 3 {
+		if _, err := d.CheckLoop(context.Background(), "tenant", "prompt"); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if lost := d.Close(context.Background()); lost != 0 {
+		t.Fatalf("expected a full flush, lost %d", lost)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.storeCalls != 3 {
+		t.Fatalf("expected 3 stores after flush, got %d", fs.storeCalls)
+	}
+}
+
+// touchStore records TTL refreshes.
+type touchStore struct {
+	fakeStore
+	touched chan []string
+}
+
+func (s *touchStore) TouchEmbeddings(_ context.Context, _ string, keys []string) error {
+	s.touched <- keys
+	return nil
+}
+
+func TestDetectorTouchOnHit(t *testing.T) {
+	ts := &touchStore{
+		fakeStore: fakeStore{records: []store.EmbeddingRecord{
+			{Similarity: 0.99, Prompt: "same", Key: "loop:t:1"},
+			{Similarity: 0.97, Prompt: "close", Key: "loop:t:2"},
+			{Similarity: 0.5, Prompt: "other", Key: "loop:t:3"},
+		}},
+		touched: make(chan []string, 4),
+	}
+	d := NewDetector(ts, fakeEmbedder{vec: []float32{0.1}}, 0.95, 5)
+	d.SetWriter(NewWriter(ts, WriterOptions{Workers: 1}))
+
+	if _, err := d.CheckLoop(context.Background(), "t", "prompt"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	d.SetTouchOnHit(true)
+	if _, err := d.CheckLoop(context.Background(), "t", "prompt"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	d.Close(context.Background())
+
+	if len(ts.touched) != 1 {
+		t.Fatalf("expected one refresh, only with touch on hit enabled, got %d", len(ts.touched))
+	}
+	if keys := <-ts.touched; !slices.Equal(keys, []string{"loop:t:1", "loop:t:2"}) {
+		t.Fatalf("expected only keys above the threshold refreshed, got %v", keys)
+	}
+}
@@ -4,145 +4,65 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
 	"time"
 
 	"embedding-sidecar/internal/telemetry"
-
-	"github.com/yalue/onnxruntime_go"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
 )
 
 type Embedding interface {
-	Compute(text string) ([]float32, error)
+	Compute(ctx context.Context, text string) ([]float32, error)
 }
 
 var errWarmupFail = errors.New("warmup failed")
 
-type onnxEmbedder struct {
-	modelPath  string
-	tokenizer  *wordpieceTokenizer
-	outputName string
-	dim        int
-}
-
 const DefaultEmbeddingDim = 384
 
-var runtimeInitOnce sync.Once
-var runtimeInitErr error
+// Warmup computes one embedding and returns its dimension, which is what the
+// index must be created with (before any projection).
+func Warmup(embedder Embedding) (int, error) {
+	return WarmupOptions{}.Run(context.Background(), embedder)
+}
 
-func NewONNXEmbedder(modelPath string, vocabPath string, outputName string, dim int) (Embedding, error) {
-	if modelPath == "" {
-		return nil, errors.New("model path not provided")
-	}
-	if vocabPath == "" {
-		return nil, errors.New("vocab path not provided")
-	}
-	if outputName == "" {
-		outputName = "sentence_embedding"
-	}
-	if dim <= 0 {
-		dim = DefaultEmbeddingDim
-	}
-	tokenizer, err := loadWordpieceTokenizer(vocabPath, 256)
-	if err != nil {
-		return nil, fmt.Errorf("load tokenizer: %w", err)
-	}
-	onnxruntime_go.SetSharedLibraryPath("/usr/local/lib/libonnxruntime.so")
-	runtimeInitOnce.Do(func() {
-		runtimeInitErr = onnxruntime_go.InitializeEnvironment()
-	})
-	if runtimeInitErr != nil {
-		return nil, fmt.Errorf("init onnx runtime: %w", runtimeInitErr)
-	}
-	return &onnxEmbedder{
-		modelPath:  modelPath,
-		tokenizer:  tokenizer,
-		outputName: outputName,
-		dim:        dim,
-	}, nil
+// WarmupOptions configures the embeddings computed before a model serves.
+// ONNX Runtime allocates and tunes its buffers on the first runs, so warming
+// up with a few prompts of typical length keeps that cost off the first real
+// loop checks.
+type WarmupOptions struct {
+	// Iterations is how many times each text is embedded; 0 or less means 1.
+	Iterations int
+	// Texts are the sample prompts; empty uses "warmup".
+	Texts []string
 }
 
-// Compute runs inference and returns the embedding vector.
-func (e *onnxEmbedder) Compute(text string) ([]float32, error) {
-	if text == "" {
-		return nil, errors.New("empty text")
-	}
-	ctx := context.Background()
-	ctx, span := telemetry.StartSpan(ctx, "embedder.compute",
-		attribute.Int("embedder.dim", e.dim),
-		attribute.String("embedder.output_name", e.outputName),
-	)
-	defer span.End()
+// Run embeds every text Iterations times and returns the embedding dimension.
+// Every embedding must have the same dimension. The total duration is
+// recorded as sidecar.embedder.warmup_ms.
+func (o WarmupOptions) Run(ctx context.Context, embedder Embedding) (dim int, err error) {
 	start := time.Now()
-	result := "ok"
 	defer func() {
-		telemetry.ObserveEmbedderLatency(ctx, e.dim, e.outputName, result, time.Since(start))
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		telemetry.ObserveWarmupLatency(ctx, result, time.Since(start))
 	}()
-	inputIDs, attentionMask := e.tokenizer.Encode(text)
-
-	inputTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(inputIDs))}, inputIDs)
-	if err != nil {
-		result = "error"
-		return nil, fmt.Errorf("create input_ids tensor: %w", err)
-	}
-	tokenTypeIDs := make([]int64, len(inputIDs)) // all zeros
-	typeTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(tokenTypeIDs))}, tokenTypeIDs)
-	if err != nil {
-		result = "error"
-		return nil, fmt.Errorf("create token_type_ids tensor: %w", err)
-	}
-	maskTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(attentionMask))}, attentionMask)
-	if err != nil {
-		result = "error"
-		return nil, fmt.Errorf("create attention_mask tensor: %w", err)
-	}
-
-	inputNames := []string{"input_ids", "token_type_ids", "attention_mask"}
-	outputNames := []string{e.outputName}
-	seqLen := int64(len(attentionMask))
-	outputBuffer := make([]float32, int(seqLen)*e.dim)
-	outputTensor, err := onnxruntime_go.NewTensor[float32](onnxruntime_go.Shape{1, seqLen, int64(e.dim)}, outputBuffer)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		result = "error"
-		return nil, fmt.Errorf("create output tensor: %w", err)
-	}
-
-	inputVals := []onnxruntime_go.Value{inputTensor, typeTensor, maskTensor}
-	outputVals := []onnxruntime_go.Value{outputTensor}
-	session, err := onnxruntime_go.NewAdvancedSession(e.modelPath, inputNames, outputNames, inputVals, outputVals, nil)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		result = "error"
-		return nil, fmt.Errorf("create onnx session: %w", err)
-	}
-	defer session.Destroy()
-
-	if err := session.Run(); err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		result = "error"
-		return nil, fmt.Errorf("onnx run: %w", err)
-	}
-
-	data := outputTensor.GetData()
-	pooled, err := meanPool(data, attentionMask, e.dim)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		result = "error"
-		return nil, err
+	texts := o.Texts
+	if len(texts) == 0 {
+		texts = []string{"warmup"}
+	}
+	for i := 0; i < max(o.Iterations, 1); i++ {
+		for _, text := range texts {
+			vec, err := embedder.Compute(ctx, text)
+			if err != nil {
+				return 0, err
+			}
+			if dim > 0 && len(vec) != dim {
+				return 0, fmt.Errorf("warmup embeddings have %d and %d dimensions", dim, len(vec))
+			}
+			dim = len(vec)
+		}
 	}
-	return pooled, nil
-}
-
-func Warmup(embedder Embedding) error {
-	_, err := embedder.Compute("warmup")
-	return err
+	return dim, nil
 }
 
 // meanPool averages token embeddings (data laid out [seqLen * dim]) over tokens with attention mask == 1.
@@ -1,8 +1,6 @@
 package embedder
 
-import (
-	"testing"
-)
+import "testing"
 
 func TestMeanPoolHappyPath(t *testing.T) {
 	dim := 3
@@ -0,0 +1,67 @@
+package embedder
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"unicode"
+)
+
+// Embedding backends, selected with LOOP_EMBEDDER.
+const (
+	BackendONNX    = "onnx"
+	BackendHashing = "hashing"
+)
+
+type hashingEmbedder struct {
+	dim int
+}
+
+// NewHashingEmbedder returns a pure-Go embedder for hosts without ONNX Runtime
+// (CGO_ENABLED=0 builds, alpine or other musl images, unsupported
+// architectures). It uses the hashing trick: words and adjacent word pairs
+// are hashed into dim signed buckets, and the counts are scaled to unit
+// length. That captures lexical overlap only, not meaning: a reworded prompt
+// scores lower than with a sentence-transformer model, so
+// LOOP_SIMILARITY_THRESHOLD usually needs lowering. A dim of 0 or less uses
+// DefaultEmbeddingDim.
+func NewHashingEmbedder(dim int) Embedding {
+	if dim <= 0 {
+		dim = DefaultEmbeddingDim
+	}
+	return &hashingEmbedder{dim: dim}
+}
+
+func (e *hashingEmbedder) Compute(_ context.Context, text string) ([]float32, error) {
+	var words []string
+	for _, token := range basicTokenize(strings.ToLower(text)) {
+		if strings.IndexFunc(token, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			words = append(words, token)
+		}
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty text")
+	}
+	vec := make([]float32, e.dim)
+	for i, word := range words {
+		e.add(vec, "w:"+word)
+		if i > 0 {
+			e.add(vec, "b:"+words[i-1]+" "+word)
+		}
+	}
+	return normalize(vec), nil
+}
+
+// add hashes feature into vec. The sign comes from a separate bit of the hash
+// so colliding features tend to cancel out instead of adding up.
+func (e *hashingEmbedder) add(vec []float32, feature string) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		vec[sum%uint64(e.dim)]--
+	} else {
+		vec[sum%uint64(e.dim)]++
+	}
+}
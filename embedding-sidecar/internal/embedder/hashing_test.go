@@ -0,0 +1,52 @@
+package embedder
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestHashingEmbedder(t *testing.T) {
+	emb := NewHashingEmbedder(0)
+	ctx := context.Background()
+
+	vec, err := emb.Compute(ctx, "Retry the failing test, then run the build.")
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if len(vec) != DefaultEmbeddingDim {
+		t.Fatalf("expected default dim %d, got %d", DefaultEmbeddingDim, len(vec))
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if math.Abs(norm-1) > 1e-5 {
+		t.Fatalf("expected unit vector, got squared norm %f", norm)
+	}
+
+	same, _ := emb.Compute(ctx, "retry the failing test then run the build")
+	if got := cosine(vec, same); got < 0.999 {
+		t.Fatalf("case and punctuation changes should not matter, got similarity %f", got)
+	}
+	near, _ := emb.Compute(ctx, "Retry the failing test, then run the whole build.")
+	other, _ := emb.Compute(ctx, "Summarize this quarterly revenue report for the board.")
+	if cosine(vec, near) <= cosine(vec, other) {
+		t.Fatalf("expected near-duplicate to score above unrelated text: %f vs %f", cosine(vec, near), cosine(vec, other))
+	}
+
+	if _, err := emb.Compute(ctx, " ... "); err == nil {
+		t.Fatalf("expected error for text without words")
+	}
+	if vec, _ := NewHashingEmbedder(64).Compute(ctx, "hello"); len(vec) != 64 {
+		t.Fatalf("expected dim 64, got %d", len(vec))
+	}
+}
@@ -0,0 +1,42 @@
+package embedder
+
+import (
+	"context"
+	"math"
+)
+
+type normalizedEmbedder struct {
+	Embedding
+}
+
+// Normalized returns emb with every embedding scaled to unit length. Many
+// sentence-transformer exports skip the final normalization layer; unit
+// vectors are required for an inner-product index to rank like cosine.
+func Normalized(emb Embedding) Embedding {
+	return &normalizedEmbedder{Embedding: emb}
+}
+
+func (e *normalizedEmbedder) Compute(ctx context.Context, text string) ([]float32, error) {
+	vec, err := e.Embedding.Compute(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(vec), nil
+}
+
+// normalize scales vec to unit L2 norm in place; a zero vector is returned
+// unchanged.
+func normalize(vec []float32) []float32 {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return vec
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec
+}
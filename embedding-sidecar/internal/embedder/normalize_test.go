@@ -0,0 +1,20 @@
+package embedder
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestNormalizedEmbedder(t *testing.T) {
+	vec, err := Normalized(fixedEmbedder{vec: []float32{3, 4}}).Compute(context.Background(), "x")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if math.Abs(float64(vec[0])-0.6) > 1e-6 || math.Abs(float64(vec[1])-0.8) > 1e-6 {
+		t.Fatalf("expected unit vector, got %v", vec)
+	}
+	if zero := normalize([]float32{0, 0}); zero[0] != 0 || zero[1] != 0 {
+		t.Fatalf("expected zero vector unchanged, got %v", zero)
+	}
+}
@@ -0,0 +1,203 @@
+//go:build cgo
+
+package embedder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"embedding-sidecar/internal/telemetry"
+
+	"github.com/yalue/onnxruntime_go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type onnxEmbedder struct {
+	modelPath string
+	// modelData is the model as loaded, so replacing the file on disk has no
+	// effect until the embedder is rebuilt (see Reloadable).
+	modelData  []byte
+	tokenizer  *wordpieceTokenizer
+	outputName string
+	dim        int
+	provider   string
+	options    *onnxruntime_go.SessionOptions
+}
+
+var runtimeInitOnce sync.Once
+var runtimeInitErr error
+
+// NewONNXEmbedder loads a sentence-transformer ONNX model and its wordpiece
+// vocabulary. It needs cgo; see onnx_nocgo.go for CGO_ENABLED=0 builds.
+func NewONNXEmbedder(modelPath string, vocabPath string, outputName string, dim int, runtime RuntimeOptions) (Embedding, error) {
+	if modelPath == "" {
+		return nil, errors.New("model path not provided")
+	}
+	if vocabPath == "" {
+		return nil, errors.New("vocab path not provided")
+	}
+	if outputName == "" {
+		outputName = "sentence_embedding"
+	}
+	runtime.Provider = strings.ToLower(runtime.Provider)
+	switch runtime.Provider {
+	case "":
+		runtime.Provider = ProviderCPU
+	case ProviderCPU, ProviderCUDA, ProviderDirectML:
+	default:
+		return nil, fmt.Errorf("unknown execution provider %q", runtime.Provider)
+	}
+	tokenizer, err := loadWordpieceTokenizer(vocabPath, 256)
+	if err != nil {
+		return nil, fmt.Errorf("load tokenizer: %w", err)
+	}
+	library, err := ResolveRuntimeLibrary(runtime.Library)
+	if err != nil {
+		return nil, err
+	}
+	runtimeInitOnce.Do(func() {
+		onnxruntime_go.SetSharedLibraryPath(library)
+		runtimeInitErr = onnxruntime_go.InitializeEnvironment()
+	})
+	if runtimeInitErr != nil {
+		return nil, fmt.Errorf("init onnx runtime: %w", runtimeInitErr)
+	}
+	options, err := runtime.sessionOptions()
+	if err != nil {
+		return nil, err
+	}
+	modelData, err := os.ReadFile(modelPath)
+	if err != nil {
+		options.Destroy()
+		return nil, fmt.Errorf("read model: %w", err)
+	}
+	// The model's declared hidden size wins over the configured one: a wrong
+	// LOOP_EMBEDDING_DIM would otherwise fail every inference.
+	if detected := modelOutputDim(modelData, modelPath, outputName); detected > 0 {
+		if dim > 0 && dim != detected {
+			slog.Warn("LOOP_EMBEDDING_DIM does not match the model output; using the model's",
+				"configured", dim, "model", detected)
+		}
+		dim = detected
+	}
+	if dim <= 0 {
+		dim = DefaultEmbeddingDim
+	}
+	return &onnxEmbedder{
+		modelPath:  modelPath,
+		modelData:  modelData,
+		tokenizer:  tokenizer,
+		outputName: outputName,
+		dim:        dim,
+		provider:   runtime.Provider,
+		options:    options,
+	}, nil
+}
+
+// Compute runs inference and returns the embedding vector.
+func (e *onnxEmbedder) Compute(ctx context.Context, text string) ([]float32, error) {
+	if text == "" {
+		return nil, errors.New("empty text")
+	}
+	ctx, span := telemetry.StartSpan(ctx, "embedder.compute",
+		attribute.Int("embedder.dim", e.dim),
+		attribute.String("embedder.output_name", e.outputName),
+		attribute.String("embedder.provider", e.provider),
+		// GenAI semantic conventions, so embedding spans line up with the proxy's.
+		attribute.String("gen_ai.operation.name", "embeddings"),
+		attribute.String("gen_ai.request.model", strings.TrimSuffix(filepath.Base(e.modelPath), filepath.Ext(e.modelPath))),
+		attribute.Int("gen_ai.embeddings.dimension.count", e.dim),
+	)
+	defer span.End()
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		telemetry.ObserveEmbedderLatency(ctx, e.dim, e.outputName, result, time.Since(start))
+	}()
+	inputIDs, attentionMask := e.tokenizer.Encode(text)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", len(inputIDs)))
+
+	inputTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(inputIDs))}, inputIDs)
+	if err != nil {
+		result = "error"
+		return nil, fmt.Errorf("create input_ids tensor: %w", err)
+	}
+	tokenTypeIDs := make([]int64, len(inputIDs)) // all zeros
+	typeTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(tokenTypeIDs))}, tokenTypeIDs)
+	if err != nil {
+		result = "error"
+		return nil, fmt.Errorf("create token_type_ids tensor: %w", err)
+	}
+	maskTensor, err := onnxruntime_go.NewTensor[int64](onnxruntime_go.Shape{1, int64(len(attentionMask))}, attentionMask)
+	if err != nil {
+		result = "error"
+		return nil, fmt.Errorf("create attention_mask tensor: %w", err)
+	}
+
+	inputNames := []string{"input_ids", "token_type_ids", "attention_mask"}
+	outputNames := []string{e.outputName}
+	seqLen := int64(len(attentionMask))
+	outputBuffer := make([]float32, int(seqLen)*e.dim)
+	outputTensor, err := onnxruntime_go.NewTensor[float32](onnxruntime_go.Shape{1, seqLen, int64(e.dim)}, outputBuffer)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return nil, fmt.Errorf("create output tensor: %w", err)
+	}
+
+	inputVals := []onnxruntime_go.Value{inputTensor, typeTensor, maskTensor}
+	outputVals := []onnxruntime_go.Value{outputTensor}
+	session, err := onnxruntime_go.NewAdvancedSessionWithONNXData(e.modelData, inputNames, outputNames, inputVals, outputVals, e.options)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return nil, fmt.Errorf("create onnx session: %w", err)
+	}
+	defer session.Destroy()
+
+	if err := session.Run(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return nil, fmt.Errorf("onnx run: %w", err)
+	}
+
+	data := outputTensor.GetData()
+	pooled, err := meanPool(data, attentionMask, e.dim)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return nil, err
+	}
+	return pooled, nil
+}
+
+// modelOutputDim reads the last dimension of the named output from the model
+// metadata, or returns 0 if it is dynamic or cannot be read.
+func modelOutputDim(modelData []byte, modelPath, outputName string) int {
+	_, outputs, err := onnxruntime_go.GetInputOutputInfoWithONNXData(modelData)
+	if err != nil {
+		slog.Warn("failed to read model outputs", "path", modelPath, "error", err)
+		return 0
+	}
+	for _, out := range outputs {
+		if out.Name != outputName || len(out.Dimensions) == 0 {
+			continue
+		}
+		if last := out.Dimensions[len(out.Dimensions)-1]; last > 0 {
+			return int(last)
+		}
+	}
+	return 0
+}
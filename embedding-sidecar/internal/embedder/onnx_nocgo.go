@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package embedder
+
+import "errors"
+
+// ErrONNXUnavailable is returned by NewONNXEmbedder in binaries built without
+// cgo (CGO_ENABLED=0), which cannot load ONNX Runtime.
+var ErrONNXUnavailable = errors.New("onnx embedder unavailable: built without cgo; set LOOP_EMBEDDER=hashing or rebuild with CGO_ENABLED=1")
+
+func NewONNXEmbedder(modelPath string, vocabPath string, outputName string, dim int, runtime RuntimeOptions) (Embedding, error) {
+	return nil, ErrONNXUnavailable
+}
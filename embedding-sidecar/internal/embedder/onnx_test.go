@@ -0,0 +1,15 @@
+//go:build cgo
+
+package embedder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewONNXEmbedderRejectsUnknownProvider(t *testing.T) {
+	_, err := NewONNXEmbedder("model.onnx", "vocab.txt", "", 0, RuntimeOptions{Provider: "tpu"})
+	if err == nil || !strings.Contains(err.Error(), "unknown execution provider") {
+		t.Fatalf("expected unknown provider error, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package embedder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Projection modes for fitting model embeddings to the index dimension.
+const (
+	ProjectionNone     = "none"
+	ProjectionTruncate = "truncate"
+	ProjectionPCA      = "pca"
+)
+
+// Projector maps model embeddings to a smaller index dimension, either by
+// keeping the leading components (suited to Matryoshka-trained models) or by a
+// precomputed PCA. Cosine similarity, which the index uses, is unaffected by
+// the scale of the result.
+type Projector struct {
+	inDim      int
+	outDim     int
+	mean       []float32
+	components [][]float32 // outDim rows of inDim; nil means truncation
+}
+
+// NewTruncation keeps the first outDim components of inDim-dimensional
+// embeddings.
+func NewTruncation(inDim, outDim int) (*Projector, error) {
+	if outDim <= 0 || outDim > inDim {
+		return nil, fmt.Errorf("cannot truncate %d dimensions to %d", inDim, outDim)
+	}
+	return &Projector{inDim: inDim, outDim: outDim}, nil
+}
+
+// pcaFile is the JSON layout LoadPCA reads: the training mean and one
+// principal component per output dimension.
+type pcaFile struct {
+	Mean       []float32   `json:"mean"`
+	Components [][]float32 `json:"components"`
+}
+
+// LoadPCA reads a PCA projection from path and checks it fits inDim-dimensional
+// embeddings and an outDim index.
+func LoadPCA(path string, inDim, outDim int) (*Projector, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pca: %w", err)
+	}
+	var f pcaFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("parse pca: %w", err)
+	}
+	if len(f.Components) != outDim {
+		return nil, fmt.Errorf("pca has %d components, index needs %d", len(f.Components), outDim)
+	}
+	if len(f.Mean) != 0 && len(f.Mean) != inDim {
+		return nil, fmt.Errorf("pca mean has %d dimensions, model outputs %d", len(f.Mean), inDim)
+	}
+	for i, c := range f.Components {
+		if len(c) != inDim {
+			return nil, fmt.Errorf("pca component %d has %d dimensions, model outputs %d", i, len(c), inDim)
+		}
+	}
+	return &Projector{inDim: inDim, outDim: outDim, mean: f.Mean, components: f.Components}, nil
+}
+
+// Dim is the projected dimension.
+func (p *Projector) Dim() int {
+	return p.outDim
+}
+
+// Project maps vec to the index dimension.
+func (p *Projector) Project(vec []float32) ([]float32, error) {
+	if len(vec) != p.inDim {
+		return nil, fmt.Errorf("unexpected embedding size: got %d want %d", len(vec), p.inDim)
+	}
+	if p.components == nil {
+		return vec[:p.outDim:p.outDim], nil
+	}
+	out := make([]float32, p.outDim)
+	for i, c := range p.components {
+		var sum float32
+		for j, v := range vec {
+			if p.mean != nil {
+				v -= p.mean[j]
+			}
+			sum += c[j] * v
+		}
+		out[i] = sum
+	}
+	return out, nil
+}
+
+// NewProjector builds the projector for mode, or returns nil when the model
+// already matches the index. A mismatch without a projection is an error, as
+// every store and search would fail.
+func NewProjector(mode, pcaPath string, modelDim, indexDim int) (*Projector, error) {
+	if indexDim <= 0 || indexDim == modelDim {
+		return nil, nil
+	}
+	switch mode {
+	case ProjectionTruncate:
+		return NewTruncation(modelDim, indexDim)
+	case ProjectionPCA:
+		if pcaPath == "" {
+			return nil, errors.New("LOOP_EMBEDDING_PCA_PATH is required for pca projection")
+		}
+		return LoadPCA(pcaPath, modelDim, indexDim)
+	default:
+		return nil, fmt.Errorf("model outputs %d dimensions but the index has %d; set LOOP_EMBEDDING_PROJECTION or recreate the index", modelDim, indexDim)
+	}
+}
+
+type projectedEmbedder struct {
+	Embedding
+	projector *Projector
+}
+
+// Projected returns emb with every embedding passed through p; a nil p
+// returns emb unchanged.
+func Projected(emb Embedding, p *Projector) Embedding {
+	if p == nil {
+		return emb
+	}
+	return &projectedEmbedder{Embedding: emb, projector: p}
+}
+
+func (e *projectedEmbedder) Compute(ctx context.Context, text string) ([]float32, error) {
+	vec, err := e.Embedding.Compute(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return e.projector.Project(vec)
+}
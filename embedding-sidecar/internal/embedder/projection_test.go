@@ -0,0 +1,54 @@
+package embedder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewProjector(t *testing.T) {
+	if p, err := NewProjector(ProjectionNone, "", 3, 3); p != nil || err != nil {
+		t.Fatalf("expected no projection for matching dims, got %v %v", p, err)
+	}
+	if _, err := NewProjector(ProjectionNone, "", 3, 2); err == nil {
+		t.Fatalf("expected mismatch without projection to fail")
+	}
+	if _, err := NewProjector(ProjectionTruncate, "", 2, 3); err == nil {
+		t.Fatalf("expected truncation to a larger dim to fail")
+	}
+	if _, err := NewProjector(ProjectionPCA, "", 3, 2); err == nil {
+		t.Fatalf("expected pca without a file to fail")
+	}
+}
+
+func TestProjectedEmbedder(t *testing.T) {
+	base := &countingEmbedder{} // returns {0.1, 0.2, 0.3}
+
+	trunc, err := NewProjector(ProjectionTruncate, "", 3, 2)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	vec, err := Projected(base, trunc).Compute(context.Background(), "x")
+	if err != nil || len(vec) != 2 || vec[0] != 0.1 || vec[1] != 0.2 {
+		t.Fatalf("unexpected truncation: %v %v", vec, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pca.json")
+	pca := `{"mean": [0.1, 0, 0], "components": [[0, 0, 1], [1, 1, 0]]}`
+	if err := os.WriteFile(path, []byte(pca), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	proj, err := NewProjector(ProjectionPCA, path, 3, 2)
+	if err != nil {
+		t.Fatalf("pca: %v", err)
+	}
+	vec, err = Projected(base, proj).Compute(context.Background(), "x")
+	if err != nil || len(vec) != 2 || vec[0] != 0.3 || vec[1] != 0.2 {
+		t.Fatalf("unexpected pca projection: %v %v", vec, err)
+	}
+
+	if _, err := LoadPCA(path, 4, 2); err == nil {
+		t.Fatalf("expected pca for another model dim to fail")
+	}
+}
@@ -0,0 +1,60 @@
+package embedder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type loadedEmbedding struct {
+	emb Embedding
+	dim int
+}
+
+// Reloadable is an Embedding whose model can be swapped while serving. In-flight
+// computations finish on the model they started with.
+type Reloadable struct {
+	mu      sync.Mutex // serializes reloads
+	current atomic.Pointer[loadedEmbedding]
+	warmup  WarmupOptions
+}
+
+// NewReloadable serves emb, whose warmed-up embeddings have dim dimensions.
+func NewReloadable(emb Embedding, dim int) *Reloadable {
+	r := &Reloadable{}
+	r.current.Store(&loadedEmbedding{emb: emb, dim: dim})
+	return r
+}
+
+// SetWarmup sets how reloaded models are warmed up before they serve.
+func (r *Reloadable) SetWarmup(opts WarmupOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warmup = opts
+}
+
+func (r *Reloadable) Compute(ctx context.Context, text string) ([]float32, error) {
+	return r.current.Load().emb.Compute(ctx, text)
+}
+
+// Reload builds a new embedding model, warms it up and switches to it. On any
+// failure the current model keeps serving. The new model must produce the
+// same dimension, as the index (and any projection) is built for it.
+func (r *Reloadable) Reload(build func() (Embedding, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, err := build()
+	if err != nil {
+		return fmt.Errorf("load model: %w", err)
+	}
+	dim, err := r.warmup.Run(context.Background(), next)
+	if err != nil {
+		return fmt.Errorf("warm up model: %w", err)
+	}
+	if current := r.current.Load().dim; dim != current {
+		return fmt.Errorf("new model outputs %d dimensions, serving model %d; restart to change dimensions", dim, current)
+	}
+	r.current.Store(&loadedEmbedding{emb: next, dim: dim})
+	return nil
+}
@@ -0,0 +1,47 @@
+package embedder
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fixedEmbedder struct {
+	vec []float32
+	err error
+}
+
+func (f fixedEmbedder) Compute(context.Context, string) ([]float32, error) {
+	return f.vec, f.err
+}
+
+func TestReloadableSwapsAfterWarmup(t *testing.T) {
+	r := NewReloadable(fixedEmbedder{vec: []float32{1, 0}}, 2)
+
+	if err := r.Reload(func() (Embedding, error) { return fixedEmbedder{vec: []float32{0, 1}}, nil }); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	vec, _ := r.Compute(context.Background(), "x")
+	if vec[1] != 1 {
+		t.Fatalf("expected new model to serve, got %v", vec)
+	}
+}
+
+func TestReloadableKeepsCurrentModelOnFailure(t *testing.T) {
+	r := NewReloadable(fixedEmbedder{vec: []float32{1, 0}}, 2)
+
+	failures := []func() (Embedding, error){
+		func() (Embedding, error) { return nil, errors.New("missing file") },
+		func() (Embedding, error) { return fixedEmbedder{err: errors.New("bad model")}, nil },
+		func() (Embedding, error) { return fixedEmbedder{vec: []float32{1, 0, 0}}, nil },
+	}
+	for i, build := range failures {
+		if err := r.Reload(build); err == nil {
+			t.Fatalf("case %d: expected reload to fail", i)
+		}
+		vec, err := r.Compute(context.Background(), "x")
+		if err != nil || vec[0] != 1 {
+			t.Fatalf("case %d: expected rollback to the serving model, got %v %v", i, vec, err)
+		}
+	}
+}
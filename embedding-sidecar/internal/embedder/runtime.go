@@ -0,0 +1,101 @@
+package embedder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Execution providers.
+const (
+	ProviderCPU      = "cpu"
+	ProviderCUDA     = "cuda"
+	ProviderDirectML = "directml"
+)
+
+// RuntimeOptions tunes ONNX Runtime for the host. Zero values keep ONNX
+// Runtime's defaults (CPU, one intra-op thread per core).
+type RuntimeOptions struct {
+	// Library is the ONNX Runtime shared library; GPU providers need a build
+	// that includes them. Empty searches the usual install locations (see
+	// ResolveRuntimeLibrary).
+	Library string
+	// Provider is ProviderCPU, ProviderCUDA or ProviderDirectML. Nodes a GPU
+	// provider cannot run fall back to the CPU.
+	Provider string
+	// DeviceID selects the GPU for ProviderCUDA and ProviderDirectML.
+	DeviceID int
+	// IntraOpThreads parallelizes a single inference; InterOpThreads runs
+	// independent graph nodes concurrently.
+	IntraOpThreads int
+	InterOpThreads int
+}
+
+// ResolveRuntimeLibrary returns library when set. Otherwise it looks for the
+// platform's ONNX Runtime library (libonnxruntime.so, libonnxruntime.dylib or
+// onnxruntime.dll) in the loader path (LD_LIBRARY_PATH, DYLD_LIBRARY_PATH or
+// PATH), then in the usual install directories for the OS and architecture.
+func ResolveRuntimeLibrary(library string) (string, error) {
+	if library != "" {
+		return library, nil
+	}
+	name := runtimeLibraryName(runtime.GOOS)
+	dirs := runtimeLibraryDirs(runtime.GOOS, runtime.GOARCH)
+	if found := findLibrary(name, dirs); found != "" {
+		return found, nil
+	}
+	return "", fmt.Errorf("onnx runtime library %s not found in %s; set ONNXRUNTIME_LIB_PATH, or LOOP_EMBEDDER=hashing to run without ONNX Runtime",
+		name, strings.Join(dirs, ", "))
+}
+
+func runtimeLibraryName(goos string) string {
+	switch goos {
+	case "darwin":
+		return "libonnxruntime.dylib"
+	case "windows":
+		return "onnxruntime.dll"
+	default:
+		return "libonnxruntime.so"
+	}
+}
+
+// runtimeLibraryDirs lists the directories searched for the library, loader
+// path first.
+func runtimeLibraryDirs(goos, goarch string) []string {
+	var dirs []string
+	switch goos {
+	case "darwin":
+		dirs = filepath.SplitList(os.Getenv("DYLD_LIBRARY_PATH"))
+		dirs = append(dirs, "/usr/local/lib", "/opt/homebrew/lib")
+	case "windows":
+		dirs = filepath.SplitList(os.Getenv("PATH"))
+	default:
+		dirs = filepath.SplitList(os.Getenv("LD_LIBRARY_PATH"))
+		dirs = append(dirs, "/usr/local/lib")
+		// Debian and Ubuntu multiarch directories.
+		switch goarch {
+		case "amd64":
+			dirs = append(dirs, "/usr/lib/x86_64-linux-gnu")
+		case "arm64":
+			dirs = append(dirs, "/usr/lib/aarch64-linux-gnu")
+		}
+		dirs = append(dirs, "/usr/lib", "/lib")
+	}
+	return dirs
+}
+
+// findLibrary returns the first dirs entry holding name, or "".
+func findLibrary(name string, dirs []string) string {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
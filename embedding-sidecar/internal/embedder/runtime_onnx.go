@@ -0,0 +1,59 @@
+//go:build cgo
+
+package embedder
+
+import (
+	"fmt"
+
+	"github.com/yalue/onnxruntime_go"
+)
+
+// sessionOptions builds the ONNX Runtime session options for o.
+func (o RuntimeOptions) sessionOptions() (*onnxruntime_go.SessionOptions, error) {
+	opts, err := onnxruntime_go.NewSessionOptions()
+	if err != nil {
+		return nil, fmt.Errorf("create session options: %w", err)
+	}
+	if err := o.apply(opts); err != nil {
+		opts.Destroy()
+		return nil, err
+	}
+	return opts, nil
+}
+
+func (o RuntimeOptions) apply(opts *onnxruntime_go.SessionOptions) error {
+	if o.IntraOpThreads > 0 {
+		if err := opts.SetIntraOpNumThreads(o.IntraOpThreads); err != nil {
+			return fmt.Errorf("set intra-op threads: %w", err)
+		}
+	}
+	if o.InterOpThreads > 0 {
+		if err := opts.SetInterOpNumThreads(o.InterOpThreads); err != nil {
+			return fmt.Errorf("set inter-op threads: %w", err)
+		}
+	}
+	switch o.Provider {
+	case "", ProviderCPU:
+		return nil
+	case ProviderCUDA:
+		cuda, err := onnxruntime_go.NewCUDAProviderOptions()
+		if err != nil {
+			return fmt.Errorf("create cuda options: %w", err)
+		}
+		defer cuda.Destroy()
+		if err := cuda.Update(map[string]string{"device_id": fmt.Sprint(o.DeviceID)}); err != nil {
+			return fmt.Errorf("set cuda device: %w", err)
+		}
+		if err := opts.AppendExecutionProviderCUDA(cuda); err != nil {
+			return fmt.Errorf("enable cuda: %w", err)
+		}
+		return nil
+	case ProviderDirectML:
+		if err := opts.AppendExecutionProviderDirectML(o.DeviceID); err != nil {
+			return fmt.Errorf("enable directml: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown execution provider %q", o.Provider)
+	}
+}
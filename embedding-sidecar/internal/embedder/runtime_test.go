@@ -0,0 +1,41 @@
+package embedder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRuntimeLibrary(t *testing.T) {
+	got, err := ResolveRuntimeLibrary("/opt/onnx/libonnxruntime.so")
+	if err != nil || got != "/opt/onnx/libonnxruntime.so" {
+		t.Fatalf("configured library should be used as is, got %q, %v", got, err)
+	}
+
+	dir := t.TempDir()
+	name := runtimeLibraryName("linux")
+	if findLibrary(name, []string{"", filepath.Join(dir, "missing"), dir}) != "" {
+		t.Fatalf("expected no library before it exists")
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := findLibrary(name, []string{filepath.Join(dir, "missing"), dir}); got != filepath.Join(dir, name) {
+		t.Fatalf("expected library in %s, got %q", dir, got)
+	}
+}
+
+func TestRuntimeLibraryDirs(t *testing.T) {
+	t.Setenv("LD_LIBRARY_PATH", "/custom/lib")
+	dirs := runtimeLibraryDirs("linux", "arm64")
+	if dirs[0] != "/custom/lib" {
+		t.Fatalf("expected loader path first, got %v", dirs)
+	}
+	if !strings.Contains(strings.Join(dirs, ":"), "/usr/lib/aarch64-linux-gnu") {
+		t.Fatalf("expected arm64 multiarch dir, got %v", dirs)
+	}
+	if name := runtimeLibraryName("darwin"); name != "libonnxruntime.dylib" {
+		t.Fatalf("unexpected darwin library name %q", name)
+	}
+}
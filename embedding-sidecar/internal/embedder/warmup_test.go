@@ -1,22 +1,29 @@
 package embedder
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 type countingEmbedder struct {
 	count int
 	err   error
 }
 
-func (c *countingEmbedder) Compute(text string) ([]float32, error) {
+func (c *countingEmbedder) Compute(_ context.Context, text string) ([]float32, error) {
 	c.count++
 	return []float32{0.1, 0.2, 0.3}, c.err
 }
 
 func TestWarmupEmbedder_Succeeds(t *testing.T) {
 	emb := &countingEmbedder{}
-	if err := Warmup(emb); err != nil {
+	dim, err := Warmup(emb)
+	if err != nil {
 		t.Fatalf("warmup failed: %v", err)
 	}
+	if dim != 3 {
+		t.Fatalf("expected detected dim 3, got %d", dim)
+	}
 	if emb.count != 1 {
 		t.Fatalf("expected 1 call, got %d", emb.count)
 	}
@@ -24,7 +31,32 @@ func TestWarmupEmbedder_Succeeds(t *testing.T) {
 
 func TestWarmupEmbedder_Fails(t *testing.T) {
 	emb := &countingEmbedder{err: errWarmupFail}
-	if err := Warmup(emb); err == nil {
+	if _, err := Warmup(emb); err == nil {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+type lengthEmbedder struct {
+	texts []string
+}
+
+func (l *lengthEmbedder) Compute(_ context.Context, text string) ([]float32, error) {
+	l.texts = append(l.texts, text)
+	return make([]float32, len(text)), nil
+}
+
+func TestWarmupOptionsRunsEveryTextEachIteration(t *testing.T) {
+	emb := &lengthEmbedder{}
+	opts := WarmupOptions{Iterations: 2, Texts: []string{"abc", "xyz"}}
+	dim, err := opts.Run(context.Background(), emb)
+	if err != nil {
+		t.Fatalf("warmup failed: %v", err)
+	}
+	if dim != 3 || len(emb.texts) != 4 {
+		t.Fatalf("expected dim 3 over 4 calls, got %d over %v", dim, emb.texts)
+	}
+
+	if _, err := (WarmupOptions{Texts: []string{"abc", "abcd"}}).Run(context.Background(), &lengthEmbedder{}); err == nil {
+		t.Fatalf("expected error for embeddings of different dimensions")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"embedding-sidecar/internal/embedder"
 	storepkg "embedding-sidecar/internal/store"
 	pb "embedding-sidecar/proto"
+	"embedding-sidecar/redisconf"
 
 	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
@@ -22,7 +23,7 @@ type stubEmbedder struct {
 	vec []float32
 }
 
-func (s *stubEmbedder) Compute(text string) ([]float32, error) {
+func (s *stubEmbedder) Compute(_ context.Context, text string) ([]float32, error) {
 	return s.vec, nil
 }
 
@@ -32,7 +33,7 @@ func TestGRPCIntegration_CheckLoop(t *testing.T) {
 		redisURL = "redis://localhost:6380"
 	}
 
-	vectorStore, err := storepkg.NewVectorStore(redisURL, 5*time.Minute, 5, embedder.DefaultEmbeddingDim)
+	vectorStore, err := storepkg.NewVectorStore(redisURL, redisconf.Settings{}, 5*time.Minute, 5, embedder.DefaultEmbeddingDim)
 	if err != nil {
 		t.Skipf("skipping: redis not reachable (%v)", err)
 	}
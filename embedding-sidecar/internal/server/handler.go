@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"log/slog"
+	"sync/atomic"
 
 	"embedding-sidecar/internal/detector"
 	"embedding-sidecar/internal/telemetry"
@@ -10,27 +11,73 @@ import (
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// errNotReady is returned while the sidecar is still warming up; clients
+// retry or fail open, as for any unavailable sidecar.
+var errNotReady = status.Error(grpccodes.Unavailable, "embedding sidecar is starting: model warmup or redis index check pending")
+
 type EmbeddingHandler struct {
 	pb.UnimplementedEmbeddingServiceServer
-	detector *detector.Detector
+	detector     atomic.Pointer[detector.Detector]
+	capabilities atomic.Pointer[pb.GetCapabilitiesResponse]
 }
 
+// NewEmbeddingHandler serves loop checks with detector. A nil detector starts
+// the handler unready, answering Unavailable until SetDetector, so the server
+// can listen (and report NOT_SERVING health) during warmup.
 func NewEmbeddingHandler(detector *detector.Detector) *EmbeddingHandler {
-	return &EmbeddingHandler{detector: detector}
+	h := &EmbeddingHandler{}
+	h.capabilities.Store(&pb.GetCapabilitiesResponse{ApiVersion: pb.APIVersion, MinApiVersion: pb.MinAPIVersion})
+	if detector != nil {
+		h.detector.Store(detector)
+	}
+	return h
+}
+
+// SetDetector makes the handler ready to serve loop checks with detector.
+func (h *EmbeddingHandler) SetDetector(detector *detector.Detector) {
+	h.detector.Store(detector)
+}
+
+// SetCapabilities sets what GetCapabilities reports; the API versions are
+// filled in from this build.
+func (h *EmbeddingHandler) SetCapabilities(caps *pb.GetCapabilitiesResponse) {
+	caps.ApiVersion = pb.APIVersion
+	caps.MinApiVersion = pb.MinAPIVersion
+	h.capabilities.Store(caps)
+}
+
+// GetCapabilities answers Unavailable until the handler is ready, so a client
+// does not mistake the capabilities of a sidecar still starting up for the
+// final ones.
+func (h *EmbeddingHandler) GetCapabilities(ctx context.Context, req *pb.GetCapabilitiesRequest) (*pb.GetCapabilitiesResponse, error) {
+	if v := req.GetApiVersion(); v < pb.MinAPIVersion {
+		slog.Warn("client API version is older than supported", "client_api_version", v, "min_api_version", pb.MinAPIVersion)
+	}
+	if h.detector.Load() == nil {
+		return nil, errNotReady
+	}
+	return h.capabilities.Load(), nil
 }
 
 func (h *EmbeddingHandler) CheckLoop(ctx context.Context, req *pb.CheckLoopRequest) (*pb.CheckLoopResponse, error) {
 	if req == nil {
 		return &pb.CheckLoopResponse{}, nil
 	}
+	ctx = telemetry.WithRequestID(ctx, req.GetRequestId())
 	ctx, span := telemetry.StartSpan(ctx, "check_loop")
 	defer span.End()
 
-	result, err := h.detector.CheckLoop(ctx, req.GetTenantId(), req.GetPrompt())
+	det := h.detector.Load()
+	if det == nil {
+		return nil, errNotReady
+	}
+	result, err := det.CheckLoop(ctx, req.GetTenantId(), req.GetPrompt())
 	if err != nil {
-		slog.Error("detector failed", "error", err)
+		slog.ErrorContext(ctx, "detector failed", "error", err)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return nil, err
@@ -45,3 +92,38 @@ func (h *EmbeddingHandler) CheckLoop(ctx context.Context, req *pb.CheckLoopReque
 		SimilarPrompt: result.SimilarPrompt,
 	}, nil
 }
+
+// GetCalibration reports the latest threshold calibration per tenant; it is
+// empty (Enabled false) when the calibration job is off.
+func (h *EmbeddingHandler) GetCalibration(ctx context.Context, req *pb.GetCalibrationRequest) (*pb.GetCalibrationResponse, error) {
+	det := h.detector.Load()
+	if det == nil {
+		return nil, errNotReady
+	}
+	calibrator := det.Calibrator()
+	if calibrator == nil {
+		return &pb.GetCalibrationResponse{}, nil
+	}
+	opts := calibrator.Options()
+	resp := &pb.GetCalibrationResponse{
+		Enabled:                 true,
+		AutoApply:               opts.AutoApply,
+		TargetFalsePositiveRate: opts.TargetRate,
+	}
+	for _, r := range calibrator.Results(req.GetTenantId()) {
+		resp.Tenants = append(resp.Tenants, &pb.TenantCalibration{
+			TenantId:             r.TenantID,
+			Samples:              int64(r.Samples),
+			CurrentThreshold:     r.CurrentThreshold,
+			RecommendedThreshold: r.RecommendedThreshold,
+			CurrentFlagRate:      r.CurrentFlagRate,
+			RecommendedFlagRate:  r.RecommendedFlagRate,
+			P50Similarity:        r.P50,
+			P95Similarity:        r.P95,
+			P99Similarity:        r.P99,
+			Applied:              r.Applied,
+			CalibratedAt:         r.CalibratedAt.Unix(),
+		})
+	}
+	return resp, nil
+}
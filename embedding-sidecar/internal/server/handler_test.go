@@ -1,13 +1,20 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
 	"testing"
 
 	"embedding-sidecar/internal/detector"
 	"embedding-sidecar/internal/store"
+	"embedding-sidecar/internal/telemetry"
 	pb "embedding-sidecar/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type fakeEmbedder struct {
@@ -15,7 +22,7 @@ type fakeEmbedder struct {
 	err error
 }
 
-func (f fakeEmbedder) Compute(string) ([]float32, error) {
+func (f fakeEmbedder) Compute(context.Context, string) ([]float32, error) {
 	return f.vec, f.err
 }
 
@@ -71,3 +78,93 @@ func TestHandlerPropagatesDetectorError(t *testing.T) {
 		t.Fatalf("expected nil response on error")
 	}
 }
+
+func TestHandlerLogsRequestID(t *testing.T) {
+	var out bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(telemetry.LogHandler(slog.NewJSONHandler(&out, nil))))
+	defer slog.SetDefault(prev)
+
+	d := detector.NewDetector(&fakeStore{}, fakeEmbedder{err: errors.New("embed fail")}, 0.9, 5)
+	h := NewEmbeddingHandler(d)
+	_, _ = h.CheckLoop(context.Background(), &pb.CheckLoopRequest{TenantId: "t1", Prompt: "hello", RequestId: "req-7"})
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line: %v (%q)", err, out.String())
+	}
+	if line["request_id"] != "req-7" {
+		t.Fatalf("expected request_id on the sidecar log line, got %v", line)
+	}
+}
+
+func TestHandlerGetCapabilities(t *testing.T) {
+	h := NewEmbeddingHandler(detector.NewDetector(&fakeStore{}, fakeEmbedder{}, 0.9, 5))
+	h.SetCapabilities(&pb.GetCapabilitiesResponse{
+		ModelName:    "all-MiniLM-L6-v2",
+		EmbeddingDim: 384,
+		Features:     []string{pb.FeatureCheckLoop},
+	})
+
+	resp, err := h.GetCapabilities(context.Background(), &pb.GetCapabilitiesRequest{ApiVersion: pb.APIVersion})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetApiVersion() != pb.APIVersion || resp.GetMinApiVersion() != pb.MinAPIVersion {
+		t.Fatalf("expected build API versions, got %d/%d", resp.GetApiVersion(), resp.GetMinApiVersion())
+	}
+	if resp.GetModelName() != "all-MiniLM-L6-v2" || resp.GetEmbeddingDim() != 384 || len(resp.GetFeatures()) != 1 {
+		t.Fatalf("unexpected capabilities: %+v", resp)
+	}
+}
+
+func TestHandlerUnavailableUntilReady(t *testing.T) {
+	h := NewEmbeddingHandler(nil)
+	h.SetCapabilities(&pb.GetCapabilitiesResponse{Features: []string{pb.FeatureCheckLoop}})
+
+	if _, err := h.CheckLoop(context.Background(), &pb.CheckLoopRequest{TenantId: "t1", Prompt: "hello"}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable before ready, got %v", err)
+	}
+	if _, err := h.GetCapabilities(context.Background(), &pb.GetCapabilitiesRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable capabilities before ready, got %v", err)
+	}
+
+	h.SetDetector(detector.NewDetector(&fakeStore{}, fakeEmbedder{vec: []float32{0.1}}, 0.9, 5))
+	if _, err := h.CheckLoop(context.Background(), &pb.CheckLoopRequest{TenantId: "t1", Prompt: "hello"}); err != nil {
+		t.Fatalf("expected loop check once ready, got %v", err)
+	}
+	if caps, err := h.GetCapabilities(context.Background(), &pb.GetCapabilitiesRequest{}); err != nil || len(caps.GetFeatures()) != 1 {
+		t.Fatalf("expected capabilities once ready, got %v, %v", caps, err)
+	}
+}
+
+func TestHandlerGetCalibration(t *testing.T) {
+	det := detector.NewDetector(&fakeStore{records: []store.EmbeddingRecord{{Similarity: 0.5}}}, fakeEmbedder{vec: []float32{0.1}}, 0.9, 5)
+	h := NewEmbeddingHandler(det)
+	if resp, err := h.GetCalibration(context.Background(), &pb.GetCalibrationRequest{}); err != nil || resp.GetEnabled() {
+		t.Fatalf("expected calibration disabled, got %v, %v", resp, err)
+	}
+
+	calibrator := detector.NewCalibrator(0.9, detector.CalibrationOptions{Window: 5, MinSamples: 5})
+	det.SetCalibrator(calibrator)
+	for _, tenant := range []string{"t1", "t2"} {
+		for range 5 {
+			if _, err := h.CheckLoop(context.Background(), &pb.CheckLoopRequest{TenantId: tenant, Prompt: "hello"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	calibrator.Calibrate(context.Background())
+
+	resp, err := h.GetCalibration(context.Background(), &pb.GetCalibrationRequest{TenantId: "t2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.GetEnabled() || resp.GetTargetFalsePositiveRate() != detector.DefaultCalibrationTargetRate || len(resp.GetTenants()) != 1 {
+		t.Fatalf("unexpected calibration: %+v", resp)
+	}
+	if got := resp.GetTenants()[0]; got.GetTenantId() != "t2" || got.GetSamples() != 5 || got.GetP50Similarity() != 0.5 ||
+		got.GetRecommendedThreshold() != detector.DefaultCalibrationMin {
+		t.Fatalf("unexpected tenant calibration: %+v", got)
+	}
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
@@ -11,8 +12,11 @@ import (
 	"strings"
 	"time"
 
+	"embedding-sidecar/envelope"
+	"embedding-sidecar/inflight"
 	"embedding-sidecar/internal/embedder"
 	"embedding-sidecar/internal/telemetry"
+	"embedding-sidecar/redisconf"
 
 	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel/attribute"
@@ -24,11 +28,36 @@ const (
 	redisKeyPrefix = "loop:"
 )
 
+// Distance metrics. MetricIP skips the per-comparison normalization cosine
+// does, so it needs unit-length embeddings (LOOP_EMBEDDING_NORMALIZE); for
+// those both metrics rank and score identically.
+const (
+	MetricCosine = "COSINE"
+	MetricIP     = "IP"
+)
+
+// ErrIndexMetricMismatch is returned by EnsureIndex when the existing index
+// uses a different distance metric.
+var ErrIndexMetricMismatch = errors.New("embedding index distance metric mismatch")
+
+// ErrIndexDimMismatch is returned by EnsureIndex when the existing index was
+// created for a different embedding dimension.
+var ErrIndexDimMismatch = errors.New("embedding index dimension mismatch")
+
 type VectorStore struct {
 	client redis.UniversalClient
 	ttl    time.Duration
 	keep   int
 	dim    int
+	metric string
+	// namespace prefixes the index and embedding keys (EMBEDDING_REDIS_NAMESPACE).
+	namespace string
+	// keyring, when set, encrypts stored prompts per tenant.
+	keyring *envelope.Keyring
+	// background tracks pruning writes for the shutdown drain.
+	background *inflight.Group
+	// hitTTL is the TTL matched embeddings are refreshed to; 0 uses ttl.
+	hitTTL time.Duration
 }
 
 type EmbeddingRecord struct {
@@ -38,16 +67,63 @@ type EmbeddingRecord struct {
 	Key        string
 }
 
-func NewVectorStore(redisURL string, ttl time.Duration, keep int, dim int) (*VectorStore, error) {
+func NewVectorStore(redisURL string, settings redisconf.Settings, ttl time.Duration, keep int, dim int) (*VectorStore, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
 	}
+	if err := settings.Apply(opts); err != nil {
+		return nil, err
+	}
 	client := redis.NewClient(opts)
 	if dim <= 0 {
 		dim = embedder.DefaultEmbeddingDim
 	}
-	return &VectorStore{client: client, ttl: ttl, keep: keep, dim: dim}, nil
+	return &VectorStore{client: client, ttl: ttl, keep: keep, dim: dim, metric: MetricCosine, namespace: settings.Namespace}, nil
+}
+
+// indexName is the RediSearch index inside the store's namespace.
+func (s *VectorStore) indexName() string {
+	return redisconf.Key(s.namespace, redisIndexName)
+}
+
+// keyPrefix is the embedding key prefix inside the store's namespace.
+func (s *VectorStore) keyPrefix() string {
+	return redisconf.Key(s.namespace, redisKeyPrefix)
+}
+
+// SetHitTTL sets the TTL TouchEmbeddings refreshes matched embeddings to;
+// 0 or less uses the store TTL.
+func (s *VectorStore) SetHitTTL(ttl time.Duration) {
+	s.hitTTL = ttl
+}
+
+// SetBackground tracks the store's background writes (pruning old
+// embeddings) in g, so shutdown can wait for them.
+func (s *VectorStore) SetBackground(g *inflight.Group) {
+	s.background = g
+}
+
+// SetDistanceMetric selects the index distance metric, MetricCosine or
+// MetricIP; unknown metrics fall back to MetricCosine. It only affects index
+// creation.
+func (s *VectorStore) SetDistanceMetric(metric string) {
+	metric = strings.ToUpper(metric)
+	if metric != MetricIP {
+		metric = MetricCosine
+	}
+	s.metric = metric
+}
+
+// Client returns the underlying Redis client.
+func (s *VectorStore) Client() redis.UniversalClient {
+	return s.client
+}
+
+// SetKeyring encrypts prompts stored from now on with their tenant's data key.
+// Vectors and tenant IDs stay in the clear so they can be indexed.
+func (s *VectorStore) SetKeyring(keyring *envelope.Keyring) {
+	s.keyring = keyring
 }
 
 func (s *VectorStore) EnsureIndex(ctx context.Context) error {
@@ -59,22 +135,40 @@ func (s *VectorStore) EnsureIndex(ctx context.Context) error {
 		telemetry.ObserveRedisLatency(ctx, "ensure_index", result, "", time.Since(start))
 	}()
 
-	_, err := s.client.Do(ctx, "FT.INFO", redisIndexName).Result()
+	info, err := s.client.Do(ctx, "FT.INFO", s.indexName()).Result()
 	if err == nil {
+		// An index built for another model cannot be searched with this one's
+		// embeddings, and FT.CREATE will not alter it.
+		if existing := indexVectorDim(info); existing > 0 && existing != s.dim {
+			result = "error"
+			err := fmt.Errorf("%w: index %s has %d dimensions, embeddings have %d; drop it with FT.DROPINDEX %s DD (stored embeddings expire anyway) or project to %d with LOOP_EMBEDDING_INDEX_DIM",
+				ErrIndexDimMismatch, s.indexName(), existing, s.dim, s.indexName(), existing)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		if existing := indexDistanceMetric(info); existing != "" && existing != s.metric {
+			result = "error"
+			err := fmt.Errorf("%w: index %s uses %s, configured %s; drop it with FT.DROPINDEX %s DD to switch",
+				ErrIndexMetricMismatch, s.indexName(), existing, s.metric, s.indexName())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
 		return nil
 	}
 
 	args := []any{
-		"FT.CREATE", redisIndexName,
+		"FT.CREATE", s.indexName(),
 		"ON", "HASH",
-		"PREFIX", 1, redisKeyPrefix,
+		"PREFIX", 1, s.keyPrefix(),
 		"SCHEMA",
 		"tenant_id", "TAG",
 		"prompt", "TEXT",
 		"vec", "VECTOR", "HNSW", 6,
 		"TYPE", "FLOAT32",
 		"DIM", s.dim,
-		"DISTANCE_METRIC", "COSINE",
+		"DISTANCE_METRIC", s.metric,
 	}
 	if err := s.client.Do(ctx, args...).Err(); err != nil {
 		span.RecordError(err)
@@ -100,8 +194,15 @@ func (s *VectorStore) StoreEmbedding(ctx context.Context, tenantID, prompt strin
 		return fmt.Errorf("embedding dimension mismatch: got %d want %d", len(embedding), s.dim)
 	}
 
-	key := fmt.Sprintf("%s%s:%d", redisKeyPrefix, tenantID, time.Now().UnixNano())
+	key := fmt.Sprintf("%s%s:%d", s.keyPrefix(), tenantID, time.Now().UnixNano())
 	vecBlob := float32SliceToBytes(embedding)
+	prompt, err := s.keyring.Seal(ctx, tenantID, prompt)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return err
+	}
 
 	fields := []any{
 		"tenant_id", tenantID,
@@ -124,19 +225,56 @@ func (s *VectorStore) StoreEmbedding(ctx context.Context, tenantID, prompt strin
 
 	// Optional pruning to keep recent embeddings small per tenant.
 	if s.keep > 0 {
-		go s.pruneOldEmbeddings(context.Background(), tenantID, s.keep)
+		pruneCtx := context.WithoutCancel(ctx)
+		s.background.Go(func() { s.pruneOldEmbeddings(pruneCtx, tenantID, s.keep) })
+	}
+	return nil
+}
+
+// TouchEmbeddings refreshes the TTL of stored embeddings that were matched
+// again, so a pattern that keeps repeating outlives the TTL while one-off
+// prompts still expire. The TTL is only ever extended (EXPIRE GT), and keys
+// that expired meanwhile are skipped.
+func (s *VectorStore) TouchEmbeddings(ctx context.Context, tenantID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, span := telemetry.StartSpan(ctx, "redis.touch_embeddings",
+		attribute.String("tenant.id", tenantID),
+		attribute.Int("touch.keys", len(keys)),
+	)
+	defer span.End()
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		telemetry.ObserveRedisLatency(ctx, "touch_embeddings", result, tenantID, time.Since(start))
+	}()
+
+	ttl := s.hitTTL
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	pipe := s.client.Pipeline()
+	for _, key := range keys {
+		pipe.ExpireGT(ctx, key, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "error"
+		return err
 	}
 	return nil
 }
 
 func (s *VectorStore) pruneOldEmbeddings(ctx context.Context, tenantID string, keep int) {
-	iter := s.client.Scan(ctx, 0, fmt.Sprintf("%s%s:*", redisKeyPrefix, tenantID), 100).Iterator()
+	iter := s.client.Scan(ctx, 0, fmt.Sprintf("%s%s:*", s.keyPrefix(), tenantID), 100).Iterator()
 	var keys []string
 	for iter.Next(ctx) {
 		keys = append(keys, iter.Val())
 	}
 	if err := iter.Err(); err != nil {
-		slog.Warn("prune scan failed", "tenant", tenantID, "error", err)
+		slog.WarnContext(ctx, "prune scan failed", "tenant", tenantID, "error", err)
 		return
 	}
 	if len(keys) <= keep {
@@ -145,7 +283,7 @@ func (s *VectorStore) pruneOldEmbeddings(ctx context.Context, tenantID string, k
 	sort.Strings(keys)
 	toDelete := keys[:len(keys)-keep]
 	if err := s.client.Del(ctx, toDelete...).Err(); err != nil {
-		slog.Warn("prune delete failed", "tenant", tenantID, "error", err, "count", len(toDelete))
+		slog.WarnContext(ctx, "prune delete failed", "tenant", tenantID, "error", err, "count", len(toDelete))
 	}
 }
 
@@ -172,7 +310,7 @@ func (s *VectorStore) SearchSimilarEmbeddings(ctx context.Context, tenantID stri
 	query := fmt.Sprintf("@tenant_id:{%s}=>[KNN %d @vec $vec AS score]", tenantTag, limit)
 
 	args := []any{
-		"FT.SEARCH", redisIndexName,
+		"FT.SEARCH", s.indexName(),
 		query,
 		"PARAMS", 2, "vec", vecBlob,
 		"SORTBY", "score",
@@ -191,21 +329,32 @@ func (s *VectorStore) SearchSimilarEmbeddings(ctx context.Context, tenantID stri
 	}
 
 	// RESP3 (Redis 7.4+) returns a map; handle that first.
+	var records []EmbeddingRecord
 	if m, ok := raw.(map[any]any); ok {
-		return parseSearchMapResult(m, limit), nil
+		records = parseSearchMapResult(m, limit)
+	} else if arr, ok := raw.([]any); ok && len(arr) > 0 {
+		records = parseSearchArrayResult(arr, limit)
 	}
+	s.openPrompts(ctx, tenantID, records)
+	return records, nil
+}
 
-	arr, ok := raw.([]any)
-	if !ok || len(arr) < 1 {
-		return nil, nil
+// openPrompts decrypts the prompts of records in place. A prompt that cannot be
+// decrypted (e.g. its tenant's key was deleted) is cleared: the similarity
+// still counts, only the text is lost.
+func (s *VectorStore) openPrompts(ctx context.Context, tenantID string, records []EmbeddingRecord) {
+	for i := range records {
+		prompt, err := s.keyring.Open(ctx, tenantID, records[i].Prompt)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to decrypt stored prompt", "tenant", tenantID, "key", records[i].Key, "error", err)
+		}
+		records[i].Prompt = prompt
 	}
-
-	records := parseSearchArrayResult(arr, limit)
-	return records, nil
 }
 
 func distanceToSimilarity(distance float64) float64 {
 	// COSINE distance: 0.0 (identical) to 2.0 (opposite). Convert to similarity 0..1.
+	// IP distance over unit vectors is the same 1 - cos.
 	if distance <= 0 {
 		return 1
 	}
@@ -306,3 +455,49 @@ func parseFloatFromAny(v any) float64 {
 	}
 	return 0
 }
+
+// indexVectorDim finds the vector field's DIM in an FT.INFO reply, or
+// returns 0.
+func indexVectorDim(info any) int {
+	return dimFromAny(indexAttr(info, "dim"))
+}
+
+// indexDistanceMetric finds the vector field's distance metric in an FT.INFO
+// reply, or returns "".
+func indexDistanceMetric(info any) string {
+	metric, _ := indexAttr(info, "distance_metric").(string)
+	return strings.ToUpper(metric)
+}
+
+// indexAttr finds the first value named name in an FT.INFO reply, as a RESP2
+// key/value list or a RESP3 map, or returns nil.
+func indexAttr(info any, name string) any {
+	switch v := info.(type) {
+	case map[any]any:
+		for key, val := range v {
+			if k, _ := key.(string); strings.EqualFold(k, name) {
+				return val
+			}
+			if found := indexAttr(val, name); found != nil {
+				return found
+			}
+		}
+	case []any:
+		for i, val := range v {
+			if k, ok := val.(string); ok && strings.EqualFold(k, name) && i+1 < len(v) {
+				return v[i+1]
+			}
+			if found := indexAttr(val, name); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func dimFromAny(v any) int {
+	if n, ok := v.(int64); ok {
+		return int(n)
+	}
+	return int(parseFloatFromAny(v))
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"embedding-sidecar/internal/embedder"
+	"embedding-sidecar/redisconf"
 )
 
 // These integration tests require Redis Stack with VSS (e.g., redis-stack at localhost:6380).
@@ -17,7 +18,7 @@ func TestVectorStoreIntegration_WithRedisStack(t *testing.T) {
 		redisURL = "redis://localhost:6380"
 	}
 
-	store, err := NewVectorStore(redisURL, 5*time.Minute, 5, embedder.DefaultEmbeddingDim)
+	store, err := NewVectorStore(redisURL, redisconf.Settings{}, 5*time.Minute, 5, embedder.DefaultEmbeddingDim)
 	if err != nil {
 		t.Skipf("skipping: redis not reachable (%v)", err)
 	}
@@ -48,4 +49,20 @@ func TestVectorStoreIntegration_WithRedisStack(t *testing.T) {
 	if records[0].Similarity < 0.99 {
 		t.Fatalf("expected similarity >= 0.99, got %v", records[0].Similarity)
 	}
+
+	// A matched embedding's TTL is extended to the hit TTL, never shortened.
+	store.SetHitTTL(time.Hour)
+	if err := store.TouchEmbeddings(ctx, tenant, []string{records[0].Key}); err != nil {
+		t.Fatalf("TouchEmbeddings error: %v", err)
+	}
+	if ttl := store.Client().TTL(ctx, records[0].Key).Val(); ttl <= 5*time.Minute {
+		t.Fatalf("expected the TTL extended past 5m, got %v", ttl)
+	}
+	store.SetHitTTL(time.Minute)
+	if err := store.TouchEmbeddings(ctx, tenant, []string{records[0].Key, "missing-key"}); err != nil {
+		t.Fatalf("TouchEmbeddings error: %v", err)
+	}
+	if ttl := store.Client().TTL(ctx, records[0].Key).Val(); ttl <= 5*time.Minute {
+		t.Fatalf("expected a shorter hit TTL to leave the TTL alone, got %v", ttl)
+	}
 }
@@ -49,3 +49,41 @@ func TestParseSearchMapResult(t *testing.T) {
 		t.Fatalf("parseSearchMapResult got %+v want %+v", records, want)
 	}
 }
+
+func TestIndexVectorDim(t *testing.T) {
+	resp2 := []any{
+		"index_name", "loop:embeddings_idx",
+		"attributes", []any{
+			[]any{"identifier", "tenant_id", "type", "TAG"},
+			[]any{"identifier", "vec", "type", "VECTOR", "algorithm", "HNSW", "data_type", "FLOAT32", "dim", int64(768), "distance_metric", "COSINE"},
+		},
+	}
+	if got := indexVectorDim(resp2); got != 768 {
+		t.Fatalf("resp2 dim got %d want 768", got)
+	}
+	resp3 := map[any]any{
+		"attributes": []any{
+			map[any]any{"identifier": "vec", "type": "VECTOR", "dim": "384"},
+		},
+	}
+	if got := indexVectorDim(resp3); got != 384 {
+		t.Fatalf("resp3 dim got %d want 384", got)
+	}
+	if got := indexDistanceMetric(resp2); got != MetricCosine {
+		t.Fatalf("resp2 metric got %q want COSINE", got)
+	}
+	if got := indexVectorDim([]any{"index_name", "x"}); got != 0 {
+		t.Fatalf("expected 0 without a vector field, got %d", got)
+	}
+}
+
+func TestNamespacedKeys(t *testing.T) {
+	s := &VectorStore{}
+	if s.indexName() != "loop:embeddings_idx" || s.keyPrefix() != "loop:" {
+		t.Fatalf("expected bare keys, got %q %q", s.indexName(), s.keyPrefix())
+	}
+	s.namespace = "staging"
+	if s.indexName() != "staging:loop:embeddings_idx" || s.keyPrefix() != "staging:loop:" {
+		t.Fatalf("expected namespaced keys, got %q %q", s.indexName(), s.keyPrefix())
+	}
+}
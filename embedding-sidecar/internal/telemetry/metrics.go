@@ -22,6 +22,17 @@ var (
 	redisErrors  metric.Int64Counter
 
 	loopChecks metric.Int64Counter
+
+	modelReloads metric.Int64Counter
+	warmup       metric.Float64Histogram
+
+	storeDropped metric.Int64Counter
+	storeDepth   metric.Int64ObservableGauge
+	depthOnce    sync.Once
+
+	calibrationThreshold metric.Float64Gauge
+	calibrationFlagRate  metric.Float64Gauge
+	thresholdAdjustments metric.Int64Counter
 )
 
 func initMeter() {
@@ -44,6 +55,27 @@ func initMeter() {
 		if loopChecks, err = meter.Int64Counter("sidecar.loop_check.requests"); err != nil {
 			slog.Warn("failed to create metric", "name", "sidecar.loop_check.requests", "error", err)
 		}
+		if modelReloads, err = meter.Int64Counter("sidecar.model.reloads"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.model.reloads", "error", err)
+		}
+		if warmup, err = meter.Float64Histogram("sidecar.embedder.warmup_ms"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.embedder.warmup_ms", "error", err)
+		}
+		if storeDropped, err = meter.Int64Counter("sidecar.store_queue.dropped"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.store_queue.dropped", "error", err)
+		}
+		if storeDepth, err = meter.Int64ObservableGauge("sidecar.store_queue.depth"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.store_queue.depth", "error", err)
+		}
+		if calibrationThreshold, err = meter.Float64Gauge("sidecar.calibration.threshold"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.calibration.threshold", "error", err)
+		}
+		if calibrationFlagRate, err = meter.Float64Gauge("sidecar.calibration.flag_rate"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.calibration.flag_rate", "error", err)
+		}
+		if thresholdAdjustments, err = meter.Int64Counter("sidecar.calibration.adjustments"); err != nil {
+			slog.Warn("failed to create metric", "name", "sidecar.calibration.adjustments", "error", err)
+		}
 	})
 }
 
@@ -76,9 +108,7 @@ func ObserveRedisLatency(ctx context.Context, op, result, tenantID string, d tim
 		attribute.String("op", op),
 		attribute.String("result", result),
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 	redisLatency.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
 	if result == "error" && redisErrors != nil {
 		redisErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
@@ -95,8 +125,103 @@ func RecordLoopCheck(ctx context.Context, result, tenantID string) {
 	attrs := []attribute.KeyValue{
 		attribute.String("result", result),
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 	loopChecks.Add(ctx, 1, metric.WithAttributes(attrs...))
 }
+
+// RecordModelReload counts a model reload attempt (result=ok|error).
+func RecordModelReload(ctx context.Context, result string) {
+	if modelReloads == nil {
+		initMeter()
+	}
+	if modelReloads == nil {
+		return
+	}
+	modelReloads.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
+// ObserveWarmupLatency records how long a model warmup took (result=ok|error),
+// at startup and on reload.
+func ObserveWarmupLatency(ctx context.Context, result string, d time.Duration) {
+	if warmup == nil {
+		initMeter()
+	}
+	if warmup == nil {
+		return
+	}
+	warmup.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attribute.String("result", result)))
+}
+
+// RecordStoreDropped counts an embedding write dropped by the store queue
+// (reason=drop_oldest|drop_newest|closed).
+func RecordStoreDropped(ctx context.Context, reason, tenantID string) {
+	if storeDropped == nil {
+		initMeter()
+	}
+	if storeDropped == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.String("reason", reason)}, tenantID)
+	storeDropped.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// AddStoreDropped counts n background Redis writes given up at once, such as
+// those still pending when the shutdown drain times out
+// (reason=shutdown_timeout).
+func AddStoreDropped(ctx context.Context, n int64, reason string) {
+	if storeDropped == nil {
+		initMeter()
+	}
+	if storeDropped == nil || n <= 0 {
+		return
+	}
+	storeDropped.Add(ctx, n, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// RegisterStoreQueueDepth reports depth() as sidecar.store_queue.depth. Only
+// the first registration takes effect.
+func RegisterStoreQueueDepth(depth func() int64) {
+	depthOnce.Do(func() {
+		initMeter()
+		if storeDepth == nil {
+			return
+		}
+		if _, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+			o.ObserveInt64(storeDepth, depth())
+			return nil
+		}, storeDepth); err != nil {
+			slog.Warn("failed to register metric callback", "name", "sidecar.store_queue.depth", "error", err)
+		}
+	})
+}
+
+// RecordCalibration reports a tenant's calibration run: the threshold in
+// effect and the recommended one (kind=current|recommended), and the share of
+// sampled checks each would flag.
+func RecordCalibration(ctx context.Context, tenantID string, current, recommended, currentRate, recommendedRate float64) {
+	if calibrationThreshold == nil {
+		initMeter()
+	}
+	if calibrationThreshold == nil || calibrationFlagRate == nil {
+		return
+	}
+	currentAttrs := metric.WithAttributes(appendTenant([]attribute.KeyValue{attribute.String("kind", "current")}, tenantID)...)
+	recommendedAttrs := metric.WithAttributes(appendTenant([]attribute.KeyValue{attribute.String("kind", "recommended")}, tenantID)...)
+	calibrationThreshold.Record(ctx, current, currentAttrs)
+	calibrationThreshold.Record(ctx, recommended, recommendedAttrs)
+	calibrationFlagRate.Record(ctx, currentRate, currentAttrs)
+	calibrationFlagRate.Record(ctx, recommendedRate, recommendedAttrs)
+}
+
+// RecordThresholdAdjustment counts a calibrated threshold applied to a
+// tenant (direction=raise|lower).
+func RecordThresholdAdjustment(ctx context.Context, tenantID, direction string) {
+	if thresholdAdjustments == nil {
+		initMeter()
+	}
+	if thresholdAdjustments == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.String("direction", direction)}, tenantID)
+	thresholdAdjustments.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
@@ -0,0 +1,56 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the proxy's request ID. Spans started
+// with StartSpan and log records written through LogHandler pick it up.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDAttr is the span attribute for the request ID, as on the proxy.
+func requestIDAttr(id string) attribute.KeyValue {
+	return attribute.String("request.id", id)
+}
+
+// LogHandler wraps base, adding request_id to records logged with a context
+// that carries one.
+func LogHandler(base slog.Handler) slog.Handler {
+	return logHandler{base}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+func (h logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{h.Handler.WithGroup(name)}
+}
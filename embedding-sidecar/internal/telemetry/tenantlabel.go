@@ -0,0 +1,147 @@
+package telemetry
+
+import (
+	"cmp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Tenant label modes for METRICS_TENANT_MODE.
+const (
+	tenantModeAll  = "all"
+	tenantModeTop  = "top"
+	tenantModeNone = "none"
+)
+
+const (
+	// otherTenant is the tenant.id value for tenants outside the labeled set.
+	otherTenant = "other"
+	// tenantTopWindow is how often the top-N set is recomputed from request counts.
+	tenantTopWindow   = time.Minute
+	defaultTenantTopN = 20
+	// maxTrackedTenants bounds the per-window counts; tenants first seen after
+	// the limit is reached are not ranked until the next window.
+	maxTrackedTenants = 10000
+)
+
+// tenantLabeler decides the tenant.id label value for metrics so the number of
+// series stays bounded with thousands of tenants.
+type tenantLabeler struct {
+	mode  string
+	allow map[string]bool
+	topN  int
+	now   func() time.Time
+
+	mu          sync.Mutex
+	counts      map[string]int
+	top         map[string]bool
+	windowStart time.Time
+}
+
+var tenantLabels = newTenantLabelerFromEnv()
+
+// newTenantLabelerFromEnv reads METRICS_TENANT_MODE (all, top or none; default
+// all), METRICS_TENANT_ALLOWLIST (comma-separated tenants always labeled in top
+// mode) and METRICS_TENANT_TOP_N (default 20).
+func newTenantLabelerFromEnv() *tenantLabeler {
+	topN := defaultTenantTopN
+	if v, err := strconv.Atoi(os.Getenv("METRICS_TENANT_TOP_N")); err == nil && v >= 0 {
+		topN = v
+	}
+	var allow []string
+	for _, t := range strings.Split(os.Getenv("METRICS_TENANT_ALLOWLIST"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			allow = append(allow, t)
+		}
+	}
+	return newTenantLabeler(os.Getenv("METRICS_TENANT_MODE"), allow, topN)
+}
+
+func newTenantLabeler(mode string, allow []string, topN int) *tenantLabeler {
+	switch mode {
+	case tenantModeTop, tenantModeNone:
+	default:
+		mode = tenantModeAll
+	}
+	l := &tenantLabeler{
+		mode:   mode,
+		allow:  make(map[string]bool, len(allow)),
+		topN:   topN,
+		now:    time.Now,
+		counts: make(map[string]int),
+		top:    make(map[string]bool),
+	}
+	for _, t := range allow {
+		l.allow[t] = true
+	}
+	return l
+}
+
+// label returns the label value for tenantID, or "" when the tenant dimension
+// should be omitted.
+func (l *tenantLabeler) label(tenantID string) string {
+	if tenantID == "" || l.mode == tenantModeNone {
+		return ""
+	}
+	if l.mode == tenantModeAll || l.allow[tenantID] {
+		return tenantID
+	}
+	if l.topN == 0 {
+		return otherTenant
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if l.windowStart.IsZero() {
+		l.windowStart = now
+	} else if now.Sub(l.windowStart) >= tenantTopWindow {
+		l.rotate()
+		l.windowStart = now
+	}
+	if _, ok := l.counts[tenantID]; ok || len(l.counts) < maxTrackedTenants {
+		l.counts[tenantID]++
+	}
+	if l.top[tenantID] {
+		return tenantID
+	}
+	return otherTenant
+}
+
+// rotate replaces the top set with the busiest tenants of the ending window.
+// Caller holds mu.
+func (l *tenantLabeler) rotate() {
+	type tenantCount struct {
+		id    string
+		count int
+	}
+	ranked := make([]tenantCount, 0, len(l.counts))
+	for id, n := range l.counts {
+		ranked = append(ranked, tenantCount{id, n})
+	}
+	slices.SortFunc(ranked, func(a, b tenantCount) int {
+		if c := cmp.Compare(b.count, a.count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.id, b.id)
+	})
+	l.top = make(map[string]bool, l.topN)
+	for _, tc := range ranked[:min(l.topN, len(ranked))] {
+		l.top[tc.id] = true
+	}
+	l.counts = make(map[string]int, len(l.counts))
+}
+
+// appendTenant adds the tenant.id attribute permitted by the labeling policy.
+func appendTenant(attrs []attribute.KeyValue, tenantID string) []attribute.KeyValue {
+	if label := tenantLabels.label(tenantID); label != "" {
+		attrs = append(attrs, attribute.String("tenant.id", label))
+	}
+	return attrs
+}
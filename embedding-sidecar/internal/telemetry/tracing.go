@@ -14,7 +14,7 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
 )
 
 var tracer trace.Tracer
@@ -72,14 +72,19 @@ func Tracer() trace.Tracer {
 }
 
 // StartSpan starts a span if tracer is set; otherwise returns ctx, noop span.
+// The span records the request ID carried by ctx, if any.
 func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
 	if tracer == nil {
 		return ctx, trace.SpanFromContext(ctx)
 	}
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, requestIDAttr(id))
+	}
 	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
 }
 
-// GRPCUnaryInterceptor returns the otelgrpc unary interceptor.
-func GRPCUnaryInterceptor() grpc.UnaryServerInterceptor {
-	return otelgrpc.UnaryServerInterceptor()
+// GRPCServerHandler returns the otelgrpc stats handler, which continues the
+// caller's trace from the incoming metadata.
+func GRPCServerHandler() stats.Handler {
+	return otelgrpc.NewServerHandler()
 }
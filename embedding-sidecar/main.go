@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"embedding-sidecar/envelope"
+	"embedding-sidecar/inflight"
 	"embedding-sidecar/internal/config"
 	"embedding-sidecar/internal/detector"
 	"embedding-sidecar/internal/embedder"
@@ -24,39 +29,16 @@ import (
 
 func main() {
 	cfg := config.Load()
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger := slog.New(telemetry.LogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
 	slog.SetDefault(logger)
 
 	shutdownTracing := telemetry.Init("embedding-sidecar")
 	defer shutdownTracing(context.Background())
 
-	vectorStore, err := store.NewVectorStore(cfg.EmbeddingRedisURL, cfg.EmbeddingTTL, cfg.HistorySize, cfg.EmbeddingDim)
-	if err != nil {
-		slog.Error("failed to init redis", "error", err)
-		os.Exit(1)
-	}
-
-	ctx := context.Background()
-	if err := vectorStore.EnsureIndex(ctx); err != nil {
-		slog.Error("failed to ensure redis index", "error", err)
-		os.Exit(1)
-	}
-
-	emb, err := embedder.NewONNXEmbedder(cfg.EmbeddingModelPath, cfg.EmbeddingVocabPath, cfg.EmbeddingOutputName, cfg.EmbeddingDim)
-	if err != nil {
-		slog.Error("failed to init embedder", "error", err)
-		os.Exit(1)
-	}
-
-	if err := embedder.Warmup(emb); err != nil {
-		slog.Error("embedder warmup failed", "error", err)
-		os.Exit(1)
-	}
-	slog.Info("embedder warmup completed")
-
-	det := detector.NewDetector(vectorStore, emb, cfg.SimilarityThreshold, cfg.HistorySize)
-	handler := server.NewEmbeddingHandler(det)
-
+	// Listen right away, unready: loop checks answer Unavailable and health
+	// reports NOT_SERVING until the model is warmed up and the Redis index
+	// checked, so "starting" can be told apart from "down".
+	handler := server.NewEmbeddingHandler(nil)
 	if err := removeIfExists(cfg.UDSPath); err != nil {
 		slog.Error("failed to cleanup UDS path", "error", err)
 		os.Exit(1)
@@ -74,11 +56,12 @@ func main() {
 	}
 
 	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(telemetry.GRPCUnaryInterceptor()),
+		grpc.StatsHandler(telemetry.GRPCServerHandler()),
 	)
 	pb.RegisterEmbeddingServiceServer(grpcServer, handler)
 
 	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
 	healthpb.RegisterHealthServer(grpcServer, healthServer)
 
 	go func() {
@@ -88,17 +71,227 @@ func main() {
 		}
 	}()
 
+	startCtx, stopStart := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	runtimeOpts := embedder.RuntimeOptions{
+		Library:        cfg.ONNXRuntimeLibrary,
+		Provider:       cfg.EmbeddingProvider,
+		DeviceID:       cfg.EmbeddingDeviceID,
+		IntraOpThreads: cfg.IntraOpThreads,
+		InterOpThreads: cfg.InterOpThreads,
+	}
+	modelName := strings.TrimSuffix(filepath.Base(cfg.EmbeddingModelPath), filepath.Ext(cfg.EmbeddingModelPath))
+	var newEmbedder func() (embedder.Embedding, error)
+	switch backend := strings.ToLower(cfg.Embedder); backend {
+	case embedder.BackendONNX:
+		newEmbedder = func() (embedder.Embedding, error) {
+			return embedder.NewONNXEmbedder(cfg.EmbeddingModelPath, cfg.EmbeddingVocabPath, cfg.EmbeddingOutputName, cfg.EmbeddingDim, runtimeOpts)
+		}
+	case embedder.BackendHashing:
+		// No model files or ONNX Runtime: for CGO_ENABLED=0 builds and hosts
+		// onnxruntime does not ship for.
+		newEmbedder = func() (embedder.Embedding, error) {
+			return embedder.NewHashingEmbedder(cfg.EmbeddingDim), nil
+		}
+		modelName = backend
+	default:
+		slog.Error("unknown embedder; use onnx or hashing", "embedder", cfg.Embedder)
+		os.Exit(1)
+	}
+	emb, err := newEmbedder()
+	if err != nil {
+		slog.Error("failed to init embedder", "error", err)
+		os.Exit(1)
+	}
+
+	warmup := embedder.WarmupOptions{Iterations: cfg.WarmupIterations, Texts: cfg.WarmupTexts}
+	warmupStart := time.Now()
+	modelDim, err := warmup.Run(startCtx, emb)
+	if err != nil {
+		slog.Error("embedder warmup failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("embedder warmup completed", "embedder", cfg.Embedder, "dim", modelDim, "provider", runtimeOpts.Provider,
+		"iterations", max(warmup.Iterations, 1), "duration_ms", time.Since(warmupStart).Milliseconds())
+
+	// SIGHUP reloads the model and vocab from their paths; the embedder holds
+	// its own copy, so files can be replaced first.
+	reloadable := embedder.NewReloadable(emb, modelDim)
+	reloadable.SetWarmup(warmup)
+	emb = reloadable
+
+	// The index dimension follows the model unless a projection fits the model
+	// to an existing (smaller) index.
+	indexDim := modelDim
+	projector, err := embedder.NewProjector(cfg.EmbeddingProjection, cfg.EmbeddingPCAPath, modelDim, cfg.EmbeddingIndexDim)
+	if err != nil {
+		slog.Error("failed to configure embedding projection", "error", err)
+		os.Exit(1)
+	}
+	if projector != nil {
+		indexDim = projector.Dim()
+		emb = embedder.Projected(emb, projector)
+		slog.Info("projecting embeddings", "mode", cfg.EmbeddingProjection, "model_dim", modelDim, "index_dim", indexDim)
+	}
+
+	vectorStore, err := store.NewVectorStore(cfg.EmbeddingRedisURL, cfg.Redis, cfg.EmbeddingTTL, cfg.HistorySize, indexDim)
+	if err != nil {
+		slog.Error("failed to init redis", "error", err)
+		os.Exit(1)
+	}
+	vectorStore.SetDistanceMetric(cfg.DistanceMetric)
+	vectorStore.SetHitTTL(cfg.HitTTL)
+	keyring, err := envelope.FromEnv(vectorStore.Client(), cfg.Redis.Namespace)
+	if err != nil {
+		slog.Error("failed to init prompt encryption", "error", err)
+		os.Exit(1)
+	}
+	if keyring != nil {
+		vectorStore.SetKeyring(keyring)
+		slog.Info("encrypting stored prompts")
+	}
+
+	// Normalize last, after any projection. An inner-product index only
+	// behaves like cosine over unit vectors, so it always normalizes.
+	normalize := cfg.EmbeddingNormalize
+	if strings.EqualFold(cfg.DistanceMetric, store.MetricIP) && !normalize {
+		slog.Warn("IP distance metric requires normalized embeddings; enabling LOOP_EMBEDDING_NORMALIZE")
+		normalize = true
+	}
+	if normalize {
+		emb = embedder.Normalized(emb)
+	}
+
+	if err := waitForIndex(startCtx, vectorStore); err != nil {
+		if startCtx.Err() != nil {
+			slog.Info("shutdown requested during startup")
+			grpcServer.Stop()
+			_ = removeIfExists(cfg.UDSPath)
+			return
+		}
+		slog.Error("failed to ensure redis index", "error", err)
+		grpcServer.Stop()
+		_ = removeIfExists(cfg.UDSPath)
+		os.Exit(1)
+	}
+	stopStart()
+
+	det := detector.NewDetector(vectorStore, emb, cfg.SimilarityThreshold, cfg.HistorySize)
+	det.SetStoreMode(cfg.StoreMode)
+	det.SetTouchOnHit(cfg.TouchOnHit)
+	// Every background Redis write is tracked in one group, as the proxy
+	// tracks its async operations, so shutdown drains them together.
+	background := inflight.NewGroup(0)
+	vectorStore.SetBackground(background)
+	writer := detector.NewWriter(vectorStore, detector.WriterOptions{
+		Workers:   cfg.StoreWorkers,
+		QueueSize: cfg.StoreQueueSize,
+		Policy:    cfg.StoreQueuePolicy,
+		Group:     background,
+	})
+	det.SetWriter(writer)
+	telemetry.RegisterStoreQueueDepth(writer.Depth)
+
+	features := []string{pb.FeatureCheckLoop, pb.FeatureHotReload, pb.FeatureStoreModePrefix + det.StoreMode()}
+	if cfg.CalibrationEnabled {
+		calibrator := detector.NewCalibrator(cfg.SimilarityThreshold, detector.CalibrationOptions{
+			Interval:   cfg.CalibrationInterval,
+			TargetRate: cfg.CalibrationTargetRate,
+			Window:     cfg.CalibrationWindow,
+			MinSamples: cfg.CalibrationMinSamples,
+			Min:        cfg.CalibrationMinThreshold,
+			Max:        cfg.CalibrationMaxThreshold,
+			AutoApply:  cfg.CalibrationAutoApply,
+			MaxStep:    cfg.CalibrationMaxStep,
+		})
+		det.SetCalibrator(calibrator)
+		// Runs for the life of the process; applied thresholds are not kept
+		// across restarts.
+		go calibrator.Run(context.Background())
+		opts := calibrator.Options()
+		slog.Info("threshold calibration enabled", "interval", opts.Interval, "target_fpr", opts.TargetRate, "auto_apply", opts.AutoApply)
+		features = append(features, pb.FeatureCalibration)
+	}
+	if normalize {
+		features = append(features, pb.FeatureNormalize)
+	}
+	if projector != nil {
+		features = append(features, pb.FeatureProjectionPrefix+cfg.EmbeddingProjection)
+	}
+	handler.SetCapabilities(&pb.GetCapabilitiesResponse{
+		ModelName:           modelName,
+		EmbeddingDim:        int32(indexDim),
+		SimilarityThreshold: cfg.SimilarityThreshold,
+		HistorySize:         int32(cfg.HistorySize),
+		Features:            features,
+	})
+	handler.SetDetector(det)
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	slog.Info("embedding sidecar ready")
+
+	ctx := context.Background()
 	// Mark serving after warmup and registrations completed.
 	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 
-	waitForShutdown(grpcServer, cfg.UDSPath)
+	reload := func() {
+		start := time.Now()
+		err := reloadable.Reload(newEmbedder)
+		if err != nil {
+			telemetry.RecordModelReload(ctx, "error")
+			slog.Error("model reload failed; keeping the current model", "error", err)
+			return
+		}
+		telemetry.RecordModelReload(ctx, "ok")
+		slog.Info("model reloaded", "path", cfg.EmbeddingModelPath, "duration_ms", time.Since(start).Milliseconds())
+	}
+
+	flush := func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), cfg.StoreFlushTimeout)
+		defer cancel()
+		slog.Info("waiting for in-flight redis writes", "pending", background.Pending(), "timeout", cfg.StoreFlushTimeout)
+		if lost := det.Close(flushCtx); lost > 0 {
+			telemetry.AddStoreDropped(context.Background(), int64(lost), "shutdown_timeout")
+			slog.Warn("shutdown flush timed out; pending redis writes were lost", "lost", lost)
+		}
+	}
+
+	waitForShutdown(grpcServer, cfg.UDSPath, reload, flush)
+}
+
+// waitForIndex checks (or creates) the Redis index, retrying with backoff
+// while Redis is unreachable, until it passes or ctx is done. An index that
+// conflicts with the configuration is returned at once: retrying cannot fix
+// it.
+func waitForIndex(ctx context.Context, vectorStore *store.VectorStore) error {
+	backoff := 500 * time.Millisecond
+	for {
+		err := vectorStore.EnsureIndex(ctx)
+		if err == nil || errors.Is(err, store.ErrIndexDimMismatch) || errors.Is(err, store.ErrIndexMetricMismatch) {
+			return err
+		}
+		slog.Warn("redis index check failed; staying NOT_SERVING and retrying", "error", err, "retry_in_ms", backoff.Milliseconds())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
 }
 
-func waitForShutdown(grpcServer *grpc.Server, udsPath string) {
+// waitForShutdown reloads on SIGHUP until SIGINT or SIGTERM, then stops
+// taking requests and flushes the embeddings they queued.
+func waitForShutdown(grpcServer *grpc.Server, udsPath string, reload, flush func()) {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	<-signals
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload()
+	}
 	grpcServer.GracefulStop()
+	flush()
 	_ = removeIfExists(udsPath)
 	slog.Info("embedding sidecar shutdown complete")
 }
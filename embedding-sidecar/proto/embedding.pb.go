@@ -22,9 +22,11 @@ const (
 )
 
 type CheckLoopRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	TenantId      string                 `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
-	Prompt        string                 `protobuf:"bytes,2,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	TenantId string                 `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	Prompt   string                 `protobuf:"bytes,2,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	// Proxy request ID, added to the sidecar's spans and log lines for this check.
+	RequestId     string `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -73,6 +75,13 @@ func (x *CheckLoopRequest) GetPrompt() string {
 	return ""
 }
 
+func (x *CheckLoopRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
 type CheckLoopResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	LoopDetected  bool                   `protobuf:"varint,1,opt,name=loop_detected,json=loopDetected,proto3" json:"loop_detected,omitempty"`
@@ -133,20 +142,442 @@ func (x *CheckLoopResponse) GetSimilarPrompt() string {
 	return ""
 }
 
+type GetCapabilitiesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// API version the client speaks.
+	ApiVersion    uint32 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCapabilitiesRequest) Reset() {
+	*x = GetCapabilitiesRequest{}
+	mi := &file_embedding_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCapabilitiesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCapabilitiesRequest) ProtoMessage() {}
+
+func (x *GetCapabilitiesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_embedding_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCapabilitiesRequest.ProtoReflect.Descriptor instead.
+func (*GetCapabilitiesRequest) Descriptor() ([]byte, []int) {
+	return file_embedding_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetCapabilitiesRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+type GetCapabilitiesResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// API version the sidecar speaks, and the oldest client version it serves.
+	ApiVersion    uint32 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	MinApiVersion uint32 `protobuf:"varint,2,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`
+	ModelName     string `protobuf:"bytes,3,opt,name=model_name,json=modelName,proto3" json:"model_name,omitempty"`
+	// Dimension of stored and searched embeddings (after any projection).
+	EmbeddingDim        int32   `protobuf:"varint,4,opt,name=embedding_dim,json=embeddingDim,proto3" json:"embedding_dim,omitempty"`
+	SimilarityThreshold float64 `protobuf:"fixed64,5,opt,name=similarity_threshold,json=similarityThreshold,proto3" json:"similarity_threshold,omitempty"`
+	HistorySize         int32   `protobuf:"varint,6,opt,name=history_size,json=historySize,proto3" json:"history_size,omitempty"`
+	// Optional behaviors, e.g. "check_loop", "store_mode:sync", "hot_reload".
+	Features      []string `protobuf:"bytes,7,rep,name=features,proto3" json:"features,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCapabilitiesResponse) Reset() {
+	*x = GetCapabilitiesResponse{}
+	mi := &file_embedding_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCapabilitiesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCapabilitiesResponse) ProtoMessage() {}
+
+func (x *GetCapabilitiesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_embedding_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCapabilitiesResponse.ProtoReflect.Descriptor instead.
+func (*GetCapabilitiesResponse) Descriptor() ([]byte, []int) {
+	return file_embedding_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetCapabilitiesResponse) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetCapabilitiesResponse) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *GetCapabilitiesResponse) GetModelName() string {
+	if x != nil {
+		return x.ModelName
+	}
+	return ""
+}
+
+func (x *GetCapabilitiesResponse) GetEmbeddingDim() int32 {
+	if x != nil {
+		return x.EmbeddingDim
+	}
+	return 0
+}
+
+func (x *GetCapabilitiesResponse) GetSimilarityThreshold() float64 {
+	if x != nil {
+		return x.SimilarityThreshold
+	}
+	return 0
+}
+
+func (x *GetCapabilitiesResponse) GetHistorySize() int32 {
+	if x != nil {
+		return x.HistorySize
+	}
+	return 0
+}
+
+func (x *GetCapabilitiesResponse) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+type GetCalibrationRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tenant to report; empty reports every calibrated tenant.
+	TenantId      string `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCalibrationRequest) Reset() {
+	*x = GetCalibrationRequest{}
+	mi := &file_embedding_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCalibrationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCalibrationRequest) ProtoMessage() {}
+
+func (x *GetCalibrationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_embedding_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCalibrationRequest.ProtoReflect.Descriptor instead.
+func (*GetCalibrationRequest) Descriptor() ([]byte, []int) {
+	return file_embedding_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetCalibrationRequest) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+type TenantCalibration struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	TenantId string                 `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	// Loop checks sampled for the recommendation.
+	Samples int64 `protobuf:"varint,2,opt,name=samples,proto3" json:"samples,omitempty"`
+	// Threshold the tenant's checks use now, and the one holding the target rate.
+	CurrentThreshold     float64 `protobuf:"fixed64,3,opt,name=current_threshold,json=currentThreshold,proto3" json:"current_threshold,omitempty"`
+	RecommendedThreshold float64 `protobuf:"fixed64,4,opt,name=recommended_threshold,json=recommendedThreshold,proto3" json:"recommended_threshold,omitempty"`
+	// Share of sampled checks above the current and the recommended threshold.
+	CurrentFlagRate     float64 `protobuf:"fixed64,5,opt,name=current_flag_rate,json=currentFlagRate,proto3" json:"current_flag_rate,omitempty"`
+	RecommendedFlagRate float64 `protobuf:"fixed64,6,opt,name=recommended_flag_rate,json=recommendedFlagRate,proto3" json:"recommended_flag_rate,omitempty"`
+	// Percentiles of the sampled max similarities.
+	P50Similarity float64 `protobuf:"fixed64,7,opt,name=p50_similarity,json=p50Similarity,proto3" json:"p50_similarity,omitempty"`
+	P95Similarity float64 `protobuf:"fixed64,8,opt,name=p95_similarity,json=p95Similarity,proto3" json:"p95_similarity,omitempty"`
+	P99Similarity float64 `protobuf:"fixed64,9,opt,name=p99_similarity,json=p99Similarity,proto3" json:"p99_similarity,omitempty"`
+	// Whether the recommendation is in effect (auto-apply).
+	Applied bool `protobuf:"varint,10,opt,name=applied,proto3" json:"applied,omitempty"`
+	// Unix seconds of the calibration run.
+	CalibratedAt  int64 `protobuf:"varint,11,opt,name=calibrated_at,json=calibratedAt,proto3" json:"calibrated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TenantCalibration) Reset() {
+	*x = TenantCalibration{}
+	mi := &file_embedding_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TenantCalibration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TenantCalibration) ProtoMessage() {}
+
+func (x *TenantCalibration) ProtoReflect() protoreflect.Message {
+	mi := &file_embedding_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TenantCalibration.ProtoReflect.Descriptor instead.
+func (*TenantCalibration) Descriptor() ([]byte, []int) {
+	return file_embedding_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TenantCalibration) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+func (x *TenantCalibration) GetSamples() int64 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetCurrentThreshold() float64 {
+	if x != nil {
+		return x.CurrentThreshold
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetRecommendedThreshold() float64 {
+	if x != nil {
+		return x.RecommendedThreshold
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetCurrentFlagRate() float64 {
+	if x != nil {
+		return x.CurrentFlagRate
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetRecommendedFlagRate() float64 {
+	if x != nil {
+		return x.RecommendedFlagRate
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetP50Similarity() float64 {
+	if x != nil {
+		return x.P50Similarity
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetP95Similarity() float64 {
+	if x != nil {
+		return x.P95Similarity
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetP99Similarity() float64 {
+	if x != nil {
+		return x.P99Similarity
+	}
+	return 0
+}
+
+func (x *TenantCalibration) GetApplied() bool {
+	if x != nil {
+		return x.Applied
+	}
+	return false
+}
+
+func (x *TenantCalibration) GetCalibratedAt() int64 {
+	if x != nil {
+		return x.CalibratedAt
+	}
+	return 0
+}
+
+type GetCalibrationResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the calibration job runs and applies its recommendations.
+	Enabled                 bool                 `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	AutoApply               bool                 `protobuf:"varint,2,opt,name=auto_apply,json=autoApply,proto3" json:"auto_apply,omitempty"`
+	TargetFalsePositiveRate float64              `protobuf:"fixed64,3,opt,name=target_false_positive_rate,json=targetFalsePositiveRate,proto3" json:"target_false_positive_rate,omitempty"`
+	Tenants                 []*TenantCalibration `protobuf:"bytes,4,rep,name=tenants,proto3" json:"tenants,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *GetCalibrationResponse) Reset() {
+	*x = GetCalibrationResponse{}
+	mi := &file_embedding_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCalibrationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCalibrationResponse) ProtoMessage() {}
+
+func (x *GetCalibrationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_embedding_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCalibrationResponse.ProtoReflect.Descriptor instead.
+func (*GetCalibrationResponse) Descriptor() ([]byte, []int) {
+	return file_embedding_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetCalibrationResponse) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+func (x *GetCalibrationResponse) GetAutoApply() bool {
+	if x != nil {
+		return x.AutoApply
+	}
+	return false
+}
+
+func (x *GetCalibrationResponse) GetTargetFalsePositiveRate() float64 {
+	if x != nil {
+		return x.TargetFalsePositiveRate
+	}
+	return 0
+}
+
+func (x *GetCalibrationResponse) GetTenants() []*TenantCalibration {
+	if x != nil {
+		return x.Tenants
+	}
+	return nil
+}
+
 var File_embedding_proto protoreflect.FileDescriptor
 
 const file_embedding_proto_rawDesc = "" +
 	"\n" +
-	"\x0fembedding.proto\x12\tembedding\"G\n" +
+	"\x0fembedding.proto\x12\tembedding\"f\n" +
 	"\x10CheckLoopRequest\x12\x1b\n" +
 	"\ttenant_id\x18\x01 \x01(\tR\btenantId\x12\x16\n" +
-	"\x06prompt\x18\x02 \x01(\tR\x06prompt\"\x86\x01\n" +
+	"\x06prompt\x18\x02 \x01(\tR\x06prompt\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId\"\x86\x01\n" +
 	"\x11CheckLoopResponse\x12#\n" +
 	"\rloop_detected\x18\x01 \x01(\bR\floopDetected\x12%\n" +
 	"\x0emax_similarity\x18\x02 \x01(\x01R\rmaxSimilarity\x12%\n" +
-	"\x0esimilar_prompt\x18\x03 \x01(\tR\rsimilarPrompt2Z\n" +
+	"\x0esimilar_prompt\x18\x03 \x01(\tR\rsimilarPrompt\"9\n" +
+	"\x16GetCapabilitiesRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\"\x98\x02\n" +
+	"\x17GetCapabilitiesResponse\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\x02 \x01(\rR\rminApiVersion\x12\x1d\n" +
+	"\n" +
+	"model_name\x18\x03 \x01(\tR\tmodelName\x12#\n" +
+	"\rembedding_dim\x18\x04 \x01(\x05R\fembeddingDim\x121\n" +
+	"\x14similarity_threshold\x18\x05 \x01(\x01R\x13similarityThreshold\x12!\n" +
+	"\fhistory_size\x18\x06 \x01(\x05R\vhistorySize\x12\x1a\n" +
+	"\bfeatures\x18\a \x03(\tR\bfeatures\"4\n" +
+	"\x15GetCalibrationRequest\x12\x1b\n" +
+	"\ttenant_id\x18\x01 \x01(\tR\btenantId\"\xc0\x03\n" +
+	"\x11TenantCalibration\x12\x1b\n" +
+	"\ttenant_id\x18\x01 \x01(\tR\btenantId\x12\x18\n" +
+	"\asamples\x18\x02 \x01(\x03R\asamples\x12+\n" +
+	"\x11current_threshold\x18\x03 \x01(\x01R\x10currentThreshold\x123\n" +
+	"\x15recommended_threshold\x18\x04 \x01(\x01R\x14recommendedThreshold\x12*\n" +
+	"\x11current_flag_rate\x18\x05 \x01(\x01R\x0fcurrentFlagRate\x122\n" +
+	"\x15recommended_flag_rate\x18\x06 \x01(\x01R\x13recommendedFlagRate\x12%\n" +
+	"\x0ep50_similarity\x18\a \x01(\x01R\rp50Similarity\x12%\n" +
+	"\x0ep95_similarity\x18\b \x01(\x01R\rp95Similarity\x12%\n" +
+	"\x0ep99_similarity\x18\t \x01(\x01R\rp99Similarity\x12\x18\n" +
+	"\aapplied\x18\n" +
+	" \x01(\bR\aapplied\x12#\n" +
+	"\rcalibrated_at\x18\v \x01(\x03R\fcalibratedAt\"\xc6\x01\n" +
+	"\x16GetCalibrationResponse\x12\x18\n" +
+	"\aenabled\x18\x01 \x01(\bR\aenabled\x12\x1d\n" +
+	"\n" +
+	"auto_apply\x18\x02 \x01(\bR\tautoApply\x12;\n" +
+	"\x1atarget_false_positive_rate\x18\x03 \x01(\x01R\x17targetFalsePositiveRate\x126\n" +
+	"\atenants\x18\x04 \x03(\v2\x1c.embedding.TenantCalibrationR\atenants2\x8b\x02\n" +
 	"\x10EmbeddingService\x12F\n" +
-	"\tCheckLoop\x12\x1b.embedding.CheckLoopRequest\x1a\x1c.embedding.CheckLoopResponseB\x1fZ\x1dembedding-sidecar/proto;protob\x06proto3"
+	"\tCheckLoop\x12\x1b.embedding.CheckLoopRequest\x1a\x1c.embedding.CheckLoopResponse\x12X\n" +
+	"\x0fGetCapabilities\x12!.embedding.GetCapabilitiesRequest\x1a\".embedding.GetCapabilitiesResponse\x12U\n" +
+	"\x0eGetCalibration\x12 .embedding.GetCalibrationRequest\x1a!.embedding.GetCalibrationResponseB\x1fZ\x1dembedding-sidecar/proto;protob\x06proto3"
 
 var (
 	file_embedding_proto_rawDescOnce sync.Once
@@ -160,19 +591,29 @@ func file_embedding_proto_rawDescGZIP() []byte {
 	return file_embedding_proto_rawDescData
 }
 
-var file_embedding_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_embedding_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_embedding_proto_goTypes = []any{
-	(*CheckLoopRequest)(nil),  // 0: embedding.CheckLoopRequest
-	(*CheckLoopResponse)(nil), // 1: embedding.CheckLoopResponse
+	(*CheckLoopRequest)(nil),        // 0: embedding.CheckLoopRequest
+	(*CheckLoopResponse)(nil),       // 1: embedding.CheckLoopResponse
+	(*GetCapabilitiesRequest)(nil),  // 2: embedding.GetCapabilitiesRequest
+	(*GetCapabilitiesResponse)(nil), // 3: embedding.GetCapabilitiesResponse
+	(*GetCalibrationRequest)(nil),   // 4: embedding.GetCalibrationRequest
+	(*TenantCalibration)(nil),       // 5: embedding.TenantCalibration
+	(*GetCalibrationResponse)(nil),  // 6: embedding.GetCalibrationResponse
 }
 var file_embedding_proto_depIdxs = []int32{
-	0, // 0: embedding.EmbeddingService.CheckLoop:input_type -> embedding.CheckLoopRequest
-	1, // 1: embedding.EmbeddingService.CheckLoop:output_type -> embedding.CheckLoopResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	5, // 0: embedding.GetCalibrationResponse.tenants:type_name -> embedding.TenantCalibration
+	0, // 1: embedding.EmbeddingService.CheckLoop:input_type -> embedding.CheckLoopRequest
+	2, // 2: embedding.EmbeddingService.GetCapabilities:input_type -> embedding.GetCapabilitiesRequest
+	4, // 3: embedding.EmbeddingService.GetCalibration:input_type -> embedding.GetCalibrationRequest
+	1, // 4: embedding.EmbeddingService.CheckLoop:output_type -> embedding.CheckLoopResponse
+	3, // 5: embedding.EmbeddingService.GetCapabilities:output_type -> embedding.GetCapabilitiesResponse
+	6, // 6: embedding.EmbeddingService.GetCalibration:output_type -> embedding.GetCalibrationResponse
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_embedding_proto_init() }
@@ -186,7 +627,7 @@ func file_embedding_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_embedding_proto_rawDesc), len(file_embedding_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,7 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	EmbeddingService_CheckLoop_FullMethodName = "/embedding.EmbeddingService/CheckLoop"
+	EmbeddingService_CheckLoop_FullMethodName       = "/embedding.EmbeddingService/CheckLoop"
+	EmbeddingService_GetCapabilities_FullMethodName = "/embedding.EmbeddingService/GetCapabilities"
+	EmbeddingService_GetCalibration_FullMethodName  = "/embedding.EmbeddingService/GetCalibration"
 )
 
 // EmbeddingServiceClient is the client API for EmbeddingService service.
@@ -27,6 +29,10 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type EmbeddingServiceClient interface {
 	CheckLoop(ctx context.Context, in *CheckLoopRequest, opts ...grpc.CallOption) (*CheckLoopResponse, error)
+	// GetCapabilities describes the sidecar so clients can verify compatibility.
+	GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error)
+	// GetCalibration reports the similarity threshold calibration per tenant.
+	GetCalibration(ctx context.Context, in *GetCalibrationRequest, opts ...grpc.CallOption) (*GetCalibrationResponse, error)
 }
 
 type embeddingServiceClient struct {
@@ -47,11 +53,35 @@ func (c *embeddingServiceClient) CheckLoop(ctx context.Context, in *CheckLoopReq
 	return out, nil
 }
 
+func (c *embeddingServiceClient) GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*GetCapabilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetCapabilitiesResponse)
+	err := c.cc.Invoke(ctx, EmbeddingService_GetCapabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *embeddingServiceClient) GetCalibration(ctx context.Context, in *GetCalibrationRequest, opts ...grpc.CallOption) (*GetCalibrationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetCalibrationResponse)
+	err := c.cc.Invoke(ctx, EmbeddingService_GetCalibration_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EmbeddingServiceServer is the server API for EmbeddingService service.
 // All implementations must embed UnimplementedEmbeddingServiceServer
 // for forward compatibility
 type EmbeddingServiceServer interface {
 	CheckLoop(context.Context, *CheckLoopRequest) (*CheckLoopResponse, error)
+	// GetCapabilities describes the sidecar so clients can verify compatibility.
+	GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error)
+	// GetCalibration reports the similarity threshold calibration per tenant.
+	GetCalibration(context.Context, *GetCalibrationRequest) (*GetCalibrationResponse, error)
 	mustEmbedUnimplementedEmbeddingServiceServer()
 }
 
@@ -62,6 +92,12 @@ type UnimplementedEmbeddingServiceServer struct {
 func (UnimplementedEmbeddingServiceServer) CheckLoop(context.Context, *CheckLoopRequest) (*CheckLoopResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CheckLoop not implemented")
 }
+func (UnimplementedEmbeddingServiceServer) GetCapabilities(context.Context, *GetCapabilitiesRequest) (*GetCapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCapabilities not implemented")
+}
+func (UnimplementedEmbeddingServiceServer) GetCalibration(context.Context, *GetCalibrationRequest) (*GetCalibrationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCalibration not implemented")
+}
 func (UnimplementedEmbeddingServiceServer) mustEmbedUnimplementedEmbeddingServiceServer() {}
 
 // UnsafeEmbeddingServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -93,6 +129,42 @@ func _EmbeddingService_CheckLoop_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EmbeddingService_GetCapabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmbeddingServiceServer).GetCapabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EmbeddingService_GetCapabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmbeddingServiceServer).GetCapabilities(ctx, req.(*GetCapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EmbeddingService_GetCalibration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCalibrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmbeddingServiceServer).GetCalibration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EmbeddingService_GetCalibration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmbeddingServiceServer).GetCalibration(ctx, req.(*GetCalibrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // EmbeddingService_ServiceDesc is the grpc.ServiceDesc for EmbeddingService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -104,6 +176,14 @@ var EmbeddingService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CheckLoop",
 			Handler:    _EmbeddingService_CheckLoop_Handler,
 		},
+		{
+			MethodName: "GetCapabilities",
+			Handler:    _EmbeddingService_GetCapabilities_Handler,
+		},
+		{
+			MethodName: "GetCalibration",
+			Handler:    _EmbeddingService_GetCalibration_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "embedding.proto",
@@ -0,0 +1,23 @@
+package proto
+
+// APIVersion is the EmbeddingService version this build speaks. Bump it when
+// a change needs both sides upgraded together; MinAPIVersion is the oldest
+// peer version still supported.
+const (
+	APIVersion    = 1
+	MinAPIVersion = 1
+)
+
+// Capability features reported by GetCapabilities.
+const (
+	FeatureCheckLoop = "check_loop"
+	FeatureHotReload = "hot_reload"
+	FeatureNormalize = "normalize"
+	// FeatureCalibration is reported when the threshold calibration job runs
+	// and GetCalibration has results to serve.
+	FeatureCalibration = "calibration"
+	// FeatureStoreModePrefix and FeatureProjectionPrefix are followed by the
+	// configured mode, e.g. "store_mode:sync".
+	FeatureStoreModePrefix  = "store_mode:"
+	FeatureProjectionPrefix = "projection:"
+)
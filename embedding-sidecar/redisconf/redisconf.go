@@ -0,0 +1,244 @@
+// Package redisconf reads Redis client settings that a URL cannot express
+// well: TLS with a private CA or client certificate, ACL credentials kept out
+// of the URL, connection pool sizing, timeouts and retry backoff, and the key
+// namespace that lets several deployments share one Redis.
+//
+// It is shared by the proxy (spend limits) and the embedding sidecar (vector
+// store).
+package redisconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Settings override the options parsed from a Redis URL. Zero values keep the
+// URL's or the client's defaults.
+type Settings struct {
+	// Namespace prefixes every key the client's users write, e.g. "staging"
+	// gives "staging:spend:{tenant}". Empty keeps the bare keys.
+	Namespace string
+
+	// Username and Password authenticate as an ACL user.
+	Username string
+	Password string
+
+	// CAFile is a PEM bundle trusted for the server certificate, on top of the
+	// system roots. CertFile and KeyFile present a client certificate.
+	// ServerName overrides the name verified against the certificate. They
+	// only apply to TLS connections (rediss:// URLs).
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxRetries is the number of retries per command; -1 disables them.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+// FromEnv reads <prefix>NAMESPACE, <prefix>USERNAME, <prefix>PASSWORD, <prefix>TLS_CA_FILE,
+// <prefix>TLS_CERT_FILE, <prefix>TLS_KEY_FILE, <prefix>TLS_SERVER_NAME,
+// <prefix>POOL_SIZE, <prefix>MIN_IDLE_CONNS, <prefix>DIAL_TIMEOUT_MS,
+// <prefix>READ_TIMEOUT_MS, <prefix>WRITE_TIMEOUT_MS, <prefix>MAX_RETRIES,
+// <prefix>MIN_RETRY_BACKOFF_MS and <prefix>MAX_RETRY_BACKOFF_MS. Each variable
+// is looked up under the prefixes in order, e.g. "EMBEDDING_REDIS_" then
+// "REDIS_". Invalid numbers are ignored.
+func FromEnv(prefixes ...string) Settings {
+	get := func(name string) string {
+		for _, prefix := range prefixes {
+			if v := os.Getenv(prefix + name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	number := func(name string) int {
+		v, _ := strconv.Atoi(get(name))
+		return v
+	}
+	millis := func(name string) time.Duration {
+		if v := number(name); v > 0 {
+			return time.Duration(v) * time.Millisecond
+		}
+		return 0
+	}
+	s := Settings{
+		Namespace:       get("NAMESPACE"),
+		Username:        get("USERNAME"),
+		Password:        get("PASSWORD"),
+		CAFile:          get("TLS_CA_FILE"),
+		CertFile:        get("TLS_CERT_FILE"),
+		KeyFile:         get("TLS_KEY_FILE"),
+		ServerName:      get("TLS_SERVER_NAME"),
+		PoolSize:        max(number("POOL_SIZE"), 0),
+		MinIdleConns:    max(number("MIN_IDLE_CONNS"), 0),
+		DialTimeout:     millis("DIAL_TIMEOUT_MS"),
+		ReadTimeout:     millis("READ_TIMEOUT_MS"),
+		WriteTimeout:    millis("WRITE_TIMEOUT_MS"),
+		MinRetryBackoff: millis("MIN_RETRY_BACKOFF_MS"),
+		MaxRetryBackoff: millis("MAX_RETRY_BACKOFF_MS"),
+	}
+	if v := number("MAX_RETRIES"); v >= -1 {
+		s.MaxRetries = v
+	}
+	return s
+}
+
+// Validate checks the namespace and that a client certificate has both halves.
+func (s Settings) Validate() error {
+	if strings.ContainsAny(s.Namespace, "*?[]{}\\ \t\r\n") {
+		return fmt.Errorf("redis namespace %q must not contain glob characters, braces or spaces", s.Namespace)
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return errors.New("redis TLS client certificate needs both a cert file and a key file")
+	}
+	if s.MinRetryBackoff > 0 && s.MaxRetryBackoff > 0 && s.MinRetryBackoff > s.MaxRetryBackoff {
+		return errors.New("redis min retry backoff exceeds max retry backoff")
+	}
+	return nil
+}
+
+// Key returns name inside the settings' namespace.
+func (s Settings) Key(name string) string {
+	return Key(s.Namespace, name)
+}
+
+// Key returns name inside namespace: "namespace:name", or name when namespace
+// is empty.
+func Key(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + ":" + name
+}
+
+// ConfigureTLS adds the CA, client certificate and server name to cfg. cfg
+// may be nil for plain connections, which are left as they are.
+func (s Settings) ConfigureTLS(cfg *tls.Config) error {
+	if cfg == nil {
+		return nil
+	}
+	if s.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return fmt.Errorf("read redis CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", s.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load redis client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if s.ServerName != "" {
+		cfg.ServerName = s.ServerName
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	return nil
+}
+
+// Apply sets the settings on single-instance options.
+func (s Settings) Apply(opts *redis.Options) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Username != "" {
+		opts.Username = s.Username
+	}
+	if s.Password != "" {
+		opts.Password = s.Password
+	}
+	setInt(&opts.PoolSize, s.PoolSize)
+	setInt(&opts.MinIdleConns, s.MinIdleConns)
+	setInt(&opts.MaxRetries, s.MaxRetries)
+	setDuration(&opts.DialTimeout, s.DialTimeout)
+	setDuration(&opts.ReadTimeout, s.ReadTimeout)
+	setDuration(&opts.WriteTimeout, s.WriteTimeout)
+	setDuration(&opts.MinRetryBackoff, s.MinRetryBackoff)
+	setDuration(&opts.MaxRetryBackoff, s.MaxRetryBackoff)
+	return s.ConfigureTLS(opts.TLSConfig)
+}
+
+// ApplyCluster sets the settings on cluster options. Pool settings apply per
+// node.
+func (s Settings) ApplyCluster(opts *redis.ClusterOptions) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Username != "" {
+		opts.Username = s.Username
+	}
+	if s.Password != "" {
+		opts.Password = s.Password
+	}
+	setInt(&opts.PoolSize, s.PoolSize)
+	setInt(&opts.MinIdleConns, s.MinIdleConns)
+	setInt(&opts.MaxRetries, s.MaxRetries)
+	setDuration(&opts.DialTimeout, s.DialTimeout)
+	setDuration(&opts.ReadTimeout, s.ReadTimeout)
+	setDuration(&opts.WriteTimeout, s.WriteTimeout)
+	setDuration(&opts.MinRetryBackoff, s.MinRetryBackoff)
+	setDuration(&opts.MaxRetryBackoff, s.MaxRetryBackoff)
+	return s.ConfigureTLS(opts.TLSConfig)
+}
+
+// ApplyFailover sets the settings on sentinel options. Credentials apply to
+// the master; sentinels keep their own.
+func (s Settings) ApplyFailover(opts *redis.FailoverOptions) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Username != "" {
+		opts.Username = s.Username
+	}
+	if s.Password != "" {
+		opts.Password = s.Password
+	}
+	setInt(&opts.PoolSize, s.PoolSize)
+	setInt(&opts.MinIdleConns, s.MinIdleConns)
+	setInt(&opts.MaxRetries, s.MaxRetries)
+	setDuration(&opts.DialTimeout, s.DialTimeout)
+	setDuration(&opts.ReadTimeout, s.ReadTimeout)
+	setDuration(&opts.WriteTimeout, s.WriteTimeout)
+	setDuration(&opts.MinRetryBackoff, s.MinRetryBackoff)
+	setDuration(&opts.MaxRetryBackoff, s.MaxRetryBackoff)
+	return s.ConfigureTLS(opts.TLSConfig)
+}
+
+func setInt(dst *int, v int) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+func setDuration(dst *time.Duration, v time.Duration) {
+	if v > 0 {
+		*dst = v
+	}
+}
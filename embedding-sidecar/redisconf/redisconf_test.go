@@ -0,0 +1,93 @@
+package redisconf
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestFromEnvFallsBackThroughPrefixes(t *testing.T) {
+	t.Setenv("EMBEDDING_REDIS_POOL_SIZE", "20")
+	t.Setenv("REDIS_POOL_SIZE", "5")
+	t.Setenv("REDIS_USERNAME", "sentinel")
+	t.Setenv("REDIS_READ_TIMEOUT_MS", "250")
+	t.Setenv("REDIS_MAX_RETRIES", "-1")
+	t.Setenv("REDIS_MIN_IDLE_CONNS", "nope")
+
+	s := FromEnv("EMBEDDING_REDIS_", "REDIS_")
+	if s.PoolSize != 20 {
+		t.Fatalf("expected prefixed pool size 20, got %d", s.PoolSize)
+	}
+	if s.Username != "sentinel" || s.ReadTimeout != 250*time.Millisecond || s.MaxRetries != -1 {
+		t.Fatalf("expected fallback values, got %+v", s)
+	}
+	if s.MinIdleConns != 0 {
+		t.Fatalf("expected invalid value ignored, got %d", s.MinIdleConns)
+	}
+}
+
+func TestApplyOverridesURLOptions(t *testing.T) {
+	opts, err := redis.ParseURL("rediss://:urlpass@localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Settings{
+		Username:     "acl",
+		Password:     "secret",
+		ServerName:   "redis.internal",
+		PoolSize:     50,
+		MinIdleConns: 4,
+		DialTimeout:  2 * time.Second,
+	}
+	if err := s.Apply(opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Username != "acl" || opts.Password != "secret" {
+		t.Fatalf("expected credentials applied, got %q/%q", opts.Username, opts.Password)
+	}
+	if opts.PoolSize != 50 || opts.MinIdleConns != 4 || opts.DialTimeout != 2*time.Second {
+		t.Fatalf("expected pool settings applied, got %+v", opts)
+	}
+	if opts.TLSConfig.ServerName != "redis.internal" || opts.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("expected TLS settings applied, got %+v", opts.TLSConfig)
+	}
+}
+
+func TestApplyKeepsDefaultsWhenUnset(t *testing.T) {
+	opts, err := redis.ParseURL("redis://:urlpass@localhost:6379/0?pool_size=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (Settings{}).Apply(opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Password != "urlpass" || opts.PoolSize != 7 || opts.TLSConfig != nil {
+		t.Fatalf("expected URL options kept, got %+v", opts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Settings{CertFile: "client.pem"}).Validate(); err == nil {
+		t.Fatal("expected error for a cert without a key")
+	}
+	if err := (Settings{MinRetryBackoff: time.Second, MaxRetryBackoff: time.Millisecond}).Validate(); err == nil {
+		t.Fatal("expected error for inverted backoff")
+	}
+	if err := (Settings{Namespace: "prod*"}).Validate(); err == nil {
+		t.Fatal("expected error for a namespace with glob characters")
+	}
+	if err := (Settings{CAFile: "/does/not/exist"}).ConfigureTLS(&tls.Config{}); err == nil {
+		t.Fatal("expected error for a missing CA file")
+	}
+}
+
+func TestKey(t *testing.T) {
+	if got := (Settings{}).Key("spend:t1"); got != "spend:t1" {
+		t.Fatalf("expected bare key, got %q", got)
+	}
+	if got := (Settings{Namespace: "staging"}).Key("spend:t1"); got != "staging:spend:t1" {
+		t.Fatalf("expected namespaced key, got %q", got)
+	}
+}
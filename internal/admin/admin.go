@@ -0,0 +1,843 @@
+// Package admin exposes an HTTP JSON API for inspecting tenant spend and recent
+// proxy decisions. It is served on a separate listener from proxy traffic.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/experiments"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/secrets"
+	"agent-sentinel/internal/slo"
+)
+
+// SpendStore is the subset of the rate limiter used by the admin API.
+type SpendStore interface {
+	ListTenants(ctx context.Context) ([]string, error)
+	GetSpend(ctx context.Context, tenantID string) (float64, error)
+	GetLimit(ctx context.Context, tenantID string) (float64, error)
+	SetLimit(ctx context.Context, tenantID string, limit float64) error
+	PurgeTenant(ctx context.Context, tenantID string) error
+	ShadowMode(ctx context.Context) (bool, error)
+	SetShadowMode(ctx context.Context, enabled bool) error
+	GetCredits(ctx context.Context, tenantID string) (float64, error)
+	TopUpCredits(ctx context.Context, tenantID string, amount float64) (float64, error)
+	PendingSettlements(ctx context.Context, limit int) ([]ratelimit.PendingSettlement, error)
+	QueryUsage(ctx context.Context, q ratelimit.UsageQuery) ([]ratelimit.UsageRecord, string, error)
+}
+
+// PricingSource is implemented by stores that know the pricing table in
+// effect; usage recomputation falls back to the built-in prices otherwise.
+type PricingSource interface {
+	PricingTable() ratelimit.ProviderPricing
+}
+
+// Snapshotter is implemented by stores that can export and restore the budget
+// state of every tenant.
+type Snapshotter interface {
+	Snapshot(ctx context.Context) (*ratelimit.Snapshot, error)
+	Restore(ctx context.Context, snap *ratelimit.Snapshot) (int, error)
+}
+
+// maxSnapshotBody bounds a snapshot uploaded for restore.
+const maxSnapshotBody = 256 << 20
+
+// CalibrationSource reports the loop detection threshold calibration; the
+// sidecar client implements it.
+type CalibrationSource interface {
+	Calibration(ctx context.Context, tenantID string) (*loopdetect.Calibration, error)
+}
+
+// TenantCredits is a tenant's prepaid credit balance.
+type TenantCredits struct {
+	TenantID string  `json:"tenant_id"`
+	Balance  float64 `json:"balance"`
+	// Display repeats the balance in the display currency, when one is configured.
+	Display *DisplayCredits `json:"display,omitempty"`
+}
+
+// DisplayCredits is a credit balance converted to Options.Currency.
+type DisplayCredits struct {
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+}
+
+// TenantSpend is the per-tenant spend summary returned by the API. Amounts
+// are USD.
+type TenantSpend struct {
+	TenantID  string  `json:"tenant_id"`
+	Spend     float64 `json:"spend"`
+	Limit     float64 `json:"limit"`
+	Remaining float64 `json:"remaining"`
+	// Overdraft is spend beyond the limit taken under the grace overdraft.
+	Overdraft float64 `json:"overdraft"`
+	// Display repeats the amounts in the display currency, when one is configured.
+	Display *DisplaySpend `json:"display,omitempty"`
+}
+
+// DisplaySpend is a spend summary converted to Options.Currency.
+type DisplaySpend struct {
+	Currency  string  `json:"currency"`
+	Spend     float64 `json:"spend"`
+	Limit     float64 `json:"limit"`
+	Remaining float64 `json:"remaining"`
+	Overdraft float64 `json:"overdraft"`
+}
+
+// Options configures the admin handler.
+type Options struct {
+	// Token, when set, is required as a bearer token on every request. Without
+	// one the mutating endpoints answer 401.
+	Token string
+	// TokenFunc, when set, returns the current token instead of Token, so a
+	// rotated token applies without a restart.
+	TokenFunc func() string
+	// ReadOnly disables the mutating endpoints (limits, purge, shadow mode).
+	ReadOnly bool
+	// Experiments, when set, serves per-variant comparisons at /admin/experiments.
+	Experiments *experiments.Registry
+	// SLO, when set, serves objective burn rates at /admin/slo.
+	SLO *slo.Tracker
+	// Health, when set, serves provider probe results at /admin/status.
+	Health *probe.Prober
+	// Currency, when set, adds converted amounts to spend and credit responses.
+	// Limits and top-ups are still given in USD.
+	Currency *ratelimit.DisplayCurrency
+	// Secrets, when set, allows rotating credentials at /admin/credentials.
+	Secrets *secrets.Store
+	// Models is the model metadata served at /admin/models; the built-in table
+	// when nil.
+	Models *ratelimit.ModelCatalog
+	// LoopAnalytics, when set, serves cross-tenant loop stats at
+	// /admin/loops/analytics.
+	LoopAnalytics *loopdetect.Analytics
+	// LoopCalibration, when set, serves the sidecar's threshold calibration at
+	// /admin/loops/calibration.
+	LoopCalibration CalibrationSource
+	// FineTune, when set, serves tenants' fine-tuning spend and allowance at
+	// /admin/tenants/{id}/fine-tuning.
+	FineTune *finetune.Accountant
+}
+
+// ModelEntry is one model listed at /admin/models: its metadata and, when the
+// pricing table has it, its prices per 1M tokens.
+type ModelEntry struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	ratelimit.ModelInfo
+	Pricing *ratelimit.Pricing `json:"pricing,omitempty"`
+}
+
+type server struct {
+	store    SpendStore
+	recorder *events.Recorder
+	opts     Options
+}
+
+// NewHandler returns the admin API handler. store may be nil when rate limiting
+// is disabled; tenant endpoints then return empty results.
+func NewHandler(store SpendStore, recorder *events.Recorder, opts Options) http.Handler {
+	if recorder == nil {
+		recorder = events.Default()
+	}
+	s := &server{store: store, recorder: recorder, opts: opts}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/tenants", s.listTenants)
+	mux.HandleFunc("GET /admin/tenants/{id}", s.getTenant)
+	mux.HandleFunc("GET /admin/events", s.listEvents)
+	mux.HandleFunc("GET /admin/events/stream", s.streamEvents)
+	mux.HandleFunc("GET /admin/latency", s.latency)
+	mux.HandleFunc("GET /admin/finish-reasons", s.finishReasons)
+	mux.HandleFunc("GET /admin/loops/analytics", s.loopAnalytics)
+	mux.HandleFunc("GET /admin/loops/calibration", s.loopCalibration)
+	mux.HandleFunc("GET /admin/shadow-mode", s.getShadowMode)
+	mux.HandleFunc("GET /admin/tenants/{id}/credits", s.getCredits)
+	mux.HandleFunc("GET /admin/tenants/{id}/fine-tuning", s.getFineTuning)
+	mux.HandleFunc("GET /admin/settlements/pending", s.pendingSettlements)
+	mux.HandleFunc("GET /admin/experiments", s.listExperiments)
+	mux.HandleFunc("GET /admin/slo", s.sloStatus)
+	mux.HandleFunc("GET /admin/status", s.providerStatus)
+	mux.HandleFunc("GET /admin/usage", s.queryUsage)
+	mux.HandleFunc("POST /admin/usage/recompute", s.recomputeUsage)
+	mux.HandleFunc("GET /admin/snapshot", s.snapshot)
+	mux.HandleFunc("GET /admin/models", s.listModels)
+	if !opts.ReadOnly {
+		mux.Handle("POST /admin/tenants/{id}/credits", s.requireToken(s.topUpCredits))
+		mux.Handle("PUT /admin/tenants/{id}/limit", s.requireToken(s.setLimit))
+		mux.Handle("DELETE /admin/tenants/{id}", s.requireToken(s.purgeTenant))
+		mux.Handle("PUT /admin/shadow-mode", s.requireToken(s.setShadowMode))
+		mux.Handle("PUT /admin/credentials/{name}", s.requireToken(s.setCredential))
+		mux.Handle("POST /admin/credentials/refresh", s.requireToken(s.refreshCredentials))
+		mux.Handle("POST /admin/snapshot/restore", s.requireToken(s.restoreSnapshot))
+	}
+	return s.authenticate(mux)
+}
+
+// token returns the configured admin token, empty when there is none.
+func (s *server) token() string {
+	if s.opts.TokenFunc != nil {
+		return s.opts.TokenFunc()
+	}
+	return s.opts.Token
+}
+
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := s.token(); token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireToken refuses a mutating endpoint while no token is configured, so an
+// admin port left open without ADMIN_TOKEN can be read but not changed.
+// authenticate has already checked the token when there is one.
+func (s *server) requireToken(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token() == "" {
+			writeError(w, http.StatusUnauthorized, "admin token required for changes; set ADMIN_TOKEN")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *server) listTenants(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"tenants": []TenantSpend{}})
+		return
+	}
+	ctx := r.Context()
+	ids, err := s.store.ListTenants(ctx)
+	if err != nil {
+		slog.Warn("admin: list tenants failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to list tenants")
+		return
+	}
+	tenants := make([]TenantSpend, 0, len(ids))
+	for _, id := range ids {
+		t, err := s.tenantSpend(ctx, id)
+		if err != nil {
+			slog.Warn("admin: tenant spend lookup failed", "error", err, "tenant_id", id)
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tenants": tenants})
+}
+
+func (s *server) getTenant(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	t, err := s.tenantSpend(r.Context(), r.PathValue("id"))
+	if err != nil {
+		slog.Warn("admin: tenant spend lookup failed", "error", err, "tenant_id", r.PathValue("id"))
+		writeError(w, http.StatusBadGateway, "failed to load tenant")
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (s *server) tenantSpend(ctx context.Context, tenantID string) (TenantSpend, error) {
+	spend, err := s.store.GetSpend(ctx, tenantID)
+	if err != nil {
+		return TenantSpend{}, err
+	}
+	limit, err := s.store.GetLimit(ctx, tenantID)
+	if err != nil {
+		return TenantSpend{}, err
+	}
+	t := TenantSpend{TenantID: tenantID, Spend: spend, Limit: limit, Remaining: max(0, limit-spend), Overdraft: max(0, spend-limit)}
+	if c := s.opts.Currency; c != nil {
+		t.Display = &DisplaySpend{
+			Currency:  c.Code,
+			Spend:     c.Convert(t.Spend),
+			Limit:     c.Convert(t.Limit),
+			Remaining: c.Convert(t.Remaining),
+			Overdraft: c.Convert(t.Overdraft),
+		}
+	}
+	return t, nil
+}
+
+func (s *server) tenantCredits(tenantID string, balance float64) TenantCredits {
+	t := TenantCredits{TenantID: tenantID, Balance: balance}
+	if c := s.opts.Currency; c != nil {
+		t.Display = &DisplayCredits{Currency: c.Code, Balance: c.Convert(balance)}
+	}
+	return t
+}
+
+func (s *server) listEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			limit = min(parsed, 1000)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"events": s.recorder.Recent(limit, r.URL.Query().Get("type")),
+	})
+}
+
+// streamEvents tails new events as Server-Sent Events until the client disconnects.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	eventType := r.URL.Query().Get("type")
+	ch, stop := s.recorder.Watch(64)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, _ = io.WriteString(w, ": keepalive\n\n")
+			flusher.Flush()
+		case ev := <-ch:
+			if eventType != "" && ev.Type != eventType {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *server) setLimit(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	var body struct {
+		Limit *float64 `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Limit == nil || *body.Limit < 0 {
+		writeError(w, http.StatusBadRequest, "body must be {\"limit\": <non-negative number>}")
+		return
+	}
+	tenantID := r.PathValue("id")
+	if err := s.store.SetLimit(r.Context(), tenantID, *body.Limit); err != nil {
+		slog.Warn("admin: set limit failed", "error", err, "tenant_id", tenantID)
+		writeError(w, http.StatusBadGateway, "failed to set limit")
+		return
+	}
+	s.audit(r, "set_limit", tenantID, map[string]any{"limit": *body.Limit})
+
+	t, err := s.tenantSpend(r.Context(), tenantID)
+	if err != nil {
+		writeJSON(w, http.StatusOK, TenantSpend{TenantID: tenantID, Limit: *body.Limit})
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (s *server) purgeTenant(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	tenantID := r.PathValue("id")
+	if err := s.store.PurgeTenant(r.Context(), tenantID); err != nil {
+		slog.Warn("admin: purge tenant failed", "error", err, "tenant_id", tenantID)
+		writeError(w, http.StatusBadGateway, "failed to purge tenant")
+		return
+	}
+	s.audit(r, "purge_tenant", tenantID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) getCredits(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	tenantID := r.PathValue("id")
+	balance, err := s.store.GetCredits(r.Context(), tenantID)
+	if err != nil {
+		slog.Warn("admin: credit lookup failed", "error", err, "tenant_id", tenantID)
+		writeError(w, http.StatusBadGateway, "failed to load credits")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.tenantCredits(tenantID, balance))
+}
+
+// getFineTuning reports a tenant's fine-tuning spend against its allowance for
+// a month (?month=YYYY-MM, the current one by default).
+func (s *server) getFineTuning(w http.ResponseWriter, r *http.Request) {
+	if s.opts.FineTune == nil {
+		writeError(w, http.StatusServiceUnavailable, "fine-tuning accounting disabled")
+		return
+	}
+	tenantID := r.PathValue("id")
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = s.opts.FineTune.Month()
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(w, http.StatusBadRequest, "month must be YYYY-MM")
+		return
+	}
+	spent, err := s.opts.FineTune.Spend(r.Context(), tenantID, month)
+	if err != nil {
+		slog.Warn("admin: fine-tuning spend lookup failed", "error", err, "tenant_id", tenantID)
+		writeError(w, http.StatusBadGateway, "failed to load fine-tuning spend")
+		return
+	}
+	allowance := s.opts.FineTune.Allowance(tenantID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"tenant_id": tenantID,
+		"month":     month,
+		"spent":     spent,
+		"allowance": allowance,
+		"remaining": max(allowance-spent, 0),
+	})
+}
+
+// pendingSettlements lists failed cost adjustments and refunds awaiting retry.
+func (s *server) pendingSettlements(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"pending": []ratelimit.PendingSettlement{}})
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			limit = min(parsed, 1000)
+		}
+	}
+	pending, err := s.store.PendingSettlements(r.Context(), limit)
+	if err != nil {
+		slog.Warn("admin: pending settlements lookup failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to load pending settlements")
+		return
+	}
+	if pending == nil {
+		pending = []ratelimit.PendingSettlement{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pending": pending})
+}
+
+// queryUsage pages through the per-request usage log, newest first. Filters:
+// tenant, model, outcome, from/to (RFC 3339), limit and cursor (next_cursor of
+// the previous page).
+func (s *server) queryUsage(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"records": []ratelimit.UsageRecord{}})
+		return
+	}
+	q, err := parseUsageQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	records, next, err := s.store.QueryUsage(r.Context(), q)
+	if err != nil {
+		slog.Warn("admin: usage query failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to query usage")
+		return
+	}
+	if records == nil {
+		records = []ratelimit.UsageRecord{}
+	}
+	resp := map[string]any{"records": records}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseUsageQuery reads the usage log filters shared by queryUsage and
+// recomputeUsage.
+func parseUsageQuery(r *http.Request) (ratelimit.UsageQuery, error) {
+	params := r.URL.Query()
+	q := ratelimit.UsageQuery{
+		TenantID: params.Get("tenant"),
+		Model:    params.Get("model"),
+		Outcome:  params.Get("outcome"),
+		Cursor:   params.Get("cursor"),
+	}
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			q.Limit = parsed
+		}
+	}
+	return q, nil
+}
+
+// maxRecomputeRecords bounds one recompute call; larger ranges continue from
+// the returned next_cursor.
+const maxRecomputeRecords = 100000
+
+// recomputeUsage re-prices usage log records with the current pricing table,
+// optionally corrected by {"pricing": {provider: {model: {...}}}} in the body,
+// and reports recorded vs recomputed cost. Takes the queryUsage filters; limit
+// is ignored. Recorded costs are never rewritten.
+func (s *server) recomputeUsage(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	q, err := parseUsageQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var body struct {
+		Pricing ratelimit.ProviderPricing `json:"pricing"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "body must be {\"pricing\": {provider: {model: {input_price, output_price}}}}")
+		return
+	}
+	pricing := ratelimit.GetPricing()
+	if table, ok := s.store.(PricingSource); ok {
+		pricing = table.PricingTable()
+	}
+	pricing = ratelimit.MergePricing(pricing, body.Pricing)
+
+	report := ratelimit.NewUsageRecomputation(pricing)
+	q.Limit = 1000
+	for scanned := 0; ; {
+		records, next, err := s.store.QueryUsage(r.Context(), q)
+		if err != nil {
+			slog.Warn("admin: usage recompute failed", "error", err)
+			writeError(w, http.StatusBadGateway, "failed to query usage")
+			return
+		}
+		report.Add(pricing, records)
+		scanned += len(records)
+		if next == "" {
+			break
+		}
+		if scanned >= maxRecomputeRecords {
+			report.NextCursor = next
+			break
+		}
+		q.Cursor = next
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// snapshot exports the budget state of every tenant for backup or for moving
+// to another Redis.
+func (s *server) snapshot(w http.ResponseWriter, r *http.Request) {
+	snapshotter, ok := s.store.(Snapshotter)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "snapshots need the Redis spend store")
+		return
+	}
+	snap, err := snapshotter.Snapshot(r.Context())
+	if err != nil {
+		slog.Warn("admin: snapshot failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to take snapshot")
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// restoreSnapshot replaces the budget state of the tenants in an uploaded
+// snapshot.
+func (s *server) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshotter, ok := s.store.(Snapshotter)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "snapshots need the Redis spend store")
+		return
+	}
+	var snap ratelimit.Snapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSnapshotBody)).Decode(&snap); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be a snapshot from GET /admin/snapshot")
+		return
+	}
+	if err := snap.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	restored, err := snapshotter.Restore(r.Context(), &snap)
+	if err != nil {
+		slog.Warn("admin: restore failed", "error", err, "restored", restored)
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("restore failed after %d tenants", restored))
+		return
+	}
+	s.audit(r, "restore_snapshot", "", map[string]any{"tenants": restored, "taken_at": snap.TakenAt})
+	writeJSON(w, http.StatusOK, map[string]any{"restored": restored, "taken_at": snap.TakenAt})
+}
+
+// listModels lists every model with metadata or pricing, optionally for one
+// ?provider=, sorted by provider and model.
+func (s *server) listModels(w http.ResponseWriter, r *http.Request) {
+	models := s.opts.Models.Table()
+	if models == nil {
+		models = ratelimit.GetModels()
+	}
+	pricing := ratelimit.GetPricing()
+	if table, ok := s.store.(PricingSource); ok {
+		pricing = table.PricingTable()
+	}
+	filter := r.URL.Query().Get("provider")
+
+	entries := []ModelEntry{}
+	seen := map[[2]string]bool{}
+	add := func(provider, model string) {
+		key := [2]string{provider, model}
+		if seen[key] || (filter != "" && provider != filter) {
+			return
+		}
+		seen[key] = true
+		entry := ModelEntry{Provider: provider, Model: model, ModelInfo: models[provider][model]}
+		if p, ok := pricing[provider][model]; ok {
+			entry.Pricing = &p
+		}
+		entries = append(entries, entry)
+	}
+	for provider, table := range models {
+		for model := range table {
+			add(provider, model)
+		}
+	}
+	for provider, table := range pricing {
+		for model := range table {
+			add(provider, model)
+		}
+	}
+	slices.SortFunc(entries, func(a, b ModelEntry) int {
+		if c := strings.Compare(a.Provider, b.Provider); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Model, b.Model)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"models": entries})
+}
+
+func (s *server) listExperiments(w http.ResponseWriter, r *http.Request) {
+	reports, err := s.opts.Experiments.Report(r.Context())
+	if err != nil {
+		slog.Warn("admin: experiment stats lookup failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to load experiment stats")
+		return
+	}
+	if reports == nil {
+		reports = []experiments.Report{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"experiments": reports})
+}
+
+func (s *server) topUpCredits(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	var body struct {
+		Amount *float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Amount == nil || *body.Amount == 0 {
+		writeError(w, http.StatusBadRequest, "body must be {\"amount\": <non-zero number>}")
+		return
+	}
+	tenantID := r.PathValue("id")
+	balance, err := s.store.TopUpCredits(r.Context(), tenantID, *body.Amount)
+	if err != nil {
+		slog.Warn("admin: credit top-up failed", "error", err, "tenant_id", tenantID)
+		writeError(w, http.StatusBadGateway, "failed to top up credits")
+		return
+	}
+	s.audit(r, "top_up_credits", tenantID, map[string]any{"amount": *body.Amount, "balance": balance})
+	writeJSON(w, http.StatusOK, s.tenantCredits(tenantID, balance))
+}
+
+func (s *server) getShadowMode(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"enabled": false})
+		return
+	}
+	enabled, err := s.store.ShadowMode(r.Context())
+	if err != nil {
+		slog.Warn("admin: shadow mode lookup failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to load shadow mode")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": enabled})
+}
+
+func (s *server) setShadowMode(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting disabled")
+		return
+	}
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "body must be {\"enabled\": true|false}")
+		return
+	}
+	if err := s.store.SetShadowMode(r.Context(), *body.Enabled); err != nil {
+		slog.Warn("admin: set shadow mode failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to set shadow mode")
+		return
+	}
+	s.audit(r, "set_shadow_mode", "", map[string]any{"enabled": *body.Enabled})
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": *body.Enabled})
+}
+
+// setCredential rotates a credential, e.g. a provider API key. Requests already
+// sent upstream finish on the old key; later ones use the new key.
+func (s *server) setCredential(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Secrets == nil {
+		writeError(w, http.StatusServiceUnavailable, "credential rotation disabled")
+		return
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == "" {
+		writeError(w, http.StatusBadRequest, "body must be {\"value\": \"...\"}")
+		return
+	}
+	name := r.PathValue("name")
+	if err := s.opts.Secrets.Set(name, body.Value); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	// Never record the value itself.
+	s.audit(r, "set_credential", "", map[string]any{"name": name})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshCredentials re-reads the secret source now, e.g. right after rotating
+// a key in Vault, instead of waiting for the next periodic refresh.
+func (s *server) refreshCredentials(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Secrets == nil {
+		writeError(w, http.StatusServiceUnavailable, "credential rotation disabled")
+		return
+	}
+	rotated, err := s.opts.Secrets.Refresh(r.Context())
+	if err != nil {
+		slog.Warn("admin: refresh credentials failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to read secret source")
+		return
+	}
+	if rotated == nil {
+		rotated = []string{}
+	}
+	s.audit(r, "refresh_credentials", "", map[string]any{"rotated": rotated})
+	writeJSON(w, http.StatusOK, map[string]any{"source": s.opts.Secrets.String(), "rotated": rotated})
+}
+
+// audit records a mutating admin call so it shows up in the event feed.
+func (s *server) audit(r *http.Request, action, tenantID string, detail map[string]any) {
+	if detail == nil {
+		detail = map[string]any{}
+	}
+	detail["action"] = action
+	detail["remote_addr"] = r.RemoteAddr
+	s.recorder.Record(events.TypeAdminAction, tenantID, detail)
+	slog.Info("admin action", "action", action, "tenant_id", tenantID, "remote_addr", r.RemoteAddr)
+}
+
+func (s *server) sloStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.opts.SLO.Status()
+	if status == nil {
+		status = []slo.Status{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"slos": status})
+}
+
+// providerStatus reports the probed health of each provider key. It answers
+// 503 while any key is down, so uptime monitors can alert on the status code.
+func (s *server) providerStatus(w http.ResponseWriter, r *http.Request) {
+	health := s.opts.Health.Status()
+	if health == nil {
+		health = []probe.Health{}
+	}
+	overall := s.opts.Health.Overall()
+	code := http.StatusOK
+	if overall == probe.StateDown {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": overall, "providers": health})
+}
+
+func (s *server) latency(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"providers": s.recorder.LatencySeries()})
+}
+
+func (s *server) finishReasons(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"tenants": s.recorder.FinishReasons()})
+}
+
+// loopAnalytics reports anonymized loop stats per model across tenants.
+func (s *server) loopAnalytics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.LoopAnalytics.Report())
+}
+
+// loopCalibration reports the per-tenant threshold calibration of the
+// embedding sidecar, optionally for one tenant (?tenant=).
+func (s *server) loopCalibration(w http.ResponseWriter, r *http.Request) {
+	if s.opts.LoopCalibration == nil {
+		writeJSON(w, http.StatusOK, &loopdetect.Calibration{Tenants: []loopdetect.TenantCalibration{}})
+		return
+	}
+	calibration, err := s.opts.LoopCalibration.Calibration(r.Context(), r.URL.Query().Get("tenant"))
+	if err != nil {
+		slog.Warn("admin: loop calibration lookup failed", "error", err)
+		writeError(w, http.StatusBadGateway, "failed to load loop calibration")
+		return
+	}
+	writeJSON(w, http.StatusOK, calibration)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"error": map[string]any{"message": message}})
+}
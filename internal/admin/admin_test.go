@@ -0,0 +1,702 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/experiments"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/secrets"
+)
+
+type fakeStore struct {
+	spend   map[string]float64
+	limit   map[string]float64
+	credits map[string]float64
+	shadow  bool
+	pending []ratelimit.PendingSettlement
+	usage   []ratelimit.UsageRecord
+	query   ratelimit.UsageQuery
+	err     error
+}
+
+func (f *fakeStore) ListTenants(ctx context.Context) ([]string, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var ids []string
+	for id := range f.spend {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeStore) GetSpend(ctx context.Context, tenantID string) (float64, error) {
+	return f.spend[tenantID], f.err
+}
+
+func (f *fakeStore) GetLimit(ctx context.Context, tenantID string) (float64, error) {
+	return f.limit[tenantID], f.err
+}
+
+func (f *fakeStore) SetLimit(ctx context.Context, tenantID string, limit float64) error {
+	if f.limit == nil {
+		f.limit = map[string]float64{}
+	}
+	f.limit[tenantID] = limit
+	return f.err
+}
+
+func (f *fakeStore) PurgeTenant(ctx context.Context, tenantID string) error {
+	delete(f.spend, tenantID)
+	delete(f.limit, tenantID)
+	return f.err
+}
+
+func (f *fakeStore) ShadowMode(ctx context.Context) (bool, error) {
+	return f.shadow, f.err
+}
+
+func (f *fakeStore) SetShadowMode(ctx context.Context, enabled bool) error {
+	f.shadow = enabled
+	return f.err
+}
+
+func (f *fakeStore) GetCredits(ctx context.Context, tenantID string) (float64, error) {
+	return f.credits[tenantID], f.err
+}
+
+func (f *fakeStore) TopUpCredits(ctx context.Context, tenantID string, amount float64) (float64, error) {
+	if f.credits == nil {
+		f.credits = map[string]float64{}
+	}
+	f.credits[tenantID] += amount
+	return f.credits[tenantID], f.err
+}
+
+func (f *fakeStore) PendingSettlements(ctx context.Context, limit int) ([]ratelimit.PendingSettlement, error) {
+	return f.pending[:min(limit, len(f.pending))], f.err
+}
+
+func (f *fakeStore) QueryUsage(ctx context.Context, q ratelimit.UsageQuery) ([]ratelimit.UsageRecord, string, error) {
+	f.query = q
+	if len(f.usage) > q.Limit {
+		return f.usage[:q.Limit], f.usage[q.Limit-1].ID, f.err
+	}
+	return f.usage, "", f.err
+}
+
+func doRequest(t *testing.T, h http.Handler, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	return doMethod(t, h, http.MethodGet, target, token, "")
+}
+
+func doMethod(t *testing.T, h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetTenant(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 7.5}, limit: map[string]float64{"acme": 10}}
+	h := NewHandler(store, events.NewRecorder(10), Options{})
+
+	rec := doRequest(t, h, "/admin/tenants/acme", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var got TenantSpend
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.TenantID != "acme" || got.Spend != 7.5 || got.Limit != 10 || got.Remaining != 2.5 || got.Overdraft != 0 {
+		t.Fatalf("unexpected tenant %+v", got)
+	}
+}
+
+func TestGetTenantReportsOverdraft(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 10.5}, limit: map[string]float64{"acme": 10}}
+	h := NewHandler(store, events.NewRecorder(10), Options{})
+
+	var got TenantSpend
+	if err := json.NewDecoder(doRequest(t, h, "/admin/tenants/acme", "").Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Remaining != 0 || got.Overdraft != 0.5 {
+		t.Fatalf("expected overdraft 0.5, got %+v", got)
+	}
+}
+
+func TestGetTenantInDisplayCurrency(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 4}, limit: map[string]float64{"acme": 10}}
+	h := NewHandler(store, events.NewRecorder(10), Options{Currency: &ratelimit.DisplayCurrency{Code: "EUR", Rate: 0.5}})
+
+	var got TenantSpend
+	if err := json.NewDecoder(doRequest(t, h, "/admin/tenants/acme", "").Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Spend != 4 || got.Display == nil || got.Display.Currency != "EUR" || got.Display.Spend != 2 || got.Display.Remaining != 3 {
+		t.Fatalf("expected USD amounts plus EUR display, got %+v (display %+v)", got, got.Display)
+	}
+}
+
+func TestListTenantsStoreError(t *testing.T) {
+	h := NewHandler(&fakeStore{err: errors.New("redis down")}, events.NewRecorder(10), Options{})
+	if rec := doRequest(t, h, "/admin/tenants", ""); rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rec.Code)
+	}
+}
+
+func TestListTenantsWithoutStore(t *testing.T) {
+	h := NewHandler(nil, events.NewRecorder(10), Options{})
+	rec := doRequest(t, h, "/admin/tenants", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body struct {
+		Tenants []TenantSpend `json:"tenants"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Tenants) != 0 {
+		t.Fatalf("expected no tenants, got %+v", body.Tenants)
+	}
+}
+
+func TestEventsFilter(t *testing.T) {
+	recorder := events.NewRecorder(10)
+	recorder.Record(events.TypeRateLimitDenied, "a", nil)
+	recorder.Record(events.TypeLoopDetected, "b", nil)
+	h := NewHandler(nil, recorder, Options{})
+
+	rec := doRequest(t, h, "/admin/events?type=loop_detected", "")
+	var body struct {
+		Events []events.Event `json:"events"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Events) != 1 || body.Events[0].TenantID != "b" {
+		t.Fatalf("unexpected events %+v", body.Events)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	h := NewHandler(nil, events.NewRecorder(10), Options{Token: "secret"})
+
+	if rec := doRequest(t, h, "/admin/events", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	if rec := doRequest(t, h, "/admin/events", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", rec.Code)
+	}
+	if rec := doRequest(t, h, "/admin/events", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with token, got %d", rec.Code)
+	}
+}
+
+func TestMutationsRequireToken(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 1}}
+	h := NewHandler(store, events.NewRecorder(10), Options{})
+
+	if rec := doMethod(t, h, http.MethodPut, "/admin/tenants/acme/limit", "", `{"limit":25}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a write without a configured token, got %d", rec.Code)
+	}
+	if rec := doMethod(t, h, http.MethodDelete, "/admin/tenants/acme", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a purge without a configured token, got %d", rec.Code)
+	}
+	if _, ok := store.limit["acme"]; ok || store.spend["acme"] != 1 {
+		t.Fatalf("expected the tenant unchanged, got limit %v spend %v", store.limit, store.spend)
+	}
+	if rec := doRequest(t, h, "/admin/tenants/acme", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected reads to stay open, got %d", rec.Code)
+	}
+}
+
+func TestRotateCredentials(t *testing.T) {
+	store := secrets.NewStore(nil, 0)
+	recorder := events.NewRecorder(10)
+	h := NewHandler(nil, recorder, Options{Secrets: store, TokenFunc: func() string { return store.Get("ADMIN_TOKEN") }})
+
+	// Credentials cannot be set before an admin token is configured.
+	if rec := doMethod(t, h, http.MethodPut, "/admin/credentials/ADMIN_TOKEN", "", `{"value":"admin-token"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a configured token, got %d", rec.Code)
+	}
+	if rec := doMethod(t, h, http.MethodPost, "/admin/credentials/refresh", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 refreshing without a configured token, got %d", rec.Code)
+	}
+	if store.Get("ADMIN_TOKEN") != "" {
+		t.Fatalf("expected the admin token to stay unset")
+	}
+	if err := store.Set("ADMIN_TOKEN", "admin-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated string
+	store.Watch("OPENAI_API_KEY", func(key string) { rotated = key })
+	if rec := doMethod(t, h, http.MethodPut, "/admin/credentials/OPENAI_API_KEY", "admin-token", `{"value":"sk-rotated-key"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if rotated != "sk-rotated-key" {
+		t.Fatalf("expected the provider key to rotate, got %q", rotated)
+	}
+	if rec := doMethod(t, h, http.MethodPut, "/admin/credentials/DATABASE_URL", "admin-token", `{"value":"x"}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown credential, got %d", rec.Code)
+	}
+	audit := recorder.Recent(10, events.TypeAdminAction)
+	if len(audit) != 1 || audit[0].Detail["name"] != "OPENAI_API_KEY" || strings.Contains(fmt.Sprint(audit[0].Detail), "sk-rotated-key") {
+		t.Fatalf("expected an audit event without the value, got %+v", audit)
+	}
+
+	// A rotated admin token applies to the next request.
+	if rec := doMethod(t, h, http.MethodPut, "/admin/credentials/ADMIN_TOKEN", "admin-token", `{"value":"new-admin-token"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if rec := doRequest(t, h, "/admin/events", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the new token to be required, got %d", rec.Code)
+	}
+	if rec := doRequest(t, h, "/admin/events", "new-admin-token"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the new token, got %d", rec.Code)
+	}
+}
+
+func TestSetLimitAndAudit(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 1}}
+	recorder := events.NewRecorder(10)
+	h := NewHandler(store, recorder, Options{Token: "secret"})
+
+	if rec := doMethod(t, h, http.MethodPut, "/admin/tenants/acme/limit", "secret", `{"limit":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid body, got %d", rec.Code)
+	}
+	rec := doMethod(t, h, http.MethodPut, "/admin/tenants/acme/limit", "secret", `{"limit":25}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if store.limit["acme"] != 25 {
+		t.Fatalf("expected limit 25, got %v", store.limit["acme"])
+	}
+	audit := recorder.Recent(10, events.TypeAdminAction)
+	if len(audit) != 1 || audit[0].TenantID != "acme" || audit[0].Detail["action"] != "set_limit" {
+		t.Fatalf("expected set_limit audit event, got %+v", audit)
+	}
+}
+
+func TestPurgeTenant(t *testing.T) {
+	store := &fakeStore{spend: map[string]float64{"acme": 1}, limit: map[string]float64{"acme": 5}}
+	h := NewHandler(store, events.NewRecorder(10), Options{Token: "secret"})
+
+	if rec := doMethod(t, h, http.MethodDelete, "/admin/tenants/acme", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if _, ok := store.spend["acme"]; ok {
+		t.Fatalf("expected tenant spend to be purged")
+	}
+}
+
+func TestListExperiments(t *testing.T) {
+	registry := experiments.NewRegistry(nil)
+	registry.Set([]experiments.Experiment{{
+		Name:       "mini",
+		MatchModel: "gpt-4o",
+		Variants:   []experiments.Variant{{Name: "control", Model: "gpt-4o", Weight: 1}, {Name: "mini", Model: "gpt-4o-mini", Weight: 1}},
+	}})
+	h := NewHandler(nil, events.NewRecorder(10), Options{Experiments: registry})
+
+	rec := doRequest(t, h, "/admin/experiments", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var got struct {
+		Experiments []experiments.Report `json:"experiments"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Experiments) != 1 || len(got.Experiments[0].Variants) != 2 || got.Experiments[0].Variants[1].Model != "gpt-4o-mini" {
+		t.Fatalf("unexpected experiments %+v", got.Experiments)
+	}
+}
+
+func TestLoopAnalytics(t *testing.T) {
+	analytics := loopdetect.NewAnalytics(1, time.Minute)
+	analytics.Observe(loopdetect.LoopCheck{Tenant: "acme", Provider: "openai", Model: "gpt-4o", Similarity: 0.97, HasSimilarity: true, SimilarityLoop: true})
+	h := NewHandler(nil, events.NewRecorder(10), Options{LoopAnalytics: analytics})
+
+	rec := doRequest(t, h, "/admin/loops/analytics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "acme") {
+		t.Fatalf("analytics must not name tenants: %s", rec.Body.String())
+	}
+	var got loopdetect.AnalyticsReport
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Models) != 1 || got.Models[0].Model != "gpt-4o" || got.Models[0].LoopRate != 1 {
+		t.Fatalf("unexpected analytics %+v", got)
+	}
+}
+
+type fakeCalibration struct {
+	tenant string
+	err    error
+}
+
+func (f *fakeCalibration) Calibration(ctx context.Context, tenantID string) (*loopdetect.Calibration, error) {
+	f.tenant = tenantID
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &loopdetect.Calibration{Enabled: true, Tenants: []loopdetect.TenantCalibration{{TenantID: tenantID, RecommendedThreshold: 0.97}}}, nil
+}
+
+func TestLoopCalibration(t *testing.T) {
+	source := &fakeCalibration{}
+	h := NewHandler(nil, events.NewRecorder(10), Options{LoopCalibration: source})
+	rec := doRequest(t, h, "/admin/loops/calibration?tenant=acme", "")
+	if rec.Code != http.StatusOK || source.tenant != "acme" {
+		t.Fatalf("expected 200 for tenant acme, got %d for %q", rec.Code, source.tenant)
+	}
+	var got loopdetect.Calibration
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !got.Enabled || len(got.Tenants) != 1 || got.Tenants[0].RecommendedThreshold != 0.97 {
+		t.Fatalf("unexpected calibration %+v", got)
+	}
+
+	source.err = errors.New("sidecar down")
+	if rec := doRequest(t, h, "/admin/loops/calibration", ""); rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 when the sidecar fails, got %d", rec.Code)
+	}
+	if rec := doRequest(t, NewHandler(nil, events.NewRecorder(10), Options{}), "/admin/loops/calibration", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"enabled":false`) {
+		t.Fatalf("expected calibration disabled without a sidecar, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFineTuning(t *testing.T) {
+	t.Setenv("FINE_TUNE_BUDGETS", "acme=100")
+	store := finetune.NewMemoryStore()
+	p, _ := openai.New("key")
+	accountant := finetune.New(finetune.NewAPI(p, nil), p, store)
+	_ = store.AdjustFineTune(context.Background(), "acme", "2026-09", 30)
+	h := NewHandler(nil, events.NewRecorder(10), Options{FineTune: accountant})
+
+	rec := doRequest(t, h, "/admin/tenants/acme/fine-tuning?month=2026-09", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var got map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got["spent"] != 30.0 || got["allowance"] != 100.0 || got["remaining"] != 70.0 {
+		t.Fatalf("unexpected fine-tuning budget %v", got)
+	}
+	if rec := doRequest(t, h, "/admin/tenants/acme/fine-tuning?month=sept", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed month, got %d", rec.Code)
+	}
+	if rec := doRequest(t, NewHandler(nil, events.NewRecorder(10), Options{}), "/admin/tenants/acme/fine-tuning", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without fine-tuning accounting, got %d", rec.Code)
+	}
+}
+
+func TestShadowModeToggle(t *testing.T) {
+	store := &fakeStore{}
+	h := NewHandler(store, events.NewRecorder(10), Options{Token: "secret"})
+
+	if rec := doMethod(t, h, http.MethodPut, "/admin/shadow-mode", "secret", `{"enabled":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	rec := doRequest(t, h, "/admin/shadow-mode", "secret")
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !body.Enabled || !store.shadow {
+		t.Fatalf("expected shadow mode enabled")
+	}
+}
+
+func TestReadOnlyRejectsMutations(t *testing.T) {
+	store := &fakeStore{}
+	h := NewHandler(store, events.NewRecorder(10), Options{ReadOnly: true})
+
+	if rec := doMethod(t, h, http.MethodPut, "/admin/shadow-mode", "", `{"enabled":true}`); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 in read-only mode, got %d", rec.Code)
+	}
+	if store.shadow {
+		t.Fatalf("shadow mode should not change in read-only mode")
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	recorder := events.NewRecorder(10)
+	server := httptest.NewServer(NewHandler(nil, recorder, Options{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/admin/events/stream?type=loop_detected")
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	recorder.Record(events.TypeRateLimitDenied, "skipped", nil)
+	recorder.Record(events.TypeLoopDetected, "acme", nil)
+
+	lines := make(chan string)
+	go func() {
+		buf := make([]byte, 4096)
+		n, _ := resp.Body.Read(buf)
+		lines <- string(buf[:n])
+	}()
+	select {
+	case got := <-lines:
+		if !strings.HasPrefix(got, "data: ") || !strings.Contains(got, `"tenant_id":"acme"`) {
+			t.Fatalf("unexpected stream data %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for streamed event")
+	}
+}
+
+func TestTopUpCredits(t *testing.T) {
+	store := &fakeStore{credits: map[string]float64{"acme": 5}}
+	recorder := events.NewRecorder(10)
+	h := NewHandler(store, recorder, Options{Token: "secret"})
+
+	if rec := doMethod(t, h, http.MethodPost, "/admin/tenants/acme/credits", "secret", `{"amount":0}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for zero amount, got %d", rec.Code)
+	}
+	rec := doMethod(t, h, http.MethodPost, "/admin/tenants/acme/credits", "secret", `{"amount":20}`)
+	var got TenantCredits
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Balance != 25 {
+		t.Fatalf("expected balance 25, got %+v", got)
+	}
+	if audit := recorder.Recent(1, events.TypeAdminAction); len(audit) != 1 || audit[0].Detail["action"] != "top_up_credits" {
+		t.Fatalf("expected top-up audit event, got %+v", audit)
+	}
+
+	rec = doRequest(t, h, "/admin/tenants/acme/credits", "secret")
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Balance != 25 {
+		t.Fatalf("expected balance 25 from GET, got %+v (%v)", got, err)
+	}
+}
+
+func TestPendingSettlements(t *testing.T) {
+	store := &fakeStore{pending: []ratelimit.PendingSettlement{
+		{Op: ratelimit.OpAdjustCost, TenantID: "acme", Estimate: 1, Actual: 2, Attempts: 1},
+		{Op: ratelimit.OpRefundEstimate, TenantID: "beta", Estimate: 3, Attempts: 2},
+	}}
+	h := NewHandler(store, events.NewRecorder(10), Options{ReadOnly: true})
+
+	rec := doRequest(t, h, "/admin/settlements/pending?limit=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body struct {
+		Pending []ratelimit.PendingSettlement `json:"pending"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Pending) != 1 || body.Pending[0].TenantID != "acme" || body.Pending[0].Actual != 2 {
+		t.Fatalf("unexpected pending settlements %+v", body.Pending)
+	}
+}
+
+func TestQueryUsage(t *testing.T) {
+	store := &fakeStore{usage: []ratelimit.UsageRecord{
+		{ID: "1700000002000-0", TenantID: "acme", Model: "gpt-4o", Outcome: ratelimit.UsageOK, Cost: 0.02},
+		{ID: "1700000001000-0", TenantID: "acme", Model: "gpt-4o", Outcome: ratelimit.UsageOK, Cost: 0.01},
+	}}
+	h := NewHandler(store, events.NewRecorder(10), Options{ReadOnly: true})
+
+	rec := doRequest(t, h, "/admin/usage?tenant=acme&model=gpt-4o&outcome=ok&from=2023-11-14T00:00:00Z&limit=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body struct {
+		Records    []ratelimit.UsageRecord `json:"records"`
+		NextCursor string                  `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Records) != 1 || body.NextCursor != "1700000002000-0" {
+		t.Fatalf("unexpected page %+v", body)
+	}
+	q := store.query
+	if q.TenantID != "acme" || q.Model != "gpt-4o" || q.Outcome != "ok" || q.From.IsZero() || !q.To.IsZero() || q.Limit != 1 {
+		t.Fatalf("unexpected query %+v", q)
+	}
+
+	if rec := doRequest(t, h, "/admin/usage?from=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid time, got %d", rec.Code)
+	}
+}
+
+func TestRecomputeUsage(t *testing.T) {
+	store := &fakeStore{usage: []ratelimit.UsageRecord{
+		{ID: "1700000001000-0", TenantID: "acme", Provider: "openai", Model: "gpt-4o", Outcome: ratelimit.UsageOK, InputTokens: 1_000_000, Cost: 2.5},
+	}}
+	h := NewHandler(store, events.NewRecorder(10), Options{ReadOnly: true})
+
+	rec := doMethod(t, h, http.MethodPost, "/admin/usage/recompute?tenant=acme", "", `{"pricing": {"openai": {"gpt-4o": {"input_price": 3, "output_price": 10}}}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report ratelimit.UsageRecomputation
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Total.Recomputed != 3 || report.Total.Delta != 0.5 || report.Repriced != 1 || report.PricingVersion == "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if store.query.TenantID != "acme" {
+		t.Fatalf("expected filters to be passed through, got %+v", store.query)
+	}
+
+	if rec := doMethod(t, h, http.MethodPost, "/admin/usage/recompute", "", "{"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a malformed body, got %d", rec.Code)
+	}
+}
+
+type snapshotStore struct {
+	fakeStore
+	snap     *ratelimit.Snapshot
+	restored *ratelimit.Snapshot
+}
+
+func (s *snapshotStore) Snapshot(ctx context.Context) (*ratelimit.Snapshot, error) {
+	return s.snap, s.err
+}
+
+func (s *snapshotStore) Restore(ctx context.Context, snap *ratelimit.Snapshot) (int, error) {
+	s.restored = snap
+	return len(snap.Tenants), s.err
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	limit := 25.0
+	store := &snapshotStore{snap: &ratelimit.Snapshot{
+		Format:        ratelimit.SnapshotFormat,
+		SchemaVersion: ratelimit.SchemaVersion(),
+		Tenants:       []ratelimit.TenantSnapshot{{TenantID: "acme", SpendMicros: map[string]int64{"1704067200": 150000000}, Limit: &limit}},
+	}}
+	recorder := events.NewRecorder(10)
+	h := NewHandler(store, recorder, Options{Token: "secret"})
+
+	rec := doRequest(t, h, "/admin/snapshot", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+
+	rec = doMethod(t, h, http.MethodPost, "/admin/snapshot/restore", "secret", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if store.restored == nil || len(store.restored.Tenants) != 1 || store.restored.Tenants[0].SpendMicros["1704067200"] != 150000000 || *store.restored.Tenants[0].Limit != 25 {
+		t.Fatalf("expected the snapshot to round-trip, got %+v", store.restored)
+	}
+	audit := recorder.Recent(10, events.TypeAdminAction)
+	if len(audit) != 1 || audit[0].Detail["action"] != "restore_snapshot" {
+		t.Fatalf("expected restore_snapshot audit event, got %+v", audit)
+	}
+
+	if rec := doMethod(t, h, http.MethodPost, "/admin/snapshot/restore", "secret", `{"format": 99}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", rec.Code)
+	}
+	if rec := doRequest(t, NewHandler(&fakeStore{}, recorder, Options{}), "/admin/snapshot", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without snapshot support, got %d", rec.Code)
+	}
+}
+
+type statusTransport struct {
+	status int
+}
+
+func (t statusTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: t.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("down")), Request: r}, nil
+}
+
+func TestProviderStatus(t *testing.T) {
+	rec := doRequest(t, NewHandler(&fakeStore{}, events.NewRecorder(10), Options{}), "/admin/status", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"unknown"`) {
+		t.Fatalf("expected unknown status without probes, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	provider, _ := openai.New("sk-test")
+	prober := probe.New(probe.Config{FailureThreshold: 1}, probe.Target{Key: "OPENAI_API_KEY", Provider: provider, Transport: statusTransport{status: http.StatusBadGateway}})
+	prober.ProbeAll(context.Background())
+	rec = doRequest(t, NewHandler(&fakeStore{}, events.NewRecorder(10), Options{Health: prober}), "/admin/status", "")
+	var body struct {
+		Status    string         `json:"status"`
+		Providers []probe.Health `json:"providers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || body.Status != probe.StateDown || len(body.Providers) != 1 || body.Providers[0].LastStatus != http.StatusBadGateway {
+		t.Fatalf("expected a down provider, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestListModels(t *testing.T) {
+	models := ratelimit.NewModelCatalog()
+	models.Set(ratelimit.ProviderModels{"openai": {"gpt-custom": {ContextWindow: 32_000}}})
+	rec := doRequest(t, NewHandler(nil, events.NewRecorder(10), Options{Models: models}), "/admin/models?provider=openai", "")
+	var body struct {
+		Models []ModelEntry `json:"models"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	var custom, gpt4o *ModelEntry
+	for i, m := range body.Models {
+		if m.Provider != "openai" {
+			t.Fatalf("expected only openai models, got %s", m.Provider)
+		}
+		switch m.Model {
+		case "gpt-custom":
+			custom = &body.Models[i]
+		case "gpt-4o":
+			gpt4o = &body.Models[i]
+		}
+	}
+	if custom == nil || custom.ContextWindow != 32_000 || custom.Pricing != nil {
+		t.Fatalf("expected the configured model without pricing, got %+v", custom)
+	}
+	if gpt4o == nil || gpt4o.ContextWindow != 128_000 || gpt4o.Pricing == nil || gpt4o.Pricing.InputPrice != 2.50 {
+		t.Fatalf("expected built-in metadata and pricing, got %+v", gpt4o)
+	}
+}
@@ -0,0 +1,76 @@
+// Package affinity maps agent sessions to a preferred proxy replica with
+// rendezvous (highest-random-weight) hashing. Loop detection stores each
+// prompt's embedding asynchronously, so two rapid prompts from one agent that
+// land on different replicas can miss each other; routing a session's
+// requests to one replica avoids that. Adding or removing a replica only moves
+// the sessions it owned.
+package affinity
+
+import (
+	"hash/fnv"
+	"os"
+	"strings"
+)
+
+// Ring assigns session keys to replicas.
+type Ring struct {
+	replicas []string
+	self     string
+}
+
+// New returns a ring over replicas; self is this replica's ID.
+func New(replicas []string, self string) *Ring {
+	return &Ring{replicas: replicas, self: self}
+}
+
+// FromEnv builds a ring from AFFINITY_REPLICAS (comma-separated replica IDs,
+// e.g. StatefulSet pod names) and AFFINITY_SELF (default: the hostname).
+// Returns nil when AFFINITY_REPLICAS is unset.
+func FromEnv() *Ring {
+	var replicas []string
+	for _, r := range strings.Split(os.Getenv("AFFINITY_REPLICAS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			replicas = append(replicas, r)
+		}
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	self := os.Getenv("AFFINITY_SELF")
+	if self == "" {
+		self, _ = os.Hostname()
+	}
+	return New(replicas, self)
+}
+
+// Self is this replica's ID.
+func (r *Ring) Self() string {
+	return r.self
+}
+
+// Owner returns the replica that should serve key.
+func (r *Ring) Owner(key string) string {
+	var owner string
+	var best uint64
+	for _, replica := range r.replicas {
+		h := fnv.New64a()
+		h.Write([]byte(replica))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if w := mix(h.Sum64()); owner == "" || w > best {
+			owner, best = replica, w
+		}
+	}
+	return owner
+}
+
+// mix spreads FNV output (whose high bits vary little for similar inputs)
+// before weights are compared.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
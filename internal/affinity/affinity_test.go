@@ -0,0 +1,29 @@
+package affinity
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOwnerIsStableAndMovesOnlyRemovedSessions(t *testing.T) {
+	full := New([]string{"sentinel-0", "sentinel-1", "sentinel-2", "sentinel-3"}, "sentinel-0")
+	shrunk := New([]string{"sentinel-0", "sentinel-1", "sentinel-3"}, "sentinel-0")
+
+	counts := map[string]int{}
+	for i := range 4000 {
+		key := fmt.Sprintf("tenant-%d", i)
+		owner := full.Owner(key)
+		counts[owner]++
+		if owner != full.Owner(key) {
+			t.Fatalf("owner of %s is not stable", key)
+		}
+		if moved := shrunk.Owner(key); owner != "sentinel-2" && moved != owner {
+			t.Fatalf("%s moved from %s to %s although its owner remained", key, owner, moved)
+		}
+	}
+	for replica, n := range counts {
+		if n < 800 || n > 1200 {
+			t.Fatalf("uneven spread: %s owns %d of 4000 sessions (%v)", replica, n, counts)
+		}
+	}
+}
@@ -6,13 +6,14 @@ import (
 	"os"
 	"strconv"
 	"sync"
+
+	"embedding-sidecar/inflight"
 )
 
 var (
-	asyncSemaphore  chan struct{}
-	asyncCompletion chan struct{}
-	RunOverride     func(fn func())
-	initOnce        sync.Once
+	group       *inflight.Group
+	RunOverride func(fn func())
+	initOnce    sync.Once
 )
 
 // Init initializes bounded async execution primitives.
@@ -22,65 +23,78 @@ func Init() {
 
 func ensureInit() {
 	initOnce.Do(func() {
-		limit := 10000
-		if limitStr := os.Getenv("ASYNC_OP_LIMIT"); limitStr != "" {
-			if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
-				limit = parsed
-			}
-		}
+		limit := limitFromEnv()
 
-		asyncSemaphore = make(chan struct{}, limit)
-		asyncCompletion = make(chan struct{}, limit*2)
+		// The sidecar tracks its embedding writes with the same group type,
+		// so both binaries drain (or count as lost) the same way on shutdown.
+		group = inflight.NewGroup(limit)
 
 		slog.Info("Async operations initialized", "concurrent_limit", limit)
 	})
 }
 
-// Run executes fn with bounded concurrency and tracks completion.
+// limitFromEnv reads ASYNC_OP_LIMIT (default 10000).
+func limitFromEnv() int {
+	limit := 10000
+	if limitStr := os.Getenv("ASYNC_OP_LIMIT"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	return limit
+}
+
+// NewGroup returns a group bounded by ASYNC_OP_LIMIT, for an owner that drains
+// its own operations instead of the process-wide ones (see WithGroup).
+func NewGroup() *inflight.Group {
+	return inflight.NewGroup(limitFromEnv())
+}
+
+type groupKey struct{}
+
+// WithGroup returns a copy of ctx whose operations started with Go run in g.
+// A nil g leaves ctx unchanged.
+func WithGroup(ctx context.Context, g *inflight.Group) context.Context {
+	if g == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, groupKey{}, g)
+}
+
+// GroupOf returns the group ctx carries, or nil for the process-wide one.
+func GroupOf(ctx context.Context) *inflight.Group {
+	g, _ := ctx.Value(groupKey{}).(*inflight.Group)
+	return g
+}
+
+// Go is Run for work done on behalf of ctx: it runs in the group ctx carries
+// (see WithGroup), otherwise in the process-wide one.
+func Go(ctx context.Context, fn func()) {
+	if g := GroupOf(ctx); g != nil && RunOverride == nil {
+		g.Go(fn)
+		return
+	}
+	Run(fn)
+}
+
+// Run executes fn with bounded concurrency and tracks completion, counting
+// operations still waiting for a slot.
 func Run(fn func()) {
 	if RunOverride != nil {
 		RunOverride(fn)
 		return
 	}
 	ensureInit()
-	go func() {
-		asyncSemaphore <- struct{}{}
-
-		defer func() {
-			<-asyncSemaphore
-			select {
-			case asyncCompletion <- struct{}{}:
-			default:
-			}
-		}()
-
-		fn()
-	}()
+	group.Go(fn)
 }
 
-// Wait drains completions for inflight work or until ctx expires.
+// Wait blocks until all pending operations finish or ctx expires, and returns
+// the number still pending (0 when fully drained).
 func Wait(ctx context.Context) int {
-	ensureInit()
-	inFlight := len(asyncSemaphore)
-	if inFlight == 0 {
-		return 0
-	}
-
-	completed := 0
-	for completed < inFlight {
-		select {
-		case <-asyncCompletion:
-			completed++
-		case <-ctx.Done():
-			return inFlight - completed
-		}
-	}
-
-	return 0
+	return group.Wait(ctx)
 }
 
-// QueueDepth returns current in-flight async operations.
+// QueueDepth returns operations queued or running.
 func QueueDepth() int64 {
-	ensureInit()
-	return int64(len(asyncSemaphore))
+	return group.Pending()
 }
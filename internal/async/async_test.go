@@ -46,8 +46,33 @@ func TestWaitContextCancel(t *testing.T) {
 	Run(func() { time.Sleep(100 * time.Millisecond) })
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
 	defer cancel()
-	remaining := Wait(ctx)
-	if remaining != 0 {
+	if remaining := Wait(ctx); remaining != 1 {
+		t.Fatalf("expected 1 task still pending after deadline, got %d", remaining)
+	}
+	// Let the task finish so it does not leak into later tests.
+	if remaining := Wait(context.Background()); remaining != 0 {
+		t.Fatalf("expected drain, got remaining %d", remaining)
+	}
+}
+
+func TestWaitIncludesWorkStartedWhileDraining(t *testing.T) {
+	Init()
+	finished := make(chan struct{})
+	Run(func() {
+		time.Sleep(10 * time.Millisecond)
+		Run(func() {
+			time.Sleep(10 * time.Millisecond)
+			close(finished)
+		})
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if remaining := Wait(ctx); remaining != 0 {
 		t.Fatalf("expected all tasks complete, got remaining %d", remaining)
 	}
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("Wait returned before follow-up work finished")
+	}
 }
@@ -0,0 +1,209 @@
+package batches
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+)
+
+// maxLineBytes bounds one line of a batch input or results file.
+const maxLineBytes = 16 << 20
+
+// NewAPI returns the batch API of provider, using its credentials, or nil when
+// the provider has none that is supported.
+func NewAPI(provider providers.Provider, client *http.Client) API {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := upstream{provider: provider, client: client}
+	switch provider.Name() {
+	case "openai":
+		return &OpenAI{upstream: c}
+	case "anthropic":
+		return &Anthropic{upstream: c}
+	}
+	return nil
+}
+
+// OpenAI reads jobs of the OpenAI Batch API. A batch's requests are uploaded
+// beforehand as a JSONL file, as are its results.
+// https://platform.openai.com/docs/api-reference/batch
+type OpenAI struct {
+	upstream
+}
+
+func (o *OpenAI) IsCreate(path string) bool {
+	return strings.TrimSuffix(path, "/") == "/v1/batches"
+}
+
+func (o *OpenAI) Requests(ctx context.Context, body map[string]any) ([]map[string]any, error) {
+	fileID, _ := body["input_file_id"].(string)
+	if fileID == "" {
+		return nil, errors.New("batch has no input_file_id")
+	}
+	var requests []map[string]any
+	err := o.lines(ctx, "/v1/files/"+url.PathEscape(fileID)+"/content", func(line map[string]any) {
+		if req, ok := line["body"].(map[string]any); ok {
+			requests = append(requests, req)
+		}
+	})
+	return requests, err
+}
+
+func (o *OpenAI) Status(ctx context.Context, id string) (Status, error) {
+	var batch struct {
+		Status       string `json:"status"`
+		OutputFileID string `json:"output_file_id"`
+	}
+	if err := o.getJSON(ctx, "/v1/batches/"+url.PathEscape(id), &batch); err != nil {
+		return Status{}, err
+	}
+	status := Status{State: batch.Status}
+	switch batch.Status {
+	case "completed", "failed", "expired", "cancelled":
+		status.Done = true
+		// Expired and cancelled batches keep the results of the requests
+		// that finished, and those are billed.
+		if batch.OutputFileID != "" {
+			status.ResultsPath = "/v1/files/" + url.PathEscape(batch.OutputFileID) + "/content"
+		}
+	}
+	return status, nil
+}
+
+func (o *OpenAI) Results(ctx context.Context, status Status) ([]map[string]any, error) {
+	var results []map[string]any
+	err := o.lines(ctx, status.ResultsPath, func(line map[string]any) {
+		if resp, ok := line["response"].(map[string]any); ok {
+			if body, ok := resp["body"].(map[string]any); ok {
+				results = append(results, body)
+			}
+		}
+	})
+	return results, err
+}
+
+// Anthropic reads jobs of Anthropic's Message Batches API. A batch's requests
+// are inline in the request creating it; its results are served as JSONL.
+// https://docs.anthropic.com/en/api/creating-message-batches
+type Anthropic struct {
+	upstream
+}
+
+func (a *Anthropic) IsCreate(path string) bool {
+	return strings.TrimSuffix(path, "/") == "/v1/messages/batches"
+}
+
+func (a *Anthropic) Requests(ctx context.Context, body map[string]any) ([]map[string]any, error) {
+	items, _ := body["requests"].([]any)
+	requests := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			if params, ok := m["params"].(map[string]any); ok {
+				requests = append(requests, params)
+			}
+		}
+	}
+	return requests, nil
+}
+
+func (a *Anthropic) Status(ctx context.Context, id string) (Status, error) {
+	var batch struct {
+		ProcessingStatus string `json:"processing_status"`
+		ResultsURL       string `json:"results_url"`
+	}
+	if err := a.getJSON(ctx, "/v1/messages/batches/"+url.PathEscape(id), &batch); err != nil {
+		return Status{}, err
+	}
+	status := Status{State: batch.ProcessingStatus, Done: batch.ProcessingStatus == "ended"}
+	if status.Done && batch.ResultsURL != "" {
+		// Only the path is kept, so credentials are never sent elsewhere.
+		u, err := url.Parse(batch.ResultsURL)
+		if err != nil {
+			return Status{}, fmt.Errorf("parse results_url: %w", err)
+		}
+		status.ResultsPath = u.RequestURI()
+	}
+	return status, nil
+}
+
+func (a *Anthropic) Results(ctx context.Context, status Status) ([]map[string]any, error) {
+	var results []map[string]any
+	err := a.lines(ctx, status.ResultsPath, func(line map[string]any) {
+		result, _ := line["result"].(map[string]any)
+		if result["type"] != "succeeded" {
+			// Errored, cancelled and expired requests are not billed.
+			return
+		}
+		if message, ok := result["message"].(map[string]any); ok {
+			results = append(results, message)
+		}
+	})
+	return results, err
+}
+
+// upstream sends authenticated GET requests to a provider.
+type upstream struct {
+	provider providers.Provider
+	client   *http.Client
+}
+
+func (u upstream) get(ctx context.Context, path string) (*http.Response, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	target := *u.provider.BaseURL()
+	target.Path, target.RawQuery = ref.Path, ref.RawQuery
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	u.provider.PrepareRequest(req)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s returned HTTP %d: %s", ref.Path, resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+func (u upstream) getJSON(ctx context.Context, path string, out any) error {
+	resp, err := u.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// lines calls fn with every JSON object line of the JSONL file at path.
+// Lines that are not JSON objects are skipped.
+func (u upstream) lines(ctx context.Context, path string, fn func(map[string]any)) error {
+	resp, err := u.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err == nil {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,334 @@
+// Package batches accounts for provider batch jobs (OpenAI's Batch API,
+// Anthropic's Message Batches). A batch's results arrive hours later through a
+// file or a results URL, never in the response the proxy relays, so per-request
+// settlement never happens. Instead the batch's estimate is charged when it is
+// submitted, the job is tracked, and a poller settles the actual cost, at batch
+// prices, once the batch has ended.
+package batches
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// defaultMaxAge is how long a job is polled before it is given up on. Batches
+// end within their 24 hour completion window; the rest is slack for results
+// that are slow to appear.
+const defaultMaxAge = 72 * time.Hour
+
+// Job is a provider batch job whose estimate was charged at submission and
+// whose actual cost is settled once its results are available.
+type Job struct {
+	ID        string    `json:"id"`
+	Provider  string    `json:"provider"`
+	TenantID  string    `json:"tenant_id"`
+	Model     string    `json:"model,omitempty"`
+	Requests  int       `json:"requests"`
+	Estimate  float64   `json:"estimate"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// Store keeps batch jobs until they are settled. RedisStore shares them
+// between proxy instances; MemoryStore serves the other limiter backends.
+type Store interface {
+	TrackBatch(ctx context.Context, job Job) error
+	PendingBatches(ctx context.Context) ([]Job, error)
+	// RemoveBatch reports whether this call removed the job, so only one
+	// instance settles it.
+	RemoveBatch(ctx context.Context, id string) (bool, error)
+}
+
+// MemoryStore is a Store for a single proxy instance; jobs are lost on
+// restart, leaving their estimate charged.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+// TrackBatch records job.
+func (s *MemoryStore) TrackBatch(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// PendingBatches returns the tracked jobs.
+func (s *MemoryStore) PendingBatches(ctx context.Context) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RemoveBatch stops tracking the job with id.
+func (s *MemoryStore) RemoveBatch(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[id]
+	delete(s.jobs, id)
+	return ok, nil
+}
+
+// Status is a batch job's processing state.
+type Status struct {
+	// State is the provider's status, e.g. "in_progress" or "completed".
+	State string
+	// Done is true once the batch has ended, however it ended.
+	Done bool
+	// ResultsPath locates the results of an ended batch; empty when it
+	// produced none (e.g. it failed validation).
+	ResultsPath string
+}
+
+// API reads a provider's batch jobs.
+type API interface {
+	// IsCreate reports whether a POST to path submits a batch.
+	IsCreate(path string) bool
+	// Requests returns the request bodies of the batch submitted with body.
+	Requests(ctx context.Context, body map[string]any) ([]map[string]any, error)
+	// Status returns the processing state of the batch with id.
+	Status(ctx context.Context, id string) (Status, error)
+	// Results returns the response body of every request of an ended batch
+	// that was billed.
+	Results(ctx context.Context, status Status) ([]map[string]any, error)
+}
+
+// UsageRecorder is implemented by limiters that keep a per-request usage log.
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, rec ratelimit.UsageRecord)
+}
+
+// Settlement is a batch job settled once it ended.
+type Settlement struct {
+	Job Job
+	// State is the job's final provider state, e.g. "completed".
+	State        string
+	Results      int
+	InputTokens  int
+	OutputTokens int
+	// Actual is the job's cost at batch prices.
+	Actual float64
+}
+
+// Accountant charges batch jobs' estimates on submission and settles their
+// actual cost once they end.
+type Accountant struct {
+	api      API
+	provider providers.Provider
+	store    Store
+	limiter  ratelimit.Limiter
+	maxAge   time.Duration
+	now      func() time.Time
+
+	// OnSettle, when set before Run, is called with each job this instance
+	// settles.
+	OnSettle func(ctx context.Context, s Settlement)
+}
+
+// New returns an Accountant for provider's batches, tracked in store and
+// charged to limiter.
+func New(api API, provider providers.Provider, store Store, limiter ratelimit.Limiter) *Accountant {
+	return &Accountant{api: api, provider: provider, store: store, limiter: limiter, maxAge: defaultMaxAge, now: time.Now}
+}
+
+// FromEnv returns an Accountant for the proxied provider's batch API, unless
+// BATCH_ACCOUNTING is false or the provider has none. transport reaches the
+// provider and may be nil.
+func FromEnv(provider providers.Provider, transport http.RoundTripper, store Store, limiter ratelimit.Limiter) *Accountant {
+	if enabled, err := strconv.ParseBool(os.Getenv("BATCH_ACCOUNTING")); err == nil && !enabled {
+		return nil
+	}
+	api := NewAPI(provider, &http.Client{Timeout: 2 * time.Minute, Transport: transport})
+	if api == nil || store == nil || limiter == nil {
+		return nil
+	}
+	return New(api, provider, store, limiter)
+}
+
+// IsCreate reports whether a POST to path submits a batch.
+func (a *Accountant) IsCreate(path string) bool {
+	return a != nil && a.api.IsCreate(path)
+}
+
+// Requests returns the request bodies of the batch submitted with body.
+func (a *Accountant) Requests(ctx context.Context, body map[string]any) ([]map[string]any, error) {
+	return a.api.Requests(ctx, body)
+}
+
+// Track records a submitted batch job whose estimate was charged.
+func (a *Accountant) Track(ctx context.Context, job Job) error {
+	return a.store.TrackBatch(ctx, job)
+}
+
+// Settle checks every tracked job of the provider and settles the ones that
+// have ended: the difference between the actual cost and the charged estimate
+// is charged (or refunded) to the tenant. Jobs still unsettled after 72 hours
+// are dropped with their estimate kept. Returns the number of jobs settled.
+func (a *Accountant) Settle(ctx context.Context) (int, error) {
+	jobs, err := a.store.PendingBatches(ctx)
+	if err != nil {
+		return 0, err
+	}
+	settled := 0
+	for _, job := range jobs {
+		if job.Provider != a.provider.Name() {
+			continue
+		}
+		expired := a.now().Sub(job.Submitted) > a.maxAge
+		status, err := a.api.Status(ctx, job.ID)
+		if err != nil || !status.Done {
+			if expired {
+				a.abandon(ctx, job, err)
+			} else if err != nil {
+				slog.Debug("Failed to poll batch job", "error", err, "batch_id", job.ID, "tenant_id", job.TenantID)
+			}
+			continue
+		}
+		var results []map[string]any
+		if status.ResultsPath != "" {
+			if results, err = a.api.Results(ctx, status); err != nil {
+				if expired {
+					a.abandon(ctx, job, err)
+				} else {
+					slog.Warn("Failed to read batch results", "error", err, "batch_id", job.ID, "tenant_id", job.TenantID)
+				}
+				continue
+			}
+		}
+		if a.settle(ctx, job, status, results) {
+			settled++
+		}
+	}
+	return settled, nil
+}
+
+// settle charges job's actual cost, computed from results, in place of its
+// estimate. It reports false when another instance settled the job first.
+func (a *Accountant) settle(ctx context.Context, job Job, status Status, results []map[string]any) bool {
+	var actual float64
+	var inputTokens, outputTokens int
+	for _, body := range results {
+		usage := a.provider.ParseTokenUsage(body)
+		if !usage.Found {
+			continue
+		}
+		model, _ := body["model"].(string)
+		if model == "" {
+			model = job.Model
+		}
+		pricing, found := a.limiter.GetPricing(a.provider.Name(), model)
+		if !found {
+			pricing = ratelimit.DefaultPricing(a.provider.Name())
+		}
+		actual += ratelimit.CalculateCost(usage.InputTokens, usage.OutputTokens, ratelimit.BatchPricing(pricing))
+		inputTokens += usage.InputTokens
+		outputTokens += usage.OutputTokens
+	}
+
+	claimed, err := a.store.RemoveBatch(ctx, job.ID)
+	if err != nil || !claimed {
+		if err != nil {
+			slog.Warn("Failed to claim batch job for settlement", "error", err, "batch_id", job.ID)
+		}
+		return false
+	}
+	// The estimate was settled at submission, so only the difference is
+	// charged: with no reservation and no estimate, AdjustCost charges actual
+	// outright and refunds a negative amount.
+	delta := actual - job.Estimate
+	if err := a.limiter.AdjustCost(ctx, job.TenantID, "", 0, delta); err != nil {
+		slog.Warn("Failed to settle batch job", "error", err, "batch_id", job.ID, "tenant_id", job.TenantID)
+	}
+	if recorder, ok := a.limiter.(UsageRecorder); ok {
+		outcome := ratelimit.UsageOK
+		if len(results) == 0 {
+			outcome = ratelimit.UsageError
+		}
+		recorder.RecordUsage(ctx, ratelimit.UsageRecord{
+			TenantID:     job.TenantID,
+			Provider:     job.Provider,
+			Model:        job.Model,
+			Outcome:      outcome,
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			Cost:         actual,
+			Estimate:     job.Estimate,
+		})
+	}
+	telemetry.ObserveCostDelta(ctx, job.Provider, job.Model, job.TenantID, delta, false)
+	telemetry.IncBatchJob(ctx, job.Provider, job.TenantID, "settled")
+	slog.Info("Batch job settled",
+		"batch_id", job.ID,
+		"tenant_id", job.TenantID,
+		"state", status.State,
+		"requests", job.Requests,
+		"results", len(results),
+		"estimate", job.Estimate,
+		"actual", actual,
+	)
+	if a.OnSettle != nil {
+		a.OnSettle(ctx, Settlement{
+			Job:          job,
+			State:        status.State,
+			Results:      len(results),
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			Actual:       actual,
+		})
+	}
+	return true
+}
+
+// abandon stops polling a job that never ended, or whose status or results
+// could not be read, keeping its estimate charged.
+func (a *Accountant) abandon(ctx context.Context, job Job, cause error) {
+	if claimed, err := a.store.RemoveBatch(ctx, job.ID); err != nil || !claimed {
+		return
+	}
+	telemetry.IncBatchJob(ctx, job.Provider, job.TenantID, "abandoned")
+	slog.Warn("Batch job abandoned, keeping its estimate",
+		"batch_id", job.ID,
+		"tenant_id", job.TenantID,
+		"estimate", job.Estimate,
+		"submitted", job.Submitted,
+		"error", cause,
+	)
+}
+
+// Run settles ended jobs every interval until ctx is cancelled. Safe to run on
+// every proxy instance.
+func (a *Accountant) Run(ctx context.Context, interval time.Duration) {
+	if a == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Settle(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Batch settlement failed", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package batches
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/providers/anthropic"
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/ratelimit"
+)
+
+// anthropicAt is the Anthropic provider pointed at a test server.
+type anthropicAt struct {
+	*anthropic.Provider
+	base *url.URL
+}
+
+func (p anthropicAt) BaseURL() *url.URL { return p.base }
+
+type openaiAt struct {
+	*openai.Provider
+	base *url.URL
+}
+
+func (p openaiAt) BaseURL() *url.URL { return p.base }
+
+func newServer(t *testing.T, routes map[string]string) *url.URL {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	base, _ := url.Parse(srv.URL)
+	return base
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSettleAnthropicBatch(t *testing.T) {
+	base := newServer(t, map[string]string{
+		"/v1/messages/batches/b1":         `{"id":"b1","processing_status":"ended","results_url":"https://api.anthropic.com/v1/messages/batches/b1/results"}`,
+		"/v1/messages/batches/b1/results": `{"custom_id":"a","result":{"type":"succeeded","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":1000,"output_tokens":200}}}}` + "\n" + `{"custom_id":"b","result":{"type":"succeeded","message":{"model":"claude-sonnet-4-5","usage":{"input_tokens":1000,"output_tokens":200}}}}` + "\n" + `{"custom_id":"c","result":{"type":"errored","error":{"type":"invalid_request"}}}` + "\n",
+		"/v1/messages/batches/b2":         `{"id":"b2","processing_status":"in_progress"}`,
+	})
+	p, _ := anthropic.New("key")
+	provider := anthropicAt{Provider: p, base: base}
+	limiter := ratelimit.NewMemoryLimiter()
+	limiter.SetLimit("t1", 100)
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	// Both estimates were charged at submission.
+	_ = limiter.AdjustCost(ctx, "t1", "", 0, 0.01+0.02)
+	_ = store.TrackBatch(ctx, Job{ID: "b1", Provider: "anthropic", TenantID: "t1", Model: "claude-sonnet-4-5", Requests: 3, Estimate: 0.01, Submitted: time.Now()})
+	_ = store.TrackBatch(ctx, Job{ID: "b2", Provider: "anthropic", TenantID: "t1", Estimate: 0.02, Submitted: time.Now()})
+	// Jobs of another provider are left to its proxy.
+	_ = store.TrackBatch(ctx, Job{ID: "o1", Provider: "openai", TenantID: "t1", Submitted: time.Now()})
+
+	a := New(NewAPI(provider, nil), provider, store, limiter)
+	settled, err := a.Settle(ctx)
+	if err != nil || settled != 1 {
+		t.Fatalf("Settle = %d, %v; want 1 job settled", settled, err)
+	}
+	// Two succeeded requests at half of $3/$15 per 1M tokens: 2 * (0.0015 + 0.0015).
+	if spend := limiter.GetSpend("t1"); !approx(spend, 0.006+0.02) {
+		t.Fatalf("spend = %v, want the actual cost plus the pending estimate", spend)
+	}
+	pending, _ := store.PendingBatches(ctx)
+	if len(pending) != 2 {
+		t.Fatalf("expected the running and the foreign job to stay tracked, got %+v", pending)
+	}
+
+	// A job that never ends is given up on, keeping its estimate.
+	a.now = func() time.Time { return time.Now().Add(defaultMaxAge + time.Hour) }
+	if settled, err := a.Settle(ctx); err != nil || settled != 0 {
+		t.Fatalf("Settle = %d, %v; want nothing settled", settled, err)
+	}
+	if _, ok := store.jobs["b2"]; ok {
+		t.Fatal("expected the expired job to be dropped")
+	}
+	if spend := limiter.GetSpend("t1"); !approx(spend, 0.026) {
+		t.Fatalf("spend = %v, want the abandoned estimate kept", spend)
+	}
+}
+
+func TestSettleFailedBatchRefundsEstimate(t *testing.T) {
+	base := newServer(t, map[string]string{
+		"/v1/batches/batch_1": `{"id":"batch_1","status":"failed"}`,
+	})
+	p, _ := openai.New("key")
+	provider := openaiAt{Provider: p, base: base}
+	limiter := ratelimit.NewMemoryLimiter()
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	_ = limiter.AdjustCost(ctx, "t1", "", 0, 0.05)
+	_ = store.TrackBatch(ctx, Job{ID: "batch_1", Provider: "openai", TenantID: "t1", Estimate: 0.05, Submitted: time.Now()})
+	if settled, err := New(NewAPI(provider, nil), provider, store, limiter).Settle(ctx); err != nil || settled != 1 {
+		t.Fatalf("Settle = %d, %v; want 1 job settled", settled, err)
+	}
+	if spend := limiter.GetSpend("t1"); !approx(spend, 0) {
+		t.Fatalf("spend = %v, want the estimate refunded", spend)
+	}
+}
+
+func TestOpenAIBatchFiles(t *testing.T) {
+	base := newServer(t, map[string]string{
+		"/v1/files/file-in/content":  `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}}` + "\nnot json\n",
+		"/v1/batches/batch_1":        `{"id":"batch_1","status":"expired","output_file_id":"file-out"}`,
+		"/v1/files/file-out/content": `{"id":"r1","custom_id":"1","response":{"status_code":200,"body":{"model":"gpt-4o","usage":{"prompt_tokens":10,"completion_tokens":5}}},"error":null}` + "\n",
+	})
+	p, _ := openai.New("key")
+	api := NewAPI(openaiAt{Provider: p, base: base}, nil)
+	ctx := context.Background()
+
+	if !api.IsCreate("/v1/batches") || api.IsCreate("/v1/batches/batch_1/cancel") {
+		t.Fatal("IsCreate should only match batch creation")
+	}
+	requests, err := api.Requests(ctx, map[string]any{"input_file_id": "file-in"})
+	if err != nil || len(requests) != 1 || requests[0]["model"] != "gpt-4o" {
+		t.Fatalf("Requests = %v, %v", requests, err)
+	}
+	status, err := api.Status(ctx, "batch_1")
+	if err != nil || !status.Done || status.ResultsPath != "/v1/files/file-out/content" {
+		t.Fatalf("Status = %+v, %v; want an ended batch with results", status, err)
+	}
+	results, err := api.Results(ctx, status)
+	if err != nil || len(results) != 1 || results[0]["model"] != "gpt-4o" {
+		t.Fatalf("Results = %v, %v", results, err)
+	}
+	if _, err := api.Status(ctx, "missing"); err == nil {
+		t.Fatal("expected an error for an unknown batch")
+	}
+}
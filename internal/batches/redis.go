@@ -0,0 +1,59 @@
+package batches
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"agent-sentinel/internal/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// jobsKey is a hash of batch jobs awaiting settlement, keyed by job ID.
+const jobsKey = "batch_jobs"
+
+// RedisStore is a Store in Redis, shared by every proxy instance.
+type RedisStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisStore returns a store using client.
+func NewRedisStore(client *ratelimit.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// TrackBatch records a submitted batch job until RemoveBatch settles it.
+func (s *RedisStore) TrackBatch(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.client.Client().HSet(ctx, s.client.Key(jobsKey), job.ID, data).Err()
+}
+
+// PendingBatches returns the tracked batch jobs. Entries that fail to decode
+// are skipped.
+func (s *RedisStore) PendingBatches(ctx context.Context) ([]Job, error) {
+	entries, err := s.client.Client().HGetAll(ctx, s.client.Key(jobsKey)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(entries))
+	for id, data := range entries {
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			slog.Warn("Skipping unreadable batch job", "error", err, "batch_id", id)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RemoveBatch stops tracking a batch job. It reports whether this call removed
+// it, so when several proxy instances settle the same job only one charges it.
+func (s *RedisStore) RemoveBatch(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Client().HDel(ctx, s.client.Key(jobsKey), id).Result()
+	return n > 0, err
+}
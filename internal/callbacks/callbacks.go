@@ -0,0 +1,383 @@
+// Package callbacks delivers results the proxy produces after the response
+// has gone, such as replayed deferred requests and settled batch jobs, to a
+// URL each tenant configures, so agent frameworks need not poll for them.
+// Deliveries are signed with the tenant's secret and retried with backoff
+// until they succeed or run out of attempts.
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/batches"
+	"agent-sentinel/internal/deferred"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Events delivered to callback URLs.
+const (
+	EventDeferredCompleted = "deferred.completed"
+	EventBatchSettled      = "batch.settled"
+)
+
+// Headers sent with every delivery. The signature is
+// "v1=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	HeaderEvent     = "X-Sentinel-Event"
+	HeaderDelivery  = "X-Sentinel-Delivery"
+	HeaderTimestamp = "X-Sentinel-Callback-Timestamp"
+	HeaderSignature = "X-Sentinel-Callback-Signature"
+)
+
+const (
+	defaultMaxAttempts = 8
+	defaultTimeout     = 10 * time.Second
+	// maxBackoff caps the wait between attempts, which doubles from two
+	// seconds.
+	maxBackoff = time.Hour
+	// maxDeliveriesPerTick bounds how many deliveries a replica attempts per
+	// poll.
+	maxDeliveriesPerTick = 50
+)
+
+// Endpoint is where a tenant's callbacks are delivered.
+type Endpoint struct {
+	URL string `json:"url"`
+	// Secret signs deliveries so the receiver can tell them from forgeries.
+	Secret string `json:"secret"`
+}
+
+// Delivery is a result to be POSTed to a tenant's callback URL.
+type Delivery struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+	// Event names what happened, e.g. "deferred.completed".
+	Event string `json:"event"`
+	// Payload is the JSON body delivered.
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts,omitempty"`
+	Created     time.Time       `json:"created"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Store keeps deliveries until they succeed or are given up on. RedisStore
+// shares them between proxy instances; MemoryStore serves a single one.
+type Store interface {
+	ScheduleCallback(ctx context.Context, d Delivery) error
+	// ClaimDueCallbacks takes due deliveries off the schedule, so only one
+	// instance attempts each.
+	ClaimDueCallbacks(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+	RemoveCallback(ctx context.Context, id string) error
+}
+
+// Dispatcher queues and delivers callbacks. Safe for concurrent use.
+type Dispatcher struct {
+	store       Store
+	endpoints   map[string]Endpoint
+	client      *http.Client
+	maxAttempts int
+	now         func() time.Time
+}
+
+// New returns a dispatcher delivering to endpoints, keyed by tenant, giving up
+// on a delivery after maxAttempts (default 8). Each attempt is bounded by
+// timeout (default 10s).
+func New(store Store, endpoints map[string]Endpoint, maxAttempts int, timeout time.Duration) *Dispatcher {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Dispatcher{
+		store:       store,
+		endpoints:   endpoints,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		now:         time.Now,
+	}
+}
+
+// FromEnv returns a dispatcher for the endpoints in CALLBACKS_FILE, with
+// CALLBACK_MAX_ATTEMPTS and CALLBACK_TIMEOUT_MS, or nil when no file is set.
+func FromEnv(store Store) (*Dispatcher, error) {
+	path := os.Getenv("CALLBACKS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	endpoints, err := LoadEndpoints(path)
+	if err != nil {
+		return nil, err
+	}
+	maxAttempts, _ := strconv.Atoi(os.Getenv("CALLBACK_MAX_ATTEMPTS"))
+	var timeout time.Duration
+	if v, err := strconv.Atoi(os.Getenv("CALLBACK_TIMEOUT_MS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Millisecond
+	}
+	return New(store, endpoints, maxAttempts, timeout), nil
+}
+
+// LoadEndpoints reads a JSON object mapping tenants to their endpoint:
+//
+//	{"acme": {"url": "https://agents.acme.dev/hooks/sentinel", "secret": "whsec_..."}}
+func LoadEndpoints(path string) (map[string]Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints map[string]Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for tenant, ep := range endpoints {
+		u, err := url.Parse(ep.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s: tenant %q: url must be an absolute http or https URL", path, tenant)
+		}
+		if ep.Secret == "" {
+			return nil, fmt.Errorf("%s: tenant %q has no secret", path, tenant)
+		}
+	}
+	return endpoints, nil
+}
+
+// Enabled reports whether the tenant has a callback URL.
+func (d *Dispatcher) Enabled(tenantID string) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.endpoints[tenantID]
+	return ok
+}
+
+// Notify queues event with data for delivery to the tenant's callback URL. It
+// does nothing for tenants without one.
+func (d *Dispatcher) Notify(ctx context.Context, tenantID, event string, data any) error {
+	if !d.Enabled(tenantID) {
+		return nil
+	}
+	now := d.now().UTC()
+	id := newID()
+	payload, err := json.Marshal(map[string]any{
+		"id":        id,
+		"type":      event,
+		"tenant_id": tenantID,
+		"created":   now.Unix(),
+		"data":      data,
+	})
+	if err != nil {
+		return err
+	}
+	return d.store.ScheduleCallback(ctx, Delivery{
+		ID:          id,
+		TenantID:    tenantID,
+		Event:       event,
+		Payload:     payload,
+		Created:     now,
+		NextAttempt: now,
+	})
+}
+
+// DeferredCompleted queues a deferred.completed callback carrying the request
+// as its poll URL reports it. It suits deferred.Queue.OnComplete.
+func (d *Dispatcher) DeferredCompleted(ctx context.Context, req deferred.Request) {
+	if err := d.Notify(ctx, req.TenantID, EventDeferredCompleted, deferred.Status(req)); err != nil {
+		slog.Warn("Failed to queue callback", "error", err, "event", EventDeferredCompleted, "deferred_id", req.ID)
+	}
+}
+
+// BatchSettled queues a batch.settled callback. It suits
+// batches.Accountant.OnSettle.
+func (d *Dispatcher) BatchSettled(ctx context.Context, s batches.Settlement) {
+	data := map[string]any{
+		"id":             s.Job.ID,
+		"object":         "batch",
+		"provider":       s.Job.Provider,
+		"model":          s.Job.Model,
+		"state":          s.State,
+		"requests":       s.Job.Requests,
+		"results":        s.Results,
+		"input_tokens":   s.InputTokens,
+		"output_tokens":  s.OutputTokens,
+		"estimated_cost": s.Job.Estimate,
+		"actual_cost":    s.Actual,
+		"submitted_at":   s.Job.Submitted,
+	}
+	if err := d.Notify(ctx, s.Job.TenantID, EventBatchSettled, data); err != nil {
+		slog.Warn("Failed to queue callback", "error", err, "event", EventBatchSettled, "batch_id", s.Job.ID)
+	}
+}
+
+// Run delivers due callbacks every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	if d == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Deliver(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Callback delivery failed", "error", err)
+			}
+		}
+	}
+}
+
+// Deliver attempts the callbacks that are due, concurrently, and returns how
+// many were delivered. Failed attempts are scheduled again with exponential
+// backoff until the last one.
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	due, err := d.store.ClaimDueCallbacks(ctx, d.now(), maxDeliveriesPerTick)
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+	)
+	for _, delivery := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d.attempt(ctx, delivery) {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return delivered, err
+}
+
+// attempt POSTs delivery to its tenant's callback URL and reschedules or
+// drops it.
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) bool {
+	ep, ok := d.endpoints[delivery.TenantID]
+	if !ok {
+		// The tenant's endpoint was removed after the delivery was queued.
+		d.remove(ctx, delivery)
+		return false
+	}
+	delivery.Attempts++
+	err := d.post(ctx, ep, delivery)
+	if err == nil {
+		d.remove(ctx, delivery)
+		telemetry.IncCallback(ctx, delivery.TenantID, delivery.Event, "delivered")
+		slog.Info("Delivered callback",
+			"delivery_id", delivery.ID,
+			"tenant_id", delivery.TenantID,
+			"event", delivery.Event,
+			"attempts", delivery.Attempts,
+		)
+		return true
+	}
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= d.maxAttempts {
+		d.remove(ctx, delivery)
+		telemetry.IncCallback(ctx, delivery.TenantID, delivery.Event, "failed")
+		events.Record(events.TypeCallbackFailed, delivery.TenantID, map[string]any{
+			"id":       delivery.ID,
+			"event":    delivery.Event,
+			"attempts": delivery.Attempts,
+			"error":    delivery.LastError,
+		})
+		slog.Warn("Giving up on callback",
+			"error", err,
+			"delivery_id", delivery.ID,
+			"tenant_id", delivery.TenantID,
+			"event", delivery.Event,
+			"attempts", delivery.Attempts,
+		)
+		return false
+	}
+	delivery.NextAttempt = d.now().UTC().Add(backoff(delivery.Attempts))
+	if err := d.store.ScheduleCallback(ctx, delivery); err != nil {
+		slog.Warn("Failed to reschedule callback", "error", err, "delivery_id", delivery.ID)
+	}
+	telemetry.IncCallback(ctx, delivery.TenantID, delivery.Event, "retry")
+	slog.Info("Callback attempt failed, retrying",
+		"error", err,
+		"delivery_id", delivery.ID,
+		"tenant_id", delivery.TenantID,
+		"attempts", delivery.Attempts,
+		"next_attempt", delivery.NextAttempt,
+	)
+	return false
+}
+
+func (d *Dispatcher) remove(ctx context.Context, delivery Delivery) {
+	if err := d.store.RemoveCallback(ctx, delivery.ID); err != nil {
+		slog.Warn("Failed to remove callback", "error", err, "delivery_id", delivery.ID)
+	}
+}
+
+// post sends one signed delivery; any response other than a 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, ep Endpoint, delivery Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "agent-sentinel-callbacks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign([]byte(ep.Secret), timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback URL answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body sent at timestamp (Unix
+// seconds), for receivers to compare against HeaderSignature.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff is the wait after the given number of failed attempts: 2s, 4s, 8s
+// and so on, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	if attempts >= 12 {
+		return maxBackoff
+	}
+	return min(time.Duration(1<<attempts)*time.Second, maxBackoff)
+}
+
+// newID returns a random delivery ID.
+func newID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "cbk_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,126 @@
+package callbacks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/batches"
+	"agent-sentinel/internal/deferred"
+	"agent-sentinel/internal/events"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.received = append(rc.received, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"valid":     {body: `{"acme":{"url":"https://hooks.acme.dev/s","secret":"s1"}}`},
+		"bad url":   {body: `{"acme":{"url":"ftp://hooks.acme.dev","secret":"s1"}}`, wantErr: true},
+		"no secret": {body: `{"acme":{"url":"https://hooks.acme.dev"}}`, wantErr: true},
+	} {
+		path := filepath.Join(dir, "callbacks.json")
+		if err := os.WriteFile(path, []byte(tc.body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadEndpoints(path); (err != nil) != tc.wantErr {
+			t.Errorf("%s: LoadEndpoints error = %v, want error %v", name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	ctx := context.Background()
+	d := New(NewMemoryStore(), map[string]Endpoint{"t1": {URL: srv.URL, Secret: "s1"}}, 0, 0)
+
+	if err := d.Notify(ctx, "t2", EventBatchSettled, map[string]any{}); err != nil {
+		t.Fatalf("Notify for a tenant without a URL: %v", err)
+	}
+	d.BatchSettled(ctx, batches.Settlement{Job: batches.Job{ID: "batch_1", TenantID: "t1", Estimate: 2}, State: "completed", Actual: 1.5})
+	if n, err := d.Deliver(ctx); n != 1 || err != nil {
+		t.Fatalf("Deliver = %d, %v, want 1", n, err)
+	}
+
+	r, body := rc.received[0], rc.bodies[0]
+	if r.Header.Get(HeaderEvent) != EventBatchSettled || r.Header.Get(HeaderDelivery) == "" {
+		t.Fatalf("headers = %v", r.Header)
+	}
+	if want := Sign([]byte("s1"), r.Header.Get(HeaderTimestamp), body); r.Header.Get(HeaderSignature) != want {
+		t.Fatalf("signature = %q, want %q", r.Header.Get(HeaderSignature), want)
+	}
+	var envelope struct {
+		Type, TenantID string
+		Data           struct {
+			ID         string
+			ActualCost float64 `json:"actual_cost"`
+		}
+	}
+	_ = json.Unmarshal(body, &envelope)
+	if envelope.Type != EventBatchSettled || envelope.Data.ID != "batch_1" || envelope.Data.ActualCost != 1.5 {
+		t.Fatalf("payload = %s", body)
+	}
+	if n, _ := d.Deliver(ctx); n != 0 || len(rc.received) != 1 {
+		t.Fatal("expected a delivered callback not to be sent again")
+	}
+}
+
+func TestDeliverRetriesThenGivesUp(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	ctx := context.Background()
+	now := time.Now()
+	d := New(NewMemoryStore(), map[string]Endpoint{"t1": {URL: srv.URL, Secret: "s1"}}, 2, 0)
+	d.now = func() time.Time { return now }
+	before := len(events.Default().Recent(1000, events.TypeCallbackFailed))
+
+	d.DeferredCompleted(ctx, deferred.Request{ID: "dfr_1", TenantID: "t1", Status: deferred.StatusCompleted})
+	if n, _ := d.Deliver(ctx); n != 0 || len(rc.received) != 1 {
+		t.Fatalf("delivered %d after %d attempts", n, len(rc.received))
+	}
+	_, _ = d.Deliver(ctx)
+	if len(rc.received) != 1 {
+		t.Fatal("expected the retry to wait for its backoff")
+	}
+	now = now.Add(backoff(1))
+	rc.status = http.StatusBadGateway
+	_, _ = d.Deliver(ctx)
+	if len(rc.received) != 2 {
+		t.Fatalf("attempts = %d, want the retry sent", len(rc.received))
+	}
+	now = now.Add(maxBackoff)
+	_, _ = d.Deliver(ctx)
+	if len(rc.received) != 2 {
+		t.Fatal("expected the delivery dropped after its last attempt")
+	}
+	if got := len(events.Default().Recent(1000, events.TypeCallbackFailed)); got != before+1 {
+		t.Fatalf("callback_failed events = %d, want %d", got, before+1)
+	}
+}
@@ -0,0 +1,61 @@
+package callbacks
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store for a single proxy instance; pending deliveries are
+// lost on restart.
+type MemoryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]Delivery
+	due        map[string]time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{deliveries: map[string]Delivery{}, due: map[string]time.Time{}}
+}
+
+// ScheduleCallback stores d to be attempted at d.NextAttempt.
+func (s *MemoryStore) ScheduleCallback(ctx context.Context, d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[d.ID] = d
+	s.due[d.ID] = d.NextAttempt
+	return nil
+}
+
+// ClaimDueCallbacks takes up to limit deliveries due by now, earliest first.
+func (s *MemoryStore) ClaimDueCallbacks(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, at := range s.due {
+		if !at.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return s.due[ids[i]].Before(s.due[ids[j]]) })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	claimed := make([]Delivery, 0, len(ids))
+	for _, id := range ids {
+		delete(s.due, id)
+		claimed = append(claimed, s.deliveries[id])
+	}
+	return claimed, nil
+}
+
+// RemoveCallback forgets the delivery with id.
+func (s *MemoryStore) RemoveCallback(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deliveries, id)
+	delete(s.due, id)
+	return nil
+}
@@ -0,0 +1,91 @@
+package callbacks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// deliveriesKey is a hash of callback deliveries awaiting an attempt, keyed by
+// delivery ID.
+const deliveriesKey = "callback_deliveries"
+
+// dueKey is a sorted set of delivery IDs scored by when they are next
+// attempted (Unix milliseconds).
+const dueKey = "callback_due"
+
+// RedisStore is a Store in Redis, shared by every proxy instance.
+type RedisStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisStore returns a store using client.
+func NewRedisStore(client *ratelimit.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// ScheduleCallback stores d to be attempted at d.NextAttempt, replacing any
+// earlier state of the same delivery.
+func (s *RedisStore) ScheduleCallback(ctx context.Context, d Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	// The delivery is stored before it is due, so a replica claiming it always
+	// finds it.
+	if err := s.client.Client().HSet(ctx, s.client.Key(deliveriesKey), d.ID, data).Err(); err != nil {
+		return err
+	}
+	return s.client.Client().ZAdd(ctx, s.client.Key(dueKey), redis.Z{Score: float64(d.NextAttempt.UnixMilli()), Member: d.ID}).Err()
+}
+
+// ClaimDueCallbacks takes up to limit deliveries due by now off the schedule.
+// Each delivery is claimed by one replica only; the claimer schedules it again
+// or removes it with RemoveCallback.
+func (s *RedisStore) ClaimDueCallbacks(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	due := s.client.Key(dueKey)
+	ids, err := s.client.Client().ZRangeByScore(ctx, due, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	var claimed []Delivery
+	for _, id := range ids {
+		n, err := s.client.Client().ZRem(ctx, due, id).Result()
+		if err != nil {
+			return claimed, err
+		}
+		if n == 0 {
+			continue
+		}
+		data, err := s.client.Client().HGet(ctx, s.client.Key(deliveriesKey), id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+		var d Delivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			slog.Warn("Skipping unreadable callback delivery", "error", err, "delivery_id", id)
+			continue
+		}
+		claimed = append(claimed, d)
+	}
+	return claimed, nil
+}
+
+// RemoveCallback forgets a delivery that succeeded or was given up on.
+func (s *RedisStore) RemoveCallback(ctx context.Context, id string) error {
+	return s.client.Client().HDel(ctx, s.client.Key(deliveriesKey), id).Err()
+}
@@ -0,0 +1,275 @@
+// Package chaos injects faults into the proxy's dependencies at configured
+// rates: Redis errors, embedding sidecar timeouts, upstream 429/5xx responses
+// and slowed streams. It lets operators watch their fail-open/fail-closed
+// policies and alerting react before a real outage does. It is meant for test
+// environments only.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "embedding-sidecar/proto"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"agent-sentinel/internal/stream"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Faults, as counted in proxy.chaos.injected.
+const (
+	FaultRedisError     = "redis_error"
+	FaultSidecarTimeout = "sidecar_timeout"
+	FaultUpstream429    = "upstream_429"
+	FaultUpstream5xx    = "upstream_5xx"
+	FaultSlowStream     = "slow_stream"
+)
+
+// HeaderInjected marks synthetic upstream responses with the injected fault.
+const HeaderInjected = "X-Sentinel-Chaos"
+
+const defaultSlowStreamDelay = 500 * time.Millisecond
+
+// ErrInjected is the error of injected Redis failures.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Config sets the share of operations, 0-100 percent, that get each fault.
+type Config struct {
+	RedisErrorPercent     float64
+	SidecarTimeoutPercent float64
+	Upstream429Percent    float64
+	Upstream5xxPercent    float64
+	SlowStreamPercent     float64
+	// SlowStreamDelay is waited before every read of a slowed stream.
+	SlowStreamDelay time.Duration
+}
+
+// ConfigFromEnv reads CHAOS_MODE (must be "true" to enable injection),
+// CHAOS_REDIS_ERROR_PERCENT, CHAOS_SIDECAR_TIMEOUT_PERCENT,
+// CHAOS_UPSTREAM_429_PERCENT, CHAOS_UPSTREAM_5XX_PERCENT,
+// CHAOS_SLOW_STREAM_PERCENT and CHAOS_SLOW_STREAM_DELAY_MS (default 500). ok is
+// false when CHAOS_MODE is not set.
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv("CHAOS_MODE")); !enabled {
+		return cfg, false, nil
+	}
+	percents := []struct {
+		key  string
+		dest *float64
+	}{
+		{"CHAOS_REDIS_ERROR_PERCENT", &cfg.RedisErrorPercent},
+		{"CHAOS_SIDECAR_TIMEOUT_PERCENT", &cfg.SidecarTimeoutPercent},
+		{"CHAOS_UPSTREAM_429_PERCENT", &cfg.Upstream429Percent},
+		{"CHAOS_UPSTREAM_5XX_PERCENT", &cfg.Upstream5xxPercent},
+		{"CHAOS_SLOW_STREAM_PERCENT", &cfg.SlowStreamPercent},
+	}
+	for _, p := range percents {
+		v := os.Getenv(p.key)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 || parsed > 100 {
+			return cfg, false, fmt.Errorf("invalid %s %q: must be a percentage between 0 and 100", p.key, v)
+		}
+		*p.dest = parsed
+	}
+	cfg.SlowStreamDelay = defaultSlowStreamDelay
+	if v := os.Getenv("CHAOS_SLOW_STREAM_DELAY_MS"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			return cfg, false, fmt.Errorf("invalid CHAOS_SLOW_STREAM_DELAY_MS %q", v)
+		}
+		cfg.SlowStreamDelay = time.Duration(parsed) * time.Millisecond
+	}
+	return cfg, true, nil
+}
+
+// String summarises the configured rates for logs and reports.
+func (c Config) String() string {
+	var parts []string
+	add := func(name string, percent float64) {
+		if percent > 0 {
+			parts = append(parts, fmt.Sprintf("%s %g%%", name, percent))
+		}
+	}
+	add(FaultRedisError, c.RedisErrorPercent)
+	add(FaultSidecarTimeout, c.SidecarTimeoutPercent)
+	add(FaultUpstream429, c.Upstream429Percent)
+	add(FaultUpstream5xx, c.Upstream5xxPercent)
+	add(FaultSlowStream, c.SlowStreamPercent)
+	if len(parts) == 0 {
+		return "no faults"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Injector decides which operations fail. A nil Injector injects nothing, so
+// callers can wire it unconditionally. Safe for concurrent use.
+type Injector struct {
+	cfg  Config
+	roll func() float64
+}
+
+// New returns an Injector for cfg.
+func New(cfg Config) *Injector {
+	if cfg.SlowStreamDelay <= 0 {
+		cfg.SlowStreamDelay = defaultSlowStreamDelay
+	}
+	return &Injector{cfg: cfg, roll: func() float64 { return rand.Float64() * 100 }}
+}
+
+// inject rolls for fault at percent and counts it when it hits.
+func (i *Injector) inject(ctx context.Context, fault string, percent float64) bool {
+	if i == nil || percent <= 0 || i.roll() >= percent {
+		return false
+	}
+	telemetry.IncChaosFault(ctx, fault)
+	return true
+}
+
+// RedisHook fails commands and pipelines with ErrInjected before they reach
+// Redis. Add it to a client with AddHook.
+func (i *Injector) RedisHook() redis.Hook {
+	return redisHook{i: i}
+}
+
+type redisHook struct {
+	i *Injector
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if h.i.inject(ctx, FaultRedisError, h.i.cfg.RedisErrorPercent) {
+			cmd.SetErr(ErrInjected)
+			return ErrInjected
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if h.i.inject(ctx, FaultRedisError, h.i.cfg.RedisErrorPercent) {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrInjected)
+			}
+			return ErrInjected
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// SidecarDialOptions makes loop checks against the embedding sidecar time out.
+// Returns nil when no sidecar faults are configured.
+func (i *Injector) SidecarDialOptions() []grpc.DialOption {
+	if i == nil || i.cfg.SidecarTimeoutPercent <= 0 {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithChainUnaryInterceptor(i.sidecarInterceptor)}
+}
+
+// sidecarInterceptor holds a selected CheckLoop call until its deadline, as an
+// overloaded sidecar would, then fails it with DeadlineExceeded.
+func (i *Injector) sidecarInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if method != pb.EmbeddingService_CheckLoop_FullMethodName || !i.inject(ctx, FaultSidecarTimeout, i.cfg.SidecarTimeoutPercent) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		<-ctx.Done()
+	}
+	return status.Error(codes.DeadlineExceeded, "chaos: injected sidecar timeout")
+}
+
+// Wrap answers a share of upstream requests with a synthetic 429 or 503 instead
+// of sending them, and slows a share of streamed responses.
+func (i *Injector) Wrap(base http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return base
+	}
+	return &transport{i: i, base: base}
+}
+
+type transport struct {
+	i    *Injector
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	switch {
+	case t.i.inject(ctx, FaultUpstream429, t.i.cfg.Upstream429Percent):
+		return syntheticResponse(req, http.StatusTooManyRequests, FaultUpstream429), nil
+	case t.i.inject(ctx, FaultUpstream5xx, t.i.cfg.Upstream5xxPercent):
+		return syntheticResponse(req, http.StatusServiceUnavailable, FaultUpstream5xx), nil
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !stream.IsStreamingResponse(resp) {
+		return resp, err
+	}
+	if t.i.inject(ctx, FaultSlowStream, t.i.cfg.SlowStreamPercent) {
+		resp.Body = &slowBody{ReadCloser: resp.Body, ctx: ctx, delay: t.i.cfg.SlowStreamDelay}
+	}
+	return resp, nil
+}
+
+// syntheticResponse builds a provider-style error response carrying no usage,
+// so the request's estimate is refunded as for a real provider error.
+func syntheticResponse(req *http.Request, code int, fault string) *http.Response {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	body := fmt.Sprintf(`{"error":{"type":"chaos","code":%q,"message":"chaos: injected %s"}}`, fault, fault)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(HeaderInjected, fault)
+	if code == http.StatusTooManyRequests {
+		header.Set("Retry-After", "1")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// slowBody waits delay before every read, so each chunk of the stream arrives
+// at least that far apart.
+type slowBody struct {
+	io.ReadCloser
+	ctx   context.Context
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-b.ctx.Done():
+		return 0, b.ctx.Err()
+	case <-timer.C:
+	}
+	return b.ReadCloser.Read(p)
+}
@@ -0,0 +1,145 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	pb "embedding-sidecar/proto"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func fixedRoll(v float64) func() float64 { return func() float64 { return v } }
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CHAOS_UPSTREAM_429_PERCENT", "10")
+	if _, ok, err := ConfigFromEnv(); ok || err != nil {
+		t.Fatalf("expected chaos mode off without CHAOS_MODE, got ok=%v err=%v", ok, err)
+	}
+
+	t.Setenv("CHAOS_MODE", "true")
+	t.Setenv("CHAOS_SLOW_STREAM_DELAY_MS", "250")
+	cfg, ok, err := ConfigFromEnv()
+	if !ok || err != nil || cfg.Upstream429Percent != 10 || cfg.SlowStreamDelay != 250*time.Millisecond {
+		t.Fatalf("unexpected config %+v ok=%v err=%v", cfg, ok, err)
+	}
+	if got := cfg.String(); got != "upstream_429 10%" {
+		t.Fatalf("unexpected summary %q", got)
+	}
+
+	t.Setenv("CHAOS_REDIS_ERROR_PERCENT", "101")
+	if _, _, err := ConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "CHAOS_REDIS_ERROR_PERCENT") {
+		t.Fatalf("expected error for an out-of-range percent, got %v", err)
+	}
+}
+
+func TestWrapInjectsUpstreamErrors(t *testing.T) {
+	sent := 0
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	})
+	inj := New(Config{Upstream429Percent: 50, Upstream5xxPercent: 100})
+	inj.roll = fixedRoll(10)
+	req, _ := http.NewRequest(http.MethodPost, "http://upstream/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+
+	resp, err := inj.Wrap(base).RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" || resp.Header.Get(HeaderInjected) != FaultUpstream429 {
+		t.Fatalf("expected injected 429, got %+v (%v)", resp, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"error"`) {
+		t.Fatalf("expected provider-style error body, got %s", body)
+	}
+
+	inj.roll = fixedRoll(60)
+	if resp, _ := inj.Wrap(base).RoundTrip(req); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected injected 503, got %d", resp.StatusCode)
+	}
+	if sent != 0 {
+		t.Fatalf("injected errors should not reach upstream, sent %d", sent)
+	}
+
+	inj.cfg.Upstream5xxPercent = 0
+	if resp, _ := inj.Wrap(base).RoundTrip(req); resp.StatusCode != http.StatusOK || sent != 1 {
+		t.Fatalf("expected the request to pass through, got %d (sent %d)", resp.StatusCode, sent)
+	}
+}
+
+func TestWrapSlowsStreams(t *testing.T) {
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("Content-Type", "text/event-stream")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader("data: {}\n\n"))}, nil
+	})
+	inj := New(Config{SlowStreamPercent: 100, SlowStreamDelay: 20 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodPost, "http://upstream/v1/chat/completions", nil)
+
+	resp, err := inj.Wrap(base).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected the stream to be slowed, read took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp, _ = inj.Wrap(base).RoundTrip(req.WithContext(ctx))
+	if _, err := resp.Body.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancelled read to stop waiting, got %v", err)
+	}
+}
+
+func TestRedisHookFailsCommands(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	client.AddHook(New(Config{RedisErrorPercent: 100}).RedisHook())
+
+	if err := client.Ping(context.Background()).Err(); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	pipe := client.Pipeline()
+	get := pipe.Get(context.Background(), "k")
+	if _, err := pipe.Exec(context.Background()); !errors.Is(err, ErrInjected) || !errors.Is(get.Err(), ErrInjected) {
+		t.Fatalf("expected injected pipeline error, got %v / %v", err, get.Err())
+	}
+}
+
+func TestSidecarInterceptorTimesOutLoopChecks(t *testing.T) {
+	if New(Config{}).SidecarDialOptions() != nil {
+		t.Fatal("expected no dial options without sidecar faults")
+	}
+	inj := New(Config{SidecarTimeoutPercent: 100})
+	invoked := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked++
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := inj.sidecarInterceptor(ctx, pb.EmbeddingService_CheckLoop_FullMethodName, nil, nil, nil, invoker)
+	if status.Code(err) != codes.DeadlineExceeded || ctx.Err() == nil {
+		t.Fatalf("expected the check to be held until its deadline, got %v", err)
+	}
+	if err := inj.sidecarInterceptor(context.Background(), pb.EmbeddingService_GetCapabilities_FullMethodName, nil, nil, nil, invoker); err != nil || invoked != 1 {
+		t.Fatalf("expected other methods to pass through, got %v (invoked %d)", err, invoked)
+	}
+}
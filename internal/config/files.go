@@ -0,0 +1,396 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent-sentinel/internal/deferred"
+	"agent-sentinel/internal/experiments"
+	"agent-sentinel/internal/fairness"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/jsonguard"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/mcp"
+	"agent-sentinel/internal/operations"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/slo"
+	"agent-sentinel/internal/toolpolicy"
+	"agent-sentinel/internal/uploads"
+	"agent-sentinel/internal/upstream"
+	"agent-sentinel/internal/validation"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// File names read from CONFIG_DIR. Each is optional; a Kubernetes ConfigMap
+// mounted as a volume exposes one file per key.
+const (
+	PricingFile  = "pricing.json"
+	LimitsFile   = "limits.json"
+	PoliciesFile = "policies.json"
+	// ExperimentsFile holds {"experiments": [...]}; see experiments.Experiment.
+	ExperimentsFile = "experiments.json"
+	// SLOFile holds {"slos": [...]}; see slo.Objective.
+	SLOFile = "slo.json"
+	// ModelsFile holds model metadata by provider; see ratelimit.ModelInfo.
+	ModelsFile = "models.json"
+)
+
+// Limits configures spend limits. Limits set through the admin API (stored in
+// Redis) take precedence over these.
+type Limits struct {
+	Default *float64           `json:"default,omitempty"`
+	Tenants map[string]float64 `json:"tenants,omitempty"`
+	// Parents maps a tenant to its parent (e.g. agent -> team -> org). Spend rolls
+	// up to every ancestor and each level's limit is enforced.
+	Parents map[string]string `json:"parents,omitempty"`
+	// Schedules vary a tenant's limit by time of day and weekday.
+	Schedules map[string]ratelimit.Schedule `json:"schedules,omitempty"`
+	// OverdraftPercent overrides OVERDRAFT_PERCENT: how far past its limit a tenant
+	// still under the limit may go on one request before the hard stop.
+	OverdraftPercent *float64 `json:"overdraft_percent,omitempty"`
+	// MaxRequestCost overrides MAX_REQUEST_COST: the most a single request may be
+	// estimated to cost, by default and per tenant.
+	MaxRequestCost *ratelimit.RequestCostCeiling `json:"max_request_cost,omitempty"`
+	// Windows selects the window algorithm per tenant ("buckets" or "sliding"),
+	// overriding RATE_LIMIT_WINDOW.
+	Windows map[string]string `json:"windows,omitempty"`
+	// FineTune overrides FINE_TUNE_BUDGET/FINE_TUNE_BUDGETS: monthly USD
+	// allowances for fine-tuning jobs, by default and per tenant.
+	FineTune *finetune.Budgets `json:"fine_tune,omitempty"`
+}
+
+// Policies configures proxy-wide behaviour.
+type Policies struct {
+	// ShadowMode, when set, is applied to the shared shadow mode flag on every reload.
+	ShadowMode *bool `json:"shadow_mode,omitempty"`
+	// Downgrade switches tenants with a low budget to cheaper models.
+	Downgrade *ratelimit.DowngradePolicy `json:"downgrade,omitempty"`
+	// MaxTokens injects a default output token cap and clamps oversized ones,
+	// overriding MAX_TOKENS_DEFAULT/MAX_TOKENS_CAP.
+	MaxTokens *ratelimit.OutputTokenPolicy `json:"max_tokens,omitempty"`
+	// TruncationRetry retries responses cut off at their output token cap,
+	// overriding the TRUNCATION_RETRY_* variables.
+	TruncationRetry *ratelimit.TruncationRetryPolicy `json:"truncation_retry,omitempty"`
+	// JSONGuard checks that JSON responses parse and match their schema,
+	// overriding the JSON_GUARD* variables.
+	JSONGuard *jsonguard.Policy `json:"json_guard,omitempty"`
+	// Validation rejects malformed requests, overriding REQUEST_VALIDATION and
+	// REQUEST_FORBIDDEN_FIELDS.
+	Validation *validation.Policy `json:"validation,omitempty"`
+	// LoopBypass grants tenants the X-Sentinel-Loop-Bypass header, overriding
+	// LOOP_BYPASS_TENANTS.
+	LoopBypass *loopdetect.BypassPolicy `json:"loop_bypass,omitempty"`
+	// LoopHints overrides LOOP_INTERVENTION_HINT per tenant and provider.
+	LoopHints *loopdetect.HintPolicy `json:"loop_hints,omitempty"`
+	// LoopCanonicalize normalizes prompts before loop detection, overriding
+	// LOOP_CANONICALIZE.
+	LoopCanonicalize *loopdetect.CanonicalizePolicy `json:"loop_canonicalize,omitempty"`
+	// UpstreamHeaders adds headers (e.g. OpenAI-Organization) to upstream
+	// requests per provider and tenant.
+	UpstreamHeaders *upstream.HeaderPolicy `json:"upstream_headers,omitempty"`
+	// BetaFeatures allows or blocks anthropic-beta and OpenAI-Beta features,
+	// overriding the BETA_FEATURES_* variables.
+	BetaFeatures *upstream.BetaPolicy `json:"beta_features,omitempty"`
+	// Tools allows or blocks the tools requests may declare, overriding the
+	// TOOLS_* variables.
+	Tools *toolpolicy.Policy `json:"tools,omitempty"`
+	// Operations allows or blocks non-POST provider requests (file and
+	// fine-tuning management), overriding the OPERATIONS_* variables.
+	Operations *operations.Policy `json:"operations,omitempty"`
+	// Uploads limits file uploads by size, content type and per-tenant
+	// storage, overriding the UPLOAD_* variables.
+	Uploads *uploads.Policy `json:"uploads,omitempty"`
+	// Deferral queues low-priority requests over the soft or hard spend limit
+	// for later replay, overriding the DEFER_* variables.
+	Deferral *deferred.Policy `json:"deferral,omitempty"`
+	// Fairness schedules requests across tenants by tier while the provider
+	// key is contended, overriding the FAIR_QUEUE_* variables.
+	Fairness *fairness.Policy `json:"fairness,omitempty"`
+	// Smoothing throttles request bursts per tenant with a token bucket,
+	// overriding SMOOTHING_BURST and SMOOTHING_RATE_PER_SECOND.
+	Smoothing *ratelimit.SmoothingPolicy `json:"smoothing,omitempty"`
+	// MCP rate limits tool calls to MCP servers per tenant and tool,
+	// overriding MCP_TOOL_BURST and MCP_TOOL_RATE_PER_SECOND.
+	MCP *mcp.Policy `json:"mcp,omitempty"`
+}
+
+// Files is the parsed contents of CONFIG_DIR.
+type Files struct {
+	// Pricing overrides or extends the built-in pricing table (per 1M tokens).
+	Pricing  ratelimit.ProviderPricing `json:"pricing,omitempty"`
+	Limits   Limits                    `json:"limits"`
+	Policies Policies                  `json:"policies"`
+	// Experiments split tenants between model variants.
+	Experiments []experiments.Experiment `json:"experiments,omitempty"`
+	// SLOs are latency and error-rate objectives for provider responses.
+	SLOs []slo.Objective `json:"slos,omitempty"`
+	// Models overrides or extends the built-in model metadata (context windows,
+	// output caps, modalities, deprecation dates).
+	Models ratelimit.ProviderModels `json:"models,omitempty"`
+}
+
+// LoadDir reads the config files in dir. Missing files are skipped; any parse
+// error fails the whole load so a bad edit never applies half a config.
+func LoadDir(dir string) (*Files, error) {
+	files := &Files{}
+	if err := readJSON(filepath.Join(dir, PricingFile), &files.Pricing); err != nil {
+		return nil, err
+	}
+	if err := readJSON(filepath.Join(dir, LimitsFile), &files.Limits); err != nil {
+		return nil, err
+	}
+	if _, err := ratelimit.CompileSchedules(files.Limits.Schedules); err != nil {
+		return nil, fmt.Errorf("%s: %w", LimitsFile, err)
+	}
+	if p := files.Limits.OverdraftPercent; p != nil && *p < 0 {
+		return nil, fmt.Errorf("%s: overdraft_percent must not be negative", LimitsFile)
+	}
+	for tenantID, w := range files.Limits.Windows {
+		if err := ratelimit.ValidateWindow(w); err != nil {
+			return nil, fmt.Errorf("%s: windows %s: %w", LimitsFile, tenantID, err)
+		}
+	}
+	if c := files.Limits.MaxRequestCost; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", LimitsFile, err)
+		}
+	}
+	if b := files.Limits.FineTune; b != nil {
+		if err := b.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", LimitsFile, err)
+		}
+	}
+	if err := readJSON(filepath.Join(dir, PoliciesFile), &files.Policies); err != nil {
+		return nil, err
+	}
+	if d := files.Policies.Downgrade; d != nil {
+		if err := d.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if m := files.Policies.MaxTokens; m != nil {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if t := files.Policies.TruncationRetry; t != nil {
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if j := files.Policies.JSONGuard; j != nil {
+		if err := j.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if h := files.Policies.LoopHints; h != nil {
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if b := files.Policies.LoopBypass; b != nil {
+		if err := b.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if c := files.Policies.LoopCanonicalize; c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if h := files.Policies.UpstreamHeaders; h != nil {
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if b := files.Policies.BetaFeatures; b != nil {
+		if err := b.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if t := files.Policies.Tools; t != nil {
+		if err := t.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if o := files.Policies.Operations; o != nil {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if u := files.Policies.Uploads; u != nil {
+		if err := u.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if d := files.Policies.Deferral; d != nil {
+		if err := d.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if f := files.Policies.Fairness; f != nil {
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if s := files.Policies.Smoothing; s != nil {
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	if m := files.Policies.MCP; m != nil {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", PoliciesFile, err)
+		}
+	}
+	var exp struct {
+		Experiments []experiments.Experiment `json:"experiments"`
+	}
+	if err := readJSON(filepath.Join(dir, ExperimentsFile), &exp); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, e := range exp.Experiments {
+		if err := e.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", ExperimentsFile, err)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("%s: duplicate experiment %q", ExperimentsFile, e.Name)
+		}
+		names[e.Name] = true
+	}
+	files.Experiments = exp.Experiments
+
+	var objectives struct {
+		SLOs []slo.Objective `json:"slos"`
+	}
+	if err := readJSON(filepath.Join(dir, SLOFile), &objectives); err != nil {
+		return nil, err
+	}
+	names = map[string]bool{}
+	for _, o := range objectives.SLOs {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", SLOFile, err)
+		}
+		if names[o.Name] {
+			return nil, fmt.Errorf("%s: duplicate slo %q", SLOFile, o.Name)
+		}
+		names[o.Name] = true
+	}
+	files.SLOs = objectives.SLOs
+
+	if err := readJSON(filepath.Join(dir, ModelsFile), &files.Models); err != nil {
+		return nil, err
+	}
+	if err := files.Models.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ModelsFile, err)
+	}
+	return files, nil
+}
+
+// PolicyVersion identifies the limits and policies in force by content: the
+// first 12 hex characters of the SHA-256 of their JSON encoding. Responses
+// stamped with provenance carry it.
+func (f *Files) PolicyVersion() string {
+	data, _ := json.Marshal(struct {
+		Limits   Limits   `json:"limits"`
+		Policies Policies `json:"policies"`
+	}{f.Limits, f.Policies})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// ApplyLimits applies the pricing, limits and limiter policies in f to rl.
+func (f *Files) ApplyLimits(ctx context.Context, rl *ratelimit.RateLimiter) {
+	rl.SetPricingOverrides(f.Pricing)
+	rl.SetLimitOverrides(f.Limits.Default, f.Limits.Tenants)
+	rl.SetHierarchy(f.Limits.Parents)
+	rl.SetOverdraftOverride(f.Limits.OverdraftPercent)
+	rl.SetRequestCostCeiling(f.Limits.MaxRequestCost)
+	rl.SetWindowOverrides(f.Limits.Windows)
+	rl.SetDowngradePolicy(f.Policies.Downgrade)
+	if schedules, err := ratelimit.CompileSchedules(f.Limits.Schedules); err == nil {
+		rl.SetSchedules(schedules)
+	}
+	if f.Policies.ShadowMode != nil {
+		if err := rl.SetShadowMode(ctx, *f.Policies.ShadowMode); err != nil {
+			slog.Warn("Failed to apply shadow mode from config", "error", err)
+		}
+	}
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// reloadDebounce coalesces the burst of events produced by a ConfigMap update
+// (the kubelet swaps the ..data symlink and removes the old directory).
+const reloadDebounce = 500 * time.Millisecond
+
+// WatchDir calls apply with the freshly loaded config whenever a file in dir
+// changes, until ctx is cancelled. Invalid updates are logged and skipped, keeping
+// the previously applied config.
+func WatchDir(ctx context.Context, dir string, apply func(*Files)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				debounce = time.After(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Config watcher error", "error", err, "dir", dir)
+			case <-debounce:
+				debounce = nil
+				files, err := LoadDir(dir)
+				if err != nil {
+					slog.Warn("Config reload failed, keeping previous config", "error", err, "dir", dir)
+					continue
+				}
+				slog.Info("Config reloaded", "dir", dir)
+				apply(files)
+			}
+		}
+	}()
+	return nil
+}
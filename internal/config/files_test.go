@@ -0,0 +1,317 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadDirParsesFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PricingFile, `{"openai": {"gpt-custom": {"input_price": 1.5, "output_price": 6}}}`)
+	writeFile(t, dir, LimitsFile, `{"default": 50, "tenants": {"acme": 250}}`)
+
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if got := files.Pricing["openai"]["gpt-custom"]; got.InputPrice != 1.5 || got.OutputPrice != 6 {
+		t.Fatalf("unexpected pricing %+v", got)
+	}
+	if files.Limits.Default == nil || *files.Limits.Default != 50 || files.Limits.Tenants["acme"] != 250 {
+		t.Fatalf("unexpected limits %+v", files.Limits)
+	}
+	if files.Policies.ShadowMode != nil {
+		t.Fatalf("expected unset shadow mode when policies.json is missing")
+	}
+}
+
+func TestLoadDirRejectsNegativeOverdraft(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, LimitsFile, `{"overdraft_percent": -5}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
+
+func TestLoadDirRejectsInvalidJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"shadow_mode": `)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestWatchDirReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *Files, 4)
+	if err := WatchDir(ctx, dir, func(f *Files) { applied <- f }); err != nil {
+		t.Fatalf("WatchDir: %v", err)
+	}
+
+	// An invalid edit is skipped; the next valid one is applied.
+	writeFile(t, dir, PoliciesFile, `not json`)
+	time.Sleep(2 * reloadDebounce)
+	writeFile(t, dir, PoliciesFile, `{"shadow_mode": true}`)
+
+	select {
+	case f := <-applied:
+		if f.Policies.ShadowMode == nil || !*f.Policies.ShadowMode {
+			t.Fatalf("expected shadow mode from reloaded policies, got %+v", f.Policies)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for reload")
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func TestLoadDirParsesExperiments(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ExperimentsFile, `{"experiments": [{"name": "mini", "match_model": "gpt-4o", "variants": [
+		{"name": "control", "model": "gpt-4o", "weight": 90}, {"name": "mini", "model": "gpt-4o-mini", "weight": 10}]}]}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if len(files.Experiments) != 1 || files.Experiments[0].Variants[1].Weight != 10 {
+		t.Fatalf("unexpected experiments %+v", files.Experiments)
+	}
+
+	writeFile(t, dir, ExperimentsFile, `{"experiments": [{"name": "mini", "match_model": "gpt-4o", "variants": []}]}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an experiment without variants")
+	}
+}
+
+func TestLoadDirParsesSLOs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, SLOFile, `{"slos": [{"name": "gpt-4o", "model": "gpt-4o", "latency_ms": 2000, "latency_target": 0.95, "error_target": 0.99}]}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if len(files.SLOs) != 1 || files.SLOs[0].LatencyMs != 2000 || files.SLOs[0].ErrorTarget != 0.99 {
+		t.Fatalf("unexpected slos %+v", files.SLOs)
+	}
+
+	writeFile(t, dir, SLOFile, `{"slos": [{"name": "gpt-4o", "error_target": 1.5}]}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an out-of-range target")
+	}
+}
+
+func TestLoadDirValidatesMaxTokensPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"max_tokens": {"default": 1024, "max": 8192}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if m := files.Policies.MaxTokens; m == nil || m.Default != 1024 || m.Max != 8192 {
+		t.Fatalf("unexpected max_tokens policy %+v", m)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"max_tokens": {"default": 9000, "max": 8192}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a default above the max")
+	}
+}
+
+func TestLoadDirParsesLoopBypassPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"loop_bypass": {"tenants": ["acme-agent-7"], "until": "2030-01-01T00:00:00Z"}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	b := files.Policies.LoopBypass
+	if b == nil || len(b.Tenants) != 1 || b.Until == nil || b.Until.Year() != 2030 {
+		t.Fatalf("unexpected loop_bypass policy %+v", b)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"loop_bypass": {"tenants": [""]}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an empty tenant")
+	}
+}
+
+func TestLoadDirValidatesLoopHints(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"loop_hints": {"providers": {"anthropic": {"template": "Stop repeating {{.SimilarPrompt}}"}}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if h := files.Policies.LoopHints; h == nil || h.Providers["anthropic"].Template == "" {
+		t.Fatalf("unexpected loop_hints policy %+v", h)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"loop_hints": {"default": {"template": "{{.Tenant"}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an unparseable template")
+	}
+}
+
+func TestLoadDirValidatesUpstreamHeaders(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"upstream_headers": {"tenants": {"acme": {"OpenAI-Organization": "org-acme"}}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if h := files.Policies.UpstreamHeaders; h == nil || h.Tenants["acme"]["OpenAI-Organization"] != "org-acme" {
+		t.Fatalf("unexpected upstream_headers policy %+v", h)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"upstream_headers": {"default": {"Authorization": "Bearer x"}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a protected header")
+	}
+}
+
+func TestLoadDirValidatesBetaFeatures(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"beta_features": {"allow": ["prompt-caching-*"], "block": ["computer-use-*"], "action": "reject"}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if b := files.Policies.BetaFeatures; b == nil || b.Action != "reject" || len(b.Block) != 1 {
+		t.Fatalf("unexpected beta_features policy %+v", b)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"beta_features": {"action": "drop"}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an unknown action")
+	}
+}
+
+func TestLoadDirValidatesTools(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"tools": {"allow": ["*"], "block": ["code_execution*"], "tenants": {"acme": ["web_search*"]}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if p := files.Policies.Tools; p == nil || len(p.Tenants["acme"]) != 1 {
+		t.Fatalf("unexpected tools policy %+v", p)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"tools": {"block": [""]}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an empty entry")
+	}
+}
+
+func TestLoadDirValidatesOperations(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"operations": {"allow": ["GET *"], "tenants": {"ml-platform": ["DELETE /v1/files/*"]}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if p := files.Policies.Operations; p == nil || len(p.Tenants["ml-platform"]) != 1 {
+		t.Fatalf("unexpected operations policy %+v", p)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"operations": {"block": ["/v1/files/*"]}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an entry without a method")
+	}
+}
+
+func TestLoadDirValidatesFairness(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"fairness": {"concurrency": 8, "min_remaining_requests": 50, "tiers": {"enterprise": 4}, "tenants": {"acme": "enterprise"}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if p := files.Policies.Fairness; p == nil || p.Concurrency != 8 || p.Tenants["acme"] != "enterprise" {
+		t.Fatalf("unexpected fairness policy %+v", p)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"fairness": {"concurrency": 8, "tiers": {"free": 0}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a zero weight")
+	}
+}
+
+func TestLoadDirValidatesSmoothing(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"smoothing": {"default": {"burst": 20, "rate_per_second": 2}, "tenants": {"acme-batch": {"burst": 100, "rate_per_second": 10}}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if p := files.Policies.Smoothing; p == nil || p.Default.Burst != 20 || p.Tenants["acme-batch"].RatePerSecond != 10 {
+		t.Fatalf("unexpected smoothing policy %+v", p)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"smoothing": {"default": {"burst": 20}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a burst without a rate")
+	}
+}
+
+func TestLoadDirValidatesMCP(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"mcp": {"tools": {"github/*": {"burst": 10, "rate_per_second": 0.5}}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if p := files.Policies.MCP; p == nil || p.Tools["github/*"].Burst != 10 {
+		t.Fatalf("unexpected mcp policy %+v", p)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"mcp": {"tools": {"github/create_issue": {"burst": 5}}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a burst without a rate")
+	}
+}
+
+func TestLoadDirValidatesJSONGuard(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, PoliciesFile, `{"json_guard": {"enabled": true, "tenants": ["acme"], "retry": true}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if j := files.Policies.JSONGuard; j == nil || !j.Retry || !j.Requires("acme") {
+		t.Fatalf("unexpected json_guard policy %+v", j)
+	}
+
+	writeFile(t, dir, PoliciesFile, `{"json_guard": {"tenants": [""]}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for an empty tenant")
+	}
+}
+
+func TestLoadDirValidatesModels(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ModelsFile, `{"openai": {"gpt-custom": {"context_window": 32000, "max_output": 4096, "deprecated": "2026-06-30"}}}`)
+	files, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if m := files.Models["openai"]["gpt-custom"]; m.ContextWindow != 32000 || m.MaxOutput != 4096 || m.Deprecated != "2026-06-30" {
+		t.Fatalf("unexpected models %+v", files.Models)
+	}
+
+	writeFile(t, dir, ModelsFile, `{"openai": {"gpt-custom": {"context_window": 1000, "max_output": 4096}}}`)
+	if _, err := LoadDir(dir); err == nil {
+		t.Fatal("expected validation error for a max output above the context window")
+	}
+}
@@ -0,0 +1,21 @@
+// Package dashboard serves a minimal built-in web UI backed by the admin API.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// NewHandler serves the dashboard page at / and mounts adminAPI under /admin/
+// so the page can query it from the same origin.
+func NewHandler(adminAPI http.Handler) http.Handler {
+	static, _ := fs.Sub(staticFiles, "static")
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", adminAPI)
+	mux.Handle("GET /{$}", http.FileServerFS(static))
+	return mux
+}
@@ -0,0 +1,27 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServesIndexAndAdminAPI(t *testing.T) {
+	adminAPI := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Admin", r.URL.Path)
+	})
+	h := NewHandler(adminAPI)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Agent Sentinel") {
+		t.Fatalf("expected dashboard page, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/tenants", nil))
+	if rec.Header().Get("X-Admin") != "/admin/tenants" {
+		t.Fatalf("expected admin API to handle /admin/tenants")
+	}
+}
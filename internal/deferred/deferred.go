@@ -0,0 +1,516 @@
+// Package deferred queues low-priority requests that would run a tenant over
+// its soft spend limit, or that its spend limit denies, instead of failing
+// them with a 429. The client gets a 202 with a URL to poll; queued requests
+// are replayed through the proxy once the tenant's spend window has room
+// again.
+package deferred
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/override"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Reasons a request is deferred.
+const (
+	ReasonSoftLimit = "soft_limit"
+	ReasonOverLimit = "over_limit"
+)
+
+const (
+	defaultMaxQueued = 100
+	defaultTTL       = 24 * time.Hour
+	// maxReplaysPerTick bounds how many of one tenant's requests a replica
+	// replays per poll, so one tenant's backlog does not spend its whole
+	// window at once.
+	maxReplaysPerTick = 10
+	// replayTimeout bounds a single replayed request.
+	replayTimeout = 10 * time.Minute
+)
+
+// droppedHeaders are not stored with a deferred request: credentials the
+// provider adapter replaces anyway, and headers describing the original
+// connection.
+var droppedHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key", "Cookie", "Proxy-Authorization", "Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// Policy configures deferral.
+type Policy struct {
+	// Enabled defers low-priority requests the spend limit denies.
+	Enabled bool `json:"enabled"`
+	// SoftLimitPercent also defers low-priority requests once the tenant has
+	// spent this percentage of its limit; 0 defers only denied requests.
+	SoftLimitPercent float64 `json:"soft_limit_percent,omitempty"`
+	// MaxQueued caps each tenant's queued requests (default 100); requests
+	// past it get the usual 429.
+	MaxQueued int `json:"max_queued,omitempty"`
+	// TTLSeconds is how long a request may wait in the queue, and how long its
+	// response is kept once replayed (default 86400).
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// Validate checks a deferral policy.
+func (p Policy) Validate() error {
+	if p.SoftLimitPercent < 0 || p.SoftLimitPercent > 100 {
+		return fmt.Errorf("deferral soft_limit_percent must be between 0 and 100, got %g", p.SoftLimitPercent)
+	}
+	if p.MaxQueued < 0 || p.TTLSeconds < 0 {
+		return fmt.Errorf("deferral max_queued and ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+func (p Policy) maxQueued() int {
+	if p.MaxQueued > 0 {
+		return p.MaxQueued
+	}
+	return defaultMaxQueued
+}
+
+func (p Policy) ttl() time.Duration {
+	if p.TTLSeconds > 0 {
+		return time.Duration(p.TTLSeconds) * time.Second
+	}
+	return defaultTTL
+}
+
+// threshold is the share of its limit a tenant may have spent for its
+// queued requests to be replayed.
+func (p Policy) threshold() float64 {
+	if p.SoftLimitPercent > 0 {
+		return p.SoftLimitPercent / 100
+	}
+	return 1
+}
+
+// policyFromEnv reads DEFER_LOW_PRIORITY, DEFER_SOFT_LIMIT_PERCENT,
+// DEFER_MAX_QUEUED and DEFER_TTL_SECONDS.
+func policyFromEnv() Policy {
+	var p Policy
+	p.Enabled, _ = strconv.ParseBool(os.Getenv("DEFER_LOW_PRIORITY"))
+	if v, err := strconv.ParseFloat(os.Getenv("DEFER_SOFT_LIMIT_PERCENT"), 64); err == nil && v > 0 && v <= 100 {
+		p.SoftLimitPercent = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("DEFER_MAX_QUEUED")); err == nil && v > 0 {
+		p.MaxQueued = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("DEFER_TTL_SECONDS")); err == nil && v > 0 {
+		p.TTLSeconds = v
+	}
+	return p
+}
+
+// Deferred request states.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusExpired   = "expired"
+)
+
+// Request is a low-priority request queued until its tenant's budget frees
+// up, then replayed through the proxy.
+type Request struct {
+	ID        string `json:"id"`
+	TenantID  string `json:"tenant_id"`
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method"`
+	// URI is the request's path and query.
+	URI    string      `json:"uri"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	// Reason is why the request was deferred: soft_limit or over_limit.
+	Reason    string    `json:"reason"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts,omitempty"`
+	Created   time.Time `json:"created"`
+	Completed time.Time `json:"completed,omitzero"`
+	// Expires is when the request, or its response, is forgotten.
+	Expires  time.Time `json:"expires"`
+	Response *Response `json:"response,omitempty"`
+}
+
+// Response is the response a replayed request got.
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Store keeps deferred requests and each tenant's queue. RedisStore shares
+// them between proxy instances.
+type Store interface {
+	DeferRequest(ctx context.Context, req Request) error
+	RequeueDeferred(ctx context.Context, req Request) error
+	SaveDeferred(ctx context.Context, req Request) error
+	Deferred(ctx context.Context, id string) (Request, bool, error)
+	DeferredTenants(ctx context.Context) ([]string, error)
+	DeferredQueueLength(ctx context.Context, tenantID string) (int64, error)
+	NextDeferred(ctx context.Context, tenantID string) (Request, bool, error)
+}
+
+// Budget reports a tenant's spend in the current window and its limit.
+type Budget interface {
+	GetSpend(ctx context.Context, tenantID string) (float64, error)
+	GetLimit(ctx context.Context, tenantID string) (float64, error)
+}
+
+// Queue defers requests and replays them. Safe for concurrent use.
+type Queue struct {
+	store  Store
+	budget Budget
+	active override.Value[Policy]
+	now    func() time.Time
+
+	// OnComplete, when set before Run, is called with each request once its
+	// replay has completed.
+	OnComplete func(ctx context.Context, req Request)
+
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+// New returns a queue using the DEFER_* variables.
+func New(store Store, budget Budget) *Queue {
+	env := policyFromEnv()
+	return &Queue{store: store, budget: budget, active: override.New(&env), now: time.Now}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (q *Queue) Set(policy *Policy) {
+	if q == nil {
+		return
+	}
+	q.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (q *Queue) Policy() Policy {
+	if q == nil {
+		return Policy{}
+	}
+	return q.active.Get()
+}
+
+// SetHandler sets the handler deferred requests are replayed through: the
+// part of the middleware chain inside deferral.
+func (q *Queue) SetHandler(h http.Handler) {
+	q.mu.Lock()
+	q.handler = h
+	q.mu.Unlock()
+}
+
+// OverSoftLimit reports whether the tenant has spent its soft limit.
+func (q *Queue) OverSoftLimit(ctx context.Context, tenantID string) (bool, error) {
+	p := q.Policy()
+	if !p.Enabled || p.SoftLimitPercent <= 0 {
+		return false, nil
+	}
+	return q.spent(ctx, tenantID, p.threshold())
+}
+
+// spent reports whether the tenant has spent share of its limit.
+func (q *Queue) spent(ctx context.Context, tenantID string, share float64) (bool, error) {
+	limit, err := q.budget.GetLimit(ctx, tenantID)
+	if err != nil || limit <= 0 {
+		return false, err
+	}
+	spend, err := q.budget.GetSpend(ctx, tenantID)
+	if err != nil {
+		return false, err
+	}
+	return spend >= limit*share, nil
+}
+
+// Defer queues the tenant's request r, whose body is body, for replay. It
+// returns false when the tenant's queue is full.
+func (q *Queue) Defer(ctx context.Context, tenantID, reason string, r *http.Request, body []byte) (Request, bool, error) {
+	p := q.Policy()
+	queued, err := q.store.DeferredQueueLength(ctx, tenantID)
+	if err != nil {
+		return Request{}, false, err
+	}
+	if queued >= int64(p.maxQueued()) {
+		return Request{}, false, nil
+	}
+	header := r.Header.Clone()
+	for _, h := range droppedHeaders {
+		header.Del(h)
+	}
+	now := q.now().UTC()
+	req := Request{
+		ID:        newID(),
+		TenantID:  tenantID,
+		RequestID: telemetry.RequestID(ctx),
+		Method:    r.Method,
+		URI:       r.URL.RequestURI(),
+		Header:    header,
+		Body:      body,
+		Reason:    reason,
+		Status:    StatusQueued,
+		Created:   now,
+		Expires:   now.Add(p.ttl()),
+	}
+	if err := q.store.DeferRequest(ctx, req); err != nil {
+		return Request{}, false, err
+	}
+	return req, true, nil
+}
+
+// Get returns the deferred request with id.
+func (q *Queue) Get(ctx context.Context, id string) (Request, bool, error) {
+	return q.store.Deferred(ctx, id)
+}
+
+// Status describes req as tenants see it when they poll it, including the
+// replayed response once there is one.
+func Status(req Request) map[string]any {
+	out := map[string]any{
+		"id":         req.ID,
+		"object":     "deferred_request",
+		"status":     req.Status,
+		"reason":     req.Reason,
+		"created_at": req.Created,
+		"expires_at": req.Expires,
+	}
+	if !req.Completed.IsZero() {
+		out["completed_at"] = req.Completed
+	}
+	if resp := req.Response; resp != nil {
+		response := map[string]any{"status": resp.Status, "content_type": resp.Header.Get("Content-Type")}
+		if json.Valid(resp.Body) {
+			response["body"] = json.RawMessage(resp.Body)
+		} else {
+			response["body"] = string(resp.Body)
+		}
+		out["response"] = response
+	}
+	return out
+}
+
+// Run replays queued requests every interval until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context, interval time.Duration) {
+	if q == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := q.Replay(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Deferred request replay failed", "error", err)
+			}
+		}
+	}
+}
+
+// Replay replays the queued requests of every tenant whose spend has dropped
+// below the replay threshold (its soft limit, or its limit without one), in
+// order, until the tenant runs out of room again. Tenants are replayed
+// concurrently. It returns how many requests completed.
+func (q *Queue) Replay(ctx context.Context) (int, error) {
+	q.mu.RLock()
+	handler := q.handler
+	q.mu.RUnlock()
+	if handler == nil {
+		return 0, nil
+	}
+	tenants, err := q.store.DeferredTenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		completed int
+	)
+	for _, tenantID := range tenants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := q.replayTenant(ctx, handler, tenantID)
+			mu.Lock()
+			completed += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return completed, nil
+}
+
+// replayTenant replays the tenant's queued requests while its budget has room.
+func (q *Queue) replayTenant(ctx context.Context, handler http.Handler, tenantID string) int {
+	completed := 0
+	for range maxReplaysPerTick {
+		p := q.Policy()
+		full, err := q.spent(ctx, tenantID, p.threshold())
+		if err != nil {
+			slog.Warn("Failed to check budget for deferred requests", "error", err, "tenant_id", tenantID)
+			return completed
+		}
+		if full {
+			return completed
+		}
+		req, ok, err := q.store.NextDeferred(ctx, tenantID)
+		if err != nil {
+			slog.Warn("Failed to take deferred request", "error", err, "tenant_id", tenantID)
+			return completed
+		}
+		if !ok {
+			return completed
+		}
+		if !q.now().Before(req.Expires) {
+			telemetry.IncDeferred(ctx, tenantID, "expired", req.Reason)
+			continue
+		}
+
+		req.Status = StatusRunning
+		req.Attempts++
+		if err := q.store.SaveDeferred(ctx, req); err != nil {
+			slog.Warn("Failed to mark deferred request running", "error", err, "deferred_id", req.ID)
+		}
+		resp := q.replay(ctx, handler, req)
+		if deniedBySpendLimit(resp) {
+			// Another request took the room first; wait for the next poll.
+			req.Status = StatusQueued
+			if err := q.store.RequeueDeferred(ctx, req); err != nil {
+				slog.Warn("Failed to requeue deferred request", "error", err, "deferred_id", req.ID)
+			}
+			telemetry.IncDeferred(ctx, tenantID, "requeued", req.Reason)
+			return completed
+		}
+
+		now := q.now().UTC()
+		req.Status = StatusCompleted
+		req.Completed = now
+		req.Expires = now.Add(p.ttl())
+		req.Response = &resp
+		req.Header, req.Body = nil, nil
+		if err := q.store.SaveDeferred(ctx, req); err != nil {
+			slog.Warn("Failed to save deferred response", "error", err, "deferred_id", req.ID)
+		}
+		completed++
+		telemetry.IncDeferred(ctx, tenantID, "replayed", req.Reason)
+		events.Record(events.TypeRequestDeferred, tenantID, map[string]any{
+			"id":       req.ID,
+			"status":   req.Status,
+			"reason":   req.Reason,
+			"response": resp.Status,
+			"waited_s": int(now.Sub(req.Created).Seconds()),
+		})
+		slog.Info("Replayed deferred request",
+			"deferred_id", req.ID,
+			"tenant_id", tenantID,
+			"status", resp.Status,
+			"waited", now.Sub(req.Created).Round(time.Second),
+		)
+		if q.OnComplete != nil {
+			q.OnComplete(ctx, req)
+		}
+	}
+	return completed
+}
+
+// replay sends req through handler and records the response.
+func (q *Queue) replay(ctx context.Context, handler http.Handler, req Request) Response {
+	requestID := req.RequestID
+	if requestID == "" {
+		requestID = telemetry.NewRequestID()
+	}
+	ctx, cancel := context.WithTimeout(telemetry.WithRequestID(ctx, requestID), replayTimeout)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.URI, bytes.NewReader(req.Body))
+	if err != nil {
+		return Response{Status: http.StatusBadRequest, Body: errorBody("Deferred request could not be replayed: " + err.Error())}
+	}
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set(telemetry.HeaderRequestID, requestID)
+	rec := &recorder{header: http.Header{}}
+	handler.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return Response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+}
+
+// deniedBySpendLimit reports whether resp is the spend limit's 429, as
+// opposed to the provider's.
+func deniedBySpendLimit(resp Response) bool {
+	if resp.Status != http.StatusTooManyRequests {
+		return false
+	}
+	return ErrorCode(resp.Body) == "rate_limit_exceeded"
+}
+
+// ErrorCode returns the error code of a JSON error response body.
+func ErrorCode(body []byte) string {
+	var e struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &e)
+	return e.Error.Code
+}
+
+func errorBody(message string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "invalid_request_error",
+			"code":    "deferred_replay_failed",
+		},
+	})
+	return data
+}
+
+// newID returns a random deferred request ID.
+func newID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "dfr_" + hex.EncodeToString(b)
+}
+
+// recorder is the ResponseWriter a deferred request is replayed into.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+// Flush is a no-op; it lets streamed responses be replayed.
+func (r *recorder) Flush() {}
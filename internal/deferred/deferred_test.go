@@ -0,0 +1,148 @@
+package deferred
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeBudget struct {
+	spend, limit float64
+}
+
+func (b *fakeBudget) GetSpend(ctx context.Context, tenantID string) (float64, error) {
+	return b.spend, nil
+}
+
+func (b *fakeBudget) GetLimit(ctx context.Context, tenantID string) (float64, error) {
+	return b.limit, nil
+}
+
+func TestPolicyValidate(t *testing.T) {
+	if err := (Policy{Enabled: true, SoftLimitPercent: 80}).Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := (Policy{SoftLimitPercent: 120}).Validate(); err == nil {
+		t.Fatal("expected a soft limit over 100% to be rejected")
+	}
+}
+
+func TestNilQueueHasNoPolicy(t *testing.T) {
+	// Without Redis there is no queue, but config reloads still set its policy.
+	var q *Queue
+	q.Set(&Policy{Enabled: true})
+	if q.Policy().Enabled {
+		t.Fatal("expected a nil queue to stay disabled")
+	}
+}
+
+func TestOverSoftLimit(t *testing.T) {
+	budget := &fakeBudget{spend: 85, limit: 100}
+	q := New(NewMemoryStore(), budget)
+	q.Set(&Policy{Enabled: true, SoftLimitPercent: 80})
+	if over, _ := q.OverSoftLimit(context.Background(), "t1"); !over {
+		t.Fatal("expected 85 of 100 to be over an 80% soft limit")
+	}
+	budget.spend = 50
+	if over, _ := q.OverSoftLimit(context.Background(), "t1"); over {
+		t.Fatal("expected 50 of 100 to be under an 80% soft limit")
+	}
+	q.Set(&Policy{Enabled: true})
+	budget.spend = 150
+	if over, _ := q.OverSoftLimit(context.Background(), "t1"); over {
+		t.Fatal("expected no soft limit without soft_limit_percent")
+	}
+}
+
+func TestDeferAndReplay(t *testing.T) {
+	ctx := context.Background()
+	budget := &fakeBudget{spend: 100, limit: 100}
+	q := New(NewMemoryStore(), budget)
+	q.Set(&Policy{Enabled: true, MaxQueued: 2})
+
+	var replayed []string
+	deny := true
+	q.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "" {
+			t.Error("expected credentials not to be stored")
+		}
+		if deny {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"code":"rate_limit_exceeded"}}`))
+			return
+		}
+		replayed = append(replayed, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	}))
+
+	for _, body := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?x=1", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer sk-secret")
+		_, ok, err := q.Defer(ctx, "t1", ReasonOverLimit, r, []byte(body))
+		if err != nil {
+			t.Fatalf("Defer: %v", err)
+		}
+		if want := body != `{"n":3}`; ok != want {
+			t.Fatalf("Defer(%s) = %v, want %v with a queue of 2", body, ok, want)
+		}
+	}
+
+	if n, _ := q.Replay(ctx); n != 0 {
+		t.Fatalf("replayed %d requests while the budget is spent", n)
+	}
+	budget.spend = 10
+	if n, _ := q.Replay(ctx); n != 0 {
+		t.Fatalf("replayed %d requests the limit denied", n)
+	}
+	if n, _ := q.store.DeferredQueueLength(ctx, "t1"); n != 2 {
+		t.Fatalf("queue length = %d, want the denied request requeued", n)
+	}
+
+	deny = false
+	if n, _ := q.Replay(ctx); n != 2 || strings.Join(replayed, ",") != `{"n":1},{"n":2}` {
+		t.Fatalf("replayed %d: %v, want both in order", n, replayed)
+	}
+	tenants, _ := q.store.DeferredTenants(ctx)
+	if n, _ := q.Replay(ctx); n != 0 || len(tenants) != 0 {
+		t.Fatalf("expected the queue drained, got tenants %v", tenants)
+	}
+}
+
+func TestReplayRecordsResponse(t *testing.T) {
+	ctx := context.Background()
+	q := New(NewMemoryStore(), &fakeBudget{limit: 100})
+	q.Set(&Policy{Enabled: true})
+	var notified []Request
+	q.OnComplete = func(ctx context.Context, req Request) { notified = append(notified, req) }
+	q.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != "/v1/messages?beta=true" || r.Header.Get("X-Tenant-ID") != "t1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"msg_1"}`))
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages?beta=true", strings.NewReader(`{}`))
+	r.Header.Set("X-Tenant-ID", "t1")
+	req, _, _ := q.Defer(ctx, "t1", ReasonSoftLimit, r, []byte(`{}`))
+
+	if n, _ := q.Replay(ctx); n != 1 {
+		t.Fatalf("replayed %d, want 1", n)
+	}
+	got, ok, _ := q.Get(ctx, req.ID)
+	if !ok || got.Status != StatusCompleted || got.Response == nil || got.Response.Status != http.StatusOK || string(got.Response.Body) != `{"id":"msg_1"}` {
+		t.Fatalf("Get = %+v, %v", got, ok)
+	}
+	if got.Body != nil || got.Completed.IsZero() {
+		t.Fatalf("expected the request body dropped once completed, got %+v", got)
+	}
+	if len(notified) != 1 || notified[0].ID != req.ID || Status(notified[0])["response"] == nil {
+		t.Fatalf("OnComplete got %+v, want the completed request", notified)
+	}
+}
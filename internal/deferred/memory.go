@@ -0,0 +1,94 @@
+package deferred
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store for a single proxy instance; queued requests are
+// lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	requests map[string]Request
+	queues   map[string][]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{requests: map[string]Request{}, queues: map[string][]string{}}
+}
+
+// DeferRequest stores req at the back of its tenant's queue.
+func (s *MemoryStore) DeferRequest(ctx context.Context, req Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[req.ID] = req
+	s.queues[req.TenantID] = append(s.queues[req.TenantID], req.ID)
+	return nil
+}
+
+// RequeueDeferred puts req at the front of its tenant's queue.
+func (s *MemoryStore) RequeueDeferred(ctx context.Context, req Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[req.ID] = req
+	s.queues[req.TenantID] = slices.Insert(s.queues[req.TenantID], 0, req.ID)
+	return nil
+}
+
+// SaveDeferred updates req.
+func (s *MemoryStore) SaveDeferred(ctx context.Context, req Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[req.ID] = req
+	return nil
+}
+
+// Deferred returns the request with id unless it has expired.
+func (s *MemoryStore) Deferred(ctx context.Context, id string) (Request, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if ok && !time.Now().Before(req.Expires) {
+		delete(s.requests, id)
+		return Request{}, false, nil
+	}
+	return req, ok, nil
+}
+
+// DeferredTenants returns the tenants with queued requests.
+func (s *MemoryStore) DeferredTenants(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenants := make([]string, 0, len(s.queues))
+	for tenantID := range s.queues {
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, nil
+}
+
+// DeferredQueueLength returns how many of the tenant's requests are queued.
+func (s *MemoryStore) DeferredQueueLength(ctx context.Context, tenantID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.queues[tenantID])), nil
+}
+
+// NextDeferred takes the tenant's oldest queued request off its queue.
+func (s *MemoryStore) NextDeferred(ctx context.Context, tenantID string) (Request, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for len(s.queues[tenantID]) > 0 {
+		id := s.queues[tenantID][0]
+		s.queues[tenantID] = s.queues[tenantID][1:]
+		if req, ok := s.requests[id]; ok && now.Before(req.Expires) {
+			return req, true, nil
+		}
+		delete(s.requests, id)
+	}
+	delete(s.queues, tenantID)
+	return Request{}, false, nil
+}
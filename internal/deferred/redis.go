@@ -0,0 +1,144 @@
+package deferred
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tenantsKey is a set of tenants with deferred requests queued.
+const tenantsKey = "deferred_tenants"
+
+// queueKey lists the IDs of a tenant's queued deferred requests, oldest first.
+func queueKey(tenantID string) string {
+	return "deferred_queue:" + tenantID
+}
+
+// requestKey holds one deferred request, and its response once replayed, as
+// JSON.
+func requestKey(id string) string {
+	return "deferred:" + id
+}
+
+// RedisStore is a Store in Redis, shared by every proxy instance.
+type RedisStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisStore returns a store using client.
+func NewRedisStore(client *ratelimit.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// DeferRequest stores req and queues it behind its tenant's other deferred
+// requests.
+func (s *RedisStore) DeferRequest(ctx context.Context, req Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(req.Expires)
+	queue := s.client.Key(queueKey(req.TenantID))
+	// Not a transaction: the keys may live on different cluster slots. The
+	// request is stored before its ID is queued, so a replica popping it always
+	// finds it.
+	if err := s.client.Client().Set(ctx, s.client.Key(requestKey(req.ID)), data, ttl).Err(); err != nil {
+		return err
+	}
+	pipe := s.client.Client().Pipeline()
+	pipe.RPush(ctx, queue, req.ID)
+	pipe.Expire(ctx, queue, ttl)
+	pipe.SAdd(ctx, s.client.Key(tenantsKey), req.TenantID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RequeueDeferred puts req back at the front of its tenant's queue, e.g. when
+// its replay was denied again.
+func (s *RedisStore) RequeueDeferred(ctx context.Context, req Request) error {
+	if err := s.SaveDeferred(ctx, req); err != nil {
+		return err
+	}
+	pipe := s.client.Client().Pipeline()
+	pipe.LPush(ctx, s.client.Key(queueKey(req.TenantID)), req.ID)
+	pipe.SAdd(ctx, s.client.Key(tenantsKey), req.TenantID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SaveDeferred updates a deferred request, e.g. with its response.
+func (s *RedisStore) SaveDeferred(ctx context.Context, req Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.client.Client().Set(ctx, s.client.Key(requestKey(req.ID)), data, time.Until(req.Expires)).Err()
+}
+
+// Deferred returns the deferred request with id.
+func (s *RedisStore) Deferred(ctx context.Context, id string) (Request, bool, error) {
+	var req Request
+	data, err := s.client.Client().Get(ctx, s.client.Key(requestKey(id))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return req, false, nil
+	}
+	if err != nil {
+		return req, false, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, false, err
+	}
+	return req, true, nil
+}
+
+// DeferredTenants returns the tenants with deferred requests queued.
+func (s *RedisStore) DeferredTenants(ctx context.Context) ([]string, error) {
+	tenants, err := s.client.Client().SMembers(ctx, s.client.Key(tenantsKey)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return tenants, err
+}
+
+// DeferredQueueLength returns how many of the tenant's requests are queued.
+func (s *RedisStore) DeferredQueueLength(ctx context.Context, tenantID string) (int64, error) {
+	return s.client.Client().LLen(ctx, s.client.Key(queueKey(tenantID))).Result()
+}
+
+// NextDeferred takes the tenant's oldest queued request off its queue. Each
+// request is taken by one replica only. IDs whose request has expired are
+// skipped. When the queue is empty the tenant is dropped from the set of
+// tenants with queued requests.
+func (s *RedisStore) NextDeferred(ctx context.Context, tenantID string) (Request, bool, error) {
+	queue := s.client.Key(queueKey(tenantID))
+	for {
+		id, err := s.client.Client().LPop(ctx, queue).Result()
+		if errors.Is(err, redis.Nil) {
+			tenants := s.client.Key(tenantsKey)
+			if err := s.client.Client().SRem(ctx, tenants, tenantID).Err(); err != nil {
+				return Request{}, false, err
+			}
+			// A request queued between the pop and the removal would
+			// otherwise wait for the tenant's next deferral.
+			if n, err := s.client.Client().LLen(ctx, queue).Result(); err == nil && n > 0 {
+				s.client.Client().SAdd(ctx, tenants, tenantID)
+			}
+			return Request{}, false, nil
+		}
+		if err != nil {
+			return Request{}, false, err
+		}
+		req, ok, err := s.Deferred(ctx, id)
+		if err != nil {
+			return req, false, err
+		}
+		if ok {
+			return req, true, nil
+		}
+	}
+}
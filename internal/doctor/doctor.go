@@ -0,0 +1,367 @@
+// Package doctor implements the `doctor` subcommand: a one-shot readiness report
+// covering configuration, Redis, RediSearch, the embedding sidecar and provider keys.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"agent-sentinel/internal/chaos"
+	"agent-sentinel/internal/config"
+	"agent-sentinel/internal/egress"
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/secrets"
+
+	"embedding-sidecar/redisconf"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is a single line of the readiness report.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+}
+
+// Options configures which dependencies are checked.
+type Options struct {
+	// Secrets is the credential source; SecretsErr explains why it could not be loaded.
+	Secrets    *secrets.Store
+	SecretsErr error
+
+	// Provider is the resolved upstream provider; ProviderErr explains why it could not be built.
+	Provider    providers.Provider
+	ProviderErr error
+
+	RedisURL          string
+	EmbeddingRedisURL string
+	SidecarUDS        string
+	ConfigDir         string
+
+	HTTPClient *http.Client
+	Timeout    time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: o.Timeout}
+		if o.Provider != nil {
+			if transport, err := egress.NewTransport(o.Provider.Name()); err == nil {
+				o.HTTPClient.Transport = transport
+			}
+		}
+	}
+	return o
+}
+
+// numericEnv lists env vars that must parse as numbers when set.
+var numericEnv = []string{
+	"DEFAULT_SPEND_LIMIT",
+	"ASYNC_OP_LIMIT",
+	"ASYNC_FLUSH_TIMEOUT_SECONDS",
+	"LOOP_EMBEDDING_SIDECAR_TIMEOUT_MS",
+	"RESPONSE_COMPRESSION_MIN_BYTES",
+	"RESERVATION_TTL_SECONDS",
+	"RESERVATION_RECONCILE_INTERVAL_SECONDS",
+	"DLQ_RETRY_INTERVAL_SECONDS",
+	"REDIS_REPLICA_MAX_LAG_SECONDS",
+	"REDIS_REPLICA_CHECK_INTERVAL_SECONDS",
+	"PROVIDER_BACKOFF_MAX_SECONDS",
+	"QUOTA_SYNC_INTERVAL_SECONDS",
+	"QUOTA_SYNC_DRIFT_THRESHOLD",
+	"ESTIMATE_COMPENSATION_ALPHA",
+	"ESTIMATE_COMPENSATION_MAX_FACTOR",
+	"OVERDRAFT_PERCENT",
+	"MAX_REQUEST_COST",
+	"MAX_TOKENS_DEFAULT",
+	"MAX_TOKENS_CAP",
+	"USAGE_LOG_MAX_ENTRIES",
+	"LIMIT_CACHE_TTL_SECONDS",
+	"LIMIT_CACHE_SIZE",
+	"MIRROR_SAMPLE_PERCENT",
+	"MIRROR_TIMEOUT_SECONDS",
+	"MIRROR_MAX_IN_FLIGHT",
+	"SLO_EVAL_INTERVAL_SECONDS",
+	"TOKEN_PREFIX_CACHE_SIZE",
+	"METRICS_TENANT_TOP_N",
+	"ETCD_TIMEOUT_MS",
+	"ESTIMATE_SCAN_MIN_BYTES",
+	"SHED_MAX_GOROUTINES",
+	"SHED_MAX_QUEUE_DEPTH",
+	"SHED_MAX_P99_MS",
+	"SHED_RETRY_AFTER_SECONDS",
+	"SECRETS_REFRESH_SECONDS",
+	"REQUEST_SIGNING_MAX_SKEW_SECONDS",
+	"DEADLINE_MAX_MS",
+	"MODEL_CACHE_TTL_SECONDS",
+	"FAIR_QUEUE_CONCURRENCY",
+	"FAIR_QUEUE_MIN_REMAINING_REQUESTS",
+	"FAIR_QUEUE_MIN_REMAINING_TOKENS",
+	"FAIR_QUEUE_MAX_WAIT_MS",
+	"SMOOTHING_BURST",
+	"SMOOTHING_RATE_PER_SECOND",
+	"REDIS_POOL_SIZE",
+	"REDIS_MIN_IDLE_CONNS",
+	"REDIS_DIAL_TIMEOUT_MS",
+	"REDIS_READ_TIMEOUT_MS",
+	"REDIS_WRITE_TIMEOUT_MS",
+	"REDIS_MAX_RETRIES",
+	"REDIS_MIN_RETRY_BACKOFF_MS",
+	"REDIS_MAX_RETRY_BACKOFF_MS",
+	"PROBE_INTERVAL_SECONDS",
+	"PROBE_TIMEOUT_SECONDS",
+	"PROBE_FAILURE_THRESHOLD",
+}
+
+// Run executes all checks, prints the report to w and returns the process exit code.
+func Run(ctx context.Context, opts Options, w io.Writer) int {
+	opts = opts.withDefaults()
+
+	var results []Result
+	results = append(results, CheckConfig(opts)...)
+	results = append(results, checkRedis(ctx, opts))
+	results = append(results, checkRediSearch(ctx, opts))
+	results = append(results, checkSidecar(ctx, opts))
+	results = append(results, CheckProvider(ctx, opts))
+
+	// Errors can quote request URLs, and Gemini's carry the API key.
+	for i := range results {
+		results[i].Detail = opts.Secrets.Scrub(results[i].Detail)
+	}
+	return PrintReport(w, results)
+}
+
+// PrintReport writes results as a table and returns 1 if any check failed.
+func PrintReport(w io.Writer, results []Result) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+	failed := 0
+	for _, r := range results {
+		if r.Status == StatusFail {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, strings.ToUpper(string(r.Status)), r.Detail)
+	}
+	_ = tw.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(w, "\nNOT READY: %d check(s) failed\n", failed)
+		return 1
+	}
+	fmt.Fprintln(w, "\nREADY")
+	return 0
+}
+
+// CheckConfig validates the secret source, provider selection, egress settings,
+// numeric environment variables and chaos mode.
+func CheckConfig(opts Options) []Result {
+	var results []Result
+	if opts.SecretsErr != nil {
+		results = append(results, Result{Name: "config.secrets", Status: StatusFail, Detail: opts.SecretsErr.Error()})
+	} else if opts.Secrets != nil {
+		results = append(results, Result{Name: "config.secrets", Status: StatusOK, Detail: opts.Secrets.String()})
+	}
+	if opts.ProviderErr != nil {
+		results = append(results, Result{Name: "config.provider", Status: StatusFail, Detail: opts.ProviderErr.Error()})
+	} else if opts.Provider != nil {
+		results = append(results, Result{Name: "config.provider", Status: StatusOK, Detail: opts.Provider.Name()})
+		results = append(results, checkEgress(opts.Provider)...)
+	}
+
+	var invalid []string
+	for _, key := range numericEnv {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			invalid = append(invalid, key)
+		}
+	}
+	if len(invalid) > 0 {
+		results = append(results, Result{Name: "config.env", Status: StatusFail, Detail: "not numeric: " + strings.Join(invalid, ", ")})
+	} else {
+		results = append(results, Result{Name: "config.env", Status: StatusOK, Detail: "numeric settings parse"})
+	}
+
+	if cfg, ok, err := chaos.ConfigFromEnv(); err != nil {
+		results = append(results, Result{Name: "config.chaos", Status: StatusFail, Detail: err.Error()})
+	} else if ok {
+		results = append(results, Result{Name: "config.chaos", Status: StatusWarn, Detail: "CHAOS_MODE injects faults (" + cfg.String() + "); test environments only"})
+	}
+
+	if opts.ConfigDir != "" {
+		if _, err := config.LoadDir(opts.ConfigDir); err != nil {
+			results = append(results, Result{Name: "config.files", Status: StatusFail, Detail: err.Error()})
+		} else {
+			results = append(results, Result{Name: "config.files", Status: StatusOK, Detail: opts.ConfigDir})
+		}
+	}
+	return results
+}
+
+// checkEgress validates the egress proxy, custom CA and guard when any is configured.
+func checkEgress(provider providers.Provider) []Result {
+	if _, err := egress.NewTransport(provider.Name()); err != nil {
+		return []Result{{Name: "config.egress", Status: StatusFail, Detail: err.Error()}}
+	}
+	guard, err := egress.GuardFromEnv(provider.BaseURL())
+	if err != nil {
+		return []Result{{Name: "config.egress", Status: StatusFail, Detail: err.Error()}}
+	}
+	var details []string
+	if proxyURL, _ := egress.ProxyURL(provider.Name()); proxyURL != nil {
+		details = append(details, "proxy "+proxyURL.Redacted())
+	}
+	if os.Getenv("EGRESS_CA_FILE") != "" {
+		details = append(details, "custom CA loaded")
+	}
+	if guard != nil {
+		details = append(details, "guard allows "+provider.BaseURL().Hostname())
+	}
+	if protocol, _ := egress.UpstreamProtocol(); protocol != egress.ProtocolAuto {
+		details = append(details, "protocol "+protocol)
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return []Result{{Name: "config.egress", Status: StatusOK, Detail: strings.Join(details, ", ")}}
+}
+
+func checkRedis(ctx context.Context, opts Options) Result {
+	if opts.RedisURL == "" {
+		return Result{Name: "redis", Status: StatusWarn, Detail: "REDIS_URL not set; rate limiting disabled"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	client, err := ratelimit.ConnectRedis(ctx, opts.RedisURL, redisconf.FromEnv("REDIS_"))
+	if err != nil {
+		return Result{Name: "redis", Status: StatusFail, Detail: fmt.Sprintf("%s: %v", ratelimit.MaskRedisURL(opts.RedisURL), err)}
+	}
+	defer client.Close()
+	detail := fmt.Sprintf("%s (%s)", ratelimit.MaskRedisURL(opts.RedisURL), client.Backend())
+	version, err := ratelimit.ReadSchemaVersion(ctx, client)
+	switch {
+	case err != nil:
+		return Result{Name: "redis", Status: StatusFail, Detail: fmt.Sprintf("%s: read schema version: %v", detail, err)}
+	case version > ratelimit.SchemaVersion():
+		return Result{Name: "redis", Status: StatusFail, Detail: fmt.Sprintf("%s: key schema v%d is newer than this build (v%d)", detail, version, ratelimit.SchemaVersion())}
+	case version < ratelimit.SchemaVersion():
+		detail += fmt.Sprintf(", key schema v%d (migrated to v%d when the proxy starts)", version, ratelimit.SchemaVersion())
+	default:
+		detail += fmt.Sprintf(", key schema v%d", version)
+	}
+	return Result{Name: "redis", Status: StatusOK, Detail: detail}
+}
+
+func checkRediSearch(ctx context.Context, opts Options) Result {
+	if opts.EmbeddingRedisURL == "" {
+		return Result{Name: "redisearch", Status: StatusSkip, Detail: "EMBEDDING_REDIS_URL not set"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	client, err := ratelimit.ConnectRedis(ctx, opts.EmbeddingRedisURL, redisconf.FromEnv("EMBEDDING_REDIS_", "REDIS_"))
+	if err != nil {
+		return Result{Name: "redisearch", Status: StatusFail, Detail: fmt.Sprintf("%s: %v", ratelimit.MaskRedisURL(opts.EmbeddingRedisURL), err)}
+	}
+	defer client.Close()
+	if err := client.Client().Do(ctx, "FT._LIST").Err(); err != nil {
+		return Result{Name: "redisearch", Status: StatusFail, Detail: "RediSearch module unavailable: " + err.Error()}
+	}
+	return Result{Name: "redisearch", Status: StatusOK, Detail: ratelimit.MaskRedisURL(opts.EmbeddingRedisURL)}
+}
+
+func checkSidecar(ctx context.Context, opts Options) Result {
+	if opts.SidecarUDS == "" {
+		return Result{Name: "sidecar", Status: StatusSkip, Detail: "no sidecar socket configured"}
+	}
+	if _, err := os.Stat(opts.SidecarUDS); err != nil {
+		return Result{Name: "sidecar", Status: StatusFail, Detail: fmt.Sprintf("socket %s: %v", opts.SidecarUDS, err)}
+	}
+
+	conn, err := grpc.NewClient("unix://"+opts.SidecarUDS,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", opts.SidecarUDS)
+		}),
+	)
+	if err != nil {
+		return Result{Name: "sidecar", Status: StatusFail, Detail: err.Error()}
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return Result{Name: "sidecar", Status: StatusFail, Detail: "health check: " + err.Error()}
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return Result{Name: "sidecar", Status: StatusFail, Detail: "status " + resp.GetStatus().String()}
+	}
+	return Result{Name: "sidecar", Status: StatusOK, Detail: opts.SidecarUDS}
+}
+
+// CheckProvider makes a cheap authenticated call to verify the provider API key.
+func CheckProvider(ctx context.Context, opts Options) Result {
+	opts = opts.withDefaults()
+	if opts.Provider == nil {
+		return Result{Name: "provider.auth", Status: StatusSkip, Detail: "no provider configured"}
+	}
+	path, ok := probe.ModelsPaths[opts.Provider.Name()]
+	if !ok {
+		return Result{Name: "provider.auth", Status: StatusSkip, Detail: "no probe for " + opts.Provider.Name()}
+	}
+
+	target := *opts.Provider.BaseURL()
+	target.Path = path
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return Result{Name: "provider.auth", Status: StatusFail, Detail: err.Error()}
+	}
+	opts.Provider.PrepareRequest(req)
+
+	resp, err := opts.HTTPClient.Do(req)
+	if err != nil {
+		return Result{Name: "provider.auth", Status: StatusFail, Detail: err.Error()}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Result{Name: "provider.auth", Status: StatusFail, Detail: fmt.Sprintf("%s rejected API key (HTTP %d)", opts.Provider.Name(), resp.StatusCode)}
+	case resp.StatusCode >= http.StatusBadRequest:
+		return Result{Name: "provider.auth", Status: StatusWarn, Detail: fmt.Sprintf("%s returned HTTP %d", opts.Provider.Name(), resp.StatusCode)}
+	}
+	return Result{Name: "provider.auth", Status: StatusOK, Detail: opts.Provider.Name()}
+}
@@ -0,0 +1,112 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/providers"
+)
+
+type fakeProvider struct {
+	base *url.URL
+}
+
+func (f fakeProvider) Name() string      { return "openai" }
+func (f fakeProvider) BaseURL() *url.URL { return f.base }
+func (f fakeProvider) PrepareRequest(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer good-key")
+}
+func (f fakeProvider) InjectHint(map[string]any, string) bool     { return false }
+func (f fakeProvider) ExtractModelFromPath(path string) string    { return "" }
+func (f fakeProvider) ExtractPrompt(body map[string]any) string   { return "" }
+func (f fakeProvider) ExtractFullText(body map[string]any) string { return "" }
+func (f fakeProvider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
+	return providers.TokenUsage{}
+}
+
+func TestCheckProviderAuth(t *testing.T) {
+	wantAuth := "Bearer good-key"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("unexpected probe path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != wantAuth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+	base, _ := url.Parse(server.URL)
+
+	res := CheckProvider(context.Background(), Options{Provider: fakeProvider{base: base}, HTTPClient: server.Client()})
+	if res.Status != StatusOK {
+		t.Fatalf("expected ok, got %+v", res)
+	}
+
+	wantAuth = "Bearer other-key"
+	res = CheckProvider(context.Background(), Options{Provider: fakeProvider{base: base}, HTTPClient: server.Client()})
+	if res.Status != StatusFail {
+		t.Fatalf("expected fail on 401, got %+v", res)
+	}
+}
+
+func TestCheckConfigFlagsInvalidEnv(t *testing.T) {
+	t.Setenv("DEFAULT_SPEND_LIMIT", "lots")
+	results := CheckConfig(Options{ProviderErr: errors.New("OPENAI_API_KEY environment variable is not set")})
+	var failed int
+	for _, r := range results {
+		if r.Status == StatusFail {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("expected provider and env failures, got %+v", results)
+	}
+}
+
+func TestPrintReportExitCode(t *testing.T) {
+	var buf bytes.Buffer
+	if code := PrintReport(&buf, []Result{{Name: "redis", Status: StatusOK}}); code != 0 {
+		t.Fatalf("expected exit 0, got %d", code)
+	}
+	if !strings.Contains(buf.String(), "READY") {
+		t.Fatalf("expected READY in report: %s", buf.String())
+	}
+
+	buf.Reset()
+	if code := PrintReport(&buf, []Result{{Name: "redis", Status: StatusFail, Detail: "down"}}); code != 1 {
+		t.Fatalf("expected exit 1, got %d", code)
+	}
+	if !strings.Contains(buf.String(), "NOT READY") {
+		t.Fatalf("expected NOT READY in report: %s", buf.String())
+	}
+}
+
+func TestCheckConfigWarnsOnChaosMode(t *testing.T) {
+	t.Setenv("CHAOS_MODE", "true")
+	t.Setenv("CHAOS_UPSTREAM_5XX_PERCENT", "5")
+	var chaos *Result
+	results := CheckConfig(Options{})
+	for i := range results {
+		if results[i].Name == "config.chaos" {
+			chaos = &results[i]
+		}
+	}
+	if chaos == nil || chaos.Status != StatusWarn || !strings.Contains(chaos.Detail, "upstream_5xx 5%") {
+		t.Fatalf("expected chaos mode warning, got %+v", results)
+	}
+
+	t.Setenv("CHAOS_UPSTREAM_5XX_PERCENT", "150")
+	for _, r := range CheckConfig(Options{}) {
+		if r.Name == "config.chaos" && r.Status != StatusFail {
+			t.Fatalf("expected invalid chaos percent to fail, got %+v", r)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package egress configures outbound connections for networks that require an
+// egress proxy and/or a private root CA.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ProxyURL returns the egress proxy configured for provider: <PROVIDER>_PROXY_URL
+// (e.g. OPENAI_PROXY_URL), then EGRESS_PROXY_URL. Supported schemes are http,
+// https, socks5 and socks5h. Returns nil when neither is set, in which case the
+// standard HTTPS_PROXY/NO_PROXY variables still apply.
+func ProxyURL(provider string) (*url.URL, error) {
+	raw := os.Getenv(strings.ToUpper(provider) + "_PROXY_URL")
+	if raw == "" {
+		raw = os.Getenv("EGRESS_PROXY_URL")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, socks5 or socks5h)", u.Scheme)
+	}
+}
+
+var (
+	rootCAsOnce sync.Once
+	rootCAs     *x509.CertPool
+	rootCAsErr  error
+)
+
+// RootCAs returns the system roots plus the PEM certificates listed in
+// EGRESS_CA_FILE (comma-separated paths). Returns nil when EGRESS_CA_FILE is
+// unset, meaning the system roots are used as is. Loaded once per process.
+func RootCAs() (*x509.CertPool, error) {
+	rootCAsOnce.Do(func() {
+		rootCAs, rootCAsErr = loadRootCAs(os.Getenv("EGRESS_CA_FILE"))
+	})
+	return rootCAs, rootCAsErr
+}
+
+func loadRootCAs(files string) (*x509.CertPool, error) {
+	if files == "" {
+		return nil, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range strings.Split(files, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// TLSConfig returns a client TLS config trusting RootCAs, or nil when no custom
+// CA is configured.
+func TLSConfig() (*tls.Config, error) {
+	pool, err := RootCAs()
+	if err != nil || pool == nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// NewTransport returns a clone of http.DefaultTransport routed through the
+// provider's egress proxy and trusting the custom CA, when configured. It speaks
+// the protocols selected by UPSTREAM_PROTOCOL.
+func NewTransport(provider string) (*http.Transport, error) {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("http.DefaultTransport is not an *http.Transport")
+	}
+	t := base.Clone()
+
+	protocol, err := UpstreamProtocol()
+	if err != nil {
+		return nil, err
+	}
+	configureProtocols(t, protocol)
+
+	proxyURL, err := ProxyURL(provider)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	return t, nil
+}
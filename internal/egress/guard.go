@@ -0,0 +1,165 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"agent-sentinel/internal/telemetry"
+)
+
+// ErrBlocked is returned for connections outside the egress allowlist.
+var ErrBlocked = errors.New("egress blocked")
+
+// Guard restricts outbound connections to allowlisted upstream hosts. Hostnames
+// are checked per request; the resolved address is checked again at dial time,
+// so a hostname that resolves (or is rebound) to a private address is blocked
+// unless that range is allowlisted.
+type Guard struct {
+	hosts []string // exact names, or "*.example.com" for subdomains
+	cidrs []netip.Prefix
+}
+
+// GuardFromEnv returns a guard when EGRESS_GUARD_ENABLED is true; nil otherwise.
+// The upstream host is always allowed; EGRESS_ALLOWED_HOSTS (comma-separated,
+// "*.example.com" wildcards) and EGRESS_ALLOWED_CIDRS add to it.
+func GuardFromEnv(upstream *url.URL) (*Guard, error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv("EGRESS_GUARD_ENABLED")); !enabled {
+		return nil, nil
+	}
+	return NewGuard(upstream.Hostname(), os.Getenv("EGRESS_ALLOWED_HOSTS"), os.Getenv("EGRESS_ALLOWED_CIDRS"))
+}
+
+// NewGuard builds a guard from an upstream host and comma-separated extra hosts and CIDRs.
+func NewGuard(upstreamHost, hosts, cidrs string) (*Guard, error) {
+	g := &Guard{}
+	if upstreamHost != "" {
+		g.hosts = append(g.hosts, strings.ToLower(upstreamHost))
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			g.hosts = append(g.hosts, h)
+		}
+	}
+	for _, c := range strings.Split(cidrs, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("parse EGRESS_ALLOWED_CIDRS: %w", err)
+		}
+		g.cidrs = append(g.cidrs, prefix.Masked())
+	}
+	return g, nil
+}
+
+// AllowHost reports whether host (without port) may be contacted.
+func (g *Guard) AllowHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return g.inCIDRs(ip) || g.hostListed(host)
+	}
+	return g.hostListed(host)
+}
+
+func (g *Guard) hostListed(host string) bool {
+	for _, allowed := range g.hosts {
+		if allowed == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowAddr reports whether a resolved address may be dialed: allowlisted ranges
+// always, otherwise only publicly routable addresses.
+func (g *Guard) AllowAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if g.inCIDRs(ip) {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func (g *Guard) inCIDRs(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range g.cidrs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap enforces the guard on t. The dial-time address check is skipped when
+// upstream traffic goes through an egress proxy, since the proxy is then the
+// only address dialed and it does the resolving.
+func (g *Guard) Wrap(t *http.Transport, upstream *url.URL) http.RoundTripper {
+	if g == nil {
+		return t
+	}
+	if !usesProxy(t, upstream) {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   g.control,
+		}
+		t.DialContext = dialer.DialContext
+	}
+	return &guardedTransport{guard: g, base: t}
+}
+
+func usesProxy(t *http.Transport, upstream *url.URL) bool {
+	if t.Proxy == nil || upstream == nil {
+		return false
+	}
+	proxyURL, err := t.Proxy(&http.Request{URL: upstream})
+	return err == nil && proxyURL != nil
+}
+
+// control runs after DNS resolution with the address actually being dialed.
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: unparseable address %s", ErrBlocked, address)
+	}
+	if !g.AllowAddr(addrPort.Addr()) {
+		blocked("address", address)
+		return fmt.Errorf("%w: %s is not an allowed address", ErrBlocked, address)
+	}
+	return nil
+}
+
+type guardedTransport struct {
+	guard *Guard
+	base  http.RoundTripper
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if host := req.URL.Hostname(); !t.guard.AllowHost(host) {
+		blocked("host", host)
+		return nil, fmt.Errorf("%w: host %s is not allowlisted", ErrBlocked, host)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func blocked(reason, target string) {
+	slog.Warn("Egress blocked", "reason", reason, "target", target)
+	telemetry.IncEgressBlocked(context.Background(), reason)
+}
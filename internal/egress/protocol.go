@@ -0,0 +1,62 @@
+package egress
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Upstream protocols selected with UPSTREAM_PROTOCOL.
+const (
+	// ProtocolAuto negotiates HTTP/2 over TLS (ALPN), falling back to HTTP/1.1.
+	ProtocolAuto = "auto"
+	// ProtocolHTTP1 disables HTTP/2.
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2C speaks HTTP/2 without TLS (prior knowledge) to http:// upstreams,
+	// for internal OpenAI-compatible backends. https:// upstreams must then
+	// support HTTP/2.
+	ProtocolH2C = "h2c"
+)
+
+// UpstreamProtocol returns UPSTREAM_PROTOCOL (default auto).
+func UpstreamProtocol() (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("UPSTREAM_PROTOCOL"))); p {
+	case "":
+		return ProtocolAuto, nil
+	case ProtocolAuto, ProtocolHTTP1, ProtocolH2C:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported UPSTREAM_PROTOCOL %q (use auto, http1 or h2c)", p)
+	}
+}
+
+// h2PingInterval is how long an idle HTTP/2 connection waits before sending a
+// health-check ping; a connection that misses it is closed so streams
+// multiplexed on it fail fast instead of hanging.
+const (
+	h2PingInterval = 30 * time.Second
+	h2PingTimeout  = 15 * time.Second
+)
+
+// configureProtocols applies protocol to t.
+func configureProtocols(t *http.Transport, protocol string) {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+		t.ForceAttemptHTTP2 = false
+	case ProtocolH2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		t.ForceAttemptHTTP2 = true
+	}
+	t.Protocols = protocols
+	if protocols.HTTP2() || protocols.UnencryptedHTTP2() {
+		t.HTTP2 = &http.HTTP2Config{SendPingTimeout: h2PingInterval, PingTimeout: h2PingTimeout}
+	}
+}
@@ -0,0 +1,285 @@
+// Package events keeps a bounded in-memory record of notable proxy decisions
+// (denials, loop detections) and per-provider latency for the admin API.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types recorded by the proxy.
+const (
+	TypeRateLimitDenied = "rate_limit_denied"
+	TypeLoopDetected    = "loop_detected"
+	TypeAdminAction     = "admin_action"
+	TypeQuotaDrift      = "quota_drift"
+	TypeSLOAlert        = "slo_alert"
+	TypeLoopBypass      = "loop_bypass"
+	// TypeProviderOperation records a denied or mutating non-POST provider
+	// request, e.g. deleting a file.
+	TypeProviderOperation = "provider_operation"
+	// TypeProviderHealth records a provider key changing health state, as
+	// seen by the prober.
+	TypeProviderHealth = "provider_health"
+	// TypeFileUpload records a file upload to the provider, allowed or not.
+	TypeFileUpload = "file_upload"
+	// TypeRequestDeferred records a low-priority request queued until its
+	// tenant's budget frees up, and its replay.
+	TypeRequestDeferred = "request_deferred"
+	// TypeCallbackFailed records a callback delivery given up on after its
+	// last retry.
+	TypeCallbackFailed = "callback_failed"
+	// TypeMCPToolCall records a tool call sent to an MCP server through the
+	// proxy, forwarded or refused.
+	TypeMCPToolCall = "mcp_tool_call"
+)
+
+// Event is a single recorded decision.
+type Event struct {
+	ID       uint64         `json:"id"`
+	Type     string         `json:"type"`
+	TenantID string         `json:"tenant_id,omitempty"`
+	Time     time.Time      `json:"time"`
+	Detail   map[string]any `json:"detail,omitempty"`
+}
+
+// LatencyPoint is the average provider latency for one minute.
+type LatencyPoint struct {
+	Minute time.Time `json:"minute"`
+	AvgMs  float64   `json:"avg_ms"`
+	Count  int64     `json:"count"`
+}
+
+const (
+	defaultCapacity = 1000
+	latencyMinutes  = 60
+)
+
+type latencyBucket struct {
+	minute  int64
+	totalMs float64
+	count   int64
+}
+
+type finishBucket struct {
+	minute int64
+	counts map[string]int64
+}
+
+// FinishReasonStats counts a tenant's response finish reasons over the last hour.
+type FinishReasonStats struct {
+	Counts map[string]int64 `json:"counts"`
+	Total  int64            `json:"total"`
+	// TruncationRate is the share of responses cut off at the output token
+	// limit ("length").
+	TruncationRate float64 `json:"truncation_rate"`
+}
+
+// finishLength is providers.FinishLength.
+const finishLength = "length"
+
+// Recorder stores the most recent events in a ring buffer, and per-minute latency
+// buckets per provider and finish reason buckets per tenant. Safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	events  []Event
+	next    int
+	full    bool
+	lastID  uint64
+	latency map[string]*[latencyMinutes]latencyBucket
+	finish  map[string]*[latencyMinutes]finishBucket
+	now     func() time.Time
+
+	watchers map[chan Event]struct{}
+}
+
+// NewRecorder creates a recorder holding up to capacity events.
+func NewRecorder(capacity int) *Recorder {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	return &Recorder{
+		events:   make([]Event, capacity),
+		latency:  make(map[string]*[latencyMinutes]latencyBucket),
+		finish:   make(map[string]*[latencyMinutes]finishBucket),
+		now:      time.Now,
+		watchers: make(map[chan Event]struct{}),
+	}
+}
+
+var defaultRecorder = NewRecorder(defaultCapacity)
+
+// Default returns the process-wide recorder.
+func Default() *Recorder {
+	return defaultRecorder
+}
+
+// ObserveFinishReason records a response finish reason on the default recorder.
+func ObserveFinishReason(tenantID, reason string) {
+	defaultRecorder.ObserveFinishReason(tenantID, reason)
+}
+
+// Record appends an event to the default recorder.
+func Record(eventType, tenantID string, detail map[string]any) {
+	defaultRecorder.Record(eventType, tenantID, detail)
+}
+
+// ObserveLatency records a provider call latency on the default recorder.
+func ObserveLatency(provider string, d time.Duration) {
+	defaultRecorder.ObserveLatency(provider, d)
+}
+
+// Record appends an event, evicting the oldest when full.
+func (r *Recorder) Record(eventType, tenantID string, detail map[string]any) {
+	r.mu.Lock()
+	r.lastID++
+	ev := Event{ID: r.lastID, Type: eventType, TenantID: tenantID, Time: r.now().UTC(), Detail: detail}
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+	for ch := range r.watchers {
+		// Slow watchers miss events rather than blocking the request path.
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	r.mu.Unlock()
+}
+
+// Watch returns a channel receiving every subsequently recorded event and a
+// function that stops the subscription. Events are dropped when the buffer is full.
+func (r *Recorder) Watch(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, max(buffer, 1))
+	r.mu.Lock()
+	r.watchers[ch] = struct{}{}
+	r.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.watchers, ch)
+			r.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Recent returns up to limit events, newest first, optionally filtered by type.
+func (r *Recorder) Recent(limit int, eventType string) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	size := r.next
+	if r.full {
+		size = len(r.events)
+	}
+	out := make([]Event, 0, min(limit, size))
+	for i := 0; i < size && len(out) < limit; i++ {
+		idx := (r.next - 1 - i + len(r.events)) % len(r.events)
+		ev := r.events[idx]
+		if eventType != "" && ev.Type != eventType {
+			continue
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// ObserveLatency adds a latency sample to the provider's current minute bucket.
+func (r *Recorder) ObserveLatency(provider string, d time.Duration) {
+	if provider == "" {
+		return
+	}
+	minute := r.now().Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buckets, ok := r.latency[provider]
+	if !ok {
+		buckets = &[latencyMinutes]latencyBucket{}
+		r.latency[provider] = buckets
+	}
+	b := &buckets[minute%latencyMinutes]
+	if b.minute != minute {
+		*b = latencyBucket{minute: minute}
+	}
+	b.totalMs += float64(d.Microseconds()) / 1000
+	b.count++
+}
+
+// LatencySeries returns the last hour of per-minute average latency per provider,
+// oldest first. Minutes without samples are omitted.
+func (r *Recorder) LatencySeries() map[string][]LatencyPoint {
+	current := r.now().Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string][]LatencyPoint, len(r.latency))
+	for provider, buckets := range r.latency {
+		var series []LatencyPoint
+		for m := current - latencyMinutes + 1; m <= current; m++ {
+			b := buckets[m%latencyMinutes]
+			if b.minute != m || b.count == 0 {
+				continue
+			}
+			series = append(series, LatencyPoint{
+				Minute: time.Unix(m*60, 0).UTC(),
+				AvgMs:  b.totalMs / float64(b.count),
+				Count:  b.count,
+			})
+		}
+		out[provider] = series
+	}
+	return out
+}
+
+// ObserveFinishReason counts a normalized finish reason (providers.Finish*) in
+// the tenant's current minute bucket.
+func (r *Recorder) ObserveFinishReason(tenantID, reason string) {
+	if tenantID == "" || reason == "" {
+		return
+	}
+	minute := r.now().Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buckets, ok := r.finish[tenantID]
+	if !ok {
+		buckets = &[latencyMinutes]finishBucket{}
+		r.finish[tenantID] = buckets
+	}
+	b := &buckets[minute%latencyMinutes]
+	if b.minute != minute || b.counts == nil {
+		*b = finishBucket{minute: minute, counts: make(map[string]int64)}
+	}
+	b.counts[reason]++
+}
+
+// FinishReasons returns each tenant's finish reasons over the last hour.
+// Tenants without responses in that hour are omitted.
+func (r *Recorder) FinishReasons() map[string]FinishReasonStats {
+	current := r.now().Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]FinishReasonStats, len(r.finish))
+	for tenantID, buckets := range r.finish {
+		stats := FinishReasonStats{Counts: make(map[string]int64)}
+		for m := current - latencyMinutes + 1; m <= current; m++ {
+			b := buckets[m%latencyMinutes]
+			if b.minute != m {
+				continue
+			}
+			for reason, n := range b.counts {
+				stats.Counts[reason] += n
+				stats.Total += n
+			}
+		}
+		if stats.Total == 0 {
+			continue
+		}
+		stats.TruncationRate = float64(stats.Counts[finishLength]) / float64(stats.Total)
+		out[tenantID] = stats
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentNewestFirstWithEviction(t *testing.T) {
+	r := NewRecorder(3)
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		r.Record(TypeRateLimitDenied, tenant, nil)
+	}
+
+	got := r.Recent(10, "")
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events after eviction, got %d", len(got))
+	}
+	if got[0].TenantID != "d" || got[2].TenantID != "b" {
+		t.Fatalf("expected newest first [d c b], got %s %s %s", got[0].TenantID, got[1].TenantID, got[2].TenantID)
+	}
+	if got[0].ID != 4 {
+		t.Fatalf("expected id 4, got %d", got[0].ID)
+	}
+}
+
+func TestRecentFiltersByType(t *testing.T) {
+	r := NewRecorder(10)
+	r.Record(TypeRateLimitDenied, "a", nil)
+	r.Record(TypeLoopDetected, "b", map[string]any{"max_similarity": 0.97})
+	r.Record(TypeRateLimitDenied, "c", nil)
+
+	got := r.Recent(10, TypeLoopDetected)
+	if len(got) != 1 || got[0].TenantID != "b" {
+		t.Fatalf("expected only loop event for b, got %+v", got)
+	}
+	if got := r.Recent(1, TypeRateLimitDenied); len(got) != 1 || got[0].TenantID != "c" {
+		t.Fatalf("expected limit to return newest denial, got %+v", got)
+	}
+}
+
+func TestLatencySeries(t *testing.T) {
+	r := NewRecorder(10)
+	now := time.Unix(1_700_000_000, 0)
+	r.now = func() time.Time { return now }
+
+	r.ObserveLatency("openai", 100*time.Millisecond)
+	r.ObserveLatency("openai", 300*time.Millisecond)
+	now = now.Add(time.Minute)
+	r.ObserveLatency("openai", 50*time.Millisecond)
+	r.ObserveLatency("", time.Second)
+
+	series := r.LatencySeries()
+	if len(series) != 1 {
+		t.Fatalf("expected one provider, got %d", len(series))
+	}
+	points := series["openai"]
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(points))
+	}
+	if points[0].AvgMs != 200 || points[0].Count != 2 {
+		t.Fatalf("unexpected first point %+v", points[0])
+	}
+	if points[1].AvgMs != 50 {
+		t.Fatalf("unexpected second point %+v", points[1])
+	}
+
+	// Samples older than an hour fall out of the series.
+	now = now.Add(2 * time.Hour)
+	if points := r.LatencySeries()["openai"]; len(points) != 0 {
+		t.Fatalf("expected stale buckets to be dropped, got %+v", points)
+	}
+}
+
+func TestFinishReasonsTruncationRate(t *testing.T) {
+	r := NewRecorder(10)
+	now := time.Unix(1_700_000_000, 0)
+	r.now = func() time.Time { return now }
+
+	r.ObserveFinishReason("acme", "stop")
+	r.ObserveFinishReason("acme", "length")
+	now = now.Add(time.Minute)
+	r.ObserveFinishReason("acme", "length")
+	r.ObserveFinishReason("acme", "tool_calls")
+	r.ObserveFinishReason("", "length")
+
+	stats := r.FinishReasons()
+	acme, ok := stats["acme"]
+	if len(stats) != 1 || !ok {
+		t.Fatalf("expected only acme, got %+v", stats)
+	}
+	if acme.Total != 4 || acme.Counts["length"] != 2 || acme.TruncationRate != 0.5 {
+		t.Fatalf("unexpected stats %+v", acme)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if stats := r.FinishReasons(); len(stats) != 0 {
+		t.Fatalf("expected stale buckets to be dropped, got %+v", stats)
+	}
+}
+
+func TestWatchReceivesNewEvents(t *testing.T) {
+	r := NewRecorder(10)
+	r.Record(TypeRateLimitDenied, "before", nil)
+
+	ch, stop := r.Watch(4)
+	r.Record(TypeLoopDetected, "after", nil)
+
+	select {
+	case ev := <-ch:
+		if ev.TenantID != "after" {
+			t.Fatalf("expected only events recorded after Watch, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+
+	stop()
+	stop()
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected channel to be closed after stop")
+	}
+	r.Record(TypeLoopDetected, "ignored", nil)
+}
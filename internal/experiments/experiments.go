@@ -0,0 +1,219 @@
+// Package experiments splits tenants between model variants (A/B tests) and
+// tracks cost, latency and errors per variant.
+package experiments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Variant is one arm of an experiment.
+type Variant struct {
+	Name string `json:"name"`
+	// Model replaces the requested model for tenants assigned to this variant.
+	Model string `json:"model"`
+	// Weight is the variant's relative share of tenants.
+	Weight int `json:"weight"`
+}
+
+// Experiment routes requests for MatchModel to one of its variants. Assignment is
+// per tenant and sticky: a tenant always gets the same variant while the
+// experiment's name and weights are unchanged.
+type Experiment struct {
+	Name       string `json:"name"`
+	MatchModel string `json:"match_model"`
+	// Tenants, when set, limits the experiment to these tenants.
+	Tenants  []string  `json:"tenants,omitempty"`
+	Variants []Variant `json:"variants"`
+}
+
+// Validate checks an experiment definition.
+func (e Experiment) Validate() error {
+	if e.Name == "" || e.MatchModel == "" {
+		return errors.New("experiment needs a name and match_model")
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("experiment %q needs at least two variants", e.Name)
+	}
+	seen := map[string]bool{}
+	for _, v := range e.Variants {
+		if v.Name == "" || v.Model == "" || v.Weight < 0 {
+			return fmt.Errorf("experiment %q: variants need a name, a model and a non-negative weight", e.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("experiment %q: duplicate variant %q", e.Name, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// Store persists per-variant outcomes so every replica contributes to, and
+// reports, the same totals.
+type Store interface {
+	RecordExperiment(ctx context.Context, experiment, variant string, cost float64, latency time.Duration, failed bool) error
+	ExperimentStats(ctx context.Context, experiment string, variants []string) (map[string]VariantStats, error)
+}
+
+// VariantStats are the accumulated outcomes for one variant.
+type VariantStats struct {
+	Requests       int64   `json:"requests"`
+	Errors         int64   `json:"errors"`
+	TotalCost      float64 `json:"total_cost"`
+	TotalLatencyMs float64 `json:"total_latency_ms"`
+}
+
+// Assignment is the variant chosen for one request.
+type Assignment struct {
+	Experiment string
+	Variant    string
+	Model      string
+
+	store Store
+}
+
+// Observe records the request's outcome for the variant. Safe on a nil Assignment.
+func (a *Assignment) Observe(cost float64, latency time.Duration, failed bool) {
+	if a == nil || a.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := a.store.RecordExperiment(ctx, a.Experiment, a.Variant, cost, latency, failed); err != nil {
+		slog.Debug("Failed to record experiment outcome", "error", err, "experiment", a.Experiment, "variant", a.Variant)
+	}
+}
+
+// Registry holds the active experiments. Safe for concurrent use.
+type Registry struct {
+	store Store
+
+	mu          sync.RWMutex
+	experiments []Experiment
+}
+
+// NewRegistry returns an empty registry recording outcomes to store (may be nil).
+func NewRegistry(store Store) *Registry {
+	return &Registry{store: store}
+}
+
+// Set replaces the active experiments.
+func (r *Registry) Set(experiments []Experiment) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.experiments = experiments
+	r.mu.Unlock()
+}
+
+// Assign returns the variant for tenantID when an experiment matches model.
+func (r *Registry) Assign(tenantID, model string) (*Assignment, bool) {
+	if r == nil || tenantID == "" || model == "" {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.experiments {
+		if e.MatchModel != model || !e.includes(tenantID) {
+			continue
+		}
+		v, ok := e.pick(tenantID)
+		if !ok {
+			continue
+		}
+		return &Assignment{Experiment: e.Name, Variant: v.Name, Model: v.Model, store: r.store}, true
+	}
+	return nil, false
+}
+
+func (e Experiment) includes(tenantID string) bool {
+	if len(e.Tenants) == 0 {
+		return true
+	}
+	for _, t := range e.Tenants {
+		if t == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// pick hashes the tenant into the cumulative weight ranges.
+func (e Experiment) pick(tenantID string) (Variant, bool) {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return Variant{}, false
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(e.Name + "/" + tenantID))
+	point := int(h.Sum32() % uint32(total))
+	for _, v := range e.Variants {
+		if point < v.Weight {
+			return v, true
+		}
+		point -= v.Weight
+	}
+	return Variant{}, false
+}
+
+// VariantReport compares one variant's outcomes.
+type VariantReport struct {
+	Variant
+	VariantStats
+	ErrorRate    float64 `json:"error_rate"`
+	AvgCost      float64 `json:"avg_cost"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// Report is an experiment with per-variant comparisons.
+type Report struct {
+	Name       string          `json:"name"`
+	MatchModel string          `json:"match_model"`
+	Variants   []VariantReport `json:"variants"`
+}
+
+// Report returns every active experiment with its per-variant outcomes.
+func (r *Registry) Report(ctx context.Context) ([]Report, error) {
+	if r == nil {
+		return nil, nil
+	}
+	r.mu.RLock()
+	active := append([]Experiment(nil), r.experiments...)
+	r.mu.RUnlock()
+
+	reports := make([]Report, 0, len(active))
+	for _, e := range active {
+		var stats map[string]VariantStats
+		if r.store != nil {
+			names := make([]string, len(e.Variants))
+			for i, v := range e.Variants {
+				names[i] = v.Name
+			}
+			var err error
+			if stats, err = r.store.ExperimentStats(ctx, e.Name, names); err != nil {
+				return nil, err
+			}
+		}
+		report := Report{Name: e.Name, MatchModel: e.MatchModel}
+		for _, v := range e.Variants {
+			vr := VariantReport{Variant: v, VariantStats: stats[v.Name]}
+			if vr.Requests > 0 {
+				vr.ErrorRate = float64(vr.Errors) / float64(vr.Requests)
+				vr.AvgCost = vr.TotalCost / float64(vr.Requests)
+				vr.AvgLatencyMs = vr.TotalLatencyMs / float64(vr.Requests)
+			}
+			report.Variants = append(report.Variants, vr)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,114 @@
+package experiments
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	stats map[string]VariantStats
+}
+
+func (f *fakeStore) RecordExperiment(ctx context.Context, experiment, variant string, cost float64, latency time.Duration, failed bool) error {
+	s := f.stats[variant]
+	s.Requests++
+	if failed {
+		s.Errors++
+	}
+	s.TotalCost += cost
+	s.TotalLatencyMs += float64(latency.Milliseconds())
+	f.stats[variant] = s
+	return nil
+}
+
+func (f *fakeStore) ExperimentStats(ctx context.Context, experiment string, variants []string) (map[string]VariantStats, error) {
+	return f.stats, nil
+}
+
+func miniTest() Experiment {
+	return Experiment{
+		Name:       "mini",
+		MatchModel: "gpt-4o",
+		Variants: []Variant{
+			{Name: "control", Model: "gpt-4o", Weight: 50},
+			{Name: "mini", Model: "gpt-4o-mini", Weight: 50},
+		},
+	}
+}
+
+func TestAssignIsStickyAndWeighted(t *testing.T) {
+	r := NewRegistry(nil)
+	r.Set([]Experiment{miniTest()})
+
+	counts := map[string]int{}
+	for i := range 1000 {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		a, ok := r.Assign(tenant, "gpt-4o")
+		if !ok {
+			t.Fatalf("expected assignment for %s", tenant)
+		}
+		again, _ := r.Assign(tenant, "gpt-4o")
+		if again.Variant != a.Variant {
+			t.Fatalf("assignment for %s not sticky: %s then %s", tenant, a.Variant, again.Variant)
+		}
+		counts[a.Variant]++
+	}
+	if counts["control"] < 400 || counts["mini"] < 400 {
+		t.Fatalf("expected a roughly even split, got %v", counts)
+	}
+
+	if _, ok := r.Assign("tenant-1", "gpt-4o-mini"); ok {
+		t.Fatal("expected no assignment for a model outside the experiment")
+	}
+}
+
+func TestAssignHonorsTenantFilter(t *testing.T) {
+	e := miniTest()
+	e.Tenants = []string{"acme"}
+	r := NewRegistry(nil)
+	r.Set([]Experiment{e})
+	if _, ok := r.Assign("acme", "gpt-4o"); !ok {
+		t.Fatal("expected acme to be enrolled")
+	}
+	if _, ok := r.Assign("globex", "gpt-4o"); ok {
+		t.Fatal("expected globex to be excluded")
+	}
+}
+
+func TestReportComparesVariants(t *testing.T) {
+	store := &fakeStore{stats: map[string]VariantStats{}}
+	r := NewRegistry(store)
+	r.Set([]Experiment{miniTest()})
+
+	a := &Assignment{Experiment: "mini", Variant: "mini", store: store}
+	a.Observe(0.02, 100*time.Millisecond, false)
+	a.Observe(0, 300*time.Millisecond, true)
+
+	reports, err := r.Report(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(reports) != 1 || len(reports[0].Variants) != 2 {
+		t.Fatalf("unexpected reports %+v", reports)
+	}
+	mini := reports[0].Variants[1]
+	if mini.Requests != 2 || mini.ErrorRate != 0.5 || mini.AvgCost != 0.01 || mini.AvgLatencyMs != 200 {
+		t.Fatalf("unexpected variant report %+v", mini)
+	}
+	if control := reports[0].Variants[0]; control.Requests != 0 || control.Model != "gpt-4o" {
+		t.Fatalf("unexpected control report %+v", control)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	e := miniTest()
+	e.Variants[1].Name = "control"
+	if err := e.Validate(); err == nil {
+		t.Fatal("expected duplicate variant error")
+	}
+	if err := (Experiment{Name: "x", MatchModel: "m", Variants: []Variant{{Name: "a", Model: "m"}}}).Validate(); err == nil {
+		t.Fatal("expected error for a single variant")
+	}
+}
@@ -0,0 +1,285 @@
+// Package fairness shares a contended provider API key between tenants. While
+// the provider reports the key close to its request or token limit, requests
+// are admitted through a weighted fair queue: each tenant gets upstream slots
+// in proportion to its tier's weight, so one busy agent cannot take the
+// remaining capacity from everyone else on a first-come-first-served basis.
+package fairness
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/override"
+)
+
+// DefaultTier is the tier of tenants not assigned one.
+const DefaultTier = "default"
+
+const defaultMaxWait = 10 * time.Second
+
+// ErrQueueTimeout is returned when a request waited MaxWaitMs without being
+// admitted.
+var ErrQueueTimeout = errors.New("fair queue wait exceeded")
+
+// Policy configures the scheduler.
+type Policy struct {
+	// Concurrency is how many requests may be in flight upstream while the key
+	// is contended; zero disables scheduling.
+	Concurrency int `json:"concurrency"`
+	// MinRemainingRequests and MinRemainingTokens mark the key as contended
+	// once the provider reports that few requests or tokens left in the
+	// current window.
+	MinRemainingRequests int `json:"min_remaining_requests,omitempty"`
+	MinRemainingTokens   int `json:"min_remaining_tokens,omitempty"`
+	// MaxWaitMs bounds how long a request waits for a slot (default 10000).
+	MaxWaitMs int `json:"max_wait_ms,omitempty"`
+	// Tiers maps tier names to weights; a tier with weight 4 gets four times
+	// the slots of a tier with weight 1. Tiers not listed weigh 1.
+	Tiers map[string]float64 `json:"tiers,omitempty"`
+	// Tenants assigns tenants to tiers. Others are in DefaultTier.
+	Tenants map[string]string `json:"tenants,omitempty"`
+}
+
+// Validate checks a fairness policy.
+func (p Policy) Validate() error {
+	if p.Concurrency < 0 || p.MinRemainingRequests < 0 || p.MinRemainingTokens < 0 || p.MaxWaitMs < 0 {
+		return errors.New("fairness values must not be negative")
+	}
+	for tier, weight := range p.Tiers {
+		if weight <= 0 {
+			return fmt.Errorf("fairness tier %q: weight must be positive", tier)
+		}
+	}
+	for tenant, tier := range p.Tenants {
+		if tier == "" {
+			return fmt.Errorf("fairness tenant %q: tier must not be empty", tenant)
+		}
+	}
+	return nil
+}
+
+// Tier returns tenantID's tier and its weight.
+func (p Policy) Tier(tenantID string) (string, float64) {
+	tier := DefaultTier
+	if t, ok := p.Tenants[tenantID]; ok {
+		tier = t
+	}
+	if weight, ok := p.Tiers[tier]; ok {
+		return tier, weight
+	}
+	return tier, 1
+}
+
+// Contended reports whether limits reported by the provider are within the
+// policy's thresholds. Negative counts mean the provider did not report them.
+func (p Policy) Contended(remainingRequests, remainingTokens int) bool {
+	return (remainingRequests >= 0 && remainingRequests <= p.MinRemainingRequests) ||
+		(remainingTokens >= 0 && remainingTokens <= p.MinRemainingTokens)
+}
+
+func (p Policy) maxWait() time.Duration {
+	if p.MaxWaitMs > 0 {
+		return time.Duration(p.MaxWaitMs) * time.Millisecond
+	}
+	return defaultMaxWait
+}
+
+// policyFromEnv reads FAIR_QUEUE_CONCURRENCY, FAIR_QUEUE_MIN_REMAINING_REQUESTS,
+// FAIR_QUEUE_MIN_REMAINING_TOKENS and FAIR_QUEUE_MAX_WAIT_MS. Tiers are only
+// configured in policies.json.
+func policyFromEnv() Policy {
+	var p Policy
+	for name, dst := range map[string]*int{
+		"FAIR_QUEUE_CONCURRENCY":            &p.Concurrency,
+		"FAIR_QUEUE_MIN_REMAINING_REQUESTS": &p.MinRemainingRequests,
+		"FAIR_QUEUE_MIN_REMAINING_TOKENS":   &p.MinRemainingTokens,
+		"FAIR_QUEUE_MAX_WAIT_MS":            &p.MaxWaitMs,
+	} {
+		if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+			*dst = v
+		}
+	}
+	return p
+}
+
+// Limits reports the provider's remaining requests and tokens for the key, or
+// -1 when unknown.
+type Limits func() (remainingRequests, remainingTokens int)
+
+// Scheduler admits requests to the provider. Safe for concurrent use.
+type Scheduler struct {
+	active override.Value[Policy]
+	limits Limits
+
+	mu       sync.Mutex
+	inFlight int
+	queue    waitQueue
+	seq      uint64
+	// vtime is the finish tag of the last admitted request; finish holds each
+	// queued tenant's latest finish tag. Both reset when the queue empties.
+	vtime  float64
+	finish map[string]float64
+}
+
+// NewScheduler returns a scheduler using the FAIR_QUEUE_* variables that
+// reads contention from limits.
+func NewScheduler(limits Limits) *Scheduler {
+	env := policyFromEnv()
+	return &Scheduler{active: override.New(&env), limits: limits, finish: make(map[string]float64)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (s *Scheduler) Set(policy *Policy) {
+	if s == nil {
+		return
+	}
+	s.active.Set(policy)
+	// A larger concurrency may admit waiting requests right away.
+	s.release(false)
+}
+
+// Policy returns the active policy.
+func (s *Scheduler) Policy() Policy {
+	if s == nil {
+		return Policy{}
+	}
+	return s.active.Get()
+}
+
+// contended is called with s.mu held.
+func (s *Scheduler) contended(p Policy) bool {
+	if s.limits == nil {
+		return false
+	}
+	return p.Contended(s.limits())
+}
+
+// Acquire waits until tenantID's request may be sent upstream. On success the
+// caller must call release once the request finishes. wait is how long the
+// request was queued; queued is false when it was admitted without queueing.
+// It fails with ErrQueueTimeout after the policy's maximum wait, or with the
+// context's error.
+func (s *Scheduler) Acquire(ctx context.Context, tenantID string) (release func(), tier string, wait time.Duration, queued bool, err error) {
+	if s == nil {
+		return func() {}, DefaultTier, 0, false, nil
+	}
+	s.mu.Lock()
+	p := s.active.Get()
+	tier, weight := p.Tier(tenantID)
+	if p.Concurrency == 0 || (s.queue.Len() == 0 && (s.inFlight < p.Concurrency || !s.contended(p))) {
+		s.inFlight++
+		s.mu.Unlock()
+		return s.releaseFunc(), tier, 0, false, nil
+	}
+
+	start := max(s.vtime, s.finish[tenantID])
+	w := &waiter{tag: start + 1/weight, seq: s.seq, ready: make(chan struct{})}
+	s.seq++
+	s.finish[tenantID] = w.tag
+	heap.Push(&s.queue, w)
+	s.mu.Unlock()
+
+	began := time.Now()
+	timer := time.NewTimer(p.maxWait())
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return s.releaseFunc(), tier, time.Since(began), true, nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	if w.index < 0 {
+		// Admitted while giving up; hand the slot on.
+		s.mu.Unlock()
+		s.release(true)
+	} else {
+		heap.Remove(&s.queue, w.index)
+		s.resetIfIdle()
+		s.mu.Unlock()
+	}
+	return nil, tier, time.Since(began), true, err
+}
+
+func (s *Scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(func() { s.release(true) }) }
+}
+
+// release frees a slot when done is true and admits queued requests while
+// there is room or the key is no longer contended.
+func (s *Scheduler) release(done bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if done {
+		s.inFlight--
+	}
+	p := s.active.Get()
+	for s.queue.Len() > 0 && (p.Concurrency == 0 || s.inFlight < p.Concurrency || !s.contended(p)) {
+		w := heap.Pop(&s.queue).(*waiter)
+		s.vtime = w.tag
+		s.inFlight++
+		close(w.ready)
+	}
+	s.resetIfIdle()
+}
+
+// resetIfIdle forgets finish tags once nobody is waiting, so tenants that go
+// idle start from the current virtual time. Called with s.mu held.
+func (s *Scheduler) resetIfIdle() {
+	if s.queue.Len() == 0 {
+		s.vtime = 0
+		clear(s.finish)
+	}
+}
+
+type waiter struct {
+	tag   float64
+	seq   uint64
+	ready chan struct{}
+	// index is the waiter's position in the queue, -1 once admitted.
+	index int
+}
+
+// waitQueue orders waiters by finish tag, then arrival.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].tag != q[j].tag {
+		return q[i].tag < q[j].tag
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,103 @@
+package fairness
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func contended(requests int) Limits {
+	return func() (int, int) { return requests, -1 }
+}
+
+func TestSchedulerAdmitsByTierWeight(t *testing.T) {
+	s := NewScheduler(contended(0))
+	s.Set(&Policy{
+		Concurrency: 1,
+		Tiers:       map[string]float64{"enterprise": 3},
+		Tenants:     map[string]string{"acme": "enterprise"},
+	})
+
+	hold, _, _, _, err := s.Acquire(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// A burst from a default-tier tenant queued ahead of an enterprise one.
+	order := make(chan string, 8)
+	enqueue := func(tenant string) {
+		queued := s.queued()
+		go func() {
+			release, _, _, _, err := s.Acquire(context.Background(), tenant)
+			if err != nil {
+				t.Errorf("Acquire(%s): %v", tenant, err)
+				return
+			}
+			order <- tenant
+			release()
+		}()
+		waitQueued(t, s, queued+1)
+	}
+	for range 3 {
+		enqueue("noisy")
+	}
+	for range 3 {
+		enqueue("acme")
+	}
+
+	hold()
+	var got []string
+	for range 6 {
+		got = append(got, <-order)
+	}
+	// acme's tags are 1/3, 2/3 and 1; noisy's are 1, 2 and 3.
+	want := []string{"acme", "acme", "noisy", "acme", "noisy", "noisy"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("admission order %v, want %v", got, want)
+		}
+	}
+}
+
+// waitQueued waits until want requests are queued.
+func waitQueued(t *testing.T, s *Scheduler, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if s.queued() >= want {
+			return
+		}
+	}
+	t.Fatal("request was not queued")
+}
+
+func (s *Scheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.Len()
+}
+
+func TestSchedulerTimesOutAndBypassesWhenUncontended(t *testing.T) {
+	remaining := 0
+	s := NewScheduler(func() (int, int) { return remaining, -1 })
+	s.Set(&Policy{Concurrency: 1, MinRemainingRequests: 10, MaxWaitMs: 20})
+
+	hold, _, _, _, err := s.Acquire(context.Background(), "acme")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, _, wait, queued, err := s.Acquire(context.Background(), "acme"); !errors.Is(err, ErrQueueTimeout) || !queued || wait < 20*time.Millisecond {
+		t.Fatalf("expected a queue timeout, got %v (queued %v, waited %v)", err, queued, wait)
+	}
+
+	remaining = 100
+	release, _, _, queued, err := s.Acquire(context.Background(), "acme")
+	if err != nil || queued {
+		t.Fatalf("expected immediate admission while uncontended, got %v (queued %v)", err, queued)
+	}
+	release()
+	hold()
+	if s.inFlight != 0 {
+		t.Fatalf("expected no requests in flight, got %d", s.inFlight)
+	}
+}
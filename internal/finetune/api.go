@@ -0,0 +1,209 @@
+package finetune
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+)
+
+// maxLineBytes bounds one example of a training file.
+const maxLineBytes = 16 << 20
+
+// defaultEpochs is assumed when a job leaves n_epochs to the provider ("auto").
+// The provider picks it from the dataset size; settlement corrects the
+// difference.
+const defaultEpochs = 3
+
+// trainingPrices are USD per 1M trained tokens by base model prefix, matched
+// in order so longer prefixes come first.
+// https://openai.com/api/pricing
+var trainingPrices = []struct {
+	prefix string
+	price  float64
+}{
+	{"gpt-4.1-nano", 1.50},
+	{"gpt-4.1-mini", 5.00},
+	{"gpt-4.1", 25.00},
+	{"gpt-4o-mini", 3.00},
+	{"gpt-4o", 25.00},
+	{"gpt-3.5-turbo", 8.00},
+	{"davinci-002", 6.00},
+	{"babbage-002", 0.40},
+}
+
+// defaultTrainingPrice applies to models missing from trainingPrices.
+const defaultTrainingPrice = 25.00
+
+// TrainingCost returns the cost of training model on tokens tokens (summed
+// over epochs). A fine-tuned model ("ft:gpt-4o-mini:org::id") is priced as its
+// base model.
+func TrainingCost(model string, tokens int) float64 {
+	if rest, ok := strings.CutPrefix(model, "ft:"); ok {
+		model, _, _ = strings.Cut(rest, ":")
+	}
+	price := defaultTrainingPrice
+	for _, p := range trainingPrices {
+		if strings.HasPrefix(model, p.prefix) {
+			price = p.price
+			break
+		}
+	}
+	return float64(tokens) * price / 1_000_000
+}
+
+// Status is a fine-tuning job's processing state.
+type Status struct {
+	// State is the provider's status, e.g. "running" or "succeeded".
+	State string
+	// Done is true once the job has ended, however it ended.
+	Done bool
+	// Model is the base model the job trained.
+	Model string
+	// TrainedTokens is the billed token count, summed over epochs; 0 for jobs
+	// that failed before training.
+	TrainedTokens int
+}
+
+// API reads jobs of OpenAI's fine-tuning API, using the provider's
+// credentials. A job's training data is uploaded beforehand as a JSONL file.
+// https://platform.openai.com/docs/api-reference/fine-tuning
+type API struct {
+	provider providers.Provider
+	client   *http.Client
+}
+
+// NewAPI returns the fine-tuning API of provider, or nil when it has none that
+// is supported.
+func NewAPI(provider providers.Provider, client *http.Client) *API {
+	if provider.Name() != "openai" {
+		return nil
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &API{provider: provider, client: client}
+}
+
+// IsCreate reports whether a POST to path creates a fine-tuning job.
+func (a *API) IsCreate(path string) bool {
+	return strings.TrimSuffix(path, "/") == "/v1/fine_tuning/jobs"
+}
+
+// Estimate counts the tokens of the training file of the job created with body
+// and prices them for the job's epochs.
+func (a *API) Estimate(ctx context.Context, body map[string]any) (Estimate, error) {
+	model, _ := body["model"].(string)
+	est := Estimate{Model: model, Epochs: epochs(body)}
+	fileID, _ := body["training_file"].(string)
+	if fileID == "" {
+		return est, errors.New("fine-tuning job has no training_file")
+	}
+	err := a.lines(ctx, "/v1/files/"+url.PathEscape(fileID)+"/content", func(raw []byte) {
+		var example map[string]any
+		text := ""
+		if json.Unmarshal(raw, &example) == nil {
+			text = a.provider.ExtractFullText(example)
+		}
+		if text == "" {
+			// Formats other than chat messages (preference pairs, legacy
+			// prompt/completion) are counted as the raw line.
+			text = string(raw)
+		}
+		est.Tokens += ratelimit.CountTokens(text, model)
+	})
+	est.Cost = TrainingCost(model, est.Tokens*est.Epochs)
+	return est, err
+}
+
+// epochs returns the job's n_epochs, given at the top level or under the
+// fine-tuning method, or defaultEpochs when it is left to the provider.
+func epochs(body map[string]any) int {
+	hyperparameters := []any{body["hyperparameters"]}
+	if method, ok := body["method"].(map[string]any); ok {
+		for _, m := range method {
+			if settings, ok := m.(map[string]any); ok {
+				hyperparameters = append(hyperparameters, settings["hyperparameters"])
+			}
+		}
+	}
+	for _, h := range hyperparameters {
+		params, _ := h.(map[string]any)
+		if n, ok := params["n_epochs"].(float64); ok && n >= 1 {
+			return int(n)
+		}
+	}
+	return defaultEpochs
+}
+
+// Status returns the processing state of the job with id.
+func (a *API) Status(ctx context.Context, id string) (Status, error) {
+	var job struct {
+		Status        string `json:"status"`
+		Model         string `json:"model"`
+		TrainedTokens *int   `json:"trained_tokens"`
+	}
+	resp, err := a.get(ctx, "/v1/fine_tuning/jobs/"+url.PathEscape(id))
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return Status{}, err
+	}
+	status := Status{State: job.Status, Model: job.Model}
+	switch job.Status {
+	case "succeeded", "failed", "cancelled":
+		status.Done = true
+		if job.TrainedTokens != nil {
+			status.TrainedTokens = *job.TrainedTokens
+		}
+	}
+	return status, nil
+}
+
+func (a *API) get(ctx context.Context, path string) (*http.Response, error) {
+	target := *a.provider.BaseURL()
+	target.Path, target.RawQuery = path, ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	a.provider.PrepareRequest(req)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s returned HTTP %d: %s", path, resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+// lines calls fn with every non-empty line of the JSONL file at path.
+func (a *API) lines(ctx context.Context, path string, fn func([]byte)) error {
+	resp, err := a.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(bytes.TrimSpace(line)) > 0 {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
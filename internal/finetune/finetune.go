@@ -0,0 +1,384 @@
+// Package finetune controls spend on provider fine-tuning jobs. One training
+// job can cost more than a tenant's inference budget for a whole day, and its
+// cost is billed long after the request creating it, so fine-tuning is kept
+// out of the hourly limits and charged against a separate monthly allowance
+// that each tenant must be granted explicitly. A job's cost is estimated from
+// its training file's tokens and epochs when it is created, and the actual
+// cost is settled from the trained tokens the provider reports once it ends.
+package finetune
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// defaultMaxAge is how long a job is polled before it is given up on. Large
+// jobs can queue and train for days.
+const defaultMaxAge = 14 * 24 * time.Hour
+
+// monthLayout formats the UTC month a job's cost is charged to.
+const monthLayout = "2006-01"
+
+// Budgets are monthly fine-tuning allowances in USD. A tenant with no
+// allowance, or an allowance of 0, may not create fine-tuning jobs.
+type Budgets struct {
+	// Default applies to every tenant without an entry in Tenants.
+	Default *float64           `json:"default,omitempty"`
+	Tenants map[string]float64 `json:"tenants,omitempty"`
+}
+
+// Validate checks a budgets definition.
+func (b Budgets) Validate() error {
+	if b.Default != nil && *b.Default < 0 {
+		return fmt.Errorf("fine_tune default must not be negative")
+	}
+	for tenant, v := range b.Tenants {
+		if v < 0 {
+			return fmt.Errorf("fine_tune budget for %q must not be negative", tenant)
+		}
+	}
+	return nil
+}
+
+// Allowance returns the tenant's monthly fine-tuning allowance, 0 when it has
+// none.
+func (b Budgets) Allowance(tenantID string) float64 {
+	if v, ok := b.Tenants[tenantID]; ok {
+		return v
+	}
+	if b.Default != nil {
+		return *b.Default
+	}
+	return 0
+}
+
+// budgetsFromEnv reads FINE_TUNE_BUDGET (USD per month for every tenant) and
+// FINE_TUNE_BUDGETS ("tenant=usd,..."), which takes precedence per tenant.
+func budgetsFromEnv() Budgets {
+	var b Budgets
+	if v, err := strconv.ParseFloat(os.Getenv("FINE_TUNE_BUDGET"), 64); err == nil && v > 0 {
+		b.Default = &v
+	}
+	for _, pair := range strings.Split(os.Getenv("FINE_TUNE_BUDGETS"), ",") {
+		tenant, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || v < 0 {
+			slog.Warn("Ignoring invalid FINE_TUNE_BUDGETS entry", "entry", pair)
+			continue
+		}
+		if b.Tenants == nil {
+			b.Tenants = make(map[string]float64)
+		}
+		b.Tenants[strings.TrimSpace(tenant)] = v
+	}
+	return b
+}
+
+// Job is a fine-tuning job whose estimate was charged to its tenant's
+// fine-tuning budget at creation and whose actual cost is settled when it ends.
+type Job struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+	TenantID string `json:"tenant_id"`
+	Model    string `json:"model"`
+	// Month is the UTC month (YYYY-MM) whose budget the job is charged to.
+	Month     string    `json:"month"`
+	Estimate  float64   `json:"estimate"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// Store keeps fine-tuning spend and the jobs awaiting settlement. RedisStore
+// shares them between proxy instances; MemoryStore serves the other limiter
+// backends.
+type Store interface {
+	// ReserveFineTune charges amount to the tenant's spend for month if that
+	// stays within allowance, returning whether it did and the spend.
+	ReserveFineTune(ctx context.Context, tenantID, month string, amount, allowance float64) (bool, float64, error)
+	AdjustFineTune(ctx context.Context, tenantID, month string, delta float64) error
+	FineTuneSpend(ctx context.Context, tenantID, month string) (float64, error)
+	TrackFineTune(ctx context.Context, job Job) error
+	PendingFineTunes(ctx context.Context) ([]Job, error)
+	// RemoveFineTune reports whether this call removed the job, so only one
+	// instance settles it.
+	RemoveFineTune(ctx context.Context, id string) (bool, error)
+}
+
+// MemoryStore is a Store for a single proxy instance; spend and jobs are lost
+// on restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	spend map[string]float64
+	jobs  map[string]Job
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{spend: make(map[string]float64), jobs: make(map[string]Job)}
+}
+
+// ReserveFineTune charges amount if it fits within allowance.
+func (s *MemoryStore) ReserveFineTune(ctx context.Context, tenantID, month string, amount, allowance float64) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := tenantID + ":" + month
+	if s.spend[key]+amount > allowance {
+		return false, s.spend[key], nil
+	}
+	s.spend[key] += amount
+	return true, s.spend[key], nil
+}
+
+// AdjustFineTune adds delta to the tenant's spend for month.
+func (s *MemoryStore) AdjustFineTune(ctx context.Context, tenantID, month string, delta float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spend[tenantID+":"+month] += delta
+	return nil
+}
+
+// FineTuneSpend returns the tenant's spend for month.
+func (s *MemoryStore) FineTuneSpend(ctx context.Context, tenantID, month string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spend[tenantID+":"+month], nil
+}
+
+// TrackFineTune records job.
+func (s *MemoryStore) TrackFineTune(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// PendingFineTunes returns the tracked jobs.
+func (s *MemoryStore) PendingFineTunes(ctx context.Context) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RemoveFineTune stops tracking the job with id.
+func (s *MemoryStore) RemoveFineTune(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[id]
+	delete(s.jobs, id)
+	return ok, nil
+}
+
+// Estimate is the estimated cost of a fine-tuning job.
+type Estimate struct {
+	Model  string
+	Tokens int
+	Epochs int
+	Cost   float64
+}
+
+// Accountant enforces fine-tuning allowances and settles jobs' actual cost
+// once they end.
+type Accountant struct {
+	api      *API
+	provider providers.Provider
+	store    Store
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	budgets   Budgets
+	overrides *Budgets
+}
+
+// New returns an Accountant for provider's fine-tuning jobs, tracked in store,
+// with the FINE_TUNE_BUDGET/FINE_TUNE_BUDGETS allowances.
+func New(api *API, provider providers.Provider, store Store) *Accountant {
+	return &Accountant{api: api, provider: provider, store: store, maxAge: defaultMaxAge, now: time.Now, budgets: budgetsFromEnv()}
+}
+
+// FromEnv returns an Accountant for the proxied provider's fine-tuning API,
+// unless FINE_TUNE_ACCOUNTING is false or the provider has none. transport
+// reaches the provider and may be nil.
+func FromEnv(provider providers.Provider, transport http.RoundTripper, store Store) *Accountant {
+	if enabled, err := strconv.ParseBool(os.Getenv("FINE_TUNE_ACCOUNTING")); err == nil && !enabled {
+		return nil
+	}
+	api := NewAPI(provider, &http.Client{Timeout: 2 * time.Minute, Transport: transport})
+	if api == nil || store == nil {
+		return nil
+	}
+	return New(api, provider, store)
+}
+
+// SetBudgets replaces the environment allowances with file-configured ones;
+// nil restores the environment settings.
+func (a *Accountant) SetBudgets(budgets *Budgets) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.overrides = budgets
+	a.mu.Unlock()
+}
+
+// Allowance returns the tenant's monthly fine-tuning allowance, 0 when it may
+// not fine-tune.
+func (a *Accountant) Allowance(tenantID string) float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.overrides != nil {
+		return a.overrides.Allowance(tenantID)
+	}
+	return a.budgets.Allowance(tenantID)
+}
+
+// Month returns the UTC month new jobs are charged to.
+func (a *Accountant) Month() string {
+	return a.now().UTC().Format(monthLayout)
+}
+
+// IsCreate reports whether a POST to path creates a fine-tuning job.
+func (a *Accountant) IsCreate(path string) bool {
+	return a != nil && a.api.IsCreate(path)
+}
+
+// Estimate returns the estimated cost of the job created with body.
+func (a *Accountant) Estimate(ctx context.Context, body map[string]any) (Estimate, error) {
+	return a.api.Estimate(ctx, body)
+}
+
+// Reserve charges amount to the tenant's spend for month if it stays within
+// the tenant's allowance. It returns whether it did and the spend.
+func (a *Accountant) Reserve(ctx context.Context, tenantID, month string, amount float64) (bool, float64, error) {
+	return a.store.ReserveFineTune(ctx, tenantID, month, amount, a.Allowance(tenantID))
+}
+
+// Refund returns amount to the tenant's spend for month.
+func (a *Accountant) Refund(ctx context.Context, tenantID, month string, amount float64) error {
+	return a.store.AdjustFineTune(ctx, tenantID, month, -amount)
+}
+
+// Track records a created job whose estimate was charged.
+func (a *Accountant) Track(ctx context.Context, job Job) error {
+	return a.store.TrackFineTune(ctx, job)
+}
+
+// Spend returns the tenant's fine-tuning spend for month.
+func (a *Accountant) Spend(ctx context.Context, tenantID, month string) (float64, error) {
+	return a.store.FineTuneSpend(ctx, tenantID, month)
+}
+
+// Settle checks every tracked job of the provider and settles the ones that
+// have ended: the difference between the cost of the tokens actually trained
+// and the charged estimate is charged (or refunded) to the month the job was
+// created in. Jobs still running after 14 days are dropped with their estimate
+// kept. Returns the number of jobs settled.
+func (a *Accountant) Settle(ctx context.Context) (int, error) {
+	jobs, err := a.store.PendingFineTunes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	settled := 0
+	for _, job := range jobs {
+		if job.Provider != a.provider.Name() {
+			continue
+		}
+		status, err := a.api.Status(ctx, job.ID)
+		if err != nil || !status.Done {
+			if a.now().Sub(job.Submitted) > a.maxAge {
+				a.abandon(ctx, job, err)
+			} else if err != nil {
+				slog.Debug("Failed to poll fine-tuning job", "error", err, "job_id", job.ID, "tenant_id", job.TenantID)
+			}
+			continue
+		}
+		if a.settle(ctx, job, status) {
+			settled++
+		}
+	}
+	return settled, nil
+}
+
+// settle charges the cost of the job's trained tokens in place of its
+// estimate. It reports false when another instance settled the job first.
+func (a *Accountant) settle(ctx context.Context, job Job, status Status) bool {
+	model := status.Model
+	if model == "" {
+		model = job.Model
+	}
+	actual := TrainingCost(model, status.TrainedTokens)
+
+	claimed, err := a.store.RemoveFineTune(ctx, job.ID)
+	if err != nil || !claimed {
+		if err != nil {
+			slog.Warn("Failed to claim fine-tuning job for settlement", "error", err, "job_id", job.ID)
+		}
+		return false
+	}
+	delta := actual - job.Estimate
+	if err := a.store.AdjustFineTune(ctx, job.TenantID, job.Month, delta); err != nil {
+		slog.Warn("Failed to settle fine-tuning job", "error", err, "job_id", job.ID, "tenant_id", job.TenantID)
+	}
+	telemetry.IncFineTuneJob(ctx, job.Provider, job.TenantID, "settled")
+	slog.Info("Fine-tuning job settled",
+		"job_id", job.ID,
+		"tenant_id", job.TenantID,
+		"state", status.State,
+		"trained_tokens", status.TrainedTokens,
+		"estimate", job.Estimate,
+		"actual", actual,
+	)
+	return true
+}
+
+// abandon stops polling a job that never ended, or whose status could not be
+// read, keeping its estimate charged.
+func (a *Accountant) abandon(ctx context.Context, job Job, cause error) {
+	if claimed, err := a.store.RemoveFineTune(ctx, job.ID); err != nil || !claimed {
+		return
+	}
+	telemetry.IncFineTuneJob(ctx, job.Provider, job.TenantID, "abandoned")
+	slog.Warn("Fine-tuning job abandoned, keeping its estimate",
+		"job_id", job.ID,
+		"tenant_id", job.TenantID,
+		"estimate", job.Estimate,
+		"submitted", job.Submitted,
+		"error", cause,
+	)
+}
+
+// Run settles ended jobs every interval until ctx is cancelled. Safe to run on
+// every proxy instance.
+func (a *Accountant) Run(ctx context.Context, interval time.Duration) {
+	if a == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Settle(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Fine-tuning settlement failed", "error", err)
+			}
+		}
+	}
+}
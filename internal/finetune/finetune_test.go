@@ -0,0 +1,137 @@
+package finetune
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/providers/openai"
+)
+
+// openaiAt is the OpenAI provider pointed at a test server.
+type openaiAt struct {
+	*openai.Provider
+	base *url.URL
+}
+
+func (p openaiAt) BaseURL() *url.URL { return p.base }
+
+func newAPI(t *testing.T, routes map[string]string) *API {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	base, _ := url.Parse(srv.URL)
+	p, _ := openai.New("key")
+	return NewAPI(openaiAt{Provider: p, base: base}, nil)
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTrainingCost(t *testing.T) {
+	cases := []struct {
+		model string
+		want  float64
+	}{
+		{"gpt-4o-mini-2024-07-18", 3.00},
+		{"gpt-4o-2024-08-06", 25.00},
+		{"gpt-4.1-nano-2025-04-14", 1.50},
+		{"ft:gpt-4o-mini-2024-07-18:acme::abc123", 3.00},
+		{"unknown-model", defaultTrainingPrice},
+	}
+	for _, tc := range cases {
+		if got := TrainingCost(tc.model, 1_000_000); !approx(got, tc.want) {
+			t.Errorf("TrainingCost(%q) = %v, want %v", tc.model, got, tc.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	api := newAPI(t, map[string]string{
+		"/v1/files/file-train/content": `{"messages":[{"role":"user","content":"What is the capital of France?"},{"role":"assistant","content":"Paris."}]}` + "\n\n" +
+			`{"prompt":"Translate to German: cat","completion":"Katze"}` + "\n",
+	})
+	ctx := context.Background()
+
+	auto, err := api.Estimate(ctx, map[string]any{"model": "gpt-4o-mini", "training_file": "file-train"})
+	if err != nil || auto.Tokens == 0 || auto.Epochs != defaultEpochs {
+		t.Fatalf("Estimate = %+v, %v; want the file's tokens and the default epochs", auto, err)
+	}
+	if !approx(auto.Cost, TrainingCost("gpt-4o-mini", auto.Tokens*defaultEpochs)) {
+		t.Fatalf("cost = %v, want the tokens priced for %d epochs", auto.Cost, defaultEpochs)
+	}
+
+	method := map[string]any{"supervised": map[string]any{"hyperparameters": map[string]any{"n_epochs": float64(5)}}}
+	est, err := api.Estimate(ctx, map[string]any{"model": "gpt-4o-mini", "training_file": "file-train", "method": method})
+	if err != nil || est.Epochs != 5 || est.Tokens != auto.Tokens {
+		t.Fatalf("Estimate = %+v, %v; want 5 epochs from the method", est, err)
+	}
+	if _, err := api.Estimate(ctx, map[string]any{"model": "gpt-4o-mini", "training_file": "missing"}); err == nil {
+		t.Fatal("expected an error for an unreadable training file")
+	}
+}
+
+func TestSettle(t *testing.T) {
+	api := newAPI(t, map[string]string{
+		"/v1/fine_tuning/jobs/ftjob-done":    `{"id":"ftjob-done","status":"succeeded","model":"gpt-4o-mini-2024-07-18","trained_tokens":2000000}`,
+		"/v1/fine_tuning/jobs/ftjob-failed":  `{"id":"ftjob-failed","status":"failed","model":"gpt-4o-mini-2024-07-18","trained_tokens":null}`,
+		"/v1/fine_tuning/jobs/ftjob-running": `{"id":"ftjob-running","status":"running","model":"gpt-4o-mini-2024-07-18"}`,
+	})
+	store := NewMemoryStore()
+	a := New(api, api.provider, store)
+	ctx := context.Background()
+
+	for id, estimate := range map[string]float64{"ftjob-done": 5, "ftjob-failed": 2, "ftjob-running": 1} {
+		_ = store.AdjustFineTune(ctx, "t1", "2026-09", estimate)
+		_ = store.TrackFineTune(ctx, Job{ID: id, Provider: "openai", TenantID: "t1", Month: "2026-09", Estimate: estimate, Submitted: time.Now()})
+	}
+	settled, err := a.Settle(ctx)
+	if err != nil || settled != 2 {
+		t.Fatalf("Settle = %d, %v; want 2 jobs settled", settled, err)
+	}
+	// 2M trained tokens at $3/1M replace the $5 estimate; the failed job is refunded.
+	if spend, _ := store.FineTuneSpend(ctx, "t1", "2026-09"); !approx(spend, 6+1) {
+		t.Fatalf("spend = %v, want the actual cost plus the running job's estimate", spend)
+	}
+
+	a.now = func() time.Time { return time.Now().Add(defaultMaxAge + time.Hour) }
+	if settled, _ := a.Settle(ctx); settled != 0 {
+		t.Fatalf("Settle = %d, want nothing settled", settled)
+	}
+	if pending, _ := store.PendingFineTunes(ctx); len(pending) != 0 {
+		t.Fatalf("expected the stale job to be dropped, got %+v", pending)
+	}
+}
+
+func TestAllowance(t *testing.T) {
+	t.Setenv("FINE_TUNE_BUDGET", "50")
+	t.Setenv("FINE_TUNE_BUDGETS", "acme=500, blocked=0, bad=x")
+	a := New(nil, nil, NewMemoryStore())
+
+	if a.Allowance("acme") != 500 || a.Allowance("blocked") != 0 || a.Allowance("other") != 50 {
+		t.Fatalf("unexpected allowances: acme=%v blocked=%v other=%v", a.Allowance("acme"), a.Allowance("blocked"), a.Allowance("other"))
+	}
+	a.SetBudgets(&Budgets{Tenants: map[string]float64{"other": 10}})
+	if a.Allowance("acme") != 0 || a.Allowance("other") != 10 {
+		t.Fatal("expected the file budgets to replace the environment")
+	}
+	a.SetBudgets(nil)
+	if a.Allowance("acme") != 500 {
+		t.Fatal("expected clearing the file budgets to restore the environment")
+	}
+	if err := (Budgets{Tenants: map[string]float64{"x": -1}}).Validate(); err == nil {
+		t.Fatal("expected a negative budget to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package finetune
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// jobsKey is a hash of fine-tuning jobs awaiting settlement, keyed by job ID.
+const jobsKey = "fine_tune_jobs"
+
+// spendTTL keeps a month's fine-tuning spend until the month after next, so
+// the previous month can still be reported.
+const spendTTL = 62 * 24 * time.Hour
+
+// spendKey holds a tenant's fine-tuning spend for one UTC month (YYYY-MM) in
+// integer micro-cents.
+func spendKey(tenantID, month string) string {
+	return "fine_tune_spend:" + tenantID + ":" + month
+}
+
+// reserveLUA adds ARGV[1] micro-cents to a tenant's monthly fine-tuning spend
+// unless it would exceed the allowance ARGV[2].
+const reserveLUA = `
+local spent = tonumber(redis.call('GET', KEYS[1]) or '0') or 0
+local amount = tonumber(ARGV[1])
+if spent + amount > tonumber(ARGV[2]) then
+  return {0, string.format('%d', spent)}
+end
+spent = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return {1, string.format('%d', spent)}
+`
+
+// RedisStore is a Store in Redis, shared by every proxy instance.
+type RedisStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisStore returns a store using client.
+func NewRedisStore(client *ratelimit.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// ReserveFineTune charges amount to the tenant's fine-tuning spend for month
+// if that stays within allowance. It returns whether it did, and the spend
+// before the charge when it did not or after it when it did.
+func (s *RedisStore) ReserveFineTune(ctx context.Context, tenantID, month string, amount, allowance float64) (bool, float64, error) {
+	res, err := redis.NewScript(reserveLUA).Run(ctx, s.client.Client(), s.client.Keys(spendKey(tenantID, month)),
+		ratelimit.ToMicros(amount), ratelimit.ToMicros(allowance), int(spendTTL.Seconds())).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, errors.New("unexpected fine-tune reservation result")
+	}
+	allowed, _ := res[0].(int64)
+	spentStr, _ := res[1].(string)
+	spent, _ := strconv.ParseInt(spentStr, 10, 64)
+	return allowed == 1, ratelimit.FromMicros(spent), nil
+}
+
+// AdjustFineTune adds delta, which may be negative, to the tenant's
+// fine-tuning spend for month.
+func (s *RedisStore) AdjustFineTune(ctx context.Context, tenantID, month string, delta float64) error {
+	key := s.client.Key(spendKey(tenantID, month))
+	pipe := s.client.Client().TxPipeline()
+	pipe.IncrBy(ctx, key, ratelimit.ToMicros(delta))
+	pipe.Expire(ctx, key, spendTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// FineTuneSpend returns the tenant's fine-tuning spend for month.
+func (s *RedisStore) FineTuneSpend(ctx context.Context, tenantID, month string) (float64, error) {
+	spent, err := s.client.Client().Get(ctx, s.client.Key(spendKey(tenantID, month))).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return ratelimit.FromMicros(spent), err
+}
+
+// TrackFineTune records a created fine-tuning job until RemoveFineTune
+// settles it.
+func (s *RedisStore) TrackFineTune(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.client.Client().HSet(ctx, s.client.Key(jobsKey), job.ID, data).Err()
+}
+
+// PendingFineTunes returns the tracked fine-tuning jobs. Entries that fail to
+// decode are skipped.
+func (s *RedisStore) PendingFineTunes(ctx context.Context) ([]Job, error) {
+	entries, err := s.client.Client().HGetAll(ctx, s.client.Key(jobsKey)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(entries))
+	for id, data := range entries {
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			slog.Warn("Skipping unreadable fine-tuning job", "error", err, "job_id", id)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// RemoveFineTune stops tracking a fine-tuning job. It reports whether this
+// call removed it, so only one proxy instance settles it.
+func (s *RedisStore) RemoveFineTune(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Client().HDel(ctx, s.client.Key(jobsKey), id).Result()
+	return n > 0, err
+}
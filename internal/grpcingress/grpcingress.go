@@ -0,0 +1,312 @@
+// Package grpcingress serves the proxy over gRPC for internal services that
+// would rather not speak HTTP/JSON to it. Each call is turned into an HTTP
+// request and run through the same handler as the HTTP listener, so tenant
+// headers, signing, spend limits, loop detection and every other middleware
+// apply unchanged; the response is mapped back to a gRPC reply, or to a gRPC
+// status when the request failed.
+package grpcingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "agent-sentinel/proto"
+)
+
+// Trailer keys set on calls that fail, alongside the response headers (e.g.
+// retry-after and the rate limit headers).
+const (
+	TrailerHTTPStatus = "x-sentinel-http-status"
+	TrailerErrorCode  = "x-sentinel-error-code"
+)
+
+// skippedMetadata describes the gRPC call itself and is not passed on as
+// request headers.
+var skippedMetadata = map[string]bool{"content-type": true, "te": true, "user-agent": true}
+
+// skippedTrailers are response headers with no meaning on a gRPC status.
+var skippedTrailers = map[string]bool{"content-type": true, "content-length": true, "transfer-encoding": true, "connection": true}
+
+// Server implements pb.SentinelServiceServer on top of an http.Handler.
+type Server struct {
+	pb.UnimplementedSentinelServiceServer
+	handler http.Handler
+}
+
+// New returns a server running calls through handler, the proxy's complete
+// middleware chain.
+func New(handler http.Handler) *Server {
+	return &Server{handler: handler}
+}
+
+// Register adds the service to s.
+func (s *Server) Register(g *grpc.Server) {
+	pb.RegisterSentinelServiceServer(g, s)
+}
+
+// Forward runs one request and returns its buffered response.
+func (s *Server) Forward(ctx context.Context, in *pb.ForwardRequest) (*pb.ForwardResponse, error) {
+	r, err := newRequest(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	w := &bufferWriter{header: http.Header{}}
+	s.handler.ServeHTTP(w, r)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 {
+		return nil, errorStatus(ctx, w.status, w.header, w.body.Bytes())
+	}
+	return &pb.ForwardResponse{Status: int32(w.status), Headers: flatten(w.header), Body: w.body.Bytes()}, nil
+}
+
+// ForwardStream runs one request and sends its response as it is written.
+func (s *Server) ForwardStream(in *pb.ForwardRequest, stream pb.SentinelService_ForwardStreamServer) error {
+	ctx := stream.Context()
+	r, err := newRequest(ctx, in)
+	if err != nil {
+		return err
+	}
+	w := &streamWriter{header: http.Header{}, stream: stream}
+	s.handler.ServeHTTP(w, r)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 {
+		return errorStatus(ctx, w.status, w.header, w.held.Bytes())
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if !w.sent {
+		return w.send(nil)
+	}
+	return nil
+}
+
+// newRequest builds the HTTP request for a call: the call's metadata, then
+// in.Headers, become its headers.
+func newRequest(ctx context.Context, in *pb.ForwardRequest) (*http.Request, error) {
+	method := in.GetMethod()
+	if method == "" {
+		method = http.MethodPost
+	}
+	path := in.GetPath()
+	if !strings.HasPrefix(path, "/") {
+		return nil, status.Error(codes.InvalidArgument, "path must start with /")
+	}
+	r, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(in.GetBody()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r.RequestURI = path
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if key == ":authority" && len(values) > 0 {
+				r.Host = values[0]
+			}
+			if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || skippedMetadata[key] {
+				continue
+			}
+			for _, v := range values {
+				r.Header.Add(key, v)
+			}
+		}
+	}
+	for key, v := range in.GetHeaders() {
+		r.Header.Set(key, v)
+	}
+	if r.Header.Get("Content-Type") == "" && len(in.GetBody()) > 0 {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r, nil
+}
+
+// errorStatus maps a failed response to a gRPC status. Its message is the
+// error message from the body; the HTTP status, error code and response
+// headers are set as trailers.
+func errorStatus(ctx context.Context, httpStatus int, header http.Header, body []byte) error {
+	message, code := http.StatusText(httpStatus), ""
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    any    `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		if parsed.Error.Message != "" {
+			message = parsed.Error.Message
+		}
+		if c, ok := parsed.Error.Code.(string); ok {
+			code = c
+		}
+	}
+	trailer := metadata.Pairs(TrailerHTTPStatus, strconv.Itoa(httpStatus))
+	if code != "" {
+		trailer.Set(TrailerErrorCode, code)
+	}
+	for key, values := range header {
+		key = strings.ToLower(key)
+		if skippedTrailers[key] {
+			continue
+		}
+		trailer.Append(key, values...)
+	}
+	_ = grpc.SetTrailer(ctx, trailer)
+	return status.Error(Code(httpStatus), message)
+}
+
+// Code returns the gRPC code for an HTTP error status. Denials by a spend or
+// rate limit are ResourceExhausted; load shedding and provider outages are
+// Unavailable, which clients may retry.
+func Code(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusPaymentRequired, http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if httpStatus >= 500 {
+		return codes.Internal
+	}
+	return codes.FailedPrecondition
+}
+
+// flatten joins repeated header values with commas, as HTTP allows.
+func flatten(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for key, values := range header {
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// bufferWriter keeps a unary call's response.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// Flush is a no-op; the response is sent once complete.
+func (w *bufferWriter) Flush() {}
+
+// streamWriter sends each write as a chunk. Error responses are held back to
+// become the call's status.
+type streamWriter struct {
+	header http.Header
+	stream pb.SentinelService_ForwardStreamServer
+
+	mu     sync.Mutex
+	status int
+	sent   bool
+	held   bytes.Buffer
+	err    error
+}
+
+func (w *streamWriter) Header() http.Header { return w.header }
+
+func (w *streamWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 {
+		return w.held.Write(b)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.send(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush sends the status and headers if nothing has been sent yet, so the
+// client sees the stream start.
+func (w *streamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.sent || w.status >= 400 || w.err != nil {
+		return
+	}
+	_ = w.send(nil)
+}
+
+// send sends data, with the status and headers on the first chunk. Callers
+// hold w.mu.
+func (w *streamWriter) send(data []byte) error {
+	chunk := &pb.ForwardChunk{Data: data}
+	if !w.sent {
+		chunk.Status = int32(w.status)
+		chunk.Headers = flatten(w.header)
+	}
+	w.sent = true
+	if err := w.stream.Send(chunk); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
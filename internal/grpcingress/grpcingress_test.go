@@ -0,0 +1,132 @@
+package grpcingress
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "agent-sentinel/proto"
+)
+
+func startServer(t *testing.T, handler http.Handler) pb.SentinelServiceClient {
+	t.Helper()
+	udsPath := filepath.Join(t.TempDir(), "sentinel.sock")
+	lis, err := net.Listen("unix", udsPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	New(handler).Register(grpcServer)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("unix://"+udsPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewSentinelServiceClient(conn)
+}
+
+func TestForward(t *testing.T) {
+	client := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" || string(body) != `{"model":"gpt-4o"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("X-Tenant-ID") != "t1" || r.Header.Get("Authorization") != "Bearer sk-test" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		w.Header().Set("X-RateLimit-Remaining", "4")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	}))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "t1")
+	resp, err := client.Forward(ctx, &pb.ForwardRequest{
+		Path:    "/v1/chat/completions",
+		Headers: map[string]string{"Authorization": "Bearer sk-test"},
+		Body:    []byte(`{"model":"gpt-4o"}`),
+	})
+	if err != nil {
+		t.Fatalf("Forward: %v", err)
+	}
+	if resp.Status != http.StatusOK || string(resp.Body) != `{"id":"chatcmpl-1"}` || resp.Headers["X-Ratelimit-Remaining"] != "4" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
+
+func TestForwardDenied(t *testing.T) {
+	client := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"Spend limit exceeded","type":"rate_limit_error","code":"rate_limit_exceeded"}}`))
+	}))
+	var trailer metadata.MD
+	_, err := client.Forward(context.Background(), &pb.ForwardRequest{Path: "/v1/chat/completions"}, grpc.Trailer(&trailer))
+	st, _ := status.FromError(err)
+	if st.Code() != codes.ResourceExhausted || st.Message() != "Spend limit exceeded" {
+		t.Fatalf("status = %v, want ResourceExhausted with the error message", st)
+	}
+	if got := trailer.Get(TrailerErrorCode); len(got) != 1 || got[0] != "rate_limit_exceeded" {
+		t.Fatalf("error code trailer = %v", got)
+	}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "120" {
+		t.Fatalf("retry-after trailer = %v", got)
+	}
+}
+
+func TestForwardStream(t *testing.T) {
+	client := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{"data: 1\n\n", "data: [DONE]\n\n"} {
+			_, _ = w.Write([]byte(event))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	stream, err := client.ForwardStream(context.Background(), &pb.ForwardRequest{Path: "/v1/chat/completions", Body: []byte(`{"stream":true}`)})
+	if err != nil {
+		t.Fatalf("ForwardStream: %v", err)
+	}
+	var chunks []*pb.ForwardChunk
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 2 || chunks[0].Status != http.StatusOK || chunks[0].Headers["Content-Type"] != "text/event-stream" || chunks[1].Headers != nil {
+		t.Fatalf("chunks = %v", chunks)
+	}
+	if string(chunks[0].Data)+string(chunks[1].Data) != "data: 1\n\ndata: [DONE]\n\n" {
+		t.Fatalf("data = %q %q", chunks[0].Data, chunks[1].Data)
+	}
+}
+
+func TestCode(t *testing.T) {
+	for httpStatus, want := range map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusForbidden:           codes.PermissionDenied,
+		http.StatusTooManyRequests:     codes.ResourceExhausted,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+		http.StatusInternalServerError: codes.Internal,
+	} {
+		if got := Code(httpStatus); got != want {
+			t.Errorf("Code(%d) = %v, want %v", httpStatus, got, want)
+		}
+	}
+}
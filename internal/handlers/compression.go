@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"agent-sentinel/internal/stream"
+)
+
+// maxDecodedBodyBytes bounds a decompressed response body read for cost
+// tracking, so a small compressed body cannot expand without limit.
+const maxDecodedBodyBytes = 64 << 20
+
+// errDecodedBodyTooLarge is returned when a body decompresses to more than
+// maxDecodedBodyBytes.
+var errDecodedBodyTooLarge = errors.New("decoded response body too large")
+
+// decodeBody returns the plaintext body for the given Content-Encoding.
+// Identity (empty) encodings are returned as-is.
+func decodeBody(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readDecoded(zr)
+	case "deflate":
+		fr := flate.NewReader(bytes.NewReader(body))
+		defer fr.Close()
+		return readDecoded(fr)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// readDecoded reads up to maxDecodedBodyBytes from a decompressor.
+func readDecoded(r io.Reader) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(r, maxDecodedBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > maxDecodedBodyBytes {
+		return nil, errDecodedBodyTooLarge
+	}
+	return decoded, nil
+}
+
+// decodingReader wraps a streaming body with a decompressor while keeping the
+// original body available for Close.
+type decodingReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decodingReader) Close() error {
+	var firstErr error
+	for _, c := range d.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// decodeStreamingBody replaces an encoded streaming body with a decoded one so
+// SSE chunks can be parsed. The client receives the decoded stream, so the
+// Content-Encoding and Content-Length headers are dropped.
+func decodeStreamingBody(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var dec io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		dec = zr
+	case "deflate":
+		dec = flate.NewReader(resp.Body)
+	default:
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	resp.Body = &decodingReader{Reader: dec, closers: []io.Closer{dec, resp.Body}}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// ChainModifyResponse runs each ModifyResponse hook in order, stopping at the first error.
+func ChainModifyResponse(fns ...func(*http.Response) error) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		for _, fn := range fns {
+			if fn == nil {
+				continue
+			}
+			if err := fn(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// CreateCompressResponse gzips non-streaming, unencoded responses of at least
+// minBytes when the client advertised gzip support. Disabled when minBytes <= 0.
+func CreateCompressResponse(minBytes int) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if minBytes <= 0 || resp.Request == nil {
+			return nil
+		}
+		if resp.Header.Get("Content-Encoding") != "" || stream.IsStreamingResponse(resp) {
+			return nil
+		}
+		if !acceptsGzip(resp.Request.Header.Get("Accept-Encoding")) {
+			return nil
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength < int64(minBytes) {
+			return nil
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			slog.Warn("Failed to read response body for compression", "error", err)
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+		if len(body) < minBytes {
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil || zw.Close() != nil {
+			slog.Warn("Failed to gzip response body, sending uncompressed", "error", err)
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			return nil
+		}
+
+		resp.Body = io.NopCloser(&buf)
+		resp.ContentLength = int64(buf.Len())
+		resp.Header.Set("Content-Encoding", "gzip")
+		resp.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+		resp.Header.Add("Vary", "Accept-Encoding")
+		return nil
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		rejected := false
+		for _, param := range fields[1:] {
+			param = strings.ReplaceAll(strings.TrimSpace(param), " ", "")
+			if param == "q=0" || param == "q=0.0" || param == "q=0.00" || param == "q=0.000" {
+				rejected = true
+			}
+		}
+		if !rejected {
+			return true
+		}
+	}
+	return false
+}
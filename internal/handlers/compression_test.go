@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/middleware"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestModifyResponseParsesGzipBodyAndPreservesEncoding(t *testing.T) {
+	lim := &fakeLimiter{adjustCh: make(chan struct{}, 1)}
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	prov := fakeProvider{usage: providers.TokenUsage{InputTokens: 2, OutputTokens: 3, Found: true}}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	ctx := context.WithValue(req.Context(), middleware.ContextKeyTenantID, "t1")
+	ctx = context.WithValue(ctx, middleware.ContextKeyEstimate, float64(1.0))
+	ctx = context.WithValue(ctx, middleware.ContextKeyPricing, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1})
+	req = req.WithContext(ctx)
+
+	compressed := gzipBytes(t, []byte(`{"usage":{}}`))
+	resp := &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewReader(compressed)),
+		Request:    req,
+		Header:     http.Header{"Content-Encoding": []string{"gzip"}},
+	}
+
+	if err := CreateModifyResponse(lim, prov)(resp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	select {
+	case <-lim.adjustCh:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("expected cost adjustment for gzip body")
+	}
+
+	forwarded, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(forwarded, compressed) {
+		t.Fatalf("expected original compressed bytes forwarded to client")
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected Content-Encoding preserved")
+	}
+}
+
+func TestDecodeStreamingBodyDropsEncoding(t *testing.T) {
+	payload := "data: {\"usage\":{}}\n\ndata: [DONE]\n\n"
+	resp := &http.Response{
+		Body:          io.NopCloser(bytes.NewReader(gzipBytes(t, []byte(payload)))),
+		Header:        http.Header{"Content-Encoding": []string{"gzip"}, "Content-Length": []string{"10"}},
+		ContentLength: 10,
+	}
+	if err := decodeStreamingBody(resp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	if string(got) != payload {
+		t.Fatalf("unexpected decoded stream %q", got)
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 {
+		t.Fatalf("expected encoding headers dropped, got %v", resp.Header)
+	}
+}
+
+func TestDecodeBodyRejectsUnknownEncoding(t *testing.T) {
+	if _, err := decodeBody([]byte("x"), "br"); err == nil {
+		t.Fatalf("expected error for unsupported encoding")
+	}
+	got, err := decodeBody([]byte("x"), "")
+	if err != nil || string(got) != "x" {
+		t.Fatalf("expected identity passthrough, got %q err=%v", got, err)
+	}
+}
+
+func TestDecodeBodyCapsDecompressedSize(t *testing.T) {
+	bomb := gzipBytes(t, make([]byte, maxDecodedBodyBytes+1))
+	if len(bomb) > 1<<20 {
+		t.Fatalf("expected a small compressed body, got %d bytes", len(bomb))
+	}
+	if _, err := decodeBody(bomb, "gzip"); !errors.Is(err, errDecodedBodyTooLarge) {
+		t.Fatalf("expected errDecodedBodyTooLarge, got %v", err)
+	}
+	got, err := decodeBody(gzipBytes(t, []byte(`{"ok":true}`)), "gzip")
+	if err != nil || string(got) != `{"ok":true}` {
+		t.Fatalf("expected small bodies decoded, got %q err=%v", got, err)
+	}
+}
+
+func TestCompressResponseLargeBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	body := strings.Repeat("a", 2048)
+	resp := &http.Response{
+		StatusCode:    200,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		ContentLength: int64(len(body)),
+	}
+
+	if err := CreateCompressResponse(1024)(resp); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding")
+	}
+	compressed, _ := io.ReadAll(resp.Body)
+	decoded, err := decodeBody(compressed, "gzip")
+	if err != nil || string(decoded) != body {
+		t.Fatalf("round trip failed: err=%v", err)
+	}
+}
+
+func TestCompressResponseSkipsSmallOrUnaccepted(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/x", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	resp := &http.Response{
+		Body:    io.NopCloser(strings.NewReader(strings.Repeat("a", 4096))),
+		Request: req,
+		Header:  make(http.Header),
+	}
+	_ = CreateCompressResponse(1024)(resp)
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected no compression when gzip rejected")
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp = &http.Response{
+		Body:    io.NopCloser(strings.NewReader("small")),
+		Request: req,
+		Header:  make(http.Header),
+	}
+	_ = CreateCompressResponse(1024)(resp)
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected no compression for small body")
+	}
+	got, _ := io.ReadAll(resp.Body)
+	if string(got) != "small" {
+		t.Fatalf("expected body preserved, got %q", got)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"agent-sentinel/internal/upstream"
+)
+
+// CreateProviderLimitsResponse feeds provider-reported rate limits into backoff
+// and exposes them to clients as X-Provider-RateLimit-* headers, normalized across
+// providers. The raw upstream headers are forwarded unchanged.
+func CreateProviderLimitsResponse(backoff *upstream.Backoff) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if backoff == nil {
+			return nil
+		}
+		limits := backoff.Observe(resp.StatusCode, resp.Header)
+		if limits.RemainingRequests >= 0 {
+			resp.Header.Set("X-Provider-RateLimit-Remaining-Requests", strconv.Itoa(limits.RemainingRequests))
+		}
+		if limits.RemainingTokens >= 0 {
+			resp.Header.Set("X-Provider-RateLimit-Remaining-Tokens", strconv.Itoa(limits.RemainingTokens))
+		}
+		if limits.ResetRequests > 0 {
+			resp.Header.Set("X-Provider-RateLimit-Reset-Requests", strconv.FormatFloat(limits.ResetRequests.Seconds(), 'f', 3, 64))
+		}
+		if limits.ResetTokens > 0 {
+			resp.Header.Set("X-Provider-RateLimit-Reset-Tokens", strconv.FormatFloat(limits.ResetTokens.Seconds(), 'f', 3, 64))
+		}
+		return nil
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/experiments"
 	"agent-sentinel/internal/middleware"
 	"agent-sentinel/internal/providers"
 	"agent-sentinel/internal/ratelimit"
@@ -18,20 +21,7 @@ import (
 )
 
 // CreateModifyResponse builds the proxy ModifyResponse handler for cost tracking.
-type costLimiter interface {
-	ratelimitAdjuster
-	ratelimitRefund
-}
-
-type ratelimitAdjuster interface {
-	AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error
-}
-
-type ratelimitRefund interface {
-	RefundEstimate(ctx context.Context, tenantID string, estimate float64) error
-}
-
-func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func(*http.Response) error {
+func CreateModifyResponse(limiter ratelimit.CostSettler, provider providers.Provider) func(*http.Response) error {
 	return func(resp *http.Response) error {
 		if limiter == nil {
 			return nil
@@ -43,13 +33,43 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 		pricing, _ := ctx.Value(middleware.ContextKeyPricing).(ratelimit.Pricing)
 		model, _ := ctx.Value(middleware.ContextKeyModel).(string)
 		startTime, _ := ctx.Value(middleware.ContextKeyReqStart).(time.Time)
+		reservationID, _ := ctx.Value(middleware.ContextKeyReservationID).(string)
+		assignment, _ := ctx.Value(middleware.ContextKeyExperiment).(*experiments.Assignment)
 
 		if tenantID == "" || estimate == 0 {
 			return nil
 		}
+		usageRecord := ratelimit.UsageRecord{TenantID: tenantID, Provider: provider.Name(), Model: model, Estimate: estimate, InputPrice: pricing.InputPrice, OutputPrice: pricing.OutputPrice}
 
 		if stream.IsStreamingResponse(resp) {
-			streamReader := stream.NewStreamingResponseReader(resp.Body, provider.ParseTokenUsage, tenantID, estimate, pricing, limiter, provider.Name(), model, startTime)
+			if err := decodeStreamingBody(resp); err != nil {
+				slog.Warn("Failed to decode streaming response, keeping estimate",
+					"error", err,
+					"tenant_id", tenantID,
+					"content_encoding", resp.Header.Get("Content-Encoding"),
+				)
+				keepEstimate(ctx, limiter, usageRecord, reservationID, resp.StatusCode)
+				return nil
+			}
+			streamReader := stream.NewStreamingResponseReader(resp.Body, provider.ParseTokenUsage, tenantID, reservationID, estimate, pricing, limiter, provider.Name(), model, startTime)
+			if extractor, ok := provider.(providers.StreamTextExtractor); ok {
+				streamReader.ExtractText = extractor.ExtractStreamText
+				streamReader.InputTokens, _ = ctx.Value(middleware.ContextKeyInputTokens).(int)
+			}
+			if extractor, ok := provider.(providers.FinishReasonExtractor); ok {
+				streamReader.ExtractFinishReasons = extractor.ExtractFinishReasons
+			}
+			streamReader.Span = telemetry.RequestSpan(ctx)
+			streamReader.Group = async.GroupOf(ctx)
+			streamReader.OnSettle = func(usage stream.TokenUsage, actual float64, failed bool) {
+				latency := time.Since(startTime)
+				assignment.Observe(actual, latency, failed)
+				settled := usageRecord
+				settled.Outcome = usageOutcome(failed)
+				settled.InputTokens, settled.OutputTokens, settled.Cost = usage.InputTokens, usage.OutputTokens, actual
+				settled.LatencyMs = float64(latency.Microseconds()) / 1000
+				middleware.RecordUsage(ctx, limiter, settled)
+			}
 			resp.Body = streamReader
 			slog.Debug("Streaming response detected, using chunk-based cost tracking",
 				"tenant_id", tenantID,
@@ -65,27 +85,57 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 				"error", err,
 				"tenant_id", tenantID,
 			)
+			keepEstimate(ctx, limiter, usageRecord, reservationID, resp.StatusCode)
 			return nil
 		}
+		// Preserve the original (possibly compressed) bytes for the client.
 		resp.Body = io.NopCloser(bytes.NewReader(body))
 
+		decoded, err := decodeBody(body, resp.Header.Get("Content-Encoding"))
+		if err != nil {
+			slog.Warn("Failed to decode response body, keeping estimate",
+				"error", err,
+				"tenant_id", tenantID,
+				"content_encoding", resp.Header.Get("Content-Encoding"),
+			)
+			keepEstimate(ctx, limiter, usageRecord, reservationID, resp.StatusCode)
+			return nil
+		}
+
 		var data map[string]any
-		if err := json.Unmarshal(body, &data); err != nil {
+		if err := json.Unmarshal(decoded, &data); err != nil {
 			slog.Debug("Response not JSON, keeping estimate",
 				"tenant_id", tenantID,
 				"content_type", resp.Header.Get("Content-Type"),
 			)
+			keepEstimate(ctx, limiter, usageRecord, reservationID, resp.StatusCode)
 			return nil
 		}
 
 		isError := hasErrorInResponse(data) || resp.StatusCode >= http.StatusBadRequest
 		usage := provider.ParseTokenUsage(data)
+		var finishReasons []string
+		if extractor, ok := provider.(providers.FinishReasonExtractor); ok {
+			finishReasons = extractor.ExtractFinishReasons(data)
+		}
+		telemetry.SetGenAIResponse(telemetry.RequestSpan(ctx), usage, finishReasons, data)
+		latency := time.Since(startTime)
+		usageRecord.Outcome = usageOutcome(isError)
+		usageRecord.LatencyMs = float64(latency.Microseconds()) / 1000
 
-		async.Run(func() {
-			bgCtx := context.Background()
+		async.Go(ctx, func() {
+			bgCtx := telemetry.Detach(ctx)
+			for _, reason := range finishReasons {
+				reason = providers.NormalizeFinishReason(reason)
+				telemetry.IncFinishReason(bgCtx, provider.Name(), model, tenantID, reason)
+				events.ObserveFinishReason(tenantID, reason)
+			}
 			if usage.Found {
 				actualCost := ratelimit.CalculateCost(usage.InputTokens, usage.OutputTokens, pricing)
-				if err := limiter.AdjustCost(bgCtx, tenantID, estimate, actualCost); err != nil {
+				assignment.Observe(actualCost, latency, isError)
+				usageRecord.InputTokens, usageRecord.OutputTokens, usageRecord.Cost = usage.InputTokens, usage.OutputTokens, actualCost
+				middleware.RecordUsage(bgCtx, limiter, usageRecord)
+				if err := limiter.AdjustCost(bgCtx, tenantID, reservationID, estimate, actualCost); err != nil {
 					slog.Warn("Failed to adjust cost",
 						"error", err,
 						"tenant_id", tenantID,
@@ -93,7 +143,7 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 						"actual", actualCost,
 					)
 				} else {
-					telemetry.ObserveCostDelta(bgCtx, provider.Name(), model, tenantID, actualCost-estimate)
+					telemetry.ObserveCostDelta(bgCtx, provider.Name(), model, tenantID, actualCost-estimate, false)
 					slog.Debug("Cost adjusted",
 						"tenant_id", tenantID,
 						"estimate", estimate,
@@ -103,7 +153,9 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 					)
 				}
 			} else if isError {
-				if err := limiter.RefundEstimate(bgCtx, tenantID, estimate); err != nil {
+				assignment.Observe(0, latency, true)
+				middleware.RecordUsage(bgCtx, limiter, usageRecord)
+				if err := limiter.RefundEstimate(bgCtx, tenantID, reservationID, estimate); err != nil {
 					slog.Warn("Failed to refund estimate",
 						"error", err,
 						"tenant_id", tenantID,
@@ -117,6 +169,9 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 						"status_code", resp.StatusCode,
 					)
 				}
+			} else {
+				assignment.Observe(estimate, latency, false)
+				settleAtEstimate(bgCtx, limiter, usageRecord, reservationID)
 			}
 		})
 
@@ -124,23 +179,102 @@ func CreateModifyResponse(limiter costLimiter, provider providers.Provider) func
 	}
 }
 
+// keepEstimate charges the reserved estimate for a response whose cost cannot
+// be read (a body that fails to read or decode, or is not JSON), so the
+// reservation is settled rather than refunded in full by the reconciler.
+func keepEstimate(ctx context.Context, limiter ratelimit.CostSettler, rec ratelimit.UsageRecord, reservationID string, status int) {
+	rec.Outcome = usageOutcome(status >= http.StatusBadRequest)
+	bgCtx := telemetry.Detach(ctx)
+	async.Go(bgCtx, func() { settleAtEstimate(bgCtx, limiter, rec, reservationID) })
+}
+
+// settleAtEstimate settles the reservation with the estimate as the actual
+// cost and records it in the usage log.
+func settleAtEstimate(ctx context.Context, limiter ratelimit.CostSettler, rec ratelimit.UsageRecord, reservationID string) {
+	rec.Cost = rec.Estimate
+	middleware.RecordUsage(ctx, limiter, rec)
+	if err := limiter.AdjustCost(ctx, rec.TenantID, reservationID, rec.Estimate, rec.Estimate); err != nil {
+		slog.Warn("Failed to settle estimate",
+			"error", err,
+			"tenant_id", rec.TenantID,
+			"estimate", rec.Estimate,
+		)
+		return
+	}
+	slog.Debug("Estimate kept (no usage in response)",
+		"tenant_id", rec.TenantID,
+		"estimate", rec.Estimate,
+	)
+}
+
+func usageOutcome(failed bool) string {
+	if failed {
+		return ratelimit.UsageError
+	}
+	return ratelimit.UsageOK
+}
+
 func hasErrorInResponse(data map[string]any) bool {
 	_, ok := data["error"]
 	return ok
 }
 
 // CreateErrorHandler builds the proxy error handler.
-func CreateErrorHandler(limiter ratelimitRefund) func(http.ResponseWriter, *http.Request, error) {
+func CreateErrorHandler(limiter ratelimit.CostSettler) func(http.ResponseWriter, *http.Request, error) {
 	return func(w http.ResponseWriter, r *http.Request, proxyErr error) {
 		ctx := r.Context()
 		tenantID, _ := ctx.Value(middleware.ContextKeyTenantID).(string)
 		estimate, _ := ctx.Value(middleware.ContextKeyEstimate).(float64)
 		model, _ := ctx.Value(middleware.ContextKeyModel).(string)
+		reservationID, _ := ctx.Value(middleware.ContextKeyReservationID).(string)
+		assignment, _ := ctx.Value(middleware.ContextKeyExperiment).(*experiments.Assignment)
+		startTime, _ := ctx.Value(middleware.ContextKeyReqStart).(time.Time)
+
+		if assignment != nil && !startTime.IsZero() {
+			latency := time.Since(startTime)
+			async.Go(ctx, func() { assignment.Observe(0, latency, true) })
+		}
+
+		// A request whose latency budget ran out after it reached the provider
+		// may still be billed for its input; only the rest is refunded.
+		deadline, hasDeadline := middleware.DeadlineFromContext(ctx)
+		timedOut := hasDeadline && errors.Is(ctx.Err(), context.DeadlineExceeded)
+		var partialCost float64
+		inputTokens, _ := ctx.Value(middleware.ContextKeyInputTokens).(int)
+		pricing, _ := ctx.Value(middleware.ContextKeyPricing).(ratelimit.Pricing)
+		if timedOut && deadline.Sent() && inputTokens > 0 {
+			partialCost = min(ratelimit.CalculateCost(inputTokens, 0, pricing), estimate)
+		}
 
 		if limiter != nil && tenantID != "" && estimate > 0 {
-			async.Run(func() {
+			rec := ratelimit.UsageRecord{TenantID: tenantID, Model: model, Outcome: ratelimit.UsageError, Estimate: estimate}
+			if p, ok := ctx.Value(middleware.ContextKeyProvider).(providers.Provider); ok {
+				rec.Provider = p.Name()
+			}
+			rec.InputPrice, rec.OutputPrice = pricing.InputPrice, pricing.OutputPrice
+			if partialCost > 0 {
+				rec.InputTokens, rec.Cost = inputTokens, partialCost
+			}
+			if !startTime.IsZero() {
+				rec.LatencyMs = float64(time.Since(startTime).Microseconds()) / 1000
+			}
+			middleware.RecordUsage(ctx, limiter, rec)
+			async.Go(ctx, func() {
 				bgCtx := context.Background()
-				if refundErr := limiter.RefundEstimate(bgCtx, tenantID, estimate); refundErr != nil {
+				if partialCost > 0 {
+					if err := limiter.AdjustCost(bgCtx, tenantID, reservationID, estimate, partialCost); err != nil {
+						slog.Warn("Failed to settle input cost on deadline",
+							"error", err,
+							"tenant_id", tenantID,
+							"estimate", estimate,
+							"actual", partialCost,
+						)
+					} else {
+						telemetry.IncRefund(bgCtx, rec.Provider, model, tenantID, "deadline_partial")
+					}
+					return
+				}
+				if refundErr := limiter.RefundEstimate(bgCtx, tenantID, reservationID, estimate); refundErr != nil {
 					slog.Warn("Failed to refund estimate on proxy error",
 						"error", refundErr,
 						"tenant_id", tenantID,
@@ -157,6 +291,22 @@ func CreateErrorHandler(limiter ratelimitRefund) func(http.ResponseWriter, *http
 			})
 		}
 
+		if timedOut {
+			stage := "proxy"
+			if deadline.Sent() {
+				stage = "upstream"
+			}
+			telemetry.IncDeadlineExceeded(ctx, tenantID, stage)
+			slog.Warn("Request deadline exceeded",
+				"tenant_id", tenantID,
+				"budget_ms", deadline.Budget.Milliseconds(),
+				"stage", stage,
+				"charged", partialCost,
+			)
+			middleware.WriteDeadlineExceeded(w, deadline)
+			return
+		}
+
 		slog.Error("Proxy error",
 			"error", proxyErr,
 			"tenant_id", tenantID,
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
 	"net/url"
 	"testing"
 	"time"
@@ -26,7 +27,7 @@ type fakeLimiter struct {
 	refundCh       chan struct{}
 }
 
-func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error {
+func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
 	f.adjustEstimate = estimate
 	f.adjustActual = actual
 	if f.adjustCh != nil {
@@ -34,7 +35,7 @@ func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID string, estimate,
 	}
 	return nil
 }
-func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID string, estimate float64) error {
+func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
 	f.refundEstimate = estimate
 	if f.refundCh != nil {
 		f.refundCh <- struct{}{}
@@ -135,6 +136,55 @@ func TestCreateModifyResponseRefundsOnErrorNoUsage(t *testing.T) {
 	}
 }
 
+func TestCreateModifyResponseKeepsEstimateWithoutUsage(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	bomb := gzipBytes(t, make([]byte, maxDecodedBodyBytes+1))
+	for _, tc := range []struct {
+		name     string
+		body     []byte
+		header   http.Header
+		usage    providers.TokenUsage
+		readFail bool
+	}{
+		{name: "not json", body: []byte("RIFF....WAVEfmt "), header: http.Header{"Content-Type": {"audio/wav"}}},
+		{name: "bad encoding", body: []byte("not gzip"), header: http.Header{"Content-Encoding": {"gzip"}}},
+		{name: "decoded too large", body: bomb, header: http.Header{"Content-Encoding": {"gzip"}}},
+		{name: "no usage", body: []byte(`{"id":"x"}`), header: http.Header{}},
+		{name: "read error", readFail: true, header: http.Header{}},
+		{name: "bad streaming encoding", body: []byte("data: x\n\n"), header: http.Header{"Content-Type": {"text/event-stream"}, "Content-Encoding": {"br"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lim := &fakeLimiter{adjustCh: make(chan struct{}, 1)}
+			req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", nil)
+			ctx := context.WithValue(req.Context(), middleware.ContextKeyTenantID, "t1")
+			ctx = context.WithValue(ctx, middleware.ContextKeyEstimate, float64(1.5))
+			ctx = context.WithValue(ctx, middleware.ContextKeyReservationID, "res-1")
+			var body io.ReadCloser = io.NopCloser(bytes.NewReader(tc.body))
+			if tc.readFail {
+				body = io.NopCloser(&failingReader{})
+			}
+			resp := &http.Response{StatusCode: http.StatusOK, Body: body, Request: req.WithContext(ctx), Header: tc.header}
+
+			if err := CreateModifyResponse(lim, fakeProvider{usage: tc.usage})(resp); err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			select {
+			case <-lim.adjustCh:
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("expected the reservation settled")
+			}
+			if lim.adjustEstimate != 1.5 || lim.adjustActual != 1.5 || lim.refundEstimate != 0 {
+				t.Fatalf("expected the estimate kept, got adjust %v->%v refund %v", lim.adjustEstimate, lim.adjustActual, lim.refundEstimate)
+			}
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
 func TestErrorHandlerRefundsOnProxyError(t *testing.T) {
 	lim := &fakeLimiter{refundCh: make(chan struct{}, 1)}
 	defer func() { async.RunOverride = nil }()
@@ -160,3 +210,44 @@ func TestErrorHandlerRefundsOnProxyError(t *testing.T) {
 		t.Fatalf("expected 502, got %d", rr.Code)
 	}
 }
+
+func TestErrorHandlerChargesInputOnDeadline(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+	target, _ := url.Parse(upstream.URL)
+
+	lim := &fakeLimiter{adjustCh: make(chan struct{}, 1)}
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = CreateErrorHandler(lim)
+	pricing := ratelimit.Pricing{InputPrice: 2, OutputPrice: 8}
+	handler := middleware.LatencyBudget(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.ContextKeyTenantID, "t1")
+		ctx = context.WithValue(ctx, middleware.ContextKeyEstimate, float64(1))
+		ctx = context.WithValue(ctx, middleware.ContextKeyInputTokens, 1000)
+		ctx = context.WithValue(ctx, middleware.ContextKeyPricing, pricing)
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set(middleware.HeaderDeadline, "50")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case <-lim.adjustCh:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the cost adjustment")
+	}
+	if want := ratelimit.CalculateCost(1000, 0, pricing); lim.adjustActual != want || lim.adjustEstimate != 1 {
+		t.Fatalf("expected the input cost %v charged, got %v of %v", want, lim.adjustActual, lim.adjustEstimate)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"agent-sentinel/internal/middleware"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// CreateSizeMetrics records request and response body sizes per provider,
+// model and tenant. It must run first in the ModifyResponse chain so the
+// response is measured as received from the provider (before decompression,
+// settlement or recompression).
+func CreateSizeMetrics(provider providers.Provider) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.Request == nil {
+			return nil
+		}
+		ctx := resp.Request.Context()
+		tenantID, _ := ctx.Value(middleware.ContextKeyTenantID).(string)
+		model, _ := ctx.Value(middleware.ContextKeyModel).(string)
+		if model == "" {
+			model = provider.ExtractModelFromPath(resp.Request.URL.Path)
+		}
+		if resp.Request.ContentLength > 0 {
+			telemetry.ObserveBodySize(ctx, "request", provider.Name(), model, tenantID, resp.Request.ContentLength)
+		}
+		if resp.Body != nil && resp.Body != http.NoBody {
+			resp.Body = &sizedBody{ReadCloser: resp.Body, record: func(n int64) {
+				telemetry.ObserveBodySize(context.WithoutCancel(ctx), "response", provider.Name(), model, tenantID, n)
+			}}
+		}
+		return nil
+	}
+}
+
+// sizedBody counts the bytes read through it and records the total once, at
+// EOF or Close, whichever comes first (a client that disconnects mid-stream
+// records what was read).
+type sizedBody struct {
+	io.ReadCloser
+	n      int64
+	record func(int64)
+	once   sync.Once
+}
+
+func (b *sizedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.record(b.n) })
+	}
+	return n, err
+}
+
+func (b *sizedBody) Close() error {
+	b.once.Do(func() { b.record(b.n) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSizedBodyRecordsOnce(t *testing.T) {
+	var recorded []int64
+	record := func(n int64) { recorded = append(recorded, n) }
+
+	body := &sizedBody{ReadCloser: io.NopCloser(strings.NewReader("hello world")), record: record}
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	body.Close()
+	if len(recorded) != 1 || recorded[0] != 11 {
+		t.Fatalf("expected one record of 11 bytes, got %v", recorded)
+	}
+
+	// A client disconnecting mid-stream records what was read by Close.
+	recorded = nil
+	body = &sizedBody{ReadCloser: io.NopCloser(strings.NewReader("hello world")), record: record}
+	buf := make([]byte, 5)
+	_, _ = body.Read(buf)
+	body.Close()
+	if len(recorded) != 1 || recorded[0] != 5 {
+		t.Fatalf("expected a partial record of 5 bytes, got %v", recorded)
+	}
+}
@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+// overshootScenario drives N replicas (each with its own limiter and Redis
+// connection) against one tenant until every worker is denied, with actual costs
+// drawn above the estimate to mimic requests without max_tokens.
+type overshootScenario struct {
+	replicas       int
+	workers        int
+	limit          float64
+	estimate       float64
+	minActualRatio float64
+	maxActualRatio float64
+	maxLatency     time.Duration
+}
+
+func runOvershoot(t *testing.T, client *ratelimit.RedisClient, tenant string, sc overshootScenario) float64 {
+	t.Helper()
+	ctx := context.Background()
+	clearTenantSpend(t, client, tenant)
+	_ = client.Client().Del(ctx, "estratio:"+tenant).Err()
+	if err := client.Client().Set(ctx, "limit:"+tenant, sc.limit, 0).Err(); err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+	t.Cleanup(func() {
+		clearTenantSpend(t, client, tenant)
+		_ = client.Client().Del(ctx, "estratio:"+tenant).Err()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < sc.replicas; i++ {
+		replicaClient := ratelimit.NewRedisClient()
+		if replicaClient == nil {
+			t.Fatalf("replica %d could not connect to redis", i)
+		}
+		t.Cleanup(func() { _ = replicaClient.Close() })
+		limiter := ratelimit.NewRateLimiter(replicaClient)
+
+		for w := 0; w < sc.workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					res, err := limiter.CheckLimitAndIncrement(ctx, tenant, sc.estimate)
+					if err != nil || !res.Allowed {
+						return
+					}
+					time.Sleep(time.Duration(rand.Int64N(int64(sc.maxLatency))))
+					actual := sc.estimate * (sc.minActualRatio + rand.Float64()*(sc.maxActualRatio-sc.minActualRatio))
+					_ = limiter.AdjustCost(ctx, tenant, res.ReservationID, sc.estimate, actual)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	spend, err := ratelimit.NewRateLimiter(client).GetSpend(ctx, tenant)
+	if err != nil {
+		t.Fatalf("get spend: %v", err)
+	}
+	return (spend - sc.limit) / sc.limit
+}
+
+// TestHorizontalOvershoot quantifies how far N replicas overshoot a shared limit
+// with and without estimate compensation.
+func TestHorizontalOvershoot(t *testing.T) {
+	client := requireRedis(t)
+	defer client.Close()
+
+	sc := overshootScenario{
+		replicas:       8,
+		workers:        4,
+		limit:          1.0,
+		estimate:       0.002,
+		minActualRatio: 1.5,
+		maxActualRatio: 2.5,
+		maxLatency:     10 * time.Millisecond,
+	}
+
+	results := map[bool]float64{}
+	for _, compensate := range []bool{false, true} {
+		t.Setenv("ESTIMATE_COMPENSATION_ENABLED", fmt.Sprint(compensate))
+		overshoot := runOvershoot(t, client, fmt.Sprintf("overshoot-%v", compensate), sc)
+		results[compensate] = overshoot
+		t.Logf("replicas=%d workers=%d compensation=%v overshoot=%.2f%%", sc.replicas, sc.workers, compensate, overshoot*100)
+	}
+
+	// Without compensation every in-flight request can drift by up to
+	// (maxActualRatio-1)*estimate; with it the drift is mostly absorbed.
+	inFlight := float64(sc.replicas * sc.workers)
+	worstCase := inFlight * (sc.maxActualRatio - 1) * sc.estimate / sc.limit
+	if results[false] > worstCase {
+		t.Fatalf("uncompensated overshoot %.2f%% exceeds theoretical bound %.2f%%", results[false]*100, worstCase*100)
+	}
+	if results[true] > results[false] {
+		t.Fatalf("compensation increased overshoot: %.2f%% > %.2f%%", results[true]*100, results[false]*100)
+	}
+	if results[true] > worstCase/2 {
+		t.Fatalf("compensated overshoot %.2f%% not bounded below %.2f%%", results[true]*100, worstCase/2*100)
+	}
+}
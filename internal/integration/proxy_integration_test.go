@@ -51,7 +51,7 @@ func initAsyncAndTracing(t *testing.T) {
 	t.Cleanup(func() { _ = shutdown(context.Background()) })
 }
 
-func requireRedis(t *testing.T) *ratelimit.RedisClient {
+func requireRedis(t testing.TB) *ratelimit.RedisClient {
 	t.Helper()
 	redisURL := os.Getenv("REDIS_URL_INTEGRATION")
 	if redisURL == "" {
@@ -65,7 +65,7 @@ func requireRedis(t *testing.T) *ratelimit.RedisClient {
 	return client
 }
 
-func clearTenantSpend(t *testing.T, client *ratelimit.RedisClient, tenant string) {
+func clearTenantSpend(t testing.TB, client *ratelimit.RedisClient, tenant string) {
 	t.Helper()
 	ctx := context.Background()
 	_ = client.Client().Del(ctx, fmt.Sprintf("spend:%s", tenant)).Err()
@@ -179,11 +179,11 @@ func newProxyServer(t *testing.T, provider testProvider, limiter *ratelimit.Rate
 
 	var handler http.Handler = proxy
 	handler = middleware.Logging(provider, handler)
-	handler = middleware.LoopDetection(loopClient, provider, "X-Tenant-ID", hint)(handler)
+	handler = middleware.LoopDetection(loopClient, provider, "X-Tenant-ID", loopdetect.NewHints(hint), nil, nil, nil, nil)(handler)
 	if limiter == nil {
-		handler = middleware.RateLimiting(nil, provider, "X-Tenant-ID")(handler)
+		handler = middleware.RateLimiting(nil, provider, "X-Tenant-ID", nil)(handler)
 	} else {
-		handler = middleware.RateLimiting(limiter, provider, "X-Tenant-ID")(handler)
+		handler = middleware.RateLimiting(limiter, provider, "X-Tenant-ID", nil)(handler)
 	}
 	handler = telemetry.Middleware(provider, handler)
 
@@ -599,3 +599,70 @@ func TestIntegrationFullStack_WithRealSidecarOptIn(t *testing.T) {
 		}
 	}
 }
+
+func TestIntegrationSchemaMigration(t *testing.T) {
+	t.Setenv("REDIS_NAMESPACE", fmt.Sprintf("migrate-%d", time.Now().UnixNano()))
+	client := requireRedis(t)
+	defer client.Close()
+	ctx := context.Background()
+	spendKey, legacyKey := client.Key("spend:legacy"), client.Key("credits:legacy")
+	t.Cleanup(func() {
+		_ = client.Client().Del(ctx, spendKey, legacyKey, client.Key("ucredits:legacy"), client.Key("schema_version")).Err()
+	})
+	if err := client.Client().HSet(ctx, spendKey, "1704067200", "1.5").Err(); err != nil {
+		t.Fatalf("seed spend: %v", err)
+	}
+	if err := client.Client().Set(ctx, legacyKey, "2.25", 0).Err(); err != nil {
+		t.Fatalf("seed credits: %v", err)
+	}
+
+	rl := ratelimit.NewRateLimiter(client)
+	from, to, err := rl.Migrate(ctx)
+	if err != nil || from != 0 || to != ratelimit.SchemaVersion() {
+		t.Fatalf("expected migration 0 -> %d, got %d -> %d (%v)", ratelimit.SchemaVersion(), from, to, err)
+	}
+	if got := client.Client().HGet(ctx, spendKey, "1704067200").Val(); got != "150000000" {
+		t.Fatalf("expected spend converted to micro-cents, got %q", got)
+	}
+	if credits, err := rl.GetCredits(ctx, "legacy"); err != nil || credits != 2.25 {
+		t.Fatalf("expected credits 2.25, got %v (%v)", credits, err)
+	}
+	if client.Client().Exists(ctx, legacyKey).Val() != 0 {
+		t.Fatal("expected legacy credits key removed")
+	}
+	if version, err := ratelimit.ReadSchemaVersion(ctx, client); err != nil || version != ratelimit.SchemaVersion() {
+		t.Fatalf("expected schema version %d, got %d (%v)", ratelimit.SchemaVersion(), version, err)
+	}
+}
+
+func TestIntegrationSnapshotRestore(t *testing.T) {
+	t.Setenv("REDIS_NAMESPACE", fmt.Sprintf("snapshot-%d", time.Now().UnixNano()))
+	client := requireRedis(t)
+	defer client.Close()
+	ctx := context.Background()
+	rl := ratelimit.NewRateLimiter(client)
+	t.Cleanup(func() {
+		_ = client.Client().Del(ctx, client.Keys("spend:acme", "limit:acme", "ucredits:acme", "shadow_mode")...).Err()
+	})
+
+	if err := rl.SetLimit(ctx, "acme", 40); err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+	if _, err := rl.TopUpCredits(ctx, "acme", 3); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+	snap, err := rl.Snapshot(ctx)
+	if err != nil || len(snap.Tenants) != 1 || *snap.Tenants[0].Limit != 40 || *snap.Tenants[0].CreditsMicros != 300000000 {
+		t.Fatalf("unexpected snapshot %+v (%v)", snap, err)
+	}
+
+	if err := rl.PurgeTenant(ctx, "acme"); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if n, err := rl.Restore(ctx, snap); err != nil || n != 1 {
+		t.Fatalf("restore: %d (%v)", n, err)
+	}
+	if limit, _ := rl.GetLimit(ctx, "acme"); limit != 40 {
+		t.Fatalf("expected restored limit 40, got %v", limit)
+	}
+}
@@ -0,0 +1,62 @@
+package integration
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+// BenchmarkCheckLimitWindows compares the Redis cost of the bucket and sliding
+// window algorithms. Besides ns/op it reports redis-usec/op, the server-side
+// script time from INFO commandstats.
+func BenchmarkCheckLimitWindows(b *testing.B) {
+	client := requireRedis(b)
+	ctx := context.Background()
+	for _, window := range []string{ratelimit.WindowBuckets, ratelimit.WindowSliding} {
+		b.Run(window, func(b *testing.B) {
+			b.Setenv("RATE_LIMIT_WINDOW", window)
+			b.Setenv("DEFAULT_SPEND_LIMIT", "1000000")
+			tenant := "bench-window-" + window
+			clearTenantSpend(b, client, tenant)
+			b.Cleanup(func() { clearTenantSpend(b, client, tenant) })
+			limiter := ratelimit.NewRateLimiter(client)
+
+			before := scriptUsec(b, client)
+			b.ResetTimer()
+			for range b.N {
+				res, err := limiter.CheckLimitAndIncrement(ctx, tenant, 0.0001)
+				if err != nil || !res.Allowed {
+					b.Fatalf("check: %+v, %v", res, err)
+				}
+				_ = limiter.RefundEstimate(ctx, tenant, res.ReservationID, 0.0001)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(scriptUsec(b, client)-before)/float64(b.N), "redis-usec/op")
+		})
+	}
+}
+
+// scriptUsec returns the total microseconds Redis has spent in EVAL/EVALSHA.
+func scriptUsec(b *testing.B, client *ratelimit.RedisClient) int64 {
+	b.Helper()
+	info, err := client.Client().Info(context.Background(), "commandstats").Result()
+	if err != nil {
+		b.Fatalf("info: %v", err)
+	}
+	var total int64
+	for _, line := range strings.Split(info, "\n") {
+		if !strings.HasPrefix(line, "cmdstat_eval") {
+			continue
+		}
+		for _, field := range strings.Split(strings.TrimSpace(line[strings.Index(line, ":")+1:]), ",") {
+			if v, ok := strings.CutPrefix(field, "usec="); ok {
+				n, _ := strconv.ParseInt(v, 10, 64)
+				total += n
+			}
+		}
+	}
+	return total
+}
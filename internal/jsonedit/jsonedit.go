@@ -0,0 +1,215 @@
+// Package jsonedit re-encodes a decoded JSON document that has been changed,
+// keeping the original text of everything that did not change. Re-marshaling a
+// map[string]any sorts keys, rewrites numbers through float64 (so 1.0 becomes
+// 1 and large integers lose precision) and re-escapes strings, which breaks
+// request signatures and annoys providers that hash or diff bodies. Rewrite
+// instead keeps the original key order and the original bytes of unchanged
+// values, and encodes only what is new.
+package jsonedit
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+)
+
+// Rewrite encodes v, which was decoded from orig with encoding/json (into
+// map[string]any, []any and scalars) and then changed. Unchanged values keep
+// their original text; object keys keep their original order, with removed
+// keys dropped and added keys appended in sorted order; array elements that
+// were inserted, removed or changed are matched up with the originals so the
+// untouched ones are kept. When orig is not valid JSON, v is marshaled as is.
+func Rewrite(orig []byte, v any) ([]byte, error) {
+	if !json.Valid(orig) {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	if err := rewrite(&buf, bytes.TrimSpace(orig), v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rewrite writes v to buf, reusing raw (the original encoding of the value at
+// the same place) where it still matches.
+func rewrite(buf *bytes.Buffer, raw json.RawMessage, v any) error {
+	if equal(raw, v) {
+		buf.Write(raw)
+		return nil
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		if keys, values, ok := objectMembers(raw); ok {
+			return rewriteObject(buf, keys, values, v)
+		}
+	case []any:
+		if elems, ok := arrayElements(raw); ok {
+			return rewriteArray(buf, elems, v)
+		}
+	}
+	return encode(buf, v)
+}
+
+func rewriteObject(buf *bytes.Buffer, keys []string, values []json.RawMessage, v map[string]any) error {
+	buf.WriteByte('{')
+	written := make(map[string]bool, len(v))
+	first := true
+	member := func(key string, raw json.RawMessage) error {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		if err := encode(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		return rewrite(buf, raw, v[key])
+	}
+	for i, key := range keys {
+		if _, ok := v[key]; !ok || written[key] {
+			continue
+		}
+		written[key] = true
+		if err := member(key, lastValue(keys, values, i)); err != nil {
+			return err
+		}
+	}
+	var added []string
+	for key := range v {
+		if !written[key] {
+			added = append(added, key)
+		}
+	}
+	slices.Sort(added)
+	for _, key := range added {
+		if err := member(key, nil); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// lastValue returns the value of the last occurrence of keys[i], the one
+// encoding/json decodes when a key is repeated.
+func lastValue(keys []string, values []json.RawMessage, i int) json.RawMessage {
+	for j := len(keys) - 1; j > i; j-- {
+		if keys[j] == keys[i] {
+			return values[j]
+		}
+	}
+	return values[i]
+}
+
+func rewriteArray(buf *bytes.Buffer, elems []json.RawMessage, v []any) error {
+	buf.WriteByte('[')
+	j := 0
+	for i, elem := range v {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		// An original element, here or further on when elements were removed,
+		// that is unchanged.
+		if k := indexEqual(elems, j, elem, len(v) < len(elems)); k >= 0 {
+			buf.Write(elems[k])
+			j = k + 1
+			continue
+		}
+		// As many elements left on both sides: this one was changed in place.
+		if j < len(elems) && len(v)-i == len(elems)-j {
+			if err := rewrite(buf, elems[j], elem); err != nil {
+				return err
+			}
+			j++
+			continue
+		}
+		// Otherwise it was inserted.
+		if err := encode(buf, elem); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// indexEqual returns start when elems[start] still equals v or, with search,
+// the index of the first element after it that does, or -1.
+func indexEqual(elems []json.RawMessage, start int, v any, search bool) int {
+	end := min(start+1, len(elems))
+	if search {
+		end = len(elems)
+	}
+	for k := start; k < end; k++ {
+		if equal(elems[k], v) {
+			return k
+		}
+	}
+	return -1
+}
+
+// equal reports whether raw decodes to v. A nil raw (no original) never does.
+func equal(raw json.RawMessage, v any) bool {
+	if raw == nil {
+		return false
+	}
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(decoded, v)
+}
+
+// objectMembers splits a JSON object into its keys and raw values, in order.
+func objectMembers(raw json.RawMessage) ([]string, []json.RawMessage, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, false
+	}
+	var keys []string
+	var values []json.RawMessage
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, false
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, false
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, true
+}
+
+// arrayElements splits a JSON array into its raw elements.
+func arrayElements(raw json.RawMessage) ([]json.RawMessage, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, false
+	}
+	var elems []json.RawMessage
+	for dec.More() {
+		var elem json.RawMessage
+		if err := dec.Decode(&elem); err != nil {
+			return nil, false
+		}
+		elems = append(elems, elem)
+	}
+	return elems, true
+}
+
+// encode writes v as compact JSON, as json.Marshal would.
+func encode(buf *bytes.Buffer, v any) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(out)
+	return nil
+}
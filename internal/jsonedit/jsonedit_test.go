@@ -0,0 +1,96 @@
+package jsonedit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return v
+}
+
+func TestRewrite(t *testing.T) {
+	const orig = `{"model":"gpt-4o","seed":12345678901234567890,"temperature":1.0,"messages":[{"role":"user","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}],"stream":true}`
+	tests := []struct {
+		name   string
+		change func(map[string]any)
+		want   string
+	}{
+		{"unchanged", func(map[string]any) {}, orig},
+		{"replace scalar", func(v map[string]any) { v["model"] = "gpt-4o-mini" },
+			`{"model":"gpt-4o-mini","seed":12345678901234567890,"temperature":1.0,"messages":[{"role":"user","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}],"stream":true}`},
+		{"prepend element", func(v map[string]any) {
+			v["messages"] = append([]any{map[string]any{"role": "system", "content": "hint"}}, v["messages"].([]any)...)
+		}, `{"model":"gpt-4o","seed":12345678901234567890,"temperature":1.0,"messages":[{"content":"hint","role":"system"},{"role":"user","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}],"stream":true}`},
+		{"remove elements", func(v map[string]any) {
+			tools := v["tools"].([]any)
+			v["tools"] = []any{tools[1]}
+		}, `{"model":"gpt-4o","seed":12345678901234567890,"temperature":1.0,"messages":[{"role":"user","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"b"}],"stream":true}`},
+		{"change nested", func(v map[string]any) {
+			v["messages"].([]any)[0].(map[string]any)["role"] = "developer"
+		}, `{"model":"gpt-4o","seed":12345678901234567890,"temperature":1.0,"messages":[{"role":"developer","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}],"stream":true}`},
+		{"add and remove keys", func(v map[string]any) {
+			delete(v, "stream")
+			v["max_tokens"] = 512
+			v["metadata"] = map[string]any{"k": "v"}
+		}, `{"model":"gpt-4o","seed":12345678901234567890,"temperature":1.0,"messages":[{"role":"user","content":"caf\u00e9 <b>","x_custom":{"b":1,"a":2}}],"tools":[{"name":"a"},{"name":"b"},{"name":"c"}],"max_tokens":512,"metadata":{"k":"v"}}`},
+	}
+	for _, tt := range tests {
+		v := decode(t, orig)
+		tt.change(v)
+		got, err := Rewrite([]byte(orig), v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+		// The result must decode to exactly the changed document.
+		if back, _ := json.Marshal(decode(t, string(got))); string(back) != string(mustMarshal(t, v)) {
+			t.Fatalf("%s: round trip mismatch %s", tt.name, got)
+		}
+	}
+}
+
+func TestRewriteKeepsWhitespaceOfUnchangedValues(t *testing.T) {
+	orig := "{\n  \"model\": \"a\",\n  \"messages\": [ {\"role\": \"user\"} ]\n}\n"
+	v := decode(t, orig)
+	got, err := Rewrite([]byte(orig), v)
+	if err != nil || string(got) != "{\n  \"model\": \"a\",\n  \"messages\": [ {\"role\": \"user\"} ]\n}" {
+		t.Fatalf("expected the document kept as is, got %q (%v)", got, err)
+	}
+	v["model"] = "b"
+	got, _ = Rewrite([]byte(orig), v)
+	if string(got) != `{"model":"b","messages":[ {"role": "user"} ]}` {
+		t.Fatalf("unexpected rewrite %q", got)
+	}
+}
+
+func TestRewriteDuplicateKeysAndInvalidInput(t *testing.T) {
+	orig := `{"model":"a","model":"b","n":1}`
+	v := decode(t, orig)
+	v["n"] = 2
+	got, _ := Rewrite([]byte(orig), v)
+	if string(got) != `{"model":"b","n":2}` {
+		t.Fatalf("expected one model, the last one, got %s", got)
+	}
+
+	got, err := Rewrite([]byte(`{not json`), map[string]any{"b": 1, "a": 2})
+	if err != nil || string(got) != `{"a":2,"b":1}` {
+		t.Fatalf("expected a plain marshal for invalid input, got %s (%v)", got, err)
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return out
+}
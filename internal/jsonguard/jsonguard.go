@@ -0,0 +1,129 @@
+// Package jsonguard checks that responses which are meant to be JSON (the
+// request set a JSON response format, or the tenant's policy requires it)
+// actually parse and match the declared schema. Malformed structured output
+// is a common silent trigger for agent loops: the agent fails to parse the
+// answer and asks again.
+package jsonguard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// Violation reasons.
+const (
+	// ReasonParse means the output is not valid JSON.
+	ReasonParse = "parse"
+	// ReasonSchema means the output is JSON that does not match the schema.
+	ReasonSchema = "schema"
+)
+
+// Policy configures the JSON guard.
+type Policy struct {
+	// Enabled checks responses to requests that ask for JSON output.
+	Enabled bool `json:"enabled"`
+	// Tenants lists tenants whose responses must be JSON even when the request
+	// does not ask for it, e.g. agents that always parse the answer.
+	Tenants []string `json:"tenants,omitempty"`
+	// Retry resends a request whose response violated the guard, once.
+	Retry bool `json:"retry,omitempty"`
+}
+
+// Validate checks a JSON guard policy.
+func (p Policy) Validate() error {
+	if slices.Contains(p.Tenants, "") {
+		return errors.New("json_guard tenants must not be empty")
+	}
+	return nil
+}
+
+// Requires reports whether tenantID's responses must be JSON regardless of
+// the request.
+func (p Policy) Requires(tenantID string) bool {
+	return tenantID != "" && slices.Contains(p.Tenants, tenantID)
+}
+
+// policyFromEnv reads JSON_GUARD (true enables), JSON_GUARD_TENANTS
+// (comma-separated) and JSON_GUARD_RETRY (true enables).
+func policyFromEnv() Policy {
+	p := Policy{
+		Enabled: os.Getenv("JSON_GUARD") == "true",
+		Retry:   os.Getenv("JSON_GUARD_RETRY") == "true",
+	}
+	for _, tenant := range strings.Split(os.Getenv("JSON_GUARD_TENANTS"), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			p.Tenants = append(p.Tenants, tenant)
+		}
+	}
+	return p
+}
+
+// Guard holds the active policy. Safe for concurrent use.
+type Guard struct {
+	active override.Value[Policy]
+}
+
+// NewGuard returns a guard using the JSON_GUARD* variables.
+func NewGuard() *Guard {
+	env := policyFromEnv()
+	return &Guard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *Guard) Set(policy *Policy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *Guard) Policy() Policy {
+	if g == nil {
+		return Policy{}
+	}
+	return g.active.Get()
+}
+
+// Violation describes output that failed the guard.
+type Violation struct {
+	Reason string
+	Err    error
+}
+
+func (v *Violation) Error() string {
+	return v.Reason + ": " + v.Err.Error()
+}
+
+// Check verifies that each text is JSON and, when schema is non-nil, matches
+// it. It returns the first violation, or nil.
+func Check(texts []string, schema map[string]any) *Violation {
+	for _, text := range texts {
+		var value any
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return &Violation{Reason: ReasonParse, Err: err}
+		}
+		if schema == nil {
+			continue
+		}
+		if err := ValidateSchema(schema, value); err != nil {
+			return &Violation{Reason: ReasonSchema, Err: err}
+		}
+	}
+	return nil
+}
+
+// CorrectionHint is injected into a retried request after a violation.
+func CorrectionHint(v *Violation) string {
+	if v.Reason == ReasonSchema {
+		return fmt.Sprintf("Your previous answer did not match the required JSON schema (%v). Respond only with JSON that matches the schema.", v.Err)
+	}
+	return "Your previous answer was not valid JSON. Respond only with valid JSON, without any surrounding text."
+}
@@ -0,0 +1,64 @@
+package jsonguard
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, body string) map[string]any {
+	t.Helper()
+	var data map[string]any
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheckSchema(t *testing.T) {
+	schema := parse(t, `{
+		"type": "object",
+		"properties": {
+			"status": {"type": "string", "enum": ["ok", "failed"]},
+			"steps": {"type": "array", "items": {"$ref": "#/$defs/step"}, "minItems": 1},
+			"note": {"type": ["string", "null"]}
+		},
+		"required": ["status", "steps"],
+		"additionalProperties": false,
+		"$defs": {"step": {"type": "object", "properties": {"n": {"type": "integer", "minimum": 1}}, "required": ["n"]}}
+	}`)
+
+	for _, tc := range []struct {
+		text, reason, contains string
+	}{
+		{`{"status": "ok", "steps": [{"n": 1}], "note": null}`, "", ""},
+		{`{"status": "ok", "steps": [{"n": 1}]`, ReasonParse, ""},
+		{`Here is the JSON: {}`, ReasonParse, ""},
+		{`{"status": "done", "steps": [{"n": 1}]}`, ReasonSchema, "$.status"},
+		{`{"status": "ok", "steps": []}`, ReasonSchema, "fewer than 1 items"},
+		{`{"status": "ok", "steps": [{"n": 1.5}]}`, ReasonSchema, "$.steps[0].n: expected integer"},
+		{`{"status": "ok"}`, ReasonSchema, `missing required property "steps"`},
+		{`{"status": "ok", "steps": [{"n": 2}], "extra": 1}`, ReasonSchema, `unexpected property "extra"`},
+	} {
+		v := Check([]string{tc.text}, schema)
+		switch {
+		case tc.reason == "" && v != nil:
+			t.Errorf("%s: unexpected violation %v", tc.text, v)
+		case tc.reason != "" && (v == nil || v.Reason != tc.reason || !strings.Contains(v.Error(), tc.contains)):
+			t.Errorf("%s: expected %s violation containing %q, got %v", tc.text, tc.reason, tc.contains, v)
+		}
+	}
+}
+
+func TestCheckGeminiSchema(t *testing.T) {
+	schema := parse(t, `{"type": "OBJECT", "properties": {"city": {"type": "STRING", "nullable": true}}, "required": ["city"]}`)
+	if v := Check([]string{`{"city": null}`}, schema); v != nil {
+		t.Fatalf("expected nullable upper-case schema to accept null, got %v", v)
+	}
+	if v := Check([]string{`{"city": 3}`}, schema); v == nil || v.Reason != ReasonSchema {
+		t.Fatalf("expected a schema violation, got %v", v)
+	}
+	if v := Check([]string{`[1, 2]`}, nil); v != nil {
+		t.Fatalf("expected any JSON to pass without a schema, got %v", v)
+	}
+}
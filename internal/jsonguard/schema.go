@@ -0,0 +1,288 @@
+package jsonguard
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ValidateSchema checks value, as decoded by encoding/json, against the subset
+// of JSON Schema that structured output APIs accept: type (including Gemini's
+// upper-case OpenAPI types and nullable), enum, const, properties, required,
+// additionalProperties, items, prefixItems, anyOf, oneOf, allOf, $ref into
+// $defs or definitions, and the numeric, length and pattern bounds. Unknown
+// keywords are ignored.
+func ValidateSchema(schema map[string]any, value any) error {
+	v := &schemaValidator{root: schema}
+	return v.validate(schema, value, "$", 0)
+}
+
+// maxSchemaDepth stops $ref cycles.
+const maxSchemaDepth = 64
+
+type schemaValidator struct {
+	root map[string]any
+}
+
+func (v *schemaValidator) validate(schema map[string]any, value any, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nested too deeply", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		// References outside the schema are not enforced.
+		if target, ok := v.resolve(ref); ok {
+			if err := v.validate(target, value, path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	if nullable, _ := schema["nullable"].(bool); nullable && value == nil {
+		return nil
+	}
+	if err := checkType(schema["type"], value, path); err != nil {
+		return err
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return reflect.DeepEqual(e, value) }) {
+		return fmt.Errorf("%s: value is not one of the allowed values", path)
+	}
+	if want, ok := schema["const"]; ok && !reflect.DeepEqual(want, value) {
+		return fmt.Errorf("%s: value does not equal the required constant", path)
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := v.validate(sub, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 {
+		if !slices.ContainsFunc(anyOf, func(sub map[string]any) bool { return v.validate(sub, value, path, depth+1) == nil }) {
+			return fmt.Errorf("%s: value matches none of anyOf", path)
+		}
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.validate(sub, value, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value matches %d of oneOf, want 1", path, matches)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		return v.validateObject(schema, value, path, depth)
+	case []any:
+		return v.validateArray(schema, value, path, depth)
+	case string:
+		if n, ok := number(schema["minLength"]); ok && float64(len([]rune(value))) < n {
+			return fmt.Errorf("%s: string shorter than %v", path, n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && float64(len([]rune(value))) > n {
+			return fmt.Errorf("%s: string longer than %v", path, n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			// Patterns RE2 cannot compile are not enforced.
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(value) {
+				return fmt.Errorf("%s: string does not match pattern %q", path, pattern)
+			}
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && value < n {
+			return fmt.Errorf("%s: %v is less than %v", path, value, n)
+		}
+		if n, ok := number(schema["maximum"]); ok && value > n {
+			return fmt.Errorf("%s: %v is greater than %v", path, value, n)
+		}
+		if n, ok := number(schema["exclusiveMinimum"]); ok && value <= n {
+			return fmt.Errorf("%s: %v is not greater than %v", path, value, n)
+		}
+		if n, ok := number(schema["exclusiveMaximum"]); ok && value >= n {
+			return fmt.Errorf("%s: %v is not less than %v", path, value, n)
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateObject(schema map[string]any, value map[string]any, path string, depth int) error {
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := value[name]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	// Sorted so the reported violation is stable.
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if sub, ok := properties[name].(map[string]any); ok {
+			if err := v.validate(sub, value[name], path+"."+name, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: unexpected property %q", path, name)
+			}
+		case map[string]any:
+			if err := v.validate(additional, value[name], path+"."+name, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if n, ok := number(schema["minProperties"]); ok && float64(len(value)) < n {
+		return fmt.Errorf("%s: fewer than %v properties", path, n)
+	}
+	if n, ok := number(schema["maxProperties"]); ok && float64(len(value)) > n {
+		return fmt.Errorf("%s: more than %v properties", path, n)
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(schema map[string]any, value []any, path string, depth int) error {
+	if n, ok := number(schema["minItems"]); ok && float64(len(value)) < n {
+		return fmt.Errorf("%s: fewer than %v items", path, n)
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(value)) > n {
+		return fmt.Errorf("%s: more than %v items", path, n)
+	}
+	prefix := schemaList(schema["prefixItems"])
+	items, _ := schema["items"].(map[string]any)
+	for i, item := range value {
+		sub := items
+		if i < len(prefix) {
+			sub = prefix[i]
+		}
+		if sub == nil {
+			continue
+		}
+		if err := v.validate(sub, item, path+"["+strconv.Itoa(i)+"]", depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve follows a local reference such as "#/$defs/step".
+func (v *schemaValidator) resolve(ref string) (map[string]any, bool) {
+	if ref == "#" {
+		return v.root, true
+	}
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, false
+	}
+	var node any = v.root
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		node = object[token]
+	}
+	target, ok := node.(map[string]any)
+	return target, ok
+}
+
+func checkType(want any, value any, path string) error {
+	var types []string
+	switch want := want.(type) {
+	case string:
+		types = []string{want}
+	case []any:
+		types = stringList(want)
+	default:
+		return nil
+	}
+	for _, t := range types {
+		if hasType(strings.ToLower(t), value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, strings.ToLower(strings.Join(types, " or ")), typeName(value))
+}
+
+func hasType(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	// Unknown types are not enforced.
+	return true
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaList(v any) []map[string]any {
+	list, _ := v.([]any)
+	var schemas []map[string]any
+	for _, item := range list {
+		if schema, ok := item.(map[string]any); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+func stringList(v any) []string {
+	list, _ := v.([]any)
+	var strs []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+func number(v any) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
@@ -0,0 +1,260 @@
+package loopdetect
+
+import (
+	"crypto/sha256"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAnalyticsMinTenants hides models seen from fewer tenants, so a
+	// row cannot be traced back to the one or two tenants behind it.
+	defaultAnalyticsMinTenants = 3
+	defaultAnalyticsSessionTTL = 30 * time.Minute
+	// maxAnalyticsSessions bounds the time-to-loop state; the least recently
+	// seen session is evicted first.
+	maxAnalyticsSessions = 10000
+)
+
+// LoopCheck is one loop check as seen by the analytics: what was checked and
+// what it found. Tenant and session identify the conversation and are only
+// kept hashed.
+type LoopCheck struct {
+	Tenant   string
+	Session  string
+	Provider string
+	Model    string
+	// Similarity is the sidecar's max similarity; HasSimilarity is false when
+	// the sidecar was not consulted (tool cycles only, or it failed open).
+	Similarity    float64
+	HasSimilarity bool
+	// SimilarityLoop and ToolCycle report what was detected; either counts
+	// as a loop.
+	SimilarityLoop bool
+	ToolCycle      bool
+}
+
+// ModelLoopStats aggregates loop checks for one provider and model across
+// tenants.
+type ModelLoopStats struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Tenants is how many distinct tenants contributed.
+	Tenants int   `json:"tenants"`
+	Checks  int64 `json:"checks"`
+	Loops   int64 `json:"loops"`
+	// LoopRate is Loops over Checks.
+	LoopRate   float64 `json:"loop_rate"`
+	ToolCycles int64   `json:"tool_cycles"`
+	// AvgSimilarity is the mean sidecar similarity over all checks, and
+	// AvgSimilarityAtDetection over the checks flagged as similarity loops:
+	// the gap between them is the headroom a global threshold has.
+	AvgSimilarity            float64 `json:"avg_similarity"`
+	AvgSimilarityAtDetection float64 `json:"avg_similarity_at_detection"`
+	// SessionsLooped counts sessions that hit a loop; AvgTimeToLoopSeconds and
+	// AvgChecksToLoop measure from a session's first check to its first loop.
+	SessionsLooped       int64   `json:"sessions_looped"`
+	AvgTimeToLoopSeconds float64 `json:"avg_time_to_loop_seconds"`
+	AvgChecksToLoop      float64 `json:"avg_checks_to_loop"`
+}
+
+// AnalyticsReport is the cross-tenant loop summary served to operators.
+type AnalyticsReport struct {
+	Since  time.Time        `json:"since"`
+	Models []ModelLoopStats `json:"models"`
+	// Suppressed counts the models left out for having fewer than MinTenants
+	// tenants.
+	Suppressed int `json:"suppressed"`
+	MinTenants int `json:"min_tenants"`
+}
+
+type modelKey struct{ provider, model string }
+
+type modelAggregate struct {
+	tenants          map[[sha256.Size]byte]struct{}
+	checks, loops    int64
+	toolCycles       int64
+	similarity       float64
+	similarityChecks int64
+	detection        float64
+	detectionChecks  int64
+	sessionsLooped   int64
+	timeToLoop       time.Duration
+	checksToLoop     int64
+}
+
+type analyticsSession struct {
+	first  time.Time
+	seen   time.Time
+	checks int64
+	looped bool
+}
+
+// Analytics aggregates loop checks per provider and model across tenants, to
+// tune global defaults (threshold, hints) from real traffic. Only counts and
+// similarity scores are kept: no prompts, and tenant and session IDs only as
+// hashes, which are never reported. Models seen from fewer than minTenants
+// tenants are suppressed. State is in memory and per replica. Safe for
+// concurrent use; a nil *Analytics records nothing.
+type Analytics struct {
+	minTenants int
+	ttl        time.Duration
+	now        func() time.Time
+	since      time.Time
+
+	mu       sync.Mutex
+	models   map[modelKey]*modelAggregate
+	sessions map[[sha256.Size]byte]*analyticsSession
+}
+
+// NewAnalytics returns an aggregator reporting models seen from at least
+// minTenants tenants. A session idle for longer than ttl starts over.
+func NewAnalytics(minTenants int, ttl time.Duration) *Analytics {
+	if minTenants <= 0 {
+		minTenants = defaultAnalyticsMinTenants
+	}
+	if ttl <= 0 {
+		ttl = defaultAnalyticsSessionTTL
+	}
+	return &Analytics{
+		minTenants: minTenants,
+		ttl:        ttl,
+		now:        time.Now,
+		since:      time.Now().UTC(),
+		models:     make(map[modelKey]*modelAggregate),
+		sessions:   make(map[[sha256.Size]byte]*analyticsSession),
+	}
+}
+
+// AnalyticsFromEnv returns an aggregator unless LOOP_ANALYTICS is false,
+// configured by LOOP_ANALYTICS_MIN_TENANTS and LOOP_ANALYTICS_SESSION_TTL_SECONDS.
+func AnalyticsFromEnv() *Analytics {
+	if enabled, err := strconv.ParseBool(os.Getenv("LOOP_ANALYTICS")); err == nil && !enabled {
+		return nil
+	}
+	minTenants, _ := strconv.Atoi(os.Getenv("LOOP_ANALYTICS_MIN_TENANTS"))
+	ttlSeconds, _ := strconv.Atoi(os.Getenv("LOOP_ANALYTICS_SESSION_TTL_SECONDS"))
+	return NewAnalytics(minTenants, time.Duration(ttlSeconds)*time.Second)
+}
+
+// Observe records a loop check.
+func (a *Analytics) Observe(c LoopCheck) {
+	if a == nil || c.Tenant == "" {
+		return
+	}
+	tenant := sha256.Sum256([]byte(c.Tenant))
+	session := sha256.Sum256([]byte(c.Tenant + "\x00" + c.Session))
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := modelKey{provider: c.Provider, model: c.Model}
+	agg, ok := a.models[key]
+	if !ok {
+		agg = &modelAggregate{tenants: make(map[[sha256.Size]byte]struct{})}
+		a.models[key] = agg
+	}
+	agg.tenants[tenant] = struct{}{}
+	agg.checks++
+	if c.HasSimilarity {
+		agg.similarity += c.Similarity
+		agg.similarityChecks++
+	}
+	loop := c.SimilarityLoop || c.ToolCycle
+	if loop {
+		agg.loops++
+	}
+	if c.ToolCycle {
+		agg.toolCycles++
+	}
+	if c.SimilarityLoop {
+		agg.detection += c.Similarity
+		agg.detectionChecks++
+	}
+
+	s, ok := a.sessions[session]
+	if !ok || now.Sub(s.seen) > a.ttl {
+		a.evictLocked(now)
+		s = &analyticsSession{first: now}
+		a.sessions[session] = s
+	}
+	s.seen = now
+	s.checks++
+	if loop && !s.looped {
+		s.looped = true
+		agg.sessionsLooped++
+		agg.timeToLoop += now.Sub(s.first)
+		agg.checksToLoop += s.checks
+	}
+}
+
+// Report returns the per-model stats, busiest model first.
+func (a *Analytics) Report() AnalyticsReport {
+	if a == nil {
+		return AnalyticsReport{Models: []ModelLoopStats{}}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	report := AnalyticsReport{Since: a.since, Models: []ModelLoopStats{}, MinTenants: a.minTenants}
+	for key, agg := range a.models {
+		if len(agg.tenants) < a.minTenants {
+			report.Suppressed++
+			continue
+		}
+		stats := ModelLoopStats{
+			Provider:       key.provider,
+			Model:          key.model,
+			Tenants:        len(agg.tenants),
+			Checks:         agg.checks,
+			Loops:          agg.loops,
+			LoopRate:       float64(agg.loops) / float64(agg.checks),
+			ToolCycles:     agg.toolCycles,
+			SessionsLooped: agg.sessionsLooped,
+		}
+		if agg.similarityChecks > 0 {
+			stats.AvgSimilarity = agg.similarity / float64(agg.similarityChecks)
+		}
+		if agg.detectionChecks > 0 {
+			stats.AvgSimilarityAtDetection = agg.detection / float64(agg.detectionChecks)
+		}
+		if agg.sessionsLooped > 0 {
+			stats.AvgTimeToLoopSeconds = agg.timeToLoop.Seconds() / float64(agg.sessionsLooped)
+			stats.AvgChecksToLoop = float64(agg.checksToLoop) / float64(agg.sessionsLooped)
+		}
+		report.Models = append(report.Models, stats)
+	}
+	sort.Slice(report.Models, func(i, j int) bool {
+		mi, mj := report.Models[i], report.Models[j]
+		if mi.Checks != mj.Checks {
+			return mi.Checks > mj.Checks
+		}
+		return mi.Provider+"/"+mi.Model < mj.Provider+"/"+mj.Model
+	})
+	return report
+}
+
+// evictLocked drops expired sessions, and the least recently seen one when the
+// map is still full. a.mu must be held.
+func (a *Analytics) evictLocked(now time.Time) {
+	if len(a.sessions) < maxAnalyticsSessions {
+		return
+	}
+	var oldest [sha256.Size]byte
+	var oldestSeen time.Time
+	found := false
+	for key, s := range a.sessions {
+		if now.Sub(s.seen) > a.ttl {
+			delete(a.sessions, key)
+			continue
+		}
+		if !found || s.seen.Before(oldestSeen) {
+			oldest, oldestSeen, found = key, s.seen, true
+		}
+	}
+	if len(a.sessions) >= maxAnalyticsSessions {
+		delete(a.sessions, oldest)
+	}
+}
@@ -0,0 +1,75 @@
+package loopdetect
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsAggregatesAcrossTenants(t *testing.T) {
+	a := NewAnalytics(2, time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	a.now = func() time.Time { return now }
+
+	check := func(tenant string, similarity float64, loop bool) {
+		a.Observe(LoopCheck{Tenant: tenant, Session: "run", Provider: "openai", Model: "gpt-4o",
+			Similarity: similarity, HasSimilarity: true, SimilarityLoop: loop})
+	}
+	check("acme", 0.4, false)
+	now = now.Add(30 * time.Second)
+	check("acme", 0.97, true)
+	check("globex", 0.5, false)
+	now = now.Add(time.Minute)
+	check("globex", 0.95, true)
+	// Another loop in the same session does not count as a new session loop.
+	check("globex", 0.99, true)
+	// A model used by a single tenant is suppressed.
+	a.Observe(LoopCheck{Tenant: "acme", Provider: "anthropic", Model: "claude-private", SimilarityLoop: true})
+
+	report := a.Report()
+	if report.Suppressed != 1 || len(report.Models) != 1 {
+		t.Fatalf("expected one reported and one suppressed model, got %+v", report)
+	}
+	m := report.Models[0]
+	if m.Tenants != 2 || m.Checks != 5 || m.Loops != 3 || m.LoopRate != 0.6 {
+		t.Fatalf("unexpected counts %+v", m)
+	}
+	if got := m.AvgSimilarityAtDetection; got < 0.969 || got > 0.971 {
+		t.Fatalf("expected average detection similarity 0.97, got %v", got)
+	}
+	// acme looped 30s and 2 checks in, globex 60s and 2 checks in.
+	if m.SessionsLooped != 2 || m.AvgTimeToLoopSeconds != 45 || m.AvgChecksToLoop != 2 {
+		t.Fatalf("unexpected time to loop %+v", m)
+	}
+
+	body, _ := json.Marshal(report)
+	for _, tenant := range []string{"acme", "globex", "claude-private"} {
+		if strings.Contains(string(body), tenant) {
+			t.Fatalf("report leaks %q: %s", tenant, body)
+		}
+	}
+}
+
+func TestAnalyticsSessionExpires(t *testing.T) {
+	a := NewAnalytics(1, time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+	a.now = func() time.Time { return now }
+
+	a.Observe(LoopCheck{Tenant: "acme", Session: "run", Model: "m"})
+	now = now.Add(2 * time.Minute)
+	a.Observe(LoopCheck{Tenant: "acme", Session: "run", Model: "m", ToolCycle: true})
+
+	m := a.Report().Models[0]
+	if m.AvgTimeToLoopSeconds != 0 || m.AvgChecksToLoop != 1 {
+		t.Fatalf("an idle session should start over, got %+v", m)
+	}
+}
+
+func TestAnalyticsNil(t *testing.T) {
+	var a *Analytics
+	a.Observe(LoopCheck{Tenant: "acme"})
+	if r := a.Report(); len(r.Models) != 0 {
+		t.Fatalf("expected an empty report, got %+v", r)
+	}
+}
@@ -0,0 +1,76 @@
+package loopdetect
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/override"
+)
+
+// BypassPolicy names the tenants allowed to skip loop detection with the
+// X-Sentinel-Loop-Bypass header, e.g. while debugging a false positive.
+type BypassPolicy struct {
+	Tenants []string `json:"tenants"`
+	// Until, when set, ends the grant so a forgotten bypass does not outlive
+	// the investigation.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Validate checks a bypass policy.
+func (p BypassPolicy) Validate() error {
+	if slices.Contains(p.Tenants, "") {
+		return errors.New("loop_bypass tenants must not be empty")
+	}
+	return nil
+}
+
+// Allows reports whether tenantID may bypass loop detection at now.
+func (p BypassPolicy) Allows(tenantID string, now time.Time) bool {
+	if p.Until != nil && now.After(*p.Until) {
+		return false
+	}
+	return slices.Contains(p.Tenants, tenantID)
+}
+
+// bypassPolicyFromEnv reads LOOP_BYPASS_TENANTS (comma-separated).
+func bypassPolicyFromEnv() BypassPolicy {
+	var p BypassPolicy
+	for _, tenant := range strings.Split(os.Getenv("LOOP_BYPASS_TENANTS"), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			p.Tenants = append(p.Tenants, tenant)
+		}
+	}
+	return p
+}
+
+// BypassGuard holds the active bypass policy. Safe for concurrent use.
+type BypassGuard struct {
+	active override.Value[BypassPolicy]
+}
+
+// NewBypassGuard returns a guard using LOOP_BYPASS_TENANTS.
+func NewBypassGuard() *BypassGuard {
+	env := bypassPolicyFromEnv()
+	return &BypassGuard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *BypassGuard) Set(policy *BypassPolicy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Allows reports whether tenantID may currently bypass loop detection.
+func (g *BypassGuard) Allows(tenantID string) bool {
+	if g == nil {
+		return false
+	}
+	policy := g.active.Get()
+	return policy.Allows(tenantID, time.Now())
+}
@@ -0,0 +1,29 @@
+package loopdetect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBypassGuard(t *testing.T) {
+	t.Setenv("LOOP_BYPASS_TENANTS", "acme, beta")
+	g := NewBypassGuard()
+	if !g.Allows("beta") || g.Allows("gamma") {
+		t.Fatal("expected env tenants to be allowed and others not")
+	}
+
+	past := time.Now().Add(-time.Hour)
+	g.Set(&BypassPolicy{Tenants: []string{"gamma"}, Until: &past})
+	if g.Allows("gamma") || g.Allows("acme") {
+		t.Fatal("expected an expired file policy to replace the env policy and allow no one")
+	}
+
+	g.Set(nil)
+	if !g.Allows("acme") {
+		t.Fatal("expected env policy to be restored")
+	}
+	var nilGuard *BypassGuard
+	if nilGuard.Allows("acme") {
+		t.Fatal("expected nil guard to allow no one")
+	}
+}
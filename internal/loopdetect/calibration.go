@@ -0,0 +1,77 @@
+package loopdetect
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "embedding-sidecar/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Calibration is the sidecar's similarity threshold calibration: per tenant,
+// the threshold in effect and the one that would hold the target
+// false-positive rate.
+type Calibration struct {
+	// Enabled is false when the sidecar runs without the calibration job
+	// (LOOP_CALIBRATION_ENABLED) or predates it.
+	Enabled                 bool                `json:"enabled"`
+	AutoApply               bool                `json:"auto_apply"`
+	TargetFalsePositiveRate float64             `json:"target_false_positive_rate"`
+	Tenants                 []TenantCalibration `json:"tenants"`
+}
+
+// TenantCalibration is a tenant's latest calibration run.
+type TenantCalibration struct {
+	TenantID             string  `json:"tenant_id"`
+	Samples              int64   `json:"samples"`
+	CurrentThreshold     float64 `json:"current_threshold"`
+	RecommendedThreshold float64 `json:"recommended_threshold"`
+	// CurrentFlagRate and RecommendedFlagRate are the shares of sampled
+	// checks each threshold flags as loops.
+	CurrentFlagRate     float64 `json:"current_flag_rate"`
+	RecommendedFlagRate float64 `json:"recommended_flag_rate"`
+	P50Similarity       float64 `json:"p50_similarity"`
+	P95Similarity       float64 `json:"p95_similarity"`
+	P99Similarity       float64 `json:"p99_similarity"`
+	// Applied reports that checks use the recommended threshold.
+	Applied      bool      `json:"applied"`
+	CalibratedAt time.Time `json:"calibrated_at"`
+}
+
+// Calibration fetches the sidecar's threshold calibration for tenantID, or
+// for every calibrated tenant when it is empty.
+func (c *Client) Calibration(ctx context.Context, tenantID string) (*Calibration, error) {
+	out := &Calibration{Tenants: []TenantCalibration{}}
+	if c == nil || c.client == nil {
+		return out, nil
+	}
+	resp, err := c.client.GetCalibration(ctx, &pb.GetCalibrationRequest{TenantId: tenantID})
+	if status.Code(err) == codes.Unimplemented {
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get sidecar calibration: %w", err)
+	}
+	out.Enabled = resp.GetEnabled()
+	out.AutoApply = resp.GetAutoApply()
+	out.TargetFalsePositiveRate = resp.GetTargetFalsePositiveRate()
+	for _, t := range resp.GetTenants() {
+		out.Tenants = append(out.Tenants, TenantCalibration{
+			TenantID:             t.GetTenantId(),
+			Samples:              t.GetSamples(),
+			CurrentThreshold:     t.GetCurrentThreshold(),
+			RecommendedThreshold: t.GetRecommendedThreshold(),
+			CurrentFlagRate:      t.GetCurrentFlagRate(),
+			RecommendedFlagRate:  t.GetRecommendedFlagRate(),
+			P50Similarity:        t.GetP50Similarity(),
+			P95Similarity:        t.GetP95Similarity(),
+			P99Similarity:        t.GetP99Similarity(),
+			Applied:              t.GetApplied(),
+			CalibratedAt:         time.Unix(t.GetCalibratedAt(), 0).UTC(),
+		})
+	}
+	return out, nil
+}
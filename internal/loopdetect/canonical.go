@@ -0,0 +1,168 @@
+package loopdetect
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// Built-in normalizers for CanonicalizePolicy.Builtin and LOOP_CANONICALIZE.
+const (
+	NormalizeTimestamps = "timestamps"
+	NormalizeUUIDs      = "uuids"
+	NormalizeRequestIDs = "request_ids"
+	NormalizeWhitespace = "whitespace"
+)
+
+var builtinNormalizers = map[string][]Replacement{
+	NormalizeTimestamps: {
+		// ISO 8601 / RFC 3339 date-times, with optional fraction and zone.
+		{Pattern: `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:[.,]\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`, Replace: "<ts>"},
+		// Unix seconds or milliseconds from this century.
+		{Pattern: `\b1\d{9}(?:\d{3})?\b`, Replace: "<ts>"},
+	},
+	NormalizeUUIDs: {
+		{Pattern: `(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`, Replace: "<uuid>"},
+	},
+	NormalizeRequestIDs: {
+		// Prefixed IDs minted by providers and agent frameworks.
+		{Pattern: `\b(?:req|request|call|toolu|msg|run|resp|chatcmpl|span|trace)[-_][A-Za-z0-9]{6,}\b`, Replace: "<id>"},
+		// Long hex strings (trace IDs, hashes).
+		{Pattern: `(?i)\b[0-9a-f]{16,}\b`, Replace: "<id>"},
+	},
+}
+
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// Replacement rewrites every match of Pattern (RE2 syntax) to Replace, which
+// may reference groups as $1.
+type Replacement struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// CanonicalizePolicy strips superficial differences (timestamps, IDs,
+// whitespace) that agent frameworks inject into otherwise repeated prompts, so
+// they do not defeat similarity search. Builtins run first, then Patterns in
+// order; whitespace is collapsed last.
+type CanonicalizePolicy struct {
+	Builtin  []string      `json:"builtin,omitempty"`
+	Patterns []Replacement `json:"patterns,omitempty"`
+}
+
+// Validate checks builtin names and compiles the patterns.
+func (p CanonicalizePolicy) Validate() error {
+	_, err := compileCanonicalizer(p)
+	return err
+}
+
+type compiledReplacement struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+type canonicalizer struct {
+	replacements []compiledReplacement
+	whitespace   bool
+}
+
+func compileCanonicalizer(p CanonicalizePolicy) (*canonicalizer, error) {
+	c := &canonicalizer{}
+	add := func(r Replacement) error {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("loop_canonicalize pattern %q: %w", r.Pattern, err)
+		}
+		c.replacements = append(c.replacements, compiledReplacement{re: re, replace: r.Replace})
+		return nil
+	}
+	for _, name := range p.Builtin {
+		if name == NormalizeWhitespace {
+			c.whitespace = true
+			continue
+		}
+		replacements, ok := builtinNormalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("loop_canonicalize: unknown builtin %q", name)
+		}
+		for _, r := range replacements {
+			if err := add(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, r := range p.Patterns {
+		if err := add(r); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *canonicalizer) apply(s string) string {
+	for _, r := range c.replacements {
+		s = r.re.ReplaceAllString(s, r.replace)
+	}
+	if c.whitespace {
+		s = strings.TrimSpace(whitespaceRun.ReplaceAllString(s, " "))
+	}
+	return s
+}
+
+// canonicalizePolicyFromEnv reads LOOP_CANONICALIZE, a comma-separated list of
+// builtins ("all" for every one). Unknown names are ignored.
+func canonicalizePolicyFromEnv() CanonicalizePolicy {
+	var p CanonicalizePolicy
+	for _, name := range strings.Split(os.Getenv("LOOP_CANONICALIZE"), ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "all":
+			return CanonicalizePolicy{Builtin: []string{NormalizeTimestamps, NormalizeUUIDs, NormalizeRequestIDs, NormalizeWhitespace}}
+		case name == NormalizeWhitespace:
+			p.Builtin = append(p.Builtin, name)
+		case builtinNormalizers[name] != nil:
+			p.Builtin = append(p.Builtin, name)
+		}
+	}
+	return p
+}
+
+// Canonicalizer applies the active canonicalization policy. Safe for
+// concurrent use.
+type Canonicalizer struct {
+	active override.Value[canonicalizer]
+}
+
+// NewCanonicalizer returns a canonicalizer using LOOP_CANONICALIZE.
+func NewCanonicalizer() *Canonicalizer {
+	env, _ := compileCanonicalizer(canonicalizePolicyFromEnv())
+	return &Canonicalizer{active: override.New(env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings. The policy must have passed Validate.
+func (c *Canonicalizer) Set(policy *CanonicalizePolicy) {
+	if c == nil {
+		return
+	}
+	var compiled *canonicalizer
+	if policy != nil {
+		compiled, _ = compileCanonicalizer(*policy)
+	}
+	c.active.Set(compiled)
+}
+
+// Apply returns the canonical form of prompt.
+func (c *Canonicalizer) Apply(prompt string) string {
+	if c == nil {
+		return prompt
+	}
+	active := c.active.Active()
+	if active == nil {
+		return prompt
+	}
+	return active.apply(prompt)
+}
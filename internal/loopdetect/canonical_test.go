@@ -0,0 +1,44 @@
+package loopdetect
+
+import "testing"
+
+func TestCanonicalizerBuiltins(t *testing.T) {
+	t.Setenv("LOOP_CANONICALIZE", "all")
+	c := NewCanonicalizer()
+
+	a := "[2026-10-16T09:15:02.123Z] run 3f2b8c1e-9a4d-4c3b-8f00-1234567890ab:  retry call_Ab12Cd34Ef at 1760606102\n\ntrace 4bf92f3577b34da6a3ce929d0e0e4736"
+	b := "[2026-10-16 09:17:45+02:00] run 0c8e5a7d-1b2f-4e6a-9c3d-abcdefabcdef: retry call_Zz98Yy76Xx at 1760606265123 trace 00f067aa0ba902b7aaaaaaaaaaaaaaaa"
+	if ca, cb := c.Apply(a), c.Apply(b); ca != cb {
+		t.Fatalf("expected identical canonical prompts:\n%q\n%q", ca, cb)
+	}
+	if got, want := c.Apply("  deploy   at 2026-10-16T09:15:02Z "), "deploy at <ts>"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestCanonicalizerFilePolicy(t *testing.T) {
+	t.Setenv("LOOP_CANONICALIZE", "")
+	c := NewCanonicalizer()
+	if got := c.Apply("attempt 7  of 10"); got != "attempt 7  of 10" {
+		t.Fatalf("expected no canonicalization by default, got %q", got)
+	}
+
+	policy := &CanonicalizePolicy{
+		Builtin:  []string{NormalizeWhitespace},
+		Patterns: []Replacement{{Pattern: `attempt \d+`, Replace: "attempt N"}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	c.Set(policy)
+	if got := c.Apply("attempt 7  of 10"); got != "attempt N of 10" {
+		t.Fatalf("got %q", got)
+	}
+
+	if err := (CanonicalizePolicy{Builtin: []string{"emails"}}).Validate(); err == nil {
+		t.Fatal("expected unknown builtin to be rejected")
+	}
+	if err := (CanonicalizePolicy{Patterns: []Replacement{{Pattern: "("}}}).Validate(); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+}
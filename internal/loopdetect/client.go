@@ -2,30 +2,41 @@ package loopdetect
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	pb "embedding-sidecar/proto"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
+	otelcodes "go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	"agent-sentinel/internal/telemetry"
 )
 
 // Client wraps the gRPC client for the embedding sidecar.
 type Client struct {
+	conn    *grpc.ClientConn
 	client  pb.EmbeddingServiceClient
 	timeout time.Duration
 	tracer  trace.Tracer
+	// disabled is set when the sidecar turns out to be incompatible.
+	disabled atomic.Bool
 }
 
-// New creates a client dialing over UDS with the given timeout.
-func New(udsPath string, timeout time.Duration) (*Client, error) {
+// New creates a client dialing over UDS with the given timeout. opts are added
+// to the dial options, e.g. interceptors.
+func New(udsPath string, timeout time.Duration, opts ...grpc.DialOption) (*Client, error) {
 	if udsPath == "" {
 		return nil, nil
 	}
@@ -36,21 +47,30 @@ func New(udsPath string, timeout time.Duration) (*Client, error) {
 			var d net.Dialer
 			return d.DialContext(ctx, "unix", udsPath)
 		}),
+		// Propagates the trace so the sidecar's CheckLoop span joins the request trace.
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 	}
+	dialOpts = append(dialOpts, opts...)
 	conn, err := grpc.Dial("unix://"+udsPath, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
+		conn:    conn,
 		client:  pb.NewEmbeddingServiceClient(conn),
 		timeout: timeout,
 		tracer:  tr,
 	}, nil
 }
 
+// Close closes the connection to the sidecar.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
 // Check calls the sidecar for loop detection. Fail-open on error.
 func (c *Client) Check(ctx context.Context, tenantID, prompt string) (*pb.CheckLoopResponse, error) {
-	if c == nil || c.client == nil || prompt == "" || tenantID == "" {
+	if c == nil || c.client == nil || c.disabled.Load() || prompt == "" || tenantID == "" {
 		return nil, nil
 	}
 	start := time.Now()
@@ -70,13 +90,14 @@ func (c *Client) Check(ctx context.Context, tenantID, prompt string) (*pb.CheckL
 		defer cancel()
 	}
 	resp, err := c.client.CheckLoop(callCtx, &pb.CheckLoopRequest{
-		TenantId: tenantID,
-		Prompt:   prompt,
+		TenantId:  tenantID,
+		Prompt:    prompt,
+		RequestId: telemetry.RequestID(ctx),
 	})
 	if err != nil {
 		if span != nil {
 			span.RecordError(err)
-			span.SetStatus(codes.Error, err.Error())
+			span.SetStatus(otelcodes.Error, err.Error())
 		}
 		return nil, err
 	}
@@ -91,3 +112,66 @@ func (c *Client) Check(ctx context.Context, tenantID, prompt string) (*pb.CheckL
 	slog.Debug("loop detect rpc", "tenant_id", tenantID, "duration_ms", dur.Milliseconds(), "timeout_ms", c.timeout.Milliseconds())
 	return resp, nil
 }
+
+// verifyRetryInterval spaces GetCapabilities calls while the sidecar starts.
+const verifyRetryInterval = 500 * time.Millisecond
+
+// Verify asks the sidecar for its capabilities, waiting until it is reachable
+// and ready or ctx is done, and logs them. An incompatible sidecar disables loop
+// detection (Check returns nothing) so a proxy/sidecar version mismatch shows
+// up as an error instead of wrong results. A sidecar predating
+// GetCapabilities speaks API version 1 and is accepted.
+func (c *Client) Verify(ctx context.Context) error {
+	if c == nil || c.client == nil {
+		return nil
+	}
+	caps, err := c.client.GetCapabilities(ctx, &pb.GetCapabilitiesRequest{ApiVersion: pb.APIVersion}, grpc.WaitForReady(true))
+	// A sidecar still warming up its model or checking its Redis index
+	// answers Unavailable; its capabilities are only final once it is ready.
+	for status.Code(err) == codes.Unavailable {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("get sidecar capabilities: sidecar not ready: %w", err)
+		case <-time.After(verifyRetryInterval):
+		}
+		caps, err = c.client.GetCapabilities(ctx, &pb.GetCapabilitiesRequest{ApiVersion: pb.APIVersion}, grpc.WaitForReady(true))
+	}
+	if status.Code(err) == codes.Unimplemented {
+		slog.Warn("Loop detection sidecar does not report capabilities; assuming API version 1")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get sidecar capabilities: %w", err)
+	}
+	if err := Compatible(caps); err != nil {
+		c.disabled.Store(true)
+		return err
+	}
+	slog.Info("Loop detection sidecar verified",
+		"api_version", caps.GetApiVersion(),
+		"model", caps.GetModelName(),
+		"dim", caps.GetEmbeddingDim(),
+		"similarity_threshold", caps.GetSimilarityThreshold(),
+		"history_size", caps.GetHistorySize(),
+		"features", caps.GetFeatures(),
+	)
+	return nil
+}
+
+// ErrIncompatibleSidecar is returned by Verify when the sidecar and proxy
+// cannot work together.
+var ErrIncompatibleSidecar = errors.New("incompatible loop detection sidecar")
+
+// Compatible checks that this proxy and a sidecar with caps support each
+// other's API versions and that the sidecar offers loop checks.
+func Compatible(caps *pb.GetCapabilitiesResponse) error {
+	switch {
+	case caps.GetApiVersion() < pb.MinAPIVersion:
+		return fmt.Errorf("%w: sidecar speaks API v%d, proxy needs at least v%d", ErrIncompatibleSidecar, caps.GetApiVersion(), pb.MinAPIVersion)
+	case caps.GetMinApiVersion() > pb.APIVersion:
+		return fmt.Errorf("%w: proxy speaks API v%d, sidecar needs at least v%d", ErrIncompatibleSidecar, pb.APIVersion, caps.GetMinApiVersion())
+	case !slices.Contains(caps.GetFeatures(), pb.FeatureCheckLoop):
+		return fmt.Errorf("%w: sidecar does not offer %s", ErrIncompatibleSidecar, pb.FeatureCheckLoop)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package loopdetect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "embedding-sidecar/proto"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"agent-sentinel/internal/telemetry"
+)
+
+type traceCapturingServer struct {
+	pb.UnimplementedEmbeddingServiceServer
+	spanCtx   trace.SpanContext
+	requestID string
+}
+
+func (s *traceCapturingServer) CheckLoop(ctx context.Context, req *pb.CheckLoopRequest) (*pb.CheckLoopResponse, error) {
+	s.spanCtx = trace.SpanContextFromContext(ctx)
+	s.requestID = req.GetRequestId()
+	return &pb.CheckLoopResponse{}, nil
+}
+
+func TestCheckPropagatesTrace(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	udsPath := filepath.Join(t.TempDir(), "sidecar.sock")
+	lis, err := net.Listen("unix", udsPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &traceCapturingServer{}
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	pb.RegisterEmbeddingServiceServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	client, err := New(udsPath, 2*time.Second)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	ctx, parent := tp.Tracer("test").Start(telemetry.WithRequestID(context.Background(), "req-1"), "llm_proxy_request")
+	defer parent.End()
+	if _, err := client.Check(ctx, "tenant", "prompt"); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+
+	if !srv.spanCtx.IsValid() {
+		t.Fatal("expected the sidecar handler to run inside a span")
+	}
+	if srv.spanCtx.TraceID() != parent.SpanContext().TraceID() {
+		t.Fatalf("sidecar span trace %s, want the proxy trace %s", srv.spanCtx.TraceID(), parent.SpanContext().TraceID())
+	}
+	if srv.requestID != "req-1" {
+		t.Fatalf("expected the request ID sent to the sidecar, got %q", srv.requestID)
+	}
+}
+
+type capabilitiesServer struct {
+	pb.UnimplementedEmbeddingServiceServer
+	caps *pb.GetCapabilitiesResponse
+	// unready is how many calls answer Unavailable, as while warming up.
+	unready atomic.Int32
+}
+
+func (s *capabilitiesServer) GetCapabilities(ctx context.Context, req *pb.GetCapabilitiesRequest) (*pb.GetCapabilitiesResponse, error) {
+	if s.unready.Add(-1) >= 0 {
+		return nil, status.Error(codes.Unavailable, "warming up")
+	}
+	if s.caps == nil { // a sidecar from before GetCapabilities
+		return s.UnimplementedEmbeddingServiceServer.GetCapabilities(ctx, req)
+	}
+	return s.caps, nil
+}
+
+func (s *capabilitiesServer) CheckLoop(context.Context, *pb.CheckLoopRequest) (*pb.CheckLoopResponse, error) {
+	return &pb.CheckLoopResponse{LoopDetected: true}, nil
+}
+
+func TestVerify(t *testing.T) {
+	compatible := &pb.GetCapabilitiesResponse{ApiVersion: pb.APIVersion, MinApiVersion: pb.MinAPIVersion, Features: []string{pb.FeatureCheckLoop}}
+	tooNew := &pb.GetCapabilitiesResponse{ApiVersion: pb.APIVersion + 1, MinApiVersion: pb.APIVersion + 1, Features: []string{pb.FeatureCheckLoop}}
+	cases := []struct {
+		name         string
+		caps         *pb.GetCapabilitiesResponse
+		unready      int32
+		incompatible bool
+	}{
+		{name: "compatible", caps: compatible},
+		{name: "compatible after warmup", caps: compatible, unready: 2},
+		{name: "predates capabilities", caps: nil},
+		{name: "requires newer proxy", caps: tooNew, incompatible: true},
+		{name: "no loop checks", caps: &pb.GetCapabilitiesResponse{ApiVersion: pb.APIVersion}, incompatible: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			udsPath := filepath.Join(t.TempDir(), "sidecar.sock")
+			lis, err := net.Listen("unix", udsPath)
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			grpcServer := grpc.NewServer()
+			srv := &capabilitiesServer{caps: tc.caps}
+			srv.unready.Store(tc.unready)
+			pb.RegisterEmbeddingServiceServer(grpcServer, srv)
+			go grpcServer.Serve(lis)
+			defer grpcServer.Stop()
+
+			client, err := New(udsPath, 2*time.Second)
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.Verify(ctx)
+			if got := errors.Is(err, ErrIncompatibleSidecar); got != tc.incompatible {
+				t.Fatalf("Verify() = %v, want incompatible=%v", err, tc.incompatible)
+			}
+			if !tc.incompatible && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp, _ := client.Check(context.Background(), "tenant", "prompt")
+			if got := resp != nil; got == tc.incompatible {
+				t.Fatalf("expected checks enabled=%v, got response %v", !tc.incompatible, resp)
+			}
+		})
+	}
+}
+
+type calibrationServer struct {
+	capabilitiesServer
+}
+
+func (s *calibrationServer) GetCalibration(ctx context.Context, req *pb.GetCalibrationRequest) (*pb.GetCalibrationResponse, error) {
+	return &pb.GetCalibrationResponse{
+		Enabled:                 true,
+		TargetFalsePositiveRate: 0.01,
+		Tenants: []*pb.TenantCalibration{{
+			TenantId:             req.GetTenantId(),
+			Samples:              500,
+			CurrentThreshold:     0.95,
+			RecommendedThreshold: 0.97,
+			CalibratedAt:         1_700_000_000,
+		}},
+	}, nil
+}
+
+func TestCalibration(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		srv     pb.EmbeddingServiceServer
+		enabled bool
+		tenants int
+	}{
+		{name: "calibrating", srv: &calibrationServer{}, enabled: true, tenants: 1},
+		{name: "predates calibration", srv: &capabilitiesServer{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			udsPath := filepath.Join(t.TempDir(), "sidecar.sock")
+			lis, err := net.Listen("unix", udsPath)
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			grpcServer := grpc.NewServer()
+			pb.RegisterEmbeddingServiceServer(grpcServer, tc.srv)
+			go grpcServer.Serve(lis)
+			defer grpcServer.Stop()
+
+			client, err := New(udsPath, 2*time.Second)
+			if err != nil {
+				t.Fatalf("new client: %v", err)
+			}
+			got, err := client.Calibration(context.Background(), "acme")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Enabled != tc.enabled || len(got.Tenants) != tc.tenants {
+				t.Fatalf("unexpected calibration %+v", got)
+			}
+			if tc.enabled && (got.Tenants[0].TenantID != "acme" || got.Tenants[0].RecommendedThreshold != 0.97 || got.Tenants[0].CalibratedAt.Unix() != 1_700_000_000) {
+				t.Fatalf("unexpected tenant calibration %+v", got.Tenants[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package loopdetect
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+)
+
+// DefaultHint is injected on loop detection when nothing else is configured.
+const DefaultHint = "System: break the loop and respond with a new approach."
+
+// maxSnippetRunes bounds the similar prompt quoted in a hint.
+const maxSnippetRunes = 160
+
+// Hint is an intervention hint: a text/template rendered with HintData, and an
+// optional suggested alternative available to it as {{.Alternative}}.
+type Hint struct {
+	Template    string `json:"template"`
+	Alternative string `json:"alternative,omitempty"`
+}
+
+// HintPolicy overrides the intervention hint per tenant and per provider
+// ("openai", "anthropic", "gemini"). The most specific match wins: tenant,
+// then provider, then Default, then LOOP_INTERVENTION_HINT.
+type HintPolicy struct {
+	Default   *Hint           `json:"default,omitempty"`
+	Providers map[string]Hint `json:"providers,omitempty"`
+	Tenants   map[string]Hint `json:"tenants,omitempty"`
+}
+
+// HintData is what hint templates can reference.
+type HintData struct {
+	Tenant   string
+	Provider string
+	Model    string
+	// SimilarPrompt is the start of the earlier, similar prompt.
+	SimilarPrompt string
+	// Similarity is the cosine similarity, 0..1 (e.g. {{printf "%.2f" .Similarity}}).
+	Similarity float64
+	// ToolCycle is the repeating tool sequence, e.g. "search → read_file",
+	// when the loop was found by tool-call cycle detection.
+	ToolCycle   string
+	Alternative string
+}
+
+// Validate checks that every template in the policy parses.
+func (p HintPolicy) Validate() error {
+	if p.Default != nil {
+		if _, err := parseHint("default", *p.Default); err != nil {
+			return err
+		}
+	}
+	for name, h := range p.Providers {
+		if _, err := parseHint("provider "+name, h); err != nil {
+			return err
+		}
+	}
+	for name, h := range p.Tenants {
+		if _, err := parseHint("tenant "+name, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type parsedHint struct {
+	tmpl        *template.Template
+	alternative string
+}
+
+func parseHint(name string, h Hint) (*parsedHint, error) {
+	if strings.TrimSpace(h.Template) == "" {
+		return nil, fmt.Errorf("loop_hints %s: template must not be empty", name)
+	}
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(h.Template)
+	if err != nil {
+		return nil, fmt.Errorf("loop_hints %s: %w", name, err)
+	}
+	return &parsedHint{tmpl: tmpl, alternative: h.Alternative}, nil
+}
+
+type parsedHints struct {
+	fallback  *parsedHint
+	providers map[string]*parsedHint
+	tenants   map[string]*parsedHint
+}
+
+// Hints renders intervention hints. Safe for concurrent use.
+type Hints struct {
+	env *parsedHint
+
+	mu       sync.RWMutex
+	override *parsedHints
+}
+
+// NewHints returns hints rendering envTemplate (LOOP_INTERVENTION_HINT), or
+// DefaultHint when it is empty or does not parse.
+func NewHints(envTemplate string) *Hints {
+	if envTemplate == "" {
+		envTemplate = DefaultHint
+	}
+	env, err := parseHint("LOOP_INTERVENTION_HINT", Hint{Template: envTemplate})
+	if err != nil {
+		slog.Warn("Invalid loop intervention hint, using the default", "error", err)
+		env, _ = parseHint("default", Hint{Template: DefaultHint})
+	}
+	return &Hints{env: env}
+}
+
+// Set replaces the per-tenant and per-provider overrides; nil removes them.
+// The policy must have passed Validate.
+func (h *Hints) Set(policy *HintPolicy) {
+	if h == nil {
+		return
+	}
+	var parsed *parsedHints
+	if policy != nil {
+		parsed = &parsedHints{providers: map[string]*parsedHint{}, tenants: map[string]*parsedHint{}}
+		if policy.Default != nil {
+			parsed.fallback, _ = parseHint("default", *policy.Default)
+		}
+		for name, hint := range policy.Providers {
+			if p, err := parseHint("provider "+name, hint); err == nil {
+				parsed.providers[name] = p
+			}
+		}
+		for name, hint := range policy.Tenants {
+			if p, err := parseHint("tenant "+name, hint); err == nil {
+				parsed.tenants[name] = p
+			}
+		}
+	}
+	h.mu.Lock()
+	h.override = parsed
+	h.mu.Unlock()
+}
+
+// Render returns the hint for data.Tenant and data.Provider. A template that
+// fails to execute falls back to DefaultHint.
+func (h *Hints) Render(data HintData) string {
+	if h == nil {
+		return DefaultHint
+	}
+	hint := h.pick(data.Tenant, data.Provider)
+	data.Alternative = hint.alternative
+	data.SimilarPrompt = snippet(data.SimilarPrompt)
+	var out strings.Builder
+	if err := hint.tmpl.Execute(&out, data); err != nil {
+		slog.Warn("Failed to render loop intervention hint", "template", hint.tmpl.Name(), "error", err)
+		return DefaultHint
+	}
+	return out.String()
+}
+
+func (h *Hints) pick(tenant, provider string) *parsedHint {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if o := h.override; o != nil {
+		if p, ok := o.tenants[tenant]; ok {
+			return p
+		}
+		if p, ok := o.providers[provider]; ok {
+			return p
+		}
+		if o.fallback != nil {
+			return o.fallback
+		}
+	}
+	return h.env
+}
+
+// snippet shortens s to maxSnippetRunes runes on one line.
+func snippet(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= maxSnippetRunes {
+		return s
+	}
+	return string([]rune(s)[:maxSnippetRunes]) + "…"
+}
@@ -0,0 +1,59 @@
+package loopdetect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHintsRenderPrecedence(t *testing.T) {
+	h := NewHints("")
+	data := HintData{Tenant: "acme", Provider: "anthropic", SimilarPrompt: "run   the\ntests again", Similarity: 0.973}
+	if got := h.Render(data); got != DefaultHint {
+		t.Fatalf("expected default hint, got %q", got)
+	}
+
+	policy := &HintPolicy{
+		Default:   &Hint{Template: "default for {{.Tenant}}"},
+		Providers: map[string]Hint{"anthropic": {Template: `You repeated "{{.SimilarPrompt}}" ({{printf "%.2f" .Similarity}}). {{.Alternative}}`, Alternative: "Try a different tool."}},
+		Tenants:   map[string]Hint{"vip": {Template: "vip hint"}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	h.Set(policy)
+
+	if got, want := h.Render(data), `You repeated "run the tests again" (0.97). Try a different tool.`; got != want {
+		t.Fatalf("provider hint got %q want %q", got, want)
+	}
+	data.Tenant = "vip"
+	if got := h.Render(data); got != "vip hint" {
+		t.Fatalf("expected tenant hint, got %q", got)
+	}
+	data.Tenant, data.Provider = "acme", "openai"
+	if got := h.Render(data); got != "default for acme" {
+		t.Fatalf("expected policy default, got %q", got)
+	}
+
+	h.Set(nil)
+	if got := h.Render(data); got != DefaultHint {
+		t.Fatalf("expected env hint after clearing overrides, got %q", got)
+	}
+}
+
+func TestHintPolicyValidate(t *testing.T) {
+	bad := HintPolicy{Tenants: map[string]Hint{"acme": {Template: "{{.Tenant"}}}
+	if err := bad.Validate(); err == nil || !strings.Contains(err.Error(), "tenant acme") {
+		t.Fatalf("expected parse error naming the tenant, got %v", err)
+	}
+	if err := (HintPolicy{Default: &Hint{}}).Validate(); err == nil {
+		t.Fatal("expected empty template to be rejected")
+	}
+}
+
+func TestHintSnippetTruncates(t *testing.T) {
+	long := strings.Repeat("é", maxSnippetRunes+10)
+	got := snippet(long)
+	if !strings.HasSuffix(got, "…") || len([]rune(got)) != maxSnippetRunes+1 {
+		t.Fatalf("unexpected snippet %q", got)
+	}
+}
@@ -0,0 +1,214 @@
+package loopdetect
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/providers"
+)
+
+const (
+	defaultToolCycleMaxPeriod = 4
+	defaultToolCycleRepeats   = 2
+	defaultToolCycleTTL       = 30 * time.Minute
+	// maxToolCycleSessions bounds memory; the least recently seen session is
+	// evicted first.
+	maxToolCycleSessions = 10000
+)
+
+// ToolCycle is a repeating sequence of tool-calling steps, e.g. A→B→A→B.
+type ToolCycle struct {
+	// Steps are the tool names of each step in one period; parallel calls in a
+	// step are joined with "+".
+	Steps   []string
+	Repeats int
+}
+
+// String renders the cycle as "search → read_file".
+func (c ToolCycle) String() string {
+	return strings.Join(c.Steps, " → ")
+}
+
+type toolStep struct {
+	hash  [sha256.Size]byte
+	names string
+}
+
+type toolChain struct {
+	steps  []toolStep
+	lastID string
+	cycle  *ToolCycle
+	seen   time.Time
+}
+
+// ToolCycles keeps a per-session chain of hashed tool-calling steps (tool name
+// and normalized arguments) and flags exact repetition cycles, which
+// embeddings detect poorly when long arguments are mostly boilerplate. State is
+// in memory, so sessions should be pinned to a replica (see affinity). Safe for
+// concurrent use.
+type ToolCycles struct {
+	maxPeriod int
+	repeats   int
+	ttl       time.Duration
+	canon     *Canonicalizer
+	now       func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*toolChain
+}
+
+// NewToolCycles returns a detector flagging cycles of up to maxPeriod steps
+// that repeat back to back repeats times. Arguments are normalized with canon
+// (which may be nil) before hashing.
+func NewToolCycles(maxPeriod, repeats int, ttl time.Duration, canon *Canonicalizer) *ToolCycles {
+	if maxPeriod <= 0 {
+		maxPeriod = defaultToolCycleMaxPeriod
+	}
+	if repeats < 2 {
+		repeats = defaultToolCycleRepeats
+	}
+	if ttl <= 0 {
+		ttl = defaultToolCycleTTL
+	}
+	return &ToolCycles{
+		maxPeriod: maxPeriod,
+		repeats:   repeats,
+		ttl:       ttl,
+		canon:     canon,
+		now:       time.Now,
+		sessions:  make(map[string]*toolChain),
+	}
+}
+
+// ToolCyclesFromEnv returns a detector when LOOP_TOOL_CYCLE_DETECTION is true,
+// configured by LOOP_TOOL_CYCLE_MAX_PERIOD, LOOP_TOOL_CYCLE_REPEATS and
+// LOOP_TOOL_CYCLE_TTL_SECONDS; otherwise nil.
+func ToolCyclesFromEnv(canon *Canonicalizer) *ToolCycles {
+	if enabled, _ := strconv.ParseBool(os.Getenv("LOOP_TOOL_CYCLE_DETECTION")); !enabled {
+		return nil
+	}
+	maxPeriod, _ := strconv.Atoi(os.Getenv("LOOP_TOOL_CYCLE_MAX_PERIOD"))
+	repeats, _ := strconv.Atoi(os.Getenv("LOOP_TOOL_CYCLE_REPEATS"))
+	ttlSeconds, _ := strconv.Atoi(os.Getenv("LOOP_TOOL_CYCLE_TTL_SECONDS"))
+	return NewToolCycles(maxPeriod, repeats, time.Duration(ttlSeconds)*time.Second, canon)
+}
+
+// Observe appends the tool calls of a request's latest assistant turn to the
+// session's chain and returns the cycle at its end, if any. A request that
+// replays the step already recorded (same call IDs, e.g. a client retry) is
+// not appended again and reports the same result.
+func (d *ToolCycles) Observe(session string, calls []providers.ToolCall) *ToolCycle {
+	if d == nil || len(calls) == 0 {
+		return nil
+	}
+	step, id := d.step(calls)
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	chain, ok := d.sessions[session]
+	if !ok || now.Sub(chain.seen) > d.ttl {
+		d.evictLocked(now)
+		chain = &toolChain{}
+		d.sessions[session] = chain
+	}
+	chain.seen = now
+	if id != "" && id == chain.lastID {
+		return chain.cycle
+	}
+	chain.lastID = id
+	chain.steps = append(chain.steps, step)
+	if limit := d.maxPeriod * d.repeats; len(chain.steps) > limit {
+		chain.steps = slices.Delete(chain.steps, 0, len(chain.steps)-limit)
+	}
+	chain.cycle = d.detect(chain.steps)
+	return chain.cycle
+}
+
+// step hashes the calls, sorted so parallel calls in any order match, and
+// returns the joined call IDs identifying this step.
+func (d *ToolCycles) step(calls []providers.ToolCall) (toolStep, string) {
+	keys := make([]string, 0, len(calls))
+	names := make([]string, 0, len(calls))
+	ids := make([]string, 0, len(calls))
+	for _, call := range calls {
+		keys = append(keys, call.Name+"\x00"+d.normalizeArgs(call.Arguments))
+		names = append(names, call.Name)
+		if call.ID != "" {
+			ids = append(ids, call.ID)
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(names)
+	sort.Strings(ids)
+	return toolStep{
+		hash:  sha256.Sum256([]byte(strings.Join(keys, "\x01"))),
+		names: strings.Join(names, "+"),
+	}, strings.Join(ids, ",")
+}
+
+// normalizeArgs re-encodes JSON arguments with sorted keys and no insignificant
+// whitespace, then applies the canonicalizer.
+func (d *ToolCycles) normalizeArgs(args string) string {
+	var v any
+	if err := json.Unmarshal([]byte(args), &v); err == nil {
+		if b, err := json.Marshal(v); err == nil {
+			args = string(b)
+		}
+	}
+	return d.canon.Apply(args)
+}
+
+// detect returns the shortest period whose last repeats occurrences are
+// identical back to back.
+func (d *ToolCycles) detect(steps []toolStep) *ToolCycle {
+	for period := 1; period <= d.maxPeriod; period++ {
+		n := period * d.repeats
+		if len(steps) < n {
+			return nil
+		}
+		tail := steps[len(steps)-n:]
+		repeating := true
+		for i := period; i < n && repeating; i++ {
+			repeating = tail[i].hash == tail[i-period].hash
+		}
+		if !repeating {
+			continue
+		}
+		cycle := &ToolCycle{Repeats: d.repeats}
+		for _, s := range tail[:period] {
+			cycle.Steps = append(cycle.Steps, s.names)
+		}
+		return cycle
+	}
+	return nil
+}
+
+// evictLocked drops expired sessions, and the least recently seen one when the
+// map is still full. d.mu must be held.
+func (d *ToolCycles) evictLocked(now time.Time) {
+	if len(d.sessions) < maxToolCycleSessions {
+		return
+	}
+	var oldest string
+	var oldestSeen time.Time
+	for key, chain := range d.sessions {
+		if now.Sub(chain.seen) > d.ttl {
+			delete(d.sessions, key)
+			continue
+		}
+		if oldest == "" || chain.seen.Before(oldestSeen) {
+			oldest, oldestSeen = key, chain.seen
+		}
+	}
+	if len(d.sessions) >= maxToolCycleSessions {
+		delete(d.sessions, oldest)
+	}
+}
@@ -0,0 +1,68 @@
+package loopdetect
+
+import (
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/providers"
+)
+
+func TestToolCyclesDetectsAlternation(t *testing.T) {
+	d := NewToolCycles(4, 2, time.Minute, nil)
+	search := func(id string) []providers.ToolCall {
+		return []providers.ToolCall{{ID: id, Name: "search", Arguments: `{"query": "flaky test", "limit": 10}`}}
+	}
+	read := func(id string) []providers.ToolCall {
+		// Same arguments with different key order and spacing.
+		return []providers.ToolCall{{ID: id, Name: "read_file", Arguments: `{"path":"a.go","lines":[1,200]}`}}
+	}
+
+	if c := d.Observe("s1", search("1")); c != nil {
+		t.Fatalf("unexpected cycle after one step: %v", c)
+	}
+	if c := d.Observe("s1", read("2")); c != nil {
+		t.Fatalf("unexpected cycle after two steps: %v", c)
+	}
+	d.Observe("s1", []providers.ToolCall{{ID: "3", Name: "search", Arguments: `{"limit":10,"query":"flaky test"}`}})
+	c := d.Observe("s1", read("4"))
+	if c == nil || c.String() != "search → read_file" || c.Repeats != 2 {
+		t.Fatalf("expected search → read_file cycle, got %v", c)
+	}
+
+	// Another session has its own chain.
+	if c := d.Observe("s2", search("5")); c != nil {
+		t.Fatalf("sessions should not share chains, got %v", c)
+	}
+}
+
+func TestToolCyclesIgnoresReplayedStep(t *testing.T) {
+	d := NewToolCycles(4, 2, time.Minute, nil)
+	step := []providers.ToolCall{{ID: "call_1", Name: "list", Arguments: `{}`}}
+	d.Observe("s", step)
+	if c := d.Observe("s", step); c != nil {
+		t.Fatalf("a retried request must not count as a repeat, got %v", c)
+	}
+	if c := d.Observe("s", []providers.ToolCall{{ID: "call_2", Name: "list", Arguments: `{}`}}); c == nil || c.String() != "list" {
+		t.Fatalf("expected period-1 cycle, got %v", c)
+	}
+}
+
+func TestToolCyclesCanonicalizesArguments(t *testing.T) {
+	t.Setenv("LOOP_CANONICALIZE", "timestamps")
+	d := NewToolCycles(2, 2, time.Minute, NewCanonicalizer())
+	d.Observe("s", []providers.ToolCall{{Name: "poll", Arguments: `{"since":"2026-10-16T09:00:00Z"}`}})
+	if c := d.Observe("s", []providers.ToolCall{{Name: "poll", Arguments: `{"since":"2026-10-16T09:00:05Z"}`}}); c == nil {
+		t.Fatal("expected timestamps to be ignored when hashing arguments")
+	}
+}
+
+func TestToolCyclesExpiresSessions(t *testing.T) {
+	d := NewToolCycles(4, 2, time.Minute, nil)
+	now := time.Unix(0, 0)
+	d.now = func() time.Time { return now }
+	d.Observe("s", []providers.ToolCall{{Name: "list"}})
+	now = now.Add(2 * time.Minute)
+	if c := d.Observe("s", []providers.ToolCall{{Name: "list"}}); c != nil {
+		t.Fatalf("expected expired chain to restart, got %v", c)
+	}
+}
@@ -0,0 +1,299 @@
+// Package mcp puts the proxy between agents and their Model Context Protocol
+// tool servers. Agents reach a server at /mcp/{name} on the proxy, which
+// forwards the Streamable HTTP transport to the server's URL; the tools/call
+// requests in that traffic get per-tool rate limits, audit logging and loop
+// detection, as completions do.
+//
+// Tools are identified as "server/tool", e.g. "github/create_issue".
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/override"
+	"agent-sentinel/internal/ratelimit"
+)
+
+// PathPrefix is where MCP servers are served on the proxy.
+const PathPrefix = "/mcp/"
+
+// HeaderSession carries the MCP session ID assigned by the server.
+const HeaderSession = "Mcp-Session-Id"
+
+// MethodToolsCall is the JSON-RPC method invoking a tool.
+const MethodToolsCall = "tools/call"
+
+// JSON-RPC error codes for tool calls the proxy refuses, from the range
+// JSON-RPC leaves to implementations.
+const (
+	CodeToolRateExceeded = -32001
+	CodeToolLoop         = -32002
+)
+
+const defaultLoopRepeats = 3
+
+// Route splits a proxy path into the server name and the path below it; ok
+// is false for paths outside PathPrefix.
+func Route(path string) (server, rest string, ok bool) {
+	trimmed, found := strings.CutPrefix(path, PathPrefix)
+	if !found {
+		return "", "", false
+	}
+	server, rest, _ = strings.Cut(trimmed, "/")
+	if server == "" {
+		return "", "", false
+	}
+	if rest != "" {
+		rest = "/" + rest
+	}
+	return server, rest, true
+}
+
+// Call is a tools/call request.
+type Call struct {
+	// ID is the JSON-RPC request ID, as sent.
+	ID json.RawMessage
+	// Tool is "server/tool".
+	Tool string
+	// Arguments are the tool arguments, as sent.
+	Arguments json.RawMessage
+}
+
+type message struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"params"`
+}
+
+// ParseCalls returns the tools/call requests in body, a JSON-RPC message or
+// batch sent to server. Other messages (initialize, tools/list,
+// notifications, responses) are ignored.
+func ParseCalls(server string, body []byte) ([]Call, error) {
+	body = bytes.TrimSpace(body)
+	var messages []message
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &messages); err != nil {
+			return nil, err
+		}
+	} else {
+		var m message
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+		messages = []message{m}
+	}
+	var calls []Call
+	for _, m := range messages {
+		if m.Method != MethodToolsCall || m.Params.Name == "" {
+			continue
+		}
+		calls = append(calls, Call{ID: m.ID, Tool: server + "/" + m.Params.Name, Arguments: m.Params.Arguments})
+	}
+	return calls, nil
+}
+
+// ErrorResponse returns a JSON-RPC error response to the request with id.
+func ErrorResponse(id json.RawMessage, code int, message string, data map[string]any) map[string]any {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	e := map[string]any{"code": code, "message": message}
+	if data != nil {
+		e["data"] = data
+	}
+	return map[string]any{"jsonrpc": "2.0", "id": id, "error": e}
+}
+
+// ParseServers parses "name=url,..." into server URLs.
+func ParseServers(value string) (map[string]*url.URL, error) {
+	servers := make(map[string]*url.URL)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("MCP server %q (want name=url)", pair)
+		}
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("MCP server %s: URL must be http or https", name)
+		}
+		if _, dup := servers[name]; dup {
+			return nil, fmt.Errorf("duplicate MCP server %q", name)
+		}
+		servers[name] = u
+	}
+	return servers, nil
+}
+
+// ServersFromEnv reads MCP_SERVERS; it returns no servers when unset.
+func ServersFromEnv() (map[string]*url.URL, error) {
+	return ParseServers(os.Getenv("MCP_SERVERS"))
+}
+
+// NewProxy returns a handler forwarding /mcp/{name}/... to the named server
+// through transport. Responses, including event streams, are passed through
+// as they are written; unknown servers get a 404.
+func NewProxy(servers map[string]*url.URL, transport http.RoundTripper) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			server, rest, _ := Route(pr.In.URL.Path)
+			target := servers[server]
+			pr.SetURL(target)
+			pr.Out.URL.Path, pr.Out.URL.RawPath = target.Path, ""
+			if rest != "" {
+				pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + rest
+			}
+			pr.SetXForwarded()
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, http.StatusBadGateway, "MCP server unavailable", "mcp_server_unavailable")
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server, _, ok := Route(r.URL.Path)
+		if !ok || servers[server] == nil {
+			writeError(w, http.StatusNotFound, "Unknown MCP server: "+server, "mcp_server_not_found")
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "mcp_error",
+			"code":    code,
+		},
+	})
+}
+
+// Policy sets per-tool rate limits. Each tenant gets a token bucket per tool.
+type Policy struct {
+	// Default applies to tools without their own rate; a zero Burst leaves
+	// them unthrottled.
+	Default ratelimit.SmoothingRate `json:"default"`
+	// Tools overrides Default per tool. Entries match a tool exactly, or by
+	// prefix with a trailing "*" (e.g. "github/*"); an exact match wins, then
+	// the longest prefix. Each tool still has its own bucket.
+	Tools map[string]ratelimit.SmoothingRate `json:"tools,omitempty"`
+}
+
+// Validate checks an MCP policy.
+func (p Policy) Validate() error {
+	check := func(name string, r ratelimit.SmoothingRate) error {
+		if r.Burst < 0 || r.RatePerSecond < 0 {
+			return fmt.Errorf("mcp %s: burst and rate_per_second must not be negative", name)
+		}
+		if r.Burst > 0 && r.RatePerSecond == 0 {
+			return fmt.Errorf("mcp %s: rate_per_second is required with a burst", name)
+		}
+		return nil
+	}
+	if err := check("default", p.Default); err != nil {
+		return err
+	}
+	for tool, r := range p.Tools {
+		if tool == "" {
+			return errors.New("mcp tools entries must not be empty")
+		}
+		if err := check(tool, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For returns tool's rate; ok is false when the tool is not throttled.
+func (p Policy) For(tool string) (ratelimit.SmoothingRate, bool) {
+	r, found := p.Tools[tool]
+	if !found {
+		r = p.Default
+		longest := -1
+		for pattern, rate := range p.Tools {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(tool, prefix) && len(prefix) > longest {
+				r, longest = rate, len(prefix)
+			}
+		}
+	}
+	return r, r.Burst > 0 && r.RatePerSecond > 0
+}
+
+// policyFromEnv reads MCP_TOOL_BURST and MCP_TOOL_RATE_PER_SECOND.
+func policyFromEnv() Policy {
+	var p Policy
+	if v, err := strconv.Atoi(os.Getenv("MCP_TOOL_BURST")); err == nil && v > 0 {
+		p.Default.Burst = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("MCP_TOOL_RATE_PER_SECOND"), 64); err == nil && v > 0 {
+		p.Default.RatePerSecond = v
+	}
+	return p
+}
+
+// Guard holds the active MCP policy. Safe for concurrent use.
+type Guard struct {
+	active override.Value[Policy]
+}
+
+// NewGuard returns a guard using the MCP_TOOL_* variables.
+func NewGuard() *Guard {
+	env := policyFromEnv()
+	return &Guard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *Guard) Set(policy *Policy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *Guard) Policy() Policy {
+	if g == nil {
+		return Policy{}
+	}
+	return g.active.Get()
+}
+
+// LoopsFromEnv returns a detector for repeated tool calls when
+// MCP_LOOP_DETECTION is true, flagging identical calls, or cycles of up to
+// MCP_LOOP_MAX_PERIOD calls, repeated MCP_LOOP_REPEATS times (default 3) within
+// MCP_LOOP_TTL_SECONDS of each other; otherwise nil.
+func LoopsFromEnv(canon *loopdetect.Canonicalizer) *loopdetect.ToolCycles {
+	if enabled, _ := strconv.ParseBool(os.Getenv("MCP_LOOP_DETECTION")); !enabled {
+		return nil
+	}
+	maxPeriod, _ := strconv.Atoi(os.Getenv("MCP_LOOP_MAX_PERIOD"))
+	repeats, err := strconv.Atoi(os.Getenv("MCP_LOOP_REPEATS"))
+	if err != nil || repeats < 2 {
+		repeats = defaultLoopRepeats
+	}
+	ttlSeconds, _ := strconv.Atoi(os.Getenv("MCP_LOOP_TTL_SECONDS"))
+	return loopdetect.NewToolCycles(maxPeriod, repeats, time.Duration(ttlSeconds)*time.Second, canon)
+}
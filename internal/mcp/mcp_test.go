@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+func TestRoute(t *testing.T) {
+	for path, want := range map[string][2]string{
+		"/mcp/github":          {"github", ""},
+		"/mcp/github/messages": {"github", "/messages"},
+	} {
+		server, rest, ok := Route(path)
+		if !ok || server != want[0] || rest != want[1] {
+			t.Errorf("Route(%q) = %q %q %v, want %q %q", path, server, rest, ok, want[0], want[1])
+		}
+	}
+	for _, path := range []string{"/v1/chat/completions", "/mcp/", "/mcpx/github"} {
+		if _, _, ok := Route(path); ok {
+			t.Errorf("Route(%q) matched, want no MCP server", path)
+		}
+	}
+}
+
+func TestParseCalls(t *testing.T) {
+	calls, err := ParseCalls("github", []byte(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search","arguments":{"q":"loop"}}}`))
+	if err != nil || len(calls) != 1 || string(calls[0].ID) != "7" || calls[0].Tool != "github/search" || string(calls[0].Arguments) != `{"q":"loop"}` {
+		t.Fatalf("ParseCalls = %+v, %v", calls, err)
+	}
+
+	calls, err = ParseCalls("fs", []byte(` [
+		{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"read_file","arguments":{"path":"/tmp"}}},
+		{"jsonrpc":"2.0","id":"b","method":"tools/list"},
+		{"jsonrpc":"2.0","method":"notifications/initialized"}
+	]`))
+	if err != nil || len(calls) != 1 || string(calls[0].ID) != `"a"` || calls[0].Tool != "fs/read_file" {
+		t.Fatalf("ParseCalls(batch) = %+v, %v", calls, err)
+	}
+
+	if _, err := ParseCalls("fs", []byte(`not json`)); err == nil {
+		t.Fatal("expected an error for a body that is not JSON-RPC")
+	}
+}
+
+func TestParseServers(t *testing.T) {
+	servers, err := ParseServers("github=https://mcp.example.com/mcp, fs=http://localhost:3000")
+	if err != nil || len(servers) != 2 || servers["github"].Path != "/mcp" || servers["fs"].Host != "localhost:3000" {
+		t.Fatalf("ParseServers = %v, %v", servers, err)
+	}
+	for _, value := range []string{"github", "github=ftp://example.com", "a/b=http://example.com", "x=http://a,x=http://b"} {
+		if _, err := ParseServers(value); err == nil {
+			t.Errorf("ParseServers(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestProxyForwardsToServer(t *testing.T) {
+	var gotPath, gotSession string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotSession = r.URL.Path, r.Header.Get(HeaderSession)
+		w.Header().Set(HeaderSession, "s-1")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL + "/mcp")
+	proxy := httptest.NewServer(NewProxy(map[string]*url.URL{"github": target}, http.DefaultTransport))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/mcp/github", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`))
+	req.Header.Set(HeaderSession, "s-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || gotPath != "/mcp" || gotSession != "s-1" || resp.Header.Get(HeaderSession) != "s-1" || !strings.Contains(string(body), `"result"`) {
+		t.Fatalf("response = %d %s, upstream saw path %q session %q", resp.StatusCode, body, gotPath, gotSession)
+	}
+
+	resp, err = http.Post(proxy.URL+"/mcp/unknown", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown server status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	p := Policy{
+		Default: ratelimit.SmoothingRate{Burst: 20, RatePerSecond: 2},
+		Tools: map[string]ratelimit.SmoothingRate{
+			"github/*":            {Burst: 10, RatePerSecond: 1},
+			"github/create_*":     {Burst: 2, RatePerSecond: 0.1},
+			"github/create_issue": {Burst: 1, RatePerSecond: 0.01},
+			"fs/*":                {},
+		},
+	}
+	for tool, want := range map[string]int{
+		"github/create_issue": 1,
+		"github/create_pr":    2,
+		"github/search":       10,
+		"slack/post":          20,
+	} {
+		if r, ok := p.For(tool); !ok || r.Burst != want {
+			t.Errorf("For(%q) = %+v %v, want burst %d", tool, r, ok, want)
+		}
+	}
+	if _, ok := p.For("fs/read_file"); ok {
+		t.Fatal("expected a zero rate to leave the tool unthrottled")
+	}
+	if err := (Policy{Tools: map[string]ratelimit.SmoothingRate{"a/b": {Burst: -1}}}).Validate(); err == nil {
+		t.Fatal("expected a negative burst to be rejected")
+	}
+}
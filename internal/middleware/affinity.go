@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+
+	"agent-sentinel/internal/affinity"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderAffinity names the replica that should serve a session's requests. It
+// is set on responses; load balancers route on it when clients echo it back.
+const HeaderAffinity = "X-Sentinel-Affinity"
+
+// HeaderSession optionally narrows affinity from a tenant to one agent session.
+const HeaderSession = "X-Sentinel-Session"
+
+// Affinity adds the session's owning replica to every tenant response, so
+// consecutive prompts from one agent can be routed to the replica whose loop
+// checks have already seen them. Requests served elsewhere are counted.
+func Affinity(ring *affinity.Ring, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if ring == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if tenantID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := tenantID
+			if session := r.Header.Get(HeaderSession); session != "" {
+				key += "/" + session
+			}
+			owner := ring.Owner(key)
+			w.Header().Set(HeaderAffinity, owner)
+			routed := "owner"
+			if owner != ring.Self() {
+				routed = "other"
+			}
+			telemetry.IncAffinityRequest(r.Context(), routed)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
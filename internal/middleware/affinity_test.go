@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/affinity"
+)
+
+func TestAffinitySetsOwnerHeader(t *testing.T) {
+	ring := affinity.New([]string{"sentinel-0", "sentinel-1"}, "sentinel-0")
+	handler := Affinity(ring, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(tenant, session string) string {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		req.Header.Set(HeaderSession, session)
+		handler.ServeHTTP(rr, req)
+		return rr.Header().Get(HeaderAffinity)
+	}
+	if got := serve("acme", ""); got != ring.Owner("acme") {
+		t.Fatalf("expected tenant owner %q, got %q", ring.Owner("acme"), got)
+	}
+	if got := serve("acme", "run-7"); got != ring.Owner("acme/run-7") {
+		t.Fatalf("expected session owner %q, got %q", ring.Owner("acme/run-7"), got)
+	}
+	if got := serve("", ""); got != "" {
+		t.Fatalf("expected no affinity without a tenant, got %q", got)
+	}
+}
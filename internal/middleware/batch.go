@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/batches"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// maxBatchResponseBytes bounds the response captured to read a submitted
+// batch's ID; batch objects are far smaller.
+const maxBatchResponseBytes = 1 << 20
+
+// BatchAccounting charges batch submissions, whose requests are billed long
+// after the response and never pass through the proxy, against the tenant's
+// budget. The batch's requests are estimated at batch prices and reserved
+// like a single request; once the provider accepts the batch, the estimate
+// is settled as spent and the job is tracked so the accountant can settle
+// its actual cost when it ends. A batch whose requests cannot be read is let
+// through and charged in full when it is settled.
+func BatchAccounting(accountant *batches.Accountant, limiter ratelimit.Limiter, provider providers.Provider, headerName string, models *ratelimit.ModelCatalog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if accountant == nil || limiter == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if r.Method != http.MethodPost || tenantID == "" || !accountant.IsCreate(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requests, err := accountant.Requests(ctx, data)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to read batch requests, charging the batch when it settles",
+					"error", err,
+					"tenant_id", tenantID,
+				)
+			}
+			estimate, model := estimateBatch(limiter, provider, models, requests)
+
+			var reservationID string
+			if estimate > 0 {
+				result, err := limiter.CheckLimitAndIncrement(ctx, tenantID, estimate)
+				switch {
+				case err != nil:
+					slog.WarnContext(ctx, "Rate limit check failed for batch, failing open",
+						"error", err,
+						"tenant_id", tenantID,
+					)
+					telemetry.RecordRateLimitRequest(ctx, "fail_open", "redis_error", provider.Name(), model, tenantID)
+					// Nothing was reserved, so the whole cost is charged on settlement.
+					estimate = 0
+				case !result.Allowed:
+					rejectBatchOverLimit(ctx, w, limiter, provider, tenantID, model, len(requests), estimate, result)
+					return
+				default:
+					reservationID = result.ReservationID
+				}
+			}
+
+			// The batch object is read from the response, so ask for it
+			// uncompressed; the transport still negotiates compression upstream.
+			r.Header.Del("Accept-Encoding")
+			capture := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(capture, r)
+
+			var batch struct {
+				ID string `json:"id"`
+			}
+			accepted := capture.status < http.StatusBadRequest && !capture.truncated &&
+				json.Unmarshal(capture.body.Bytes(), &batch) == nil && batch.ID != ""
+			job := batches.Job{
+				ID:        batch.ID,
+				Provider:  provider.Name(),
+				TenantID:  tenantID,
+				Model:     model,
+				Requests:  len(requests),
+				Estimate:  estimate,
+				Submitted: time.Now().UTC(),
+			}
+			async.Go(ctx, func() {
+				bgCtx := telemetry.Detach(ctx)
+				if !accepted {
+					if estimate > 0 {
+						if err := limiter.RefundEstimate(bgCtx, tenantID, reservationID, estimate); err != nil {
+							slog.Warn("Failed to refund batch estimate", "error", err, "tenant_id", tenantID)
+						}
+					}
+					return
+				}
+				// Reservations expire long before a batch ends, so the estimate
+				// is settled as spent now and trued up by the accountant.
+				if estimate > 0 {
+					if err := limiter.AdjustCost(bgCtx, tenantID, reservationID, estimate, estimate); err != nil {
+						slog.Warn("Failed to charge batch estimate", "error", err, "tenant_id", tenantID)
+					}
+				}
+				if err := accountant.Track(bgCtx, job); err != nil {
+					slog.Warn("Failed to track batch job, keeping its estimate",
+						"error", err,
+						"batch_id", job.ID,
+						"tenant_id", tenantID,
+					)
+					return
+				}
+				telemetry.IncBatchJob(bgCtx, job.Provider, tenantID, "submitted")
+				slog.Info("Batch job submitted",
+					"batch_id", job.ID,
+					"tenant_id", tenantID,
+					"requests", job.Requests,
+					"estimate", estimate,
+				)
+			})
+		})
+	}
+}
+
+// estimateBatch returns the estimated cost of requests at batch prices, and
+// the model they use (the first request's; a batch normally uses one).
+func estimateBatch(limiter ratelimit.Limiter, provider providers.Provider, models *ratelimit.ModelCatalog, requests []map[string]any) (float64, string) {
+	var total float64
+	var model string
+	for _, req := range requests {
+		m, _ := req["model"].(string)
+		if model == "" {
+			model = m
+		}
+		text := provider.ExtractFullText(req)
+		if text == "" {
+			continue
+		}
+		inputTokens := ratelimit.CountPromptTokens(text, m)
+		pricing, found := limiter.GetPricing(provider.Name(), m)
+		if !found {
+			pricing = ratelimit.DefaultPricing(provider.Name())
+		}
+		outputTokens := ratelimit.EstimateOutputTokens(inputTokens, ratelimit.ExtractMaxOutputTokens(req))
+		if info, ok := models.Lookup(provider.Name(), m); ok {
+			outputTokens = info.BoundOutput(inputTokens, outputTokens)
+		}
+		total += ratelimit.CalculateCost(inputTokens, outputTokens, ratelimit.BatchPricing(pricing))
+	}
+	return total, model
+}
+
+// rejectBatchOverLimit answers a batch whose estimate exceeds the tenant's
+// remaining budget.
+func rejectBatchOverLimit(ctx context.Context, w http.ResponseWriter, limiter ratelimit.Limiter, provider providers.Provider, tenantID, model string, requests int, estimate float64, result *ratelimit.CheckLimitResult) {
+	slog.WarnContext(ctx, "Rate limit exceeded by batch",
+		"tenant_id", tenantID,
+		"requests", requests,
+		"current_spend", result.CurrentSpend,
+		"limit", result.Limit,
+		"estimated_cost", estimate,
+	)
+	reason := "over_limit"
+	if result.Prepaid {
+		reason = "insufficient_credits"
+	}
+	telemetry.RecordRateLimitRequest(ctx, "denied", reason, provider.Name(), model, tenantID)
+	telemetry.IncBatchJob(ctx, provider.Name(), tenantID, "denied")
+	Decide(ctx, StageRateLimit, reason, fmt.Sprintf("batch=%d,est=%.4f,spend=%.4f,limit=%.2f", requests, estimate, result.CurrentSpend, result.Limit))
+	RecordUsage(ctx, limiter, ratelimit.UsageRecord{TenantID: tenantID, Provider: provider.Name(), Model: model, Outcome: ratelimit.UsageDenied, Estimate: estimate})
+	message := fmt.Sprintf("Rate limit exceeded. The batch's estimated cost of $%.4f exceeds the remaining hourly spend limit.", estimate)
+	code := "rate_limit_exceeded"
+	if result.Prepaid {
+		message = fmt.Sprintf("Insufficient credit balance for the batch's estimated cost of $%.4f.", estimate)
+		code = "insufficient_credits"
+	} else {
+		w.Header().Set("Retry-After", "3600")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "rate_limit_error",
+			"code":    code,
+		},
+		"estimated_cost": estimate,
+		"remaining":      result.Remaining,
+	})
+}
+
+// captureWriter records the status and the start of the body written
+// through it.
+type captureWriter struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	room := maxBatchResponseBytes - w.body.Len()
+	if len(p) > room {
+		w.truncated = true
+	}
+	if room > 0 {
+		w.body.Write(p[:min(len(p), room)])
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
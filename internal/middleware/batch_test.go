@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/batches"
+	"agent-sentinel/internal/providers/anthropic"
+	"agent-sentinel/internal/ratelimit"
+)
+
+func TestBatchAccounting(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+
+	provider, _ := anthropic.New("key")
+	body := `{"requests":[` +
+		`{"custom_id":"a","params":{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"Summarize the quarterly report"}]}},` +
+		`{"custom_id":"b","params":{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"Translate the release notes"}]}}]}`
+
+	cases := []struct {
+		name     string
+		limit    float64
+		status   int
+		response string
+		wantCode int
+		tracked  bool
+	}{
+		{name: "accepted", limit: 10, status: http.StatusOK, response: `{"id":"msgbatch_1","processing_status":"in_progress"}`, wantCode: http.StatusOK, tracked: true},
+		{name: "rejected upstream", limit: 10, status: http.StatusBadRequest, response: `{"type":"error","error":{"type":"invalid_request_error"}}`, wantCode: http.StatusBadRequest},
+		{name: "over limit", limit: 0.000001, wantCode: http.StatusTooManyRequests},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := ratelimit.NewMemoryLimiter()
+			limiter.SetLimit("t1", tc.limit)
+			store := batches.NewMemoryStore()
+			accountant := batches.New(batches.NewAPI(provider, nil), provider, store, limiter)
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				if r.Header.Get("Accept-Encoding") != "" {
+					t.Error("expected the batch to be requested uncompressed")
+				}
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.response))
+			})
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages/batches", strings.NewReader(body))
+			req.Header.Set("X-Tenant-ID", "t1")
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			BatchAccounting(accountant, limiter, provider, "X-Tenant-ID", nil)(next).ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if called == (tc.wantCode == http.StatusTooManyRequests) {
+				t.Fatalf("upstream called = %v", called)
+			}
+			jobs, _ := store.PendingBatches(context.Background())
+			spend := limiter.GetSpend("t1")
+			if !tc.tracked {
+				if len(jobs) != 0 || spend != 0 {
+					t.Fatalf("expected nothing tracked or charged, got %+v and spend %v", jobs, spend)
+				}
+				return
+			}
+			if len(jobs) != 1 {
+				t.Fatalf("expected the batch tracked, got %+v", jobs)
+			}
+			job := jobs[0]
+			if job.ID != "msgbatch_1" || job.TenantID != "t1" || job.Model != "claude-sonnet-4-5" || job.Requests != 2 {
+				t.Fatalf("unexpected job %+v", job)
+			}
+			if job.Estimate <= 0 || spend < job.Estimate-1e-9 || spend > job.Estimate+1e-9 {
+				t.Fatalf("spend = %v, want the estimate %v charged", spend, job.Estimate)
+			}
+		})
+	}
+}
+
+func TestEstimateBatchUsesBatchPricing(t *testing.T) {
+	provider, _ := anthropic.New("key")
+	limiter := ratelimit.NewMemoryLimiter()
+	req := map[string]any{"model": "claude-sonnet-4-5", "max_tokens": float64(100), "messages": []any{map[string]any{"role": "user", "content": "hello there"}}}
+
+	cost, model := estimateBatch(limiter, provider, nil, []map[string]any{req})
+	pricing, _ := limiter.GetPricing("anthropic", "claude-sonnet-4-5")
+	input := ratelimit.CountPromptTokens("hello there", "claude-sonnet-4-5")
+	full := ratelimit.CalculateCost(input, ratelimit.EstimateOutputTokens(input, 100), pricing)
+	if model != "claude-sonnet-4-5" || cost < full/2-1e-12 || cost > full/2+1e-12 {
+		t.Fatalf("estimate = %v (%s), want half of %v", cost, model, full)
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/upstream"
+)
+
+// BetaFeatures enforces the beta feature policy on the anthropic-beta and
+// OpenAI-Beta headers: features that are not allowed for the tenant are
+// removed, or the request is rejected with a 400, depending on the policy.
+func BetaFeatures(guard *upstream.BetaGuard, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if guard == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			denied, reject := guard.Filter(tenantID, r.Header)
+			if len(denied) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			action := upstream.BetaActionStrip
+			if reject {
+				action = upstream.BetaActionReject
+			}
+			slog.InfoContext(r.Context(), "Beta features not allowed",
+				"tenant_id", tenantID,
+				"provider", provider.Name(),
+				"features", denied,
+				"action", action,
+			)
+			telemetry.IncBetaDenied(r.Context(), tenantID, provider.Name(), action)
+			if !reject {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Beta features not allowed: " + strings.Join(denied, ", "),
+					"type":    "invalid_request_error",
+					"code":    "beta_feature_not_allowed",
+				},
+			})
+		})
+	}
+}
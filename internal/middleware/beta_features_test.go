@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/upstream"
+)
+
+func TestBetaFeaturesRejectsBlockedFeature(t *testing.T) {
+	guard := upstream.NewBetaGuard()
+	guard.Set(&upstream.BetaPolicy{Allow: []string{"*"}, Block: []string{"computer-use-*"}, Action: upstream.BetaActionReject})
+	called := false
+	handler := BetaFeatures(guard, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("anthropic-beta", "computer-use-2025-01-24")
+	handler.ServeHTTP(rr, req)
+	if called || rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a 400 without forwarding, got %d (forwarded %v)", rr.Code, called)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("anthropic-beta", "prompt-caching-2024-07-31")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !called {
+		t.Fatal("expected an allowed feature to be forwarded")
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"agent-sentinel/internal/jsonedit"
+)
+
+// defaultScanMinBytes is the body size from which rate limiting scans the
+// request for estimation instead of decoding it (ESTIMATE_SCAN_MIN_BYTES).
+const defaultScanMinBytes = 1 << 20
+
+// maxPooledBodyBytes keeps unusually large buffers out of the pool so one
+// huge request does not pin its memory for the life of the process.
+const maxPooledBodyBytes = 16 << 20
+
+var bodyBufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// scanMinBytesFromEnv reads ESTIMATE_SCAN_MIN_BYTES; 0 disables scanning.
+func scanMinBytesFromEnv() int {
+	if v := os.Getenv("ESTIMATE_SCAN_MIN_BYTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			return parsed
+		}
+	}
+	return defaultScanMinBytes
+}
+
+// pooledBody is a request body held in a pooled buffer. The buffer returns to
+// the pool when the body is closed, which the transport does once the request
+// has been sent.
+type pooledBody struct {
+	*bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		if b.buf.Cap() <= maxPooledBodyBytes {
+			b.buf.Reset()
+			bodyBufferPool.Put(b.buf)
+		}
+	})
+	return nil
+}
+
+// readBody returns r's body. The first middleware to read it copies it once
+// into a pooled buffer sized from Content-Length and replaces the body with a
+// reader over that buffer; later middlewares and the proxy reuse it without
+// copying. The bytes are only valid until the body is closed, so anything
+// that outlives the request (e.g. mirroring) must copy them.
+func readBody(r *http.Request) ([]byte, error) {
+	if pb, ok := r.Body.(*pooledBody); ok {
+		pb.Reset(pb.buf.Bytes())
+		return pb.buf.Bytes(), nil
+	}
+	buf := bodyBufferPool.Get().(*bytes.Buffer)
+	if r.ContentLength > 0 && r.ContentLength <= maxPooledBodyBytes {
+		buf.Grow(int(r.ContentLength))
+	}
+	if _, err := io.Copy(buf, r.Body); err != nil {
+		buf.Reset()
+		bodyBufferPool.Put(buf)
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+	return buf.Bytes(), nil
+}
+
+// setJSONBody replaces r's body with data, encoded into a pooled buffer; r is
+// left unchanged if data cannot be encoded. data is normally r's body decoded
+// and then changed: what did not change keeps its original text and key order
+// (see jsonedit.Rewrite).
+func setJSONBody(r *http.Request, data map[string]any) {
+	var orig []byte
+	if pb, ok := r.Body.(*pooledBody); ok {
+		orig = pb.buf.Bytes()
+	}
+	rewriteJSONBody(r, orig, data)
+}
+
+// rewriteJSONBody is setJSONBody with the original text given. Retries use it
+// with a copy taken before the first attempt: by then the transport has closed
+// the body and its buffer may already hold another request.
+func rewriteJSONBody(r *http.Request, orig []byte, data map[string]any) {
+	out, err := jsonedit.Rewrite(orig, data)
+	if err != nil {
+		return
+	}
+	buf := bodyBufferPool.Get().(*bytes.Buffer)
+	buf.Write(out)
+	r.Body = &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+	r.ContentLength = int64(buf.Len())
+	r.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+}
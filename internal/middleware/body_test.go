@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadBodyIsSharedAcrossMiddlewares(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"m"}`))
+	first, err := readBody(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, req.Body)
+	second, err := readBody(req)
+	if err != nil || &first[0] != &second[0] {
+		t.Fatalf("expected the second read to reuse the first buffer (%v)", err)
+	}
+	if forwarded, _ := io.ReadAll(req.Body); string(forwarded) != `{"model":"m"}` {
+		t.Fatalf("expected the body to be rewound for forwarding, got %q", forwarded)
+	}
+
+	setJSONBody(req, map[string]any{"model": "small"})
+	updated, _ := readBody(req)
+	if string(updated) != `{"model":"small"}` || req.ContentLength != int64(len(updated)) {
+		t.Fatalf("unexpected rewritten body %q (length %d)", updated, req.ContentLength)
+	}
+	if err := req.Body.Close(); err != nil {
+		t.Fatalf("unexpected close err: %v", err)
+	}
+}
+
+func TestSetJSONBodyKeepsOriginalText(t *testing.T) {
+	const body = `{"model":"m","seed":12345678901234567890,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	raw, _ := readBody(req)
+	var data map[string]any
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	data["max_tokens"] = 100
+
+	setJSONBody(req, data)
+	updated, _ := readBody(req)
+	want := `{"model":"m","seed":12345678901234567890,"messages":[{"role":"user","content":"hi"}],"max_tokens":100}`
+	if string(updated) != want {
+		t.Fatalf("expected key order and numbers kept, got %s", updated)
+	}
+}
+
+// benchmarkBodyLayers passes a 256KB body through three body-reading layers
+// and a forwarding handler, the shape of the proxy's middleware chain.
+func benchmarkBodyLayers(b *testing.B, read func(*http.Request) []byte) {
+	messages := []any{map[string]any{"role": "user", "content": strings.Repeat("lorem ipsum ", 256<<10/12)}}
+	payload, _ := json.Marshal(map[string]any{"model": "m", "messages": messages})
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	})
+	for range 3 {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = read(r)
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(payload))
+		handler.ServeHTTP(nil, req)
+	}
+}
+
+func BenchmarkRequestBody(b *testing.B) {
+	b.Run("readall", func(b *testing.B) {
+		benchmarkBodyLayers(b, func(r *http.Request) []byte {
+			body, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			return body
+		})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkBodyLayers(b, func(r *http.Request) []byte {
+			body, _ := readBody(r)
+			return body
+		})
+	})
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderDeadline carries the caller's latency budget in milliseconds.
+const HeaderDeadline = "X-Deadline-Ms"
+
+// ContextKeyDeadline holds the *Deadline of a request with a latency budget.
+const ContextKeyDeadline ContextKey = "deadline"
+
+// Deadline is a request's latency budget.
+type Deadline struct {
+	Budget time.Duration
+	sent   atomic.Bool
+}
+
+// Sent reports whether the request was written to the provider, which may then
+// bill its input even though the answer never arrived.
+func (d *Deadline) Sent() bool {
+	return d != nil && d.sent.Load()
+}
+
+// DeadlineFromContext returns the request's latency budget, if it has one.
+func DeadlineFromContext(ctx context.Context) (*Deadline, bool) {
+	d, ok := ctx.Value(ContextKeyDeadline).(*Deadline)
+	return d, ok
+}
+
+// LatencyBudget applies the X-Deadline-Ms header: the request context gets that
+// deadline, so the rate limit check, the sidecar call and the upstream request
+// all share it. A budget exhausted before the provider answers ends in a 504
+// from the proxy error handler. maxBudget, when positive, caps the budget.
+// Missing or invalid headers leave the request unbounded.
+func LatencyBudget(maxBudget time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ms, err := strconv.ParseInt(r.Header.Get(HeaderDeadline), 10, 64)
+			if err != nil || ms <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Header.Del(HeaderDeadline)
+			budget := time.Duration(ms) * time.Millisecond
+			if maxBudget > 0 && budget > maxBudget {
+				budget = maxBudget
+			}
+
+			d := &Deadline{Budget: budget}
+			ctx, cancel := context.WithTimeout(r.Context(), budget)
+			defer cancel()
+			ctx = context.WithValue(ctx, ContextKeyDeadline, d)
+			ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				WroteRequest: func(info httptrace.WroteRequestInfo) {
+					if info.Err == nil {
+						d.sent.Store(true)
+					}
+				},
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WriteDeadlineExceeded writes the 504 for a request whose budget ran out.
+func WriteDeadlineExceeded(w http.ResponseWriter, d *Deadline) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": fmt.Sprintf("Deadline of %d ms exceeded", d.Budget.Milliseconds()),
+			"type":    "timeout_error",
+			"code":    "deadline_exceeded",
+		},
+	})
+}
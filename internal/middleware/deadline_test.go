@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyBudgetSetsDeadline(t *testing.T) {
+	var remaining time.Duration
+	var hasDeadline, forwarded bool
+	handler := LatencyBudget(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		deadline, hasDeadline = r.Context().Deadline()
+		remaining = time.Until(deadline)
+		forwarded = r.Header.Get(HeaderDeadline) != ""
+	}))
+	serve := func(value string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set(HeaderDeadline, value)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("250")
+	if !hasDeadline || remaining > 250*time.Millisecond || remaining < 200*time.Millisecond || forwarded {
+		t.Fatalf("expected a 250ms deadline and the header removed, got %v (forwarded %v)", remaining, forwarded)
+	}
+	serve("60000")
+	if remaining > time.Second {
+		t.Fatalf("expected the budget capped at 1s, got %v", remaining)
+	}
+	serve("soon")
+	if hasDeadline {
+		t.Fatal("expected an invalid header to be ignored")
+	}
+}
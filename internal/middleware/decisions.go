@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderDecisions summarises, on the response, what each middleware did with
+// the request: e.g. "rate_limit=allowed(est=0.0042,remaining=9.99) 1.2ms".
+const HeaderDecisions = "X-Sentinel-Decisions"
+
+// HeaderTrace asks for the decision trace of one request when DECISION_TRACE
+// is "request". It is not forwarded to the provider.
+const HeaderTrace = "X-Sentinel-Trace"
+
+// Decision trace modes.
+const (
+	// TraceAlways traces every request.
+	TraceAlways = "always"
+	// TraceOnRequest traces requests that send X-Sentinel-Trace: true.
+	TraceOnRequest = "request"
+)
+
+// Stages named in the decision trace by the middlewares that annotate it.
+const (
+	StageRateLimit        = "rate_limit"
+	StageLoopDetection    = "loop_detection"
+	StageOutputTokens     = "output_tokens"
+	StageToolPolicy       = "tool_policy"
+	StageExperiments      = "experiments"
+	StageModelCache       = "model_cache"
+	StageModelDeprecation = "model_deprecation"
+)
+
+// ContextKeyTrace holds the *Trace of a traced request.
+const ContextKeyTrace ContextKey = "decision_trace"
+
+// Trace collects the decisions of one request, in the order the stages ran.
+// Safe for concurrent use.
+type Trace struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	decisions []decision
+}
+
+type decision struct {
+	stage   string
+	outcome string
+	detail  string
+	start   time.Time
+	took    time.Duration
+	passed  bool
+}
+
+// TraceFromContext returns the request's trace, if it is traced.
+func TraceFromContext(ctx context.Context) (*Trace, bool) {
+	t, ok := ctx.Value(ContextKeyTrace).(*Trace)
+	return t, ok
+}
+
+// enter records that stage started handling the request and returns its index.
+func (t *Trace) enter(stage string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decisions = append(t.decisions, decision{stage: stage, start: t.now()})
+	return len(t.decisions) - 1
+}
+
+// pass records that the stage at i handed the request on.
+func (t *Trace) pass(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := &t.decisions[i]
+	d.passed, d.took = true, t.now().Sub(d.start)
+}
+
+// decide sets the outcome of the latest run of stage.
+func (t *Trace) decide(stage, outcome, detail string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.decisions) - 1; i >= 0; i-- {
+		if t.decisions[i].stage == stage {
+			t.decisions[i].outcome, t.decisions[i].detail = outcome, detail
+			return
+		}
+	}
+}
+
+// String renders the trace for HeaderDecisions. A stage that did not hand the
+// request on is shown with the response status, timed up to now.
+func (t *Trace) String(status int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	parts := make([]string, 0, len(t.decisions))
+	for _, d := range t.decisions {
+		outcome, took := d.outcome, d.took
+		if !d.passed {
+			took = now.Sub(d.start)
+			if outcome == "" {
+				outcome = strconv.Itoa(status)
+			}
+		} else if outcome == "" {
+			outcome = "pass"
+		}
+		if d.detail != "" {
+			outcome += "(" + d.detail + ")"
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s %.1fms", d.stage, outcome, float64(took.Microseconds())/1000))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Decide annotates stage in the request's trace with its outcome (e.g.
+// "denied") and an optional short detail. A no-op for untraced requests.
+func Decide(ctx context.Context, stage, outcome, detail string) {
+	if t, ok := TraceFromContext(ctx); ok {
+		t.decide(stage, outcome, detail)
+	}
+}
+
+// Decisions traces requests per mode (TraceAlways or TraceOnRequest; anything
+// else disables tracing) and writes the trace to HeaderDecisions when the
+// response starts. Timings of the upstream stage are therefore time to first
+// byte. It should run outside every Traced stage.
+func Decisions(mode string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if mode != TraceAlways && mode != TraceOnRequest {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested, _ := strconv.ParseBool(r.Header.Get(HeaderTrace))
+			r.Header.Del(HeaderTrace)
+			if mode == TraceOnRequest && !requested {
+				next.ServeHTTP(w, r)
+				return
+			}
+			t := &Trace{now: time.Now}
+			dw := &decisionWriter{ResponseWriter: w, trace: t, ctx: r.Context()}
+			next.ServeHTTP(dw, r.WithContext(context.WithValue(r.Context(), ContextKeyTrace, t)))
+		})
+	}
+}
+
+// Traced runs mw as the named stage of traced requests, recording when it
+// hands the request on and how long that took. Untraced requests skip the
+// bookkeeping.
+func Traced(stage string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := TraceFromContext(r.Context()); ok {
+				if i, ok := r.Context().Value(traceStageKey(stage)).(int); ok {
+					t.pass(i)
+				}
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return TraceStage(stage, inner)
+	}
+}
+
+// TraceStage runs h as the named stage of traced requests. Use it for the
+// final handler, which never hands the request on.
+func TraceStage(stage string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, ok := TraceFromContext(r.Context())
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		i := t.enter(stage)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceStageKey(stage), i)))
+	})
+}
+
+// traceStageKey holds the index of a stage's latest entry in the trace, so a
+// stage run twice (e.g. by a retry) passes the right one.
+type traceStageKey string
+
+// decisionWriter adds HeaderDecisions when the response starts.
+type decisionWriter struct {
+	http.ResponseWriter
+	trace   *Trace
+	ctx     context.Context
+	written bool
+}
+
+func (w *decisionWriter) WriteHeader(status int) {
+	w.emit(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *decisionWriter) Write(b []byte) (int, error) {
+	w.emit(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush starts the response, if needed, before flushing it.
+func (w *decisionWriter) Flush() {
+	w.emit(http.StatusOK)
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *decisionWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *decisionWriter) emit(status int) {
+	if w.written || status < http.StatusOK {
+		return
+	}
+	w.written = true
+	value := w.trace.String(status)
+	w.Header().Set(HeaderDecisions, value)
+	slog.DebugContext(w.ctx, "Request decisions", "status", status, "decisions", value)
+}
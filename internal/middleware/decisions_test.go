@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestDecisionsHeader(t *testing.T) {
+	var forwarded bool
+	upstream := TraceStage("upstream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(HeaderTrace) != ""
+		_, _ = w.Write([]byte("ok"))
+	}))
+	annotate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Decide(r.Context(), StageRateLimit, "allowed", "est=0.0100")
+			next.ServeHTTP(w, r)
+		})
+	}
+	passThrough := func(next http.Handler) http.Handler { return next }
+	chain := func(mode string) http.Handler {
+		var h http.Handler = upstream
+		h = Traced(StageRateLimit, annotate)(h)
+		h = Traced("affinity", passThrough)(h)
+		return Decisions(mode)(h)
+	}
+	serve := func(mode string, requested bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if requested {
+			req.Header.Set(HeaderTrace, "true")
+		}
+		rec := httptest.NewRecorder()
+		chain(mode).ServeHTTP(rec, req)
+		return rec
+	}
+
+	got := serve(TraceAlways, false).Header().Get(HeaderDecisions)
+	want := regexp.MustCompile(`^affinity=pass [0-9.]+ms, rate_limit=allowed\(est=0\.0100\) [0-9.]+ms, upstream=200 [0-9.]+ms$`)
+	if !want.MatchString(got) {
+		t.Fatalf("unexpected decisions %q", got)
+	}
+
+	if got := serve(TraceOnRequest, false).Header().Get(HeaderDecisions); got != "" {
+		t.Fatalf("expected no trace unless requested, got %q", got)
+	}
+	if got := serve(TraceOnRequest, true).Header().Get(HeaderDecisions); got == "" || forwarded {
+		t.Fatalf("expected a requested trace and the header removed, got %q (forwarded %v)", got, forwarded)
+	}
+	if got := serve("", true).Header().Get(HeaderDecisions); got != "" {
+		t.Fatalf("expected tracing disabled by default, got %q", got)
+	}
+}
+
+func TestDecisionsStoppedStage(t *testing.T) {
+	upstream := TraceStage("upstream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request should not reach upstream")
+	}))
+	reject := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+	}
+	h := Decisions(TraceAlways)(Traced("smoothing", reject)(upstream))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if got := rec.Header().Get(HeaderDecisions); !regexp.MustCompile(`^smoothing=429 [0-9.]+ms$`).MatchString(got) {
+		t.Fatalf("expected the rejecting stage with its status, got %q", got)
+	}
+}
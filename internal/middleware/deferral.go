@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"agent-sentinel/internal/deferred"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/shed"
+	"agent-sentinel/internal/telemetry"
+)
+
+// DeferredPath is where tenants poll deferred requests:
+// GET /sentinel/deferred/{id}.
+const DeferredPath = "/sentinel/deferred/"
+
+// maxDeferredBodyBytes bounds the request bodies kept in the deferred queue;
+// larger low-priority requests are denied as usual.
+const maxDeferredBodyBytes = 4 << 20
+
+// Deferral queues low-priority requests (X-Sentinel-Priority: low) instead of
+// failing them when the tenant has spent its soft limit or the spend limit
+// denies them. The client gets a 202 with a poll URL under DeferredPath, which
+// this middleware also serves; the queue replays the request through next
+// once the tenant's budget has room. It runs after request signing, so polls
+// and deferred requests are authenticated like any other.
+func Deferral(queue *deferred.Queue, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if queue == nil {
+			return next
+		}
+		queue.SetHandler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if id, ok := strings.CutPrefix(r.URL.Path, DeferredPath); ok && r.Method == http.MethodGet {
+				serveDeferred(w, r, queue, tenantID, id)
+				return
+			}
+			if r.Method != http.MethodPost || tenantID == "" || r.Header.Get(HeaderPriority) != shed.PriorityLow ||
+				!queue.Policy().Enabled || r.ContentLength > maxDeferredBodyBytes {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			body, err := readBody(r)
+			if err != nil || len(body) > maxDeferredBodyBytes {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			over, err := queue.OverSoftLimit(ctx, tenantID)
+			if err != nil {
+				slog.WarnContext(ctx, "Soft limit check failed, not deferring", "error", err, "tenant_id", tenantID)
+			}
+			if over && deferRequest(ctx, w, r, queue, tenantID, deferred.ReasonSoftLimit, body) {
+				return
+			}
+
+			hw := &holdWriter{ResponseWriter: w}
+			next.ServeHTTP(hw, r)
+			if !hw.held {
+				return
+			}
+			if deferred.ErrorCode(hw.body.Bytes()) == "rate_limit_exceeded" {
+				retryAfter := w.Header().Get("Retry-After")
+				w.Header().Del("Retry-After")
+				if deferRequest(ctx, w, r, queue, tenantID, deferred.ReasonOverLimit, body) {
+					return
+				}
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+			}
+			w.WriteHeader(hw.status)
+			_, _ = w.Write(hw.body.Bytes())
+		})
+	}
+}
+
+// deferRequest queues r and answers it with a 202, or returns false when the
+// tenant's queue is full or unavailable.
+func deferRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, queue *deferred.Queue, tenantID, reason string, body []byte) bool {
+	req, ok, err := queue.Defer(ctx, tenantID, reason, r, body)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to defer request", "error", err, "tenant_id", tenantID)
+		return false
+	}
+	if !ok {
+		telemetry.IncDeferred(ctx, tenantID, "queue_full", reason)
+		return false
+	}
+	telemetry.IncDeferred(ctx, tenantID, "queued", reason)
+	events.Record(events.TypeRequestDeferred, tenantID, map[string]any{
+		"id":     req.ID,
+		"status": req.Status,
+		"reason": reason,
+		"path":   r.URL.Path,
+	})
+	slog.InfoContext(ctx, "Deferred low-priority request", "deferred_id", req.ID, "tenant_id", tenantID, "reason", reason)
+
+	pollURL := DeferredPath + req.ID
+	w.Header().Set("Location", pollURL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":         req.ID,
+		"object":     "deferred_request",
+		"status":     req.Status,
+		"reason":     reason,
+		"poll_url":   pollURL,
+		"expires_at": req.Expires,
+	})
+	return true
+}
+
+// serveDeferred answers a poll for a deferred request. Other tenants'
+// requests are reported as not found.
+func serveDeferred(w http.ResponseWriter, r *http.Request, queue *deferred.Queue, tenantID, id string) {
+	req, ok, err := queue.Get(r.Context(), id)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to read deferred request", "error", err, "deferred_id", id)
+		writeDeferredError(w, http.StatusServiceUnavailable, "Deferred request status is unavailable. Try again later.", "deferred_unavailable")
+		return
+	}
+	if !ok || req.TenantID != tenantID {
+		writeDeferredError(w, http.StatusNotFound, "No deferred request "+id+"; it may have expired.", "deferred_not_found")
+		return
+	}
+	if req.Status == deferred.StatusQueued {
+		// Budgets free up as the spend window slides.
+		w.Header().Set("Retry-After", "60")
+	}
+	writeDeferredJSON(w, http.StatusOK, deferred.Status(req))
+}
+
+func writeDeferredError(w http.ResponseWriter, status int, message, code string) {
+	writeDeferredJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "invalid_request_error",
+			"code":    code,
+		},
+	})
+}
+
+func writeDeferredJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// holdWriter holds back a 429 so it can be replaced by a deferral; any other
+// response passes straight through.
+type holdWriter struct {
+	http.ResponseWriter
+	started bool
+	held    bool
+	status  int
+	body    bytes.Buffer
+}
+
+func (w *holdWriter) WriteHeader(status int) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if status == http.StatusTooManyRequests {
+		w.held, w.status = true, status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *holdWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.held {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes responses that are not held.
+func (w *holdWriter) Flush() {
+	if w.held {
+		return
+	}
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *holdWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/deferred"
+)
+
+type fakeDeferBudget struct{ spend, limit float64 }
+
+func (b fakeDeferBudget) GetSpend(ctx context.Context, tenantID string) (float64, error) {
+	return b.spend, nil
+}
+
+func (b fakeDeferBudget) GetLimit(ctx context.Context, tenantID string) (float64, error) {
+	return b.limit, nil
+}
+
+func TestDeferral(t *testing.T) {
+	cases := []struct {
+		name       string
+		spend      float64
+		priority   string
+		upstream   int
+		code       string
+		wantStatus int
+		wantReason string
+	}{
+		{name: "over soft limit", spend: 90, priority: "low", upstream: http.StatusOK, wantStatus: http.StatusAccepted, wantReason: "soft_limit"},
+		{name: "denied by limit", spend: 10, priority: "low", upstream: http.StatusTooManyRequests, code: "rate_limit_exceeded", wantStatus: http.StatusAccepted, wantReason: "over_limit"},
+		{name: "provider 429 passes", spend: 10, priority: "low", upstream: http.StatusTooManyRequests, code: "rate_limit_error", wantStatus: http.StatusTooManyRequests},
+		{name: "normal priority denied", spend: 90, upstream: http.StatusTooManyRequests, code: "rate_limit_exceeded", wantStatus: http.StatusTooManyRequests},
+		{name: "under soft limit", spend: 10, priority: "low", upstream: http.StatusOK, wantStatus: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			queue := deferred.New(deferred.NewMemoryStore(), fakeDeferBudget{spend: tc.spend, limit: 100})
+			queue.Set(&deferred.Policy{Enabled: true, SoftLimitPercent: 80})
+			h := Deferral(queue, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.upstream != http.StatusOK {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(tc.upstream)
+					_, _ = w.Write([]byte(`{"error":{"code":"` + tc.code + `"}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+			req.Header.Set("X-Tenant-ID", "t1")
+			if tc.priority != "" {
+				req.Header.Set(HeaderPriority, tc.priority)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if tc.wantStatus != http.StatusAccepted {
+				return
+			}
+			var accepted struct {
+				ID, Status, Reason string
+				PollURL            string `json:"poll_url"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &accepted)
+			if accepted.Status != "queued" || accepted.Reason != tc.wantReason || accepted.PollURL != DeferredPath+accepted.ID || rec.Header().Get("Retry-After") != "" {
+				t.Fatalf("got %+v (Retry-After %q)", accepted, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestDeferralPoll(t *testing.T) {
+	queue := deferred.New(deferred.NewMemoryStore(), fakeDeferBudget{spend: 90, limit: 100})
+	queue.Set(&deferred.Policy{Enabled: true, SoftLimitPercent: 80})
+	h := Deferral(queue, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	req.Header.Set("X-Tenant-ID", "t1")
+	req.Header.Set(HeaderPriority, "low")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	pollURL := rec.Header().Get("Location")
+
+	poll := func(tenantID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, pollURL, nil)
+		req.Header.Set("X-Tenant-ID", tenantID)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := poll("t1"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"queued"`) {
+		t.Fatalf("poll = %d %s", rec.Code, rec.Body)
+	}
+	if rec := poll("t2"); rec.Code != http.StatusNotFound {
+		t.Fatalf("poll by another tenant = %d, want 404", rec.Code)
+	}
+
+	queue.Set(&deferred.Policy{Enabled: true})
+	if n, _ := queue.Replay(context.Background()); n != 1 {
+		t.Fatalf("replayed %d, want 1", n)
+	}
+	rec = poll("t1")
+	if !strings.Contains(rec.Body.String(), `"status":"completed"`) || !strings.Contains(rec.Body.String(), `"body":{"id":"chatcmpl-1"}`) {
+		t.Fatalf("poll = %s, want the replayed response", rec.Body)
+	}
+}
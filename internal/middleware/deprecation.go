@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderModelDeprecated warns that the requested model has an announced
+// retirement date: "<model>; sunset=<YYYY-MM-DD>", followed by
+// "; replaced_by=<model>" when the request was rewritten to its successor.
+const HeaderModelDeprecated = "X-Sentinel-Model-Deprecated"
+
+// ModelDeprecation warns about requests for models with a deprecation date in
+// the model metadata table. Once the date has passed, requests for a model
+// with a configured successor are rewritten to it. It runs before validation
+// and rate limiting, so both see the model actually sent.
+func ModelDeprecation(models *ratelimit.ModelCatalog, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if models == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			pathModel := provider.ExtractModelFromPath(r.URL.Path)
+			model := pathModel
+			var data map[string]any
+			if model == "" {
+				body, err := readBody(r)
+				if err != nil || json.Unmarshal(body, &data) != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				model, _ = data["model"].(string)
+			}
+			info, ok := models.Lookup(provider.Name(), model)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sunset, ok := info.Sunset()
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tenantID := r.Header.Get(headerName)
+			warning := model + "; sunset=" + info.Deprecated
+			action := "warned"
+			if info.Successor != "" && !time.Now().Before(sunset) {
+				rewriteModel(r, data, pathModel, info.Successor)
+				warning += "; replaced_by=" + info.Successor
+				action = "rewritten"
+				slog.InfoContext(r.Context(), "Rewrote retired model to its successor",
+					"tenant_id", tenantID,
+					"model", model,
+					"successor", info.Successor,
+					"sunset", info.Deprecated,
+				)
+			} else {
+				// RFC 8594, for clients and gateways that already act on it.
+				w.Header().Set("Sunset", sunset.Format(http.TimeFormat))
+			}
+			w.Header().Set(HeaderModelDeprecated, warning)
+			Decide(r.Context(), StageModelDeprecation, action, model)
+			telemetry.IncDeprecatedModel(r.Context(), provider.Name(), model, tenantID, action)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+func TestModelDeprecation(t *testing.T) {
+	models := ratelimit.NewModelCatalog()
+	models.Set(ratelimit.ProviderModels{"fake": {
+		"old":     {Deprecated: "2999-01-01"},
+		"retired": {Deprecated: "2000-01-01", Successor: "new"},
+		"gone":    {Deprecated: "2000-01-01"},
+	}})
+	var gotModel string
+	h := ModelDeprecation(models, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&data)
+		gotModel, _ = data["model"].(string)
+	}))
+	send := func(model string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := `{"model":"` + model + `","messages":[{"role":"user","content":"hi"}]}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := send("old")
+	if got := rec.Header().Get(HeaderModelDeprecated); got != "old; sunset=2999-01-01" || gotModel != "old" {
+		t.Fatalf("expected a warning without a rewrite, got %q (model %q)", got, gotModel)
+	}
+	if got := rec.Header().Get("Sunset"); got != "Tue, 01 Jan 2999 00:00:00 GMT" {
+		t.Fatalf("unexpected Sunset header %q", got)
+	}
+
+	rec = send("retired")
+	if got := rec.Header().Get(HeaderModelDeprecated); got != "retired; sunset=2000-01-01; replaced_by=new" || gotModel != "new" {
+		t.Fatalf("expected the successor after the sunset, got %q (model %q)", got, gotModel)
+	}
+
+	rec = send("gone")
+	if got := rec.Header().Get(HeaderModelDeprecated); got == "" || gotModel != "gone" {
+		t.Fatalf("expected a retired model without a successor forwarded as is, got %q (model %q)", got, gotModel)
+	}
+
+	if rec := send("current"); rec.Header().Get(HeaderModelDeprecated) != "" || gotModel != "current" {
+		t.Fatal("expected other models left alone")
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"agent-sentinel/internal/experiments"
+	"agent-sentinel/internal/providers"
+)
+
+// ContextKeyExperiment carries the *experiments.Assignment for the request.
+const ContextKeyExperiment ContextKey = "experiment_assignment"
+
+// HeaderExperiment reports the experiment and variant a request was assigned to.
+const HeaderExperiment = "X-Sentinel-Experiment"
+
+// Experiments assigns tenants to experiment variants and rewrites the requested
+// model accordingly. It runs before rate limiting so the estimate uses the
+// variant's pricing.
+func Experiments(registry *experiments.Registry, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if registry == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if r.Method != http.MethodPost || tenantID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var data map[string]any
+			_ = json.Unmarshal(body, &data)
+			pathModel := provider.ExtractModelFromPath(r.URL.Path)
+			model := pathModel
+			if model == "" {
+				model, _ = data["model"].(string)
+			}
+			assignment, ok := registry.Assign(tenantID, model)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if assignment.Model != model {
+				rewriteModel(r, data, pathModel, assignment.Model)
+			}
+
+			ctx := context.WithValue(r.Context(), ContextKeyExperiment, assignment)
+			trace.SpanFromContext(ctx).SetAttributes(
+				attribute.String("experiment.name", assignment.Experiment),
+				attribute.String("experiment.variant", assignment.Variant),
+			)
+			w.Header().Set(HeaderExperiment, assignment.Experiment+"/"+assignment.Variant)
+			Decide(ctx, StageExperiments, "assigned", assignment.Experiment+"/"+assignment.Variant)
+			slog.DebugContext(r.Context(), "Experiment variant assigned",
+				"tenant_id", tenantID,
+				"experiment", assignment.Experiment,
+				"variant", assignment.Variant,
+				"model", assignment.Model,
+			)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// rewriteModel points r at model: in the path when the provider keeps the model
+// there (Gemini), otherwise in the body's "model" field.
+func rewriteModel(r *http.Request, data map[string]any, pathModel, model string) {
+	if pathModel != "" {
+		r.URL.Path = strings.Replace(r.URL.Path, "/models/"+pathModel, "/models/"+model, 1)
+		r.URL.RawPath = ""
+		return
+	}
+	if data == nil {
+		return
+	}
+	data["model"] = model
+	setJSONBody(r, data)
+}
+
+// withExperiment adds the request's experiment assignment, if any, to an event's detail.
+func withExperiment(ctx context.Context, detail map[string]any) map[string]any {
+	if a, ok := ctx.Value(ContextKeyExperiment).(*experiments.Assignment); ok {
+		detail["experiment"] = a.Experiment
+		detail["variant"] = a.Variant
+	}
+	return detail
+}
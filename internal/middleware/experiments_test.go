@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/experiments"
+)
+
+func TestExperimentsRewritesModel(t *testing.T) {
+	registry := experiments.NewRegistry(nil)
+	registry.Set([]experiments.Experiment{{
+		Name:       "mini",
+		MatchModel: "gpt-4o",
+		Variants: []experiments.Variant{
+			{Name: "control", Model: "gpt-4o", Weight: 0},
+			{Name: "mini", Model: "gpt-4o-mini", Weight: 1},
+		},
+	}})
+
+	var gotModel string
+	var gotAssignment *experiments.Assignment
+	h := Experiments(registry, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var data map[string]any
+		_ = json.Unmarshal(body, &data)
+		gotModel, _ = data["model"].(string)
+		gotAssignment, _ = r.Context().Value(ContextKeyExperiment).(*experiments.Assignment)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader([]byte(`{"model":"gpt-4o"}`)))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if gotModel != "gpt-4o-mini" {
+		t.Fatalf("expected model rewritten to gpt-4o-mini, got %q", gotModel)
+	}
+	if gotAssignment == nil || gotAssignment.Variant != "mini" {
+		t.Fatalf("expected assignment in context, got %+v", gotAssignment)
+	}
+	if got := rec.Header().Get(HeaderExperiment); got != "mini/mini" {
+		t.Fatalf("expected experiment header, got %q", got)
+	}
+
+	// Requests for other models pass through untouched.
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader([]byte(`{"model":"gpt-4.1"}`)))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if gotModel != "gpt-4.1" || rec.Header().Get(HeaderExperiment) != "" {
+		t.Fatalf("expected pass-through, got model %q header %q", gotModel, rec.Header().Get(HeaderExperiment))
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"agent-sentinel/internal/fairness"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Fairness admits requests through the fair queue while the provider key is
+// contended. It runs before rate limiting, so a queued request holds no spend
+// reservation and one that gives up has nothing to refund.
+func Fairness(scheduler *fairness.Scheduler, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if scheduler == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenantID := r.Header.Get(headerName)
+			release, tier, wait, queued, err := scheduler.Acquire(r.Context(), tenantID)
+			if err == nil {
+				if queued {
+					telemetry.ObserveFairQueueWait(r.Context(), tenantID, tier, "admitted", wait)
+				}
+				defer release()
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			deadline, hasDeadline := DeadlineFromContext(r.Context())
+			switch {
+			case errors.Is(err, fairness.ErrQueueTimeout):
+				telemetry.ObserveFairQueueWait(r.Context(), tenantID, tier, "timeout", wait)
+				slog.WarnContext(r.Context(), "Fair queue wait exceeded",
+					"tenant_id", tenantID,
+					"tier", tier,
+					"wait_ms", wait.Milliseconds(),
+				)
+				w.Header().Set("Retry-After", "1")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"error": map[string]any{
+						"message": "Provider capacity is contended; no slot became available in time.",
+						"type":    "rate_limit_error",
+						"code":    "fair_queue_timeout",
+					},
+				})
+			case hasDeadline && errors.Is(err, context.DeadlineExceeded):
+				telemetry.ObserveFairQueueWait(r.Context(), tenantID, tier, "timeout", wait)
+				telemetry.IncDeadlineExceeded(r.Context(), tenantID, "proxy")
+				WriteDeadlineExceeded(w, deadline)
+			default:
+				// The client went away.
+				telemetry.ObserveFairQueueWait(r.Context(), tenantID, tier, "canceled", wait)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/fairness"
+)
+
+func TestFairnessRejectsAfterQueueTimeout(t *testing.T) {
+	scheduler := fairness.NewScheduler(func() (int, int) { return 0, -1 })
+	scheduler.Set(&fairness.Policy{Concurrency: 1, MaxWaitMs: 10})
+	hold, _, _, _, err := scheduler.Acquire(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	calls := 0
+	h := Fairness(scheduler, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("X-Tenant-ID", "acme")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve()
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "fair_queue_timeout") || calls != 0 {
+		t.Fatalf("expected a fair queue timeout, got %d %q after %d calls", rec.Code, rec.Body.String(), calls)
+	}
+
+	hold()
+	if rec := serve(); rec.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the request through once a slot is free, got %d after %d calls", rec.Code, calls)
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// FineTuneAccounting holds fine-tuning job creation to the tenant's monthly
+// fine-tuning allowance, kept apart from the hourly limits. Tenants without an
+// allowance get a 403. The job's training cost is estimated from its training
+// file and charged to the month; a job that would exceed the allowance gets a
+// 429. Once the provider accepts the job it is tracked so the accountant can
+// settle its actual cost when it ends. A job whose training file cannot be
+// read is let through and charged in full when it is settled.
+func FineTuneAccounting(accountant *finetune.Accountant, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if accountant == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if r.Method != http.MethodPost || tenantID == "" || !accountant.IsCreate(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			allowance := accountant.Allowance(tenantID)
+			if allowance <= 0 {
+				rejectFineTune(ctx, w, provider, tenantID, http.StatusForbidden, "not_allowed",
+					"permission_error", "fine_tune_not_allowed", "Fine-tuning is not enabled for this tenant.")
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			est, err := accountant.Estimate(ctx, data)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to estimate fine-tuning job, charging it when it settles",
+					"error", err,
+					"tenant_id", tenantID,
+				)
+				est.Cost = 0
+			}
+			month := accountant.Month()
+			if est.Cost > 0 {
+				allowed, spent, err := accountant.Reserve(ctx, tenantID, month, est.Cost)
+				switch {
+				case err != nil:
+					slog.WarnContext(ctx, "Fine-tuning budget check failed, failing open",
+						"error", err,
+						"tenant_id", tenantID,
+					)
+					est.Cost = 0
+				case !allowed:
+					slog.WarnContext(ctx, "Fine-tuning budget exceeded",
+						"tenant_id", tenantID,
+						"model", est.Model,
+						"tokens", est.Tokens,
+						"epochs", est.Epochs,
+						"estimated_cost", est.Cost,
+						"spent", spent,
+						"allowance", allowance,
+					)
+					rejectFineTune(ctx, w, provider, tenantID, http.StatusTooManyRequests, "over_budget",
+						"rate_limit_error", "fine_tune_budget_exceeded",
+						fmt.Sprintf("The fine-tuning job's estimated cost of $%.2f exceeds the remaining monthly fine-tuning budget ($%.2f of $%.2f spent).", est.Cost, spent, allowance))
+					return
+				}
+			}
+
+			// The job object is read from the response, so ask for it
+			// uncompressed; the transport still negotiates compression upstream.
+			r.Header.Del("Accept-Encoding")
+			capture := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(capture, r)
+
+			var created struct {
+				ID string `json:"id"`
+			}
+			accepted := capture.status < http.StatusBadRequest && !capture.truncated &&
+				json.Unmarshal(capture.body.Bytes(), &created) == nil && created.ID != ""
+			job := finetune.Job{
+				ID:        created.ID,
+				Provider:  provider.Name(),
+				TenantID:  tenantID,
+				Model:     est.Model,
+				Month:     month,
+				Estimate:  est.Cost,
+				Submitted: time.Now().UTC(),
+			}
+			async.Go(ctx, func() {
+				bgCtx := telemetry.Detach(ctx)
+				if !accepted {
+					if est.Cost > 0 {
+						if err := accountant.Refund(bgCtx, tenantID, month, est.Cost); err != nil {
+							slog.Warn("Failed to refund fine-tuning estimate", "error", err, "tenant_id", tenantID)
+						}
+					}
+					return
+				}
+				if err := accountant.Track(bgCtx, job); err != nil {
+					slog.Warn("Failed to track fine-tuning job, keeping its estimate",
+						"error", err,
+						"job_id", job.ID,
+						"tenant_id", tenantID,
+					)
+					return
+				}
+				telemetry.IncFineTuneJob(bgCtx, job.Provider, tenantID, "submitted")
+				slog.Info("Fine-tuning job created",
+					"job_id", job.ID,
+					"tenant_id", tenantID,
+					"model", est.Model,
+					"tokens", est.Tokens,
+					"epochs", est.Epochs,
+					"estimate", est.Cost,
+				)
+			})
+		})
+	}
+}
+
+// rejectFineTune answers a fine-tuning job the tenant's allowance does not
+// cover.
+func rejectFineTune(ctx context.Context, w http.ResponseWriter, provider providers.Provider, tenantID string, status int, outcome, errType, code, message string) {
+	telemetry.IncFineTuneJob(ctx, provider.Name(), tenantID, outcome)
+	Decide(ctx, StageRateLimit, "fine_tune_"+outcome, code)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/providers/openai"
+)
+
+type openaiAt struct {
+	*openai.Provider
+	base *url.URL
+}
+
+func (p openaiAt) BaseURL() *url.URL { return p.base }
+
+func TestFineTuneAccounting(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	t.Setenv("FINE_TUNE_BUDGETS", "t1=10")
+
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/files/file-train/content" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(strings.Repeat(`{"messages":[{"role":"user","content":"Classify this support ticket"},{"role":"assistant","content":"billing"}]}`+"\n", 100)))
+	}))
+	defer files.Close()
+	base, _ := url.Parse(files.URL)
+	p, _ := openai.New("key")
+	provider := openaiAt{Provider: p, base: base}
+	body := `{"model":"gpt-4o-mini-2024-07-18","training_file":"file-train"}`
+
+	cases := []struct {
+		name     string
+		tenant   string
+		spent    float64
+		status   int
+		response string
+		wantCode int
+		tracked  bool
+	}{
+		{name: "created", tenant: "t1", status: http.StatusOK, response: `{"id":"ftjob-1","status":"validating_files"}`, wantCode: http.StatusOK, tracked: true},
+		{name: "rejected upstream", tenant: "t1", status: http.StatusBadRequest, response: `{"error":{"message":"invalid file"}}`, wantCode: http.StatusBadRequest},
+		{name: "no allowance", tenant: "t2", wantCode: http.StatusForbidden},
+		{name: "over budget", tenant: "t1", spent: 9.9999, wantCode: http.StatusTooManyRequests},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := finetune.NewMemoryStore()
+			accountant := finetune.New(finetune.NewAPI(provider, nil), provider, store)
+			ctx := context.Background()
+			month := accountant.Month()
+			_ = store.AdjustFineTune(ctx, tc.tenant, month, tc.spent)
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.response))
+			})
+			req := httptest.NewRequest(http.MethodPost, "/v1/fine_tuning/jobs", strings.NewReader(body))
+			req.Header.Set("X-Tenant-ID", tc.tenant)
+			rec := httptest.NewRecorder()
+			FineTuneAccounting(accountant, provider, "X-Tenant-ID")(next).ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if called != (tc.status != 0) {
+				t.Fatalf("upstream called = %v", called)
+			}
+			jobs, _ := store.PendingFineTunes(ctx)
+			spend, _ := store.FineTuneSpend(ctx, tc.tenant, month)
+			if !tc.tracked {
+				if len(jobs) != 0 || spend != tc.spent {
+					t.Fatalf("expected nothing tracked or charged, got %+v and spend %v", jobs, spend)
+				}
+				return
+			}
+			if len(jobs) != 1 || jobs[0].ID != "ftjob-1" || jobs[0].Month != month {
+				t.Fatalf("expected the job tracked, got %+v", jobs)
+			}
+			if jobs[0].Estimate <= 0 || spend != jobs[0].Estimate {
+				t.Fatalf("spend = %v, want the estimate %v charged", spend, jobs[0].Estimate)
+			}
+		})
+	}
+}
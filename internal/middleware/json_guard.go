@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"agent-sentinel/internal/jsonguard"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderJSONViolation reports why a response that should have been JSON
+// failed the JSON guard ("parse" or "schema").
+const HeaderJSONViolation = "X-Sentinel-JSON-Violation"
+
+// HeaderJSONRetry marks a response served from a retry after a JSON violation.
+const HeaderJSONRetry = "X-Sentinel-JSON-Retry"
+
+// ContextKeyJSONRetry marks the retry of a request whose response failed the
+// JSON guard, which loop detection and mirroring skip.
+const ContextKeyJSONRetry ContextKey = "json_retry"
+
+// JSONGuard checks that non-streaming responses to requests asking for JSON
+// (or from tenants whose policy requires it) parse and match the requested
+// schema. Violations are counted and, when the policy allows, retried once
+// with a corrective hint. The retry goes through rate limiting like a new
+// request and both calls are charged.
+func JSONGuard(guard *jsonguard.Guard, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		structured, ok := provider.(providers.StructuredOutputProvider)
+		if guard == nil || !ok {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			policy := guard.Policy()
+			if r.Method != http.MethodPost || (!policy.Enabled && !policy.Requires(tenantID)) ||
+				!isGenerationPath(r.URL.Path) || strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			requested, schema := structured.RequestedJSON(data)
+			if stream, _ := data["stream"].(bool); stream || !(policy.Enabled && requested || policy.Requires(tenantID)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Let the transport negotiate and decode compression so the
+			// buffered response can be inspected.
+			r.Header.Del("Accept-Encoding")
+
+			// The body's buffer returns to the pool once the first attempt
+			// is sent, so the retry rewrites a copy.
+			orig := bytes.Clone(body)
+			first := newBufferedResponse()
+			next.ServeHTTP(first, r)
+			violation := checkJSONResponse(first, structured, schema)
+			if violation == nil {
+				first.writeTo(w)
+				return
+			}
+			telemetry.IncJSONViolation(r.Context(), provider.Name(), tenantID, violation.Reason)
+			slog.InfoContext(r.Context(), "Response failed JSON guard",
+				"tenant_id", tenantID,
+				"provider", provider.Name(),
+				"reason", violation.Reason,
+				"error", violation.Err,
+			)
+			first.header.Set(HeaderJSONViolation, violation.Reason)
+			if !policy.Retry {
+				first.writeTo(w)
+				return
+			}
+
+			providers.InjectHint(provider, r.URL.Path, data, jsonguard.CorrectionHint(violation))
+			retryReq := r.Clone(context.WithValue(r.Context(), ContextKeyJSONRetry, true))
+			rewriteJSONBody(retryReq, orig, data)
+			retry := newBufferedResponse()
+			next.ServeHTTP(retry, retryReq)
+
+			outcome := "valid"
+			switch {
+			case retry.status >= http.StatusBadRequest:
+				// Denied (e.g. out of budget) or failed: keep the first answer.
+				outcome = "rejected"
+				if retry.status == http.StatusTooManyRequests {
+					outcome = "denied"
+				}
+				first.writeTo(w)
+			default:
+				if again := checkJSONResponse(retry, structured, schema); again != nil {
+					outcome = "invalid"
+					telemetry.IncJSONViolation(r.Context(), provider.Name(), tenantID, again.Reason)
+					retry.header.Set(HeaderJSONViolation, again.Reason)
+				}
+				retry.header.Set(HeaderJSONRetry, "true")
+				retry.writeTo(w)
+			}
+			telemetry.IncJSONRetry(r.Context(), provider.Name(), tenantID, outcome)
+			slog.InfoContext(r.Context(), "Retried response that failed JSON guard",
+				"tenant_id", tenantID,
+				"outcome", outcome,
+				"status", retry.status,
+			)
+		})
+	}
+}
+
+// checkJSONResponse checks the generated text of a successful JSON response.
+// Responses without text (e.g. only tool calls) pass.
+func checkJSONResponse(resp *bufferedResponse, structured providers.StructuredOutputProvider, schema map[string]any) *jsonguard.Violation {
+	if resp.status != http.StatusOK || resp.header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal(resp.body.Bytes(), &data); err != nil {
+		return nil
+	}
+	return jsonguard.Check(structured.ExtractResponseTexts(data), schema)
+}
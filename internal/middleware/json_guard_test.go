@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/jsonguard"
+	"agent-sentinel/internal/providers/openai"
+)
+
+func TestJSONGuardRetriesSchemaViolation(t *testing.T) {
+	guard := jsonguard.NewGuard()
+	guard.Set(&jsonguard.Policy{Enabled: true, Retry: true})
+	prov, _ := openai.New("k")
+
+	var hinted, retryMarked bool
+	calls := 0
+	h := JSONGuard(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		raw, _ := io.ReadAll(r.Body)
+		hinted = strings.Contains(string(raw), "did not match the required JSON schema")
+		retryMarked = isRetry(r.Context())
+		content := `{\"answer\": 42}`
+		if calls > 1 {
+			content = `{\"answer\": \"42\"}`
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"` + content + `"},"finish_reason":"stop"}]}`))
+	}))
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"q"}],"response_format":{"type":"json_schema","json_schema":{"name":"a","schema":{"type":"object","properties":{"answer":{"type":"string"}},"required":["answer"]}}}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if calls != 2 || !hinted || !retryMarked {
+		t.Fatalf("expected one hinted retry, got %d calls (hinted %v, marked %v)", calls, hinted, retryMarked)
+	}
+	if rec.Header().Get(HeaderJSONRetry) != "true" || rec.Header().Get(HeaderJSONViolation) != "" {
+		t.Fatalf("expected the valid retried response, got headers %v", rec.Header())
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !strings.Contains(rec.Body.String(), `\"42\"`) {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+}
+
+func TestJSONGuardTenantPolicyWithoutRetry(t *testing.T) {
+	guard := jsonguard.NewGuard()
+	guard.Set(&jsonguard.Policy{Tenants: []string{"acme"}})
+	prov, _ := openai.New("k")
+
+	calls := 0
+	h := JSONGuard(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"content":"Sure! Here it is: {}"}}]}`))
+	}))
+	serve := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("acme"); calls != 1 || rec.Header().Get(HeaderJSONViolation) != jsonguard.ReasonParse {
+		t.Fatalf("expected a flagged parse violation without retry, got %d calls, headers %v", calls, rec.Header())
+	}
+	if rec := serve("other"); rec.Header().Get(HeaderJSONViolation) != "" {
+		t.Fatal("expected other tenants' free-text responses to pass")
+	}
+}
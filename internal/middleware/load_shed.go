@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"agent-sentinel/internal/shed"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderPriority sets a request's load-shedding priority: low, normal (default)
+// or high.
+const HeaderPriority = "X-Sentinel-Priority"
+
+// LoadShedding rejects requests with 503 while the shedder reports pressure,
+// lowest priority first. It runs before any body is read so shed requests cost
+// almost nothing.
+func LoadShedding(shedder *shed.Shedder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if shedder == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			priority := r.Header.Get(HeaderPriority)
+			shedding, reason := shedder.Shed(priority)
+			if !shedding {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if priority == "" {
+				priority = shed.PriorityNormal
+			}
+			slog.DebugContext(r.Context(), "Request shed under load",
+				"priority", priority,
+				"reason", reason,
+				"path", r.URL.Path,
+			)
+			telemetry.IncLoadShed(r.Context(), priority, reason)
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(shedder.RetryAfter().Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Proxy overloaded, request shed. Retry later.",
+					"type":    "overloaded_error",
+					"code":    "overloaded",
+				},
+			})
+		})
+	}
+}
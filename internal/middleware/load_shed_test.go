@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/shed"
+)
+
+func TestLoadSheddingRejectsLowPriority(t *testing.T) {
+	// A one-goroutine threshold is always exceeded.
+	shedder := shed.New(shed.Config{MaxGoroutines: 1})
+	shedder.Evaluate()
+
+	handler := LoadShedding(shedder)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(priority string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if priority != "" {
+			req.Header.Set(HeaderPriority, priority)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("low")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "5" {
+		t.Fatalf("expected 503 with Retry-After, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := serve("high"); rr.Code != http.StatusOK {
+		t.Fatalf("expected high priority admitted, got %d", rr.Code)
+	}
+}
@@ -1,9 +1,7 @@
 package middleware
 
 import (
-	"bytes"
 	"encoding/json"
-	"io"
 	"log/slog"
 	"net/http"
 
@@ -19,7 +17,7 @@ func Logging(provider providers.Provider, next http.Handler) http.Handler {
 
 		model := provider.ExtractModelFromPath(r.URL.Path)
 
-		body, err := io.ReadAll(r.Body)
+		body, err := readBody(r)
 		if err != nil {
 			slog.Error("Failed to read request body",
 				"error", err,
@@ -29,7 +27,6 @@ func Logging(provider providers.Provider, next http.Handler) http.Handler {
 			http.Error(w, "Error reading request body", http.StatusBadRequest)
 			return
 		}
-		r.Body = io.NopCloser(bytes.NewReader(body))
 
 		var prompt string
 		var data map[string]any
@@ -1,14 +1,15 @@
 package middleware
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"io"
+	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"strings"
 
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/loopdetect"
 	"agent-sentinel/internal/providers"
 	"agent-sentinel/internal/telemetry"
 	pb "embedding-sidecar/proto"
@@ -18,15 +19,27 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// HeaderLoopBypass asks to skip loop detection for a request; its value is
+// recorded as the reason. Only tenants granted the bypass are exempted.
+const HeaderLoopBypass = "X-Sentinel-Loop-Bypass"
+
+// maxBypassReasonLen bounds the client-supplied reason kept in the audit trail.
+const maxBypassReasonLen = 200
+
 type LoopClient interface {
 	Check(ctx context.Context, tenantID, prompt string) (*pb.CheckLoopResponse, error)
 }
 
-// LoopDetection middleware calls the embedding sidecar to detect loops and injects a hint on detection.
-func LoopDetection(client LoopClient, provider providers.Provider, headerName, interventionHint string) func(http.Handler) http.Handler {
+// LoopDetection middleware calls the embedding sidecar to detect loops and injects a hint, rendered
+// for the tenant and provider by hints, on detection. Prompts are canonicalized by canon first.
+// When cycles is set, it also flags exact tool-call repetition cycles per session (tenant plus
+// HeaderSession); either check may be disabled by passing nil.
+// Tenants allowed by bypass can skip it with HeaderLoopBypass; every bypass request is audited.
+// Every check is also counted, anonymized, in analytics (which may be nil).
+func LoopDetection(client LoopClient, provider providers.Provider, headerName string, hints *loopdetect.Hints, bypass *loopdetect.BypassGuard, canon *loopdetect.Canonicalizer, cycles *loopdetect.ToolCycles, analytics *loopdetect.Analytics) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if client == nil || provider == nil || r.Method != http.MethodPost {
+			if (client == nil && cycles == nil) || provider == nil || r.Method != http.MethodPost || isRetry(r.Context()) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -41,13 +54,25 @@ func LoopDetection(client LoopClient, provider providers.Provider, headerName, i
 				return
 			}
 
-			body, err := io.ReadAll(r.Body)
+			if reason := r.Header.Get(HeaderLoopBypass); reason != "" {
+				allowed := bypass.Allows(tenantID)
+				auditLoopBypass(r, tenantID, reason, allowed)
+				if allowed {
+					Decide(ctx, StageLoopDetection, "bypassed", "")
+					if span != nil {
+						span.SetAttributes(attribute.Bool("loop.bypassed", true))
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			body, err := readBody(r)
 			if err != nil {
-				slog.Warn("loop detect: failed to read body", "error", err)
+				slog.WarnContext(r.Context(), "loop detect: failed to read body", "error", err)
 				next.ServeHTTP(w, r)
 				return
 			}
-			r.Body = io.NopCloser(bytes.NewReader(body))
 
 			var data map[string]any
 			if err := json.Unmarshal(body, &data); err != nil {
@@ -55,23 +80,51 @@ func LoopDetection(client LoopClient, provider providers.Provider, headerName, i
 				return
 			}
 
-			prompt := provider.ExtractFullText(data)
-			if prompt == "" {
-				next.ServeHTTP(w, r)
-				return
+			model, _ := data["model"].(string)
+			if model == "" {
+				model = provider.ExtractModelFromPath(r.URL.Path)
 			}
 
-			resp, err := client.Check(ctx, tenantID, prompt)
-			if err != nil {
-				slog.Warn("loop detect: sidecar check failed (fail-open)", "error", err)
-				if span != nil {
-					span.RecordError(err)
-					span.SetStatus(codes.Error, err.Error())
+			var cycle *loopdetect.ToolCycle
+			cycleChecked := false
+			if extractor, ok := provider.(providers.ToolCallExtractor); ok && cycles != nil {
+				session := tenantID
+				if s := r.Header.Get(HeaderSession); s != "" {
+					session += "/" + s
 				}
-				next.ServeHTTP(w, r)
-				return
+				cycle = cycles.Observe(session, extractor.ExtractToolCalls(data))
+				cycleChecked = true
+			}
+
+			var resp *pb.CheckLoopResponse
+			if prompt := canon.Apply(provider.ExtractFullText(data)); client != nil && prompt != "" {
+				resp, err = client.Check(ctx, tenantID, prompt)
+				if err != nil {
+					slog.WarnContext(r.Context(), "loop detect: sidecar check failed (fail-open)", "error", err)
+					Decide(ctx, StageLoopDetection, "fail_open", "sidecar_error")
+					if span != nil {
+						span.RecordError(err)
+						span.SetStatus(codes.Error, err.Error())
+					}
+					resp = nil
+				}
+			}
+			if resp != nil || cycleChecked {
+				analytics.Observe(loopdetect.LoopCheck{
+					Tenant:         tenantID,
+					Session:        r.Header.Get(HeaderSession),
+					Provider:       provider.Name(),
+					Model:          model,
+					Similarity:     resp.GetMaxSimilarity(),
+					HasSimilarity:  resp != nil,
+					SimilarityLoop: resp.GetLoopDetected(),
+					ToolCycle:      cycle != nil,
+				})
 			}
-			if resp == nil || !resp.GetLoopDetected() {
+			if !resp.GetLoopDetected() && cycle == nil {
+				if resp != nil {
+					Decide(ctx, StageLoopDetection, "clear", fmt.Sprintf("similarity=%.2f", resp.GetMaxSimilarity()))
+				}
 				if span != nil {
 					span.SetAttributes(
 						attribute.Bool("loop.detected", false),
@@ -82,27 +135,70 @@ func LoopDetection(client LoopClient, provider providers.Provider, headerName, i
 				return
 			}
 
-			if provider.InjectHint(data, interventionHint) {
-				updated, err := json.Marshal(data)
-				if err == nil {
-					r.Body = io.NopCloser(bytes.NewReader(updated))
-					r.ContentLength = int64(len(updated))
-					r.Header.Set("Content-Length", strconv.Itoa(len(updated)))
-				}
+			hintData := loopdetect.HintData{
+				Tenant:        tenantID,
+				Provider:      provider.Name(),
+				Model:         model,
+				SimilarPrompt: resp.GetSimilarPrompt(),
+				Similarity:    resp.GetMaxSimilarity(),
 			}
+			if cycle != nil {
+				hintData.ToolCycle = cycle.String()
+			}
+			if providers.InjectHint(provider, r.URL.Path, data, hints.Render(hintData)) {
+				setJSONBody(r, data)
+			}
+			var found []string
+			if resp.GetLoopDetected() {
+				found = append(found, fmt.Sprintf("similarity=%.2f", resp.GetMaxSimilarity()))
+			}
+			if cycle != nil {
+				found = append(found, "tool_cycle="+hintData.ToolCycle)
+			}
+			Decide(ctx, StageLoopDetection, "detected", strings.Join(found, ","))
 
-			if span != nil {
-				span.SetAttributes(
-					attribute.Bool("loop.detected", true),
-					attribute.Float64("loop.max_similarity", resp.GetMaxSimilarity()),
-				)
+			if resp.GetLoopDetected() {
+				if span != nil {
+					span.SetAttributes(
+						attribute.Bool("loop.detected", true),
+						attribute.Float64("loop.max_similarity", resp.GetMaxSimilarity()),
+					)
+				}
+				slog.InfoContext(r.Context(), "loop detected", "tenant_id", tenantID, "max_similarity", resp.GetMaxSimilarity(), "similar_prompt", resp.GetSimilarPrompt())
+				events.Record(events.TypeLoopDetected, tenantID, withExperiment(r.Context(), map[string]any{"max_similarity": resp.GetMaxSimilarity()}))
+			}
+			if cycle != nil {
+				if span != nil {
+					span.SetAttributes(
+						attribute.Bool("loop.detected", true),
+						attribute.String("loop.tool_cycle", hintData.ToolCycle),
+					)
+				}
+				slog.InfoContext(r.Context(), "tool loop detected", "tenant_id", tenantID, "cycle", hintData.ToolCycle, "repeats", cycle.Repeats)
+				events.Record(events.TypeLoopDetected, tenantID, withExperiment(r.Context(), map[string]any{"tool_cycle": hintData.ToolCycle, "repeats": cycle.Repeats}))
+				telemetry.IncToolCycle(r.Context(), tenantID, len(cycle.Steps))
 			}
-			slog.Info("loop detected", "tenant_id", tenantID, "max_similarity", resp.GetMaxSimilarity(), "similar_prompt", resp.GetSimilarPrompt())
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// auditLoopBypass records a bypass request, granted or not, in the event feed,
+// the log and metrics.
+func auditLoopBypass(r *http.Request, tenantID, reason string, allowed bool) {
+	if len(reason) > maxBypassReasonLen {
+		reason = reason[:maxBypassReasonLen]
+	}
+	detail := withExperiment(r.Context(), map[string]any{"allowed": allowed, "reason": reason, "path": r.URL.Path})
+	events.Record(events.TypeLoopBypass, tenantID, detail)
+	telemetry.IncLoopBypass(r.Context(), tenantID, allowed)
+	if allowed {
+		slog.InfoContext(r.Context(), "loop detection bypassed", "tenant_id", tenantID, "reason", reason)
+		return
+	}
+	slog.WarnContext(r.Context(), "loop detection bypass refused: tenant not granted", "tenant_id", tenantID, "reason", reason)
+}
+
 // telemetryTracer returns the global tracer; separated for testability.
 func telemetryTracer() trace.Tracer {
 	return telemetry.Tracer()
@@ -5,12 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/loopdetect"
 	"agent-sentinel/internal/providers"
 	pb "embedding-sidecar/proto"
 )
@@ -49,7 +53,7 @@ func TestLoopDetectSkipNoTenant(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/x", bytes.NewReader([]byte(`{"body":1}`)))
 	// no tenant header
 	nextCalled := false
-	handler := LoopDetection(client, prov, "X-Tenant-ID", "hint")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := LoopDetection(client, prov, "X-Tenant-ID", loopdetect.NewHints("hint"), nil, nil, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	}))
 	handler.ServeHTTP(rr, req)
@@ -74,7 +78,7 @@ func TestLoopDetectInjectsOnDetect(t *testing.T) {
 	req.Header.Set("X-Tenant-ID", "t1")
 
 	nextCalled := false
-	handler := LoopDetection(client, prov, "X-Tenant-ID", "hint")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := LoopDetection(client, prov, "X-Tenant-ID", loopdetect.NewHints("hint"), nil, nil, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 		buf, _ := io.ReadAll(r.Body)
 		if !bytes.Contains(buf, []byte("hint")) {
@@ -95,7 +99,7 @@ func TestLoopDetectFailOpen(t *testing.T) {
 	req.Header.Set("X-Tenant-ID", "t1")
 
 	nextCalled := false
-	handler := LoopDetection(client, prov, "X-Tenant-ID", "hint")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := LoopDetection(client, prov, "X-Tenant-ID", loopdetect.NewHints("hint"), nil, nil, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	}))
 	handler.ServeHTTP(rr, req)
@@ -103,3 +107,77 @@ func TestLoopDetectFailOpen(t *testing.T) {
 		t.Fatalf("expected next called on fail-open")
 	}
 }
+
+func TestLoopDetectBypassRequiresGrant(t *testing.T) {
+	t.Setenv("LOOP_BYPASS_TENANTS", "granted")
+	bypass := loopdetect.NewBypassGuard()
+	client := &fakeLoopClient{resp: &pb.CheckLoopResponse{LoopDetected: true, MaxSimilarity: 0.99}}
+	prov := fakeProviderLD{text: "hi"}
+
+	serve := func(tenant string) bool {
+		var hinted bool
+		handler := LoopDetection(client, prov, "X-Tenant-ID", loopdetect.NewHints("hint"), bypass, nil, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, _ := io.ReadAll(r.Body)
+			hinted = bytes.Contains(buf, []byte("hint"))
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/x", bytes.NewReader([]byte(`{"body":1}`)))
+		req.Header.Set("X-Tenant-ID", tenant)
+		req.Header.Set(HeaderLoopBypass, "debugging false positive")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return hinted
+	}
+
+	before := len(events.Default().Recent(1000, events.TypeLoopBypass))
+	if serve("granted") {
+		t.Fatal("expected granted tenant to bypass loop detection")
+	}
+	if !serve("other") {
+		t.Fatal("expected bypass header from an ungranted tenant to be ignored")
+	}
+	if got := len(events.Default().Recent(1000, events.TypeLoopBypass)) - before; got != 2 {
+		t.Fatalf("expected both bypass requests audited, got %d events", got)
+	}
+}
+
+type fakeToolProviderLD struct {
+	fakeProviderLD
+}
+
+func (f fakeToolProviderLD) ExtractToolCalls(body map[string]any) []providers.ToolCall {
+	id, _ := body["id"].(string)
+	name, _ := body["tool"].(string)
+	return []providers.ToolCall{{ID: id, Name: name, Arguments: `{}`}}
+}
+
+func TestLoopDetectToolCycleWithoutSidecar(t *testing.T) {
+	cycles := loopdetect.NewToolCycles(4, 2, time.Minute, nil)
+	analytics := loopdetect.NewAnalytics(1, time.Minute)
+	handler := LoopDetection(nil, fakeToolProviderLD{}, "X-Tenant-ID", loopdetect.NewHints("cycle {{.ToolCycle}}"), nil, nil, cycles, analytics)
+	var hint any
+	serve := handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		hint = body["hinted"]
+	}))
+
+	for i, tool := range []string{"search", "read", "search", "read"} {
+		payload, _ := json.Marshal(map[string]any{"id": fmt.Sprint(i), "tool": tool})
+		req := httptest.NewRequest(http.MethodPost, "/x", bytes.NewReader(payload))
+		req.Header.Set("X-Tenant-ID", "t1")
+		req.Header.Set(HeaderSession, "run-1")
+		serve.ServeHTTP(httptest.NewRecorder(), req)
+		if i < 3 && hint != nil {
+			t.Fatalf("unexpected hint after step %d: %v", i, hint)
+		}
+	}
+	if hint != "cycle search → read" {
+		t.Fatalf("expected tool cycle hint, got %v", hint)
+	}
+	report := analytics.Report()
+	if len(report.Models) != 1 {
+		t.Fatalf("expected one model in the analytics, got %+v", report)
+	}
+	if m := report.Models[0]; m.Checks != 4 || m.Loops != 1 || m.ToolCycles != 1 || m.SessionsLooped != 1 || m.AvgChecksToLoop != 4 {
+		t.Fatalf("unexpected loop analytics %+v", m)
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/mcp"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// ToolBucket is implemented by limiters that keep per-tenant token buckets for
+// each MCP tool, shared across replicas.
+type ToolBucket interface {
+	TakeToolToken(ctx context.Context, tenantID, tool string, rate ratelimit.SmoothingRate) (bool, time.Duration, error)
+}
+
+// MCP governs tools/call requests sent to MCP servers through the proxy. Each
+// call takes a token from the tenant's bucket for the tool, and is checked by
+// cycles (which may be nil) for identical calls or call cycles repeating in
+// the MCP session. Refused messages get a JSON-RPC error per call instead of
+// reaching the server; a loop's error carries the intervention hint rendered
+// for provider "mcp". Tenants allowed by bypass can skip loop detection with
+// HeaderLoopBypass. Every call is logged and recorded as an mcp_tool_call
+// event with its outcome. Bucket errors fail open.
+func MCP(guard *mcp.Guard, buckets ToolBucket, cycles *loopdetect.ToolCycles, hints *loopdetect.Hints, bypass *loopdetect.BypassGuard, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server, _, ok := mcp.Route(r.URL.Path)
+			if !ok || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			calls, err := mcp.ParseCalls(server, body)
+			if err != nil || len(calls) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			tenantID := r.Header.Get(headerName)
+			session := r.Header.Get(mcp.HeaderSession)
+			batch := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+
+			if refusal := checkToolRates(ctx, guard, buckets, tenantID, calls); refusal != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(refusal.wait.Seconds())))))
+				refuseMCP(w, r, server, tenantID, session, calls, batch, refusal)
+				return
+			}
+			if cycles != nil && tenantID != "" && !mcpLoopBypassed(r, tenantID, bypass) {
+				steps := make([]providers.ToolCall, 0, len(calls))
+				for _, call := range calls {
+					steps = append(steps, providers.ToolCall{ID: string(call.ID), Name: call.Tool, Arguments: string(call.Arguments)})
+				}
+				if cycle := cycles.Observe(tenantID+"/mcp/"+server+"/"+session, steps); cycle != nil {
+					hint := hints.Render(loopdetect.HintData{Tenant: tenantID, Provider: "mcp", ToolCycle: cycle.String()})
+					slog.InfoContext(ctx, "MCP tool loop detected", "tenant_id", tenantID, "server", server, "cycle", cycle.String(), "repeats", cycle.Repeats)
+					events.Record(events.TypeLoopDetected, tenantID, map[string]any{"tool_cycle": cycle.String(), "repeats": cycle.Repeats, "mcp_server": server})
+					telemetry.IncToolCycle(ctx, tenantID, len(cycle.Steps))
+					refuseMCP(w, r, server, tenantID, session, calls, batch, &mcpRefusal{
+						outcome: "loop",
+						code:    mcp.CodeToolLoop,
+						message: "Tool loop detected (" + cycle.String() + "). " + hint,
+						data:    map[string]any{"code": "tool_loop", "cycle": cycle.String(), "repeats": cycle.Repeats},
+					})
+					return
+				}
+			}
+
+			start := time.Now()
+			rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			for _, call := range calls {
+				auditToolCall(ctx, server, tenantID, session, call, "allowed", rw.status, time.Since(start))
+			}
+		})
+	}
+}
+
+// mcpRefusal is why a message's tool calls were not forwarded.
+type mcpRefusal struct {
+	outcome string
+	code    int
+	message string
+	data    map[string]any
+	wait    time.Duration
+}
+
+// checkToolRates takes a token for each call, returning a refusal for the
+// first tool whose bucket is empty.
+func checkToolRates(ctx context.Context, guard *mcp.Guard, buckets ToolBucket, tenantID string, calls []mcp.Call) *mcpRefusal {
+	if guard == nil || buckets == nil || tenantID == "" {
+		return nil
+	}
+	policy := guard.Policy()
+	for _, call := range calls {
+		rate, ok := policy.For(call.Tool)
+		if !ok {
+			continue
+		}
+		allowed, wait, err := buckets.TakeToolToken(ctx, tenantID, call.Tool, rate)
+		if err != nil {
+			slog.WarnContext(ctx, "Tool bucket check failed, failing open",
+				"error", err,
+				"tenant_id", tenantID,
+				"tool", call.Tool,
+			)
+			continue
+		}
+		if allowed {
+			continue
+		}
+		slog.WarnContext(ctx, "Tool rate exceeded",
+			"tenant_id", tenantID,
+			"tool", call.Tool,
+			"burst", rate.Burst,
+			"rate_per_second", rate.RatePerSecond,
+			"retry_after", wait,
+		)
+		return &mcpRefusal{
+			outcome: "rate_limited",
+			code:    mcp.CodeToolRateExceeded,
+			message: "Rate limit exceeded for tool " + call.Tool + ". Retry after " + wait.Round(time.Millisecond).String() + ".",
+			data:    map[string]any{"code": "tool_rate_exceeded", "tool": call.Tool, "retry_after_seconds": wait.Seconds()},
+			wait:    wait,
+		}
+	}
+	return nil
+}
+
+// mcpLoopBypassed reports whether the request skips loop detection with
+// HeaderLoopBypass.
+func mcpLoopBypassed(r *http.Request, tenantID string, bypass *loopdetect.BypassGuard) bool {
+	reason := r.Header.Get(HeaderLoopBypass)
+	if reason == "" {
+		return false
+	}
+	allowed := bypass.Allows(tenantID)
+	auditLoopBypass(r, tenantID, reason, allowed)
+	return allowed
+}
+
+// refuseMCP answers every call in the message with the refusal as a JSON-RPC
+// error, in a batch when the message was one.
+func refuseMCP(w http.ResponseWriter, r *http.Request, server, tenantID, session string, calls []mcp.Call, batch bool, refusal *mcpRefusal) {
+	responses := make([]map[string]any, 0, len(calls))
+	for _, call := range calls {
+		responses = append(responses, mcp.ErrorResponse(call.ID, refusal.code, refusal.message, refusal.data))
+		auditToolCall(r.Context(), server, tenantID, session, call, refusal.outcome, 0, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if batch {
+		_ = json.NewEncoder(w).Encode(responses)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(responses[0])
+}
+
+// auditToolCall logs a tool call and records it in the event feed and
+// metrics. Arguments are logged by hash only, since they may hold secrets.
+func auditToolCall(ctx context.Context, server, tenantID, session string, call mcp.Call, outcome string, status int, duration time.Duration) {
+	sum := sha256.Sum256(call.Arguments)
+	argsHash := hex.EncodeToString(sum[:8])
+	slog.InfoContext(ctx, "MCP tool call",
+		"tenant_id", tenantID,
+		"server", server,
+		"tool", call.Tool,
+		"session", session,
+		"arguments_sha256", argsHash,
+		"outcome", outcome,
+		"status", status,
+		"duration_ms", duration.Milliseconds(),
+	)
+	detail := map[string]any{
+		"server":           server,
+		"tool":             call.Tool,
+		"arguments_sha256": argsHash,
+		"outcome":          outcome,
+	}
+	if session != "" {
+		detail["session"] = session
+	}
+	if status != 0 {
+		detail["status"] = status
+	}
+	events.Record(events.TypeMCPToolCall, tenantID, detail)
+	telemetry.IncMCPToolCall(ctx, tenantID, server, call.Tool, outcome)
+}
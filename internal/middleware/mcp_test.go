@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/mcp"
+	"agent-sentinel/internal/ratelimit"
+)
+
+type fakeToolBucket struct {
+	tokens map[string]int
+}
+
+func (b *fakeToolBucket) TakeToolToken(ctx context.Context, tenantID, tool string, rate ratelimit.SmoothingRate) (bool, time.Duration, error) {
+	if b.tokens[tool] == 0 {
+		return false, 2500 * time.Millisecond, nil
+	}
+	b.tokens[tool]--
+	return true, 0, nil
+}
+
+func serveMCP(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/mcp/github", strings.NewReader(body))
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set(mcp.HeaderSession, "s-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMCPRateLimitsTools(t *testing.T) {
+	guard := mcp.NewGuard()
+	guard.Set(&mcp.Policy{Tools: map[string]ratelimit.SmoothingRate{"github/create_issue": {Burst: 1, RatePerSecond: 0.1}}})
+	bucket := &fakeToolBucket{tokens: map[string]int{"github/create_issue": 1}}
+	calls := 0
+	h := MCP(guard, bucket, nil, nil, nil, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	call := `{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"create_issue","arguments":{"title":"x"}}}`
+
+	if rec := serveMCP(h, strings.Replace(call, "%d", "1", 1)); rec.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("expected the first call forwarded, got %d after %d calls", rec.Code, calls)
+	}
+	rec := serveMCP(h, strings.Replace(call, "%d", "2", 1))
+	var resp struct {
+		ID    int `json:"id"`
+		Error struct {
+			Code int            `json:"code"`
+			Data map[string]any `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || calls != 1 || resp.ID != 2 || resp.Error.Code != mcp.CodeToolRateExceeded || resp.Error.Data["code"] != "tool_rate_exceeded" {
+		t.Fatalf("expected a JSON-RPC rate limit error, got %q after %d calls", rec.Body.String(), calls)
+	}
+	if rec.Header().Get("Retry-After") != "3" {
+		t.Fatalf("Retry-After = %q, want 3", rec.Header().Get("Retry-After"))
+	}
+
+	// Other tools and other methods are not limited.
+	serveMCP(h, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"search","arguments":{}}}`)
+	serveMCP(h, `{"jsonrpc":"2.0","id":4,"method":"tools/list"}`)
+	if calls != 3 {
+		t.Fatalf("expected unlimited messages forwarded, got %d calls", calls)
+	}
+}
+
+func TestMCPDetectsRepeatedCalls(t *testing.T) {
+	cycles := loopdetect.NewToolCycles(1, 3, time.Minute, nil)
+	calls := 0
+	h := MCP(nil, nil, cycles, loopdetect.NewHints("Try something else."), nil, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	call := `[{"jsonrpc":"2.0","id":"%s","method":"tools/call","params":{"name":"search","arguments":{"q":"same","page":1}}}]`
+
+	serveMCP(h, strings.Replace(call, "%s", "a", 1))
+	serveMCP(h, strings.Replace(call, "%s", "b", 1))
+	rec := serveMCP(h, strings.Replace(call, "%s", "c", 1))
+	var resp []struct {
+		ID    string `json:"id"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || calls != 2 || len(resp) != 1 || resp[0].ID != "c" || resp[0].Error.Code != mcp.CodeToolLoop {
+		t.Fatalf("expected the third identical call refused, got %q after %d calls", rec.Body.String(), calls)
+	}
+	if !strings.Contains(resp[0].Error.Message, "Try something else.") {
+		t.Fatalf("expected the intervention hint in %q", resp[0].Error.Message)
+	}
+
+	var audited []events.Event
+	for _, e := range events.Default().Recent(50, events.TypeMCPToolCall) {
+		if e.TenantID == "acme" && e.Detail["tool"] == "github/search" {
+			audited = append(audited, e)
+		}
+	}
+	if len(audited) < 3 || audited[0].Detail["outcome"] != "loop" {
+		t.Fatalf("expected every call audited, newest refused, got %+v", audited)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	"agent-sentinel/internal/mirror"
+)
+
+// Mirror copies a sampled share of requests to a shadow backend. It runs after
+// rate limiting, so only admitted requests are mirrored, and the copies bypass
+// the limiter entirely: mirrored traffic is never charged to a tenant.
+func Mirror(m *mirror.Mirror) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if m == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || isRetry(r.Context()) || !m.Sampled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The copy outlives the request; the pooled body does not.
+			m.Send(r, bytes.Clone(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/upstream"
+)
+
+// HeaderModelCache reports whether a model list response came from the cache
+// ("hit") or the provider ("miss").
+const HeaderModelCache = "X-Sentinel-Cache"
+
+// ModelCache answers model list requests from the cache while the stored
+// response is fresh, and stores successful provider responses. It runs inside
+// upstream headers so per-tenant headers are part of the cache key.
+func ModelCache(cache *upstream.ModelCache, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cache == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !upstream.IsModelListPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenantID := r.Header.Get(headerName)
+			key := upstream.ModelCacheKey(r)
+
+			if entry, ok := cache.Get(key); ok {
+				telemetry.IncModelCache(r.Context(), provider.Name(), tenantID, "hit")
+				if entry.ContentType != "" {
+					w.Header().Set("Content-Type", entry.ContentType)
+				}
+				w.Header().Set("Age", strconv.Itoa(int(cache.Age(entry).Seconds())))
+				w.Header().Set(HeaderModelCache, "hit")
+				Decide(r.Context(), StageModelCache, "hit", "")
+				w.Header().Set("Content-Length", strconv.Itoa(len(entry.Body)))
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(entry.Body)
+				return
+			}
+
+			// Ask for an identity body so the cached copy can be served to any
+			// client.
+			r.Header.Del("Accept-Encoding")
+			resp := newBufferedResponse()
+			next.ServeHTTP(resp, r)
+			if resp.status == http.StatusOK && resp.header.Get("Content-Encoding") == "" {
+				cache.Put(key, resp.header.Get("Content-Type"), resp.body.Bytes())
+			}
+			telemetry.IncModelCache(r.Context(), provider.Name(), tenantID, "miss")
+			resp.header.Set(HeaderModelCache, "miss")
+			resp.writeTo(w)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/upstream"
+)
+
+func TestModelCacheServesRepeatedListsLocally(t *testing.T) {
+	prov, _ := openai.New("k")
+	calls := 0
+	h := ModelCache(upstream.NewModelCache(time.Minute), prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/v1/models" && r.Header.Get("Accept-Encoding") != "" {
+			t.Errorf("expected Accept-Encoding to be dropped, got %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o"}]}`))
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve(http.MethodGet, "/v1/models")
+	second := serve(http.MethodGet, "/v1/models")
+	if calls != 1 || first.Header().Get(HeaderModelCache) != "miss" || second.Header().Get(HeaderModelCache) != "hit" {
+		t.Fatalf("expected one upstream call then a hit, got %d calls (%q, %q)", calls, first.Header().Get(HeaderModelCache), second.Header().Get(HeaderModelCache))
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the cached response, got %q (%v)", second.Body.String(), second.Header())
+	}
+
+	serve(http.MethodPost, "/v1/chat/completions")
+	serve(http.MethodGet, "/v1/files")
+	if calls != 3 {
+		t.Fatalf("expected other requests to pass through, got %d calls", calls)
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/operations"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Operations governs provider requests other than POSTs (file, batch and
+// fine-tuning management): requests the operations policy does not allow get
+// a 403, and every one is audit logged. Deletions and other mutating requests
+// are also recorded as admin events.
+func Operations(guard *operations.Guard, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenantID := r.Header.Get(headerName)
+
+			if policy := guard.Policy(); policy != nil && !policy.Allows(tenantID, r.Method, r.URL.Path) {
+				slog.InfoContext(r.Context(), "Provider operation not allowed",
+					"tenant_id", tenantID,
+					"provider", provider.Name(),
+					"method", r.Method,
+					"path", r.URL.Path,
+				)
+				telemetry.IncOperation(r.Context(), provider.Name(), tenantID, r.Method, "denied")
+				events.Record(events.TypeProviderOperation, tenantID, map[string]any{
+					"method":  r.Method,
+					"path":    r.URL.Path,
+					"allowed": false,
+				})
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"error": map[string]any{
+						"message": "Operation not allowed by policy: " + r.Method + " " + r.URL.Path,
+						"type":    "permission_error",
+						"code":    "operation_not_allowed",
+					},
+				})
+				return
+			}
+
+			start := time.Now()
+			rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rw, r)
+			slog.InfoContext(r.Context(), "Provider operation",
+				"tenant_id", tenantID,
+				"provider", provider.Name(),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rw.status,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+			telemetry.IncOperation(r.Context(), provider.Name(), tenantID, r.Method, "allowed")
+			if operations.Mutating(r.Method) {
+				events.Record(events.TypeProviderOperation, tenantID, map[string]any{
+					"method":  r.Method,
+					"path":    r.URL.Path,
+					"allowed": true,
+					"status":  rw.status,
+				})
+			}
+		})
+	}
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
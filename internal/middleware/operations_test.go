@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/operations"
+	"agent-sentinel/internal/providers/openai"
+)
+
+func TestOperationsEnforcesPolicyOnNonPOST(t *testing.T) {
+	guard := operations.NewGuard()
+	guard.Set(&operations.Policy{
+		Allow:   []string{"GET *"},
+		Tenants: map[string][]string{"ml-platform": {"DELETE /v1/files/*"}},
+	})
+	prov, _ := openai.New("k")
+	calls := 0
+	h := Operations(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method, path, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodDelete, "/v1/files/file-abc", "acme")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "operation_not_allowed") || calls != 0 {
+		t.Fatalf("expected the delete to be refused, got %d %q after %d calls", rec.Code, rec.Body.String(), calls)
+	}
+	for _, rec := range []*httptest.ResponseRecorder{
+		serve(http.MethodDelete, "/v1/files/file-abc", "ml-platform"),
+		serve(http.MethodGet, "/v1/files", "acme"),
+		serve(http.MethodPost, "/v1/chat/completions", "acme"),
+	} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected allowed requests to pass, got %d", rec.Code)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 forwarded requests, got %d", calls)
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderMaxTokensInjected reports the default output token cap added to a
+// request that did not set one.
+const HeaderMaxTokensInjected = "X-Sentinel-Max-Tokens-Injected"
+
+// generationSuffixes are the request paths that accept an output token cap.
+// Other endpoints (embeddings, token counting, model listing) are left alone.
+var generationSuffixes = []string{
+	"/chat/completions",
+	"/completions",
+	"/responses",
+	"/messages",
+	":generateContent",
+	":streamGenerateContent",
+}
+
+func isGenerationPath(path string) bool {
+	for _, suffix := range generationSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputTokens applies the output token policy: requests without a cap get the
+// default and larger caps are clamped to the maximum. It runs before rate
+// limiting so the estimate is made from the cap actually sent upstream.
+func OutputTokens(guard *ratelimit.OutputTokenGuard, provider providers.Provider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if guard == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := guard.Policy()
+			if r.Method != http.MethodPost || (policy.Default == 0 && policy.Max == 0) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			before := ratelimit.ExtractMaxOutputTokens(data)
+			maxOutput, changed := policy.Apply(data, provider.Name(), isGenerationPath(r.URL.Path))
+			if !changed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			setJSONBody(r, data)
+
+			model := provider.ExtractModelFromPath(r.URL.Path)
+			if model == "" {
+				model, _ = data["model"].(string)
+			}
+			action := "clamped"
+			header := HeaderMaxTokensClamped
+			if before == 0 {
+				action = "injected"
+				header = HeaderMaxTokensInjected
+			}
+			w.Header().Set(header, strconv.Itoa(maxOutput))
+			Decide(r.Context(), StageOutputTokens, action, strconv.Itoa(maxOutput))
+			telemetry.IncMaxTokensAdjusted(r.Context(), provider.Name(), model, action)
+			slog.DebugContext(r.Context(), "Applied output token policy",
+				"action", action,
+				"model", model,
+				"requested", before,
+				"max_output_tokens", maxOutput,
+			)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+func TestOutputTokensInjectsAndClamps(t *testing.T) {
+	guard := ratelimit.NewOutputTokenGuard()
+	guard.Set(&ratelimit.OutputTokenPolicy{Default: 1024, Max: 8192})
+
+	var got map[string]any
+	h := OutputTokens(guard, fakeProvider{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = nil
+		_ = json.Unmarshal(body, &got)
+	}))
+	send := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := send("/v1/chat/completions", `{"model":"gpt-4o","messages":[]}`)
+	if got["max_completion_tokens"] != float64(1024) || rec.Header().Get(HeaderMaxTokensInjected) != "1024" {
+		t.Fatalf("expected injected default, got %v (headers %v)", got, rec.Header())
+	}
+
+	rec = send("/v1/chat/completions", `{"model":"gpt-4o","messages":[],"max_tokens":50000}`)
+	if got["max_tokens"] != float64(8192) || rec.Header().Get(HeaderMaxTokensClamped) != "8192" {
+		t.Fatalf("expected clamped cap, got %v (headers %v)", got, rec.Header())
+	}
+
+	send("/v1/embeddings", `{"model":"text-embedding-3-small","input":"hi"}`)
+	if _, ok := got["max_completion_tokens"]; ok {
+		t.Fatalf("expected embeddings request untouched, got %v", got)
+	}
+
+	guard.Set(&ratelimit.OutputTokenPolicy{})
+	send("/v1/chat/completions", `{"model":"gpt-4o","messages":[]}`)
+	if _, ok := got["max_completion_tokens"]; ok {
+		t.Fatalf("expected no change with an empty policy, got %v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/provenance"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Provenance stamps provider responses with a signed provenance.Header naming
+// the request, tenant, provider, model and policy version. With stream events
+// enabled, event streams also end with a provenance.EventName event whose stamp
+// covers the streamed body. It runs just outside logging, so the model stamped
+// is the one actually sent and responses the proxy answers itself are not
+// stamped.
+func Provenance(signer *provenance.Signer, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if signer == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stamp := provenance.Stamp{
+				RequestID:     telemetry.RequestID(r.Context()),
+				TenantID:      r.Header.Get(headerName),
+				Provider:      provider.Name(),
+				Model:         provider.ExtractModelFromPath(r.URL.Path),
+				PolicyVersion: signer.PolicyVersion(),
+			}
+			if stamp.Model == "" && r.Method == http.MethodPost {
+				var data struct {
+					Model string `json:"model"`
+				}
+				if body, err := readBody(r); err == nil && json.Unmarshal(body, &data) == nil {
+					stamp.Model = data.Model
+				}
+			}
+			pw := &provenanceWriter{ResponseWriter: w, signer: signer, stamp: stamp}
+			next.ServeHTTP(pw, r)
+			if pw.digest == nil {
+				return
+			}
+			pw.stamp.SHA256 = hex.EncodeToString(pw.digest.Sum(nil))
+			if _, err := w.Write(signer.Event(pw.stamp)); err != nil {
+				slog.DebugContext(r.Context(), "Failed to write provenance event", "error", err)
+				return
+			}
+			_ = http.NewResponseController(w).Flush()
+		})
+	}
+}
+
+// provenanceWriter adds the stamp when the response starts and, for event
+// streams when stream events are enabled, hashes the body written through it.
+type provenanceWriter struct {
+	http.ResponseWriter
+	signer  *provenance.Signer
+	stamp   provenance.Stamp
+	written bool
+	digest  hash.Hash
+}
+
+func (w *provenanceWriter) WriteHeader(status int) {
+	w.emit(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *provenanceWriter) Write(b []byte) (int, error) {
+	w.emit(http.StatusOK)
+	n, err := w.ResponseWriter.Write(b)
+	if w.digest != nil {
+		w.digest.Write(b[:n])
+		w.stamp.Bytes += int64(n)
+	}
+	return n, err
+}
+
+// Flush starts the response, if needed, before flushing it.
+func (w *provenanceWriter) Flush() {
+	w.emit(http.StatusOK)
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *provenanceWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *provenanceWriter) emit(status int) {
+	if w.written || status < http.StatusOK {
+		return
+	}
+	w.written = true
+	w.stamp.Time = time.Now()
+	h := w.Header()
+	h.Set(provenance.Header, w.signer.Sign(w.stamp))
+	// An event can only be appended to an uncompressed stream of unknown
+	// length.
+	if w.signer.StreamEvent() && status < http.StatusBadRequest &&
+		strings.Contains(h.Get("Content-Type"), "text/event-stream") &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Length") == "" {
+		w.digest = sha256.New()
+	}
+}
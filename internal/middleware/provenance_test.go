@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/provenance"
+	"agent-sentinel/internal/telemetry"
+)
+
+func TestProvenance(t *testing.T) {
+	signer := provenance.NewSigner([]byte("secret"), "k1", true)
+	signer.SetPolicyVersion("3a7bd3e2360a")
+	keys := map[string][]byte{"k1": []byte("secret")}
+	const streamed = "data: {\"choices\":[]}\n\ndata: [DONE]\n\n"
+	h := Provenance(signer, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(streamed))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	}))
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		req.Header.Set("X-Tenant-ID", "acme")
+		req = req.WithContext(telemetry.WithRequestID(req.Context(), "req-1"))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("/v1/chat/completions")
+	fields, err := provenance.Verify(rec.Header().Get(provenance.Header), keys)
+	if err != nil {
+		t.Fatalf("Verify(%q): %v", rec.Header().Get(provenance.Header), err)
+	}
+	if fields["rid"] != "req-1" || fields["tenant"] != "acme" || fields["provider"] != "fake" || fields["model"] != "gpt-4o" || fields["policy"] != "3a7bd3e2360a" {
+		t.Fatalf("fields = %v", fields)
+	}
+	if rec.Body.String() != `{"id":"chatcmpl-1"}` {
+		t.Fatalf("body = %q, want it unchanged", rec.Body)
+	}
+
+	rec = send("/stream")
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, streamed+"event: "+provenance.EventName+"\n") {
+		t.Fatalf("body = %q, want the stream followed by a provenance event", body)
+	}
+	stamp := strings.TrimSuffix(strings.SplitN(body, `"provenance":"`, 2)[1], "\"}\n\n")
+	fields, err = provenance.Verify(stamp, keys)
+	sum := sha256.Sum256([]byte(streamed))
+	if err != nil || fields["sha256"] != hex.EncodeToString(sum[:]) || fields["bytes"] != strconv.Itoa(len(streamed)) {
+		t.Fatalf("Verify = %v, %v", fields, err)
+	}
+}
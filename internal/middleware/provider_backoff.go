@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/upstream"
+)
+
+// ProviderBackoff rejects requests with 429 while the provider has reported the
+// API key as rate limited, instead of sending traffic that will only be refused.
+// It runs before tenant rate limiting so rejected requests reserve no spend.
+func ProviderBackoff(backoff *upstream.Backoff, provider providers.Provider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if backoff == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, wait := backoff.Allow()
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			model := provider.ExtractModelFromPath(r.URL.Path)
+			slog.WarnContext(r.Context(), "Provider rate limited, backing off",
+				"provider", provider.Name(),
+				"model", model,
+				"retry_after", wait,
+			)
+			telemetry.RecordRateLimitRequest(r.Context(), "denied", "provider_backoff", provider.Name(), model, "")
+
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Upstream provider rate limit reached. Retry after " + (time.Duration(retryAfter) * time.Second).String() + ".",
+					"type":    "rate_limit_error",
+					"code":    "provider_rate_limited",
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/upstream"
+)
+
+func TestProviderBackoffRejectsWhileLimited(t *testing.T) {
+	backoff := upstream.NewBackoff(0)
+	called := 0
+	h := ProviderBackoff(backoff, fakeProvider{model: "m"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if rec.Code != http.StatusOK || called != 1 {
+		t.Fatalf("expected pass-through before any limit, got %d", rec.Code)
+	}
+
+	limited := http.Header{}
+	limited.Set("Retry-After", "3")
+	backoff.Observe(http.StatusTooManyRequests, limited)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if rec.Code != http.StatusTooManyRequests || called != 1 {
+		t.Fatalf("expected 429 without calling upstream, got %d (calls %d)", rec.Code, called)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Fatalf("expected Retry-After 3, got %q", got)
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+// ProviderHealth rejects requests with 503 while the prober reports the proxied
+// key as down (PROBE_FAIL_FAST), so clients fail over immediately instead of
+// waiting on a provider that is not answering. Probes keep running, and traffic
+// resumes with the first successful one. It runs before tenant rate limiting so
+// rejected requests reserve no spend.
+func ProviderHealth(prober *probe.Prober, provider providers.Provider) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if prober == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !prober.Unavailable() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			model := provider.ExtractModelFromPath(r.URL.Path)
+			slog.WarnContext(r.Context(), "Provider down according to probes, failing fast",
+				"provider", provider.Name(),
+				"model", model,
+			)
+			telemetry.RecordRateLimitRequest(r.Context(), "denied", "provider_down", provider.Name(), model, "")
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(prober.Interval().Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Upstream provider is unavailable according to health probes.",
+					"type":    "api_error",
+					"code":    "provider_unavailable",
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/providers/openai"
+)
+
+type probeTransport struct {
+	status int
+}
+
+func (t *probeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: t.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}")), Request: r}, nil
+}
+
+func TestProviderHealthFailsFastWhileDown(t *testing.T) {
+	provider, _ := openai.New("sk-test")
+	transport := &probeTransport{status: http.StatusInternalServerError}
+	prober := probe.New(probe.Config{FailureThreshold: 2, FailFast: true}, probe.Target{Key: "OPENAI_API_KEY", Provider: provider, Transport: transport})
+	called := 0
+	h := ProviderHealth(prober, fakeProvider{model: "m"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+		return rec
+	}
+
+	prober.ProbeAll(context.Background())
+	if rec := serve(); rec.Code != http.StatusOK || called != 1 {
+		t.Fatalf("expected pass-through while degraded, got %d", rec.Code)
+	}
+
+	prober.ProbeAll(context.Background())
+	rec := serve()
+	if rec.Code != http.StatusServiceUnavailable || called != 1 || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("expected 503 without calling upstream, got %d (calls %d)", rec.Code, called)
+	}
+
+	transport.status = http.StatusOK
+	prober.ProbeAll(context.Background())
+	if rec := serve(); rec.Code != http.StatusOK || called != 2 {
+		t.Fatalf("expected traffic to resume after recovery, got %d", rec.Code)
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/events"
 	"agent-sentinel/internal/providers"
 	"agent-sentinel/internal/ratelimit"
 	"agent-sentinel/internal/telemetry"
@@ -25,14 +27,41 @@ const (
 	ContextKeyProvider ContextKey = "rate_limit_provider"
 	ContextKeyPricing  ContextKey = "rate_limit_pricing"
 	ContextKeyReqStart ContextKey = "request_start_time"
+	// ContextKeyReservationID carries the spend reservation to settle after the response.
+	ContextKeyReservationID ContextKey = "rate_limit_reservation_id"
+	// ContextKeyInputTokens carries the estimated input token count.
+	ContextKeyInputTokens ContextKey = "rate_limit_input_tokens"
 )
 
-type RateLimiter interface {
-	CheckLimitAndIncrement(ctx context.Context, tenantID string, estimatedCost float64) (*ratelimit.CheckLimitResult, error)
-	GetPricing(provider, model string) (ratelimit.Pricing, bool)
+// HeaderMaxTokensClamped reports the output token cap applied to keep a request
+// within its per-request cost ceiling.
+const HeaderMaxTokensClamped = "X-Sentinel-Max-Tokens-Clamped"
+
+// HeaderDowngradedFrom names the requested model when a low budget switched the
+// request to a cheaper one.
+const HeaderDowngradedFrom = "X-Sentinel-Downgraded-From"
+
+// UsageRecorder is implemented by limiters that keep a per-request usage log.
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, rec ratelimit.UsageRecord)
+}
+
+// RecordUsage adds rec to limiter's usage log in the background, if it keeps
+// one. ctx is the request the record is for; it only places the write in the
+// request's async group.
+func RecordUsage(ctx context.Context, limiter any, rec ratelimit.UsageRecord) {
+	if recorder, ok := limiter.(UsageRecorder); ok {
+		async.Go(ctx, func() { recorder.RecordUsage(context.Background(), rec) })
+	}
 }
 
-func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+// RateLimiting reserves each request's estimated cost against its tenant's
+// budget. With models, prompts longer than the model's context window are
+// rejected, output estimates are bounded by the model's limits, and downgrades
+// skip models whose context window the prompt does not fit.
+func RateLimiting(limiter ratelimit.Limiter, provider providers.Provider, headerName string, models *ratelimit.ModelCatalog) func(http.Handler) http.Handler {
+	scanMinBytes := scanMinBytesFromEnv()
+	textPaths, _ := provider.(providers.TextPathProvider)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if limiter == nil || provider == nil || r.Method != http.MethodPost {
@@ -42,7 +71,7 @@ func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName s
 
 			tenantID := r.Header.Get(headerName)
 			if tenantID == "" {
-				slog.Debug("No tenant ID in request, skipping rate limit",
+				slog.DebugContext(r.Context(), "No tenant ID in request, skipping rate limit",
 					"header", headerName,
 					"path", r.URL.Path,
 				)
@@ -55,30 +84,48 @@ func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName s
 				r = r.WithContext(context.WithValue(r.Context(), ContextKeyReqStart, time.Now()))
 			}
 
-			body, err := io.ReadAll(r.Body)
+			body, err := readBody(r)
 			if err != nil {
-				slog.Error("Failed to read request body for rate limiting",
+				slog.ErrorContext(r.Context(), "Failed to read request body for rate limiting",
 					"error", err,
 					"tenant_id", tenantID,
 				)
 				next.ServeHTTP(w, r)
 				return
 			}
-			r.Body = io.NopCloser(bytes.NewReader(body))
 
-			model := provider.ExtractModelFromPath(r.URL.Path)
-			var data map[string]any
-			if err := json.Unmarshal(body, &data); err == nil {
-				if model == "" {
-					if m, ok := data["model"].(string); ok {
-						model = m
-					}
+			// Large bodies are scanned for the fields estimation reads rather than
+			// decoded, so a multi-megabyte prompt is not held twice. data, the
+			// full decode, is then only built if the body has to be rewritten.
+			var data, fields map[string]any
+			var requestText string
+			if textPaths != nil && scanMinBytes > 0 && len(body) >= scanMinBytes {
+				if scanned, err := ratelimit.ScanRequest(bytes.NewReader(body), textPaths.RequestTextPaths()); err == nil {
+					fields, requestText = scanned.Fields, scanned.Text
+				}
+			} else {
+				if err := json.Unmarshal(body, &data); err == nil {
+					fields = data
+				}
+				requestText = provider.ExtractFullText(data)
+			}
+			decoded := func() map[string]any {
+				if data == nil && fields != nil {
+					_ = json.Unmarshal(body, &data)
+				}
+				return data
+			}
+
+			pathModel := provider.ExtractModelFromPath(r.URL.Path)
+			model := pathModel
+			if model == "" {
+				if m, ok := fields["model"].(string); ok {
+					model = m
 				}
 			}
 
-			requestText := provider.ExtractFullText(data)
 			if requestText == "" {
-				slog.Debug("No text content found for token estimation",
+				slog.DebugContext(r.Context(), "No text content found for token estimation",
 					"tenant_id", tenantID,
 					"model", model,
 				)
@@ -86,51 +133,178 @@ func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName s
 				return
 			}
 
-			estStart := time.Now()
-			inputTokens := ratelimit.CountTokens(requestText, model)
+			maxOutputFromRequest := ratelimit.ExtractMaxOutputTokens(fields)
+			estimate := func(model string) (int, ratelimit.Pricing, float64) {
+				estStart := time.Now()
+				inputTokens := ratelimit.CountPromptTokens(requestText, model)
+
+				pricing, found := limiter.GetPricing(provider.Name(), model)
+				if !found {
+					pricing = ratelimit.DefaultPricing(provider.Name())
+					slog.DebugContext(r.Context(), "Using default pricing for unknown model",
+						"model", model,
+						"provider", provider.Name(),
+					)
+				}
+
+				estimatedOutputTokens := ratelimit.EstimateOutputTokens(inputTokens, maxOutputFromRequest)
+				if info, ok := models.Lookup(provider.Name(), model); ok {
+					estimatedOutputTokens = info.BoundOutput(inputTokens, estimatedOutputTokens)
+				}
+				estimatedCost := ratelimit.CalculateCost(inputTokens, estimatedOutputTokens, pricing)
+				telemetry.ObserveEstimateLatency(r.Context(), provider.Name(), model, tenantID, time.Since(estStart))
+				return inputTokens, pricing, estimatedCost
+			}
+			inputTokens, pricing, estimatedCost := estimate(model)
 
-			pricing, found := limiter.GetPricing(provider.Name(), model)
-			if !found {
-				pricing = ratelimit.DefaultPricing(provider.Name())
-				slog.Debug("Using default pricing for unknown model",
+			ctx := r.Context()
+			if info, ok := models.Lookup(provider.Name(), model); ok && !info.Fits(inputTokens) {
+				RecordUsage(ctx, limiter, ratelimit.UsageRecord{TenantID: tenantID, Provider: provider.Name(), Model: model, Outcome: ratelimit.UsageDenied, Estimate: estimatedCost, InputPrice: pricing.InputPrice, OutputPrice: pricing.OutputPrice})
+				Decide(ctx, StageRateLimit, "over_context", fmt.Sprintf("tokens=%d,window=%d", inputTokens, info.ContextWindow))
+				rejectOverContext(ctx, w, provider, tenantID, model, inputTokens, info.ContextWindow)
+				return
+			}
+			// notes collects adjustments for the decision trace.
+			var notes []string
+			if ceiling, clamp := limiter.MaxRequestCost(tenantID); ceiling > 0 && estimatedCost > ceiling {
+				maxOutput := 0
+				if clamp && fields != nil {
+					maxOutput = ratelimit.MaxOutputTokensWithin(ceiling, inputTokens, pricing)
+				}
+				if maxOutput <= 0 {
+					RecordUsage(ctx, limiter, ratelimit.UsageRecord{TenantID: tenantID, Provider: provider.Name(), Model: model, Outcome: ratelimit.UsageDenied, Estimate: estimatedCost, InputPrice: pricing.InputPrice, OutputPrice: pricing.OutputPrice})
+					Decide(ctx, StageRateLimit, "over_ceiling", fmt.Sprintf("est=%.4f,ceiling=%.4f", estimatedCost, ceiling))
+					rejectOverCeiling(ctx, w, provider, tenantID, model, estimatedCost, ceiling)
+					return
+				}
+				ratelimit.SetMaxOutputTokens(decoded(), provider.Name(), maxOutput)
+				setJSONBody(r, data)
+				maxOutputFromRequest = maxOutput
+				estimatedCost = ratelimit.CalculateCost(inputTokens, ratelimit.EstimateOutputTokens(inputTokens, maxOutput), pricing)
+				w.Header().Set(HeaderMaxTokensClamped, strconv.Itoa(maxOutput))
+				notes = append(notes, "clamped="+strconv.Itoa(maxOutput))
+				slog.InfoContext(r.Context(), "Clamped max output tokens to per-request cost ceiling",
+					"tenant_id", tenantID,
 					"model", model,
-					"provider", provider.Name(),
+					"max_output_tokens", maxOutput,
+					"max_request_cost", ceiling,
 				)
 			}
 
-			maxOutputFromRequest := ratelimit.ExtractMaxOutputTokens(data)
-			estimatedOutputTokens := ratelimit.EstimateOutputTokens(inputTokens, maxOutputFromRequest)
-			estimatedCost := ratelimit.CalculateCost(inputTokens, estimatedOutputTokens, pricing)
-			telemetry.ObserveEstimateLatency(r.Context(), provider.Name(), model, tenantID, time.Since(estStart))
-
-			ctx := r.Context()
-			result, err := limiter.CheckLimitAndIncrement(ctx, tenantID, estimatedCost)
-			if err != nil {
-				slog.Warn("Rate limit check failed, failing open",
+			// failOpen forwards the request unmetered when a limit check fails.
+			failOpen := func(err error) {
+				slog.WarnContext(r.Context(), "Rate limit check failed, failing open",
 					"error", err,
 					"tenant_id", tenantID,
 				)
 				telemetry.RecordRateLimitRequest(ctx, "fail_open", "redis_error", provider.Name(), model, tenantID)
+				Decide(ctx, StageRateLimit, "fail_open", "redis_error")
 				next.ServeHTTP(w, r)
+			}
+			result, err := limiter.CheckLimitAndIncrement(ctx, tenantID, estimatedCost)
+			if err != nil {
+				failOpen(err)
 				return
 			}
 
+			// Low or exhausted budget: retry with the policy's cheaper model. An allowed
+			// reservation is returned first so only the cheaper estimate stays charged,
+			// and taken again if the cheaper model is then denied.
+			if target, ok := limiter.DowngradeFor(tenantID, model, result); ok {
+				downgraded := true
+				if info, known := models.Lookup(provider.Name(), target); known && !info.Fits(inputTokens) {
+					slog.DebugContext(r.Context(), "Skipping downgrade to a model with a smaller context window",
+						"tenant_id", tenantID,
+						"from", model,
+						"to", target,
+						"input_tokens", inputTokens,
+						"context_window", info.ContextWindow,
+					)
+					downgraded = false
+				}
+				if downgraded && result.Allowed {
+					if err := limiter.RefundEstimate(ctx, tenantID, result.ReservationID, estimatedCost); err != nil {
+						slog.WarnContext(r.Context(), "Failed to release estimate for downgrade, keeping requested model",
+							"error", err,
+							"tenant_id", tenantID,
+						)
+						downgraded = false
+					}
+				}
+				if downgraded {
+					reason := "low_budget"
+					if !result.Allowed {
+						reason = "over_limit"
+					}
+					targetInput, targetPricing, targetCost := estimate(target)
+					targetResult, err := limiter.CheckLimitAndIncrement(ctx, tenantID, targetCost)
+					if err != nil {
+						failOpen(err)
+						return
+					}
+					switch {
+					case targetResult.Allowed:
+						rewriteModel(r, decoded(), pathModel, target)
+						w.Header().Set(HeaderDowngradedFrom, model)
+						notes = append(notes, "downgraded="+model+">"+target)
+						telemetry.IncModelDowngrade(ctx, provider.Name(), model, target, reason)
+						slog.InfoContext(r.Context(), "Request downgraded to cheaper model",
+							"tenant_id", tenantID,
+							"from", model,
+							"to", target,
+							"reason", reason,
+							"remaining", result.Remaining,
+						)
+						model, inputTokens, pricing, estimatedCost, result = target, targetInput, targetPricing, targetCost, targetResult
+					case result.Allowed:
+						// The requested model was admitted before its reservation was
+						// returned; take it again rather than deny the request.
+						if result, err = limiter.CheckLimitAndIncrement(ctx, tenantID, estimatedCost); err != nil {
+							failOpen(err)
+							return
+						}
+					default:
+						model, inputTokens, pricing, estimatedCost, result = target, targetInput, targetPricing, targetCost, targetResult
+					}
+				}
+			}
+
 			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%.2f", result.Limit))
 			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%.2f", result.Remaining))
 			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			if result.Schedule != "" {
+				w.Header().Set("X-RateLimit-Schedule", result.Schedule)
+			}
+			if result.Overdraft > 0 {
+				w.Header().Set("X-RateLimit-Overdraft", fmt.Sprintf("%.4f", result.Overdraft))
+			}
+			if result.Prepaid {
+				w.Header().Set("X-Credit-Balance", fmt.Sprintf("%.2f", result.Remaining))
+			}
 
 			if !result.Allowed {
-				slog.Warn("Rate limit exceeded",
+				slog.WarnContext(r.Context(), "Rate limit exceeded",
 					"tenant_id", tenantID,
+					"limited_by", result.LimitedBy,
 					"current_spend", result.CurrentSpend,
 					"limit", result.Limit,
 					"estimated_cost", estimatedCost,
 				)
-				telemetry.RecordRateLimitRequest(ctx, "denied", "over_limit", provider.Name(), model, tenantID)
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("Retry-After", "3600")
-				w.WriteHeader(http.StatusTooManyRequests)
-				_ = json.NewEncoder(w).Encode(map[string]any{
+				reason := "over_limit"
+				if result.Prepaid {
+					reason = "insufficient_credits"
+				}
+				telemetry.RecordRateLimitRequest(ctx, "denied", reason, provider.Name(), model, tenantID)
+				Decide(ctx, StageRateLimit, reason, fmt.Sprintf("est=%.4f,spend=%.4f,limit=%.2f", estimatedCost, result.CurrentSpend, result.Limit))
+				RecordUsage(ctx, limiter, ratelimit.UsageRecord{TenantID: tenantID, Provider: provider.Name(), Model: model, Outcome: ratelimit.UsageDenied, Estimate: estimatedCost, InputPrice: pricing.InputPrice, OutputPrice: pricing.OutputPrice})
+				events.Record(events.TypeRateLimitDenied, tenantID, withExperiment(ctx, map[string]any{
+					"model":          model,
+					"limited_by":     result.LimitedBy,
+					"current_spend":  result.CurrentSpend,
+					"limit":          result.Limit,
+					"estimated_cost": estimatedCost,
+				}))
+				body := map[string]any{
 					"error": map[string]any{
 						"message": "Rate limit exceeded. Hourly spend limit reached.",
 						"type":    "rate_limit_error",
@@ -139,20 +313,67 @@ func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName s
 					"current_spend": result.CurrentSpend,
 					"limit":         result.Limit,
 					"remaining":     result.Remaining,
-				})
+				}
+				if result.LimitedBy != "" && result.LimitedBy != tenantID {
+					// The budget of a parent tenant (team/org) is exhausted.
+					body["limited_by"] = result.LimitedBy
+				}
+				if result.Prepaid {
+					// Credits don't replenish over time, so there is no Retry-After.
+					body = map[string]any{
+						"error": map[string]any{
+							"message": "Insufficient credit balance.",
+							"type":    "rate_limit_error",
+							"code":    "insufficient_credits",
+						},
+						"balance":        result.Remaining,
+						"estimated_cost": estimatedCost,
+					}
+				} else {
+					w.Header().Set("Retry-After", "3600")
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_ = json.NewEncoder(w).Encode(body)
 				return
 			}
 
+			telemetry.SetGenAIRequest(r.Context(), model, maxOutputFromRequest, fields)
 			ctx = context.WithValue(r.Context(), ContextKeyTenantID, tenantID)
 			ctx = context.WithValue(ctx, ContextKeyEstimate, estimatedCost)
 			ctx = context.WithValue(ctx, ContextKeyModel, model)
 			ctx = context.WithValue(ctx, ContextKeyProvider, provider)
 			ctx = context.WithValue(ctx, ContextKeyPricing, pricing)
+			ctx = context.WithValue(ctx, ContextKeyReservationID, result.ReservationID)
+			ctx = context.WithValue(ctx, ContextKeyInputTokens, inputTokens)
 			r = r.WithContext(ctx)
 
-			telemetry.RecordRateLimitRequest(ctx, "allowed", "ok", provider.Name(), model, tenantID)
+			outcome := "allowed"
+			if result.Shadowed {
+				outcome = "shadow"
+			}
+			Decide(ctx, StageRateLimit, outcome, strings.Join(append([]string{fmt.Sprintf("est=%.4f,remaining=%.2f", estimatedCost, result.Remaining)}, notes...), ","))
 
-			slog.Debug("Rate limit check passed",
+			if result.Shadowed {
+				slog.WarnContext(r.Context(), "Rate limit exceeded (shadow mode, allowing)",
+					"tenant_id", tenantID,
+					"current_spend", result.CurrentSpend,
+					"limit", result.Limit,
+					"estimated_cost", estimatedCost,
+				)
+				telemetry.RecordRateLimitRequest(ctx, "shadow", "over_limit", provider.Name(), model, tenantID)
+				events.Record(events.TypeRateLimitDenied, tenantID, withExperiment(ctx, map[string]any{
+					"model":          model,
+					"current_spend":  result.CurrentSpend,
+					"limit":          result.Limit,
+					"estimated_cost": estimatedCost,
+					"shadow":         true,
+				}))
+			} else {
+				telemetry.RecordRateLimitRequest(ctx, "allowed", "ok", provider.Name(), model, tenantID)
+			}
+
+			slog.DebugContext(r.Context(), "Rate limit check passed",
 				"tenant_id", tenantID,
 				"estimated_cost", estimatedCost,
 				"current_spend", result.CurrentSpend,
@@ -163,3 +384,55 @@ func RateLimiting(limiter RateLimiter, provider providers.Provider, headerName s
 		})
 	}
 }
+
+// rejectOverContext answers a prompt estimated to exceed the model's context
+// window, which the provider would reject after the proxy had charged for it.
+func rejectOverContext(ctx context.Context, w http.ResponseWriter, provider providers.Provider, tenantID, model string, inputTokens, window int) {
+	slog.InfoContext(ctx, "Request exceeds the model's context window",
+		"tenant_id", tenantID,
+		"model", model,
+		"input_tokens", inputTokens,
+		"context_window", window,
+	)
+	telemetry.RecordRateLimitRequest(ctx, "denied", "context_window", provider.Name(), model, tenantID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": fmt.Sprintf("The prompt is about %d tokens, which exceeds the %d token context window of %s. Shorten the input.", inputTokens, window, model),
+			"type":    "invalid_request_error",
+			"code":    "context_length_exceeded",
+		},
+		"input_tokens":   inputTokens,
+		"context_window": window,
+	})
+}
+
+// rejectOverCeiling answers a request whose estimate exceeds the per-request cost
+// ceiling. Unlike a spend limit this does not clear with time, so it is a 400.
+func rejectOverCeiling(ctx context.Context, w http.ResponseWriter, provider providers.Provider, tenantID, model string, estimatedCost, ceiling float64) {
+	slog.WarnContext(ctx, "Request exceeds per-request cost ceiling",
+		"tenant_id", tenantID,
+		"model", model,
+		"estimated_cost", estimatedCost,
+		"max_request_cost", ceiling,
+	)
+	telemetry.RecordRateLimitRequest(ctx, "denied", "max_request_cost", provider.Name(), model, tenantID)
+	events.Record(events.TypeRateLimitDenied, tenantID, withExperiment(ctx, map[string]any{
+		"model":            model,
+		"reason":           "max_request_cost",
+		"estimated_cost":   estimatedCost,
+		"max_request_cost": ceiling,
+	}))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": fmt.Sprintf("Estimated request cost $%.4f exceeds the per-request limit of $%.4f. Reduce the input or max tokens.", estimatedCost, ceiling),
+			"type":    "invalid_request_error",
+			"code":    "request_cost_too_high",
+		},
+		"estimated_cost":   estimatedCost,
+		"max_request_cost": ceiling,
+	})
+}
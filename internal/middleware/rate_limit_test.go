@@ -8,9 +8,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/providers/anthropic"
+	"agent-sentinel/internal/providers/gemini"
+	"agent-sentinel/internal/providers/openai"
 	"agent-sentinel/internal/ratelimit"
 )
 
@@ -32,26 +36,48 @@ func (f fakeProvider) ParseTokenUsage(body map[string]any) providers.TokenUsage
 
 type fakeLimiter struct {
 	result *ratelimit.CheckLimitResult
-	err    error
-	refund float64
-	adjust struct {
+	// ceiling and clamp are returned by MaxRequestCost.
+	ceiling float64
+	clamp   bool
+	// results, when set, are returned by successive checks before result.
+	results   []*ratelimit.CheckLimitResult
+	downgrade map[string]string
+	// lowBudget downgrades allowed requests too, as a low-budget policy does.
+	lowBudget bool
+	checks    []float64
+	err       error
+	refund    float64
+	adjust    struct {
 		estimate float64
 		actual   float64
 	}
 }
 
 func (f *fakeLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID string, estimatedCost float64) (*ratelimit.CheckLimitResult, error) {
+	f.checks = append(f.checks, estimatedCost)
+	if len(f.results) > 0 {
+		next := f.results[0]
+		f.results = f.results[1:]
+		return next, f.err
+	}
 	return f.result, f.err
 }
+func (f *fakeLimiter) MaxRequestCost(tenantID string) (float64, bool) {
+	return f.ceiling, f.clamp
+}
+func (f *fakeLimiter) DowngradeFor(tenantID, model string, result *ratelimit.CheckLimitResult) (string, bool) {
+	target, ok := f.downgrade[model]
+	return target, ok && (!result.Allowed || f.lowBudget)
+}
 func (f *fakeLimiter) GetPricing(provider, model string) (ratelimit.Pricing, bool) {
 	return ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, true
 }
-func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error {
+func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
 	f.adjust.estimate = estimate
 	f.adjust.actual = actual
 	return nil
 }
-func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID string, estimate float64) error {
+func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
 	f.refund = estimate
 	return nil
 }
@@ -70,7 +96,7 @@ func TestRateLimitMiddlewareAllow(t *testing.T) {
 	req.Header.Set("X-Tenant-ID", "t1")
 
 	nextCalled := false
-	handler := RateLimiting(limiter, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 		if r.Context().Value(ContextKeyTenantID) != "t1" {
 			t.Fatalf("tenant missing in context")
@@ -105,7 +131,7 @@ func TestRateLimitMiddlewareDeny(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", bytes.NewReader(payload))
 	req.Header.Set("X-Tenant-ID", "t1")
 
-	handler := RateLimiting(limiter, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Fatalf("next should not be called on deny")
 	}))
 	handler.ServeHTTP(rr, req)
@@ -115,6 +141,67 @@ func TestRateLimitMiddlewareDeny(t *testing.T) {
 	}
 }
 
+func TestRateLimitMiddlewareInsufficientCredits(t *testing.T) {
+	body := map[string]any{"contents": []any{map[string]any{"parts": []any{map[string]any{"text": "hi"}}}}}
+	payload, _ := json.Marshal(body)
+
+	limiter := &fakeLimiter{
+		result: &ratelimit.CheckLimitResult{Allowed: false, Prepaid: true, Limit: 0.5, Remaining: 0.5},
+	}
+	prov := fakeProvider{text: "hi"}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", bytes.NewReader(payload))
+	req.Header.Set("X-Tenant-ID", "t1")
+
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("next should not be called without credits")
+	}))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected no Retry-After for prepaid denial")
+	}
+	if rr.Header().Get("X-Credit-Balance") != "0.50" {
+		t.Fatalf("expected credit balance header, got %q", rr.Header().Get("X-Credit-Balance"))
+	}
+	var resp map[string]any
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if errBody, _ := resp["error"].(map[string]any); errBody["code"] != "insufficient_credits" {
+		t.Fatalf("expected insufficient_credits, got %v", resp)
+	}
+}
+
+func TestRateLimitMiddlewareShadowAllows(t *testing.T) {
+	body := map[string]any{"contents": []any{map[string]any{"parts": []any{map[string]any{"text": "hi"}}}}}
+	payload, _ := json.Marshal(body)
+
+	limiter := &fakeLimiter{
+		result: &ratelimit.CheckLimitResult{Allowed: true, Shadowed: true, Limit: 1, CurrentSpend: 1, ReservationID: "res-1"},
+	}
+	prov := fakeProvider{text: "hi"}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", bytes.NewReader(payload))
+	req.Header.Set("X-Tenant-ID", "t1")
+
+	nextCalled := false
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+		if r.Context().Value(ContextKeyReservationID) != "res-1" {
+			t.Fatalf("expected reservation to be propagated in shadow mode")
+		}
+	}))
+	handler.ServeHTTP(rr, req)
+
+	if !nextCalled {
+		t.Fatalf("expected next handler to be called in shadow mode")
+	}
+}
+
 func TestRateLimitMiddlewareFailOpen(t *testing.T) {
 	body := map[string]any{"contents": []any{map[string]any{"parts": []any{map[string]any{"text": "hi"}}}}}
 	payload, _ := json.Marshal(body)
@@ -129,7 +216,7 @@ func TestRateLimitMiddlewareFailOpen(t *testing.T) {
 	req.Header.Set("X-Tenant-ID", "t1")
 
 	nextCalled := false
-	handler := RateLimiting(limiter, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	}))
 	handler.ServeHTTP(rr, req)
@@ -141,3 +228,214 @@ func TestRateLimitMiddlewareFailOpen(t *testing.T) {
 		t.Fatalf("expected status 200, got %d", rr.Code)
 	}
 }
+
+func TestRateLimitMiddlewareDowngradesInsteadOfDenying(t *testing.T) {
+	payload := []byte(`{"model":"big","messages":[{"role":"user","content":"hi"}]}`)
+	limiter := &fakeLimiter{
+		results: []*ratelimit.CheckLimitResult{
+			{Allowed: false, Limit: 1, Remaining: 0.1},
+			{Allowed: true, Limit: 1, Remaining: 0.05, ReservationID: "res-small"},
+		},
+		downgrade: map[string]string{"big": "small"},
+	}
+	prov := fakeProvider{text: "hi"}
+
+	var gotModel string
+	handler := RateLimiting(limiter, prov, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&data)
+		gotModel, _ = data["model"].(string)
+		if r.Context().Value(ContextKeyModel) != "small" || r.Context().Value(ContextKeyReservationID) != "res-small" {
+			t.Fatalf("expected downgraded model and reservation in context")
+		}
+	}))
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(payload))
+	req.Header.Set("X-Tenant-ID", "t1")
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || gotModel != "small" {
+		t.Fatalf("expected request forwarded with the cheaper model, got %d model %q", rr.Code, gotModel)
+	}
+	if got := rr.Header().Get(HeaderDowngradedFrom); got != "big" {
+		t.Fatalf("expected downgrade header, got %q", got)
+	}
+	if len(limiter.checks) != 2 || limiter.refund != 0 {
+		t.Fatalf("expected a second check and no refund after a denial, got checks %v refund %v", limiter.checks, limiter.refund)
+	}
+}
+
+func TestRateLimitMiddlewareKeepsAllowedModelWhenDowngradeDenied(t *testing.T) {
+	payload := []byte(`{"model":"big","messages":[{"role":"user","content":"hi"}]}`)
+	limiter := &fakeLimiter{
+		results: []*ratelimit.CheckLimitResult{
+			{Allowed: true, Limit: 1, Remaining: 0.1, ReservationID: "res-big"},
+			{Allowed: false, Limit: 1, Remaining: 0},
+			{Allowed: true, Limit: 1, Remaining: 0.1, ReservationID: "res-big-again"},
+		},
+		downgrade: map[string]string{"big": "small"},
+		lowBudget: true,
+	}
+
+	var gotModel, gotReservation any
+	handler := RateLimiting(limiter, fakeProvider{text: "hi"}, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotModel, gotReservation = r.Context().Value(ContextKeyModel), r.Context().Value(ContextKeyReservationID)
+	}))
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(payload))
+	req.Header.Set("X-Tenant-ID", "t1")
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || gotModel != "big" || gotReservation != "res-big-again" {
+		t.Fatalf("expected the requested model forwarded, got %d model %v reservation %v", rr.Code, gotModel, gotReservation)
+	}
+	if rr.Header().Get(HeaderDowngradedFrom) != "" || len(limiter.checks) != 3 || limiter.checks[2] != limiter.checks[0] || limiter.refund != limiter.checks[0] {
+		t.Fatalf("expected the refunded estimate reserved again, got checks %v refund %v", limiter.checks, limiter.refund)
+	}
+}
+
+func TestRateLimitMiddlewareContextWindow(t *testing.T) {
+	payload := []byte(`{"model":"big","messages":[{"role":"user","content":"hi"}]}`)
+	serve := func(limiter *fakeLimiter, models *ratelimit.ModelCatalog, text string) (*httptest.ResponseRecorder, string) {
+		var gotModel string
+		handler := RateLimiting(limiter, fakeProvider{text: text}, "X-Tenant-ID", models)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotModel, _ = r.Context().Value(ContextKeyModel).(string)
+		}))
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(payload))
+		req.Header.Set("X-Tenant-ID", "t1")
+		handler.ServeHTTP(rr, req)
+		return rr, gotModel
+	}
+	models := ratelimit.NewModelCatalog()
+	models.Set(ratelimit.ProviderModels{"fake": {
+		"big":   {ContextWindow: 100},
+		"small": {ContextWindow: 20},
+	}})
+	prompt := strings.Repeat("hello ", 50)
+
+	limiter := &fakeLimiter{result: &ratelimit.CheckLimitResult{Allowed: true, Limit: 10, Remaining: 9}}
+	if rr, _ := serve(limiter, models, strings.Repeat(prompt, 3)); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "context_length_exceeded") || len(limiter.checks) != 0 {
+		t.Fatalf("expected 400 without a limit check, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The cheaper model's window is too small for the prompt, so the request is denied.
+	limiter = &fakeLimiter{
+		result:    &ratelimit.CheckLimitResult{Allowed: false, Limit: 1, Remaining: 0},
+		downgrade: map[string]string{"big": "small"},
+	}
+	if rr, _ := serve(limiter, models, prompt[:200]); rr.Code != http.StatusTooManyRequests || len(limiter.checks) != 1 {
+		t.Fatalf("expected a denial without a downgrade, got %d (checks %v)", rr.Code, limiter.checks)
+	}
+
+	limiter = &fakeLimiter{
+		results:   []*ratelimit.CheckLimitResult{{Allowed: false, Limit: 1, Remaining: 0}},
+		result:    &ratelimit.CheckLimitResult{Allowed: true, Limit: 1, Remaining: 0.5},
+		downgrade: map[string]string{"big": "small"},
+	}
+	if rr, model := serve(limiter, models, "hi"); rr.Code != http.StatusOK || model != "small" {
+		t.Fatalf("expected a short prompt downgraded, got %d model %q", rr.Code, model)
+	}
+}
+
+func TestRateLimitMiddlewareCostCeiling(t *testing.T) {
+	// The fake prices every token at $1/1M; "hi" is one input token.
+	payload := []byte(`{"model":"m","max_tokens":1000,"messages":[{"role":"user","content":"hi"}]}`)
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(payload))
+		req.Header.Set("X-Tenant-ID", "t1")
+		return req
+	}
+
+	limiter := &fakeLimiter{result: &ratelimit.CheckLimitResult{Allowed: true, Limit: 10, Remaining: 9}, ceiling: 0.0001}
+	handler := RateLimiting(limiter, fakeProvider{text: "hi"}, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("next should not be called over the ceiling")
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusBadRequest || len(limiter.checks) != 0 {
+		t.Fatalf("expected 400 without a limit check, got %d (checks %v)", rr.Code, limiter.checks)
+	}
+
+	limiter.clamp = true
+	var gotMax float64
+	handler = RateLimiting(limiter, fakeProvider{text: "hi"}, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&data)
+		gotMax, _ = data["max_tokens"].(float64)
+	}))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusOK || gotMax != 99 || rr.Header().Get(HeaderMaxTokensClamped) != "99" {
+		t.Fatalf("expected max_tokens clamped to 99, got %d max %v", rr.Code, gotMax)
+	}
+	if len(limiter.checks) != 1 || limiter.checks[0] > 0.0001 {
+		t.Fatalf("expected the clamped estimate to fit the ceiling, got %v", limiter.checks)
+	}
+}
+
+func TestRateLimitMiddlewareWithMemoryLimiter(t *testing.T) {
+	body := map[string]any{"model": "m", "contents": []any{map[string]any{"parts": []any{map[string]any{"text": "hi"}}}}}
+	payload, _ := json.Marshal(body)
+	limiter := ratelimit.NewMemoryLimiter()
+	limiter.SetLimit("t1", 0)
+	handler := RateLimiting(limiter, fakeProvider{model: "m", text: "hi"}, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1beta/models/m:generateContent", bytes.NewReader(payload))
+		req.Header.Set("X-Tenant-ID", "t1")
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 with a zero limit, got %d", code)
+	}
+	limiter.SetLimit("t1", 100)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("expected 200 after raising the limit, got %d", code)
+	}
+	if spend := limiter.GetSpend("t1"); spend <= 0 {
+		t.Fatalf("expected the estimate to be reserved, got spend %v", spend)
+	}
+}
+
+func TestRateLimitMiddlewareScannedEstimateMatchesDecoded(t *testing.T) {
+	openaiProvider, _ := openai.New("key")
+	anthropicProvider, _ := anthropic.New("key")
+	geminiProvider, _ := gemini.New("key")
+	cases := []struct {
+		provider providers.Provider
+		path     string
+		body     string
+	}{
+		{openaiProvider, "/v1/chat/completions", `{"model":"gpt-4o","max_tokens":50,"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hello there"}]}`},
+		{anthropicProvider, "/v1/messages", `{"model":"claude-3-5-sonnet","max_tokens":50,"system":[{"type":"text","text":"be brief"}],"messages":[{"role":"user","content":[{"type":"text","text":"hello there"}]}]}`},
+		{geminiProvider, "/v1beta/models/gemini-1.5-pro:generateContent", `{"contents":[{"parts":[{"text":"hello there"}]}],"generationConfig":{"maxOutputTokens":50}}`},
+	}
+	estimate := func(provider providers.Provider, path, body string) float64 {
+		limiter := &fakeLimiter{result: &ratelimit.CheckLimitResult{Allowed: true, Limit: 10, Remaining: 9}}
+		var got float64
+		handler := RateLimiting(limiter, provider, "X-Tenant-ID", nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = r.Context().Value(ContextKeyEstimate).(float64)
+			forwarded := new(bytes.Buffer)
+			_, _ = forwarded.ReadFrom(r.Body)
+			if forwarded.String() != body {
+				t.Fatalf("%s: body not forwarded intact", provider.Name())
+			}
+		}))
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("X-Tenant-ID", "t1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+	for _, tc := range cases {
+		t.Setenv("ESTIMATE_SCAN_MIN_BYTES", "0")
+		decoded := estimate(tc.provider, tc.path, tc.body)
+		t.Setenv("ESTIMATE_SCAN_MIN_BYTES", "1")
+		scanned := estimate(tc.provider, tc.path, tc.body)
+		if decoded == 0 || scanned != decoded {
+			t.Fatalf("%s: scanned estimate %v, decoded %v", tc.provider.Name(), scanned, decoded)
+		}
+	}
+}
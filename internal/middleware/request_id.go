@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"agent-sentinel/internal/telemetry"
+)
+
+// RequestID gives every request an ID: the client's X-Request-ID when it is a
+// sane value, otherwise a random one. The ID is echoed on the response, added
+// to log lines written with the request context, and sent to the embedding
+// sidecar, so one grep finds the request on both sides. It should run outside
+// tracing so the request span records it too.
+func RequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(telemetry.HeaderRequestID)
+			if !telemetry.ValidRequestID(id) {
+				id = telemetry.NewRequestID()
+			}
+			w.Header().Set(telemetry.HeaderRequestID, id)
+			next.ServeHTTP(w, r.WithContext(telemetry.WithRequestID(r.Context(), id)))
+		})
+	}
+}
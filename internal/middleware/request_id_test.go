@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/telemetry"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = telemetry.RequestID(r.Context())
+	}))
+	serve := func(header string) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		if header != "" {
+			req.Header.Set(telemetry.HeaderRequestID, header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get(telemetry.HeaderRequestID); got != seen {
+			t.Fatalf("expected the response to echo %q, got %q", seen, got)
+		}
+		return seen
+	}
+
+	if got := serve("agent-run-42"); got != "agent-run-42" {
+		t.Fatalf("expected the client's ID kept, got %q", got)
+	}
+	if got := serve(""); len(got) != 32 {
+		t.Fatalf("expected a generated ID, got %q", got)
+	}
+	if got := serve("has spaces\tand tabs"); got == "has spaces\tand tabs" || len(got) != 32 {
+		t.Fatalf("expected an unsafe ID replaced, got %q", got)
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"agent-sentinel/internal/signing"
+	"agent-sentinel/internal/telemetry"
+)
+
+// RequestSigning rejects tenant requests whose HMAC signature is missing (when
+// required), invalid, outside the allowed time window or replayed. It runs
+// before anything trusts the tenant header. The signature headers are removed
+// before the request is forwarded.
+func RequestSigning(verifier *signing.Verifier, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if verifier == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				var err error
+				if body, err = readBody(r); err != nil {
+					writeSigningError(w, http.StatusBadRequest, "failed to read request body")
+					return
+				}
+			}
+			if err := verifier.Verify(r.Context(), r, tenantID, body); err != nil {
+				reason := signing.Reason(err)
+				telemetry.IncSigningRejected(r.Context(), tenantID, reason)
+				slog.InfoContext(r.Context(), "Rejected request signature",
+					"tenant_id", tenantID,
+					"reason", reason,
+					"path", r.URL.Path,
+				)
+				writeSigningError(w, http.StatusUnauthorized, "Request signature rejected: "+err.Error())
+				return
+			}
+			r.Header.Del(signing.HeaderTimestamp)
+			r.Header.Del(signing.HeaderNonce)
+			r.Header.Del(signing.HeaderSignature)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeSigningError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    "authentication_error",
+			"code":    "invalid_signature",
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/signing"
+)
+
+func TestRequestSigning(t *testing.T) {
+	verifier := signing.NewVerifier(signing.ModeRequired, time.Minute, map[string][]string{"acme": {"tenant-key"}}, nil)
+	var forwarded string
+	var leaked bool
+	h := RequestSigning(verifier, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		forwarded = string(raw)
+		leaked = r.Header.Get(signing.HeaderSignature) != ""
+	}))
+
+	body := `{"model":"gpt-4o","messages":[]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("X-Tenant-ID", "acme")
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(signing.HeaderTimestamp, ts)
+	req.Header.Set(signing.HeaderNonce, "nonce-1")
+	req.Header.Set(signing.HeaderSignature, signing.Sign([]byte("tenant-key"), http.MethodPost, "/v1/chat/completions", "acme", ts, "nonce-1", []byte(body)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || forwarded != body || leaked {
+		t.Fatalf("expected the signed request forwarded intact without signature headers, got %d %q (leaked %v)", rec.Code, forwarded, leaked)
+	}
+
+	forwarded = ""
+	req = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || forwarded != "" || !strings.Contains(rec.Body.String(), "invalid_signature") {
+		t.Fatalf("expected an unsigned request to be rejected, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// TokenBucket is implemented by limiters that keep per-tenant token buckets
+// shared across replicas.
+type TokenBucket interface {
+	TakeToken(ctx context.Context, tenantID string, rate ratelimit.SmoothingRate) (bool, time.Duration, error)
+}
+
+// Smoothing throttles bursts: each tenant's requests are drawn from a token
+// bucket, and a request finding it empty gets a 429 with the time until the
+// next token. It runs before rate limiting, so throttled requests reserve no
+// spend. Bucket errors fail open.
+func Smoothing(guard *ratelimit.SmoothingGuard, buckets TokenBucket, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if guard == nil || buckets == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			if r.Method != http.MethodPost || tenantID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rate, ok := guard.Policy().For(tenantID)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, wait, err := buckets.TakeToken(r.Context(), tenantID, rate)
+			if err != nil {
+				slog.WarnContext(r.Context(), "Token bucket check failed, failing open",
+					"error", err,
+					"tenant_id", tenantID,
+				)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			model := provider.ExtractModelFromPath(r.URL.Path)
+			slog.WarnContext(r.Context(), "Request rate exceeded",
+				"tenant_id", tenantID,
+				"burst", rate.Burst,
+				"rate_per_second", rate.RatePerSecond,
+				"retry_after", wait,
+			)
+			telemetry.RecordRateLimitRequest(r.Context(), "denied", "smoothing", provider.Name(), model, tenantID)
+			events.Record(events.TypeRateLimitDenied, tenantID, map[string]any{
+				"reason":          "smoothing",
+				"burst":           rate.Burst,
+				"rate_per_second": rate.RatePerSecond,
+			})
+
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Request rate exceeded. Slow down and retry after " + wait.Round(time.Millisecond).String() + ".",
+					"type":    "rate_limit_error",
+					"code":    "request_rate_exceeded",
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+type fakeBucket struct {
+	tokens int
+	err    error
+}
+
+func (b *fakeBucket) TakeToken(ctx context.Context, tenantID string, rate ratelimit.SmoothingRate) (bool, time.Duration, error) {
+	if b.err != nil {
+		return false, 0, b.err
+	}
+	if b.tokens == 0 {
+		return false, 1500 * time.Millisecond, nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+func TestSmoothingThrottlesBursts(t *testing.T) {
+	guard := ratelimit.NewSmoothingGuard()
+	guard.Set(&ratelimit.SmoothingPolicy{Default: ratelimit.SmoothingRate{Burst: 2, RatePerSecond: 1}})
+	bucket := &fakeBucket{tokens: 2}
+	calls := 0
+	h := Smoothing(guard, bucket, &fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("X-Tenant-ID", "acme")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve()
+	serve()
+	rec := serve()
+	if calls != 2 || rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" || !strings.Contains(rec.Body.String(), "request_rate_exceeded") {
+		t.Fatalf("expected the third request throttled, got %d %q (Retry-After %q) after %d calls", rec.Code, rec.Body.String(), rec.Header().Get("Retry-After"), calls)
+	}
+
+	bucket.err = errors.New("redis down")
+	if rec := serve(); rec.Code != http.StatusOK || calls != 3 {
+		t.Fatalf("expected bucket errors to fail open, got %d", rec.Code)
+	}
+}
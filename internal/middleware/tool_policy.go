@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/toolpolicy"
+)
+
+// HeaderToolsStripped lists the tools removed from a request by the tool policy.
+const HeaderToolsStripped = "X-Sentinel-Tools-Stripped"
+
+// ToolPolicy enforces the tool allowlist on the tools a request declares:
+// tools the tenant may not use are removed, or the request is rejected with a
+// 403 listing them, depending on the policy.
+func ToolPolicy(guard *toolpolicy.Guard, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if guard == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := guard.Policy()
+			if policy == nil || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tenantID := r.Header.Get(headerName)
+			reject := policy.Rejects()
+			denied, changed := policy.Filter(provider.Name(), tenantID, data, !reject)
+			if len(denied) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			action := toolpolicy.ActionStrip
+			if reject {
+				action = toolpolicy.ActionReject
+			}
+			slog.InfoContext(r.Context(), "Tools not allowed",
+				"tenant_id", tenantID,
+				"provider", provider.Name(),
+				"tools", denied,
+				"action", action,
+			)
+			telemetry.IncToolsDenied(r.Context(), tenantID, provider.Name(), action)
+
+			if !reject {
+				if changed {
+					setJSONBody(r, data)
+				}
+				w.Header().Set(HeaderToolsStripped, strings.Join(denied, ","))
+				Decide(r.Context(), StageToolPolicy, "stripped", strings.Join(denied, ","))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Tools not allowed by policy: " + strings.Join(denied, ", "),
+					"type":    "permission_error",
+					"code":    "tool_not_allowed",
+					"tools":   denied,
+				},
+			})
+		})
+	}
+}
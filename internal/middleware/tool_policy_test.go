@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/toolpolicy"
+)
+
+func TestToolPolicyStripsAndRejects(t *testing.T) {
+	guard := toolpolicy.NewGuard()
+	guard.Set(&toolpolicy.Policy{Allow: []string{"*"}, Block: []string{"code_interpreter"}})
+	var forwarded map[string]any
+	handler := ToolPolicy(guard, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &forwarded)
+	}))
+	body := `{"model": "gpt-4o", "tools": [{"type": "code_interpreter"}, {"type": "function", "function": {"name": "lookup"}}]}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body)))
+	if rr.Header().Get(HeaderToolsStripped) != "code_interpreter" || len(forwarded["tools"].([]any)) != 1 {
+		t.Fatalf("expected code_interpreter stripped, got %v (%q)", forwarded, rr.Header().Get(HeaderToolsStripped))
+	}
+
+	guard.Set(&toolpolicy.Policy{Allow: []string{"*"}, Block: []string{"code_interpreter"}, Action: toolpolicy.ActionReject})
+	forwarded = nil
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(body)))
+	if rr.Code != http.StatusForbidden || forwarded != nil || !strings.Contains(rr.Body.String(), `"tool_not_allowed"`) {
+		t.Fatalf("expected a 403 policy error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
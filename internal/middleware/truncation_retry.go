@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+)
+
+// HeaderTruncationRetry reports the output token cap a truncated response was
+// transparently retried with.
+const HeaderTruncationRetry = "X-Sentinel-Truncation-Retry"
+
+// ContextKeyTruncationRetry marks the retry of a truncated request, which loop
+// detection and mirroring skip: it repeats a request they already handled.
+const ContextKeyTruncationRetry ContextKey = "truncation_retry"
+
+// isRetry reports whether the request repeats one already handled, after a
+// truncated response or a JSON violation.
+func isRetry(ctx context.Context) bool {
+	truncation, _ := ctx.Value(ContextKeyTruncationRetry).(bool)
+	jsonRetry, _ := ctx.Value(ContextKeyJSONRetry).(bool)
+	return truncation || jsonRetry
+}
+
+// TruncationRetry retries, once and with a larger output token cap, tenant
+// requests whose non-streaming response finished with reason "length". It runs
+// before the output token policy and rate limiting, so the retried cap is still
+// clamped to the policy maximum and the retry is admitted only while the tenant
+// has budget; otherwise the truncated response is returned. Both calls are
+// charged.
+func TruncationRetry(guard *ratelimit.TruncationRetryGuard, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		extractor, ok := provider.(providers.FinishReasonExtractor)
+		if guard == nil || !ok {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID := r.Header.Get(headerName)
+			policy := guard.Policy()
+			if r.Method != http.MethodPost || !policy.Enabled(tenantID) || tenantID == "" ||
+				!isGenerationPath(r.URL.Path) || strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if stream, _ := data["stream"].(bool); stream {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Let the transport negotiate and decode compression so the
+			// buffered response can be inspected.
+			r.Header.Del("Accept-Encoding")
+
+			// The body's buffer returns to the pool once the first attempt
+			// is sent, so the retry rewrites a copy.
+			orig := bytes.Clone(body)
+			first := newBufferedResponse()
+			next.ServeHTTP(first, r)
+
+			retryCap, ok := truncatedRetryCap(first, extractor, provider, policy, data)
+			if !ok {
+				first.writeTo(w)
+				return
+			}
+
+			ratelimit.SetMaxOutputTokens(data, provider.Name(), retryCap)
+			retryReq := r.Clone(context.WithValue(r.Context(), ContextKeyTruncationRetry, true))
+			rewriteJSONBody(retryReq, orig, data)
+			retry := newBufferedResponse()
+			next.ServeHTTP(retry, retryReq)
+
+			outcome := "retried"
+			if retry.status >= http.StatusBadRequest {
+				// Denied (e.g. out of budget) or failed: keep the truncated answer.
+				outcome = "rejected"
+				if retry.status == http.StatusTooManyRequests {
+					outcome = "denied"
+				}
+				first.writeTo(w)
+			} else {
+				retry.header.Set(HeaderTruncationRetry, strconv.Itoa(retryCap))
+				retry.writeTo(w)
+			}
+			telemetry.IncTruncationRetry(r.Context(), provider.Name(), tenantID, outcome)
+			slog.InfoContext(r.Context(), "Retried truncated response",
+				"tenant_id", tenantID,
+				"max_output_tokens", retryCap,
+				"outcome", outcome,
+				"status", retry.status,
+			)
+		})
+	}
+}
+
+// truncatedRetryCap returns the cap to retry with when resp is a successful
+// JSON response that stopped at its output token cap.
+func truncatedRetryCap(resp *bufferedResponse, extractor providers.FinishReasonExtractor, provider providers.Provider, policy ratelimit.TruncationRetryPolicy, request map[string]any) (int, bool) {
+	if resp.status != http.StatusOK || resp.header.Get("Content-Encoding") != "" {
+		return 0, false
+	}
+	var data map[string]any
+	if err := json.Unmarshal(resp.body.Bytes(), &data); err != nil {
+		return 0, false
+	}
+	truncated := false
+	for _, reason := range extractor.ExtractFinishReasons(data) {
+		if providers.NormalizeFinishReason(reason) == providers.FinishLength {
+			truncated = true
+		}
+	}
+	if !truncated {
+		return 0, false
+	}
+	// Without a cap in the request (e.g. one injected by the output token
+	// policy), the output actually produced is the cap that was hit.
+	current := ratelimit.ExtractMaxOutputTokens(request)
+	if usage := provider.ParseTokenUsage(data); usage.Found && usage.OutputTokens > current {
+		current = usage.OutputTokens
+	}
+	return policy.RetryCap(current)
+}
+
+// bufferedResponse holds a response until the retry decision is made.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo sends the buffered response to w.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(b.body.Len()))
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/ratelimit"
+)
+
+func TestTruncationRetryRetriesWithLargerCap(t *testing.T) {
+	guard := ratelimit.NewTruncationRetryGuard()
+	guard.Set(&ratelimit.TruncationRetryPolicy{Max: 300})
+	prov, _ := openai.New("k")
+
+	var caps []any
+	var retryMarked bool
+	h := TruncationRetry(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+		caps = append(caps, body["max_tokens"])
+		retryMarked = isRetry(r.Context())
+		reason := "length"
+		if len(caps) > 1 {
+			reason = "stop"
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"finish_reason":"` + reason + `"}],"usage":{"prompt_tokens":5,"completion_tokens":100}}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[],"max_tokens":100}`))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if len(caps) != 2 || caps[1] != float64(200) || !retryMarked {
+		t.Fatalf("expected one retry with max_tokens 200, got caps %v (retry marked %v)", caps, retryMarked)
+	}
+	if rec.Header().Get(HeaderTruncationRetry) != "200" || !strings.Contains(rec.Body.String(), `"stop"`) {
+		t.Fatalf("expected the retried response, got %q (headers %v)", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestTruncationRetryBodyOutlivesPooledBuffer(t *testing.T) {
+	guard := ratelimit.NewTruncationRetryGuard()
+	guard.Set(&ratelimit.TruncationRetryPolicy{Max: 300})
+	prov, _ := openai.New("k")
+
+	// Other requests keep taking buffers from the pool while the retry is
+	// built, as concurrent tenants do in the proxy.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	reuse := func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			other := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"other tenant"}]}`))
+			_, _ = readBody(other)
+			other.Body.Close()
+		}
+	}
+
+	var retryBody string
+	calls := 0
+	h := TruncationRetry(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		raw, _ := io.ReadAll(r.Body)
+		// Closing returns the buffer to the pool, as the transport does once
+		// the request is sent.
+		r.Body.Close()
+		if calls == 1 {
+			for range 4 {
+				wg.Add(1)
+				go reuse()
+			}
+			_, _ = w.Write([]byte(`{"choices":[{"finish_reason":"length"}],"usage":{"completion_tokens":100}}`))
+			return
+		}
+		retryBody = string(raw)
+		_, _ = w.Write([]byte(`{"choices":[{"finish_reason":"stop"}],"usage":{"completion_tokens":150}}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"acme only"}],"max_tokens":100}`))
+	req.Header.Set("X-Tenant-ID", "acme")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	close(stop)
+	wg.Wait()
+
+	want := `{"model":"gpt-4o","messages":[{"role":"user","content":"acme only"}],"max_tokens":200}`
+	if calls != 2 || retryBody != want {
+		t.Fatalf("retry body = %s after %d calls, want %s", retryBody, calls, want)
+	}
+}
+
+func TestTruncationRetryKeepsTruncatedResponseWhenDenied(t *testing.T) {
+	guard := ratelimit.NewTruncationRetryGuard()
+	guard.Set(&ratelimit.TruncationRetryPolicy{Max: 4096, Tenants: []string{"acme"}})
+	prov, _ := openai.New("k")
+
+	calls := 0
+	h := TruncationRetry(guard, prov, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls > 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"choices":[{"finish_reason":"length"}],"usage":{"completion_tokens":512}}`))
+	}))
+	serve := func(tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("X-Tenant-ID", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("acme", `{"model":"gpt-4o","messages":[]}`)
+	if calls != 2 || rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"length"`) {
+		t.Fatalf("expected truncated response after a denied retry, got %d %q after %d calls", rec.Code, rec.Body.String(), calls)
+	}
+
+	calls = 0
+	serve("other", `{"model":"gpt-4o","messages":[]}`)
+	serve("acme", `{"model":"gpt-4o","messages":[],"stream":true}`)
+	if calls != 2 {
+		t.Fatalf("expected no retries for other tenants or streams, got %d calls", calls)
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/uploads"
+)
+
+// maxUploadOverhead allows for multipart boundaries and form fields on top of
+// the file itself when bounding an upload body by the policy's max_bytes.
+const maxUploadOverhead = 64 << 10
+
+// Uploads governs files uploaded to the provider: uploads over the size cap
+// get a 413, types outside the allowlist a 415 and uploads past the tenant's
+// storage quota a 403. With a scanner configured, content that passes through
+// the proxy is scanned first; rejected files get a 422. Every upload, allowed
+// or not, is logged and recorded as a file_upload event. Files created are
+// counted against the tenant's quota until deleted through the proxy.
+func Uploads(governor *uploads.Governor, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if governor == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kind := uploads.Classify(provider.Name(), r.Method, r.URL.Path, r.Header)
+			if kind == uploads.None {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tenantID := r.Header.Get(headerName)
+			if kind == uploads.Delete {
+				forgetDeletedUpload(w, r, next, governor, provider, tenantID)
+				return
+			}
+			ctx := r.Context()
+			policy := governor.Policy()
+			scanner := governor.Scanner()
+			enforced := policy.MaxBytes > 0 || len(policy.ContentTypes) > 0 || scanner != nil
+
+			if policy.MaxBytes > 0 && kind == uploads.Create {
+				limit := policy.MaxBytes + maxUploadOverhead
+				if r.ContentLength > limit {
+					rejectUpload(ctx, w, provider, tenantID, uploads.Request{Bytes: r.ContentLength}, http.StatusRequestEntityTooLarge, "too_large", "file_too_large",
+						fmt.Sprintf("File exceeds the upload size limit of %d bytes.", policy.MaxBytes))
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			body, err := readBody(r)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					rejectUpload(ctx, w, provider, tenantID, uploads.Request{Bytes: -1}, http.StatusRequestEntityTooLarge, "too_large", "file_too_large",
+						fmt.Sprintf("File exceeds the upload size limit of %d bytes.", policy.MaxBytes))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			req, err := uploads.Parse(provider.Name(), kind, r.URL.Path, r.Header, body)
+			if err != nil {
+				if enforced {
+					rejectUpload(ctx, w, provider, tenantID, req, http.StatusBadRequest, "invalid", "invalid_upload", "Upload could not be read: "+err.Error())
+					return
+				}
+				slog.WarnContext(ctx, "Failed to read upload, forwarding it", "error", err, "tenant_id", tenantID, "path", r.URL.Path)
+			}
+			if !checkUpload(ctx, w, governor, policy, provider, tenantID, kind, req) {
+				return
+			}
+
+			r.Header.Del("Accept-Encoding")
+			capture := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(capture, r)
+			if kind == uploads.Part {
+				return
+			}
+			ok := capture.status < http.StatusBadRequest && !capture.truncated
+			created, tracked := uploads.ParseCreated(provider.Name(), kind, req, capture.Header(), capture.body.Bytes(), time.Now())
+			auditUpload(ctx, provider, tenantID, req, ok, capture.status, created.ID, "")
+			if ok && (tracked || kind == uploads.Create) {
+				telemetry.RecordUpload(ctx, provider.Name(), tenantID, "allowed", created.Bytes)
+			}
+			if !ok || !tracked || tenantID == "" {
+				return
+			}
+			file := uploads.Upload{
+				ID:          created.ID,
+				Provider:    provider.Name(),
+				TenantID:    tenantID,
+				Filename:    req.Filename,
+				ContentType: req.ContentType,
+				Bytes:       created.Bytes,
+				Uploaded:    time.Now().UTC(),
+				Expires:     created.Expires,
+			}
+			async.Go(ctx, func() {
+				if err := governor.Track(telemetry.Detach(ctx), file); err != nil {
+					slog.Warn("Failed to track uploaded file", "error", err, "file_id", file.ID, "tenant_id", tenantID)
+				}
+			})
+		})
+	}
+}
+
+// checkUpload enforces the size cap, content types, storage quota and
+// scanner on an upload, answering the request and returning false when it
+// is rejected.
+func checkUpload(ctx context.Context, w http.ResponseWriter, governor *uploads.Governor, policy uploads.Policy, provider providers.Provider, tenantID string, kind uploads.Kind, req uploads.Request) bool {
+	declares := kind == uploads.Create || kind == uploads.Start
+	if declares && policy.MaxBytes > 0 && req.Bytes > policy.MaxBytes {
+		rejectUpload(ctx, w, provider, tenantID, req, http.StatusRequestEntityTooLarge, "too_large", "file_too_large",
+			fmt.Sprintf("File of %d bytes exceeds the upload size limit of %d bytes.", req.Bytes, policy.MaxBytes))
+		return false
+	}
+	if declares && !policy.AllowsType(req.ContentType) {
+		rejectUpload(ctx, w, provider, tenantID, req, http.StatusUnsupportedMediaType, "content_type", "unsupported_file_type",
+			fmt.Sprintf("Files of type %q may not be uploaded.", req.ContentType))
+		return false
+	}
+	if quota := policy.Quota(tenantID); declares && quota > 0 && tenantID != "" {
+		used, err := governor.Usage(ctx, tenantID)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "Upload quota check failed, failing open", "error", err, "tenant_id", tenantID)
+		case used+max(req.Bytes, 0) > quota:
+			rejectUpload(ctx, w, provider, tenantID, req, http.StatusForbidden, "quota", "storage_quota_exceeded",
+				fmt.Sprintf("Upload would exceed the storage quota: %d of %d bytes used. Delete files to free space.", used, quota))
+			return false
+		}
+	}
+
+	scanner := governor.Scanner()
+	// The parts of an OpenAI upload are scanned as they arrive.
+	if scanner == nil || kind == uploads.Complete || (kind == uploads.Start && provider.Name() != "gemini") {
+		return true
+	}
+	if req.Data == nil {
+		// The content of a Gemini resumable upload goes to the provider's
+		// upload URL, not through the proxy.
+		if governor.ScanFailOpen() {
+			return true
+		}
+		rejectUpload(ctx, w, provider, tenantID, req, http.StatusUnprocessableEntity, "scan_error", "file_not_scannable",
+			"Uploads are scanned and this upload's content does not pass through the proxy; upload the file in a single request.")
+		return false
+	}
+	verdict, err := scanner.Scan(ctx, uploads.File{
+		TenantID:    tenantID,
+		Provider:    provider.Name(),
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		Data:        req.Data,
+	})
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Upload scan failed", "error", err, "tenant_id", tenantID, "fail_open", governor.ScanFailOpen())
+		if governor.ScanFailOpen() {
+			return true
+		}
+		rejectUpload(ctx, w, provider, tenantID, req, http.StatusServiceUnavailable, "scan_error", "file_scan_unavailable",
+			"The upload could not be scanned. Try again later.")
+		return false
+	case !verdict.Allowed:
+		reason := verdict.Reason
+		if reason == "" {
+			reason = "rejected by scan"
+		}
+		rejectUpload(ctx, w, provider, tenantID, req, http.StatusUnprocessableEntity, "scan_rejected", "file_rejected",
+			"File rejected: "+reason)
+		return false
+	}
+	return true
+}
+
+// forgetDeletedUpload forwards a file deletion and stops counting the file
+// against the tenant's quota once the provider has deleted it.
+func forgetDeletedUpload(w http.ResponseWriter, r *http.Request, next http.Handler, governor *uploads.Governor, provider providers.Provider, tenantID string) {
+	rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rw, r)
+	if tenantID == "" || rw.status >= http.StatusBadRequest {
+		return
+	}
+	req, _ := uploads.Parse(provider.Name(), uploads.Delete, r.URL.Path, r.Header, nil)
+	ctx := telemetry.Detach(r.Context())
+	async.Go(ctx, func() {
+		if _, err := governor.Remove(ctx, tenantID, req.FileID); err != nil {
+			slog.Warn("Failed to forget deleted file", "error", err, "file_id", req.FileID, "tenant_id", tenantID)
+		}
+	})
+}
+
+// rejectUpload answers an upload the policy does not allow.
+func rejectUpload(ctx context.Context, w http.ResponseWriter, provider providers.Provider, tenantID string, req uploads.Request, status int, outcome, code, message string) {
+	telemetry.RecordUpload(ctx, provider.Name(), tenantID, outcome, 0)
+	auditUpload(ctx, provider, tenantID, req, false, status, "", code)
+	errType := "invalid_request_error"
+	if status == http.StatusForbidden {
+		errType = "permission_error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	})
+}
+
+// auditUpload logs an upload and records it in the event feed.
+func auditUpload(ctx context.Context, provider providers.Provider, tenantID string, req uploads.Request, allowed bool, status int, fileID, code string) {
+	slog.InfoContext(ctx, "File upload",
+		"tenant_id", tenantID,
+		"provider", provider.Name(),
+		"filename", req.Filename,
+		"content_type", req.ContentType,
+		"bytes", req.Bytes,
+		"allowed", allowed,
+		"status", status,
+		"file_id", fileID,
+		"code", code,
+	)
+	detail := map[string]any{
+		"provider":     provider.Name(),
+		"filename":     req.Filename,
+		"content_type": req.ContentType,
+		"bytes":        req.Bytes,
+		"allowed":      allowed,
+		"status":       status,
+	}
+	if fileID != "" {
+		detail["file_id"] = fileID
+	}
+	if code != "" {
+		detail["code"] = code
+	}
+	events.Record(events.TypeFileUpload, tenantID, detail)
+}
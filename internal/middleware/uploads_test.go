@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/uploads"
+)
+
+type fakeScanner struct {
+	verdict uploads.Verdict
+	err     error
+	scanned []string
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, file uploads.File) (uploads.Verdict, error) {
+	s.scanned = append(s.scanned, file.Filename)
+	return s.verdict, s.err
+}
+
+func fileUploadRequest(t *testing.T, filename, content string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("purpose", "assistants")
+	part, _ := mw.CreateFormFile("file", filename)
+	_, _ = part.Write([]byte(content))
+	_ = mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Tenant-ID", "t1")
+	return req
+}
+
+func TestUploads(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	provider, _ := openai.New("key")
+
+	cases := []struct {
+		name     string
+		policy   uploads.Policy
+		scanner  *fakeScanner
+		used     int64
+		filename string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "allowed", policy: uploads.Policy{MaxBytes: 100, ContentTypes: []string{"application/jsonl"}, QuotaBytes: 1000}, filename: "batch.jsonl", wantCode: http.StatusOK},
+		{name: "too large", policy: uploads.Policy{MaxBytes: 5}, filename: "batch.jsonl", wantCode: http.StatusRequestEntityTooLarge, wantErr: "file_too_large"},
+		{name: "content type", policy: uploads.Policy{ContentTypes: []string{"application/jsonl"}}, filename: "payload.exe", wantCode: http.StatusUnsupportedMediaType, wantErr: "unsupported_file_type"},
+		{name: "quota", policy: uploads.Policy{QuotaBytes: 1000}, used: 990, filename: "batch.jsonl", wantCode: http.StatusForbidden, wantErr: "storage_quota_exceeded"},
+		{name: "scan rejected", scanner: &fakeScanner{verdict: uploads.Verdict{Reason: "pii: email address"}}, filename: "batch.jsonl", wantCode: http.StatusUnprocessableEntity, wantErr: "pii: email address"},
+		{name: "scanner down", scanner: &fakeScanner{err: errors.New("timeout")}, filename: "batch.jsonl", wantCode: http.StatusServiceUnavailable, wantErr: "file_scan_unavailable"},
+		{name: "scan allowed", scanner: &fakeScanner{verdict: uploads.Verdict{Allowed: true}}, filename: "batch.jsonl", wantCode: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := uploads.NewMemoryStore()
+			_ = store.TrackUpload(context.Background(), uploads.Upload{ID: "file-old", TenantID: "t1", Bytes: tc.used})
+			var scanner uploads.Scanner
+			if tc.scanner != nil {
+				scanner = tc.scanner
+			}
+			governor := uploads.New(store, scanner)
+			governor.Set(&tc.policy)
+			before := len(events.Default().Recent(1000, events.TypeFileUpload))
+
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				_, _ = w.Write([]byte(`{"id":"file-new","object":"file","bytes":18,"filename":"batch.jsonl"}`))
+			})
+			rec := httptest.NewRecorder()
+			Uploads(governor, provider, "X-Tenant-ID")(next).ServeHTTP(rec, fileUploadRequest(t, tc.filename, `{"custom_id":"1"}`+"\n"))
+
+			if rec.Code != tc.wantCode || !strings.Contains(rec.Body.String(), tc.wantErr) {
+				t.Fatalf("got %d %s, want %d with %q", rec.Code, rec.Body, tc.wantCode, tc.wantErr)
+			}
+			if called != (tc.wantCode == http.StatusOK) {
+				t.Fatalf("upstream called = %v", called)
+			}
+			if tc.scanner != nil && (len(tc.scanner.scanned) != 1 || tc.scanner.scanned[0] != tc.filename) {
+				t.Fatalf("scanned %v, want %s", tc.scanner.scanned, tc.filename)
+			}
+			recorded := events.Default().Recent(1000, events.TypeFileUpload)
+			if len(recorded)-before != 1 || recorded[0].Detail["allowed"] != called {
+				t.Fatalf("expected the upload audited, got %+v", recorded)
+			}
+			used, _ := governor.Usage(context.Background(), "t1")
+			if want := tc.used + map[bool]int64{true: 18}[called]; used != want {
+				t.Fatalf("usage = %d, want %d", used, want)
+			}
+		})
+	}
+}
+
+func TestUploadsForgetsDeletedFiles(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	provider, _ := openai.New("key")
+	store := uploads.NewMemoryStore()
+	_ = store.TrackUpload(context.Background(), uploads.Upload{ID: "file-abc", TenantID: "t1", Bytes: 500})
+	governor := uploads.New(store, nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"file-abc","deleted":true}`))
+	})
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/files/file-abc", nil)
+	req.Header.Set("X-Tenant-ID", "t1")
+	Uploads(governor, provider, "X-Tenant-ID")(next).ServeHTTP(httptest.NewRecorder(), req)
+	if used, _ := governor.Usage(context.Background(), "t1"); used != 0 {
+		t.Fatalf("usage = %d, want the deleted file freed", used)
+	}
+}
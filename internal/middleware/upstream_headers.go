@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/upstream"
+)
+
+// UpstreamHeaders adds the headers configured for the request's tenant and
+// provider (e.g. OpenAI-Organization) before it is forwarded, so provider-side
+// usage attribution matches sentinel tenants. Configured headers replace any
+// the client sent.
+func UpstreamHeaders(headers *upstream.Headers, provider providers.Provider, headerName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if headers == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers.Apply(r.Header, upstream.HeaderData{
+				Tenant:   r.Header.Get(headerName),
+				Session:  r.Header.Get(HeaderSession),
+				Provider: provider.Name(),
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
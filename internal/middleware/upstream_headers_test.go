@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/upstream"
+)
+
+func TestUpstreamHeadersInjectsTenantHeaders(t *testing.T) {
+	headers := upstream.NewHeaders()
+	headers.Set(&upstream.HeaderPolicy{
+		Providers: map[string]map[string]string{"fake": {"X-Provider-User": "{{.Tenant}}:{{.Session}}"}},
+	})
+	var got string
+	handler := UpstreamHeaders(headers, fakeProvider{}, "X-Tenant-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Provider-User")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set(HeaderSession, "run-7")
+	req.Header.Set("X-Provider-User", "spoofed")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "acme:run-7" {
+		t.Fatalf("expected the configured header to replace the client's, got %q", got)
+	}
+}
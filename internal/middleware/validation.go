@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/validation"
+)
+
+// Validation rejects malformed request bodies with a 400 listing each invalid
+// field, before they reach the provider or are charged an estimate. With models,
+// requests are also checked against the requested model's output token maximum
+// and input modalities.
+func Validation(validator *validation.Validator, provider providers.Provider, models *ratelimit.ModelCatalog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if validator == nil || provider == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := validator.Policy()
+			if !policy.Enabled || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := readBody(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var errs []validation.FieldError
+			var data map[string]any
+			if err := json.Unmarshal(body, &data); err != nil {
+				// Uploads and other non-JSON bodies only fail on endpoints with a schema.
+				if validation.HasSchema(provider.Name(), r.URL.Path) {
+					errs = []validation.FieldError{{Message: "request body must be a JSON object"}}
+				}
+			} else {
+				errs = validation.Validate(provider.Name(), r.URL.Path, data, policy)
+			}
+			model := provider.ExtractModelFromPath(r.URL.Path)
+			if model == "" {
+				model, _ = data["model"].(string)
+			}
+			if info, ok := models.Lookup(provider.Name(), model); ok && data != nil {
+				errs = append(errs, validation.ValidateModel(data, validation.Model{MaxOutput: info.MaxOutput, Modalities: info.Modalities})...)
+			}
+			if len(errs) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			slog.InfoContext(r.Context(), "Rejected invalid request",
+				"provider", provider.Name(),
+				"model", model,
+				"path", r.URL.Path,
+				"error", errs[0].Error(),
+				"errors", len(errs),
+			)
+			telemetry.IncInvalidRequest(r.Context(), provider.Name(), model)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"error": map[string]any{
+					"message": "Invalid request: " + errs[0].Error(),
+					"type":    "invalid_request_error",
+					"code":    "invalid_request",
+					"fields":  errs,
+				},
+			})
+		})
+	}
+}
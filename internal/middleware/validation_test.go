@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/validation"
+)
+
+func TestValidationRejectsInvalidRequests(t *testing.T) {
+	validator := validation.NewValidator()
+	validator.Set(&validation.Policy{Enabled: true})
+
+	called := 0
+	h := Validation(validator, fakeProvider{}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+	send := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := send("/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"top_p":5}`)
+	if rec.Code != http.StatusBadRequest || called != 0 {
+		t.Fatalf("expected 400 without forwarding, got %d (calls %d)", rec.Code, called)
+	}
+	var resp struct {
+		Error struct {
+			Code   string                  `json:"code"`
+			Fields []validation.FieldError `json:"fields"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Error.Code != "invalid_request" || len(resp.Error.Fields) != 1 || resp.Error.Fields[0].Field != "top_p" {
+		t.Fatalf("unexpected error body %+v", resp)
+	}
+
+	if rec := send("/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK || called != 1 {
+		t.Fatalf("expected valid request forwarded, got %d", rec.Code)
+	}
+	if rec := send("/v1/files", `--multipart--`); rec.Code != http.StatusOK || called != 2 {
+		t.Fatalf("expected non-JSON upload forwarded, got %d", rec.Code)
+	}
+
+	validator.Set(&validation.Policy{})
+	if rec := send("/v1/chat/completions", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected pass-through when disabled, got %d", rec.Code)
+	}
+}
+
+func TestValidationChecksModelLimits(t *testing.T) {
+	validator := validation.NewValidator()
+	validator.Set(&validation.Policy{Enabled: true})
+	models := ratelimit.NewModelCatalog()
+	models.Set(ratelimit.ProviderModels{"fake": {"small": {MaxOutput: 1000, Modalities: []string{ratelimit.ModalityText}}}})
+
+	h := Validation(validator, fakeProvider{}, models)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	send := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := send(`{"model":"small","max_tokens":4000,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "maximum output of 1000") {
+		t.Fatalf("expected the oversized cap rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(`{"model":"small","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected image input rejected, got %d", rec.Code)
+	}
+	if rec := send(`{"model":"other","max_tokens":4000,"messages":[{"role":"user","content":"hi"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected unknown models unchecked, got %d", rec.Code)
+	}
+}
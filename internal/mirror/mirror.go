@@ -0,0 +1,201 @@
+// Package mirror sends a sampled copy of proxied requests to a shadow backend,
+// e.g. a cheaper model or a test environment, and discards the responses.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/jsonedit"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+)
+
+const (
+	defaultSamplePercent = 10
+	defaultMaxInFlight   = 100
+	defaultTimeout       = 60 * time.Second
+)
+
+// HeaderMirrored marks mirrored requests so the shadow backend can tell them apart.
+const HeaderMirrored = "X-Sentinel-Mirror"
+
+// hopHeaders are connection-scoped and must not be copied to the mirrored request.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// Config configures a Mirror.
+type Config struct {
+	// Target is the shadow backend's base URL; the request path and query are kept.
+	Target *url.URL
+	// Percent of requests to mirror, 0-100.
+	Percent float64
+	// Model, when set, replaces the requested model in the mirrored request.
+	Model string
+	// Prepare sets credentials on the mirrored request. When nil, the client's own
+	// headers are forwarded and the proxy's API key is never sent.
+	Prepare func(*http.Request)
+	// Provider extracts the model from the path (Gemini puts it there).
+	Provider    providers.Provider
+	Transport   http.RoundTripper
+	Timeout     time.Duration
+	MaxInFlight int
+}
+
+// Mirror asynchronously replays sampled requests. Safe for concurrent use.
+type Mirror struct {
+	cfg    Config
+	client *http.Client
+	slots  chan struct{}
+	sample func() float64
+}
+
+// New returns a Mirror for cfg.
+func New(cfg Config) *Mirror {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = defaultMaxInFlight
+	}
+	return &Mirror{
+		cfg:    cfg,
+		client: &http.Client{Transport: cfg.Transport, Timeout: cfg.Timeout},
+		slots:  make(chan struct{}, cfg.MaxInFlight),
+		sample: func() float64 { return rand.Float64() * 100 },
+	}
+}
+
+// ConfigFromEnv reads MIRROR_URL (enables mirroring), MIRROR_SAMPLE_PERCENT
+// (default 10), MIRROR_MODEL, MIRROR_TIMEOUT_SECONDS (default 60) and
+// MIRROR_MAX_IN_FLIGHT (default 100). ok is false when MIRROR_URL is unset.
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	raw := os.Getenv("MIRROR_URL")
+	if raw == "" {
+		return cfg, false, nil
+	}
+	target, err := url.Parse(raw)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return cfg, false, fmt.Errorf("invalid MIRROR_URL %q", raw)
+	}
+	cfg.Target = target
+	cfg.Percent = defaultSamplePercent
+	if v := os.Getenv("MIRROR_SAMPLE_PERCENT"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed >= 0 && parsed <= 100 {
+			cfg.Percent = parsed
+		}
+	}
+	cfg.Model = os.Getenv("MIRROR_MODEL")
+	if v := os.Getenv("MIRROR_TIMEOUT_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			cfg.Timeout = time.Duration(parsed) * time.Second
+		}
+	}
+	if v := os.Getenv("MIRROR_MAX_IN_FLIGHT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			cfg.MaxInFlight = parsed
+		}
+	}
+	return cfg, true, nil
+}
+
+// Sampled reports whether the current request should be mirrored.
+func (m *Mirror) Sampled() bool {
+	return m != nil && m.cfg.Percent > 0 && m.sample() < m.cfg.Percent
+}
+
+// Send mirrors r (with the already-read body) in the background. It never blocks:
+// when MaxInFlight mirrored requests are outstanding the copy is dropped.
+func (m *Mirror) Send(r *http.Request, body []byte) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		telemetry.IncMirrorRequest(context.Background(), "dropped", 0)
+		return
+	}
+
+	req, err := m.build(r, body)
+	if err != nil {
+		<-m.slots
+		slog.Debug("Mirror request not built", "error", err)
+		telemetry.IncMirrorRequest(context.Background(), "error", 0)
+		return
+	}
+	go func() {
+		defer func() { <-m.slots }()
+		m.do(req)
+	}()
+}
+
+func (m *Mirror) do(req *http.Request) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		slog.Debug("Mirror request failed", "error", err, "path", req.URL.Path)
+		telemetry.IncMirrorRequest(req.Context(), "error", 0)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	telemetry.IncMirrorRequest(req.Context(), "sent", resp.StatusCode)
+}
+
+// build copies r onto the target, detached from the client's context so the
+// mirror outlives the original request.
+func (m *Mirror) build(r *http.Request, body []byte) (*http.Request, error) {
+	path := r.URL.Path
+	if m.cfg.Model != "" {
+		body = replaceModel(body, m.cfg.Model)
+		if m.cfg.Provider != nil {
+			if model := m.cfg.Provider.ExtractModelFromPath(path); model != "" {
+				path = strings.Replace(path, "/models/"+model, "/models/"+m.cfg.Model, 1)
+			}
+		}
+	}
+
+	target := *m.cfg.Target
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(context.Background(), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set(HeaderMirrored, "1")
+	if m.cfg.Prepare != nil {
+		m.cfg.Prepare(req)
+	}
+	// Prepare may point Host at the primary provider; the mirror goes to Target.
+	req.Host = m.cfg.Target.Host
+	return req, nil
+}
+
+// replaceModel swaps the top-level "model" field, leaving other bodies untouched.
+func replaceModel(body []byte, model string) []byte {
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return body
+	}
+	if _, ok := data["model"]; !ok {
+		return body
+	}
+	data["model"] = model
+	out, err := jsonedit.Rewrite(body, data)
+	if err != nil {
+		return body
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package mirror
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendRewritesModelAndCredentials(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	bodies := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		got <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL + "/shadow")
+
+	m := New(Config{
+		Target:  target,
+		Percent: 100,
+		Model:   "gpt-4o-mini",
+		Prepare: func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer mirror-key")
+			r.Host = "api.openai.com"
+		},
+	})
+	orig := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?stream=false", nil)
+	orig.Header.Set("X-Tenant-ID", "acme")
+	m.Send(orig, []byte(`{"model":"gpt-4o","messages":[]}`))
+
+	select {
+	case r := <-got:
+		if r.URL.Path != "/shadow/v1/chat/completions" || r.URL.RawQuery != "stream=false" {
+			t.Fatalf("unexpected target %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer mirror-key" || r.Header.Get(HeaderMirrored) != "1" || r.Header.Get("X-Tenant-ID") != "acme" {
+			t.Fatalf("unexpected headers %v", r.Header)
+		}
+		if !strings.HasPrefix(r.Host, "127.0.0.1") {
+			t.Fatalf("expected Host to be the mirror target, got %s", r.Host)
+		}
+		if body := <-bodies; body["model"] != "gpt-4o-mini" {
+			t.Fatalf("expected model rewrite, got %v", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("mirror request not received")
+	}
+}
+
+func TestSendDropsWhenSaturated(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	target, _ := url.Parse(srv.URL)
+
+	m := New(Config{Target: target, Percent: 100, MaxInFlight: 1})
+	orig := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	m.Send(orig, []byte(`{}`))
+	m.Send(orig, []byte(`{}`))
+	if len(m.slots) != 1 {
+		t.Fatalf("expected one mirrored request in flight, got %d", len(m.slots))
+	}
+}
+
+func TestSampled(t *testing.T) {
+	m := New(Config{Percent: 25})
+	m.sample = func() float64 { return 24.9 }
+	if !m.Sampled() {
+		t.Fatal("expected sample below percent to be mirrored")
+	}
+	m.sample = func() float64 { return 25 }
+	if m.Sampled() {
+		t.Fatal("expected sample at percent to be skipped")
+	}
+	var nilMirror *Mirror
+	if nilMirror.Sampled() {
+		t.Fatal("expected nil mirror to never sample")
+	}
+}
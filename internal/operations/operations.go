@@ -0,0 +1,140 @@
+// Package operations governs provider requests other than POSTs: listing,
+// reading and deleting files, batches and fine-tuning jobs, cancelling runs,
+// and so on. These carry no tokens, so spend limits never see them, yet some
+// (deleting a file another agent relies on, cancelling a fine-tune) matter
+// more than any single completion.
+package operations
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// Policy decides which non-POST operations tenants may perform. Entries are a
+// method and a path, e.g. "DELETE /v1/files/*" or "* /v1/fine_tuning/*". The
+// method is matched exactly, or "*" matches any method. The path is matched
+// exactly, or by prefix with a trailing "*". Block wins over Allow and Tenants.
+type Policy struct {
+	// Allow lists the operations every tenant may perform.
+	Allow []string `json:"allow,omitempty"`
+	// Block lists operations no tenant may perform.
+	Block []string `json:"block,omitempty"`
+	// Tenants lists operations allowed for specific tenants on top of Allow.
+	Tenants map[string][]string `json:"tenants,omitempty"`
+}
+
+// Validate checks an operations policy.
+func (p Policy) Validate() error {
+	lists := [][]string{p.Allow, p.Block}
+	for _, entries := range p.Tenants {
+		lists = append(lists, entries)
+	}
+	for _, entries := range lists {
+		for _, entry := range entries {
+			if _, _, err := parseEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether tenantID may send a method request to path.
+func (p Policy) Allows(tenantID, method, path string) bool {
+	if match(p.Block, method, path) {
+		return false
+	}
+	return match(p.Allow, method, path) || match(p.Tenants[tenantID], method, path)
+}
+
+func parseEntry(entry string) (method, path string, err error) {
+	method, path, ok := strings.Cut(strings.TrimSpace(entry), " ")
+	path = strings.TrimSpace(path)
+	if !ok || method == "" || path == "" {
+		return "", "", fmt.Errorf("operations entry %q (want \"METHOD /path\")", entry)
+	}
+	if method != "*" && method != strings.ToUpper(method) {
+		return "", "", fmt.Errorf("operations entry %q: method must be upper case", entry)
+	}
+	return method, path, nil
+}
+
+func match(entries []string, method, path string) bool {
+	for _, entry := range entries {
+		m, pattern, err := parseEntry(entry)
+		if err != nil || (m != "*" && m != method) {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// policyFromEnv reads OPERATIONS_ALLOW and OPERATIONS_BLOCK (comma-separated).
+// It returns nil when neither is set or either is invalid.
+func policyFromEnv() *Policy {
+	p := &Policy{
+		Allow: splitList(os.Getenv("OPERATIONS_ALLOW")),
+		Block: splitList(os.Getenv("OPERATIONS_BLOCK")),
+	}
+	if len(p.Allow) == 0 && len(p.Block) == 0 {
+		return nil
+	}
+	if p.Validate() != nil {
+		return nil
+	}
+	return p
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Guard holds the active operations policy. Safe for concurrent use.
+type Guard struct {
+	active override.Value[Policy]
+}
+
+// NewGuard returns a guard using the OPERATIONS_* variables.
+func NewGuard() *Guard {
+	return &Guard{active: override.New(policyFromEnv())}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *Guard) Set(policy *Policy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy, or nil when operations are unrestricted.
+func (g *Guard) Policy() *Policy {
+	if g == nil {
+		return nil
+	}
+	return g.active.Active()
+}
+
+// Mutating reports whether method changes provider state, so the operation
+// is recorded in the admin event log and not only logged.
+func Mutating(method string) bool {
+	return slices.Contains([]string{"DELETE", "PUT", "PATCH"}, method)
+}
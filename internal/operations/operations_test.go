@@ -0,0 +1,54 @@
+package operations
+
+import "testing"
+
+func TestPolicyAllows(t *testing.T) {
+	p := Policy{
+		Allow:   []string{"GET *", "DELETE /v1/files/file-scratch*"},
+		Block:   []string{"* /v1/fine_tuning/*"},
+		Tenants: map[string][]string{"ml-platform": {"DELETE /v1/files/*"}},
+	}
+	cases := []struct {
+		tenant, method, path string
+		want                 bool
+	}{
+		{"acme", "GET", "/v1/files", true},
+		{"acme", "DELETE", "/v1/files/file-abc", false},
+		{"acme", "DELETE", "/v1/files/file-scratch-1", true},
+		{"ml-platform", "DELETE", "/v1/files/file-abc", true},
+		{"ml-platform", "GET", "/v1/fine_tuning/jobs", false},
+		{"acme", "PATCH", "/v1/files/file-abc", false},
+	}
+	for _, c := range cases {
+		if got := p.Allows(c.tenant, c.method, c.path); got != c.want {
+			t.Errorf("Allows(%q, %q, %q) = %v, want %v", c.tenant, c.method, c.path, got, c.want)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for _, entry := range []string{"", "/v1/files", "delete /v1/files/*", "GET "} {
+		if err := (Policy{Allow: []string{entry}}).Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", entry)
+		}
+	}
+	if err := (Policy{Block: []string{"* /v1/batches*"}}).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGuardSetOverridesEnv(t *testing.T) {
+	t.Setenv("OPERATIONS_BLOCK", "DELETE /v1/files/*")
+	g := NewGuard()
+	if p := g.Policy(); p == nil || p.Allows("acme", "DELETE", "/v1/files/f") {
+		t.Fatalf("expected the env policy to block deletes, got %+v", p)
+	}
+	g.Set(&Policy{Allow: []string{"* *"}})
+	if !g.Policy().Allows("acme", "DELETE", "/v1/files/f") {
+		t.Fatal("expected the file policy to replace the env policy")
+	}
+	g.Set(nil)
+	if g.Policy().Allows("acme", "DELETE", "/v1/files/f") {
+		t.Fatal("expected nil to restore the env policy")
+	}
+}
@@ -0,0 +1,50 @@
+// Package override holds policies read from the environment that the config
+// directory can replace while the proxy runs. Guards keep a Value so a reload
+// swaps their policy and removing it from the files restores the environment.
+package override
+
+import "sync"
+
+// Value holds a policy from the environment and, while one is set, the
+// file-configured policy replacing it. The zero Value holds no policy. Safe for
+// concurrent use.
+type Value[T any] struct {
+	mu       sync.RWMutex
+	env      *T
+	override *T
+}
+
+// New returns a value holding env, which may be nil when the environment sets
+// no policy.
+func New[T any](env *T) Value[T] {
+	return Value[T]{env: env}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (v *Value[T]) Set(policy *T) {
+	v.mu.Lock()
+	v.override = policy
+	v.mu.Unlock()
+}
+
+// Active returns the file-configured policy when one is set, otherwise the
+// environment policy, which may be nil.
+func (v *Value[T]) Active() *T {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.override != nil {
+		return v.override
+	}
+	return v.env
+}
+
+// Get returns a copy of the active policy, or the zero policy when there is
+// none.
+func (v *Value[T]) Get() T {
+	if p := v.Active(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,29 @@
+package override
+
+import "testing"
+
+type policy struct {
+	Limit int
+}
+
+func TestSetAndRestore(t *testing.T) {
+	v := New(&policy{Limit: 5})
+	if got := v.Get(); got.Limit != 5 {
+		t.Fatalf("expected the environment policy, got %+v", got)
+	}
+	v.Set(&policy{Limit: 10})
+	if got := v.Get(); got.Limit != 10 {
+		t.Fatalf("expected the file policy, got %+v", got)
+	}
+	v.Set(nil)
+	if got := v.Get(); got.Limit != 5 {
+		t.Fatalf("expected the environment policy restored, got %+v", got)
+	}
+}
+
+func TestWithoutPolicy(t *testing.T) {
+	var v Value[policy]
+	if v.Active() != nil || v.Get().Limit != 0 {
+		t.Fatalf("expected no policy, got %+v", v.Active())
+	}
+}
@@ -0,0 +1,359 @@
+// Package probe sends small synthetic requests to the provider on a schedule (a
+// model list call, or a one-token canary completion) and tracks the outcome, so
+// an outage or a revoked key is noticed before user traffic fails.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/upstream"
+)
+
+// Health states of a probed key.
+const (
+	// StateUnknown has not been probed yet.
+	StateUnknown = "unknown"
+	StateHealthy = "healthy"
+	// StateDegraded has failed recent probes, but fewer than the threshold.
+	StateDegraded = "degraded"
+	// StateDown has failed FailureThreshold probes in a row.
+	StateDown = "down"
+)
+
+// Probe kinds.
+const (
+	KindModels = "models"
+	KindCanary = "canary"
+)
+
+const (
+	defaultInterval         = 30 * time.Second
+	defaultTimeout          = 10 * time.Second
+	defaultFailureThreshold = 3
+	// maxErrorBody bounds the provider error kept in Health.LastError.
+	maxErrorBody = 256
+)
+
+// ModelsPaths are cheap authenticated GET endpoints listing each provider's models.
+var ModelsPaths = map[string]string{
+	"openai":    "/v1/models",
+	"anthropic": "/v1/models",
+	"gemini":    "/v1beta/models",
+}
+
+// Config configures a Prober.
+type Config struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// CanaryModel, when set, sends a one-token completion to this model instead
+	// of listing models. It exercises inference, not just authentication, at a
+	// small cost per probe that is not charged to any tenant.
+	CanaryModel string
+	// FailureThreshold is how many consecutive failed probes mark a key down.
+	FailureThreshold int
+	// FailFast rejects requests while the proxied key is down.
+	FailFast bool
+}
+
+// ConfigFromEnv reads PROBE_INTERVAL_SECONDS (enables probing; default 30 when
+// PROBE_ENABLED=true), PROBE_TIMEOUT_SECONDS (default 10), PROBE_CANARY_MODEL,
+// PROBE_FAILURE_THRESHOLD (default 3) and PROBE_FAIL_FAST. ok is false when
+// probing is not enabled.
+func ConfigFromEnv() (cfg Config, ok bool) {
+	enabled, _ := strconv.ParseBool(os.Getenv("PROBE_ENABLED"))
+	cfg.Interval = defaultInterval
+	if v, err := strconv.Atoi(os.Getenv("PROBE_INTERVAL_SECONDS")); err == nil && v > 0 {
+		cfg.Interval = time.Duration(v) * time.Second
+		enabled = true
+	}
+	cfg.Timeout = defaultTimeout
+	if v, err := strconv.Atoi(os.Getenv("PROBE_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		cfg.Timeout = time.Duration(v) * time.Second
+	}
+	cfg.CanaryModel = os.Getenv("PROBE_CANARY_MODEL")
+	cfg.FailureThreshold = defaultFailureThreshold
+	if v, err := strconv.Atoi(os.Getenv("PROBE_FAILURE_THRESHOLD")); err == nil && v > 0 {
+		cfg.FailureThreshold = v
+	}
+	cfg.FailFast, _ = strconv.ParseBool(os.Getenv("PROBE_FAIL_FAST"))
+	return cfg, enabled
+}
+
+// Target is one provider key to probe.
+type Target struct {
+	// Key names the credential, e.g. OPENAI_API_KEY.
+	Key       string
+	Provider  providers.Provider
+	Transport http.RoundTripper
+	// Backoff, when set, observes every probe response, so rate limits seen by
+	// probes open the provider circuit breaker like those of user traffic.
+	Backoff *upstream.Backoff
+}
+
+// Health is the probed state of one key.
+type Health struct {
+	Provider            string     `json:"provider"`
+	Key                 string     `json:"key"`
+	Probe               string     `json:"probe"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastStatus          int        `json:"last_status,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LatencyMs           float64    `json:"latency_ms"`
+}
+
+// Prober probes its targets every Interval. Safe for concurrent use; a nil
+// Prober reports nothing and never fails requests fast.
+type Prober struct {
+	cfg     Config
+	kind    string
+	targets []Target
+	now     func() time.Time
+
+	mu     sync.Mutex
+	health []Health
+}
+
+// New returns a prober for targets. The first target is the key the proxy
+// serves traffic with.
+func New(cfg Config, targets ...Target) *Prober {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	kind := KindModels
+	if cfg.CanaryModel != "" {
+		kind = KindCanary
+	}
+	p := &Prober{cfg: cfg, kind: kind, targets: targets, now: time.Now, health: make([]Health, len(targets))}
+	for i, t := range targets {
+		p.health[i] = Health{Provider: t.Provider.Name(), Key: t.Key, Probe: kind, State: StateUnknown}
+	}
+	return p
+}
+
+// Interval is the time between probes.
+func (p *Prober) Interval() time.Duration {
+	return p.cfg.Interval
+}
+
+// Run probes every target immediately and then every Interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		p.ProbeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeAll probes every target once, concurrently.
+func (p *Prober) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.probe(ctx, i)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Prober) probe(ctx context.Context, i int) {
+	target := p.targets[i]
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	start := p.now()
+	status, err := p.send(ctx, target)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// Shutting down; says nothing about the provider.
+		return
+	}
+	latency := p.now().Sub(start)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	telemetry.ObserveProbe(context.Background(), target.Provider.Name(), target.Key, p.kind, status, result, latency)
+
+	p.mu.Lock()
+	h := &p.health[i]
+	previous := h.State
+	h.LastCheck, h.LastStatus, h.LatencyMs = &start, status, float64(latency.Microseconds())/1000
+	if err == nil {
+		h.ConsecutiveFailures, h.LastError, h.LastSuccess = 0, "", &start
+		h.State = StateHealthy
+	} else {
+		h.ConsecutiveFailures++
+		h.LastError = err.Error()
+		h.State = StateDegraded
+		if h.ConsecutiveFailures >= p.cfg.FailureThreshold {
+			h.State = StateDown
+		}
+	}
+	current := *h
+	p.mu.Unlock()
+
+	if current.State == previous || (previous == StateUnknown && current.State == StateHealthy) {
+		return
+	}
+	attrs := []any{"provider", current.Provider, "key", current.Key, "from", previous, "state", current.State, "consecutive_failures", current.ConsecutiveFailures}
+	switch current.State {
+	case StateHealthy:
+		slog.Info("Provider probe recovered", attrs...)
+	default:
+		slog.Warn("Provider probe failing", append(attrs, "error", current.LastError)...)
+	}
+	events.Record(events.TypeProviderHealth, "", map[string]any{
+		"provider": current.Provider,
+		"key":      current.Key,
+		"from":     previous,
+		"state":    current.State,
+		"error":    current.LastError,
+	})
+}
+
+// send issues one probe and returns the HTTP status (0 when none was received)
+// and why the probe failed. Rate limiting (429) counts as healthy: the provider
+// is up and the circuit breaker handles the limit.
+func (p *Prober) send(ctx context.Context, target Target) (int, error) {
+	req, err := p.buildRequest(ctx, target.Provider)
+	if err != nil {
+		return 0, err
+	}
+	target.Provider.PrepareRequest(req)
+	client := &http.Client{Transport: target.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Transport errors can quote the URL, which carries Gemini's key.
+		return 0, fmt.Errorf("request failed: %w", redactURLError(err))
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if target.Backoff != nil {
+		target.Backoff.Observe(resp.StatusCode, resp.Header)
+	}
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusTooManyRequests {
+		return resp.StatusCode, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// buildRequest returns the model list call, or a one-token canary completion
+// when CanaryModel is set.
+func (p *Prober) buildRequest(ctx context.Context, provider providers.Provider) (*http.Request, error) {
+	target := *provider.BaseURL()
+	if p.cfg.CanaryModel == "" {
+		path, ok := ModelsPaths[provider.Name()]
+		if !ok {
+			return nil, fmt.Errorf("no probe for provider %q", provider.Name())
+		}
+		target.Path = path
+		return http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	}
+
+	model := p.cfg.CanaryModel
+	var body map[string]any
+	switch provider.Name() {
+	case "openai":
+		target.Path = "/v1/chat/completions"
+		body = map[string]any{"model": model, "max_tokens": 1, "messages": []any{map[string]any{"role": "user", "content": "ping"}}}
+	case "anthropic":
+		target.Path = "/v1/messages"
+		body = map[string]any{"model": model, "max_tokens": 1, "messages": []any{map[string]any{"role": "user", "content": "ping"}}}
+	case "gemini":
+		target.Path = "/v1beta/models/" + url.PathEscape(model) + ":generateContent"
+		body = map[string]any{
+			"contents":         []any{map[string]any{"role": "user", "parts": []any{map[string]any{"text": "ping"}}}},
+			"generationConfig": map[string]any{"maxOutputTokens": 1},
+		}
+	default:
+		return nil, fmt.Errorf("no canary for provider %q", provider.Name())
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// redactURLError drops the request URL from transport errors.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// Status returns the health of every target, in the order given to New.
+func (p *Prober) Status() []Health {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Health(nil), p.health...)
+}
+
+// Overall is the worst state across targets: down, degraded, healthy, or
+// unknown before the first probe. A nil Prober is unknown.
+func (p *Prober) Overall() string {
+	state := StateUnknown
+	rank := map[string]int{StateUnknown: 0, StateHealthy: 1, StateDegraded: 2, StateDown: 3}
+	for _, h := range p.Status() {
+		if rank[h.State] > rank[state] {
+			state = h.State
+		}
+	}
+	return state
+}
+
+// Unavailable reports whether requests should fail fast: FailFast is set and
+// the proxied key is down.
+func (p *Prober) Unavailable() bool {
+	if p == nil || !p.cfg.FailFast || len(p.targets) == 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.health[0].State == StateDown
+}
@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/providers/anthropic"
+	"agent-sentinel/internal/providers/gemini"
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/upstream"
+)
+
+type fakeTransport struct {
+	status int
+	header http.Header
+	err    error
+	reqs   []*http.Request
+	bodies []string
+}
+
+func (t *fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.reqs = append(t.reqs, r)
+	body := ""
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}
+	t.bodies = append(t.bodies, body)
+	if t.err != nil {
+		return nil, t.err
+	}
+	header := t.header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: t.status, Header: header, Body: io.NopCloser(strings.NewReader(`{"error":"boom"}`)), Request: r}, nil
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	if _, ok := ConfigFromEnv(); ok {
+		t.Fatal("expected probing to be off by default")
+	}
+	t.Setenv("PROBE_INTERVAL_SECONDS", "15")
+	t.Setenv("PROBE_CANARY_MODEL", "gpt-4o-mini")
+	t.Setenv("PROBE_FAIL_FAST", "true")
+	cfg, ok := ConfigFromEnv()
+	if !ok || cfg.Interval != 15*time.Second || cfg.CanaryModel != "gpt-4o-mini" || !cfg.FailFast || cfg.FailureThreshold != 3 {
+		t.Fatalf("unexpected config %+v (ok %v)", cfg, ok)
+	}
+}
+
+func TestProbeStateTransitions(t *testing.T) {
+	provider, _ := openai.New("sk-test")
+	transport := &fakeTransport{status: http.StatusServiceUnavailable}
+	p := New(Config{FailureThreshold: 2}, Target{Key: "OPENAI_API_KEY", Provider: provider, Transport: transport})
+	if p.Overall() != StateUnknown {
+		t.Fatalf("expected unknown before probing, got %s", p.Overall())
+	}
+	before := len(events.Default().Recent(100, events.TypeProviderHealth))
+
+	p.ProbeAll(context.Background())
+	if h := p.Status()[0]; h.State != StateDegraded || h.LastStatus != http.StatusServiceUnavailable || !strings.Contains(h.LastError, "HTTP 503") {
+		t.Fatalf("expected degraded after one failure, got %+v", h)
+	}
+	p.ProbeAll(context.Background())
+	if p.Overall() != StateDown {
+		t.Fatalf("expected down after two failures, got %s", p.Overall())
+	}
+	transport.status = http.StatusOK
+	p.ProbeAll(context.Background())
+	if h := p.Status()[0]; h.State != StateHealthy || h.ConsecutiveFailures != 0 || h.LastSuccess == nil {
+		t.Fatalf("expected recovery, got %+v", h)
+	}
+	if got := len(events.Default().Recent(100, events.TypeProviderHealth)) - before; got != 3 {
+		t.Fatalf("expected 3 provider_health events, got %d", got)
+	}
+	if got := transport.reqs[0].URL.Path; got != "/v1/models" || transport.reqs[0].Header.Get("Authorization") != "Bearer sk-test" {
+		t.Fatalf("expected an authenticated model list call, got %s", got)
+	}
+}
+
+func TestRateLimitedProbeIsHealthyAndOpensBackoff(t *testing.T) {
+	provider, _ := openai.New("sk-test")
+	header := http.Header{}
+	header.Set("Retry-After", "5")
+	backoff := upstream.NewBackoff(0)
+	p := New(Config{}, Target{Key: "OPENAI_API_KEY", Provider: provider, Transport: &fakeTransport{status: http.StatusTooManyRequests, header: header}, Backoff: backoff})
+
+	p.ProbeAll(context.Background())
+	if h := p.Status()[0]; h.State != StateHealthy {
+		t.Fatalf("expected a rate limited key to count as healthy, got %+v", h)
+	}
+	if allowed, _ := backoff.Allow(); allowed {
+		t.Fatal("expected the probe's 429 to open the provider backoff")
+	}
+}
+
+func TestCanaryRequests(t *testing.T) {
+	openaiProvider, _ := openai.New("sk-test")
+	anthropicProvider, _ := anthropic.New("sk-ant")
+	geminiProvider, _ := gemini.New("g-key")
+	cases := []struct {
+		provider providers.Provider
+		path     string
+		field    string
+	}{
+		{openaiProvider, "/v1/chat/completions", "max_tokens"},
+		{anthropicProvider, "/v1/messages", "max_tokens"},
+		{geminiProvider, "/v1beta/models/canary-model:generateContent", "generationConfig"},
+	}
+	for _, tc := range cases {
+		transport := &fakeTransport{status: http.StatusOK}
+		p := New(Config{CanaryModel: "canary-model"}, Target{Key: "KEY", Provider: tc.provider, Transport: transport})
+		p.ProbeAll(context.Background())
+		if h := p.Status()[0]; h.State != StateHealthy || h.Probe != KindCanary {
+			t.Fatalf("%s: unexpected health %+v", tc.provider.Name(), h)
+		}
+		req := transport.reqs[0]
+		var body map[string]any
+		if err := json.Unmarshal([]byte(transport.bodies[0]), &body); err != nil {
+			t.Fatalf("%s: canary body is not JSON: %v", tc.provider.Name(), err)
+		}
+		if req.Method != http.MethodPost || req.URL.Path != tc.path || body[tc.field] == nil {
+			t.Fatalf("%s: unexpected canary %s %s %v", tc.provider.Name(), req.Method, req.URL.Path, body)
+		}
+	}
+}
+
+func TestProbeErrorsDoNotLeakKeys(t *testing.T) {
+	provider, _ := gemini.New("secret-gemini-key")
+	p := New(Config{}, Target{Key: "GEMINI_API_KEY", Provider: provider, Transport: &fakeTransport{err: errors.New("connection refused")}})
+	p.ProbeAll(context.Background())
+	if h := p.Status()[0]; h.State != StateDegraded || strings.Contains(h.LastError, "secret-gemini-key") {
+		t.Fatalf("expected a redacted transport error, got %+v", h)
+	}
+}
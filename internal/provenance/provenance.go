@@ -0,0 +1,183 @@
+// Package provenance stamps provider responses with a signed record that they
+// passed through the proxy, for downstream attribution and incident forensics.
+//
+// A stamp is a list of fields ending in an HMAC-SHA256 signature over
+// everything before it:
+//
+//	X-Sentinel-Provenance: v=1; kid=2025-10; ts=1760000000; rid=4f9c...;
+//	    tenant=acme; provider=openai; model=gpt-4o; policy=3a7bd3e2360a;
+//	    sig=hex(HMAC-SHA256(key, "v=1; kid=...; policy=3a7bd3e2360a"))
+//
+// Field values are query-escaped. A stamp closing a stream also carries the
+// SHA-256 and length of the streamed body (sha256=..., bytes=...), so a
+// transcript can be matched to the response it came from.
+package provenance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header carries the stamp on responses.
+const Header = "X-Sentinel-Provenance"
+
+// EventName is the SSE event that closes a stamped stream.
+const EventName = "sentinel.provenance"
+
+// version is the stamp format version.
+const version = "1"
+
+// Verification failures.
+var (
+	ErrMalformed    = errors.New("malformed provenance stamp")
+	ErrUnknownKey   = errors.New("no key for provenance stamp")
+	ErrBadSignature = errors.New("provenance signature does not match")
+)
+
+// Stamp is what a stamp records about a response.
+type Stamp struct {
+	Time      time.Time
+	RequestID string
+	TenantID  string
+	Provider  string
+	Model     string
+	// PolicyVersion identifies the config files in force; "env" when none
+	// were loaded.
+	PolicyVersion string
+	// SHA256 and Bytes describe a streamed body; empty for the header stamp.
+	SHA256 string
+	Bytes  int64
+}
+
+// Signer signs stamps with the proxy's key. Safe for concurrent use.
+type Signer struct {
+	key         []byte
+	keyID       string
+	streamEvent bool
+
+	mu            sync.RWMutex
+	policyVersion string
+}
+
+// NewSigner returns a signer using key, identified in stamps by keyID (may be
+// empty). With streamEvent, streams end with a stamp event.
+func NewSigner(key []byte, keyID string, streamEvent bool) *Signer {
+	return &Signer{key: key, keyID: keyID, streamEvent: streamEvent, policyVersion: "env"}
+}
+
+// FromEnv returns a signer for PROVENANCE_KEY, identified by PROVENANCE_KEY_ID,
+// that closes streams with a stamp event when PROVENANCE_STREAM_EVENT is true,
+// or nil when no key is set.
+func FromEnv() *Signer {
+	key := os.Getenv("PROVENANCE_KEY")
+	if key == "" {
+		return nil
+	}
+	streamEvent, _ := strconv.ParseBool(os.Getenv("PROVENANCE_STREAM_EVENT"))
+	return NewSigner([]byte(key), os.Getenv("PROVENANCE_KEY_ID"), streamEvent)
+}
+
+// SetPolicyVersion records the version of the config files now in force; ""
+// means none were loaded.
+func (s *Signer) SetPolicyVersion(v string) {
+	if s == nil {
+		return
+	}
+	if v == "" {
+		v = "env"
+	}
+	s.mu.Lock()
+	s.policyVersion = v
+	s.mu.Unlock()
+}
+
+// PolicyVersion returns the version stamped on responses.
+func (s *Signer) PolicyVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policyVersion
+}
+
+// StreamEvent reports whether streams end with a stamp event.
+func (s *Signer) StreamEvent() bool {
+	return s.streamEvent
+}
+
+// Sign returns the signed stamp for st.
+func (s *Signer) Sign(st Stamp) string {
+	fields := []string{"v=" + version}
+	add := func(name, value string) {
+		fields = append(fields, name+"="+url.QueryEscape(value))
+	}
+	if s.keyID != "" {
+		add("kid", s.keyID)
+	}
+	add("ts", strconv.FormatInt(st.Time.Unix(), 10))
+	add("rid", st.RequestID)
+	add("tenant", st.TenantID)
+	add("provider", st.Provider)
+	add("model", st.Model)
+	add("policy", st.PolicyVersion)
+	if st.SHA256 != "" {
+		add("sha256", st.SHA256)
+		add("bytes", strconv.FormatInt(st.Bytes, 10))
+	}
+	signed := strings.Join(fields, "; ")
+	return signed + "; sig=" + mac(s.key, signed)
+}
+
+// Event returns the SSE event closing a stream stamped with st.
+func (s *Signer) Event(st Stamp) []byte {
+	return []byte("event: " + EventName + "\ndata: {\"provenance\":\"" + s.Sign(st) + "\"}\n\n")
+}
+
+// Verify checks a stamp against keys, by key ID ("" for stamps signed without
+// one), and returns its fields.
+func Verify(stamp string, keys map[string][]byte) (map[string]string, error) {
+	signed, sig, ok := strings.Cut(stamp, "; sig=")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(signed, "; ") {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, ErrMalformed
+		}
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		fields[name] = unescaped
+	}
+	if fields["v"] != version {
+		return nil, ErrMalformed
+	}
+	key, ok := keys[fields["kid"]]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	given, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	want, _ := hex.DecodeString(mac(key, signed))
+	if !hmac.Equal(given, want) {
+		return nil, ErrBadSignature
+	}
+	return fields, nil
+}
+
+func mac(key []byte, signed string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(signed))
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,68 @@
+package provenance
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"), "k2", false)
+	s.SetPolicyVersion("3a7bd3e2360a")
+	stamp := s.Sign(Stamp{
+		Time:          time.Unix(1760000000, 0),
+		RequestID:     "req-1",
+		TenantID:      "acme; team=ml",
+		Provider:      "openai",
+		Model:         "gpt-4o",
+		PolicyVersion: s.PolicyVersion(),
+	})
+	if !strings.HasPrefix(stamp, "v=1; kid=k2; ts=1760000000; rid=req-1; tenant=acme%3B+team%3Dml;") {
+		t.Fatalf("stamp = %q", stamp)
+	}
+
+	fields, err := Verify(stamp, map[string][]byte{"k1": []byte("old"), "k2": []byte("secret")})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if fields["tenant"] != "acme; team=ml" || fields["model"] != "gpt-4o" || fields["policy"] != "3a7bd3e2360a" {
+		t.Fatalf("fields = %v", fields)
+	}
+
+	tampered := strings.Replace(stamp, "model=gpt-4o", "model=gpt-4o-mini", 1)
+	if _, err := Verify(tampered, map[string][]byte{"k2": []byte("secret")}); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify(tampered) = %v, want ErrBadSignature", err)
+	}
+	if _, err := Verify(stamp, map[string][]byte{"k1": []byte("old")}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Verify(unknown key) = %v, want ErrUnknownKey", err)
+	}
+	if _, err := Verify("v=1; ts=1", nil); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Verify(unsigned) = %v, want ErrMalformed", err)
+	}
+}
+
+func TestStreamStamp(t *testing.T) {
+	s := NewSigner([]byte("secret"), "", true)
+	event := string(s.Event(Stamp{Time: time.Unix(1760000000, 0), Provider: "anthropic", SHA256: "abc123", Bytes: 42}))
+	if !strings.HasPrefix(event, "event: "+EventName+"\ndata: {\"provenance\":\"v=1; ts=") || !strings.HasSuffix(event, "\"}\n\n") {
+		t.Fatalf("event = %q", event)
+	}
+	stamp := strings.TrimSuffix(strings.SplitN(event, `"provenance":"`, 2)[1], "\"}\n\n")
+	fields, err := Verify(stamp, map[string][]byte{"": []byte("secret")})
+	if err != nil || fields["sha256"] != "abc123" || fields["bytes"] != "42" || fields["policy"] != "" {
+		t.Fatalf("Verify = %v, %v", fields, err)
+	}
+}
+
+func TestPolicyVersionDefaultsToEnv(t *testing.T) {
+	s := NewSigner([]byte("secret"), "", false)
+	if v := s.PolicyVersion(); v != "env" {
+		t.Fatalf("PolicyVersion = %q, want env", v)
+	}
+	s.SetPolicyVersion("abc")
+	s.SetPolicyVersion("")
+	if v := s.PolicyVersion(); v != "env" {
+		t.Fatalf("PolicyVersion = %q, want env after clearing", v)
+	}
+}
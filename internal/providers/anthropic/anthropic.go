@@ -1,6 +1,7 @@
 package anthropic
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strings"
@@ -14,7 +15,7 @@ const APIVersion = "2023-06-01"
 
 type Provider struct {
 	base   *url.URL
-	apiKey string
+	apiKey providers.APIKey
 }
 
 func New(apiKey string) (*Provider, error) {
@@ -22,7 +23,9 @@ func New(apiKey string) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Provider{base: base, apiKey: apiKey}, nil
+	p := &Provider{base: base}
+	p.apiKey.Set(apiKey)
+	return p, nil
 }
 
 func (p *Provider) Name() string {
@@ -33,35 +36,32 @@ func (p *Provider) BaseURL() *url.URL {
 	return p.base
 }
 
+// SetAPIKey replaces the API key used for subsequent requests.
+func (p *Provider) SetAPIKey(key string) {
+	p.apiKey.Set(key)
+}
+
 func (p *Provider) PrepareRequest(req *http.Request) {
-	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("x-api-key", p.apiKey.Get())
 	req.Header.Set("anthropic-version", APIVersion)
 	req.Host = p.base.Host
 }
 
 // InjectHint sets or prepends to the system field in the request body.
 // Anthropic uses a top-level "system" field (string or array of content blocks).
+// Only message requests are changed; batches and malformed bodies are not.
 func (p *Provider) InjectHint(body map[string]any, hint string) bool {
 	if hint == "" {
 		return false
 	}
-	existing, hasSystem := body["system"]
-	if !hasSystem {
-		body["system"] = hint
-		return true
-	}
-	// If system is a string, prepend the hint
-	if existingStr, ok := existing.(string); ok {
-		body["system"] = hint + "\n\n" + existingStr
-		return true
+	if _, ok := body["messages"].([]any); !ok {
+		return false
 	}
 	// If system is an array of content blocks, prepend a text block
-	if existingArr, ok := existing.([]any); ok {
-		hintBlock := map[string]any{"type": "text", "text": hint}
-		body["system"] = append([]any{hintBlock}, existingArr...)
-		return true
+	if _, ok := body["system"].([]any); ok {
+		return providers.PrependItem(body, "system", map[string]any{"type": "text", "text": hint})
 	}
-	return false
+	return providers.PrependText(body, "system", hint)
 }
 
 // ExtractModelFromPath extracts the model from paths like /v1/messages
@@ -162,6 +162,41 @@ func (p *Provider) ExtractFullText(body map[string]any) string {
 	return strings.Join(parts, " ")
 }
 
+// RequestTextPaths lists the strings ExtractFullText reads.
+func (p *Provider) RequestTextPaths() [][]string {
+	return [][]string{
+		{"system"},
+		{"system", "*", "text"},
+		{"messages", "*", "content"},
+		{"messages", "*", "content", "*", "text"},
+	}
+}
+
+// ExtractToolCalls returns the tool_use blocks of the last assistant message.
+func (p *Provider) ExtractToolCalls(body map[string]any) []providers.ToolCall {
+	messages, _ := body["messages"].([]any)
+	for i := len(messages) - 1; i >= 0; i-- {
+		msgMap, ok := messages[i].(map[string]any)
+		if !ok || msgMap["role"] != "assistant" {
+			continue
+		}
+		var calls []providers.ToolCall
+		blocks, _ := msgMap["content"].([]any)
+		for _, block := range blocks {
+			blockMap, ok := block.(map[string]any)
+			if !ok || blockMap["type"] != "tool_use" {
+				continue
+			}
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			args, _ := json.Marshal(blockMap["input"])
+			calls = append(calls, providers.ToolCall{ID: id, Name: name, Arguments: string(args)})
+		}
+		return calls
+	}
+	return nil
+}
+
 // ParseTokenUsage extracts token usage from Anthropic response.
 // Anthropic format: usage: {input_tokens: N, output_tokens: N}
 func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
@@ -181,3 +216,63 @@ func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
 	}
 	return providers.TokenUsage{}
 }
+
+// ExtractStreamText returns the generated text in a content_block_delta event:
+// delta.text, or delta.partial_json for tool use.
+func (p *Provider) ExtractStreamText(chunk map[string]any) string {
+	if chunk["type"] != "content_block_delta" {
+		return ""
+	}
+	delta, ok := chunk["delta"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if text, ok := delta["text"].(string); ok {
+		return text
+	}
+	if partial, ok := delta["partial_json"].(string); ok {
+		return partial
+	}
+	return ""
+}
+
+// ExtractFinishReasons returns stop_reason from a message, or from the delta of
+// a streaming message_delta event.
+func (p *Provider) ExtractFinishReasons(body map[string]any) []string {
+	if delta, ok := body["delta"].(map[string]any); ok && body["type"] == "message_delta" {
+		body = delta
+	}
+	if reason, ok := body["stop_reason"].(string); ok && reason != "" {
+		return []string{reason}
+	}
+	return nil
+}
+
+// RequestedJSON reads output_format, where a json_schema format asks for
+// output matching its schema.
+func (p *Provider) RequestedJSON(body map[string]any) (bool, map[string]any) {
+	format, _ := body["output_format"].(map[string]any)
+	if format["type"] != "json_schema" {
+		return false, nil
+	}
+	schema, _ := format["schema"].(map[string]any)
+	return true, schema
+}
+
+// ExtractResponseTexts returns the text blocks of a message, joined. A message
+// without text (e.g. only tool_use blocks) yields nothing.
+func (p *Provider) ExtractResponseTexts(body map[string]any) []string {
+	var parts []string
+	content, _ := body["content"].([]any)
+	for _, block := range content {
+		if blockMap, ok := block.(map[string]any); ok && blockMap["type"] == "text" {
+			if text, ok := blockMap["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return []string{strings.Join(parts, "")}
+}
@@ -218,3 +218,102 @@ func TestParseTokenUsage_NoUsage(t *testing.T) {
 		t.Error("expected usage.Found to be false")
 	}
 }
+
+func TestExtractStreamText(t *testing.T) {
+	p := &Provider{}
+	text := map[string]any{"type": "content_block_delta", "delta": map[string]any{"type": "text_delta", "text": "Hello"}}
+	if got := p.ExtractStreamText(text); got != "Hello" {
+		t.Errorf("text delta = %q, want %q", got, "Hello")
+	}
+	tool := map[string]any{"type": "content_block_delta", "delta": map[string]any{"type": "input_json_delta", "partial_json": `{"a"`}}
+	if got := p.ExtractStreamText(tool); got != `{"a"` {
+		t.Errorf("tool delta = %q, want %q", got, `{"a"`)
+	}
+	if got := p.ExtractStreamText(map[string]any{"type": "message_start"}); got != "" {
+		t.Errorf("message_start = %q, want empty", got)
+	}
+}
+
+func TestExtractFinishReasons(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	if got := p.ExtractFinishReasons(map[string]any{"stop_reason": "max_tokens"}); len(got) != 1 || got[0] != "max_tokens" {
+		t.Errorf("message stop_reason = %v", got)
+	}
+	chunk := map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn"}}
+	if got := p.ExtractFinishReasons(chunk); len(got) != 1 || got[0] != "end_turn" {
+		t.Errorf("message_delta stop_reason = %v", got)
+	}
+	if got := p.ExtractFinishReasons(map[string]any{"type": "content_block_delta"}); got != nil {
+		t.Errorf("expected no reason, got %v", got)
+	}
+}
+
+func TestExtractToolCalls(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	body := map[string]any{"messages": []any{
+		map[string]any{"role": "assistant", "content": []any{
+			map[string]any{"type": "text", "text": "Searching."},
+			map[string]any{"type": "tool_use", "id": "toolu_1", "name": "search", "input": map[string]any{"q": "x"}},
+		}},
+		map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"},
+		}},
+	}}
+	got := p.ExtractToolCalls(body)
+	if len(got) != 1 || got[0].ID != "toolu_1" || got[0].Name != "search" || got[0].Arguments != `{"q":"x"}` {
+		t.Errorf("tool calls = %+v", got)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	body := map[string]any{"output_format": map[string]any{"type": "json_schema", "schema": map[string]any{"type": "object"}}}
+	if ok, schema := p.RequestedJSON(body); !ok || schema["type"] != "object" {
+		t.Errorf("requested JSON = %v %v", ok, schema)
+	}
+	resp := map[string]any{"content": []any{
+		map[string]any{"type": "text", "text": `{"a":`},
+		map[string]any{"type": "text", "text": `1}`},
+	}}
+	if got := p.ExtractResponseTexts(resp); len(got) != 1 || got[0] != `{"a":1}` {
+		t.Errorf("response texts = %v", got)
+	}
+	if got := p.ExtractResponseTexts(map[string]any{"content": []any{map[string]any{"type": "tool_use"}}}); got != nil {
+		t.Errorf("expected no text, got %v", got)
+	}
+}
+
+func TestInjectHint_MalformedBodies(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	msgs := []any{map[string]any{"role": "user", "content": "hello"}}
+
+	body := map[string]any{"system": nil, "messages": msgs}
+	if !p.InjectHint(body, "hint") || body["system"] != "hint" {
+		t.Fatalf("expected a null system replaced, got %v", body["system"])
+	}
+	body = map[string]any{"system": 7.0, "messages": msgs}
+	if p.InjectHint(body, "hint") || body["system"] != 7.0 {
+		t.Fatalf("expected a numeric system left unchanged, got %v", body["system"])
+	}
+	body = map[string]any{"requests": []any{}}
+	if p.InjectHint(body, "hint") || len(body) != 1 {
+		t.Fatalf("expected a body without messages left unchanged, got %v", body)
+	}
+}
+
+func TestInjectHint_KeepsCachedBlocks(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	cached := map[string]any{"type": "text", "text": "long prompt", "cache_control": map[string]any{"type": "ephemeral"}}
+	system := []any{cached}
+	body := map[string]any{"system": system, "messages": []any{}}
+	if !p.InjectHint(body, "hint") {
+		t.Fatal("expected InjectHint to succeed")
+	}
+	blocks := body["system"].([]any)
+	if len(blocks) != 2 || blocks[1].(map[string]any)["cache_control"] == nil {
+		t.Fatalf("expected the cached block kept after the hint, got %v", blocks)
+	}
+	if system[0].(map[string]any)["text"] != "long prompt" {
+		t.Fatal("expected the original system array untouched")
+	}
+}
@@ -1,8 +1,11 @@
 package gemini
 
 import (
+	"encoding/json"
+	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
 	"agent-sentinel/internal/providers"
@@ -10,7 +13,7 @@ import (
 
 type Provider struct {
 	base   *url.URL
-	apiKey string
+	apiKey providers.APIKey
 }
 
 func New(apiKey string) (*Provider, error) {
@@ -18,7 +21,9 @@ func New(apiKey string) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Provider{base: base, apiKey: apiKey}, nil
+	p := &Provider{base: base}
+	p.apiKey.Set(apiKey)
+	return p, nil
 }
 
 func (p *Provider) Name() string {
@@ -29,18 +34,83 @@ func (p *Provider) BaseURL() *url.URL {
 	return p.base
 }
 
+// SetAPIKey replaces the API key used for subsequent requests.
+func (p *Provider) SetAPIKey(key string) {
+	p.apiKey.Set(key)
+}
+
 func (p *Provider) PrepareRequest(req *http.Request) {
 	q := req.URL.Query()
-	q.Set("key", p.apiKey)
+	q.Set("key", p.apiKey.Get())
 	req.URL.RawQuery = q.Encode()
 	req.Host = p.base.Host
 }
 
-// InjectHint prepends a text hint to the first content part.
+// InjectHint prepends the hint to the system instruction, creating one when the
+// request has none, so the model does not take it for user text and echo it.
+// Requests that cannot carry a system instruction get the hint in the first
+// content instead (see injectContentHint).
 func (p *Provider) InjectHint(body map[string]any, hint string) bool {
 	if hint == "" {
 		return false
 	}
+	if _, ok := body["contents"].([]any); !ok {
+		return false
+	}
+	if _, cached := cachedContent(body); cached {
+		// Requests using cached content may not set a system instruction.
+		return injectContentHint(body, hint)
+	}
+	key := "systemInstruction"
+	if _, ok := body["system_instruction"]; ok {
+		key = "system_instruction"
+	}
+	hintPart := map[string]any{"text": hint}
+	switch instruction := body[key].(type) {
+	case nil:
+		body[key] = map[string]any{"parts": []any{hintPart}}
+		return true
+	case map[string]any:
+		parts, isArray := instruction["parts"].([]any)
+		if !isArray && instruction["parts"] != nil {
+			return injectContentHint(body, hint)
+		}
+		updated := maps.Clone(instruction)
+		updated["parts"] = append([]any{hintPart}, parts...)
+		body[key] = updated
+		return true
+	default:
+		return injectContentHint(body, hint)
+	}
+}
+
+// InjectHintForPath injects into the system instruction on generateContent and
+// streamGenerateContent, and into the first content on other methods (e.g.
+// countTokens), which do not accept one.
+func (p *Provider) InjectHintForPath(path string, body map[string]any, hint string) bool {
+	if hint == "" {
+		return false
+	}
+	if strings.HasSuffix(path, ":generateContent") || strings.HasSuffix(path, ":streamGenerateContent") {
+		return p.InjectHint(body, hint)
+	}
+	return injectContentHint(body, hint)
+}
+
+// cachedContent returns the cached content a request refers to, if any.
+func cachedContent(body map[string]any) (string, bool) {
+	for _, key := range []string{"cachedContent", "cached_content"} {
+		if name, ok := body[key].(string); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// injectContentHint prepends a text hint to the first content part. A first
+// turn of function calls or responses, which must hold only those parts, gets
+// the hint in a user turn of its own before it.
+func injectContentHint(body map[string]any, hint string) bool {
 	contents, ok := body["contents"].([]any)
 	if !ok || len(contents) == 0 {
 		return false
@@ -49,17 +119,39 @@ func (p *Provider) InjectHint(body map[string]any, hint string) bool {
 	if !ok {
 		return false
 	}
-	partsAny, ok := first["parts"].([]any)
-	if !ok {
-		partsAny = []any{}
-	}
 	hintPart := map[string]any{"text": hint}
-	first["parts"] = append([]any{hintPart}, partsAny...)
-	contents[0] = first
+	parts, isArray := first["parts"].([]any)
+	if !isArray && first["parts"] != nil {
+		return false
+	}
+	if hasFunctionParts(parts) {
+		return providers.PrependItem(body, "contents", map[string]any{"role": "user", "parts": []any{hintPart}})
+	}
+	updated := maps.Clone(first)
+	updated["parts"] = append([]any{hintPart}, parts...)
+	contents = slices.Clone(contents)
+	contents[0] = updated
 	body["contents"] = contents
 	return true
 }
 
+// hasFunctionParts reports whether parts include a function call or response,
+// in either JSON spelling.
+func hasFunctionParts(parts []any) bool {
+	for _, part := range parts {
+		m, ok := part.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"functionCall", "functionResponse", "function_call", "function_response"} {
+			if m[key] != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (p *Provider) ExtractModelFromPath(path string) string {
 	modelsIndex := strings.Index(path, "/models/")
 	if modelsIndex == -1 {
@@ -110,6 +202,40 @@ func (p *Provider) ExtractFullText(body map[string]any) string {
 	return strings.Join(parts, " ")
 }
 
+// RequestTextPaths lists the strings ExtractFullText reads.
+func (p *Provider) RequestTextPaths() [][]string {
+	return [][]string{{"contents", "*", "parts", "*", "text"}}
+}
+
+// ExtractToolCalls returns the functionCall parts of the last model turn.
+func (p *Provider) ExtractToolCalls(body map[string]any) []providers.ToolCall {
+	contents, _ := body["contents"].([]any)
+	for i := len(contents) - 1; i >= 0; i-- {
+		contentMap, ok := contents[i].(map[string]any)
+		if !ok || contentMap["role"] != "model" {
+			continue
+		}
+		var calls []providers.ToolCall
+		parts, _ := contentMap["parts"].([]any)
+		for _, part := range parts {
+			partMap, ok := part.(map[string]any)
+			if !ok {
+				continue
+			}
+			fn, ok := partMap["functionCall"].(map[string]any)
+			if !ok {
+				continue
+			}
+			id, _ := fn["id"].(string)
+			name, _ := fn["name"].(string)
+			args, _ := json.Marshal(fn["args"])
+			calls = append(calls, providers.ToolCall{ID: id, Name: name, Arguments: string(args)})
+		}
+		return calls
+	}
+	return nil
+}
+
 func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
 	if usage, ok := body["usageMetadata"].(map[string]any); ok {
 		var inputTokens, outputTokens int
@@ -125,3 +251,86 @@ func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
 	}
 	return providers.TokenUsage{}
 }
+
+// ExtractStreamText returns the generated text in a streamed
+// GenerateContentResponse: candidates[].content.parts[].text.
+func (p *Provider) ExtractStreamText(chunk map[string]any) string {
+	var parts []string
+	if candidates, ok := chunk["candidates"].([]any); ok {
+		for _, candidate := range candidates {
+			candidateMap, ok := candidate.(map[string]any)
+			if !ok {
+				continue
+			}
+			content, ok := candidateMap["content"].(map[string]any)
+			if !ok {
+				continue
+			}
+			if contentParts, ok := content["parts"].([]any); ok {
+				for _, part := range contentParts {
+					if partMap, ok := part.(map[string]any); ok {
+						if text, ok := partMap["text"].(string); ok {
+							parts = append(parts, text)
+						}
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// ExtractFinishReasons returns candidates[].finishReason.
+func (p *Provider) ExtractFinishReasons(body map[string]any) []string {
+	var reasons []string
+	if candidates, ok := body["candidates"].([]any); ok {
+		for _, candidate := range candidates {
+			if candidateMap, ok := candidate.(map[string]any); ok {
+				if reason, ok := candidateMap["finishReason"].(string); ok && reason != "" {
+					reasons = append(reasons, reason)
+				}
+			}
+		}
+	}
+	return reasons
+}
+
+// RequestedJSON reads generationConfig: a responseMimeType of application/json
+// asks for JSON, matching responseJsonSchema or responseSchema when set.
+func (p *Provider) RequestedJSON(body map[string]any) (bool, map[string]any) {
+	config, _ := body["generationConfig"].(map[string]any)
+	if config["responseMimeType"] != "application/json" {
+		return false, nil
+	}
+	schema, ok := config["responseJsonSchema"].(map[string]any)
+	if !ok {
+		schema, _ = config["responseSchema"].(map[string]any)
+	}
+	return true, schema
+}
+
+// ExtractResponseTexts returns the text parts of each candidate, joined,
+// leaving out thought summaries. Candidates without text are skipped.
+func (p *Provider) ExtractResponseTexts(body map[string]any) []string {
+	var texts []string
+	candidates, _ := body["candidates"].([]any)
+	for _, candidate := range candidates {
+		candidateMap, _ := candidate.(map[string]any)
+		content, _ := candidateMap["content"].(map[string]any)
+		contentParts, _ := content["parts"].([]any)
+		var parts []string
+		for _, part := range contentParts {
+			partMap, _ := part.(map[string]any)
+			if thought, _ := partMap["thought"].(bool); thought {
+				continue
+			}
+			if text, ok := partMap["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		if len(parts) > 0 {
+			texts = append(texts, strings.Join(parts, ""))
+		}
+	}
+	return texts
+}
@@ -20,18 +20,69 @@ func TestInjectHintAndExtraction(t *testing.T) {
 	if !ok {
 		t.Fatalf("expected inject hint to succeed")
 	}
-	first := body["contents"].([]any)[0].(map[string]any)["parts"].([]any)[0].(map[string]any)
-	if first["text"] != "hint" {
-		t.Fatalf("expected hint at first position, got %v", first["text"])
+	instruction := body["systemInstruction"].(map[string]any)
+	if first := instruction["parts"].([]any)[0].(map[string]any); first["text"] != "hint" {
+		t.Fatalf("expected hint in the system instruction, got %v", first["text"])
 	}
-	if got := p.ExtractPrompt(body); got != "hint" {
+	if got := p.ExtractPrompt(body); got != "hello" {
 		t.Fatalf("ExtractPrompt got %q", got)
 	}
-	if got := p.ExtractFullText(body); got != "hint hello" {
+	if got := p.ExtractFullText(body); got != "hello" {
 		t.Fatalf("ExtractFullText got %q", got)
 	}
 }
 
+func TestInjectHintSystemInstruction(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	contents := []any{map[string]any{"role": "user", "parts": []any{map[string]any{"text": "hello"}}}}
+
+	existing := map[string]any{"parts": []any{map[string]any{"text": "be brief"}}}
+	body := map[string]any{"contents": contents, "system_instruction": existing}
+	if !p.InjectHint(body, "hint") {
+		t.Fatal("expected InjectHint to succeed")
+	}
+	parts := body["system_instruction"].(map[string]any)["parts"].([]any)
+	if len(parts) != 2 || parts[0].(map[string]any)["text"] != "hint" || parts[1].(map[string]any)["text"] != "be brief" {
+		t.Fatalf("expected the hint before the existing instruction, got %v", parts)
+	}
+	if _, ok := body["systemInstruction"]; ok {
+		t.Fatal("expected the snake_case key reused")
+	}
+	if len(existing["parts"].([]any)) != 1 {
+		t.Fatal("expected the original instruction untouched")
+	}
+
+	// Fallbacks to the first content.
+	for name, body := range map[string]map[string]any{
+		"cached content":     {"contents": contents, "cachedContent": "cachedContents/abc"},
+		"string instruction": {"contents": contents, "systemInstruction": "be brief"},
+		"malformed parts":    {"contents": contents, "systemInstruction": map[string]any{"parts": "be brief"}},
+	} {
+		if !p.InjectHint(body, "hint") {
+			t.Fatalf("%s: expected InjectHint to succeed", name)
+		}
+		first := body["contents"].([]any)[0].(map[string]any)["parts"].([]any)[0].(map[string]any)
+		if first["text"] != "hint" {
+			t.Fatalf("%s: expected the hint in the first content, got %v", name, first)
+		}
+	}
+
+	body = map[string]any{"contents": contents}
+	if !p.InjectHintForPath("/v1beta/models/gemini-2.5-flash:countTokens", body, "hint") {
+		t.Fatal("expected InjectHintForPath to succeed")
+	}
+	if _, ok := body["systemInstruction"]; ok {
+		t.Fatal("expected countTokens to use the first content")
+	}
+	body = map[string]any{"contents": contents}
+	if !p.InjectHintForPath("/v1beta/models/gemini-2.5-flash:streamGenerateContent", body, "hint") {
+		t.Fatal("expected InjectHintForPath to succeed")
+	}
+	if _, ok := body["systemInstruction"]; !ok {
+		t.Fatal("expected streamGenerateContent to use the system instruction")
+	}
+}
+
 func TestExtractModelFromPath(t *testing.T) {
 	p := &Provider{}
 	model := p.ExtractModelFromPath("/v1beta/models/gemini-2.5-flash:generateContent")
@@ -53,3 +104,103 @@ func TestParseTokenUsage(t *testing.T) {
 		t.Fatalf("unexpected usage %+v", usage)
 	}
 }
+
+func TestExtractStreamText(t *testing.T) {
+	p := &Provider{}
+	chunk := map[string]any{
+		"candidates": []any{map[string]any{"content": map[string]any{
+			"parts": []any{map[string]any{"text": "Hello"}, map[string]any{"text": " world"}},
+		}}},
+	}
+	if got := p.ExtractStreamText(chunk); got != "Hello world" {
+		t.Fatalf("unexpected text %q", got)
+	}
+}
+
+func TestExtractFinishReasons(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	body := map[string]any{"candidates": []any{
+		map[string]any{"finishReason": "MAX_TOKENS"},
+		map[string]any{"content": map[string]any{}},
+	}}
+	if got := p.ExtractFinishReasons(body); len(got) != 1 || got[0] != "MAX_TOKENS" {
+		t.Errorf("finish reasons = %v", got)
+	}
+}
+
+func TestExtractToolCalls(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	body := map[string]any{"contents": []any{
+		map[string]any{"role": "model", "parts": []any{
+			map[string]any{"functionCall": map[string]any{"name": "search", "args": map[string]any{"q": "x"}}},
+		}},
+		map[string]any{"role": "user", "parts": []any{
+			map[string]any{"functionResponse": map[string]any{"name": "search", "response": map[string]any{}}},
+		}},
+	}}
+	got := p.ExtractToolCalls(body)
+	if len(got) != 1 || got[0].Name != "search" || got[0].Arguments != `{"q":"x"}` {
+		t.Errorf("tool calls = %+v", got)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	body := map[string]any{"generationConfig": map[string]any{
+		"responseMimeType": "application/json",
+		"responseSchema":   map[string]any{"type": "OBJECT"},
+	}}
+	if ok, schema := p.RequestedJSON(body); !ok || schema["type"] != "OBJECT" {
+		t.Errorf("requested JSON = %v %v", ok, schema)
+	}
+	resp := map[string]any{"candidates": []any{map[string]any{"content": map[string]any{"parts": []any{
+		map[string]any{"text": "thinking", "thought": true},
+		map[string]any{"text": `{"a":`},
+		map[string]any{"text": `1}`},
+	}}}}}
+	if got := p.ExtractResponseTexts(resp); len(got) != 1 || got[0] != `{"a":1}` {
+		t.Errorf("response texts = %v", got)
+	}
+}
+
+func TestInjectHintShapes(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	// countTokens takes no system instruction, so hints go in the contents.
+	const countTokens = "/v1beta/models/gemini-2.5-flash:countTokens"
+
+	image := map[string]any{"inline_data": map[string]any{"mime_type": "image/png", "data": "AA=="}}
+	first := map[string]any{"role": "user", "parts": []any{image}}
+	contents := []any{first}
+	body := map[string]any{"contents": contents}
+	if !p.InjectHintForPath(countTokens, body, "hint") {
+		t.Fatal("expected InjectHintForPath to succeed")
+	}
+	parts := body["contents"].([]any)[0].(map[string]any)["parts"].([]any)
+	if len(parts) != 2 || parts[1].(map[string]any)["inline_data"] == nil {
+		t.Fatalf("expected the image part kept after the hint, got %v", parts)
+	}
+	if len(first["parts"].([]any)) != 1 || contents[0].(map[string]any)["parts"] == nil {
+		t.Fatal("expected the original content untouched")
+	}
+
+	body = map[string]any{"contents": []any{map[string]any{"role": "user", "parts": []any{
+		map[string]any{"functionResponse": map[string]any{"name": "search", "response": map[string]any{}}},
+	}}}}
+	if !p.InjectHintForPath(countTokens, body, "hint") {
+		t.Fatal("expected InjectHintForPath to succeed")
+	}
+	turns := body["contents"].([]any)
+	if len(turns) != 2 || turns[0].(map[string]any)["parts"].([]any)[0].(map[string]any)["text"] != "hint" {
+		t.Fatalf("expected the hint in a turn of its own, got %v", turns)
+	}
+
+	for _, malformed := range []map[string]any{
+		{"contents": []any{map[string]any{"parts": "hello"}}},
+		{"contents": []any{"hello"}},
+		{"contents": map[string]any{}},
+	} {
+		if p.InjectHintForPath(countTokens, malformed, "hint") {
+			t.Fatalf("expected %v left unchanged", malformed)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package providers
+
+// Helpers for provider InjectHint implementations. Request bodies are decoded
+// from client JSON, so any field can hold any type. InjectHint must not panic
+// on unexpected shapes, must leave fields it does not understand as they are,
+// and must not modify a nested map or slice in place: it copies what it changes
+// (maps.Clone, slices.Clone) and stores the copy back in body, so other holders
+// of the decoded values, such as a retry reusing them, are unaffected.
+
+// PrependItem stores item followed by the array at body[key], creating the
+// array when the key is missing or null. It returns false, leaving body
+// unchanged, when the existing value is not an array.
+func PrependItem(body map[string]any, key string, item any) bool {
+	existing, present := body[key]
+	if !present || existing == nil {
+		body[key] = []any{item}
+		return true
+	}
+	items, ok := existing.([]any)
+	if !ok {
+		return false
+	}
+	body[key] = append([]any{item}, items...)
+	return true
+}
+
+// PrependText stores hint before the string at body[key], separated by a blank
+// line, or sets it when the key is missing, null or empty. It returns false,
+// leaving body unchanged, when the existing value is not a string.
+func PrependText(body map[string]any, key, hint string) bool {
+	existing, present := body[key]
+	if !present || existing == nil {
+		body[key] = hint
+		return true
+	}
+	text, ok := existing.(string)
+	if !ok {
+		return false
+	}
+	if text == "" {
+		body[key] = hint
+		return true
+	}
+	body[key] = hint + "\n\n" + text
+	return true
+}
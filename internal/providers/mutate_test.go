@@ -0,0 +1,41 @@
+package providers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrependItem(t *testing.T) {
+	original := []any{"a"}
+	body := map[string]any{"list": original, "null": nil, "text": "x"}
+
+	if !PrependItem(body, "list", "hint") || !reflect.DeepEqual(body["list"], []any{"hint", "a"}) {
+		t.Fatalf("expected the item prepended, got %v", body["list"])
+	}
+	if original[0] != "a" {
+		t.Fatalf("expected the original slice untouched, got %v", original)
+	}
+	if !PrependItem(body, "null", "hint") || !PrependItem(body, "missing", "hint") {
+		t.Fatal("expected null and missing keys to get a new array")
+	}
+	if PrependItem(body, "text", "hint") || body["text"] != "x" {
+		t.Fatalf("expected a non-array left unchanged, got %v", body["text"])
+	}
+}
+
+func TestPrependText(t *testing.T) {
+	body := map[string]any{"system": "be brief", "empty": "", "blocks": []any{}, "number": 3.0}
+
+	if !PrependText(body, "system", "hint") || body["system"] != "hint\n\nbe brief" {
+		t.Fatalf("unexpected text %q", body["system"])
+	}
+	if !PrependText(body, "empty", "hint") || body["empty"] != "hint" {
+		t.Fatalf("expected an empty string replaced, got %q", body["empty"])
+	}
+	if !PrependText(body, "missing", "hint") || body["missing"] != "hint" {
+		t.Fatal("expected a missing key set")
+	}
+	if PrependText(body, "number", "hint") || body["number"] != 3.0 {
+		t.Fatalf("expected a non-string left unchanged, got %v", body["number"])
+	}
+}
@@ -11,7 +11,7 @@ import (
 
 type Provider struct {
 	base   *url.URL
-	apiKey string
+	apiKey providers.APIKey
 }
 
 func New(apiKey string) (*Provider, error) {
@@ -19,7 +19,9 @@ func New(apiKey string) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Provider{base: base, apiKey: apiKey}, nil
+	p := &Provider{base: base}
+	p.apiKey.Set(apiKey)
+	return p, nil
 }
 
 func (p *Provider) Name() string {
@@ -30,23 +32,54 @@ func (p *Provider) BaseURL() *url.URL {
 	return p.base
 }
 
+// SetAPIKey replaces the API key used for subsequent requests.
+func (p *Provider) SetAPIKey(key string) {
+	p.apiKey.Set(key)
+}
+
 func (p *Provider) PrepareRequest(req *http.Request) {
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey.Get()))
 	req.Host = p.base.Host
 }
 
-// InjectHint prepends a system message with the hint.
+// InjectHint prepends a system message with the hint to Chat Completions
+// messages, or to the input items of a Responses API request; a plain string
+// input gets the hint in its instructions instead, when it has some. When the
+// conversation starts with a developer message (o-series models), the hint is
+// a developer message too. Other requests, such as embeddings, and bodies of
+// unexpected shape are left unchanged.
 func (p *Provider) InjectHint(body map[string]any, hint string) bool {
 	if hint == "" {
 		return false
 	}
-	msgs, ok := body["messages"].([]any)
-	if !ok {
-		msgs = []any{}
+	key := "messages"
+	if _, ok := body["messages"]; !ok {
+		switch input := body["input"].(type) {
+		case []any:
+			if len(input) == 0 {
+				return false
+			}
+			if _, ok := input[0].(map[string]any); !ok {
+				// Strings or token arrays: an embeddings request.
+				return false
+			}
+			key = "input"
+		case string:
+			if _, ok := body["instructions"]; !ok {
+				return false
+			}
+			return providers.PrependText(body, "instructions", hint)
+		default:
+			return false
+		}
+	}
+	role := "system"
+	if items, ok := body[key].([]any); ok && len(items) > 0 {
+		if first, ok := items[0].(map[string]any); ok && first["role"] == "developer" {
+			role = "developer"
+		}
 	}
-	hintMsg := map[string]any{"role": "system", "content": hint}
-	body["messages"] = append([]any{hintMsg}, msgs...)
-	return true
+	return providers.PrependItem(body, key, map[string]any{"role": role, "content": hint})
 }
 
 func (p *Provider) ExtractModelFromPath(path string) string {
@@ -118,6 +151,57 @@ func (p *Provider) ExtractFullText(body map[string]any) string {
 	return strings.Join(parts, " ")
 }
 
+// RequestTextPaths lists the strings ExtractFullText reads.
+func (p *Provider) RequestTextPaths() [][]string {
+	return [][]string{{"input"}, {"input", "*", "content"}, {"messages", "*", "content"}}
+}
+
+// ExtractToolCalls returns the tool_calls of the last assistant message for
+// Chat Completions, or for the Responses API the function_call items after the
+// last assistant message.
+func (p *Provider) ExtractToolCalls(body map[string]any) []providers.ToolCall {
+	var calls []providers.ToolCall
+	if messages, ok := body["messages"].([]any); ok {
+		for _, msg := range messages {
+			msgMap, ok := msg.(map[string]any)
+			if !ok || msgMap["role"] != "assistant" {
+				continue
+			}
+			calls = nil
+			toolCalls, _ := msgMap["tool_calls"].([]any)
+			for _, call := range toolCalls {
+				callMap, ok := call.(map[string]any)
+				if !ok {
+					continue
+				}
+				fn, _ := callMap["function"].(map[string]any)
+				id, _ := callMap["id"].(string)
+				name, _ := fn["name"].(string)
+				args, _ := fn["arguments"].(string)
+				calls = append(calls, providers.ToolCall{ID: id, Name: name, Arguments: args})
+			}
+		}
+		return calls
+	}
+	items, _ := body["input"].([]any)
+	for _, item := range items {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch {
+		case itemMap["type"] == "function_call":
+			id, _ := itemMap["call_id"].(string)
+			name, _ := itemMap["name"].(string)
+			args, _ := itemMap["arguments"].(string)
+			calls = append(calls, providers.ToolCall{ID: id, Name: name, Arguments: args})
+		case itemMap["role"] == "assistant":
+			calls = nil
+		}
+	}
+	return calls
+}
+
 func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
 	if usage, ok := body["usage"].(map[string]any); ok {
 		var inputTokens, outputTokens int
@@ -133,3 +217,130 @@ func (p *Provider) ParseTokenUsage(body map[string]any) providers.TokenUsage {
 	}
 	return providers.TokenUsage{}
 }
+
+// ExtractStreamText returns the generated text in a streaming chunk: Chat
+// Completions choices[].delta.content (and tool call arguments), or Responses
+// API output_text deltas.
+func (p *Provider) ExtractStreamText(chunk map[string]any) string {
+	if t, _ := chunk["type"].(string); t == "response.output_text.delta" || t == "response.function_call_arguments.delta" {
+		delta, _ := chunk["delta"].(string)
+		return delta
+	}
+	var parts []string
+	if choices, ok := chunk["choices"].([]any); ok {
+		for _, choice := range choices {
+			choiceMap, ok := choice.(map[string]any)
+			if !ok {
+				continue
+			}
+			delta, ok := choiceMap["delta"].(map[string]any)
+			if !ok {
+				continue
+			}
+			if content, ok := delta["content"].(string); ok {
+				parts = append(parts, content)
+			}
+			if toolCalls, ok := delta["tool_calls"].([]any); ok {
+				for _, call := range toolCalls {
+					if fn, ok := call.(map[string]any)["function"].(map[string]any); ok {
+						if args, ok := fn["arguments"].(string); ok {
+							parts = append(parts, args)
+						}
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// ExtractFinishReasons returns choices[].finish_reason for Chat Completions, or
+// for the Responses API "stop" when a response completed and the
+// incomplete_details reason (e.g. max_output_tokens) when it did not.
+func (p *Provider) ExtractFinishReasons(body map[string]any) []string {
+	var reasons []string
+	if choices, ok := body["choices"].([]any); ok {
+		for _, choice := range choices {
+			if choiceMap, ok := choice.(map[string]any); ok {
+				if reason, ok := choiceMap["finish_reason"].(string); ok && reason != "" {
+					reasons = append(reasons, reason)
+				}
+			}
+		}
+		return reasons
+	}
+	// Streaming Responses API events wrap the response object.
+	if response, ok := body["response"].(map[string]any); ok {
+		body = response
+	}
+	switch body["status"] {
+	case "completed":
+		reasons = append(reasons, "stop")
+	case "incomplete":
+		if details, ok := body["incomplete_details"].(map[string]any); ok {
+			if reason, ok := details["reason"].(string); ok && reason != "" {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+	return reasons
+}
+
+// RequestedJSON reads response_format for Chat Completions and text.format for
+// the Responses API: json_object asks for any JSON, json_schema for output
+// matching its schema.
+func (p *Provider) RequestedJSON(body map[string]any) (bool, map[string]any) {
+	format, _ := body["response_format"].(map[string]any)
+	if text, ok := body["text"].(map[string]any); ok && format == nil {
+		format, _ = text["format"].(map[string]any)
+	}
+	switch format["type"] {
+	case "json_object":
+		return true, nil
+	case "json_schema":
+		// Chat Completions nests the schema; the Responses API does not.
+		if wrapper, ok := format["json_schema"].(map[string]any); ok {
+			format = wrapper
+		}
+		schema, _ := format["schema"].(map[string]any)
+		return true, schema
+	}
+	return false, nil
+}
+
+// ExtractResponseTexts returns choices[].message.content for Chat Completions,
+// or for the Responses API the output_text of each output message. Choices
+// without text content (tool calls, refusals) are skipped.
+func (p *Provider) ExtractResponseTexts(body map[string]any) []string {
+	var texts []string
+	if choices, ok := body["choices"].([]any); ok {
+		for _, choice := range choices {
+			choiceMap, _ := choice.(map[string]any)
+			message, _ := choiceMap["message"].(map[string]any)
+			if content, ok := message["content"].(string); ok {
+				texts = append(texts, content)
+			}
+		}
+		return texts
+	}
+	output, _ := body["output"].([]any)
+	for _, item := range output {
+		itemMap, _ := item.(map[string]any)
+		if itemMap["type"] != "message" {
+			continue
+		}
+		var parts []string
+		content, _ := itemMap["content"].([]any)
+		for _, part := range content {
+			if partMap, ok := part.(map[string]any); ok && partMap["type"] == "output_text" {
+				if text, ok := partMap["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		if len(parts) > 0 {
+			texts = append(texts, strings.Join(parts, ""))
+		}
+	}
+	return texts
+}
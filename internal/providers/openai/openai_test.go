@@ -53,3 +53,146 @@ func TestParseTokenUsage(t *testing.T) {
 		t.Fatalf("unexpected usage %+v", usage)
 	}
 }
+
+func TestExtractStreamText(t *testing.T) {
+	p := &Provider{}
+	chunk := map[string]any{
+		"choices": []any{map[string]any{"delta": map[string]any{
+			"content":    "Hi",
+			"tool_calls": []any{map[string]any{"function": map[string]any{"arguments": `{"q":`}}},
+		}}},
+	}
+	if got := p.ExtractStreamText(chunk); got != `Hi{"q":` {
+		t.Fatalf("unexpected text %q", got)
+	}
+	responses := map[string]any{"type": "response.output_text.delta", "delta": "there"}
+	if got := p.ExtractStreamText(responses); got != "there" {
+		t.Fatalf("unexpected responses text %q", got)
+	}
+}
+
+func TestExtractFinishReasons(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	chat := map[string]any{"choices": []any{
+		map[string]any{"finish_reason": "stop"},
+		map[string]any{"finish_reason": "length"},
+		map[string]any{"finish_reason": nil},
+	}}
+	if got := p.ExtractFinishReasons(chat); len(got) != 2 || got[0] != "stop" || got[1] != "length" {
+		t.Errorf("chat finish reasons = %v", got)
+	}
+	event := map[string]any{"type": "response.incomplete", "response": map[string]any{
+		"status":             "incomplete",
+		"incomplete_details": map[string]any{"reason": "max_output_tokens"},
+	}}
+	if got := p.ExtractFinishReasons(event); len(got) != 1 || got[0] != "max_output_tokens" {
+		t.Errorf("responses finish reasons = %v", got)
+	}
+	if got := p.ExtractFinishReasons(map[string]any{"status": "completed"}); len(got) != 1 || got[0] != "stop" {
+		t.Errorf("completed response finish reasons = %v", got)
+	}
+}
+
+func TestExtractToolCalls(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	chat := map[string]any{"messages": []any{
+		map[string]any{"role": "assistant", "tool_calls": []any{
+			map[string]any{"id": "call_old", "function": map[string]any{"name": "old", "arguments": "{}"}},
+		}},
+		map[string]any{"role": "tool", "content": "result"},
+		map[string]any{"role": "assistant", "tool_calls": []any{
+			map[string]any{"id": "call_1", "function": map[string]any{"name": "search", "arguments": `{"q":"x"}`}},
+		}},
+		map[string]any{"role": "tool", "content": "result"},
+	}}
+	if got := p.ExtractToolCalls(chat); len(got) != 1 || got[0].ID != "call_1" || got[0].Name != "search" || got[0].Arguments != `{"q":"x"}` {
+		t.Errorf("chat tool calls = %+v", got)
+	}
+	responses := map[string]any{"input": []any{
+		map[string]any{"role": "user", "content": "go"},
+		map[string]any{"type": "function_call", "call_id": "call_2", "name": "read", "arguments": "{}"},
+		map[string]any{"type": "function_call_output", "call_id": "call_2", "output": "ok"},
+	}}
+	if got := p.ExtractToolCalls(responses); len(got) != 1 || got[0].ID != "call_2" || got[0].Name != "read" {
+		t.Errorf("responses tool calls = %+v", got)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	chat := map[string]any{"response_format": map[string]any{"type": "json_schema", "json_schema": map[string]any{
+		"name": "answer", "schema": map[string]any{"type": "object"},
+	}}}
+	if ok, schema := p.RequestedJSON(chat); !ok || schema["type"] != "object" {
+		t.Errorf("chat requested JSON = %v %v", ok, schema)
+	}
+	responses := map[string]any{"text": map[string]any{"format": map[string]any{"type": "json_object"}}}
+	if ok, schema := p.RequestedJSON(responses); !ok || schema != nil {
+		t.Errorf("responses requested JSON = %v %v", ok, schema)
+	}
+	if ok, _ := p.RequestedJSON(map[string]any{"response_format": map[string]any{"type": "text"}}); ok {
+		t.Error("expected text format not to request JSON")
+	}
+
+	chatResp := map[string]any{"choices": []any{
+		map[string]any{"message": map[string]any{"content": `{"a":1}`}},
+		map[string]any{"message": map[string]any{"content": nil, "tool_calls": []any{}}},
+	}}
+	if got := p.ExtractResponseTexts(chatResp); len(got) != 1 || got[0] != `{"a":1}` {
+		t.Errorf("chat response texts = %v", got)
+	}
+	responsesResp := map[string]any{"output": []any{
+		map[string]any{"type": "reasoning"},
+		map[string]any{"type": "message", "content": []any{map[string]any{"type": "output_text", "text": `{"a":1}`}}},
+	}}
+	if got := p.ExtractResponseTexts(responsesResp); len(got) != 1 || got[0] != `{"a":1}` {
+		t.Errorf("responses response texts = %v", got)
+	}
+}
+
+func TestInjectHintShapes(t *testing.T) {
+	p := &Provider{base: &url.URL{}}
+	tests := []struct {
+		name string
+		body map[string]any
+		ok   bool
+		key  string
+		role string
+	}{
+		{"chat with tool messages", map[string]any{"messages": []any{
+			map[string]any{"role": "user", "content": []any{map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:,"}}}},
+			map[string]any{"role": "tool", "tool_call_id": "call_1", "content": 42.0},
+		}}, true, "messages", "system"},
+		{"developer conversation", map[string]any{"messages": []any{map[string]any{"role": "developer", "content": "rules"}}}, true, "messages", "developer"},
+		{"null messages", map[string]any{"messages": nil}, true, "messages", "system"},
+		{"responses items", map[string]any{"input": []any{map[string]any{"role": "user", "content": "hi"}}}, true, "input", "system"},
+		{"messages not an array", map[string]any{"messages": "hello"}, false, "", ""},
+		{"embeddings strings", map[string]any{"input": []any{"a", "b"}}, false, "", ""},
+		{"embeddings string", map[string]any{"input": "a"}, false, "", ""},
+		{"no conversation", map[string]any{"model": "gpt-4o"}, false, "", ""},
+	}
+	for _, tt := range tests {
+		before, _ := tt.body[tt.key].([]any)
+		n := len(before)
+		ok := p.InjectHint(tt.body, "hint")
+		if ok != tt.ok {
+			t.Fatalf("%s: InjectHint = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if !ok {
+			continue
+		}
+		items := tt.body[tt.key].([]any)
+		first := items[0].(map[string]any)
+		if first["role"] != tt.role || first["content"] != "hint" || len(items) != n+1 {
+			t.Fatalf("%s: unexpected %s %v", tt.name, tt.key, items)
+		}
+		if n > 0 && before[0].(map[string]any)["content"] == "hint" {
+			t.Fatalf("%s: expected the original array untouched", tt.name)
+		}
+	}
+
+	body := map[string]any{"input": "hi", "instructions": "be brief"}
+	if !p.InjectHint(body, "hint") || body["instructions"] != "hint\n\nbe brief" {
+		t.Fatalf("expected the hint in the instructions, got %v", body)
+	}
+}
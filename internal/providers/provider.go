@@ -3,6 +3,8 @@ package providers
 import (
 	"net/http"
 	"net/url"
+	"strings"
+	"sync/atomic"
 )
 
 // Provider defines the minimal interface to prepare outbound requests to an LLM API.
@@ -17,6 +19,121 @@ type Provider interface {
 	ParseTokenUsage(body map[string]any) TokenUsage
 }
 
+// KeyRotator is implemented by providers whose API key can be replaced while
+// serving, e.g. when a secret manager rotates it.
+type KeyRotator interface {
+	SetAPIKey(key string)
+}
+
+// APIKey holds a provider API key that may be replaced concurrently with
+// requests reading it. The zero value is an empty key.
+type APIKey struct {
+	key atomic.Pointer[string]
+}
+
+// Get returns the current key.
+func (k *APIKey) Get() string {
+	if key := k.key.Load(); key != nil {
+		return *key
+	}
+	return ""
+}
+
+// Set replaces the key.
+func (k *APIKey) Set(key string) {
+	k.key.Store(&key)
+}
+
+// PathHintInjector is implemented by providers where the best place for a hint
+// depends on the endpoint, e.g. because only some methods accept a system
+// instruction.
+type PathHintInjector interface {
+	InjectHintForPath(path string, body map[string]any, hint string) bool
+}
+
+// InjectHint injects hint into a request body sent to path, using the
+// provider's PathHintInjector when it has one.
+func InjectHint(p Provider, path string, body map[string]any, hint string) bool {
+	if injector, ok := p.(PathHintInjector); ok {
+		return injector.InjectHintForPath(path, body, hint)
+	}
+	return p.InjectHint(body, hint)
+}
+
+// StreamTextExtractor is implemented by providers that can pull generated text
+// out of a streaming chunk. It is used to estimate output tokens when a stream
+// ends without reporting usage.
+type StreamTextExtractor interface {
+	ExtractStreamText(chunk map[string]any) string
+}
+
+// TextPathProvider is implemented by providers whose ExtractFullText reads
+// strings at fixed JSON paths, so large bodies can be estimated with a streaming
+// scan instead of a full decode. "*" in a path matches any array element.
+type TextPathProvider interface {
+	RequestTextPaths() [][]string
+}
+
+// FinishReasonExtractor is implemented by providers that report why generation
+// stopped, in a full response body or in a streaming chunk.
+type FinishReasonExtractor interface {
+	ExtractFinishReasons(body map[string]any) []string
+}
+
+// StructuredOutputProvider is implemented by providers whose requests can ask
+// for JSON output, so responses can be checked against what was requested.
+type StructuredOutputProvider interface {
+	// RequestedJSON reports whether a request body asks for JSON output, and
+	// the JSON Schema the output must match when one is declared.
+	RequestedJSON(body map[string]any) (bool, map[string]any)
+	// ExtractResponseTexts returns the generated text of each choice or
+	// candidate in a full response body.
+	ExtractResponseTexts(body map[string]any) []string
+}
+
+// Normalized finish reasons, comparable across providers.
+const (
+	FinishStop          = "stop"
+	FinishLength        = "length"
+	FinishContentFilter = "content_filter"
+	FinishToolCalls     = "tool_calls"
+	FinishOther         = "other"
+)
+
+// NormalizeFinishReason maps a provider's finish reason (OpenAI finish_reason
+// or incomplete_details.reason, Anthropic stop_reason, Gemini finishReason) to
+// one of the Finish* constants.
+func NormalizeFinishReason(reason string) string {
+	switch strings.ToLower(reason) {
+	case "stop", "end_turn", "stop_sequence":
+		return FinishStop
+	case "length", "max_tokens", "max_output_tokens", "model_context_window_exceeded":
+		return FinishLength
+	case "content_filter", "refusal", "safety", "recitation", "blocklist", "prohibited_content", "spii", "image_safety":
+		return FinishContentFilter
+	case "tool_calls", "function_call", "tool_use":
+		return FinishToolCalls
+	default:
+		return FinishOther
+	}
+}
+
+// ToolCall is a tool invocation made by the model, as replayed in a request's
+// conversation history.
+type ToolCall struct {
+	// ID is the provider-assigned call ID, empty when the format has none.
+	ID   string
+	Name string
+	// Arguments is the call's JSON arguments.
+	Arguments string
+}
+
+// ToolCallExtractor is implemented by providers that can list the tool calls
+// of the latest assistant turn in a request's conversation history.
+type ToolCallExtractor interface {
+	ExtractToolCalls(body map[string]any) []ToolCall
+}
+
 // TokenUsage holds token usage counts.
 type TokenUsage struct {
 	InputTokens  int
@@ -0,0 +1,140 @@
+// Package quotasync periodically compares sentinel-tracked spend with the cost
+// reported by the provider's billing API and flags drift between the two.
+package quotasync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/telemetry"
+)
+
+// CostSource reports what the provider billed for one UTC day.
+type CostSource interface {
+	Provider() string
+	DailyCost(ctx context.Context, day time.Time) (float64, error)
+}
+
+// UsageStore reports what the sentinel recorded for one UTC day.
+type UsageStore interface {
+	DailyUsage(ctx context.Context, provider string, day time.Time) (float64, error)
+}
+
+// Result is one reconciliation of a day's spend.
+type Result struct {
+	Provider     string
+	Day          time.Time
+	SentinelCost float64
+	ProviderCost float64
+	// Drift is (sentinel - provider) / provider; 0 when the provider reports no cost.
+	Drift float64
+}
+
+// Syncer reconciles sentinel and provider spend.
+type Syncer struct {
+	source    CostSource
+	store     UsageStore
+	threshold float64
+	now       func() time.Time
+
+	lastAlerted time.Time
+}
+
+// New returns a Syncer that alerts when |drift| exceeds threshold (e.g. 0.1 = 10%).
+func New(source CostSource, store UsageStore, threshold float64) *Syncer {
+	return &Syncer{source: source, store: store, threshold: threshold, now: time.Now}
+}
+
+// Check reconciles the last complete UTC day. Provider billing lags, so the
+// current day is never compared.
+func (s *Syncer) Check(ctx context.Context) (Result, error) {
+	day := s.now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	res := Result{Provider: s.source.Provider(), Day: day}
+
+	providerCost, err := s.source.DailyCost(ctx, day)
+	if err != nil {
+		return res, fmt.Errorf("provider cost: %w", err)
+	}
+	sentinelCost, err := s.store.DailyUsage(ctx, res.Provider, day)
+	if err != nil {
+		return res, fmt.Errorf("sentinel usage: %w", err)
+	}
+	res.ProviderCost, res.SentinelCost = providerCost, sentinelCost
+	if providerCost > 0 {
+		res.Drift = (sentinelCost - providerCost) / providerCost
+	}
+
+	telemetry.RecordQuotaDrift(ctx, res.Provider, res.Drift)
+	if math.Abs(res.Drift) > s.threshold && !day.Equal(s.lastAlerted) {
+		s.lastAlerted = day
+		slog.Warn("Sentinel spend diverges from provider billing",
+			"provider", res.Provider,
+			"day", day.Format(time.DateOnly),
+			"sentinel_cost", sentinelCost,
+			"provider_cost", providerCost,
+			"drift", res.Drift,
+			"threshold", s.threshold,
+		)
+		events.Record(events.TypeQuotaDrift, "", map[string]any{
+			"provider":      res.Provider,
+			"day":           day.Format(time.DateOnly),
+			"sentinel_cost": sentinelCost,
+			"provider_cost": providerCost,
+			"drift":         res.Drift,
+		})
+	}
+	return res, nil
+}
+
+// Run checks on every interval until ctx is cancelled, starting immediately.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	if s == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if res, err := s.Check(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Quota sync failed", "error", err, "provider", s.source.Provider())
+		} else if err == nil {
+			slog.Debug("Quota sync completed",
+				"provider", res.Provider,
+				"day", res.Day.Format(time.DateOnly),
+				"sentinel_cost", res.SentinelCost,
+				"provider_cost", res.ProviderCost,
+				"drift", res.Drift,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SourceFromEnv returns the billing source for the proxied provider when its
+// admin key is configured (OPENAI_ADMIN_KEY or ANTHROPIC_ADMIN_KEY, read with
+// secret); nil otherwise. Gemini has no billing API and is not supported.
+// transport may be nil.
+func SourceFromEnv(provider string, baseURL *url.URL, transport http.RoundTripper, secret func(name string) string) CostSource {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	switch provider {
+	case "openai":
+		if key := secret("OPENAI_ADMIN_KEY"); key != "" {
+			return &OpenAICosts{BaseURL: baseURL, AdminKey: key, ProjectID: os.Getenv("QUOTA_SYNC_OPENAI_PROJECT_ID"), Client: client}
+		}
+	case "anthropic":
+		if key := secret("ANTHROPIC_ADMIN_KEY"); key != "" {
+			return &AnthropicCosts{BaseURL: baseURL, AdminKey: key, WorkspaceID: os.Getenv("QUOTA_SYNC_ANTHROPIC_WORKSPACE_ID"), Client: client}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package quotasync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/events"
+)
+
+type fakeSource struct {
+	cost float64
+	err  error
+	day  time.Time
+}
+
+func (f *fakeSource) Provider() string { return "openai" }
+func (f *fakeSource) DailyCost(ctx context.Context, day time.Time) (float64, error) {
+	f.day = day
+	return f.cost, f.err
+}
+
+type fakeStore struct{ usage float64 }
+
+func (f *fakeStore) DailyUsage(ctx context.Context, provider string, day time.Time) (float64, error) {
+	return f.usage, nil
+}
+
+func TestCheckComparesPreviousDayAndAlertsOnce(t *testing.T) {
+	source := &fakeSource{cost: 100}
+	s := New(source, &fakeStore{usage: 80}, 0.1)
+	s.now = func() time.Time { return time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC) }
+	before := len(events.Default().Recent(1000, events.TypeQuotaDrift))
+
+	res, err := s.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if want := time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC); !source.day.Equal(want) || !res.Day.Equal(want) {
+		t.Fatalf("expected previous UTC day, got %v", source.day)
+	}
+	if res.Drift != -0.2 {
+		t.Fatalf("expected drift -0.2, got %v", res.Drift)
+	}
+
+	if _, err := s.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := len(events.Default().Recent(1000, events.TypeQuotaDrift)) - before; got != 1 {
+		t.Fatalf("expected a single drift alert per day, got %d", got)
+	}
+}
+
+func TestCheckWithinThresholdDoesNotAlert(t *testing.T) {
+	s := New(&fakeSource{cost: 100}, &fakeStore{usage: 95}, 0.1)
+	before := len(events.Default().Recent(1000, events.TypeQuotaDrift))
+	if _, err := s.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := len(events.Default().Recent(1000, events.TypeQuotaDrift)); got != before {
+		t.Fatalf("expected no alert within threshold")
+	}
+}
+
+func TestCheckSourceError(t *testing.T) {
+	s := New(&fakeSource{err: errors.New("403")}, &fakeStore{}, 0.1)
+	if _, err := s.Check(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestOpenAICostsSumsBuckets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/organization/costs" || r.Header.Get("Authorization") != "Bearer admin" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		if r.URL.Query().Get("project_ids") != "proj_1" {
+			t.Errorf("expected project filter, got %q", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"data":[{"results":[{"amount":{"value":1.25,"currency":"usd"}},{"amount":{"value":0.75,"currency":"usd"}}]}]}`))
+	}))
+	defer server.Close()
+	base, _ := url.Parse(server.URL)
+
+	source := &OpenAICosts{BaseURL: base, AdminKey: "admin", ProjectID: "proj_1"}
+	cost, err := source.DailyCost(context.Background(), time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC))
+	if err != nil || cost != 2 {
+		t.Fatalf("expected cost 2, got %v (err %v)", cost, err)
+	}
+}
+
+func TestAnthropicCostsConvertsCentsAndFiltersWorkspace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/organizations/cost_report" || r.Header.Get("x-api-key") != "admin" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		_, _ = w.Write([]byte(`{"data":[{"results":[{"amount":"250.5","workspace_id":"wrk_1"},{"amount":"999","workspace_id":"wrk_2"}]}]}`))
+	}))
+	defer server.Close()
+	base, _ := url.Parse(server.URL)
+
+	source := &AnthropicCosts{BaseURL: base, AdminKey: "admin", WorkspaceID: "wrk_1"}
+	cost, err := source.DailyCost(context.Background(), time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC))
+	if err != nil || cost != 2.505 {
+		t.Fatalf("expected cost 2.505, got %v (err %v)", cost, err)
+	}
+}
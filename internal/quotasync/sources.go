@@ -0,0 +1,136 @@
+package quotasync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/providers/anthropic"
+)
+
+// OpenAICosts reads the organization Costs API, which requires an admin key.
+type OpenAICosts struct {
+	BaseURL  *url.URL
+	AdminKey string
+	// ProjectID limits the total to the project the proxy's API key belongs to.
+	ProjectID string
+	Client    *http.Client
+}
+
+func (o *OpenAICosts) Provider() string { return "openai" }
+
+func (o *OpenAICosts) DailyCost(ctx context.Context, day time.Time) (float64, error) {
+	q := url.Values{}
+	q.Set("start_time", strconv.FormatInt(day.Unix(), 10))
+	q.Set("end_time", strconv.FormatInt(day.AddDate(0, 0, 1).Unix(), 10))
+	q.Set("bucket_width", "1d")
+	if o.ProjectID != "" {
+		q.Set("project_ids", o.ProjectID)
+	}
+	req, err := newRequest(ctx, o.BaseURL, "/v1/organization/costs", q)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.AdminKey)
+
+	var page struct {
+		Data []struct {
+			Results []struct {
+				Amount struct {
+					Value float64 `json:"value"`
+				} `json:"amount"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := doJSON(o.Client, req, &page); err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, bucket := range page.Data {
+		for _, r := range bucket.Results {
+			total += r.Amount.Value
+		}
+	}
+	return total, nil
+}
+
+// AnthropicCosts reads the organization cost report, which requires an admin key.
+type AnthropicCosts struct {
+	BaseURL  *url.URL
+	AdminKey string
+	// WorkspaceID limits the total to the workspace the proxy's API key belongs to.
+	WorkspaceID string
+	Client      *http.Client
+}
+
+func (a *AnthropicCosts) Provider() string { return "anthropic" }
+
+func (a *AnthropicCosts) DailyCost(ctx context.Context, day time.Time) (float64, error) {
+	q := url.Values{}
+	q.Set("starting_at", day.Format(time.RFC3339))
+	q.Set("ending_at", day.AddDate(0, 0, 1).Format(time.RFC3339))
+	if a.WorkspaceID != "" {
+		q.Set("group_by[]", "workspace_id")
+	}
+	req, err := newRequest(ctx, a.BaseURL, "/v1/organizations/cost_report", q)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("x-api-key", a.AdminKey)
+	req.Header.Set("anthropic-version", anthropic.APIVersion)
+
+	var page struct {
+		Data []struct {
+			Results []struct {
+				// Amount is in the lowest currency unit (cents) as a decimal string.
+				Amount      string `json:"amount"`
+				WorkspaceID string `json:"workspace_id"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	if err := doJSON(a.Client, req, &page); err != nil {
+		return 0, err
+	}
+	var cents float64
+	for _, bucket := range page.Data {
+		for _, r := range bucket.Results {
+			if a.WorkspaceID != "" && r.WorkspaceID != a.WorkspaceID {
+				continue
+			}
+			v, err := strconv.ParseFloat(r.Amount, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse cost amount %q: %w", r.Amount, err)
+			}
+			cents += v
+		}
+	}
+	return cents / 100, nil
+}
+
+func newRequest(ctx context.Context, base *url.URL, path string, q url.Values) (*http.Request, error) {
+	target := *base
+	target.Path = path
+	target.RawQuery = q.Encode()
+	return http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+}
+
+func doJSON(client *http.Client, req *http.Request, out any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned HTTP %d: %s", req.URL.Path, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Actions taken when a request's estimate exceeds the per-request cost ceiling.
+const (
+	CeilingReject = "reject"
+	CeilingClamp  = "clamp"
+)
+
+// RequestCostCeiling caps the estimated cost of any single request so one huge
+// context cannot consume a tenant's whole hourly budget.
+type RequestCostCeiling struct {
+	// Default applies to every tenant without an entry in Tenants.
+	Default *float64           `json:"default,omitempty"`
+	Tenants map[string]float64 `json:"tenants,omitempty"`
+	// Action is "reject" (default) or "clamp": lower the request's max output
+	// tokens so the estimate fits, rejecting only when the input alone exceeds it.
+	Action string `json:"action,omitempty"`
+}
+
+// Validate checks a ceiling definition.
+func (c RequestCostCeiling) Validate() error {
+	if c.Default != nil && *c.Default < 0 {
+		return fmt.Errorf("max_request_cost default must not be negative")
+	}
+	for tenant, v := range c.Tenants {
+		if v < 0 {
+			return fmt.Errorf("max_request_cost for %q must not be negative", tenant)
+		}
+	}
+	switch c.Action {
+	case "", CeilingReject, CeilingClamp:
+		return nil
+	default:
+		return fmt.Errorf("max_request_cost action must be %q or %q, got %q", CeilingReject, CeilingClamp, c.Action)
+	}
+}
+
+// ceilingFromEnv reads MAX_REQUEST_COST (USD, 0 disables) and
+// MAX_REQUEST_COST_ACTION (reject or clamp).
+func ceilingFromEnv() RequestCostCeiling {
+	var c RequestCostCeiling
+	if v, err := strconv.ParseFloat(os.Getenv("MAX_REQUEST_COST"), 64); err == nil && v > 0 {
+		c.Default = &v
+	}
+	if action := os.Getenv("MAX_REQUEST_COST_ACTION"); action == CeilingClamp {
+		c.Action = CeilingClamp
+	}
+	return c
+}
+
+// SetRequestCostCeiling replaces MAX_REQUEST_COST/MAX_REQUEST_COST_ACTION with a
+// file-configured ceiling; nil restores the environment settings.
+func (r *RateLimiter) SetRequestCostCeiling(ceiling *RequestCostCeiling) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.ceilingOverride = ceiling
+	r.mu.Unlock()
+}
+
+// MaxRequestCost returns the tenant's per-request cost ceiling (0 when none) and
+// whether oversized requests should be clamped rather than rejected.
+func (r *RateLimiter) MaxRequestCost(tenantID string) (float64, bool) {
+	if r == nil {
+		return 0, false
+	}
+	r.mu.RLock()
+	c := r.ceiling
+	if r.ceilingOverride != nil {
+		c = *r.ceilingOverride
+	}
+	r.mu.RUnlock()
+
+	clamp := c.Action == CeilingClamp
+	if v, ok := c.Tenants[tenantID]; ok {
+		return v, clamp
+	}
+	if c.Default != nil {
+		return *c.Default, clamp
+	}
+	return 0, clamp
+}
+
+// MaxOutputTokensWithin returns the largest output token count that keeps a
+// request with inputTokens within ceiling, or 0 when the input alone exceeds it.
+func MaxOutputTokensWithin(ceiling float64, inputTokens int, pricing Pricing) int {
+	remaining := ceiling - CalculateCost(inputTokens, 0, pricing)
+	if remaining <= 0 {
+		return 0
+	}
+	if pricing.OutputPrice <= 0 {
+		return MaxOutputEstimate
+	}
+	// The epsilon absorbs float error so an exact fit is not rounded down a token.
+	return int(math.Floor(remaining/pricing.OutputPrice*1_000_000 + 1e-6))
+}
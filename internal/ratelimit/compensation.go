@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+)
+
+// Estimates are made before the response is known, so when actual cost regularly
+// exceeds the estimate, concurrent requests across replicas can all pass the check
+// and together overshoot the limit once adjusted. Compensation learns a per-tenant
+// actual/estimate ratio (EWMA, stored in Redis so every replica shares it) and
+// reserves estimate*ratio instead. Overshoot is then bounded by the in-flight
+// requests' residual estimation error rather than the full drift.
+
+const (
+	defaultCompensationAlpha     = 0.2
+	defaultCompensationMaxFactor = 4.0
+)
+
+type compensationConfig struct {
+	enabled   bool
+	alpha     float64
+	maxFactor float64
+}
+
+func (c compensationConfig) flag() string {
+	if c.enabled {
+		return "1"
+	}
+	return "0"
+}
+
+func estimateRatioKey(tenantID string) string {
+	return "estratio:" + tenantID
+}
+
+// compensationFromEnv reads ESTIMATE_COMPENSATION_ENABLED (default false),
+// ESTIMATE_COMPENSATION_ALPHA (EWMA weight, default 0.2) and
+// ESTIMATE_COMPENSATION_MAX_FACTOR (cap on the multiplier, default 4).
+func compensationFromEnv() compensationConfig {
+	cfg := compensationConfig{
+		alpha:     defaultCompensationAlpha,
+		maxFactor: defaultCompensationMaxFactor,
+	}
+	if v, err := strconv.ParseBool(os.Getenv("ESTIMATE_COMPENSATION_ENABLED")); err == nil {
+		cfg.enabled = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("ESTIMATE_COMPENSATION_ALPHA"), 64); err == nil && v > 0 && v <= 1 {
+		cfg.alpha = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("ESTIMATE_COMPENSATION_MAX_FACTOR"), 64); err == nil && v >= 1 {
+		cfg.maxFactor = v
+	}
+	return cfg
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/telemetry"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Accounting modes selected with ACCOUNTING_MODE.
+const (
+	// AccountingWindow enforces an hourly spend limit over rolling minute buckets.
+	AccountingWindow = "window"
+	// AccountingPrepaid debits a prepaid credit balance per tenant.
+	AccountingPrepaid = "prepaid"
+)
+
+func accountingModeFromEnv() string {
+	if strings.EqualFold(os.Getenv("ACCOUNTING_MODE"), AccountingPrepaid) {
+		return AccountingPrepaid
+	}
+	return AccountingWindow
+}
+
+// creditsKey holds a tenant's credit balance in integer micro-cents.
+func creditsKey(tenantID string) string {
+	return "ucredits:" + tenantID
+}
+
+// legacyCreditsKey is the float USD balance used before micro-cent accounting;
+// scripts fold it into creditsKey the first time they touch the tenant.
+func legacyCreditsKey(tenantID string) string {
+	return "credits:" + tenantID
+}
+
+// AccountingMode returns the limiter's accounting mode.
+func (r *RateLimiter) AccountingMode() string {
+	if r == nil || r.accountingMode == "" {
+		return AccountingWindow
+	}
+	return r.accountingMode
+}
+
+// checkCreditsLUA places a hold for the estimate on the tenant's credit balance.
+// The hold is settled by adjustCostLUA (prepaid) or refunded by the reconciler.
+// Spend is still recorded in minute buckets so spend APIs keep working.
+// ARGV[1] is in micro-cents; the balance is returned in USD.
+const checkCreditsLUA = microUnitsLUA + `
+local creditsKey = KEYS[1]
+local spendKey = KEYS[2]
+local ledgerKey = KEYS[3]
+local reservationKey = KEYS[4]
+local shadowKey = KEYS[5]
+local estimatedCost = tonumber(ARGV[1])
+local reservationID = ARGV[2]
+local reservationTTL = tonumber(ARGV[3])
+local tenantID = ARGV[4]
+migrateCredits(creditsKey, KEYS[6])
+
+local redisTime = redis.call('TIME')
+local now = tonumber(redisTime[1])
+local minuteBucket = math.floor(now / 60) * 60
+
+local balance = tonumber(redis.call('GET', creditsKey) or '0') or 0
+local allowed = balance >= estimatedCost
+local shadowed = false
+if not allowed and redis.call('EXISTS', shadowKey) == 1 then
+  allowed = true
+  shadowed = true
+end
+
+if allowed then
+  balance = redis.call('INCRBY', creditsKey, fmtMicros(-estimatedCost))
+  migrateSpend(spendKey)
+  redis.call('HINCRBY', spendKey, tostring(minuteBucket), fmtMicros(estimatedCost))
+  redis.call('EXPIRE', spendKey, 7200)
+
+  redis.call('HSET', reservationKey, 'tenant', tenantID, 'estimate', fmtMicros(estimatedCost), 'unit', 'ucent', 'bucket', tostring(minuteBucket), 'mode', 'prepaid')
+  redis.call('EXPIRE', reservationKey, reservationTTL * 2)
+  redis.call('ZADD', ledgerKey, now + reservationTTL, reservationID)
+end
+
+return {allowed and 1 or 0, tostring(balance / MICROS), shadowed and 1 or 0}
+`
+
+// checkCredits is CheckLimitAndIncrement for prepaid accounting. Limit reports the
+// balance before the hold and Remaining the balance after it.
+func (r *RateLimiter) checkCredits(ctx context.Context, tenantID string, estimatedCost float64, reservationTTL time.Duration) (*CheckLimitResult, error) {
+	reservationID := newReservationID()
+	client := r.client.Client()
+	script := redis.NewScript(checkCreditsLUA)
+	start := time.Now()
+	result, err := runScript(ctx, script, client,
+		r.client.Keys(creditsKey(tenantID), fmt.Sprintf("spend:%s", tenantID), reservationLedgerKey, reservationKey(reservationID), shadowModeKey, legacyCreditsKey(tenantID)),
+		ToMicros(estimatedCost), reservationID, int64(reservationTTL.Seconds()), tenantID)
+	if err != nil {
+		telemetry.ObserveRedisLatency(ctx, "check_credits", r.client.Backend(), "error", time.Since(start), tenantID)
+		telemetry.IncRedisError(ctx, "check_credits", r.client.Backend(), tenantID)
+		slog.Warn("Redis error in credit check, failing open",
+			"error", err,
+			"tenant_id", tenantID,
+		)
+		return &CheckLimitResult{Allowed: true, Prepaid: true, LimitedBy: tenantID}, nil
+	}
+	telemetry.ObserveRedisLatency(ctx, "check_credits", r.client.Backend(), "ok", time.Since(start), tenantID)
+
+	results := result.([]any)
+	allowed := results[0].(int64) == 1
+	balance := toFloat64(results[1])
+	res := &CheckLimitResult{
+		Allowed:   allowed,
+		Limit:     balance,
+		Remaining: max(0, balance),
+		Reserved:  estimatedCost,
+		Prepaid:   true,
+		LimitedBy: tenantID,
+	}
+	if len(results) > 2 {
+		res.Shadowed = results[2].(int64) == 1
+	}
+	if allowed {
+		res.Limit = balance + estimatedCost
+		res.ReservationID = reservationID
+	}
+	return res, nil
+}
+
+// GetCredits returns a tenant's prepaid credit balance (0 if never topped up).
+// A legacy float balance not yet migrated is included.
+func (r *RateLimiter) GetCredits(ctx context.Context, tenantID string) (float64, error) {
+	if r == nil || r.client == nil {
+		return 0, errLimiterUnavailable
+	}
+	values, err := r.client.Client().MGet(ctx, r.client.Keys(creditsKey(tenantID), legacyCreditsKey(tenantID))...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var balance float64
+	if v, ok := values[0].(string); ok {
+		micros, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		balance += FromMicros(micros)
+	}
+	if v, ok := values[1].(string); ok {
+		legacy, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, err
+		}
+		balance += legacy
+	}
+	return balance, nil
+}
+
+// topUpCreditsLUA adds ARGV[1] micro-cents to the balance, migrating a legacy
+// float balance first, and returns the new balance in micro-cents.
+const topUpCreditsLUA = microUnitsLUA + `
+migrateCredits(KEYS[1], KEYS[2])
+return redis.call('INCRBY', KEYS[1], ARGV[1])
+`
+
+// TopUpCredits adds amount (may be negative for corrections) to a tenant's
+// balance and returns the new balance.
+func (r *RateLimiter) TopUpCredits(ctx context.Context, tenantID string, amount float64) (float64, error) {
+	if r == nil || r.client == nil {
+		return 0, errLimiterUnavailable
+	}
+	result, err := runScript(ctx, redis.NewScript(topUpCreditsLUA), r.client.Client(),
+		r.client.Keys(creditsKey(tenantID), legacyCreditsKey(tenantID)), ToMicros(amount))
+	if err != nil {
+		return 0, err
+	}
+	micros, _ := result.(int64)
+	return FromMicros(micros), nil
+}
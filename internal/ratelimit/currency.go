@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Spend and credit balances are stored in Redis as integer micro-cents
+// (1e-8 USD) so thousands of adjustments do not accumulate float rounding
+// drift. Pricing, limits and the Go API stay in float64 USD; amounts are
+// converted at the script boundary.
+const microsPerUSD = 1e8
+
+// ToMicros rounds a USD amount to the integer micro-cents Redis stores
+// amounts in.
+func ToMicros(usd float64) int64 {
+	return int64(math.Round(usd * microsPerUSD))
+}
+
+// FromMicros converts integer micro-cents to USD.
+func FromMicros(micros int64) float64 {
+	return float64(micros) / microsPerUSD
+}
+
+// microUnitsLUA is prepended to scripts that touch spend or credits. Spend
+// hashes written before micro-cent accounting hold float USD; migrateSpend
+// converts one in place the first time a script touches it and marks it with
+// unit=ucent. Credit balances moved to a new key; migrateCredits folds a
+// legacy float balance into it. Reservations carry unit=ucent the same way.
+// Lua numbers are doubles, so values are exact up to 2^53 micro-cents (~$90M).
+const microUnitsLUA = `
+local MICROS = 100000000
+
+local function ToMicros(usd)
+  return math.floor(usd * MICROS + 0.5)
+end
+
+local function fmtMicros(n)
+  return string.format('%d', n)
+end
+
+local function migrateSpend(key)
+  if redis.call('HSETNX', key, 'unit', 'ucent') == 0 then
+    return
+  end
+  local fields = redis.call('HGETALL', key)
+  for i = 1, #fields, 2 do
+    if fields[i] ~= 'unit' then
+      redis.call('HSET', key, fields[i], fmtMicros(ToMicros(tonumber(fields[i + 1]) or 0)))
+    end
+  end
+  redis.call('EXPIRE', key, 7200)
+end
+
+local function migrateCredits(key, legacyKey)
+  local legacy = redis.call('GET', legacyKey)
+  if legacy then
+    redis.call('INCRBY', key, fmtMicros(ToMicros(tonumber(legacy) or 0)))
+    redis.call('DEL', legacyKey)
+  end
+end
+
+local function reservedMicros(reservationKey)
+  local fields = redis.call('HMGET', reservationKey, 'estimate', 'unit')
+  if not fields[1] then
+    return nil
+  end
+  if fields[2] == 'ucent' then
+    return tonumber(fields[1])
+  end
+  return ToMicros(tonumber(fields[1]) or 0)
+end
+`
+
+// spendUnitField marks a spend hash whose buckets are in micro-cents.
+const spendUnitField = "unit"
+
+// sumSpendBuckets totals the buckets at or after since in a spend hash read
+// with HGETALL, handling hashes not yet migrated to micro-cents.
+func sumSpendBuckets(buckets map[string]string, since int64) float64 {
+	micro := buckets[spendUnitField] == "ucent"
+	var total float64
+	for bucketTimeStr, costStr := range buckets {
+		bucketTime, err := strconv.ParseInt(bucketTimeStr, 10, 64)
+		if err != nil || bucketTime < since {
+			continue
+		}
+		if micro {
+			if cost, err := strconv.ParseInt(costStr, 10, 64); err == nil {
+				total += FromMicros(cost)
+			}
+		} else if cost, err := strconv.ParseFloat(costStr, 64); err == nil {
+			total += cost
+		}
+	}
+	return total
+}
+
+// DisplayCurrency converts USD amounts for reporting. Accounting, limits and
+// pricing are always USD.
+type DisplayCurrency struct {
+	Code string  // ISO 4217 code, e.g. EUR
+	Rate float64 // units of Code per USD
+}
+
+// Convert returns usd in the display currency.
+func (c DisplayCurrency) Convert(usd float64) float64 {
+	return usd * c.Rate
+}
+
+// DisplayCurrencyFromEnv reads DISPLAY_CURRENCY and its rate from
+// EXCHANGE_RATES (comma-separated CODE=units-per-USD, e.g. "EUR=0.92,GBP=0.79").
+// ok is false when no display currency (or USD) is configured.
+func DisplayCurrencyFromEnv() (currency DisplayCurrency, ok bool, err error) {
+	code := strings.ToUpper(strings.TrimSpace(os.Getenv("DISPLAY_CURRENCY")))
+	if code == "" || code == "USD" {
+		return DisplayCurrency{}, false, nil
+	}
+	for _, pair := range strings.Split(os.Getenv("EXCHANGE_RATES"), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), code) {
+			continue
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate <= 0 {
+			return DisplayCurrency{}, false, fmt.Errorf("invalid exchange rate for %s: %q", code, value)
+		}
+		return DisplayCurrency{Code: code, Rate: rate}, true, nil
+	}
+	return DisplayCurrency{}, false, fmt.Errorf("EXCHANGE_RATES has no rate for %s", code)
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import "testing"
+
+func TestMicrosDoNotDrift(t *testing.T) {
+	var micros int64
+	var usd float64
+	for range 10000 {
+		micros += ToMicros(0.0001)
+		usd += 0.0001
+	}
+	if got := FromMicros(micros); got != 1 {
+		t.Fatalf("micro-cent total = %v, want exactly 1", got)
+	}
+	if usd == 1 {
+		t.Fatal("expected float accumulation to drift; test no longer demonstrates the problem")
+	}
+}
+
+func TestSumSpendBucketsReadsLegacyAndMicroHashes(t *testing.T) {
+	legacy := map[string]string{"60": "0.25", "120": "1.5", "0": "9"}
+	if got := sumSpendBuckets(legacy, 60); got != 1.75 {
+		t.Fatalf("legacy sum = %v, want 1.75", got)
+	}
+	micro := map[string]string{spendUnitField: "ucent", "60": "25000000", "120": "150000000"}
+	if got := sumSpendBuckets(micro, 60); got != 1.75 {
+		t.Fatalf("micro-cent sum = %v, want 1.75", got)
+	}
+}
+
+func TestDisplayCurrencyFromEnv(t *testing.T) {
+	t.Setenv("DISPLAY_CURRENCY", "eur")
+	t.Setenv("EXCHANGE_RATES", "GBP=0.79, EUR=0.92")
+	c, ok, err := DisplayCurrencyFromEnv()
+	if err != nil || !ok || c.Code != "EUR" || c.Rate != 0.92 {
+		t.Fatalf("got %+v, %v, %v", c, ok, err)
+	}
+
+	t.Setenv("DISPLAY_CURRENCY", "JPY")
+	if _, _, err := DisplayCurrencyFromEnv(); err == nil {
+		t.Fatal("expected an error for a currency without a rate")
+	}
+	t.Setenv("DISPLAY_CURRENCY", "USD")
+	if _, ok, err := DisplayCurrencyFromEnv(); ok || err != nil {
+		t.Fatalf("USD needs no conversion, got %v, %v", ok, err)
+	}
+}
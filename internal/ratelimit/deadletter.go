@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/telemetry"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// deadLetterKey is a Redis list of settlements (cost adjustments and refunds) that
+// failed to apply. New entries are pushed on the left and retried from the right.
+const deadLetterKey = "settlements:dlq"
+
+const (
+	// deadLetterBatchSize bounds how many entries are retried per pass.
+	deadLetterBatchSize = 100
+	// maxDeadLetterAttempts drops an entry that keeps failing (e.g. a WRONGTYPE
+	// key) so it cannot block the queue forever.
+	maxDeadLetterAttempts = 50
+	// maxLocalDeadLetters bounds the in-memory buffer used while Redis itself is
+	// unreachable.
+	maxLocalDeadLetters = 10000
+)
+
+// Settlement operations recorded in the dead-letter queue.
+const (
+	OpAdjustCost     = "adjust_cost"
+	OpRefundEstimate = "refund_estimate"
+)
+
+// PendingSettlement is a failed cost adjustment or refund awaiting retry.
+type PendingSettlement struct {
+	Op            string    `json:"op"`
+	TenantID      string    `json:"tenant_id"`
+	ReservationID string    `json:"reservation_id,omitempty"`
+	Estimate      float64   `json:"estimate"`
+	Actual        float64   `json:"actual"`
+	Attempts      int       `json:"attempts"`
+	FailedAt      time.Time `json:"failed_at"`
+	LastError     string    `json:"last_error"`
+}
+
+// deadLetterBuffer holds settlements that could not be written to the Redis
+// dead-letter list; the retrier moves them there once Redis is back.
+type deadLetterBuffer struct {
+	mu      sync.Mutex
+	entries []PendingSettlement
+}
+
+var (
+	defaultPushDeadLetter = func(ctx context.Context, client redis.UniversalClient, key string, payload []byte) error {
+		return client.LPush(ctx, key, payload).Err()
+	}
+
+	pushDeadLetter = defaultPushDeadLetter
+)
+
+// deadLetter records a failed settlement so the money is retried rather than lost.
+func (r *RateLimiter) deadLetter(p PendingSettlement, cause error) {
+	p.Attempts++
+	p.LastError = cause.Error()
+	if p.FailedAt.IsZero() {
+		p.FailedAt = time.Now().UTC()
+	}
+	if p.Attempts >= maxDeadLetterAttempts {
+		slog.Error("Dropping settlement after repeated failures",
+			"op", p.Op,
+			"tenant_id", p.TenantID,
+			"reservation_id", p.ReservationID,
+			"estimate", p.Estimate,
+			"actual", p.Actual,
+			"attempts", p.Attempts,
+		)
+		return
+	}
+
+	payload, err := json.Marshal(p)
+	if err == nil {
+		// The request context may already be done; the push must still happen.
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err = pushDeadLetter(ctx, r.client.Client(), r.client.Key(deadLetterKey), payload)
+		cancel()
+	}
+	if err == nil {
+		return
+	}
+
+	r.deadLetters.mu.Lock()
+	defer r.deadLetters.mu.Unlock()
+	if len(r.deadLetters.entries) >= maxLocalDeadLetters {
+		slog.Error("Dead-letter buffer full, dropping settlement",
+			"op", p.Op,
+			"tenant_id", p.TenantID,
+			"estimate", p.Estimate,
+			"actual", p.Actual,
+		)
+		return
+	}
+	r.deadLetters.entries = append(r.deadLetters.entries, p)
+}
+
+// RetryDeadLetters re-applies failed settlements. It stops at the first failure,
+// since Redis is most likely still unavailable. Safe to run on every proxy
+// instance: each entry is popped by exactly one of them.
+// Returns the number of settlements applied.
+func (r *RateLimiter) RetryDeadLetters(ctx context.Context) (int, error) {
+	if r == nil || r.client == nil {
+		return 0, nil
+	}
+
+	// Move locally buffered entries into Redis first so every replica can see them.
+	r.deadLetters.mu.Lock()
+	local := r.deadLetters.entries
+	r.deadLetters.entries = nil
+	r.deadLetters.mu.Unlock()
+	for i, p := range local {
+		payload, _ := json.Marshal(p)
+		if err := pushDeadLetter(ctx, r.client.Client(), r.client.Key(deadLetterKey), payload); err != nil {
+			r.deadLetters.mu.Lock()
+			r.deadLetters.entries = append(local[i:], r.deadLetters.entries...)
+			r.deadLetters.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	client := r.client.Client()
+	applied := 0
+	for range deadLetterBatchSize {
+		payload, err := client.RPop(ctx, r.client.Key(deadLetterKey)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		var p PendingSettlement
+		if err := json.Unmarshal(payload, &p); err != nil {
+			slog.Warn("Dropping malformed dead-letter entry", "error", err)
+			continue
+		}
+		if err := r.settle(ctx, p.Op, p.TenantID, p.ReservationID, p.Estimate, p.Actual); err != nil {
+			r.deadLetter(p, err)
+			return applied, err
+		}
+		applied++
+		slog.Info("Applied dead-lettered settlement",
+			"op", p.Op,
+			"tenant_id", p.TenantID,
+			"reservation_id", p.ReservationID,
+			"attempts", p.Attempts,
+		)
+	}
+	return applied, nil
+}
+
+// PendingSettlements returns up to limit failed settlements awaiting retry, oldest
+// first, including any buffered locally while Redis was unreachable.
+func (r *RateLimiter) PendingSettlements(ctx context.Context, limit int) ([]PendingSettlement, error) {
+	if r == nil || r.client == nil {
+		return nil, nil
+	}
+
+	r.deadLetters.mu.Lock()
+	pending := append([]PendingSettlement(nil), r.deadLetters.entries...)
+	r.deadLetters.mu.Unlock()
+
+	raw, err := r.client.Client().LRange(ctx, r.client.Key(deadLetterKey), -int64(limit), -1).Result()
+	if err != nil {
+		return pending, err
+	}
+	// The list is newest-first; walk it backwards for oldest-first output.
+	for i := len(raw) - 1; i >= 0; i-- {
+		var p PendingSettlement
+		if err := json.Unmarshal([]byte(raw[i]), &p); err == nil {
+			pending = append(pending, p)
+		}
+	}
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+// RunDeadLetterRetrier periodically retries failed settlements until ctx is cancelled.
+func (r *RateLimiter) RunDeadLetterRetrier(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if _, err := r.RetryDeadLetters(ctx); err != nil && ctx.Err() == nil {
+				telemetry.ObserveRedisLatency(ctx, "retry_dead_letters", r.client.Backend(), "error", time.Since(start), "")
+				slog.Warn("Dead-letter retry failed", "error", err)
+				continue
+			}
+			telemetry.ObserveRedisLatency(ctx, "retry_dead_letters", r.client.Backend(), "ok", time.Since(start), "")
+		}
+	}
+}
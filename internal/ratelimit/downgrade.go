@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DowngradePolicy rewrites requests to a cheaper model when a tenant's budget
+// runs low, instead of denying them outright.
+type DowngradePolicy struct {
+	// Threshold is the fraction of the limit (0-1) that must remain after a
+	// request; below it, the request is switched to the cheaper model. With 0,
+	// only requests that would otherwise be denied are downgraded.
+	Threshold float64 `json:"threshold"`
+	// Models maps a requested model to its cheaper replacement.
+	Models map[string]string `json:"models"`
+	// OptOut lists tenants that are never downgraded.
+	OptOut []string `json:"opt_out,omitempty"`
+}
+
+// Validate checks a downgrade policy.
+func (p DowngradePolicy) Validate() error {
+	if p.Threshold < 0 || p.Threshold >= 1 {
+		return fmt.Errorf("downgrade threshold must be in [0, 1), got %v", p.Threshold)
+	}
+	for from, to := range p.Models {
+		if from == "" || to == "" || from == to {
+			return fmt.Errorf("downgrade models: invalid mapping %q -> %q", from, to)
+		}
+	}
+	return nil
+}
+
+// SetDowngradePolicy replaces the downgrade policy; nil disables downgrades.
+func (r *RateLimiter) SetDowngradePolicy(policy *DowngradePolicy) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.downgrade = policy
+	r.mu.Unlock()
+}
+
+// DowngradeFor returns the cheaper model to retry with when result shows the
+// tenant's budget is exhausted or below the policy threshold. Shadow-mode
+// results are never downgraded, and neither is a model without a mapping.
+func (r *RateLimiter) DowngradeFor(tenantID, model string, result *CheckLimitResult) (string, bool) {
+	if r == nil || result == nil || result.Shadowed {
+		return "", false
+	}
+	r.mu.RLock()
+	policy := r.downgrade
+	r.mu.RUnlock()
+	if policy == nil || slices.Contains(policy.OptOut, tenantID) {
+		return "", false
+	}
+	target, ok := policy.Models[model]
+	if !ok {
+		return "", false
+	}
+	if !result.Allowed {
+		return target, true
+	}
+	// Prepaid results carry the balance, not a limit, so only denials apply.
+	if result.Prepaid || result.Limit <= 0 {
+		return "", false
+	}
+	return target, result.Remaining < policy.Threshold*result.Limit
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultEtcdKeyPrefix = "agent-sentinel/spend/"
+
+// EtcdStore is a SpendStore on etcd v3, spoken through its JSON gateway
+// (/v3/kv/range and /v3/kv/txn) so no etcd client dependency is needed.
+// Conditional writes compare the key's mod_revision, which etcd reports as 0
+// for a key that does not exist.
+type EtcdStore struct {
+	endpoint string
+	prefix   string
+	client   *http.Client
+}
+
+// EtcdStoreFromEnv reads ETCD_ENDPOINT (e.g. http://etcd:2379), ETCD_KEY_PREFIX
+// (default agent-sentinel/spend/) and ETCD_TIMEOUT_MS (default 2000).
+func EtcdStoreFromEnv() (*EtcdStore, error) {
+	endpoint := strings.TrimRight(os.Getenv("ETCD_ENDPOINT"), "/")
+	if endpoint == "" {
+		return nil, errors.New("ETCD_ENDPOINT is not set")
+	}
+	prefix := os.Getenv("ETCD_KEY_PREFIX")
+	if prefix == "" {
+		prefix = defaultEtcdKeyPrefix
+	}
+	timeout := 2 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("ETCD_TIMEOUT_MS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Millisecond
+	}
+	return NewEtcdStore(endpoint, prefix, &http.Client{Timeout: timeout}), nil
+}
+
+// NewEtcdStore returns a store keeping each tenant under prefix+tenantID.
+func NewEtcdStore(endpoint, prefix string, client *http.Client) *EtcdStore {
+	return &EtcdStore{endpoint: endpoint, prefix: prefix, client: client}
+}
+
+type etcdKeyValue struct {
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+// Load returns the tenant's state and the key's mod_revision.
+func (e *EtcdStore) Load(ctx context.Context, tenantID string) (*TenantSpend, int64, error) {
+	var resp struct {
+		Kvs []etcdKeyValue `json:"kvs"`
+	}
+	if err := e.call(ctx, "/v3/kv/range", map[string]any{"key": e.key(tenantID)}, &resp); err != nil {
+		return nil, 0, err
+	}
+	state := &TenantSpend{}
+	if len(resp.Kvs) == 0 {
+		return state, 0, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(resp.Kvs[0].Value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode etcd value: %w", err)
+	}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, 0, fmt.Errorf("decode tenant spend: %w", err)
+	}
+	return state, resp.Kvs[0].ModRevision, nil
+}
+
+// CompareAndSwap puts state in a transaction guarded by mod_revision == revision.
+func (e *EtcdStore) CompareAndSwap(ctx context.Context, tenantID string, revision int64, state *TenantSpend) (bool, error) {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+	key := e.key(tenantID)
+	txn := map[string]any{
+		"compare": []map[string]any{{
+			"key":          key,
+			"target":       "MOD",
+			"result":       "EQUAL",
+			"mod_revision": strconv.FormatInt(revision, 10),
+		}},
+		"success": []map[string]any{{
+			"request_put": map[string]any{"key": key, "value": base64.StdEncoding.EncodeToString(raw)},
+		}},
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call(ctx, "/v3/kv/txn", txn, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (e *EtcdStore) key(tenantID string) string {
+	return base64.StdEncoding.EncodeToString([]byte(e.prefix + tenantID))
+}
+
+func (e *EtcdStore) call(ctx context.Context, path string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s: %s: %s", path, resp.Status, bytes.TrimSpace(data))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/experiments"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// experimentStatsTTL bounds how long an experiment's totals outlive its last request.
+const experimentStatsTTL = 30 * 24 * time.Hour
+
+// experimentKey holds the outcome totals for one variant of an experiment.
+func experimentKey(experiment, variant string) string {
+	return "experiment:" + experiment + ":" + variant
+}
+
+// RecordExperiment adds one request's outcome to the variant's totals.
+func (r *RateLimiter) RecordExperiment(ctx context.Context, experiment, variant string, cost float64, latency time.Duration, failed bool) error {
+	if r == nil || r.client == nil {
+		return nil
+	}
+	key := r.client.Key(experimentKey(experiment, variant))
+	pipe := r.client.Client().TxPipeline()
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	if failed {
+		pipe.HIncrBy(ctx, key, "errors", 1)
+	}
+	if cost > 0 {
+		pipe.HIncrByFloat(ctx, key, "cost", cost)
+	}
+	pipe.HIncrByFloat(ctx, key, "latency_ms", float64(latency.Microseconds())/1000)
+	pipe.Expire(ctx, key, experimentStatsTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ExperimentStats returns the totals for the given variants of experiment.
+func (r *RateLimiter) ExperimentStats(ctx context.Context, experiment string, variants []string) (map[string]experiments.VariantStats, error) {
+	if r == nil || r.client == nil {
+		return nil, nil
+	}
+	stats := make(map[string]experiments.VariantStats, len(variants))
+	err := r.client.read(ctx, func(client redis.UniversalClient) error {
+		for _, variant := range variants {
+			fields, err := client.HGetAll(ctx, r.client.Key(experimentKey(experiment, variant))).Result()
+			if err != nil {
+				return err
+			}
+			requests, _ := strconv.ParseInt(fields["requests"], 10, 64)
+			errs, _ := strconv.ParseInt(fields["errors"], 10, 64)
+			cost, _ := strconv.ParseFloat(fields["cost"], 64)
+			latency, _ := strconv.ParseFloat(fields["latency_ms"], 64)
+			stats[variant] = experiments.VariantStats{
+				Requests:       requests,
+				Errors:         errs,
+				TotalCost:      cost,
+				TotalLatencyMs: latency,
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
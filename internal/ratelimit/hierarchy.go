@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// maxHierarchyDepth bounds how many ancestors are enforced per request
+// (e.g. org -> team -> agent uses two).
+const maxHierarchyDepth = 8
+
+// SetHierarchy replaces the child -> parent tenant mapping. A child's spend is
+// also charged to every ancestor, and a request is denied if any level is over
+// its limit.
+func (r *RateLimiter) SetHierarchy(parents map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.parents = parents
+	r.mu.Unlock()
+}
+
+// ancestors returns the tenant's parent chain, nearest first. Cycles and chains
+// deeper than maxHierarchyDepth are truncated.
+func (r *RateLimiter) ancestors(tenantID string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.parents) == 0 {
+		return nil
+	}
+
+	var chain []string
+	seen := map[string]bool{tenantID: true}
+	for current := tenantID; len(chain) < maxHierarchyDepth; {
+		parent, ok := r.parents[current]
+		if !ok || parent == "" {
+			break
+		}
+		if seen[parent] {
+			slog.Warn("Tenant hierarchy cycle, truncating", "tenant_id", tenantID, "parent", parent)
+			break
+		}
+		seen[parent] = true
+		chain = append(chain, parent)
+		current = parent
+	}
+	return chain
+}
+
+// adjustKeys returns the keys for adjustCostLUA: ancestor spend keys in window
+// mode, or the credit balance (and its legacy key) in prepaid mode.
+func (r *RateLimiter) adjustKeys(tenantID, reservationID string) []string {
+	keys := []string{fmt.Sprintf("spend:%s", tenantID), reservationLedgerKey, reservationKey(reservationID), estimateRatioKey(tenantID)}
+	if r.AccountingMode() == AccountingPrepaid {
+		return r.client.Keys(append(keys, creditsKey(tenantID), legacyCreditsKey(tenantID))...)
+	}
+	for _, ancestor := range r.ancestors(tenantID) {
+		keys = append(keys, fmt.Sprintf("spend:%s", ancestor))
+	}
+	return r.client.Keys(keys...)
+}
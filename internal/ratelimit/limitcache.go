@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Tenant limits change rarely but every check used to GET limit:{tenant} for each
+// level of the hierarchy. The limit cache resolves them in-process instead and
+// passes the effective limits to the check script, which then skips its GETs.
+// Entries expire after a short TTL; SetLimit/PurgeTenant invalidate locally and
+// keyspace notifications (when enabled on the server) invalidate other replicas.
+
+const (
+	defaultLimitCacheTTL  = 5 * time.Second
+	defaultLimitCacheSize = 10000
+)
+
+// limitEntry is a cached limit:{tenant} value; set is false when the key is absent.
+type limitEntry struct {
+	tenantID string
+	limit    float64
+	set      bool
+	expires  time.Time
+}
+
+// limitCache is a bounded LRU of Redis-stored tenant limits. Safe for concurrent use.
+type limitCache struct {
+	ttl      time.Duration
+	capacity int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+func newLimitCache(ttl time.Duration, capacity int) *limitCache {
+	if ttl <= 0 || capacity <= 0 {
+		return nil
+	}
+	return &limitCache{
+		ttl:      ttl,
+		capacity: capacity,
+		now:      time.Now,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// limitCacheFromEnv reads LIMIT_CACHE_TTL_SECONDS (default 5, 0 disables) and
+// LIMIT_CACHE_SIZE (max tenants, default 10000).
+func limitCacheFromEnv() *limitCache {
+	ttl := defaultLimitCacheTTL
+	if v := os.Getenv("LIMIT_CACHE_TTL_SECONDS"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			ttl = time.Duration(secs * float64(time.Second))
+		}
+	}
+	size := defaultLimitCacheSize
+	if v := os.Getenv("LIMIT_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			size = n
+		}
+	}
+	return newLimitCache(ttl, size)
+}
+
+// get returns the cached Redis limit for tenantID; ok is false on a miss.
+func (c *limitCache) get(tenantID string) (limit float64, set, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.items[tenantID]
+	if !found {
+		return 0, false, false
+	}
+	entry := el.Value.(*limitEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.items, tenantID)
+		return 0, false, false
+	}
+	c.order.MoveToFront(el)
+	return entry.limit, entry.set, true
+}
+
+func (c *limitCache) put(tenantID string, limit float64, set bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &limitEntry{tenantID: tenantID, limit: limit, set: set, expires: c.now().Add(c.ttl)}
+	if el, found := c.items[tenantID]; found {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[tenantID] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*limitEntry).tenantID)
+	}
+}
+
+func (c *limitCache) invalidate(tenantID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.items[tenantID]; found {
+		c.order.Remove(el)
+		delete(c.items, tenantID)
+	}
+}
+
+func (c *limitCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// resolveLimits returns the effective limit for each tenant: the Redis-stored
+// limit when one is set, otherwise the matching fallback. Cache misses are
+// fetched in one pipeline. ok is false when the cache is disabled or Redis
+// could not be read, in which case the check script reads the limits itself.
+func (r *RateLimiter) resolveLimits(ctx context.Context, tenantIDs []string, fallbacks []float64) (limits []float64, ok bool) {
+	if r.limits == nil {
+		return nil, false
+	}
+
+	limits = make([]float64, len(tenantIDs))
+	var missing []int
+	for i, tenantID := range tenantIDs {
+		limit, set, hit := r.limits.get(tenantID)
+		switch {
+		case !hit:
+			missing = append(missing, i)
+		case set:
+			limits[i] = limit
+		default:
+			limits[i] = fallbacks[i]
+		}
+	}
+	if len(missing) == 0 {
+		return limits, true
+	}
+
+	var cmds []*redis.StringCmd
+	err := r.client.read(ctx, func(client redis.UniversalClient) error {
+		pipe := client.Pipeline()
+		cmds = cmds[:0]
+		for _, i := range missing {
+			cmds = append(cmds, pipe.Get(ctx, r.client.Key(fmt.Sprintf("limit:%s", tenantIDs[i]))))
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false
+	}
+	for n, i := range missing {
+		val, err := cmds[n].Result()
+		if errors.Is(err, redis.Nil) {
+			r.limits.put(tenantIDs[i], 0, false)
+			limits[i] = fallbacks[i]
+			continue
+		}
+		limit, perr := strconv.ParseFloat(val, 64)
+		if err != nil || perr != nil {
+			return nil, false
+		}
+		r.limits.put(tenantIDs[i], limit, true)
+		limits[i] = limit
+	}
+	return limits, true
+}
+
+// limitKeyspacePattern matches keyspace notifications for the limit:{tenant}
+// keys in the client's namespace, in any DB.
+func (r *RateLimiter) limitKeyspacePattern() string {
+	return "__keyspace@*__:" + r.client.Key("limit:") + "*"
+}
+
+// limitNotificationTenant returns the tenant whose limit key a keyspace
+// notification on channel is about; ok is false for other keys.
+func (r *RateLimiter) limitNotificationTenant(channel string) (tenantID string, ok bool) {
+	_, tenantID, ok = strings.Cut(channel, "__:"+r.client.Key("limit:"))
+	return tenantID, ok
+}
+
+// RunLimitInvalidator drops cached limits when another replica changes them,
+// until ctx is cancelled. Requires notify-keyspace-events to include K, g and $
+// (e.g. "Kg$"); without them cached limits simply expire after the TTL. Keyspace
+// notifications are node-local, so on Redis Cluster only the TTL applies.
+func (r *RateLimiter) RunLimitInvalidator(ctx context.Context) {
+	if r == nil || r.client == nil || r.limits == nil {
+		return
+	}
+	pubsub := r.client.Client().PSubscribe(ctx, r.limitKeyspacePattern())
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			tenantID, found := r.limitNotificationTenant(msg.Channel)
+			if !found {
+				continue
+			}
+			r.limits.invalidate(tenantID)
+			slog.Debug("Invalidated cached tenant limit", "tenant_id", tenantID, "event", msg.Payload)
+		}
+	}
+}
@@ -2,17 +2,25 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"agent-sentinel/internal/telemetry"
 
+	"embedding-sidecar/envelope"
+
 	"github.com/redis/go-redis/v9"
 )
 
+var errLimiterUnavailable = errors.New("rate limiter unavailable")
+
 func toFloat64(v any) float64 {
 	switch n := v.(type) {
 	case float64:
@@ -27,11 +35,76 @@ func toFloat64(v any) float64 {
 	}
 }
 
+// CostSettler settles a reservation made by Limiter.CheckLimitAndIncrement once
+// the request's outcome is known. Implementations fail open: errors are handled
+// (logged, retried) internally and nil is returned.
+type CostSettler interface {
+	// AdjustCost replaces the reserved estimate with the actual cost.
+	AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error
+	// RefundEstimate releases the reserved estimate without charging anything.
+	RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error
+}
+
+// Limiter is what the request path needs from a rate limiter. RateLimiter
+// (Redis), MemoryLimiter (in-process) and StoreLimiter (SpendStore) implement it.
+type Limiter interface {
+	CostSettler
+	CheckLimitAndIncrement(ctx context.Context, tenantID string, estimatedCost float64) (*CheckLimitResult, error)
+	GetPricing(provider, model string) (Pricing, bool)
+	DowngradeFor(tenantID, model string, result *CheckLimitResult) (string, bool)
+	MaxRequestCost(tenantID string) (float64, bool)
+}
+
+var (
+	_ Limiter = (*RateLimiter)(nil)
+	_ Limiter = (*MemoryLimiter)(nil)
+	_ Limiter = (*StoreLimiter)(nil)
+)
+
 // RateLimiter handles rate limiting using Redis with minute buckets
 type RateLimiter struct {
-	client       *RedisClient
-	pricing      ProviderPricing
-	defaultLimit float64
+	client         *RedisClient
+	pricing        ProviderPricing
+	defaultLimit   float64
+	reservationTTL time.Duration
+	// compensation scales estimates by the tenant's observed actual/estimate ratio
+	// so replicas that underestimate do not collectively overshoot the limit.
+	compensation compensationConfig
+	// accountingMode is AccountingWindow (default) or AccountingPrepaid.
+	accountingMode string
+	// overdraftPercent lets a request exceed a limit that is not yet reached by
+	// up to this percentage of the limit (OVERDRAFT_PERCENT, default 0).
+	overdraftPercent float64
+	// limits caches Redis-stored tenant limits in-process; nil when disabled
+	// (see limitcache.go).
+	limits *limitCache
+	// ceiling caps the estimated cost of a single request (MAX_REQUEST_COST, see ceiling.go).
+	ceiling RequestCostCeiling
+	// usageLogMax bounds the per-request usage log; 0 disables it (see usagelog.go).
+	usageLogMax int64
+	// keyring, when set, encrypts usage log records per tenant (see usagelog.go).
+	keyring *envelope.Keyring
+	// window is the default window algorithm (RATE_LIMIT_WINDOW, see window.go).
+	window string
+
+	// mu guards config that can be reloaded at runtime (see overrides.go).
+	mu             sync.RWMutex
+	limitOverrides limitOverrides
+	// pricingVersion identifies pricing (see pricingversion.go).
+	pricingVersion string
+	parents        map[string]string
+	schedules      Schedules
+	downgrade      *DowngradePolicy
+	// windows selects the window algorithm per tenant (from limits.json).
+	windows map[string]string
+	// ceilingOverride, when set, replaces ceiling (from limits.json).
+	ceilingOverride *RequestCostCeiling
+	now             func() time.Time // overridable for tests; defaults to time.Now
+	// usageProvider, when set, enables per-day actual cost totals (see usage.go).
+	usageProvider string
+
+	// deadLetters buffers failed settlements while Redis is unreachable (see deadletter.go).
+	deadLetters deadLetterBuffer
 }
 
 var (
@@ -53,19 +126,55 @@ func NewRateLimiter(redisClient *RedisClient) *RateLimiter {
 		return nil
 	}
 
-	// Get default limit from environment
-	defaultLimit := 100.00 // $100/hour default
-	if limitStr := os.Getenv("DEFAULT_SPEND_LIMIT"); limitStr != "" {
-		if limit, err := strconv.ParseFloat(limitStr, 64); err == nil {
-			defaultLimit = limit
+	// Grace overdraft lets the request that crosses a limit through (see RATE_LIMITING_DESIGN.md).
+	var overdraftPercent float64
+	if pctStr := os.Getenv("OVERDRAFT_PERCENT"); pctStr != "" {
+		if pct, err := strconv.ParseFloat(pctStr, 64); err == nil && pct > 0 {
+			overdraftPercent = pct
 		}
 	}
 
 	return &RateLimiter{
-		client:       redisClient,
-		pricing:      GetPricing(),
-		defaultLimit: defaultLimit,
+		client:           redisClient,
+		pricing:          GetPricing(),
+		pricingVersion:   PricingVersionOf(GetPricing()),
+		defaultLimit:     defaultLimitFromEnv(),
+		reservationTTL:   reservationTTLFromEnv(),
+		compensation:     compensationFromEnv(),
+		accountingMode:   accountingModeFromEnv(),
+		overdraftPercent: overdraftPercent,
+		limits:           limitCacheFromEnv(),
+		ceiling:          ceilingFromEnv(),
+		usageLogMax:      usageLogMaxFromEnv(),
+		window:           windowFromEnv(),
+	}
+}
+
+// Redis returns the client the limiter keeps its state in, so other stores can
+// share the connection and namespace. Nil for a nil limiter.
+func (r *RateLimiter) Redis() *RedisClient {
+	if r == nil {
+		return nil
+	}
+	return r.client
+}
+
+// defaultLimitFromEnv reads DEFAULT_SPEND_LIMIT (USD per hour, default 100).
+func defaultLimitFromEnv() float64 {
+	if limit, err := strconv.ParseFloat(os.Getenv("DEFAULT_SPEND_LIMIT"), 64); err == nil {
+		return limit
+	}
+	return 100.00
+}
+
+// reservationTTLFromEnv reads RESERVATION_TTL_SECONDS. Reservations older than
+// this are treated as orphaned and refunded by the reconciler, so it must
+// comfortably exceed the longest expected request (including streams).
+func reservationTTLFromEnv() time.Duration {
+	if ttl, err := strconv.Atoi(os.Getenv("RESERVATION_TTL_SECONDS")); err == nil && ttl > 0 {
+		return time.Duration(ttl) * time.Second
 	}
+	return defaultReservationTTL
 }
 
 // CheckLimitResult contains the result of a limit check
@@ -74,78 +183,229 @@ type CheckLimitResult struct {
 	CurrentSpend float64
 	Limit        float64
 	Remaining    float64
+	// ReservationID identifies the ledger entry holding the estimate; empty when
+	// the request was denied or the limiter failed open.
+	ReservationID string
+	// Shadowed is set when the request exceeded the limit but was allowed because
+	// shadow mode is enabled. The estimate is still reserved.
+	Shadowed bool
+	// Reserved is the amount charged against the limit: the estimate scaled by the
+	// tenant's compensation factor. Equal to the estimate when compensation is off.
+	Reserved float64
+	// LimitedBy is the tenant whose budget CurrentSpend/Limit/Remaining describe:
+	// the tenant itself or the ancestor with the least remaining budget.
+	LimitedBy string
+	// Prepaid is set in prepaid accounting mode: Limit is the credit balance before
+	// this request's hold and Remaining the balance after it.
+	Prepaid bool
+	// Overdraft is how far this request takes LimitedBy past its limit when it
+	// was allowed under the grace overdraft (see OVERDRAFT_PERCENT).
+	Overdraft float64
+	// Schedule names the active schedule rule for LimitedBy; empty when none.
+	// A limit stored in Redis (limit:{tenant}) takes precedence over schedules.
+	Schedule string
 }
 
-// checkLimitAndIncrementLUA is the LUA script for atomic check and increment
-const checkLimitAndIncrementLUA = `
+// shadowModeKey, when set, makes over-limit requests pass through (and be recorded)
+// instead of being denied. Shared by all proxy instances.
+const shadowModeKey = "shadow_mode"
+
+// checkLimitAndIncrementLUA is the LUA script for atomic check and increment.
+// ARGV[1] (the estimate) is in micro-cents and limits in USD; amounts are
+// returned in USD.
+// KEYS[7..] are (spend, limit) pairs for the tenant's ancestors, with their
+// fallback limits in ARGV[11..] followed by their tenant IDs, which are recorded
+// on the reservation; every level must have room for the estimate and the
+// estimate is charged to every level. ARGV[8] is the overdraft ratio: a level
+// still under its limit may exceed it by up to limit*ratio for one request.
+// ARGV[9] is '1' when the limits passed are already resolved from the limit
+// cache, so the limit keys are not read. ARGV[10] is '1' for the sliding window,
+// which counts only the part of the oldest bucket still inside the hour.
+const checkLimitAndIncrementLUA = microUnitsLUA + `
 local spendKey = KEYS[1]
 local limitKey = KEYS[2]
+local ledgerKey = KEYS[3]
+local reservationKey = KEYS[4]
+local shadowKey = KEYS[5]
+local ratioKey = KEYS[6]
 local estimatedCost = tonumber(ARGV[1])
 local defaultLimit = tonumber(ARGV[2])
+local reservationID = ARGV[3]
+local reservationTTL = tonumber(ARGV[4])
+local tenantID = ARGV[5]
+local compensate = ARGV[6] == '1'
+local maxFactor = tonumber(ARGV[7]) or 1
+local overdraft = tonumber(ARGV[8]) or 0
+local limitsResolved = ARGV[9] == '1'
+local sliding = ARGV[10] == '1'
 
 -- Get current time from Redis (prevents server time skew)
 local redisTime = redis.call('TIME')
 local now = tonumber(redisTime[1])
 local minuteBucket = math.floor(now / 60) * 60
 local oneHourAgo = minuteBucket - 3600
+-- Share of the oldest bucket still inside the sliding window (now - 3600, now]
+local oldestWeight = (60 - (now - minuteBucket)) / 60
+
+-- Levels: the tenant itself, then each ancestor up the hierarchy
+local levels = {{spend = spendKey, limit = limitKey, default = defaultLimit}}
+local ancestors = {}
+local ancestorCount = (#KEYS - 6) / 2
+for i = 7, #KEYS, 2 do
+  local idx = #levels + 1
+  levels[idx] = {spend = KEYS[i], limit = KEYS[i + 1], default = tonumber(ARGV[9 + idx])}
+  ancestors[idx - 1] = ARGV[9 + ancestorCount + idx]
+end
 
--- Get tenant limit (from Redis or use default)
-local limit = defaultLimit
-local limitStr = redis.call('GET', limitKey)
-if limitStr then
-  limit = tonumber(limitStr)
+-- Scale the estimate by the tenant's observed actual/estimate ratio
+local rawEstimate = estimatedCost
+if compensate then
+  local factor = tonumber(redis.call('GET', ratioKey) or '1') or 1
+  factor = math.min(math.max(factor, 1), maxFactor)
+  estimatedCost = math.floor(estimatedCost * factor + 0.5)
 end
 
--- Get current spend (sum all minute buckets from last hour)
-local allBuckets = redis.call('HGETALL', spendKey)
-local currentSpend = 0
+-- Check every level; the one with the least remaining budget is reported
+local allowed = true
+local binding = 1
+for idx, level in ipairs(levels) do
+  -- Get level limit (from Redis or use default)
+  local limit = level.default
+  if not limitsResolved then
+    local limitStr = redis.call('GET', level.limit)
+    if limitStr then
+      limit = tonumber(limitStr)
+    end
+  end
+  limit = limit * MICROS
+
+  -- Sum minute buckets from the last hour and clean up older ones
+  migrateSpend(level.spend)
+  local allBuckets = redis.call('HGETALL', level.spend)
+  local currentSpend = 0
+  for i = 1, #allBuckets, 2 do
+    local bucketTime = tonumber(allBuckets[i])
+    if bucketTime and bucketTime >= oneHourAgo then
+      local cost = tonumber(allBuckets[i + 1])
+      if sliding and bucketTime == oneHourAgo then
+        cost = cost * oldestWeight
+      end
+      currentSpend = currentSpend + cost
+    elseif bucketTime then
+      redis.call('HDEL', level.spend, allBuckets[i])
+    end
+  end
 
-for i = 1, #allBuckets, 2 do
-  local bucketTime = tonumber(allBuckets[i])
-  if bucketTime and bucketTime >= oneHourAgo then
-    currentSpend = currentSpend + tonumber(allBuckets[i + 1])
+  level.currentSpend = currentSpend
+  level.limitValue = limit
+  level.remaining = math.max(0, limit - currentSpend)
+  -- Soft limit: a level still under its limit may go over by the overdraft
+  -- allowance; once over, the hard stop applies
+  local hardLimit = limit
+  if currentSpend < limit then
+    hardLimit = limit * (1 + overdraft)
+  end
+  if currentSpend + estimatedCost > hardLimit then
+    allowed = false
+  end
+  if level.remaining < levels[binding].remaining then
+    binding = idx
   end
 end
 
--- Check if adding estimated cost would exceed limit
-local newSpend = currentSpend + estimatedCost
-local allowed = newSpend <= limit
-local remaining = math.max(0, limit - currentSpend)
-
-if allowed then
-  redis.call('HINCRBYFLOAT', spendKey, tostring(minuteBucket), estimatedCost)
-  redis.call('EXPIRE', spendKey, 7200)
+-- Shadow mode: record would-be denials but let the request through
+local shadowed = false
+if not allowed and redis.call('EXISTS', shadowKey) == 1 then
+  allowed = true
+  shadowed = true
 end
 
--- Cleanup old buckets (older than 1 hour)
-for i = 1, #allBuckets, 2 do
-  local bucketTime = tonumber(allBuckets[i])
-  if bucketTime and bucketTime < oneHourAgo then
-    redis.call('HDEL', spendKey, allBuckets[i])
+if allowed then
+  for _, level in ipairs(levels) do
+    redis.call('HINCRBY', level.spend, tostring(minuteBucket), fmtMicros(estimatedCost))
+    redis.call('EXPIRE', level.spend, 7200)
   end
+
+  -- Record the reservation so orphaned estimates can be reconciled
+  redis.call('HSET', reservationKey, 'tenant', tenantID, 'estimate', fmtMicros(estimatedCost), 'raw', fmtMicros(rawEstimate), 'unit', 'ucent', 'bucket', tostring(minuteBucket), 'ancestors', table.concat(ancestors, ','))
+  redis.call('EXPIRE', reservationKey, reservationTTL * 2)
+  redis.call('ZADD', ledgerKey, now + reservationTTL, reservationID)
 end
 
-return {allowed and 1 or 0, tostring(currentSpend), tostring(limit), tostring(remaining)}
+local b = levels[binding]
+return {allowed and 1 or 0, tostring(b.currentSpend / MICROS), tostring(b.limitValue / MICROS), tostring(b.remaining / MICROS), shadowed and 1 or 0, tostring(estimatedCost / MICROS), binding}
 `
 
 // adjustCostLUA is the LUA script for atomic cost adjustment
 // Handles both cost adjustment (actual - estimate) and refunds (when actual is 0)
-const adjustCostLUA = `
+// KEYS[5..] are ancestor spend keys that receive the same adjustment; in prepaid
+// mode (ARGV[7]) KEYS[5] is instead the credit balance, debited by the adjustment,
+// and KEYS[6] its legacy float key. ARGV[1] and ARGV[2] are in micro-cents.
+const adjustCostLUA = microUnitsLUA + `
 local spendKey = KEYS[1]
+local ledgerKey = KEYS[2]
+local reservationKey = KEYS[3]
+local ratioKey = KEYS[4]
 local estimate = tonumber(ARGV[1]) or 0
 local actual = tonumber(ARGV[2]) or 0
+local reservationID = ARGV[3]
+local compensate = ARGV[4] == '1'
+local alpha = tonumber(ARGV[5]) or 0
+local maxFactor = tonumber(ARGV[6]) or 1
+local prepaid = ARGV[7] == 'prepaid'
 
 -- Get current time from Redis (prevents server time skew)
 local redisTime = redis.call('TIME')
 local now = tonumber(redisTime[1])
 local minuteBucket = math.floor(now / 60) * 60
 
+-- Settle the reservation; if the reconciler already refunded it, only charge actual.
+-- The reservation records the (possibly compensated) amount actually charged.
+-- A settled marker replaces it so a retried settlement (dead-letter queue) whose
+-- first attempt did apply is not charged twice.
+local reserved = estimate
+if reservationID ~= '' then
+  if redis.call('HEXISTS', reservationKey, 'settled') == 1 then
+    return 0
+  end
+  local stored = reservedMicros(reservationKey)
+  local removed = redis.call('ZREM', ledgerKey, reservationID)
+  redis.call('DEL', reservationKey)
+  redis.call('HSET', reservationKey, 'settled', '1')
+  redis.call('EXPIRE', reservationKey, 3600)
+  if removed == 0 then
+    reserved = 0
+  elseif stored then
+    reserved = stored
+  end
+end
+
+-- Track how far actual cost drifts from the raw estimate (EWMA)
+if compensate and estimate > 0 and actual > 0 then
+  local ratio = actual / estimate
+  local previous = tonumber(redis.call('GET', ratioKey) or '1') or 1
+  local updated = previous * (1 - alpha) + ratio * alpha
+  updated = math.min(math.max(updated, 0.25), maxFactor)
+  redis.call('SET', ratioKey, tostring(updated), 'EX', 86400)
+end
+
 -- If actual is 0, it becomes (0 - Estimate), which is a refund
-local adjustment = actual - estimate
+local adjustment = actual - reserved
 
 if adjustment ~= 0 then
-  redis.call('HINCRBYFLOAT', spendKey, tostring(minuteBucket), adjustment)
+  migrateSpend(spendKey)
+  redis.call('HINCRBY', spendKey, tostring(minuteBucket), fmtMicros(adjustment))
   redis.call('EXPIRE', spendKey, 7200)
+  if prepaid then
+    migrateCredits(KEYS[5], KEYS[6])
+    redis.call('INCRBY', KEYS[5], fmtMicros(-adjustment))
+  else
+    for i = 5, #KEYS do
+      migrateSpend(KEYS[i])
+      redis.call('HINCRBY', KEYS[i], tostring(minuteBucket), fmtMicros(adjustment))
+      redis.call('EXPIRE', KEYS[i], 7200)
+    end
+  end
 end
 
 return 1
@@ -164,14 +424,51 @@ func (r *RateLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID strin
 		}, nil
 	}
 
+	reservationTTL := r.reservationTTL
+	if reservationTTL <= 0 {
+		reservationTTL = defaultReservationTTL
+	}
+	if r.AccountingMode() == AccountingPrepaid {
+		return r.checkCredits(ctx, tenantID, estimatedCost, reservationTTL)
+	}
+
 	spendKey := fmt.Sprintf("spend:%s", tenantID)
 	limitKey := fmt.Sprintf("limit:%s", tenantID)
+	reservationID := newReservationID()
+
+	tenantLimit, tenantSchedule := r.scheduledLimit(tenantID)
+	ancestors := r.ancestors(tenantID)
+	levels := append([]string{tenantID}, ancestors...)
+	fallbacks := []float64{tenantLimit}
+	schedules := []string{tenantSchedule}
+	for _, ancestor := range ancestors {
+		limit, schedule := r.scheduledLimit(ancestor)
+		fallbacks = append(fallbacks, limit)
+		schedules = append(schedules, schedule)
+	}
+	resolvedFlag, slidingFlag := "0", "0"
+	if r.windowFor(tenantID) == WindowSliding {
+		slidingFlag = "1"
+	}
+	if resolved, ok := r.resolveLimits(ctx, levels, fallbacks); ok {
+		fallbacks, resolvedFlag = resolved, "1"
+	}
+
+	keys := []string{spendKey, limitKey, reservationLedgerKey, reservationKey(reservationID), shadowModeKey, estimateRatioKey(tenantID)}
+	args := []any{ToMicros(estimatedCost), fallbacks[0], reservationID, int64(reservationTTL.Seconds()), tenantID,
+		r.compensation.flag(), r.compensation.maxFactor, r.overdraftRatio(), resolvedFlag, slidingFlag}
+	for i, ancestor := range ancestors {
+		keys = append(keys, fmt.Sprintf("spend:%s", ancestor), fmt.Sprintf("limit:%s", ancestor))
+		args = append(args, fallbacks[i+1])
+	}
+	for _, ancestor := range ancestors {
+		args = append(args, ancestor)
+	}
 
 	client := r.client.Client()
 	script := redis.NewScript(checkLimitAndIncrementLUA)
 	start := time.Now()
-	result, err := runScript(ctx, script, client, []string{spendKey, limitKey},
-		estimatedCost, r.defaultLimit)
+	result, err := runScript(ctx, script, client, r.client.Keys(keys...), args...)
 
 	if err != nil {
 		telemetry.ObserveRedisLatency(ctx, "check_limit", r.client.Backend(), "error", time.Since(start), tenantID)
@@ -184,8 +481,8 @@ func (r *RateLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID strin
 		return &CheckLimitResult{
 			Allowed:      true,
 			CurrentSpend: 0,
-			Limit:        r.defaultLimit,
-			Remaining:    r.defaultLimit,
+			Limit:        r.limitFor(tenantID),
+			Remaining:    r.limitFor(tenantID),
 		}, nil
 	}
 
@@ -198,74 +495,96 @@ func (r *RateLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID strin
 	limit := toFloat64(results[2])
 	remaining := toFloat64(results[3])
 
-	return &CheckLimitResult{
+	res := &CheckLimitResult{
 		Allowed:      allowed,
 		CurrentSpend: currentSpend,
 		Limit:        limit,
 		Remaining:    remaining,
-	}, nil
+		Reserved:     estimatedCost,
+	}
+	if len(results) > 4 {
+		res.Shadowed = results[4].(int64) == 1
+	}
+	if len(results) > 5 {
+		res.Reserved = toFloat64(results[5])
+	}
+	res.LimitedBy = tenantID
+	res.Schedule = tenantSchedule
+	if len(results) > 6 {
+		if level, ok := results[6].(int64); ok && level > 1 && int(level-1) <= len(ancestors) {
+			res.LimitedBy = ancestors[level-2]
+			res.Schedule = schedules[level-1]
+		}
+	}
+	if allowed {
+		res.ReservationID = reservationID
+		if !res.Shadowed {
+			res.Overdraft = max(0, res.CurrentSpend+res.Reserved-res.Limit)
+		}
+	}
+	return res, nil
 }
 
-// AdjustCost atomically adjusts the cost: subtracts estimate and adds actual
-func (r *RateLimiter) AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error {
+// AdjustCost atomically adjusts the cost: subtracts estimate and adds actual.
+// reservationID settles the ledger entry created by CheckLimitAndIncrement; if the
+// reservation was already reconciled, only the actual cost is added.
+// Failures are dead-lettered and retried in the background.
+func (r *RateLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
 	if r == nil || r.client == nil {
 		// Fail-open: silently ignore if rate limiter not available
 		return nil
 	}
 
-	spendKey := fmt.Sprintf("spend:%s", tenantID)
-
-	client := r.client.Client()
-	script := redis.NewScript(adjustCostLUA)
-	start := time.Now()
-
-	err := runScriptErr(ctx, script, client, []string{spendKey},
-		estimate, actual)
-
-	if err != nil {
-		telemetry.ObserveRedisLatency(ctx, "adjust_cost", r.client.Backend(), "error", time.Since(start), tenantID)
-		telemetry.IncRedisError(ctx, "adjust_cost", r.client.Backend(), tenantID)
-		slog.Warn("Redis error in AdjustCost",
+	if err := r.settle(ctx, OpAdjustCost, tenantID, reservationID, estimate, actual); err != nil {
+		slog.Warn("Redis error in AdjustCost, queued for retry",
 			"error", err,
 			"tenant_id", tenantID,
 		)
-		// Fail-open: log but don't fail
-		return nil
+		r.deadLetter(PendingSettlement{Op: OpAdjustCost, TenantID: tenantID, ReservationID: reservationID, Estimate: estimate, Actual: actual}, err)
 	}
-
-	telemetry.ObserveRedisLatency(ctx, "adjust_cost", r.client.Backend(), "ok", time.Since(start), tenantID)
+	// Fail-open: log but don't fail
 	return nil
 }
 
 // RefundEstimate atomically refunds the estimate (subtracts it from bucket)
-func (r *RateLimiter) RefundEstimate(ctx context.Context, tenantID string, estimate float64) error {
+// and settles the reservation identified by reservationID.
+// Failures are dead-lettered and retried in the background.
+func (r *RateLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
 	if r == nil || r.client == nil {
 		// Fail-open: silently ignore if rate limiter not available
 		return nil
 	}
 
-	spendKey := fmt.Sprintf("spend:%s", tenantID)
+	// actual=0 triggers the refund logic (0 - estimate = -estimate)
+	if err := r.settle(ctx, OpRefundEstimate, tenantID, reservationID, estimate, 0); err != nil {
+		slog.Warn("Redis error in RefundEstimate, queued for retry",
+			"error", err,
+			"tenant_id", tenantID,
+		)
+		r.deadLetter(PendingSettlement{Op: OpRefundEstimate, TenantID: tenantID, ReservationID: reservationID, Estimate: estimate}, err)
+	}
+	// Fail-open: log but don't fail
+	return nil
+}
 
+// settle runs adjustCostLUA for an adjustment or refund (op names the telemetry
+// operation). Retrying a settlement that already applied is a no-op.
+func (r *RateLimiter) settle(ctx context.Context, op, tenantID, reservationID string, estimate, actual float64) error {
 	client := r.client.Client()
 	script := redis.NewScript(adjustCostLUA)
-
-	// Pass actual=0 to trigger refund logic (0 - estimate = -estimate)
 	start := time.Now()
-	err := runScriptErr(ctx, script, client, []string{spendKey},
-		estimate, 0.0)
 
+	err := runScriptErr(ctx, script, client,
+		r.adjustKeys(tenantID, reservationID),
+		ToMicros(estimate), ToMicros(actual), reservationID, r.compensation.flag(), r.compensation.alpha, r.compensation.maxFactor, r.AccountingMode())
 	if err != nil {
-		telemetry.ObserveRedisLatency(ctx, "refund_estimate", r.client.Backend(), "error", time.Since(start), tenantID)
-		telemetry.IncRedisError(ctx, "refund_estimate", r.client.Backend(), tenantID)
-		slog.Warn("Redis error in RefundEstimate",
-			"error", err,
-			"tenant_id", tenantID,
-		)
-		// Fail-open: log but don't fail
-		return nil
+		telemetry.ObserveRedisLatency(ctx, op, r.client.Backend(), "error", time.Since(start), tenantID)
+		telemetry.IncRedisError(ctx, op, r.client.Backend(), tenantID)
+		return err
 	}
 
-	telemetry.ObserveRedisLatency(ctx, "refund_estimate", r.client.Backend(), "ok", time.Since(start), tenantID)
+	telemetry.ObserveRedisLatency(ctx, op, r.client.Backend(), "ok", time.Since(start), tenantID)
+	r.recordDailyUsage(ctx, actual)
 	return nil
 }
 
@@ -275,37 +594,26 @@ func (r *RateLimiter) GetSpend(ctx context.Context, tenantID string) (float64, e
 		return 0, nil
 	}
 
-	spendKey := fmt.Sprintf("spend:%s", tenantID)
-	client := r.client.Client()
+	spendKey := r.client.Key(fmt.Sprintf("spend:%s", tenantID))
 
-	redisTime, err := client.Time(ctx).Result()
+	var (
+		now        int64
+		allBuckets map[string]string
+	)
+	err := r.client.read(ctx, func(client redis.UniversalClient) error {
+		redisTime, err := client.Time(ctx).Result()
+		if err != nil {
+			return err
+		}
+		now = redisTime.Unix()
+		allBuckets, err = client.HGetAll(ctx, spendKey).Result()
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	now := redisTime.Unix()
 	oneHourAgo := (now/60)*60 - 3600
-
-	allBuckets, err := client.HGetAll(ctx, spendKey).Result()
-	if err != nil {
-		return 0, err
-	}
-
-	var totalSpend float64
-	for bucketTimeStr, costStr := range allBuckets {
-		bucketTime, err := strconv.ParseInt(bucketTimeStr, 10, 64)
-		if err != nil {
-			continue
-		}
-
-		if bucketTime >= oneHourAgo {
-			cost, err := strconv.ParseFloat(costStr, 64)
-			if err == nil {
-				totalSpend += cost
-			}
-		}
-	}
-
-	return totalSpend, nil
+	return sumSpendBuckets(allBuckets, oneHourAgo), nil
 }
 
 // GetLimit returns the limit for a tenant (from Redis or default)
@@ -314,32 +622,86 @@ func (r *RateLimiter) GetLimit(ctx context.Context, tenantID string) (float64, e
 		return r.defaultLimit, nil
 	}
 
-	limitKey := fmt.Sprintf("limit:%s", tenantID)
-	client := r.client.Client()
-
-	limitStr, err := client.Get(ctx, limitKey).Result()
+	defaultLimit := r.limitFor(tenantID)
+	if limits, ok := r.resolveLimits(ctx, []string{tenantID}, []float64{defaultLimit}); ok {
+		return limits[0], nil
+	}
+	limitKey := r.client.Key(fmt.Sprintf("limit:%s", tenantID))
+
+	var limitStr string
+	err := r.client.read(ctx, func(client redis.UniversalClient) error {
+		var err error
+		limitStr, err = client.Get(ctx, limitKey).Result()
+		return err
+	})
 	if err == redis.Nil {
 		// No custom limit set, use default
-		return r.defaultLimit, nil
+		return defaultLimit, nil
 	}
 	if err != nil {
-		return r.defaultLimit, err
+		return defaultLimit, err
 	}
 
 	limit, err := strconv.ParseFloat(limitStr, 64)
 	if err != nil {
-		return r.defaultLimit, err
+		return defaultLimit, err
 	}
 
 	return limit, nil
 }
 
+// SetLimit sets a custom hourly spend limit for a tenant.
+func (r *RateLimiter) SetLimit(ctx context.Context, tenantID string, limit float64) error {
+	if r == nil || r.client == nil {
+		return errLimiterUnavailable
+	}
+	limitKey := r.client.Key(fmt.Sprintf("limit:%s", tenantID))
+	defer r.limits.invalidate(tenantID)
+	return r.client.Client().Set(ctx, limitKey, strconv.FormatFloat(limit, 'f', -1, 64), 0).Err()
+}
+
+// PurgeTenant deletes a tenant's spend buckets and custom limit.
+func (r *RateLimiter) PurgeTenant(ctx context.Context, tenantID string) error {
+	if r == nil || r.client == nil {
+		return errLimiterUnavailable
+	}
+	spendKey := fmt.Sprintf("spend:%s", tenantID)
+	limitKey := fmt.Sprintf("limit:%s", tenantID)
+	defer r.limits.invalidate(tenantID)
+	return r.client.Client().Del(ctx, r.client.Keys(spendKey, limitKey)...).Err()
+}
+
+// ShadowMode reports whether shadow mode is enabled.
+func (r *RateLimiter) ShadowMode(ctx context.Context) (bool, error) {
+	if r == nil || r.client == nil {
+		return false, nil
+	}
+	n, err := r.client.Client().Exists(ctx, r.client.Key(shadowModeKey)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// SetShadowMode enables or disables shadow mode for all proxy instances.
+func (r *RateLimiter) SetShadowMode(ctx context.Context, enabled bool) error {
+	if r == nil || r.client == nil {
+		return errLimiterUnavailable
+	}
+	if enabled {
+		return r.client.Client().Set(ctx, r.client.Key(shadowModeKey), "1", 0).Err()
+	}
+	return r.client.Client().Del(ctx, r.client.Key(shadowModeKey)).Err()
+}
+
 // GetPricing returns the pricing for a specific provider and model
 func (r *RateLimiter) GetPricing(provider, model string) (Pricing, bool) {
 	if r == nil {
 		return Pricing{}, false
 	}
 
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	providerPricing, ok := r.pricing[provider]
 	if !ok {
 		return Pricing{}, false
@@ -348,3 +710,26 @@ func (r *RateLimiter) GetPricing(provider, model string) (Pricing, bool) {
 	pricing, ok := providerPricing[model]
 	return pricing, ok
 }
+
+// ListTenants returns tenant IDs that have recorded spend in the current window.
+func (r *RateLimiter) ListTenants(ctx context.Context) ([]string, error) {
+	if r == nil || r.client == nil {
+		return nil, nil
+	}
+
+	var tenants []string
+	err := r.client.read(ctx, func(client redis.UniversalClient) error {
+		tenants = tenants[:0]
+		prefix := r.client.Key("spend:")
+		iter := client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			tenants = append(tenants, strings.TrimPrefix(iter.Val(), prefix))
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
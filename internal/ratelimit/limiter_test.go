@@ -2,8 +2,11 @@ package ratelimit
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -36,7 +39,9 @@ func TestCheckLimitAllowsOnScriptError(t *testing.T) {
 
 func TestCheckLimitParsesResult(t *testing.T) {
 	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
 	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys = keys
 		return []any{int64(1), "1.5", "10", "8.5"}, nil
 	}
 	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
@@ -47,26 +52,494 @@ func TestCheckLimitParsesResult(t *testing.T) {
 	if !res.Allowed || res.CurrentSpend != 1.5 || res.Limit != 10 || res.Remaining != 8.5 {
 		t.Fatalf("unexpected parsed result %+v", res)
 	}
+	if res.ReservationID == "" {
+		t.Fatalf("expected reservation id on allowed result")
+	}
+	if len(gotKeys) != 6 || gotKeys[2] != reservationLedgerKey || gotKeys[3] != reservationKey(res.ReservationID) {
+		t.Fatalf("unexpected script keys %v", gotKeys)
+	}
 }
 
-func TestAdjustCostFailOpenOnError(t *testing.T) {
+func TestCheckLimitDeniedHasNoReservation(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		return []any{int64(0), "10", "10", "0"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
+	res, err := rl.CheckLimitAndIncrement(context.Background(), "t1", 1)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if res.Allowed || res.ReservationID != "" {
+		t.Fatalf("expected denied result without reservation, got %+v", res)
+	}
+}
+
+func TestCheckLimitShadowedKeepsReservation(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		if keys[4] != shadowModeKey {
+			t.Fatalf("expected shadow mode key, got %v", keys)
+		}
+		return []any{int64(1), "10", "10", "0", int64(1)}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
+	res, err := rl.CheckLimitAndIncrement(context.Background(), "t1", 1)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !res.Allowed || !res.Shadowed || res.ReservationID == "" {
+		t.Fatalf("expected shadowed allow with reservation, got %+v", res)
+	}
+}
+
+func TestAdjustCostSettlesReservation(t *testing.T) {
 	defer func() { runScriptErr = defaultRunScriptErr }()
+	var gotKeys []string
+	var gotArgs []any
+	runScriptErr = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) error {
+		gotKeys, gotArgs = keys, args
+		return nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
+	if err := rl.AdjustCost(context.Background(), "t1", "res-1", 1, 2); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(gotKeys) != 4 || gotKeys[0] != "spend:t1" || gotKeys[2] != "reservation:res-1" || gotKeys[3] != "estratio:t1" {
+		t.Fatalf("unexpected keys %v", gotKeys)
+	}
+	if len(gotArgs) != 7 || gotArgs[2] != "res-1" || gotArgs[3] != "0" || gotArgs[6] != AccountingWindow {
+		t.Fatalf("unexpected args %v", gotArgs)
+	}
+}
+
+func TestAdjustCostFailOpenOnError(t *testing.T) {
+	defer func() { runScriptErr, pushDeadLetter = defaultRunScriptErr, defaultPushDeadLetter }()
 	runScriptErr = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) error {
 		return errors.New("script fail")
 	}
+	var pushed []PendingSettlement
+	pushDeadLetter = func(ctx context.Context, client redis.UniversalClient, key string, payload []byte) error {
+		var p PendingSettlement
+		if err := json.Unmarshal(payload, &p); err != nil {
+			t.Fatalf("decode dead letter: %v", err)
+		}
+		pushed = append(pushed, p)
+		return nil
+	}
 	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
-	if err := rl.AdjustCost(context.Background(), "t1", 1, 2); err != nil {
+	if err := rl.AdjustCost(context.Background(), "t1", "res-1", 1, 2); err != nil {
 		t.Fatalf("expected nil on error, got %v", err)
 	}
+	if len(pushed) != 1 || pushed[0].Op != OpAdjustCost || pushed[0].ReservationID != "res-1" || pushed[0].Actual != 2 || pushed[0].Attempts != 1 {
+		t.Fatalf("expected dead-lettered adjustment, got %+v", pushed)
+	}
 }
 
 func TestRefundEstimateFailOpenOnError(t *testing.T) {
-	defer func() { runScriptErr = defaultRunScriptErr }()
+	defer func() { runScriptErr, pushDeadLetter = defaultRunScriptErr, defaultPushDeadLetter }()
 	runScriptErr = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) error {
 		return errors.New("script fail")
 	}
+	// Redis is down for the dead-letter push too: the refund is buffered locally.
+	pushDeadLetter = func(ctx context.Context, client redis.UniversalClient, key string, payload []byte) error {
+		return errors.New("connection refused")
+	}
 	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
-	if err := rl.RefundEstimate(context.Background(), "t1", 1); err != nil {
+	if err := rl.RefundEstimate(context.Background(), "t1", "res-1", 1); err != nil {
 		t.Fatalf("expected nil on error, got %v", err)
 	}
+	if got := rl.deadLetters.entries; len(got) != 1 || got[0].Op != OpRefundEstimate || got[0].Estimate != 1 {
+		t.Fatalf("expected locally buffered refund, got %+v", got)
+	}
+	if _, err := rl.RetryDeadLetters(context.Background()); err == nil {
+		t.Fatalf("expected retry to fail while Redis is down")
+	}
+	if len(rl.deadLetters.entries) != 1 {
+		t.Fatalf("expected buffered refund to be kept, got %+v", rl.deadLetters.entries)
+	}
+}
+
+func TestLimitOverridesFallback(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotLimit any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotLimit = args[1]
+		return []any{int64(1), "0", "0", "0"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
+
+	fileDefault := 50.0
+	rl.SetLimitOverrides(&fileDefault, map[string]float64{"acme": 250})
+	if _, err := rl.CheckLimitAndIncrement(context.Background(), "acme", 1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if gotLimit != 250.0 {
+		t.Fatalf("expected tenant override 250, got %v", gotLimit)
+	}
+	if _, err := rl.CheckLimitAndIncrement(context.Background(), "other", 1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if gotLimit != 50.0 {
+		t.Fatalf("expected file default 50, got %v", gotLimit)
+	}
+
+	rl.SetLimitOverrides(nil, nil)
+	if rl.limitFor("acme") != 10 {
+		t.Fatalf("expected env default after clearing overrides, got %v", rl.limitFor("acme"))
+	}
+}
+
+func TestCheckLimitReportsOverdraft(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotRatio any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotRatio = args[7]
+		// Spend 9.5 of 10 before a 1.0 request: allowed under a 10% overdraft.
+		return []any{int64(1), "9.5", "10", "0.5", int64(0), "1"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10, overdraftPercent: 10}
+
+	res, err := rl.CheckLimitAndIncrement(context.Background(), "t1", 1)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if gotRatio != 0.1 {
+		t.Fatalf("expected overdraft ratio 0.1, got %v", gotRatio)
+	}
+	if res.Overdraft != 0.5 {
+		t.Fatalf("expected overdraft 0.5, got %+v", res)
+	}
+
+	filePercent := 25.0
+	rl.SetOverdraftOverride(&filePercent)
+	if _, err := rl.CheckLimitAndIncrement(context.Background(), "t1", 1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if gotRatio != 0.25 {
+		t.Fatalf("expected file overdraft ratio 0.25, got %v", gotRatio)
+	}
+}
+
+func TestPricingOverridesMergeWithBuiltIns(t *testing.T) {
+	rl := &RateLimiter{pricing: GetPricing()}
+	rl.SetPricingOverrides(ProviderPricing{
+		"openai":  {"gpt-custom": {InputPrice: 1, OutputPrice: 2}},
+		"private": {"m": {InputPrice: 3, OutputPrice: 4}},
+	})
+	if p, ok := rl.GetPricing("openai", "gpt-custom"); !ok || p.OutputPrice != 2 {
+		t.Fatalf("expected override pricing, got %+v %v", p, ok)
+	}
+	if _, ok := rl.GetPricing("openai", "gpt-4o"); !ok {
+		t.Fatalf("expected built-in pricing to be kept")
+	}
+	if _, ok := rl.GetPricing("private", "m"); !ok {
+		t.Fatalf("expected new provider pricing")
+	}
+}
+
+func TestCompensationFromEnv(t *testing.T) {
+	t.Setenv("ESTIMATE_COMPENSATION_ENABLED", "true")
+	t.Setenv("ESTIMATE_COMPENSATION_ALPHA", "0.5")
+	t.Setenv("ESTIMATE_COMPENSATION_MAX_FACTOR", "0.5")
+	cfg := compensationFromEnv()
+	if !cfg.enabled || cfg.alpha != 0.5 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.maxFactor != defaultCompensationMaxFactor {
+		t.Fatalf("expected invalid max factor to fall back to default, got %v", cfg.maxFactor)
+	}
+}
+
+func TestAncestorsStopsOnCycle(t *testing.T) {
+	rl := &RateLimiter{}
+	rl.SetHierarchy(map[string]string{"agent": "team", "team": "org", "org": "agent"})
+	got := rl.ancestors("agent")
+	if len(got) != 2 || got[0] != "team" || got[1] != "org" {
+		t.Fatalf("expected [team org], got %v", got)
+	}
+	if got := rl.ancestors("unknown"); len(got) != 0 {
+		t.Fatalf("expected no ancestors, got %v", got)
+	}
+}
+
+func TestCheckLimitEnforcesAncestors(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	var gotArgs []any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys, gotArgs = keys, args
+		// Level 3 (org) is the binding budget.
+		return []any{int64(0), "50", "50", "0", int64(0), "1", int64(3)}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10}
+	rl.SetHierarchy(map[string]string{"agent": "team", "team": "org"})
+	orgLimit := 50.0
+	rl.SetLimitOverrides(nil, map[string]float64{"org": orgLimit})
+
+	res, err := rl.CheckLimitAndIncrement(context.Background(), "agent", 1)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if res.Allowed || res.LimitedBy != "org" {
+		t.Fatalf("expected denial by org, got %+v", res)
+	}
+	if len(gotKeys) != 10 || gotKeys[6] != "spend:team" || gotKeys[9] != "limit:org" {
+		t.Fatalf("unexpected keys %v", gotKeys)
+	}
+	if len(gotArgs) != 14 || gotArgs[8] != "0" || gotArgs[11] != orgLimit || gotArgs[12] != "team" || gotArgs[13] != "org" {
+		t.Fatalf("unexpected args %v", gotArgs)
+	}
+}
+
+func TestCheckLimitNamespacesKeys(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	var gotArgs []any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys, gotArgs = keys, args
+		return []any{int64(1), "1", "10", "9"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{namespace: "staging"}, defaultLimit: 10}
+	rl.SetHierarchy(map[string]string{"agent": "team"})
+	if _, err := rl.CheckLimitAndIncrement(context.Background(), "agent", 1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	want := []string{"staging:spend:agent", "staging:limit:agent", "staging:reservations"}
+	if len(gotKeys) != 8 || !slices.Equal(gotKeys[:3], want) || gotKeys[6] != "staging:spend:team" || gotKeys[7] != "staging:limit:team" {
+		t.Fatalf("unexpected keys %v", gotKeys)
+	}
+	// The ancestor is recorded by ID, not by its namespaced key.
+	if gotArgs[len(gotArgs)-1] != "team" {
+		t.Fatalf("expected the ancestor ID last in args, got %v", gotArgs)
+	}
+}
+
+// fakeRedis answers commands in a ProcessHook instead of sending them to Redis.
+type fakeRedis struct {
+	reply func(cmd redis.Cmder)
+}
+
+func (f fakeRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (f fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.reply(cmd)
+		return nil
+	}
+}
+
+func (f fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestReconcileOrphansNamespacesAncestors(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys = keys
+		return int64(1), nil
+	}
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	var gotHash string
+	client.AddHook(fakeRedis{reply: func(cmd redis.Cmder) {
+		switch c := cmd.(type) {
+		case *redis.TimeCmd:
+			c.SetVal(time.Unix(1000, 0))
+		case *redis.StringSliceCmd:
+			c.SetVal([]string{"r1"})
+		case *redis.SliceCmd:
+			gotHash = c.Args()[1].(string)
+			c.SetVal([]any{"agent", "team,org", nil})
+		}
+	}})
+	rl := &RateLimiter{client: &RedisClient{client: client, namespace: "staging"}}
+
+	n, err := rl.ReconcileOrphans(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("ReconcileOrphans = %d, %v", n, err)
+	}
+	want := []string{"staging:reservations", "staging:reservation:r1", "staging:spend:agent", "staging:spend:team", "staging:spend:org"}
+	if gotHash != "staging:reservation:r1" || !slices.Equal(gotKeys, want) {
+		t.Fatalf("expected namespaced keys %v, got %v (hash %q)", want, gotKeys, gotHash)
+	}
+}
+
+func TestCheckLimitUsesCachedLimits(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotArgs []any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotArgs = args
+		return []any{int64(1), "0", "25", "25", int64(0), "1", int64(1)}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10, limits: newLimitCache(time.Minute, 10)}
+	rl.SetHierarchy(map[string]string{"agent": "team"})
+	rl.limits.put("agent", 25, true)
+	rl.limits.put("team", 0, false)
+
+	if _, err := rl.CheckLimitAndIncrement(context.Background(), "agent", 1); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if gotArgs[1] != 25.0 || gotArgs[8] != "1" || gotArgs[10] != 10.0 {
+		t.Fatalf("expected resolved limits [25 10], got args %v", gotArgs)
+	}
+}
+
+func TestLimitKeyspaceNotificationsUseNamespace(t *testing.T) {
+	rl := &RateLimiter{client: &RedisClient{namespace: "staging"}}
+	if got := rl.limitKeyspacePattern(); got != "__keyspace@*__:staging:limit:*" {
+		t.Fatalf("unexpected pattern %q", got)
+	}
+	if tenantID, ok := rl.limitNotificationTenant("__keyspace@0__:staging:limit:acme"); !ok || tenantID != "acme" {
+		t.Fatalf("expected acme, got %q %v", tenantID, ok)
+	}
+	if _, ok := rl.limitNotificationTenant("__keyspace@0__:limit:acme"); ok {
+		t.Fatal("expected another namespace's limit key to be ignored")
+	}
+
+	rl = &RateLimiter{client: &RedisClient{}}
+	if tenantID, ok := rl.limitNotificationTenant("__keyspace@0__:limit:acme"); rl.limitKeyspacePattern() != "__keyspace@*__:limit:*" || !ok || tenantID != "acme" {
+		t.Fatalf("expected unnamespaced keys to keep working, got %q %v", tenantID, ok)
+	}
+}
+
+func TestLimitCacheExpiresAndEvicts(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newLimitCache(5*time.Second, 2)
+	c.now = func() time.Time { return now }
+
+	c.put("a", 1, true)
+	c.put("b", 2, true)
+	if _, _, ok := c.get("a"); !ok {
+		t.Fatal("expected hit for a")
+	}
+	c.put("c", 3, true) // evicts b, the least recently used
+	if _, _, ok := c.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+
+	c.invalidate("c")
+	if _, _, ok := c.get("c"); ok {
+		t.Fatal("expected c to be invalidated")
+	}
+
+	now = now.Add(6 * time.Second)
+	if _, _, ok := c.get("a"); ok {
+		t.Fatal("expected a to expire")
+	}
+}
+
+func TestCheckCreditsHoldsEstimate(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys = keys
+		return []any{int64(1), "3", int64(0)}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, accountingMode: AccountingPrepaid}
+	res, err := rl.CheckLimitAndIncrement(context.Background(), "t1", 2)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !res.Allowed || !res.Prepaid || res.Limit != 5 || res.Remaining != 3 || res.ReservationID == "" {
+		t.Fatalf("unexpected prepaid result %+v", res)
+	}
+	if gotKeys[0] != creditsKey("t1") || gotKeys[len(gotKeys)-1] != legacyCreditsKey("t1") {
+		t.Fatalf("expected credits key first, got %v", gotKeys)
+	}
+	if keys := rl.adjustKeys("t1", "res-1"); keys[4] != creditsKey("t1") || keys[5] != legacyCreditsKey("t1") {
+		t.Fatalf("expected adjustment to debit credits, got %v", keys)
+	}
+}
+
+func TestReplicaInSync(t *testing.T) {
+	cases := []struct {
+		name string
+		info string
+		want bool
+	}{
+		{"in sync", "# Replication\r\nrole:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:2\r\n", true},
+		{"lagging", "role:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:30\r\n", false},
+		{"link down", "role:slave\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:1\r\n", false},
+		{"promoted", "role:master\r\nconnected_slaves:0\r\n", false},
+	}
+	for _, tc := range cases {
+		if got := replicaInSync(tc.info, 10*time.Second); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReadFallsBackToPrimary(t *testing.T) {
+	primary := redis.NewClient(&redis.Options{Addr: "primary:6379"})
+	rep := &replica{client: redis.NewClient(&redis.Options{Addr: "replica:6379"}), addr: "replica"}
+	rep.healthy.Store(true)
+	rc := &RedisClient{client: primary, replicas: []*replica{rep}}
+
+	var used []redis.UniversalClient
+	err := rc.read(context.Background(), func(c redis.UniversalClient) error {
+		used = append(used, c)
+		if c == rep.client {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(used) != 2 || used[0] != rep.client || used[1] != primary {
+		t.Fatalf("expected replica then primary, got %v", used)
+	}
+	if rep.healthy.Load() {
+		t.Fatal("expected failed replica to be marked unhealthy")
+	}
+	if rc.pickReplica() != nil {
+		t.Fatal("expected no healthy replica")
+	}
+}
+
+func TestDowngradeFor(t *testing.T) {
+	rl := &RateLimiter{}
+	rl.SetDowngradePolicy(&DowngradePolicy{Threshold: 0.2, Models: map[string]string{"gpt-5": "gpt-5-mini"}, OptOut: []string{"vip"}})
+
+	low := &CheckLimitResult{Allowed: true, Limit: 10, Remaining: 1}
+	if got, ok := rl.DowngradeFor("acme", "gpt-5", low); !ok || got != "gpt-5-mini" {
+		t.Fatalf("expected downgrade below threshold, got %q %v", got, ok)
+	}
+	if _, ok := rl.DowngradeFor("acme", "gpt-5", &CheckLimitResult{Allowed: true, Limit: 10, Remaining: 5}); ok {
+		t.Fatal("expected no downgrade with budget left")
+	}
+	if _, ok := rl.DowngradeFor("acme", "gpt-5", &CheckLimitResult{Allowed: false, Limit: 10}); !ok {
+		t.Fatal("expected downgrade instead of a denial")
+	}
+	if _, ok := rl.DowngradeFor("vip", "gpt-5", low); ok {
+		t.Fatal("expected opted-out tenant to keep its model")
+	}
+	if _, ok := rl.DowngradeFor("acme", "gpt-5-mini", low); ok {
+		t.Fatal("expected unmapped model to be kept")
+	}
+	if _, ok := rl.DowngradeFor("acme", "gpt-5", &CheckLimitResult{Allowed: true, Shadowed: true, Limit: 10}); ok {
+		t.Fatal("expected shadow-mode results to be left alone")
+	}
+}
+
+func TestCheckLimitSelectsWindowPerTenant(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotSliding any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotSliding = args[9]
+		return []any{int64(1), "0", "10", "10"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}, defaultLimit: 10, window: WindowBuckets}
+	rl.SetWindowOverrides(map[string]string{"acme": WindowSliding})
+
+	for tenant, want := range map[string]string{"acme": "1", "other": "0"} {
+		if _, err := rl.CheckLimitAndIncrement(context.Background(), tenant, 1); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if gotSliding != want {
+			t.Fatalf("%s: sliding flag = %v, want %s", tenant, gotSliding, want)
+		}
+	}
 }
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter is an in-process Limiter with the same one-hour window of
+// minute buckets as the Redis limiter. It serves tests and single-instance
+// deployments without Redis (RATE_LIMIT_BACKEND=memory): spend is not shared
+// between processes and is lost on restart. Hierarchies, schedules, overdraft,
+// estimate compensation and prepaid credits are Redis-only.
+type MemoryLimiter struct {
+	pricing        ProviderPricing
+	defaultLimit   float64
+	ceiling        RequestCostCeiling
+	reservationTTL time.Duration
+	now            func() time.Time // overridable for tests; defaults to time.Now
+
+	mu           sync.Mutex
+	limits       map[string]float64
+	spend        map[string]map[int64]int64 // tenant -> minute bucket -> micro-cents
+	reservations map[string]memoryReservation
+	nextID       uint64
+}
+
+type memoryReservation struct {
+	tenantID string
+	amount   int64 // micro-cents
+	bucket   int64
+	expires  time.Time
+}
+
+// NewMemoryLimiter returns an in-memory limiter using DEFAULT_SPEND_LIMIT,
+// MAX_REQUEST_COST and RESERVATION_TTL_SECONDS like NewRateLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		pricing:        GetPricing(),
+		defaultLimit:   defaultLimitFromEnv(),
+		ceiling:        ceilingFromEnv(),
+		reservationTTL: reservationTTLFromEnv(),
+		now:            time.Now,
+		limits:         make(map[string]float64),
+		spend:          make(map[string]map[int64]int64),
+		reservations:   make(map[string]memoryReservation),
+	}
+}
+
+// SetLimit sets a custom hourly spend limit for a tenant.
+func (m *MemoryLimiter) SetLimit(tenantID string, limit float64) {
+	m.mu.Lock()
+	m.limits[tenantID] = limit
+	m.mu.Unlock()
+}
+
+// GetSpend returns the tenant's spend in the last hour.
+func (m *MemoryLimiter) GetSpend(tenantID string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return FromMicros(m.currentSpend(tenantID, minuteBucket(m.now())))
+}
+
+// CheckLimitAndIncrement reserves estimatedCost against the tenant's hourly
+// limit when it fits.
+func (m *MemoryLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID string, estimatedCost float64) (*CheckLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.expireReservations(now)
+	bucket := minuteBucket(now)
+
+	limit, ok := m.limits[tenantID]
+	if !ok {
+		limit = m.defaultLimit
+	}
+	spend := FromMicros(m.currentSpend(tenantID, bucket))
+	res := &CheckLimitResult{
+		Allowed:      spend+estimatedCost <= limit,
+		CurrentSpend: spend,
+		Limit:        limit,
+		Remaining:    max(0, limit-spend),
+		Reserved:     estimatedCost,
+		LimitedBy:    tenantID,
+	}
+	if !res.Allowed {
+		return res, nil
+	}
+	m.charge(tenantID, bucket, ToMicros(estimatedCost))
+	m.nextID++
+	res.ReservationID = "mem-" + strconv.FormatUint(m.nextID, 10)
+	m.reservations[res.ReservationID] = memoryReservation{
+		tenantID: tenantID,
+		amount:   ToMicros(estimatedCost),
+		bucket:   bucket,
+		expires:  now.Add(m.reservationTTL),
+	}
+	return res, nil
+}
+
+// AdjustCost replaces the reservation's estimate with actual. A reservation that
+// already expired was refunded, so only actual is charged.
+func (m *MemoryLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
+	m.settle(tenantID, reservationID, estimate, actual)
+	return nil
+}
+
+// RefundEstimate releases the reservation's estimate.
+func (m *MemoryLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
+	m.settle(tenantID, reservationID, estimate, 0)
+	return nil
+}
+
+func (m *MemoryLimiter) settle(tenantID, reservationID string, estimate, actual float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reserved := ToMicros(estimate)
+	if reservationID != "" {
+		res, ok := m.reservations[reservationID]
+		delete(m.reservations, reservationID)
+		reserved = 0
+		if ok {
+			reserved = res.amount
+		}
+	}
+	if adjustment := ToMicros(actual) - reserved; adjustment != 0 {
+		m.charge(tenantID, minuteBucket(m.now()), adjustment)
+	}
+}
+
+// GetPricing returns the pricing for a specific provider and model.
+func (m *MemoryLimiter) GetPricing(provider, model string) (Pricing, bool) {
+	pricing, ok := m.pricing[provider][model]
+	return pricing, ok
+}
+
+// DowngradeFor never downgrades; downgrade policies need the Redis limiter.
+func (m *MemoryLimiter) DowngradeFor(tenantID, model string, result *CheckLimitResult) (string, bool) {
+	return "", false
+}
+
+// MaxRequestCost returns the MAX_REQUEST_COST ceiling and whether to clamp.
+func (m *MemoryLimiter) MaxRequestCost(tenantID string) (float64, bool) {
+	clamp := m.ceiling.Action == CeilingClamp
+	if m.ceiling.Default != nil {
+		return *m.ceiling.Default, clamp
+	}
+	return 0, clamp
+}
+
+// currentSpend sums the tenant's buckets in the last hour, dropping older ones.
+// Caller holds mu.
+func (m *MemoryLimiter) currentSpend(tenantID string, bucket int64) int64 {
+	var total int64
+	for b, cost := range m.spend[tenantID] {
+		if b < bucket-3600 {
+			delete(m.spend[tenantID], b)
+			continue
+		}
+		total += cost
+	}
+	if len(m.spend[tenantID]) == 0 {
+		delete(m.spend, tenantID)
+	}
+	return total
+}
+
+// charge adds amount to the tenant's bucket. Caller holds mu.
+func (m *MemoryLimiter) charge(tenantID string, bucket int64, amount int64) {
+	buckets, ok := m.spend[tenantID]
+	if !ok {
+		buckets = make(map[int64]int64)
+		m.spend[tenantID] = buckets
+	}
+	buckets[bucket] += amount
+}
+
+// expireReservations refunds reservations that were never settled, like the
+// Redis reconciler. Caller holds mu.
+func (m *MemoryLimiter) expireReservations(now time.Time) {
+	for id, res := range m.reservations {
+		if now.Before(res.expires) {
+			continue
+		}
+		delete(m.reservations, id)
+		if buckets, ok := m.spend[res.tenantID]; ok {
+			buckets[res.bucket] -= res.amount
+		}
+	}
+}
+
+func minuteBucket(t time.Time) int64 {
+	return t.Unix() / 60 * 60
+}
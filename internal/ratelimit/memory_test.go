@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterReservesAndSettles(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	m := NewMemoryLimiter()
+	m.now = func() time.Time { return now }
+	m.SetLimit("acme", 1)
+
+	first, _ := m.CheckLimitAndIncrement(ctx, "acme", 0.6)
+	if !first.Allowed || first.ReservationID == "" || first.Remaining != 1 {
+		t.Fatalf("first request should be allowed with a reservation, got %+v", first)
+	}
+	second, _ := m.CheckLimitAndIncrement(ctx, "acme", 0.6)
+	if second.Allowed || second.ReservationID != "" || second.CurrentSpend != 0.6 {
+		t.Fatalf("second request should be denied while the first is reserved, got %+v", second)
+	}
+
+	// Settling at the actual cost frees the rest of the estimate.
+	_ = m.AdjustCost(ctx, "acme", first.ReservationID, 0.6, 0.2)
+	if spend := m.GetSpend("acme"); spend < 0.199 || spend > 0.201 {
+		t.Fatalf("spend after adjustment = %v, want 0.2", spend)
+	}
+	// The freed budget admits another request, and refunding it restores the spend.
+	third, _ := m.CheckLimitAndIncrement(ctx, "acme", 0.6)
+	if !third.Allowed {
+		t.Fatalf("request within the remaining budget should be allowed, got %+v", third)
+	}
+	_ = m.RefundEstimate(ctx, "acme", third.ReservationID, 0.6)
+	if spend := m.GetSpend("acme"); spend < 0.199 || spend > 0.201 {
+		t.Fatalf("spend after refund = %v, want 0.2", spend)
+	}
+
+	// Spend leaves the window after an hour.
+	now = now.Add(61 * time.Minute)
+	if spend := m.GetSpend("acme"); spend != 0 {
+		t.Fatalf("spend after the window = %v, want 0", spend)
+	}
+}
+
+func TestMemoryLimiterExpiresOrphanedReservations(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	m := NewMemoryLimiter()
+	m.now = func() time.Time { return now }
+	m.SetLimit("acme", 1)
+
+	orphan, _ := m.CheckLimitAndIncrement(ctx, "acme", 0.9)
+	now = now.Add(m.reservationTTL)
+	if res, _ := m.CheckLimitAndIncrement(ctx, "acme", 0.9); !res.Allowed {
+		t.Fatalf("expired reservation should have been refunded, got %+v", res)
+	}
+	// Settling the expired reservation charges only the actual cost.
+	_ = m.AdjustCost(ctx, "acme", orphan.ReservationID, 0.9, 0.05)
+	if spend := m.GetSpend("acme"); spend < 0.949 || spend > 0.951 {
+		t.Fatalf("spend = %v, want 0.95", spend)
+	}
+}
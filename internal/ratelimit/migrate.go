@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// schemaVersionKey holds the version of the key layout that the data in Redis
+// was last migrated to. A missing key is version 0: data written before
+// versioning, or an empty Redis.
+const schemaVersionKey = "schema_version"
+
+// schemaLockKey is held by the replica running migrations so replicas starting
+// together do not migrate the same keys twice.
+const schemaLockKey = "schema_migration_lock"
+
+const schemaLockTTL = 5 * time.Minute
+
+// ErrSchemaTooNew is returned by Migrate when Redis was migrated by a newer
+// build, whose key layout this one would misread.
+var ErrSchemaTooNew = errors.New("redis key schema is newer than this build")
+
+// migration upgrades every key of one layout. Migrations must be idempotent:
+// a replica that dies halfway leaves the version unchanged, and the next start
+// runs the migration again. run returns how many keys it changed.
+type migration struct {
+	version int
+	name    string
+	run     func(ctx context.Context, r *RateLimiter) (int, error)
+}
+
+// migrations are applied in order. Add new ones at the end with the next
+// version; never renumber or remove one.
+var migrations = []migration{
+	{version: 1, name: "spend_micro_cents", run: migrateSpendKeys},
+	{version: 2, name: "credits_micro_cents", run: migrateCreditKeys},
+}
+
+// SchemaVersion is the key layout this build reads and writes.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// ReadSchemaVersion returns the schema version recorded in client's Redis, or 0
+// when none is.
+func ReadSchemaVersion(ctx context.Context, client *RedisClient) (int, error) {
+	v, err := client.Client().Get(ctx, client.Key(schemaVersionKey)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", schemaVersionKey, v)
+	}
+	return version, nil
+}
+
+// MigrateOnStart runs Migrate before traffic is served, logging the outcome. A
+// failed migration is retried on the next start; until then scripts still read
+// keys of the previous layout. Redis migrated by a newer build is only logged,
+// since refusing to start would take the caller down with it.
+func (r *RateLimiter) MigrateOnStart() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	from, to, err := r.Migrate(ctx)
+	if errors.Is(err, ErrSchemaTooNew) {
+		slog.Error("Redis was migrated by a newer build; spend data may be misread", "error", err)
+		return
+	}
+	if err != nil {
+		slog.Error("Redis schema migration failed", "error", err, "schema_version", to)
+		return
+	}
+	if from != to {
+		slog.Info("Redis schema migrations complete", "from", from, "schema_version", to)
+	}
+}
+
+// Migrate upgrades the key layout in Redis to SchemaVersion, recording the
+// version after each migration. It returns the versions before and after. When
+// another replica holds the migration lock it returns without migrating; the
+// scripts still read keys of the previous layout until that replica finishes.
+func (r *RateLimiter) Migrate(ctx context.Context) (from, to int, err error) {
+	if r == nil || r.client == nil {
+		return 0, 0, errLimiterUnavailable
+	}
+	from, err = ReadSchemaVersion(ctx, r.client)
+	if err != nil {
+		return 0, 0, err
+	}
+	current := SchemaVersion()
+	if from > current {
+		return from, from, fmt.Errorf("%w: redis has v%d, this build v%d", ErrSchemaTooNew, from, current)
+	}
+	if from == current {
+		return from, from, nil
+	}
+
+	client := r.client.Client()
+	// Any unique value identifies this replica's hold on the lock.
+	token := newReservationID()
+	lockKey := r.client.Key(schemaLockKey)
+	acquired, err := client.SetNX(ctx, lockKey, token, schemaLockTTL).Result()
+	if err != nil {
+		return from, from, err
+	}
+	if !acquired {
+		slog.Info("Redis schema migration running on another replica", "schema_version", from)
+		return from, from, nil
+	}
+	defer func() {
+		_ = runScriptErr(context.WithoutCancel(ctx), redis.NewScript(releaseLockLUA), client, []string{lockKey}, token)
+	}()
+
+	to = from
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		start := time.Now()
+		changed, err := m.run(ctx, r)
+		if err != nil {
+			return from, to, fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		if err := client.Set(ctx, r.client.Key(schemaVersionKey), m.version, 0).Err(); err != nil {
+			return from, to, err
+		}
+		to = m.version
+		slog.Info("Redis schema migrated",
+			"migration", m.name,
+			"schema_version", m.version,
+			"keys", changed,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+	return from, to, nil
+}
+
+// releaseLockLUA deletes the lock only while it still holds this replica's
+// token, so an expired lock taken over by another replica is left alone.
+const releaseLockLUA = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// scanKeys calls fn with every key matching the namespaced pattern, on every
+// master of a cluster.
+func (r *RateLimiter) scanKeys(ctx context.Context, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, r.client.Key(pattern), 500).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+	if cluster, ok := r.client.Client().(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node)
+		})
+	}
+	return scan(ctx, r.client.Client())
+}
+
+// migrateSpendLUA converts a spend hash still holding float USD to micro-cents.
+// It returns 1 when the hash was converted.
+const migrateSpendLUA = microUnitsLUA + `
+if redis.call('EXISTS', KEYS[1]) == 0 or redis.call('HEXISTS', KEYS[1], 'unit') == 1 then
+  return 0
+end
+migrateSpend(KEYS[1])
+return 1
+`
+
+// migrateSpendKeys converts spend hashes written before micro-cent accounting,
+// which scripts otherwise only convert when the tenant next sends a request.
+func migrateSpendKeys(ctx context.Context, r *RateLimiter) (int, error) {
+	script := redis.NewScript(migrateSpendLUA)
+	changed := 0
+	err := r.scanKeys(ctx, "spend:*", func(key string) error {
+		res, err := runScript(ctx, script, r.client.Client(), []string{key})
+		if n, ok := res.(int64); ok && n == 1 {
+			changed++
+		}
+		return err
+	})
+	return changed, err
+}
+
+// migrateCreditsLUA folds a legacy float credit balance into the micro-cent
+// balance and returns 1 when there was one.
+const migrateCreditsLUA = microUnitsLUA + `
+if redis.call('EXISTS', KEYS[2]) == 0 then
+  return 0
+end
+migrateCredits(KEYS[1], KEYS[2])
+return 1
+`
+
+// migrateCreditKeys moves legacy credits:{tenant} balances to ucredits:{tenant}.
+func migrateCreditKeys(ctx context.Context, r *RateLimiter) (int, error) {
+	script := redis.NewScript(migrateCreditsLUA)
+	prefix := r.client.Key("credits:")
+	changed := 0
+	err := r.scanKeys(ctx, "credits:*", func(key string) error {
+		tenantID := strings.TrimPrefix(key, prefix)
+		res, err := runScript(ctx, script, r.client.Client(), r.client.Keys(creditsKey(tenantID), legacyCreditsKey(tenantID)))
+		if n, ok := res.(int64); ok && n == 1 {
+			changed++
+		}
+		return err
+	})
+	return changed, err
+}
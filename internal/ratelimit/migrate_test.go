@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 || m.name == "" || m.run == nil {
+			t.Fatalf("migration %d is %+v, want version %d with a name and run", i, m, i+1)
+		}
+	}
+	if SchemaVersion() != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d", len(migrations), SchemaVersion())
+	}
+}
+
+func TestMigrateWithoutRedis(t *testing.T) {
+	var rl *RateLimiter
+	if _, _, err := rl.Migrate(context.Background()); !errors.Is(err, errLimiterUnavailable) {
+		t.Fatalf("expected errLimiterUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package ratelimit
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Input modalities a model may accept.
+const (
+	ModalityText     = "text"
+	ModalityImage    = "image"
+	ModalityAudio    = "audio"
+	ModalityVideo    = "video"
+	ModalityDocument = "document"
+)
+
+// DeprecationLayout is the format of ModelInfo.Deprecated.
+const DeprecationLayout = "2006-01-02"
+
+// ModelInfo records the limits and capabilities of a model.
+type ModelInfo struct {
+	// ContextWindow is the most tokens a request may use, input and output
+	// together (0 when unknown).
+	ContextWindow int `json:"context_window,omitempty"`
+	// MaxOutput is the largest output token cap the model accepts (0 when unknown).
+	MaxOutput int `json:"max_output,omitempty"`
+	// Modalities lists the input modalities the model accepts; empty means unknown.
+	Modalities []string `json:"modalities,omitempty"`
+	// Deprecated is the date (YYYY-MM-DD) the provider retires the model, if announced.
+	Deprecated string `json:"deprecated,omitempty"`
+	// Successor, when set, is the model requests are rewritten to once the
+	// Deprecated date has passed.
+	Successor string `json:"successor,omitempty"`
+}
+
+// ModelTable stores model metadata by model name.
+type ModelTable map[string]ModelInfo
+
+// ProviderModels stores model metadata per provider.
+type ProviderModels map[string]ModelTable
+
+var (
+	textOnly        = []string{ModalityText}
+	textImage       = []string{ModalityText, ModalityImage}
+	textImageDoc    = []string{ModalityText, ModalityImage, ModalityDocument}
+	geminiMultimode = []string{ModalityText, ModalityImage, ModalityAudio, ModalityVideo, ModalityDocument}
+)
+
+// GetModels returns the built-in model metadata, covering the models in
+// GetPricing. Limits are taken from each provider's model documentation as of
+// January 2026; Deprecated holds announced shutdown dates.
+func GetModels() ProviderModels {
+	return ProviderModels{
+		"anthropic": ModelTable{
+			"claude-opus-4-5":            {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImageDoc},
+			"claude-opus-4-5-20250220":   {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImageDoc},
+			"claude-sonnet-4-5":          {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImageDoc},
+			"claude-sonnet-4-5-20250220": {ContextWindow: 200_000, MaxOutput: 64_000, Modalities: textImageDoc},
+			"claude-3-5-sonnet-20241022": {ContextWindow: 200_000, MaxOutput: 8_192, Modalities: textImageDoc, Deprecated: "2025-10-22"},
+			"claude-3-5-sonnet-latest":   {ContextWindow: 200_000, MaxOutput: 8_192, Modalities: textImageDoc, Deprecated: "2025-10-22"},
+			"claude-3-5-haiku-20241022":  {ContextWindow: 200_000, MaxOutput: 8_192, Modalities: textImageDoc},
+			"claude-3-5-haiku-latest":    {ContextWindow: 200_000, MaxOutput: 8_192, Modalities: textImageDoc},
+			"claude-3-opus-20240229":     {ContextWindow: 200_000, MaxOutput: 4_096, Modalities: textImage, Deprecated: "2026-01-05"},
+			"claude-3-opus-latest":       {ContextWindow: 200_000, MaxOutput: 4_096, Modalities: textImage, Deprecated: "2026-01-05"},
+			"claude-3-sonnet-20240229":   {ContextWindow: 200_000, MaxOutput: 4_096, Modalities: textImage, Deprecated: "2025-07-21"},
+			"claude-3-haiku-20240307":    {ContextWindow: 200_000, MaxOutput: 4_096, Modalities: textImage},
+		},
+		"openai": ModelTable{
+			"gpt-5.2":                {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage},
+			"gpt-5.2-pro":            {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage},
+			"gpt-5-mini":             {ContextWindow: 400_000, MaxOutput: 128_000, Modalities: textImage},
+			"gpt-4o":                 {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage},
+			"gpt-4o-2024-08-06":      {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage},
+			"gpt-4o-2024-05-13":      {ContextWindow: 128_000, MaxOutput: 4_096, Modalities: textImage},
+			"gpt-4o-mini":            {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage},
+			"gpt-4o-mini-2024-07-18": {ContextWindow: 128_000, MaxOutput: 16_384, Modalities: textImage},
+			"gpt-4-turbo":            {ContextWindow: 128_000, MaxOutput: 4_096, Modalities: textImage},
+			"gpt-4-turbo-2024-04-09": {ContextWindow: 128_000, MaxOutput: 4_096, Modalities: textImage},
+			"gpt-4-1106-preview":     {ContextWindow: 128_000, MaxOutput: 4_096, Modalities: textOnly},
+			"gpt-4-0125-preview":     {ContextWindow: 128_000, MaxOutput: 4_096, Modalities: textOnly},
+			"gpt-4":                  {ContextWindow: 8_192, MaxOutput: 8_192, Modalities: textOnly},
+			"gpt-4-32k":              {ContextWindow: 32_768, MaxOutput: 32_768, Modalities: textOnly, Deprecated: "2025-06-06"},
+			"gpt-3.5-turbo":          {ContextWindow: 16_385, MaxOutput: 4_096, Modalities: textOnly},
+			"gpt-3.5-turbo-0125":     {ContextWindow: 16_385, MaxOutput: 4_096, Modalities: textOnly},
+			"gpt-3.5-turbo-1106":     {ContextWindow: 16_385, MaxOutput: 4_096, Modalities: textOnly},
+			"gpt-3.5-turbo-16k":      {ContextWindow: 16_385, MaxOutput: 4_096, Modalities: textOnly},
+			"o1":                     {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textImage},
+			"o1-mini":                {ContextWindow: 128_000, MaxOutput: 65_536, Modalities: textOnly},
+			"o1-preview":             {ContextWindow: 128_000, MaxOutput: 32_768, Modalities: textOnly, Deprecated: "2025-07-28"},
+			"o3":                     {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textImage},
+			"o3-mini":                {ContextWindow: 200_000, MaxOutput: 100_000, Modalities: textOnly},
+		},
+		"gemini": ModelTable{
+			"gemini-3-pro-preview":          {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-3-flash-preview":        {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-3-pro-image-preview":    {ContextWindow: 65_536, MaxOutput: 32_768, Modalities: textImage},
+			"gemini-2.5-pro":                {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.5-pro-preview":        {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.5-flash":              {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.5-flash-preview":      {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.5-flash-lite":         {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.5-flash-lite-preview": {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: geminiMultimode},
+			"gemini-2.0-flash":              {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode},
+			"gemini-2.0-flash-lite":         {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode},
+			"gemini-2.0-flash-exp":          {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode},
+			"gemini-2.0-flash-thinking-exp": {ContextWindow: 1_048_576, MaxOutput: 65_536, Modalities: textImage},
+			"gemini-1.5-pro":                {ContextWindow: 2_097_152, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-1.5-pro-latest":         {ContextWindow: 2_097_152, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-1.5-pro-002":            {ContextWindow: 2_097_152, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-1.5-flash":              {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-1.5-flash-latest":       {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-1.5-flash-8b":           {ContextWindow: 1_048_576, MaxOutput: 8_192, Modalities: geminiMultimode, Deprecated: "2025-09-24"},
+			"gemini-pro":                    {ContextWindow: 32_760, MaxOutput: 8_192, Modalities: textOnly, Deprecated: "2025-02-15"},
+			"gemini-pro-vision":             {ContextWindow: 16_384, MaxOutput: 2_048, Modalities: textImage, Deprecated: "2024-07-12"},
+			"gemini-pro-1.0":                {ContextWindow: 32_760, MaxOutput: 8_192, Modalities: textOnly, Deprecated: "2025-02-15"},
+		},
+	}
+}
+
+// Validate checks model metadata from a config file.
+func (m ModelInfo) Validate() error {
+	if m.ContextWindow < 0 || m.MaxOutput < 0 {
+		return fmt.Errorf("context_window and max_output must not be negative")
+	}
+	if m.ContextWindow > 0 && m.MaxOutput > m.ContextWindow {
+		return fmt.Errorf("max_output %d exceeds context_window %d", m.MaxOutput, m.ContextWindow)
+	}
+	for _, modality := range m.Modalities {
+		switch modality {
+		case ModalityText, ModalityImage, ModalityAudio, ModalityVideo, ModalityDocument:
+		default:
+			return fmt.Errorf("unknown modality %q", modality)
+		}
+	}
+	if m.Deprecated != "" {
+		if _, err := time.Parse(DeprecationLayout, m.Deprecated); err != nil {
+			return fmt.Errorf("deprecated must be a YYYY-MM-DD date, got %q", m.Deprecated)
+		}
+	}
+	if m.Successor != "" && m.Deprecated == "" {
+		return fmt.Errorf("successor %q needs a deprecated date", m.Successor)
+	}
+	return nil
+}
+
+// Validate checks every entry of a models table.
+func (p ProviderModels) Validate() error {
+	for provider, models := range p {
+		for model, info := range models {
+			if err := info.Validate(); err != nil {
+				return fmt.Errorf("%s/%s: %w", provider, model, err)
+			}
+			if info.Successor == model {
+				return fmt.Errorf("%s/%s: a model cannot be its own successor", provider, model)
+			}
+		}
+	}
+	return nil
+}
+
+// Sunset returns the start of the day the model is retired, in UTC.
+func (m ModelInfo) Sunset() (time.Time, bool) {
+	if m.Deprecated == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(DeprecationLayout, m.Deprecated)
+	return t, err == nil
+}
+
+// Accepts reports whether the model takes input of modality. Models with
+// unknown modalities accept anything.
+func (m ModelInfo) Accepts(modality string) bool {
+	return len(m.Modalities) == 0 || slices.Contains(m.Modalities, modality)
+}
+
+// Fits reports whether a prompt of inputTokens leaves room in the context
+// window. Models with an unknown window fit anything.
+func (m ModelInfo) Fits(inputTokens int) bool {
+	return m.ContextWindow == 0 || inputTokens < m.ContextWindow
+}
+
+// BoundOutput lowers an output token estimate to what the model can produce
+// after a prompt of inputTokens: its maximum output and the rest of its
+// context window.
+func (m ModelInfo) BoundOutput(inputTokens, outputTokens int) int {
+	if m.MaxOutput > 0 {
+		outputTokens = min(outputTokens, m.MaxOutput)
+	}
+	if m.ContextWindow > 0 {
+		outputTokens = min(outputTokens, max(m.ContextWindow-inputTokens, 0))
+	}
+	return outputTokens
+}
+
+// MergeModels returns base with overrides applied: overrides add models or
+// replace their entry as a whole. Neither argument is modified.
+func MergeModels(base, overrides ProviderModels) ProviderModels {
+	merged := make(ProviderModels, len(base))
+	for provider, models := range base {
+		merged[provider] = make(ModelTable, len(models))
+		for model, info := range models {
+			merged[provider][model] = info
+		}
+	}
+	for provider, models := range overrides {
+		if merged[provider] == nil {
+			merged[provider] = ModelTable{}
+		}
+		for model, info := range models {
+			merged[provider][model] = info
+		}
+	}
+	return merged
+}
+
+// ModelCatalog holds the model metadata in effect: the built-in table merged
+// with models.json. Safe for concurrent use; a nil catalog knows no models.
+type ModelCatalog struct {
+	mu     sync.RWMutex
+	models ProviderModels
+}
+
+// NewModelCatalog returns a catalog of the built-in model metadata.
+func NewModelCatalog() *ModelCatalog {
+	return &ModelCatalog{models: GetModels()}
+}
+
+// Set replaces the catalog with the built-in table merged with overrides.
+func (c *ModelCatalog) Set(overrides ProviderModels) {
+	if c == nil {
+		return
+	}
+	models := MergeModels(GetModels(), overrides)
+	c.mu.Lock()
+	c.models = models
+	c.mu.Unlock()
+}
+
+// Lookup returns the metadata of a model.
+func (c *ModelCatalog) Lookup(provider, model string) (ModelInfo, bool) {
+	if c == nil {
+		return ModelInfo{}, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	info, ok := c.models[provider][model]
+	return info, ok
+}
+
+// Table returns the models table in effect. The table must not be modified.
+func (c *ModelCatalog) Table() ProviderModels {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.models
+}
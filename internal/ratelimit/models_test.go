@@ -0,0 +1,84 @@
+package ratelimit
+
+import "testing"
+
+func TestBuiltinModelsCoverPricing(t *testing.T) {
+	models := GetModels()
+	if err := models.Validate(); err != nil {
+		t.Fatalf("built-in models invalid: %v", err)
+	}
+	for provider, table := range GetPricing() {
+		for model := range table {
+			if _, ok := models[provider][model]; !ok {
+				t.Errorf("no metadata for priced model %s/%s", provider, model)
+			}
+		}
+	}
+}
+
+func TestModelInfoLimits(t *testing.T) {
+	info := ModelInfo{ContextWindow: 8192, MaxOutput: 4096, Modalities: []string{ModalityText}}
+	if got := info.BoundOutput(100, 5000); got != 4096 {
+		t.Fatalf("expected the max output, got %d", got)
+	}
+	if got := info.BoundOutput(6000, 4096); got != 2192 {
+		t.Fatalf("expected the rest of the window, got %d", got)
+	}
+	if !info.Fits(8191) || info.Fits(8192) {
+		t.Fatal("expected a prompt to fit only below the window")
+	}
+	if info.Accepts(ModalityImage) || !(ModelInfo{}).Accepts(ModalityImage) {
+		t.Fatal("expected listed modalities enforced and unknown ones accepted")
+	}
+	if got := (ModelInfo{}).BoundOutput(1_000_000, 4096); got != 4096 || !(ModelInfo{}).Fits(1_000_000) {
+		t.Fatal("expected unknown limits to leave estimates alone")
+	}
+}
+
+func TestModelInfoValidate(t *testing.T) {
+	for _, bad := range []ModelInfo{
+		{ContextWindow: -1},
+		{ContextWindow: 100, MaxOutput: 200},
+		{Modalities: []string{"smell"}},
+		{Deprecated: "next year"},
+	} {
+		if bad.Validate() == nil {
+			t.Errorf("expected %+v rejected", bad)
+		}
+	}
+}
+
+func TestModelCatalogOverrides(t *testing.T) {
+	c := NewModelCatalog()
+	c.Set(ProviderModels{
+		"openai": {"gpt-custom": {ContextWindow: 32_000}, "gpt-4o": {ContextWindow: 64_000}},
+	})
+	if info, ok := c.Lookup("openai", "gpt-custom"); !ok || info.ContextWindow != 32_000 {
+		t.Fatalf("expected the added model, got %+v", info)
+	}
+	if info, _ := c.Lookup("openai", "gpt-4o"); info.ContextWindow != 64_000 || info.MaxOutput != 0 {
+		t.Fatalf("expected the override to replace the entry, got %+v", info)
+	}
+	if _, ok := c.Lookup("anthropic", "claude-sonnet-4-5"); !ok {
+		t.Fatal("expected built-in models kept")
+	}
+	if GetModels()["openai"]["gpt-4o"].ContextWindow != 128_000 {
+		t.Fatal("expected the built-in table unchanged")
+	}
+	var nilCatalog *ModelCatalog
+	if _, ok := nilCatalog.Lookup("openai", "gpt-4o"); ok {
+		t.Fatal("expected a nil catalog to know no models")
+	}
+}
+
+func TestModelSuccessorValidation(t *testing.T) {
+	if err := (ProviderModels{"openai": {"gpt-old": {Successor: "gpt-new"}}}).Validate(); err == nil {
+		t.Fatal("expected a successor without a deprecated date rejected")
+	}
+	if err := (ProviderModels{"openai": {"gpt-old": {Deprecated: "2026-01-01", Successor: "gpt-old"}}}).Validate(); err == nil {
+		t.Fatal("expected a model rejected as its own successor")
+	}
+	if sunset, ok := (ModelInfo{Deprecated: "2026-01-05"}).Sunset(); !ok || sunset.Format(DeprecationLayout) != "2026-01-05" {
+		t.Fatalf("unexpected sunset %v", sunset)
+	}
+}
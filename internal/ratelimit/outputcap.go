@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"agent-sentinel/internal/override"
+)
+
+// OutputTokenPolicy fills in and bounds the output token cap of generation
+// requests. A request without a cap is estimated from a multiplier and can run
+// to the model's maximum; injecting a default makes the estimate match what the
+// provider may actually bill.
+type OutputTokenPolicy struct {
+	// Default is set on requests that carry no cap (0 leaves them unchanged).
+	Default int `json:"default,omitempty"`
+	// Max lowers any larger cap to this value (0 allows any).
+	Max int `json:"max,omitempty"`
+}
+
+// Validate checks an output token policy.
+func (p OutputTokenPolicy) Validate() error {
+	if p.Default < 0 || p.Max < 0 {
+		return fmt.Errorf("max_tokens default and max must not be negative")
+	}
+	if p.Max > 0 && p.Default > p.Max {
+		return fmt.Errorf("max_tokens default %d exceeds max %d", p.Default, p.Max)
+	}
+	return nil
+}
+
+// Apply injects the default cap or clamps an oversized one in a parsed request
+// body. It returns the resulting cap and whether data was changed.
+func (p OutputTokenPolicy) Apply(data map[string]any, provider string, inject bool) (int, bool) {
+	current := ExtractMaxOutputTokens(data)
+	switch {
+	case current == 0 && inject && p.Default > 0:
+		SetMaxOutputTokens(data, provider, p.Default)
+		return p.Default, true
+	case p.Max > 0 && current > p.Max:
+		SetMaxOutputTokens(data, provider, p.Max)
+		return p.Max, true
+	}
+	return current, false
+}
+
+// outputTokenPolicyFromEnv reads MAX_TOKENS_DEFAULT and MAX_TOKENS_CAP. A default
+// above the cap is lowered to it.
+func outputTokenPolicyFromEnv() OutputTokenPolicy {
+	var p OutputTokenPolicy
+	if v, err := strconv.Atoi(os.Getenv("MAX_TOKENS_DEFAULT")); err == nil && v > 0 {
+		p.Default = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MAX_TOKENS_CAP")); err == nil && v > 0 {
+		p.Max = v
+	}
+	if p.Max > 0 && p.Default > p.Max {
+		p.Default = p.Max
+	}
+	return p
+}
+
+// OutputTokenGuard holds the active output token policy. Safe for concurrent use.
+type OutputTokenGuard struct {
+	active override.Value[OutputTokenPolicy]
+}
+
+// NewOutputTokenGuard returns a guard using MAX_TOKENS_DEFAULT and MAX_TOKENS_CAP.
+func NewOutputTokenGuard() *OutputTokenGuard {
+	env := outputTokenPolicyFromEnv()
+	return &OutputTokenGuard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *OutputTokenGuard) Set(policy *OutputTokenPolicy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *OutputTokenGuard) Policy() OutputTokenPolicy {
+	if g == nil {
+		return OutputTokenPolicy{}
+	}
+	return g.active.Get()
+}
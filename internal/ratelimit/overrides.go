@@ -0,0 +1,92 @@
+package ratelimit
+
+import "time"
+
+// limitOverrides holds tenant limits loaded from config files. A limit stored in
+// Redis (limit:{tenant}) still takes precedence, so runtime changes made through
+// the admin API win until the key is removed.
+type limitOverrides struct {
+	defaultLimit     *float64
+	tenants          map[string]float64
+	overdraftPercent *float64
+}
+
+// limitFor returns the fallback limit for a tenant when Redis has no explicit limit.
+func (r *RateLimiter) limitFor(tenantID string) float64 {
+	limit, _ := r.scheduledLimit(tenantID)
+	return limit
+}
+
+// scheduledLimit resolves the fallback limit and the name of the schedule rule
+// that produced it (empty when none): the tenant's active schedule rule, then
+// the file-configured tenant limit, then the file default, then DEFAULT_SPEND_LIMIT.
+func (r *RateLimiter) scheduledLimit(tenantID string) (float64, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if schedule, ok := r.schedules[tenantID]; ok {
+		now := time.Now
+		if r.now != nil {
+			now = r.now
+		}
+		if rule, ok := schedule.active(now()); ok {
+			name := rule.name
+			if name == "" {
+				name = "scheduled"
+			}
+			return rule.limit, name
+		}
+	}
+	if limit, ok := r.limitOverrides.tenants[tenantID]; ok {
+		return limit, ""
+	}
+	if r.limitOverrides.defaultLimit != nil {
+		return *r.limitOverrides.defaultLimit, ""
+	}
+	return r.defaultLimit, ""
+}
+
+// SetLimitOverrides replaces the file-configured limits. A nil defaultLimit keeps
+// DEFAULT_SPEND_LIMIT as the fallback.
+func (r *RateLimiter) SetLimitOverrides(defaultLimit *float64, tenants map[string]float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.limitOverrides = limitOverrides{defaultLimit: defaultLimit, tenants: tenants}
+	r.mu.Unlock()
+}
+
+// SetPricingOverrides replaces model pricing with the built-in table merged with
+// overrides. Overrides add new models or replace prices for existing ones.
+func (r *RateLimiter) SetPricingOverrides(overrides ProviderPricing) {
+	if r == nil {
+		return
+	}
+	pricing := MergePricing(GetPricing(), overrides)
+	r.mu.Lock()
+	r.pricing = pricing
+	r.pricingVersion = PricingVersionOf(pricing)
+	r.mu.Unlock()
+}
+
+// SetOverdraftOverride replaces OVERDRAFT_PERCENT with a file-configured value;
+// nil restores the environment setting.
+func (r *RateLimiter) SetOverdraftOverride(percent *float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.limitOverrides.overdraftPercent = percent
+	r.mu.Unlock()
+}
+
+// overdraftRatio returns the grace overdraft as a fraction of the limit.
+func (r *RateLimiter) overdraftRatio() float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	percent := r.overdraftPercent
+	if r.limitOverrides.overdraftPercent != nil {
+		percent = *r.limitOverrides.overdraftPercent
+	}
+	return max(0, percent) / 100
+}
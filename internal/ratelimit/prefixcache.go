@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"container/list"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Agent frameworks resend the same (often very large) system prompt and history
+// on every call. CountPromptTokens splits the prompt into chunks at fixed offsets
+// and caches the running token count of each prefix, so a request that shares a
+// prefix with an earlier one only tokenizes what comes after it.
+
+const (
+	// prefixChunkSize is the minimum chunk length in bytes. Chunks end at the next
+	// whitespace so no token straddles two chunks.
+	prefixChunkSize        = 2048
+	defaultPrefixCacheSize = 10000
+	// Prompts shorter than two chunks are cheap to count directly.
+	prefixCacheMinChunks = 2
+)
+
+// prefixCache maps a prefix hash to the token count of that prefix. It is a
+// bounded LRU; safe for concurrent use.
+type prefixCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[uint64]*list.Element
+}
+
+type prefixEntry struct {
+	key    uint64
+	tokens int
+}
+
+func newPrefixCache(capacity int) *prefixCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &prefixCache{capacity: capacity, order: list.New(), items: make(map[uint64]*list.Element)}
+}
+
+func (c *prefixCache) get(key uint64) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*prefixEntry).tokens, true
+}
+
+func (c *prefixCache) put(key uint64, tokens int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*prefixEntry).tokens = tokens
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&prefixEntry{key: key, tokens: tokens})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*prefixEntry).key)
+	}
+}
+
+var (
+	promptPrefixesOnce sync.Once
+	promptPrefixes     *prefixCache
+)
+
+// prefixCacheFromEnv reads TOKEN_PREFIX_CACHE_SIZE (prefixes kept, default 10000; 0 disables).
+func prefixCacheFromEnv() *prefixCache {
+	size := defaultPrefixCacheSize
+	if v := os.Getenv("TOKEN_PREFIX_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			size = n
+		}
+	}
+	return newPrefixCache(size)
+}
+
+// CountPromptTokens counts tokens like CountTokens, reusing cached counts for
+// prompt prefixes seen before. Short prompts are counted directly.
+func CountPromptTokens(text, model string) int {
+	promptPrefixesOnce.Do(func() { promptPrefixes = prefixCacheFromEnv() })
+	return countWithPrefixCache(promptPrefixes, text, model)
+}
+
+func countWithPrefixCache(cache *prefixCache, text, model string) int {
+	chunks := splitPromptChunks(text)
+	if cache == nil || len(chunks) < prefixCacheMinChunks {
+		return CountTokens(text, model)
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(model + "\x00"))
+
+	total := 0
+	cached := true
+	for _, chunk := range chunks {
+		_, _ = h.Write([]byte(chunk))
+		key := h.Sum64()
+		if cached {
+			if tokens, ok := cache.get(key); ok {
+				total = tokens
+				continue
+			}
+			cached = false
+		}
+		total += CountTokens(chunk, model)
+		cache.put(key, total)
+	}
+	return total
+}
+
+// splitPromptChunks cuts text into chunks of at least prefixChunkSize bytes,
+// each ending just before a whitespace character. Identical prefixes therefore
+// always produce identical leading chunks.
+func splitPromptChunks(text string) []string {
+	var chunks []string
+	for len(text) > prefixChunkSize {
+		idx := strings.IndexAny(text[prefixChunkSize:], " \t\n\r")
+		if idx < 0 {
+			break
+		}
+		cut := prefixChunkSize + idx
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
@@ -2,8 +2,8 @@ package ratelimit
 
 // Pricing represents token pricing for a model
 type Pricing struct {
-	InputPrice  float64 // Price per 1M tokens
-	OutputPrice float64 // Price per 1M tokens
+	InputPrice  float64 `json:"input_price"`  // Price per 1M tokens
+	OutputPrice float64 `json:"output_price"` // Price per 1M tokens
 }
 
 // ModelPricing stores pricing for all models
@@ -346,3 +346,16 @@ func DefaultPricing(provider string) Pricing {
 		}
 	}
 }
+
+// BatchDiscount is the share of the list price taken off requests submitted
+// through a provider's batch API: OpenAI and Anthropic both bill them at half
+// price.
+const BatchDiscount = 0.5
+
+// BatchPricing returns pricing for requests of a batch job.
+func BatchPricing(pricing Pricing) Pricing {
+	return Pricing{
+		InputPrice:  pricing.InputPrice * (1 - BatchDiscount),
+		OutputPrice: pricing.OutputPrice * (1 - BatchDiscount),
+	}
+}
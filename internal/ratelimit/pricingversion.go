@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// PricingVersionOf identifies a pricing table by content: the first 12 hex
+// characters of the SHA-256 of its JSON encoding (map keys are sorted, so equal
+// tables always hash the same). Usage records carry it so billing exports can
+// tell which prices a cost was computed with.
+func PricingVersionOf(pricing ProviderPricing) string {
+	data, _ := json.Marshal(pricing)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// PricingVersion returns the version of the limiter's current pricing table.
+func (r *RateLimiter) PricingVersion() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pricingVersion
+}
+
+// PricingTable returns the limiter's current pricing table (built-in prices
+// merged with pricing.json). The table must not be modified.
+func (r *RateLimiter) PricingTable() ProviderPricing {
+	if r == nil {
+		return GetPricing()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pricing
+}
+
+// MergePricing returns base with overrides applied: overrides add models or
+// replace their prices. Neither argument is modified.
+func MergePricing(base, overrides ProviderPricing) ProviderPricing {
+	merged := make(ProviderPricing, len(base))
+	for provider, models := range base {
+		merged[provider] = make(ModelPricing, len(models))
+		for model, p := range models {
+			merged[provider][model] = p
+		}
+	}
+	for provider, models := range overrides {
+		if merged[provider] == nil {
+			merged[provider] = ModelPricing{}
+		}
+		for model, p := range models {
+			merged[provider][model] = p
+		}
+	}
+	return merged
+}
+
+// CostDelta compares recorded and recomputed cost for one group of records.
+type CostDelta struct {
+	Records    int     `json:"records"`
+	Recorded   float64 `json:"recorded"`
+	Recomputed float64 `json:"recomputed"`
+	Delta      float64 `json:"delta"`
+}
+
+func (d *CostDelta) add(recorded, recomputed float64) {
+	d.Records++
+	d.Recorded += recorded
+	d.Recomputed += recomputed
+	d.Delta = d.Recomputed - d.Recorded
+}
+
+// UsageRecomputation is a spend report re-priced with a corrected pricing
+// table. The usage log is not modified; recorded costs stay auditable.
+type UsageRecomputation struct {
+	PricingVersion string    `json:"pricing_version"`
+	Total          CostDelta `json:"total"`
+	// Repriced counts records whose cost changed.
+	Repriced int `json:"repriced"`
+	// Unpriced counts records whose model is missing from the table; their
+	// recorded cost is kept.
+	Unpriced int `json:"unpriced"`
+	// RecordedVersions counts records by the pricing version they were
+	// recorded with ("" for records written before versioning).
+	RecordedVersions map[string]int       `json:"recorded_versions"`
+	ByTenant         map[string]CostDelta `json:"by_tenant"`
+	ByModel          map[string]CostDelta `json:"by_model"`
+	// NextCursor is set when the scan stopped early; pass it as the usage
+	// query cursor to continue.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewUsageRecomputation starts a report priced with pricing.
+func NewUsageRecomputation(pricing ProviderPricing) *UsageRecomputation {
+	return &UsageRecomputation{
+		PricingVersion:   PricingVersionOf(pricing),
+		RecordedVersions: map[string]int{},
+		ByTenant:         map[string]CostDelta{},
+		ByModel:          map[string]CostDelta{},
+	}
+}
+
+// Add re-prices records from their token counts and adds them to the report.
+// Denied requests carry no tokens and recompute to their recorded cost (0).
+func (u *UsageRecomputation) Add(pricing ProviderPricing, records []UsageRecord) {
+	for _, rec := range records {
+		recomputed := rec.Cost
+		if rec.InputTokens > 0 || rec.OutputTokens > 0 {
+			if p, ok := pricing[rec.Provider][rec.Model]; ok {
+				recomputed = CalculateCost(rec.InputTokens, rec.OutputTokens, p)
+			} else {
+				u.Unpriced++
+			}
+		}
+		if ToMicros(recomputed) != ToMicros(rec.Cost) {
+			u.Repriced++
+		}
+		u.RecordedVersions[rec.PricingVersion]++
+		u.Total.add(rec.Cost, recomputed)
+		tenant := u.ByTenant[rec.TenantID]
+		tenant.add(rec.Cost, recomputed)
+		u.ByTenant[rec.TenantID] = tenant
+		model := u.ByModel[rec.Model]
+		model.add(rec.Cost, recomputed)
+		u.ByModel[rec.Model] = model
+	}
+}
@@ -2,10 +2,18 @@ package ratelimit
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"agent-sentinel/internal/egress"
+
+	"embedding-sidecar/redisconf"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -14,11 +22,20 @@ import (
 type RedisClient struct {
 	client      redis.UniversalClient
 	backendType string
+	// namespace prefixes every key (REDIS_NAMESPACE); see Key.
+	namespace string
+
+	// replicas serve spend reads when REDIS_REPLICA_URLS is set; see replicas.go.
+	replicas      []*replica
+	replicaMaxLag time.Duration
+	nextReplica   atomic.Uint64
 }
 
 // NewRedisClient creates a Redis client based on REDIS_URL environment variable
 // Supports single instance, cluster, and sentinel configurations
 // Returns nil if REDIS_URL is not set or connection fails (fail-open)
+// TLS, ACL credentials and pool tuning come from the REDIS_* variables read by
+// redisconf.FromEnv.
 func NewRedisClient() *RedisClient {
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
@@ -26,17 +43,10 @@ func NewRedisClient() *RedisClient {
 		return nil
 	}
 
-	client, backend := parseRedisURL(redisURL)
-	if client == nil {
-		slog.Warn("Failed to create Redis client, rate limiting disabled",
-			"redis_url", maskRedisURL(redisURL),
-		)
-		return nil
-	}
-
-	// Test connection
-	if err := client.Ping(context.Background()).Err(); err != nil {
-		slog.Warn("Redis connection test failed, rate limiting disabled",
+	settings := redisconf.FromEnv("REDIS_")
+	client, err := ConnectRedis(context.Background(), redisURL, settings)
+	if err != nil {
+		slog.Warn("Redis connection failed, rate limiting disabled",
 			"error", err,
 			"redis_url", maskRedisURL(redisURL),
 		)
@@ -47,11 +57,35 @@ func NewRedisClient() *RedisClient {
 		"redis_url", maskRedisURL(redisURL),
 	)
 
-	return &RedisClient{client: client, backendType: backend}
+	if replicaURLs := os.Getenv("REDIS_REPLICA_URLS"); replicaURLs != "" {
+		client.connectReplicas(context.Background(), replicaURLs, settings)
+	}
+
+	return client
+}
+
+// ConnectRedis builds a client for redisURL with settings applied and verifies
+// it with a ping.
+func ConnectRedis(ctx context.Context, redisURL string, settings redisconf.Settings) (*RedisClient, error) {
+	client, backend := parseRedisURL(redisURL, settings)
+	if client == nil {
+		return nil, errors.New("invalid or unsupported redis url")
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &RedisClient{client: client, backendType: backend, namespace: settings.Namespace}, nil
+}
+
+// MaskRedisURL masks credentials in a Redis URL for logging and reports.
+func MaskRedisURL(redisURL string) string {
+	return maskRedisURL(redisURL)
 }
 
-// parseRedisURL parses the Redis URL and returns appropriate client and backend type.
-func parseRedisURL(redisURL string) (redis.UniversalClient, string) {
+// parseRedisURL parses the Redis URL, applies settings on top of it and returns
+// appropriate client and backend type.
+func parseRedisURL(redisURL string, settings redisconf.Settings) (redis.UniversalClient, string) {
 	parsedURL, err := url.Parse(redisURL)
 	if err != nil {
 		slog.Error("Invalid Redis URL format",
@@ -72,6 +106,18 @@ func parseRedisURL(redisURL string) (redis.UniversalClient, string) {
 			)
 			return nil, ""
 		}
+		if opt.TLSConfig != nil && settings.CAFile == "" {
+			pool, err := egress.RootCAs()
+			if err != nil {
+				slog.Error("Failed to load Redis CA", "error", err)
+				return nil, ""
+			}
+			opt.TLSConfig.RootCAs = pool
+		}
+		if err := settings.Apply(opt); err != nil {
+			slog.Error("Invalid Redis client settings", "error", err)
+			return nil, ""
+		}
 		return redis.NewClient(opt), "single"
 
 	case "redis-cluster", "rediss-cluster":
@@ -85,10 +131,27 @@ func parseRedisURL(redisURL string) (redis.UniversalClient, string) {
 		// Parse password from URL if present
 		password, _ := parsedURL.User.Password()
 
-		return redis.NewClusterClient(&redis.ClusterOptions{
+		opts := &redis.ClusterOptions{
 			Addrs:    addrs,
+			Username: parsedURL.User.Username(),
 			Password: password,
-		}), "cluster"
+		}
+		if parsedURL.Scheme == "rediss-cluster" {
+			tlsConfig, err := egress.TLSConfig()
+			if err != nil {
+				slog.Error("Failed to load Redis CA", "error", err)
+				return nil, ""
+			}
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			opts.TLSConfig = tlsConfig
+		}
+		if err := settings.ApplyCluster(opts); err != nil {
+			slog.Error("Invalid Redis client settings", "error", err)
+			return nil, ""
+		}
+		return redis.NewClusterClient(opts), "cluster"
 
 	case "sentinel":
 		// Sentinel mode - URL format: sentinel://localhost:26379?master=mymaster&password=xxx
@@ -101,12 +164,18 @@ func parseRedisURL(redisURL string) (redis.UniversalClient, string) {
 		password, _ := parsedURL.User.Password()
 		sentinelPassword := parsedURL.Query().Get("sentinel_password")
 
-		return redis.NewFailoverClient(&redis.FailoverOptions{
+		opts := &redis.FailoverOptions{
 			MasterName:       masterName,
 			SentinelAddrs:    []string{parsedURL.Host},
+			Username:         parsedURL.User.Username(),
 			Password:         password,
 			SentinelPassword: sentinelPassword,
-		}), "sentinel"
+		}
+		if err := settings.ApplyFailover(opts); err != nil {
+			slog.Error("Invalid Redis client settings", "error", err)
+			return nil, ""
+		}
+		return redis.NewFailoverClient(opts), "sentinel"
 
 	default:
 		slog.Error("Unsupported Redis URL scheme",
@@ -140,6 +209,29 @@ func (r *RedisClient) Client() redis.UniversalClient {
 	return r.client
 }
 
+// Namespace returns the key namespace, empty when keys are not prefixed.
+func (r *RedisClient) Namespace() string {
+	if r == nil {
+		return ""
+	}
+	return r.namespace
+}
+
+// Key returns name inside the client's namespace, so several deployments can
+// share one Redis without colliding on tenant IDs.
+func (r *RedisClient) Key(name string) string {
+	return redisconf.Key(r.Namespace(), name)
+}
+
+// Keys returns names inside the client's namespace.
+func (r *RedisClient) Keys(names ...string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = r.Key(name)
+	}
+	return keys
+}
+
 // Backend returns the redis backend type (single, cluster, sentinel).
 func (r *RedisClient) Backend() string {
 	return r.backendType
@@ -147,6 +239,9 @@ func (r *RedisClient) Backend() string {
 
 // Close closes the Redis connection
 func (r *RedisClient) Close() error {
+	for _, rep := range r.replicas {
+		_ = rep.client.Close()
+	}
 	if r.client != nil {
 		return r.client.Close()
 	}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"embedding-sidecar/redisconf"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultReplicaMaxLag is how far behind the primary a replica may be before
+// spend reads stop using it.
+const defaultReplicaMaxLag = 10 * time.Second
+
+// replica is a read-only Redis node serving GetSpend/GetLimit/ListTenants.
+type replica struct {
+	client  redis.UniversalClient
+	addr    string
+	healthy atomic.Bool
+}
+
+// connectReplicas connects the comma-separated REDIS_REPLICA_URLS. Replicas that
+// cannot be reached are still kept (marked unhealthy) so the health check can
+// bring them back once they recover.
+func (r *RedisClient) connectReplicas(ctx context.Context, urls string, settings redisconf.Settings) {
+	for _, raw := range strings.Split(urls, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		client, _ := parseRedisURL(raw, settings)
+		if client == nil {
+			continue
+		}
+		rep := &replica{client: client, addr: maskRedisURL(raw)}
+		rep.healthy.Store(client.Ping(ctx).Err() == nil)
+		r.replicas = append(r.replicas, rep)
+		slog.Info("Redis read replica configured",
+			"replica", rep.addr,
+			"healthy", rep.healthy.Load(),
+		)
+	}
+
+	r.replicaMaxLag = defaultReplicaMaxLag
+	if v := os.Getenv("REDIS_REPLICA_MAX_LAG_SECONDS"); v != "" {
+		if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed > 0 {
+			r.replicaMaxLag = time.Duration(parsed * float64(time.Second))
+		}
+	}
+}
+
+// pickReplica returns the next healthy replica round-robin, or nil when none are.
+func (r *RedisClient) pickReplica() *replica {
+	n := len(r.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := r.nextReplica.Add(1)
+	for i := range uint64(n) {
+		rep := r.replicas[(start+i)%uint64(n)]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// read runs fn against a healthy replica, falling back to the primary when no
+// replica is healthy or the replica call fails. Writes and Lua scripts must
+// always use Client().
+func (r *RedisClient) read(ctx context.Context, fn func(redis.UniversalClient) error) error {
+	if rep := r.pickReplica(); rep != nil {
+		err := fn(rep.client)
+		if err == nil || errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			return err
+		}
+		rep.healthy.Store(false)
+		slog.Warn("Redis replica read failed, falling back to primary",
+			"replica", rep.addr,
+			"error", err,
+		)
+	}
+	return fn(r.client)
+}
+
+// CheckReplicas refreshes replica health: a replica is used only while its link to
+// the primary is up and it has heard from the primary within the max lag.
+func (r *RedisClient) CheckReplicas(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for _, rep := range r.replicas {
+		info, err := rep.client.Info(ctx, "replication").Result()
+		healthy := err == nil && replicaInSync(info, r.replicaMaxLag)
+		if was := rep.healthy.Swap(healthy); was != healthy {
+			slog.Info("Redis read replica health changed",
+				"replica", rep.addr,
+				"healthy", healthy,
+				"error", err,
+			)
+		}
+	}
+}
+
+// RunReplicaHealthChecks periodically refreshes replica health until ctx is cancelled.
+func (r *RedisClient) RunReplicaHealthChecks(ctx context.Context, interval time.Duration) {
+	if r == nil || len(r.replicas) == 0 || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.CheckReplicas(ctx)
+		}
+	}
+}
+
+// replicaInSync parses INFO replication output from a replica.
+func replicaInSync(info string, maxLag time.Duration) bool {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":"); ok {
+			fields[k] = v
+		}
+	}
+	if fields["role"] != "slave" || fields["master_link_status"] != "up" {
+		return false
+	}
+	secs, err := strconv.Atoi(fields["master_last_io_seconds_ago"])
+	if err != nil || secs < 0 {
+		return false
+	}
+	return time.Duration(secs)*time.Second <= maxLag
+}
+
+// RunReplicaHealthChecks refreshes read-replica health until ctx is cancelled.
+func (r *RateLimiter) RunReplicaHealthChecks(ctx context.Context, interval time.Duration) {
+	if r == nil || r.client == nil {
+		return
+	}
+	r.client.RunReplicaHealthChecks(ctx, interval)
+}
@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-sentinel/internal/telemetry"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reservationLedgerKey is a sorted set of reservation IDs scored by expiry (unix seconds).
+// Each member has a companion hash at reservationKey(id) with tenant, estimate and bucket.
+const reservationLedgerKey = "reservations"
+
+// defaultReservationTTL is how long an unsettled reservation lives before it is
+// considered orphaned.
+const defaultReservationTTL = 15 * time.Minute
+
+// reconcileBatchSize bounds how many orphaned reservations are settled per pass.
+const reconcileBatchSize = 100
+
+func reservationKey(id string) string {
+	return "reservation:" + id
+}
+
+func newReservationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Fall back to a time-based ID; uniqueness only needs to hold within the TTL.
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// reconcileReservationLUA refunds an orphaned reservation exactly once.
+// ZREM acts as the claim: only the instance that removes the member refunds it,
+// so concurrent reconcilers across proxy instances cannot double refund.
+// KEYS[3..] are the spend keys charged at reservation time (tenant, then ancestors).
+// For prepaid reservations (ARGV[2]) the last two keys are the credit balance and
+// its legacy key; credits are always refunded since they do not age out.
+const reconcileReservationLUA = microUnitsLUA + `
+local ledgerKey = KEYS[1]
+local reservationKey = KEYS[2]
+local reservationID = ARGV[1]
+local prepaid = ARGV[2] == 'prepaid'
+
+if redis.call('ZREM', ledgerKey, reservationID) == 0 then
+  return 0
+end
+
+local bucket = redis.call('HGET', reservationKey, 'bucket')
+local estimate = reservedMicros(reservationKey) or 0
+redis.call('DEL', reservationKey)
+
+if not bucket or estimate == 0 then
+  return 0
+end
+
+local lastSpendKey = #KEYS
+local refunded = 0
+if prepaid then
+  migrateCredits(KEYS[#KEYS - 1], KEYS[#KEYS])
+  redis.call('INCRBY', KEYS[#KEYS - 1], fmtMicros(estimate))
+  lastSpendKey = #KEYS - 2
+  refunded = 1
+end
+
+-- Only refund buckets still inside the window; older ones have already aged out
+local redisTime = redis.call('TIME')
+local now = tonumber(redisTime[1])
+local oneHourAgo = math.floor(now / 60) * 60 - 3600
+if tonumber(bucket) < oneHourAgo then
+  return refunded
+end
+
+for i = 3, lastSpendKey do
+  if redis.call('HEXISTS', KEYS[i], bucket) == 1 then
+    migrateSpend(KEYS[i])
+    redis.call('HINCRBY', KEYS[i], bucket, fmtMicros(-estimate))
+  end
+end
+return 1
+`
+
+// ReconcileOrphans refunds reservations whose TTL expired without being settled by
+// AdjustCost or RefundEstimate (e.g. the owning proxy crashed mid-request).
+// Returns the number of reservations refunded.
+func (r *RateLimiter) ReconcileOrphans(ctx context.Context) (int, error) {
+	if r == nil || r.client == nil {
+		return 0, nil
+	}
+
+	client := r.client.Client()
+	start := time.Now()
+
+	redisTime, err := client.Time(ctx).Result()
+	if err != nil {
+		telemetry.IncRedisError(ctx, "reconcile_reservations", r.client.Backend(), "")
+		return 0, err
+	}
+
+	ids, err := client.ZRangeByScore(ctx, r.client.Key(reservationLedgerKey), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(redisTime.Unix(), 10),
+		Count: reconcileBatchSize,
+	}).Result()
+	if err != nil {
+		telemetry.ObserveRedisLatency(ctx, "reconcile_reservations", r.client.Backend(), "error", time.Since(start), "")
+		telemetry.IncRedisError(ctx, "reconcile_reservations", r.client.Backend(), "")
+		return 0, err
+	}
+
+	script := redis.NewScript(reconcileReservationLUA)
+	refunded := 0
+	for _, id := range ids {
+		fields, err := client.HMGet(ctx, r.client.Key(reservationKey(id)), "tenant", "ancestors", "mode").Result()
+		if err != nil {
+			slog.Warn("Failed to load orphaned reservation", "error", err, "reservation_id", id)
+			continue
+		}
+		tenantID, _ := fields[0].(string)
+		if tenantID == "" {
+			// Companion hash expired; drop the dangling ledger entry.
+			_ = client.ZRem(ctx, r.client.Key(reservationLedgerKey), id).Err()
+			continue
+		}
+
+		keys := []string{reservationLedgerKey, reservationKey(id), "spend:" + tenantID}
+		if ancestors, _ := fields[1].(string); ancestors != "" {
+			for _, ancestor := range strings.Split(ancestors, ",") {
+				keys = append(keys, "spend:"+ancestor)
+			}
+		}
+		mode, _ := fields[2].(string)
+		if mode == AccountingPrepaid {
+			keys = append(keys, creditsKey(tenantID), legacyCreditsKey(tenantID))
+		}
+		res, err := runScript(ctx, script, client, r.client.Keys(keys...), id, mode)
+		if err != nil {
+			telemetry.IncRedisError(ctx, "reconcile_reservations", r.client.Backend(), tenantID)
+			slog.Warn("Failed to reconcile reservation", "error", err, "reservation_id", id, "tenant_id", tenantID)
+			continue
+		}
+		if n, ok := res.(int64); ok && n == 1 {
+			refunded++
+			telemetry.IncRefund(ctx, "", "", tenantID, "orphaned_reservation")
+			slog.Info("Refunded orphaned reservation", "reservation_id", id, "tenant_id", tenantID)
+		}
+	}
+
+	telemetry.ObserveRedisLatency(ctx, "reconcile_reservations", r.client.Backend(), "ok", time.Since(start), "")
+	return refunded, nil
+}
+
+// RunReconciler periodically refunds orphaned reservations until ctx is cancelled.
+// Safe to run on every proxy instance.
+func (r *RateLimiter) RunReconciler(ctx context.Context, interval time.Duration) {
+	if r == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.ReconcileOrphans(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Reservation reconciliation failed", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// maxScannedFieldLen bounds the string fields ScanRequest keeps; longer strings
+// (e.g. inline base64 data outside the text paths) are dropped.
+const maxScannedFieldLen = 1024
+
+// ScannedRequest is what estimation needs from a request body, read by
+// ScanRequest without decoding the body into a map.
+type ScannedRequest struct {
+	// Fields holds top-level scalars and the scalars of top-level objects
+	// (model, max_tokens, generationConfig.maxOutputTokens, temperature, ...)
+	// decoded as json.Unmarshal would. Arrays are left out.
+	Fields map[string]any
+	// Text is the strings found at the text paths, space-separated.
+	Text string
+}
+
+type scanFrame struct {
+	object  bool
+	key     string // current key, objects only
+	wantKey bool
+}
+
+// ScanRequest reads a JSON object token by token, keeping only the strings at
+// textPaths and small scalar fields. textPaths are key paths from the root
+// with "*" standing for any array element, e.g. {"messages", "*", "content"}.
+// Large prompts are then held once (as Text) instead of as a decoded tree
+// alongside the raw body.
+func ScanRequest(r io.Reader, textPaths [][]string) (*ScannedRequest, error) {
+	dec := json.NewDecoder(r)
+	first, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := first.(json.Delim); !ok || delim != '{' {
+		return nil, errors.New("request body is not a JSON object")
+	}
+
+	scanned := &ScannedRequest{Fields: map[string]any{}}
+	var text strings.Builder
+	stack := []scanFrame{{object: true, wantKey: true}}
+	path := []string{}
+
+	// valueDone marks the value at the top of path complete.
+	valueDone := func() {
+		top := &stack[len(stack)-1]
+		if top.object {
+			top.wantKey = true
+			path = path[:len(path)-1]
+		}
+	}
+
+	for len(stack) > 0 {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		top := &stack[len(stack)-1]
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if !top.object {
+				path = path[:len(path)-1]
+			}
+			if len(stack) > 0 {
+				valueDone()
+			}
+			continue
+		}
+		if top.object && top.wantKey {
+			top.key, _ = tok.(string)
+			top.wantKey = false
+			path = append(path, top.key)
+			continue
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			frame := scanFrame{object: delim == '{', wantKey: delim == '{'}
+			if len(stack) == 1 && frame.object {
+				scanned.Fields[path[0]] = map[string]any{}
+			}
+			stack = append(stack, frame)
+			if !frame.object {
+				path = append(path, "*")
+			}
+			continue
+		}
+
+		if s, ok := tok.(string); ok && matchesAnyPath(path, textPaths) {
+			if text.Len() > 0 {
+				text.WriteByte(' ')
+			}
+			text.WriteString(s)
+		} else if keepScannedField(tok) {
+			switch {
+			case len(stack) == 1:
+				scanned.Fields[path[0]] = tok
+			case len(stack) == 2 && stack[1].object:
+				if parent, ok := scanned.Fields[path[0]].(map[string]any); ok {
+					parent[path[1]] = tok
+				}
+			}
+		}
+		valueDone()
+	}
+	scanned.Text = text.String()
+	return scanned, nil
+}
+
+func keepScannedField(tok json.Token) bool {
+	if s, ok := tok.(string); ok {
+		return len(s) <= maxScannedFieldLen
+	}
+	return true
+}
+
+func matchesAnyPath(path []string, patterns [][]string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) != len(path) {
+			continue
+		}
+		match := true
+		for i := range pattern {
+			if pattern[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanRequest(t *testing.T) {
+	body := `{
+		"model": "gemini-1.5-pro",
+		"contents": [
+			{"role": "user", "parts": [{"text": "first"}, {"inline_data": {"data": "` + strings.Repeat("A", 4096) + `"}}]},
+			{"role": "model", "parts": [{"text": "second"}]}
+		],
+		"generationConfig": {"maxOutputTokens": 256, "temperature": 0.2, "stopSequences": ["x"]},
+		"note": "` + strings.Repeat("n", maxScannedFieldLen+1) + `"
+	}`
+	scanned, err := ScanRequest(strings.NewReader(body), [][]string{{"contents", "*", "parts", "*", "text"}})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if scanned.Text != "first second" {
+		t.Fatalf("unexpected text %q", scanned.Text)
+	}
+	if scanned.Fields["model"] != "gemini-1.5-pro" || ExtractMaxOutputTokens(scanned.Fields) != 256 {
+		t.Fatalf("unexpected fields %v", scanned.Fields)
+	}
+	if _, ok := scanned.Fields["note"]; ok {
+		t.Fatal("expected an oversized field to be dropped")
+	}
+	if _, ok := scanned.Fields["contents"]; ok {
+		t.Fatal("expected arrays to be left out")
+	}
+
+	for _, bad := range []string{`[1, 2]`, `{"model": "m"`, `{"model": }`} {
+		if _, err := ScanRequest(strings.NewReader(bad), nil); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleRule applies Limit on the given weekdays between Start and End
+// (HH:MM, local to the schedule's timezone). End before Start spans midnight,
+// in which case Days refers to the day the window starts.
+type ScheduleRule struct {
+	Name  string   `json:"name,omitempty"`
+	Days  []string `json:"days,omitempty"` // mon..sun; empty means every day
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+	Limit float64  `json:"limit"`
+}
+
+// Schedule is a tenant's time-based limit configuration. The first matching rule
+// wins; outside all rules the tenant's regular limit applies.
+type Schedule struct {
+	Timezone string         `json:"timezone,omitempty"` // IANA name, default UTC
+	Rules    []ScheduleRule `json:"rules"`
+}
+
+// Schedules is a validated set of per-tenant schedules.
+type Schedules map[string]compiledSchedule
+
+type compiledSchedule struct {
+	loc   *time.Location
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	name       string
+	days       [7]bool
+	start, end int // minutes since midnight
+	limit      float64
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// CompileSchedules validates schedules, resolving timezones and parsing times.
+func CompileSchedules(schedules map[string]Schedule) (Schedules, error) {
+	out := make(Schedules, len(schedules))
+	for tenantID, s := range schedules {
+		loc := time.UTC
+		if s.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(s.Timezone); err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", tenantID, err)
+			}
+		}
+		cs := compiledSchedule{loc: loc}
+		for i, rule := range s.Rules {
+			cr, err := compileRule(rule)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %s rule %d: %w", tenantID, i, err)
+			}
+			cs.rules = append(cs.rules, cr)
+		}
+		out[tenantID] = cs
+	}
+	return out, nil
+}
+
+func compileRule(rule ScheduleRule) (compiledRule, error) {
+	cr := compiledRule{name: rule.Name, limit: rule.Limit}
+	if rule.Limit < 0 {
+		return cr, fmt.Errorf("negative limit %v", rule.Limit)
+	}
+	var err error
+	if cr.start, err = parseClock(rule.Start); err != nil {
+		return cr, err
+	}
+	if cr.end, err = parseClock(rule.End); err != nil {
+		return cr, err
+	}
+	if len(rule.Days) == 0 {
+		for i := range cr.days {
+			cr.days[i] = true
+		}
+	}
+	for _, d := range rule.Days {
+		wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+		if !ok {
+			return cr, fmt.Errorf("unknown day %q", d)
+		}
+		cr.days[wd] = true
+	}
+	return cr, nil
+}
+
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active returns the first rule covering now.
+func (s compiledSchedule) active(now time.Time) (compiledRule, bool) {
+	local := now.In(s.loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+	for _, r := range s.rules {
+		if r.start < r.end {
+			if r.days[today] && minute >= r.start && minute < r.end {
+				return r, true
+			}
+			continue
+		}
+		// Window spans midnight (or the whole day when start == end)
+		if (r.days[today] && minute >= r.start) || (r.days[yesterday] && minute < r.end) {
+			return r, true
+		}
+	}
+	return compiledRule{}, false
+}
+
+// SetSchedules replaces the per-tenant limit schedules.
+func (r *RateLimiter) SetSchedules(schedules Schedules) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.schedules = schedules
+	r.mu.Unlock()
+}
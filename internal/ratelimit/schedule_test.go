@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActiveRules(t *testing.T) {
+	schedules, err := CompileSchedules(map[string]Schedule{
+		"acme": {
+			Timezone: "America/New_York",
+			Rules: []ScheduleRule{
+				{Name: "business-hours", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00", Limit: 10},
+				{Name: "overnight", Start: "22:00", End: "06:00", Limit: 2},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	s := schedules["acme"]
+
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2026, 1, 5, 10, 0, 0, 0, ny), "business-hours"}, // Monday
+		{time.Date(2026, 1, 5, 17, 0, 0, 0, ny), ""},               // end is exclusive
+		{time.Date(2026, 1, 10, 10, 0, 0, 0, ny), ""},              // Saturday
+		{time.Date(2026, 1, 5, 23, 30, 0, 0, ny), "overnight"},
+		{time.Date(2026, 1, 6, 5, 59, 0, 0, ny), "overnight"}, // after midnight
+		{time.Date(2026, 1, 5, 15, 0, 0, 0, time.UTC), "business-hours"},
+	}
+	for _, tc := range cases {
+		rule, ok := s.active(tc.at)
+		got := ""
+		if ok {
+			got = rule.name
+		}
+		if got != tc.want {
+			t.Fatalf("at %s: expected %q, got %q", tc.at, tc.want, got)
+		}
+	}
+}
+
+func TestCompileSchedulesRejectsInvalid(t *testing.T) {
+	bad := []Schedule{
+		{Timezone: "Mars/Olympus", Rules: []ScheduleRule{{Start: "09:00", End: "17:00"}}},
+		{Rules: []ScheduleRule{{Start: "9am", End: "17:00"}}},
+		{Rules: []ScheduleRule{{Days: []string{"funday"}, Start: "09:00", End: "17:00"}}},
+	}
+	for i, s := range bad {
+		if _, err := CompileSchedules(map[string]Schedule{"t": s}); err == nil {
+			t.Fatalf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestScheduledLimitPrecedence(t *testing.T) {
+	schedules, _ := CompileSchedules(map[string]Schedule{
+		"acme": {Rules: []ScheduleRule{{Name: "night", Start: "00:00", End: "06:00", Limit: 2}}},
+	})
+	rl := &RateLimiter{defaultLimit: 100}
+	rl.SetLimitOverrides(nil, map[string]float64{"acme": 10})
+	rl.SetSchedules(schedules)
+
+	rl.now = func() time.Time { return time.Date(2026, 1, 5, 3, 0, 0, 0, time.UTC) }
+	if limit, name := rl.scheduledLimit("acme"); limit != 2 || name != "night" {
+		t.Fatalf("expected night limit 2, got %v %q", limit, name)
+	}
+	rl.now = func() time.Time { return time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC) }
+	if limit, name := rl.scheduledLimit("acme"); limit != 10 || name != "" {
+		t.Fatalf("expected tenant limit 10 outside schedule, got %v %q", limit, name)
+	}
+}
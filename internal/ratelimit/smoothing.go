@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"agent-sentinel/internal/override"
+	"agent-sentinel/internal/telemetry"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SmoothingRate is a token bucket: Burst requests may be sent at once, and the
+// bucket refills at RatePerSecond.
+type SmoothingRate struct {
+	Burst         int     `json:"burst"`
+	RatePerSecond float64 `json:"rate_per_second"`
+}
+
+// SmoothingPolicy throttles bursts of requests per tenant, independently of
+// spend: a loop firing hundreds of cheap requests in a few seconds is held to
+// a sustainable rate even while its cost is within budget.
+type SmoothingPolicy struct {
+	// Default applies to tenants without their own rate; a zero Burst leaves
+	// them unthrottled.
+	Default SmoothingRate `json:"default"`
+	// Tenants overrides Default per tenant.
+	Tenants map[string]SmoothingRate `json:"tenants,omitempty"`
+}
+
+// Validate checks a smoothing policy.
+func (p SmoothingPolicy) Validate() error {
+	check := func(name string, r SmoothingRate) error {
+		if r.Burst < 0 || r.RatePerSecond < 0 {
+			return fmt.Errorf("smoothing %s: burst and rate_per_second must not be negative", name)
+		}
+		if r.Burst > 0 && r.RatePerSecond == 0 {
+			return fmt.Errorf("smoothing %s: rate_per_second is required with a burst", name)
+		}
+		return nil
+	}
+	if err := check("default", p.Default); err != nil {
+		return err
+	}
+	for tenantID, r := range p.Tenants {
+		if err := check(tenantID, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For returns tenantID's rate; ok is false when the tenant is not throttled.
+func (p SmoothingPolicy) For(tenantID string) (SmoothingRate, bool) {
+	r, found := p.Tenants[tenantID]
+	if !found {
+		r = p.Default
+	}
+	return r, r.Burst > 0 && r.RatePerSecond > 0
+}
+
+// smoothingPolicyFromEnv reads SMOOTHING_BURST and SMOOTHING_RATE_PER_SECOND.
+func smoothingPolicyFromEnv() SmoothingPolicy {
+	var p SmoothingPolicy
+	if v, err := strconv.Atoi(os.Getenv("SMOOTHING_BURST")); err == nil && v > 0 {
+		p.Default.Burst = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SMOOTHING_RATE_PER_SECOND"), 64); err == nil && v > 0 {
+		p.Default.RatePerSecond = v
+	}
+	return p
+}
+
+// SmoothingGuard holds the active smoothing policy. Safe for concurrent use.
+type SmoothingGuard struct {
+	active override.Value[SmoothingPolicy]
+}
+
+// NewSmoothingGuard returns a guard using the SMOOTHING_* variables.
+func NewSmoothingGuard() *SmoothingGuard {
+	env := smoothingPolicyFromEnv()
+	return &SmoothingGuard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *SmoothingGuard) Set(policy *SmoothingPolicy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *SmoothingGuard) Policy() SmoothingPolicy {
+	if g == nil {
+		return SmoothingPolicy{}
+	}
+	return g.active.Get()
+}
+
+// bucketKey holds a tenant's token bucket: the tokens left and when they were
+// counted.
+func bucketKey(tenantID string) string {
+	return "bucket:" + tenantID
+}
+
+// takeTokenLUA refills the bucket for the time since it was last touched, using
+// the Redis clock so replicas agree, and takes a token if one is available. It
+// returns {1, 0} when allowed, or {0, seconds until a token is available}. The
+// bucket expires once it would be full again.
+const takeTokenLUA = `
+local key = KEYS[1]
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = (1 - tokens) / rate
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', key, math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(wait)}
+`
+
+// toolBucketKey holds a tenant's token bucket for one MCP tool.
+func toolBucketKey(tenantID, tool string) string {
+	return "tool_bucket:" + tenantID + ":" + tool
+}
+
+// TakeToken takes a token from tenantID's bucket. When none is left it returns
+// false and how long until one is.
+func (r *RateLimiter) TakeToken(ctx context.Context, tenantID string, rate SmoothingRate) (bool, time.Duration, error) {
+	if r == nil || r.client == nil {
+		return false, 0, errLimiterUnavailable
+	}
+	return r.takeToken(ctx, bucketKey(tenantID), tenantID, rate)
+}
+
+// TakeToolToken takes a token from tenantID's bucket for an MCP tool, kept
+// apart from the tenant's request bucket.
+func (r *RateLimiter) TakeToolToken(ctx context.Context, tenantID, tool string, rate SmoothingRate) (bool, time.Duration, error) {
+	if r == nil || r.client == nil {
+		return false, 0, errLimiterUnavailable
+	}
+	return r.takeToken(ctx, toolBucketKey(tenantID, tool), tenantID, rate)
+}
+
+func (r *RateLimiter) takeToken(ctx context.Context, key, tenantID string, rate SmoothingRate) (bool, time.Duration, error) {
+	start := time.Now()
+	result, err := runScript(ctx, redis.NewScript(takeTokenLUA), r.client.Client(), r.client.Keys(key), rate.Burst, rate.RatePerSecond)
+	if err != nil {
+		telemetry.ObserveRedisLatency(ctx, "take_token", r.client.Backend(), "error", time.Since(start), tenantID)
+		telemetry.IncRedisError(ctx, "take_token", r.client.Backend(), tenantID)
+		return false, 0, err
+	}
+	telemetry.ObserveRedisLatency(ctx, "take_token", r.client.Backend(), "ok", time.Since(start), tenantID)
+
+	values, ok := result.([]any)
+	if !ok || len(values) != 2 {
+		return false, 0, errors.New("unexpected take_token result")
+	}
+	allowed, _ := values[0].(int64)
+	waitStr, _ := values[1].(string)
+	wait, _ := strconv.ParseFloat(waitStr, 64)
+	return allowed == 1, time.Duration(wait * float64(time.Second)), nil
+}
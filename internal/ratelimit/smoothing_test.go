@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTakeTokenParsesResult(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	var gotArgs []any
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys, gotArgs = keys, args
+		return []any{int64(0), "0.25"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}}
+	allowed, wait, err := rl.TakeToken(context.Background(), "t1", SmoothingRate{Burst: 10, RatePerSecond: 4})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if allowed || wait != 250*time.Millisecond {
+		t.Fatalf("expected a denial with a 250ms wait, got %v %v", allowed, wait)
+	}
+	if len(gotKeys) != 1 || gotKeys[0] != "bucket:t1" || gotArgs[0] != 10 || gotArgs[1] != 4.0 {
+		t.Fatalf("unexpected script call %v %v", gotKeys, gotArgs)
+	}
+}
+
+func TestTakeToolTokenUsesToolBucket(t *testing.T) {
+	defer func() { runScript = defaultRunScript }()
+	var gotKeys []string
+	runScript = func(ctx context.Context, script *redis.Script, client redis.UniversalClient, keys []string, args ...any) (any, error) {
+		gotKeys = keys
+		return []any{int64(1), "0"}, nil
+	}
+	rl := &RateLimiter{client: &RedisClient{}}
+	allowed, _, err := rl.TakeToolToken(context.Background(), "t1", "github/create_issue", SmoothingRate{Burst: 5, RatePerSecond: 1})
+	if err != nil || !allowed {
+		t.Fatalf("expected the token taken, got %v %v", allowed, err)
+	}
+	if len(gotKeys) != 1 || gotKeys[0] != "tool_bucket:t1:github/create_issue" {
+		t.Fatalf("unexpected keys %v", gotKeys)
+	}
+}
+
+func TestSmoothingPolicy(t *testing.T) {
+	p := SmoothingPolicy{
+		Default: SmoothingRate{Burst: 20, RatePerSecond: 2},
+		Tenants: map[string]SmoothingRate{"batch": {Burst: 100, RatePerSecond: 10}, "exempt": {}},
+	}
+	if r, ok := p.For("acme"); !ok || r.Burst != 20 {
+		t.Fatalf("expected the default rate, got %+v %v", r, ok)
+	}
+	if r, ok := p.For("batch"); !ok || r.Burst != 100 {
+		t.Fatalf("expected the tenant rate, got %+v %v", r, ok)
+	}
+	if _, ok := p.For("exempt"); ok {
+		t.Fatal("expected a zero tenant rate to exempt the tenant")
+	}
+	if err := (SmoothingPolicy{Default: SmoothingRate{Burst: 5}}).Validate(); err == nil {
+		t.Fatal("expected a burst without a rate to be rejected")
+	}
+}
@@ -0,0 +1,215 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SnapshotFormat is the version of the Snapshot layout written by this build.
+const SnapshotFormat = 1
+
+// spendKeyTTL matches the expiry the scripts give spend hashes.
+const spendKeyTTL = 2 * time.Hour
+
+// Snapshot is the budget state of every tenant: spend buckets, custom limits
+// and prepaid credits, plus shadow mode. It is exported for disaster recovery
+// and for moving to a new Redis, and restored with Restore. Amounts are integer
+// micro-cents, as stored, so a round trip is exact.
+type Snapshot struct {
+	Format        int              `json:"format"`
+	SchemaVersion int              `json:"schema_version"`
+	TakenAt       time.Time        `json:"taken_at"`
+	ShadowMode    bool             `json:"shadow_mode"`
+	Tenants       []TenantSnapshot `json:"tenants"`
+}
+
+// TenantSnapshot is one tenant's budget state.
+type TenantSnapshot struct {
+	TenantID string `json:"tenant_id"`
+	// SpendMicros maps minute buckets (unix seconds) to spend in micro-cents.
+	SpendMicros map[string]int64 `json:"spend_micros,omitempty"`
+	// Limit is the custom hourly limit in USD; nil when the tenant has none.
+	Limit *float64 `json:"limit,omitempty"`
+	// CreditsMicros is the prepaid balance; nil when never topped up.
+	CreditsMicros *int64 `json:"credits_micros,omitempty"`
+}
+
+// Validate checks a snapshot before it is restored.
+func (s *Snapshot) Validate() error {
+	if s.Format != SnapshotFormat {
+		return fmt.Errorf("unsupported snapshot format %d (want %d)", s.Format, SnapshotFormat)
+	}
+	if s.SchemaVersion > SchemaVersion() {
+		return fmt.Errorf("snapshot schema v%d is newer than this build (v%d)", s.SchemaVersion, SchemaVersion())
+	}
+	seen := make(map[string]bool, len(s.Tenants))
+	for _, t := range s.Tenants {
+		if t.TenantID == "" {
+			return errors.New("snapshot tenant without tenant_id")
+		}
+		if seen[t.TenantID] {
+			return fmt.Errorf("snapshot tenant %q listed twice", t.TenantID)
+		}
+		seen[t.TenantID] = true
+		if t.Limit != nil && *t.Limit < 0 {
+			return fmt.Errorf("snapshot tenant %q: limit must not be negative", t.TenantID)
+		}
+		for bucket := range t.SpendMicros {
+			if _, err := strconv.ParseInt(bucket, 10, 64); err != nil {
+				return fmt.Errorf("snapshot tenant %q: spend bucket %q is not a unix timestamp", t.TenantID, bucket)
+			}
+		}
+	}
+	return nil
+}
+
+// Snapshot exports the budget state of every tenant with spend, a custom limit
+// or credits. Requests in flight while it runs may be caught mid-update for
+// their tenant; take it with traffic drained for an exact copy.
+func (r *RateLimiter) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if r == nil || r.client == nil {
+		return nil, errLimiterUnavailable
+	}
+	client := r.client.Client()
+	now, err := client.Time(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	shadow, err := r.ShadowMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var tenantIDs []string
+	for _, prefix := range []string{"spend:", "limit:", "ucredits:", "credits:"} {
+		namespaced := r.client.Key(prefix)
+		err := r.scanKeys(ctx, prefix+"*", func(key string) error {
+			if id := strings.TrimPrefix(key, namespaced); !seen[id] {
+				seen[id] = true
+				tenantIDs = append(tenantIDs, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(tenantIDs)
+	snap := &Snapshot{Format: SnapshotFormat, SchemaVersion: SchemaVersion(), TakenAt: now.UTC(), ShadowMode: shadow, Tenants: []TenantSnapshot{}}
+	for _, tenantID := range tenantIDs {
+		t, err := r.tenantSnapshot(ctx, tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenantID, err)
+		}
+		snap.Tenants = append(snap.Tenants, t)
+	}
+	return snap, nil
+}
+
+func (r *RateLimiter) tenantSnapshot(ctx context.Context, tenantID string) (TenantSnapshot, error) {
+	t := TenantSnapshot{TenantID: tenantID}
+	pipe := r.client.Client().Pipeline()
+	spendCmd := pipe.HGetAll(ctx, r.client.Key(fmt.Sprintf("spend:%s", tenantID)))
+	limitCmd := pipe.Get(ctx, r.client.Key(fmt.Sprintf("limit:%s", tenantID)))
+	creditsCmd := pipe.MGet(ctx, r.client.Keys(creditsKey(tenantID), legacyCreditsKey(tenantID))...)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return t, err
+	}
+
+	buckets := spendCmd.Val()
+	micro := buckets[spendUnitField] == "ucent"
+	for bucket, value := range buckets {
+		if bucket == spendUnitField {
+			continue
+		}
+		if t.SpendMicros == nil {
+			t.SpendMicros = make(map[string]int64, len(buckets))
+		}
+		if micro {
+			t.SpendMicros[bucket], _ = strconv.ParseInt(value, 10, 64)
+		} else {
+			usd, _ := strconv.ParseFloat(value, 64)
+			t.SpendMicros[bucket] = ToMicros(usd)
+		}
+	}
+
+	if v, err := limitCmd.Result(); err == nil {
+		limit, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid limit %q", v)
+		}
+		t.Limit = &limit
+	}
+
+	values := creditsCmd.Val()
+	if len(values) == 2 && (values[0] != nil || values[1] != nil) {
+		var balance int64
+		if v, ok := values[0].(string); ok {
+			balance, _ = strconv.ParseInt(v, 10, 64)
+		}
+		if v, ok := values[1].(string); ok {
+			legacy, _ := strconv.ParseFloat(v, 64)
+			balance += ToMicros(legacy)
+		}
+		t.CreditsMicros = &balance
+	}
+	return t, nil
+}
+
+// Restore writes a snapshot's budget state, replacing the spend, limit and
+// credits of every tenant it lists. Tenants not in the snapshot are left as
+// they are. Run it before sending traffic to the restored Redis; reservations
+// in flight when the snapshot was taken stay charged.
+func (r *RateLimiter) Restore(ctx context.Context, snap *Snapshot) (int, error) {
+	if r == nil || r.client == nil {
+		return 0, errLimiterUnavailable
+	}
+	if err := snap.Validate(); err != nil {
+		return 0, err
+	}
+	client := r.client.Client()
+	restored := 0
+	for _, t := range snap.Tenants {
+		spendKey := r.client.Key(fmt.Sprintf("spend:%s", t.TenantID))
+		limitKey := r.client.Key(fmt.Sprintf("limit:%s", t.TenantID))
+		pipe := client.Pipeline()
+		pipe.Del(ctx, spendKey)
+		if len(t.SpendMicros) > 0 {
+			fields := []any{spendUnitField, "ucent"}
+			for bucket, micros := range t.SpendMicros {
+				fields = append(fields, bucket, strconv.FormatInt(micros, 10))
+			}
+			pipe.HSet(ctx, spendKey, fields...)
+			pipe.Expire(ctx, spendKey, spendKeyTTL)
+		}
+		if t.Limit != nil {
+			pipe.Set(ctx, limitKey, strconv.FormatFloat(*t.Limit, 'f', -1, 64), 0)
+		} else {
+			pipe.Del(ctx, limitKey)
+		}
+		pipe.Del(ctx, r.client.Key(legacyCreditsKey(t.TenantID)))
+		if t.CreditsMicros != nil {
+			pipe.Set(ctx, r.client.Key(creditsKey(t.TenantID)), strconv.FormatInt(*t.CreditsMicros, 10), 0)
+		} else {
+			pipe.Del(ctx, r.client.Key(creditsKey(t.TenantID)))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return restored, fmt.Errorf("tenant %q: %w", t.TenantID, err)
+		}
+		r.limits.invalidate(t.TenantID)
+		restored++
+	}
+	if err := r.SetShadowMode(ctx, snap.ShadowMode); err != nil {
+		return restored, err
+	}
+	return restored, nil
+}
@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotValidate(t *testing.T) {
+	negative := -1.0
+	cases := map[string]struct {
+		snap Snapshot
+		want string
+	}{
+		"format":    {Snapshot{Format: 2}, "unsupported snapshot format"},
+		"schema":    {Snapshot{Format: SnapshotFormat, SchemaVersion: SchemaVersion() + 1}, "newer than this build"},
+		"tenant id": {Snapshot{Format: SnapshotFormat, Tenants: []TenantSnapshot{{}}}, "without tenant_id"},
+		"duplicate": {Snapshot{Format: SnapshotFormat, Tenants: []TenantSnapshot{{TenantID: "a"}, {TenantID: "a"}}}, "listed twice"},
+		"limit":     {Snapshot{Format: SnapshotFormat, Tenants: []TenantSnapshot{{TenantID: "a", Limit: &negative}}}, "must not be negative"},
+		"bucket":    {Snapshot{Format: SnapshotFormat, Tenants: []TenantSnapshot{{TenantID: "a", SpendMicros: map[string]int64{"unit": 1}}}}, "not a unix timestamp"},
+	}
+	for name, tc := range cases {
+		if err := tc.snap.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+	ok := Snapshot{Format: SnapshotFormat, SchemaVersion: SchemaVersion(), Tenants: []TenantSnapshot{{TenantID: "a", SpendMicros: map[string]int64{"1704067200": 5}}}}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSnapshotWithoutRedis(t *testing.T) {
+	var rl *RateLimiter
+	if _, err := rl.Snapshot(context.Background()); !errors.Is(err, errLimiterUnavailable) {
+		t.Fatalf("expected errLimiterUnavailable, got %v", err)
+	}
+	if _, err := rl.Restore(context.Background(), &Snapshot{Format: SnapshotFormat}); !errors.Is(err, errLimiterUnavailable) {
+		t.Fatalf("expected errLimiterUnavailable, got %v", err)
+	}
+}
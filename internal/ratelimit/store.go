@@ -0,0 +1,259 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// maxStoreAttempts bounds compare-and-swap retries when concurrent instances
+// update the same tenant.
+const maxStoreAttempts = 10
+
+var errStoreContention = errors.New("spend store contention: too many concurrent updates")
+
+// SpendStore persists each tenant's spend window for StoreLimiter. A tenant's
+// state is read and written as one record so check-and-increment stays atomic
+// across instances through conditional writes.
+type SpendStore interface {
+	// Load returns the tenant's state and its revision; revision 0 and an empty
+	// state when the tenant has no record.
+	Load(ctx context.Context, tenantID string) (*TenantSpend, int64, error)
+	// CompareAndSwap writes state only if the record is still at revision
+	// (0: does not exist yet). It reports false when another writer got there first.
+	CompareAndSwap(ctx context.Context, tenantID string, revision int64, state *TenantSpend) (bool, error)
+}
+
+// TenantSpend is a tenant's stored limit, minute buckets and open reservations.
+type TenantSpend struct {
+	// Limit overrides DEFAULT_SPEND_LIMIT when set.
+	Limit        *float64                     `json:"limit,omitempty"`
+	Buckets      map[int64]int64              `json:"buckets,omitempty"` // minute bucket (unix seconds) -> micro-cents
+	Reservations map[string]StoredReservation `json:"reservations,omitempty"`
+}
+
+// StoredReservation is an unsettled estimate held in a TenantSpend.
+type StoredReservation struct {
+	Amount  int64 `json:"amount"` // micro-cents
+	Bucket  int64 `json:"bucket"`
+	Expires int64 `json:"expires"` // unix seconds
+}
+
+// spend sums the buckets in the hour ending at bucket, dropping older ones.
+func (s *TenantSpend) spend(bucket int64) float64 {
+	var total int64
+	for b, cost := range s.Buckets {
+		if b < bucket-3600 {
+			delete(s.Buckets, b)
+			continue
+		}
+		total += cost
+	}
+	return FromMicros(total)
+}
+
+func (s *TenantSpend) charge(bucket int64, amount int64) {
+	if s.Buckets == nil {
+		s.Buckets = make(map[int64]int64)
+	}
+	s.Buckets[bucket] += amount
+}
+
+// expireReservations refunds reservations that were never settled and reports
+// whether any were.
+func (s *TenantSpend) expireReservations(now time.Time) bool {
+	expired := false
+	for id, res := range s.Reservations {
+		if now.Unix() < res.Expires {
+			continue
+		}
+		delete(s.Reservations, id)
+		if _, ok := s.Buckets[res.Bucket]; ok {
+			s.Buckets[res.Bucket] -= res.Amount
+		}
+		expired = true
+	}
+	return expired
+}
+
+// StoreLimiter is a Limiter over a SpendStore for deployments that cannot run
+// Redis (RATE_LIMIT_BACKEND=etcd). Spend is shared between instances; like
+// MemoryLimiter it supports DEFAULT_SPEND_LIMIT, per-tenant limits,
+// MAX_REQUEST_COST and reservation expiry, but not the Redis-only features.
+type StoreLimiter struct {
+	store          SpendStore
+	pricing        ProviderPricing
+	defaultLimit   float64
+	ceiling        RequestCostCeiling
+	reservationTTL time.Duration
+	now            func() time.Time // overridable for tests; defaults to time.Now
+}
+
+// NewStoreLimiter returns a limiter over store using DEFAULT_SPEND_LIMIT,
+// MAX_REQUEST_COST and RESERVATION_TTL_SECONDS like NewRateLimiter.
+func NewStoreLimiter(store SpendStore) *StoreLimiter {
+	return &StoreLimiter{
+		store:          store,
+		pricing:        GetPricing(),
+		defaultLimit:   defaultLimitFromEnv(),
+		ceiling:        ceilingFromEnv(),
+		reservationTTL: reservationTTLFromEnv(),
+		now:            time.Now,
+	}
+}
+
+// update applies fn to the tenant's state and writes it back, retrying on
+// conflicting writes. fn reports whether the state changed; unchanged state is
+// not written.
+func (l *StoreLimiter) update(ctx context.Context, tenantID string, fn func(*TenantSpend) bool) error {
+	for range maxStoreAttempts {
+		state, rev, err := l.store.Load(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		if !fn(state) {
+			return nil
+		}
+		ok, err := l.store.CompareAndSwap(ctx, tenantID, rev, state)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errStoreContention
+}
+
+// SetLimit stores a custom hourly spend limit for a tenant.
+func (l *StoreLimiter) SetLimit(ctx context.Context, tenantID string, limit float64) error {
+	return l.update(ctx, tenantID, func(s *TenantSpend) bool {
+		s.Limit = &limit
+		return true
+	})
+}
+
+// GetSpend returns the tenant's spend in the last hour.
+func (l *StoreLimiter) GetSpend(ctx context.Context, tenantID string) (float64, error) {
+	state, _, err := l.store.Load(ctx, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	return state.spend(minuteBucket(l.now())), nil
+}
+
+// CheckLimitAndIncrement reserves estimatedCost against the tenant's hourly
+// limit when it fits. Store errors are returned so the caller fails open.
+func (l *StoreLimiter) CheckLimitAndIncrement(ctx context.Context, tenantID string, estimatedCost float64) (*CheckLimitResult, error) {
+	var res *CheckLimitResult
+	err := l.update(ctx, tenantID, func(s *TenantSpend) bool {
+		now := l.now()
+		expired := s.expireReservations(now)
+		bucket := minuteBucket(now)
+
+		limit := l.defaultLimit
+		if s.Limit != nil {
+			limit = *s.Limit
+		}
+		spend := s.spend(bucket)
+		res = &CheckLimitResult{
+			Allowed:      spend+estimatedCost <= limit,
+			CurrentSpend: spend,
+			Limit:        limit,
+			Remaining:    max(0, limit-spend),
+			Reserved:     estimatedCost,
+			LimitedBy:    tenantID,
+		}
+		if !res.Allowed {
+			return expired
+		}
+		s.charge(bucket, ToMicros(estimatedCost))
+		if s.Reservations == nil {
+			s.Reservations = make(map[string]StoredReservation)
+		}
+		res.ReservationID = newStoreReservationID()
+		s.Reservations[res.ReservationID] = StoredReservation{
+			Amount:  ToMicros(estimatedCost),
+			Bucket:  bucket,
+			Expires: now.Add(l.reservationTTL).Unix(),
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AdjustCost replaces the reservation's estimate with actual. A reservation that
+// already expired was refunded, so only actual is charged.
+func (l *StoreLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
+	if err := l.settle(ctx, tenantID, reservationID, estimate, actual); err != nil {
+		slog.Warn("Spend store error in AdjustCost",
+			"error", err,
+			"tenant_id", tenantID,
+		)
+	}
+	// Fail-open: log but don't fail
+	return nil
+}
+
+// RefundEstimate releases the reservation's estimate.
+func (l *StoreLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
+	if err := l.settle(ctx, tenantID, reservationID, estimate, 0); err != nil {
+		slog.Warn("Spend store error in RefundEstimate",
+			"error", err,
+			"tenant_id", tenantID,
+		)
+	}
+	// Fail-open: log but don't fail
+	return nil
+}
+
+func (l *StoreLimiter) settle(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
+	return l.update(ctx, tenantID, func(s *TenantSpend) bool {
+		reserved := ToMicros(estimate)
+		if reservationID != "" {
+			res, ok := s.Reservations[reservationID]
+			delete(s.Reservations, reservationID)
+			reserved = 0
+			if ok {
+				reserved = res.Amount
+			}
+		}
+		if adjustment := ToMicros(actual) - reserved; adjustment != 0 {
+			s.charge(minuteBucket(l.now()), adjustment)
+		}
+		return true
+	})
+}
+
+// GetPricing returns the pricing for a specific provider and model.
+func (l *StoreLimiter) GetPricing(provider, model string) (Pricing, bool) {
+	pricing, ok := l.pricing[provider][model]
+	return pricing, ok
+}
+
+// DowngradeFor never downgrades; downgrade policies need the Redis limiter.
+func (l *StoreLimiter) DowngradeFor(tenantID, model string, result *CheckLimitResult) (string, bool) {
+	return "", false
+}
+
+// MaxRequestCost returns the MAX_REQUEST_COST ceiling and whether to clamp.
+func (l *StoreLimiter) MaxRequestCost(tenantID string) (float64, bool) {
+	clamp := l.ceiling.Action == CeilingClamp
+	if l.ceiling.Default != nil {
+		return *l.ceiling.Default, clamp
+	}
+	return 0, clamp
+}
+
+// newStoreReservationID returns a reservation ID unique across instances.
+func newStoreReservationID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "st-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySpendStore is a SpendStore in process memory, used in tests.
+type memorySpendStore struct {
+	mu      sync.Mutex
+	records map[string]memorySpendRecord
+}
+
+type memorySpendRecord struct {
+	revision int64
+	state    TenantSpend
+}
+
+func newMemorySpendStore() *memorySpendStore {
+	return &memorySpendStore{records: make(map[string]memorySpendRecord)}
+}
+
+func (m *memorySpendStore) Load(ctx context.Context, tenantID string) (*TenantSpend, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[tenantID]
+	if !ok {
+		return &TenantSpend{}, 0, nil
+	}
+	return cloneTenantSpend(&rec.state), rec.revision, nil
+}
+
+func (m *memorySpendStore) CompareAndSwap(ctx context.Context, tenantID string, revision int64, state *TenantSpend) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.records[tenantID].revision != revision {
+		return false, nil
+	}
+	m.records[tenantID] = memorySpendRecord{revision: revision + 1, state: *cloneTenantSpend(state)}
+	return true, nil
+}
+
+func cloneTenantSpend(s *TenantSpend) *TenantSpend {
+	return &TenantSpend{Limit: s.Limit, Buckets: maps.Clone(s.Buckets), Reservations: maps.Clone(s.Reservations)}
+}
+
+// conflictingStore fails the first CompareAndSwap as if another instance wrote first.
+type conflictingStore struct {
+	*memorySpendStore
+	conflicts int
+}
+
+func (c *conflictingStore) CompareAndSwap(ctx context.Context, tenantID string, revision int64, state *TenantSpend) (bool, error) {
+	if c.conflicts > 0 {
+		c.conflicts--
+		other := &TenantSpend{Buckets: map[int64]int64{minuteBucket(time.Unix(1_700_000_000, 0)): ToMicros(0.5)}}
+		_, _ = c.memorySpendStore.CompareAndSwap(ctx, tenantID, revision, other)
+		return false, nil
+	}
+	return c.memorySpendStore.CompareAndSwap(ctx, tenantID, revision, state)
+}
+
+func TestStoreLimiterSharesSpendAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	store := newMemorySpendStore()
+	a, b := NewStoreLimiter(store), NewStoreLimiter(store)
+	a.now = func() time.Time { return now }
+	b.now = a.now
+	if err := a.SetLimit(ctx, "acme", 1); err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+
+	first, err := a.CheckLimitAndIncrement(ctx, "acme", 0.6)
+	if err != nil || !first.Allowed || first.ReservationID == "" {
+		t.Fatalf("first request should be reserved, got %+v, %v", first, err)
+	}
+	if second, _ := b.CheckLimitAndIncrement(ctx, "acme", 0.6); second.Allowed {
+		t.Fatalf("another instance should see the reservation, got %+v", second)
+	}
+	_ = b.AdjustCost(ctx, "acme", first.ReservationID, 0.6, 0.2)
+	if spend, _ := a.GetSpend(ctx, "acme"); spend < 0.199 || spend > 0.201 {
+		t.Fatalf("spend after adjustment = %v, want 0.2", spend)
+	}
+}
+
+func TestStoreLimiterRetriesConflictingWrites(t *testing.T) {
+	ctx := context.Background()
+	store := &conflictingStore{memorySpendStore: newMemorySpendStore(), conflicts: 1}
+	l := NewStoreLimiter(store)
+	l.now = func() time.Time { return time.Unix(1_700_000_000, 0) }
+	l.defaultLimit = 1
+
+	res, err := l.CheckLimitAndIncrement(ctx, "acme", 0.6)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	// The retry sees the concurrent 0.5 and must not double-book past the limit.
+	if res.Allowed || res.CurrentSpend != 0.5 {
+		t.Fatalf("retry should be evaluated against the winning write, got %+v", res)
+	}
+
+	store.conflicts = maxStoreAttempts
+	if _, err := l.CheckLimitAndIncrement(ctx, "acme", 0.1); err == nil {
+		t.Fatal("expected an error after exhausting retries")
+	}
+}
+
+// fakeEtcd serves the subset of the etcd v3 JSON gateway EtcdStore uses.
+func fakeEtcd(t *testing.T) *httptest.Server {
+	var (
+		mu       sync.Mutex
+		revision int64
+		values   = map[string]string{}
+		mods     = map[string]int64{}
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var req struct {
+			Key     string `json:"key"`
+			Compare []struct {
+				Key         string `json:"key"`
+				ModRevision string `json:"mod_revision"`
+			} `json:"compare"`
+			Success []struct {
+				Put struct{ Key, Value string } `json:"request_put"`
+			} `json:"success"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode %s: %v", r.URL.Path, err)
+		}
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			resp := map[string]any{}
+			if v, ok := values[req.Key]; ok {
+				resp["kvs"] = []map[string]string{{"value": v, "mod_revision": strconv.FormatInt(mods[req.Key], 10)}}
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+		case "/v3/kv/txn":
+			want, _ := strconv.ParseInt(req.Compare[0].ModRevision, 10, 64)
+			if mods[req.Compare[0].Key] != want {
+				_ = json.NewEncoder(w).Encode(map[string]any{})
+				return
+			}
+			revision++
+			put := req.Success[0].Put
+			values[put.Key], mods[put.Key] = put.Value, revision
+			_ = json.NewEncoder(w).Encode(map[string]any{"succeeded": true})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestEtcdStoreCompareAndSwap(t *testing.T) {
+	srv := fakeEtcd(t)
+	defer srv.Close()
+	ctx := context.Background()
+	store := NewEtcdStore(srv.URL, defaultEtcdKeyPrefix, srv.Client())
+
+	state, rev, err := store.Load(ctx, "acme")
+	if err != nil || rev != 0 || len(state.Buckets) != 0 {
+		t.Fatalf("missing tenant should load empty at revision 0, got %+v, %d, %v", state, rev, err)
+	}
+	state.charge(60, ToMicros(1.5))
+	if ok, err := store.CompareAndSwap(ctx, "acme", 0, state); !ok || err != nil {
+		t.Fatalf("create should succeed, got %v, %v", ok, err)
+	}
+	if ok, _ := store.CompareAndSwap(ctx, "acme", 0, state); ok {
+		t.Fatal("a stale revision should not overwrite")
+	}
+	got, rev, err := store.Load(ctx, "acme")
+	if err != nil || rev == 0 || got.Buckets[60] != ToMicros(1.5) {
+		t.Fatalf("stored state = %+v at %d, %v", got, rev, err)
+	}
+}
@@ -113,7 +113,8 @@ func EstimateOutputTokens(inputTokens, maxFromRequest int) int {
 }
 
 // ExtractMaxOutputTokens extracts the max output tokens from an API request body.
-// Supports both OpenAI (max_tokens, max_completion_tokens) and Gemini (generationConfig.maxOutputTokens).
+// Supports OpenAI (max_tokens, max_completion_tokens, max_output_tokens), Anthropic
+// (max_tokens) and Gemini (generationConfig.maxOutputTokens).
 func ExtractMaxOutputTokens(data map[string]any) int {
 	// OpenAI: max_tokens or max_completion_tokens
 	if v, ok := data["max_tokens"].(float64); ok && v > 0 {
@@ -122,6 +123,10 @@ func ExtractMaxOutputTokens(data map[string]any) int {
 	if v, ok := data["max_completion_tokens"].(float64); ok && v > 0 {
 		return int(v)
 	}
+	// OpenAI Responses API
+	if v, ok := data["max_output_tokens"].(float64); ok && v > 0 {
+		return int(v)
+	}
 
 	// Gemini: generationConfig.maxOutputTokens
 	if config, ok := data["generationConfig"].(map[string]any); ok {
@@ -132,3 +137,37 @@ func ExtractMaxOutputTokens(data map[string]any) int {
 
 	return 0
 }
+
+// outputTokenFields are the top-level output caps used by OpenAI and Anthropic.
+var outputTokenFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens"}
+
+// SetMaxOutputTokens sets the request's output cap to n. Existing cap fields are
+// overwritten; when there are none, the provider's field is added: Gemini's
+// generationConfig.maxOutputTokens, Anthropic's max_tokens, and OpenAI's
+// max_output_tokens (Responses API) or max_completion_tokens (Chat Completions).
+func SetMaxOutputTokens(data map[string]any, provider string, n int) {
+	if config, ok := data["generationConfig"].(map[string]any); ok {
+		config["maxOutputTokens"] = n
+		return
+	}
+	set := false
+	for _, field := range outputTokenFields {
+		if _, ok := data[field]; ok {
+			data[field] = n
+			set = true
+		}
+	}
+	if set {
+		return
+	}
+	switch {
+	case provider == "gemini":
+		data["generationConfig"] = map[string]any{"maxOutputTokens": n}
+	case provider == "anthropic":
+		data["max_tokens"] = n
+	case data["input"] != nil:
+		data["max_output_tokens"] = n
+	default:
+		data["max_completion_tokens"] = n
+	}
+}
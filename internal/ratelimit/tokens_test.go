@@ -1,6 +1,10 @@
 package ratelimit
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestEstimateOutputTokens(t *testing.T) {
 	if got := EstimateOutputTokens(10, 0); got != MinOutputEstimate {
@@ -37,3 +41,118 @@ func TestCountTokensFallback(t *testing.T) {
 		t.Fatalf("expected token count > 0")
 	}
 }
+
+func TestCountWithPrefixCacheReusesPrefixes(t *testing.T) {
+	system := strings.Repeat("You are a careful assistant that follows the rules. ", 200)
+	cache := newPrefixCache(100)
+
+	first := system + "user: hello"
+	if got, want := countWithPrefixCache(cache, first, "gpt-4o"), CountTokens(first, "gpt-4o"); got != want {
+		t.Fatalf("expected %d tokens, got %d", want, got)
+	}
+	cached := cache.order.Len()
+	if cached < prefixCacheMinChunks {
+		t.Fatalf("expected prefixes cached, got %d", cached)
+	}
+
+	second := system + "user: hello\nassistant: hi\nuser: tell me more"
+	if got, want := countWithPrefixCache(cache, second, "gpt-4o"), CountTokens(second, "gpt-4o"); got != want {
+		t.Fatalf("expected %d tokens, got %d", want, got)
+	}
+	// Only the changed tail adds entries; the shared system prompt chunks are reused.
+	if added := cache.order.Len() - cached; added != 1 {
+		t.Fatalf("expected one new prefix, got %d", added)
+	}
+}
+
+func TestSplitPromptChunksIsStable(t *testing.T) {
+	text := strings.Repeat("word ", 1000)
+	chunks := splitPromptChunks(text)
+	if strings.Join(chunks, "") != text {
+		t.Fatal("chunks do not reassemble the text")
+	}
+	longer := splitPromptChunks(text + "more words")
+	for i := range len(chunks) - 1 {
+		if chunks[i] != longer[i] {
+			t.Fatalf("chunk %d changed when text was extended", i)
+		}
+	}
+}
+
+func TestSetMaxOutputTokens(t *testing.T) {
+	cases := []struct {
+		name     string
+		provider string
+		data     map[string]any
+		field    string
+	}{
+		{"existing max_tokens", "openai", map[string]any{"max_tokens": float64(5000)}, "max_tokens"},
+		{"chat completions", "openai", map[string]any{"messages": []any{}}, "max_completion_tokens"},
+		{"responses", "openai", map[string]any{"input": "hi"}, "max_output_tokens"},
+		{"anthropic", "anthropic", map[string]any{"messages": []any{}}, "max_tokens"},
+	}
+	for _, tc := range cases {
+		SetMaxOutputTokens(tc.data, tc.provider, 42)
+		if tc.data[tc.field] != 42 {
+			t.Fatalf("%s: expected %s=42, got %v", tc.name, tc.field, tc.data)
+		}
+		if got := ExtractMaxOutputTokens(roundTrip(t, tc.data)); got != 42 {
+			t.Fatalf("%s: expected extracted cap 42, got %d", tc.name, got)
+		}
+	}
+
+	gemini := map[string]any{"contents": []any{}}
+	SetMaxOutputTokens(gemini, "gemini", 42)
+	if got := ExtractMaxOutputTokens(roundTrip(t, gemini)); got != 42 {
+		t.Fatalf("expected gemini cap 42, got %d", got)
+	}
+}
+
+func TestOutputTokenPolicyApply(t *testing.T) {
+	policy := OutputTokenPolicy{Default: 1024, Max: 8192}
+
+	missing := map[string]any{"messages": []any{}}
+	if got, changed := policy.Apply(missing, "openai", true); !changed || got != 1024 || missing["max_completion_tokens"] != 1024 {
+		t.Fatalf("expected default injected, got %d %v", got, missing)
+	}
+
+	notGeneration := map[string]any{"input": "embed me"}
+	if _, changed := policy.Apply(notGeneration, "openai", false); changed || len(notGeneration) != 1 {
+		t.Fatalf("expected no injection outside generation requests, got %v", notGeneration)
+	}
+
+	huge := map[string]any{"max_tokens": float64(100000)}
+	if got, changed := policy.Apply(huge, "anthropic", true); !changed || got != 8192 || huge["max_tokens"] != 8192 {
+		t.Fatalf("expected clamp to 8192, got %d %v", got, huge)
+	}
+
+	gemini := map[string]any{"generationConfig": map[string]any{"maxOutputTokens": float64(500)}}
+	if got, changed := policy.Apply(gemini, "gemini", true); changed || got != 500 {
+		t.Fatalf("expected a cap within bounds to be kept, got %d", got)
+	}
+}
+
+func TestMaxOutputTokensWithin(t *testing.T) {
+	pricing := Pricing{InputPrice: 1, OutputPrice: 10}
+	// $0.001 ceiling minus 100 input tokens ($0.0001) leaves room for 90 output tokens.
+	if got := MaxOutputTokensWithin(0.001, 100, pricing); got != 90 {
+		t.Fatalf("expected 90, got %d", got)
+	}
+	if got := MaxOutputTokensWithin(0.0001, 1000, pricing); got != 0 {
+		t.Fatalf("expected 0 when the input alone exceeds the ceiling, got %d", got)
+	}
+}
+
+// roundTrip re-decodes data the way request bodies are parsed (numbers as float64).
+func roundTrip(t *testing.T, data map[string]any) map[string]any {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return out
+}
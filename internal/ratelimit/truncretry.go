@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// defaultTruncationRetryMultiplier scales the cap of a truncated request when
+// the policy does not set one.
+const defaultTruncationRetryMultiplier = 2
+
+// TruncationRetryPolicy retries, once, a non-streaming response that stopped at
+// its output token cap, with a larger cap. The retry is rate limited like any
+// request, so it only happens while the tenant has budget.
+type TruncationRetryPolicy struct {
+	// Max bounds the retried cap; 0 disables retries.
+	Max int `json:"max"`
+	// Multiplier scales the truncated request's cap (default 2).
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// Tenants limits retries to these tenants; empty allows all.
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// Validate checks a truncation retry policy.
+func (p TruncationRetryPolicy) Validate() error {
+	if p.Max < 0 {
+		return errors.New("truncation_retry max must not be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier <= 1 {
+		return errors.New("truncation_retry multiplier must be greater than 1")
+	}
+	return nil
+}
+
+// Enabled reports whether tenantID's truncated responses may be retried.
+func (p TruncationRetryPolicy) Enabled(tenantID string) bool {
+	return p.Max > 0 && (len(p.Tenants) == 0 || slices.Contains(p.Tenants, tenantID))
+}
+
+// RetryCap returns the cap to retry a response truncated at current tokens
+// with, and false when it would not be larger.
+func (p TruncationRetryPolicy) RetryCap(current int) (int, bool) {
+	if current <= 0 || p.Max <= 0 {
+		return 0, false
+	}
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultTruncationRetryMultiplier
+	}
+	next := min(int(math.Ceil(float64(current)*multiplier)), p.Max)
+	return next, next > current
+}
+
+// truncationRetryPolicyFromEnv reads TRUNCATION_RETRY_MAX_TOKENS,
+// TRUNCATION_RETRY_MULTIPLIER and TRUNCATION_RETRY_TENANTS (comma-separated).
+func truncationRetryPolicyFromEnv() TruncationRetryPolicy {
+	var p TruncationRetryPolicy
+	if v, err := strconv.Atoi(os.Getenv("TRUNCATION_RETRY_MAX_TOKENS")); err == nil && v > 0 {
+		p.Max = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("TRUNCATION_RETRY_MULTIPLIER"), 64); err == nil && v > 1 {
+		p.Multiplier = v
+	}
+	for _, tenant := range strings.Split(os.Getenv("TRUNCATION_RETRY_TENANTS"), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			p.Tenants = append(p.Tenants, tenant)
+		}
+	}
+	return p
+}
+
+// TruncationRetryGuard holds the active truncation retry policy. Safe for
+// concurrent use.
+type TruncationRetryGuard struct {
+	active override.Value[TruncationRetryPolicy]
+}
+
+// NewTruncationRetryGuard returns a guard using the TRUNCATION_RETRY_* variables.
+func NewTruncationRetryGuard() *TruncationRetryGuard {
+	env := truncationRetryPolicyFromEnv()
+	return &TruncationRetryGuard{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *TruncationRetryGuard) Set(policy *TruncationRetryPolicy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *TruncationRetryGuard) Policy() TruncationRetryPolicy {
+	if g == nil {
+		return TruncationRetryPolicy{}
+	}
+	return g.active.Get()
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dailyUsageTTL keeps per-day actual cost long enough to reconcile against
+// provider billing, which can lag by a day or more.
+const dailyUsageTTL = 8 * 24 * time.Hour
+
+// dailyUsageKey holds the total actual cost settled for a provider on one UTC day.
+func dailyUsageKey(provider string, day time.Time) string {
+	return "usage:" + provider + ":" + day.UTC().Format(time.DateOnly)
+}
+
+// TrackDailyUsage records the actual cost of every settled request under provider,
+// for reconciliation with the provider's billing API (see quotasync). Off by
+// default since it costs an extra Redis write per request.
+func (r *RateLimiter) TrackDailyUsage(provider string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.usageProvider = provider
+	r.mu.Unlock()
+}
+
+// recordDailyUsage adds actual to today's total; failures are logged and ignored.
+func (r *RateLimiter) recordDailyUsage(ctx context.Context, actual float64) {
+	r.mu.RLock()
+	provider := r.usageProvider
+	r.mu.RUnlock()
+	if provider == "" || actual <= 0 {
+		return
+	}
+
+	key := r.client.Key(dailyUsageKey(provider, time.Now()))
+	pipe := r.client.Client().TxPipeline()
+	pipe.IncrByFloat(ctx, key, actual)
+	pipe.Expire(ctx, key, dailyUsageTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Debug("Failed to record daily usage", "error", err, "provider", provider)
+	}
+}
+
+// DailyUsage returns the actual cost settled for provider on the given UTC day.
+func (r *RateLimiter) DailyUsage(ctx context.Context, provider string, day time.Time) (float64, error) {
+	if r == nil || r.client == nil {
+		return 0, nil
+	}
+	total, err := r.client.Client().Get(ctx, r.client.Key(dailyUsageKey(provider, day))).Float64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return total, err
+}
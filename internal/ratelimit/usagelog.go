@@ -0,0 +1,260 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"embedding-sidecar/envelope"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// usageLogKey is a Redis stream with one entry per settled or denied request.
+// Entry IDs are millisecond timestamps, so time ranges map onto stream ranges.
+const usageLogKey = "usage_log"
+
+// Outcomes recorded in the usage log.
+const (
+	UsageOK     = "ok"
+	UsageError  = "error"
+	UsageDenied = "denied"
+)
+
+const (
+	defaultUsageQueryLimit = 100
+	maxUsageQueryLimit     = 1000
+	// usageScanBatch and maxUsageScan bound the entries read per query; a
+	// selective filter over a long range returns a cursor to continue from.
+	usageScanBatch = 500
+	maxUsageScan   = 20000
+)
+
+// UsageRecord is one request in the usage log.
+type UsageRecord struct {
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	TenantID     string    `json:"tenant_id"`
+	Provider     string    `json:"provider,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Outcome      string    `json:"outcome"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cost         float64   `json:"cost"`
+	Estimate     float64   `json:"estimate"`
+	LatencyMs    float64   `json:"latency_ms,omitempty"`
+	// PricingVersion identifies the pricing table in effect when the request was
+	// recorded (see PricingVersionOf); InputPrice and OutputPrice are the
+	// per-1M-token rates its cost was computed with.
+	PricingVersion string  `json:"pricing_version,omitempty"`
+	InputPrice     float64 `json:"input_price,omitempty"`
+	OutputPrice    float64 `json:"output_price,omitempty"`
+}
+
+// UsageQuery filters the usage log. Empty fields match everything.
+type UsageQuery struct {
+	TenantID string
+	Model    string
+	Outcome  string
+	From     time.Time
+	To       time.Time
+	// Cursor continues a previous query from the NextCursor it returned.
+	Cursor string
+	Limit  int
+}
+
+// usageLogMaxFromEnv reads USAGE_LOG_MAX_ENTRIES (default 0, disabled).
+func usageLogMaxFromEnv() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("USAGE_LOG_MAX_ENTRIES"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return 0
+}
+
+// SetKeyring encrypts usage records written from now on with their tenant's
+// data key. Only the tenant ID stays in the clear; existing plaintext records
+// remain readable.
+func (r *RateLimiter) SetKeyring(keyring *envelope.Keyring) {
+	if r == nil {
+		return
+	}
+	r.keyring = keyring
+}
+
+// RecordUsage appends rec to the usage log, trimming it to USAGE_LOG_MAX_ENTRIES.
+// Records without a pricing version get the current one. Failures are logged
+// and ignored.
+func (r *RateLimiter) RecordUsage(ctx context.Context, rec UsageRecord) {
+	if r == nil || r.client == nil || r.usageLogMax <= 0 {
+		return
+	}
+	if rec.PricingVersion == "" {
+		rec.PricingVersion = r.PricingVersion()
+	}
+	values, err := r.usageValues(ctx, rec)
+	if err != nil {
+		slog.Debug("Failed to encrypt usage record", "error", err, "tenant_id", rec.TenantID)
+		return
+	}
+	err = r.client.Client().XAdd(ctx, &redis.XAddArgs{
+		Stream: r.client.Key(usageLogKey),
+		MaxLen: r.usageLogMax,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		slog.Debug("Failed to record usage", "error", err, "tenant_id", rec.TenantID)
+	}
+}
+
+// usageValues returns the stream fields for rec: plain fields, or the tenant
+// and the sealed record when a keyring is set.
+func (r *RateLimiter) usageValues(ctx context.Context, rec UsageRecord) ([]any, error) {
+	if r.keyring != nil {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := r.keyring.Seal(ctx, rec.TenantID, string(data))
+		if err != nil {
+			return nil, err
+		}
+		return []any{"tenant", rec.TenantID, "enc", sealed}, nil
+	}
+	return []any{
+		"tenant", rec.TenantID,
+		"provider", rec.Provider,
+		"model", rec.Model,
+		"outcome", rec.Outcome,
+		"input_tokens", rec.InputTokens,
+		"output_tokens", rec.OutputTokens,
+		"cost", rec.Cost,
+		"estimate", rec.Estimate,
+		"latency_ms", rec.LatencyMs,
+		"pricing_version", rec.PricingVersion,
+		"input_price", rec.InputPrice,
+		"output_price", rec.OutputPrice,
+	}, nil
+}
+
+// QueryUsage returns matching records, newest first, and a cursor for the next
+// page ("" when there are no more).
+func (r *RateLimiter) QueryUsage(ctx context.Context, q UsageQuery) ([]UsageRecord, string, error) {
+	if r == nil || r.client == nil {
+		return nil, "", nil
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultUsageQueryLimit
+	}
+	limit = min(limit, maxUsageQueryLimit)
+
+	end := "+"
+	if !q.To.IsZero() {
+		end = strconv.FormatInt(q.To.UnixMilli(), 10)
+	}
+	if q.Cursor != "" {
+		end = "(" + q.Cursor
+	}
+	start := "-"
+	if !q.From.IsZero() {
+		start = strconv.FormatInt(q.From.UnixMilli(), 10)
+	}
+
+	var out []UsageRecord
+	scanned := 0
+	for {
+		var batch []redis.XMessage
+		err := r.client.read(ctx, func(client redis.UniversalClient) error {
+			var err error
+			batch, err = client.XRevRangeN(ctx, r.client.Key(usageLogKey), end, start, usageScanBatch).Result()
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		for i, msg := range batch {
+			scanned++
+			rec, err := r.openUsageRecord(ctx, msg)
+			if err != nil {
+				// Sealed with a key that is gone or not configured.
+				slog.Debug("Failed to decrypt usage record", "error", err, "id", msg.ID)
+			} else if q.matches(rec) {
+				out = append(out, rec)
+			}
+			if len(out) == limit || scanned >= maxUsageScan {
+				// More may follow unless this was the final entry in range.
+				if i < len(batch)-1 || len(batch) == usageScanBatch {
+					return out, msg.ID, nil
+				}
+				return out, "", nil
+			}
+		}
+		if len(batch) < usageScanBatch {
+			return out, "", nil
+		}
+		end = "(" + batch[len(batch)-1].ID
+	}
+}
+
+func (q UsageQuery) matches(rec UsageRecord) bool {
+	return (q.TenantID == "" || rec.TenantID == q.TenantID) &&
+		(q.Model == "" || rec.Model == q.Model) &&
+		(q.Outcome == "" || rec.Outcome == q.Outcome)
+}
+
+// openUsageRecord parses msg, decrypting it if it was sealed.
+func (r *RateLimiter) openUsageRecord(ctx context.Context, msg redis.XMessage) (UsageRecord, error) {
+	rec := parseUsageRecord(msg)
+	sealed, ok := msg.Values["enc"].(string)
+	if !ok {
+		return rec, nil
+	}
+	data, err := r.keyring.Open(ctx, rec.TenantID, sealed)
+	if err != nil {
+		return UsageRecord{}, err
+	}
+	opened := UsageRecord{}
+	if err := json.Unmarshal([]byte(data), &opened); err != nil {
+		return UsageRecord{}, err
+	}
+	opened.ID, opened.Time, opened.TenantID = rec.ID, rec.Time, rec.TenantID
+	return opened, nil
+}
+
+func parseUsageRecord(msg redis.XMessage) UsageRecord {
+	str := func(field string) string {
+		s, _ := msg.Values[field].(string)
+		return s
+	}
+	num := func(field string) float64 {
+		v, _ := strconv.ParseFloat(str(field), 64)
+		return v
+	}
+	rec := UsageRecord{
+		ID:           msg.ID,
+		TenantID:     str("tenant"),
+		Provider:     str("provider"),
+		Model:        str("model"),
+		Outcome:      str("outcome"),
+		InputTokens:  int(num("input_tokens")),
+		OutputTokens: int(num("output_tokens")),
+		Cost:         num("cost"),
+		Estimate:     num("estimate"),
+		LatencyMs:    num("latency_ms"),
+
+		PricingVersion: str("pricing_version"),
+		InputPrice:     num("input_price"),
+		OutputPrice:    num("output_price"),
+	}
+	if ms, _, ok := strings.Cut(msg.ID, "-"); ok {
+		if v, err := strconv.ParseInt(ms, 10, 64); err == nil {
+			rec.Time = time.UnixMilli(v).UTC()
+		}
+	}
+	return rec
+}
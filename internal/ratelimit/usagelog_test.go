@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"embedding-sidecar/envelope"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseUsageRecord(t *testing.T) {
+	rec := parseUsageRecord(redis.XMessage{ID: "1700000000123-0", Values: map[string]any{
+		"tenant":        "acme",
+		"provider":      "openai",
+		"model":         "gpt-4o",
+		"outcome":       UsageOK,
+		"input_tokens":  "120",
+		"output_tokens": "30",
+		"cost":          "0.0006",
+		"estimate":      "0.004",
+		"latency_ms":    "812.5",
+
+		"pricing_version": "0123456789ab",
+		"input_price":     "2.5",
+		"output_price":    "10",
+	}})
+	if rec.TenantID != "acme" || rec.Model != "gpt-4o" || rec.InputTokens != 120 || rec.OutputTokens != 30 || rec.Cost != 0.0006 || rec.LatencyMs != 812.5 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if rec.PricingVersion != "0123456789ab" || rec.InputPrice != 2.5 || rec.OutputPrice != 10 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if !rec.Time.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("expected time from the entry ID, got %v", rec.Time)
+	}
+
+	q := UsageQuery{TenantID: "acme", Outcome: UsageDenied}
+	if q.matches(rec) {
+		t.Fatal("expected outcome filter to exclude the record")
+	}
+	q.Outcome = UsageOK
+	if !q.matches(rec) {
+		t.Fatal("expected record to match")
+	}
+}
+
+type memoryKeyStore map[string][]byte
+
+func (s memoryKeyStore) LoadOrStore(_ context.Context, tenantID string, wrapped []byte) ([]byte, error) {
+	if existing, ok := s[tenantID]; ok {
+		return existing, nil
+	}
+	s[tenantID] = wrapped
+	return wrapped, nil
+}
+
+func (s memoryKeyStore) Delete(_ context.Context, tenantID string) error {
+	delete(s, tenantID)
+	return nil
+}
+
+func TestEncryptedUsageRecord(t *testing.T) {
+	ctx := context.Background()
+	wrapper, _ := envelope.NewLocalWrapper([]byte(strings.Repeat("m", 32)))
+	r := &RateLimiter{}
+	r.SetKeyring(envelope.NewKeyring(wrapper, memoryKeyStore{}))
+
+	values, err := r.usageValues(ctx, UsageRecord{TenantID: "acme", Model: "gpt-4o", Outcome: UsageOK, InputTokens: 120, Cost: 0.0006})
+	if err != nil || len(values) != 4 || strings.Contains(values[3].(string), "gpt-4o") {
+		t.Fatalf("expected tenant and sealed record only, got %v (%v)", values, err)
+	}
+	rec, err := r.openUsageRecord(ctx, redis.XMessage{ID: "1700000000123-0", Values: map[string]any{"tenant": "acme", "enc": values[3]}})
+	if err != nil || rec.TenantID != "acme" || rec.Model != "gpt-4o" || rec.InputTokens != 120 || rec.ID != "1700000000123-0" {
+		t.Fatalf("unexpected record %+v (%v)", rec, err)
+	}
+	// The tenant field is authenticated: a record cannot be moved to another tenant.
+	if _, err := r.openUsageRecord(ctx, redis.XMessage{ID: "1-0", Values: map[string]any{"tenant": "beta", "enc": values[3]}}); err == nil {
+		t.Fatal("expected a sealed record under another tenant to fail")
+	}
+}
+
+func TestUsageRecomputation(t *testing.T) {
+	base := ProviderPricing{"openai": {"gpt-4o": {InputPrice: 2.5, OutputPrice: 10}}}
+	corrected := MergePricing(base, ProviderPricing{"openai": {"gpt-4o": {InputPrice: 5, OutputPrice: 10}}})
+	if base["openai"]["gpt-4o"].InputPrice != 2.5 {
+		t.Fatal("MergePricing modified its base table")
+	}
+	if PricingVersionOf(base) == PricingVersionOf(corrected) || PricingVersionOf(base) != PricingVersionOf(MergePricing(base, nil)) {
+		t.Fatal("expected versions to follow table contents")
+	}
+
+	report := NewUsageRecomputation(corrected)
+	report.Add(corrected, []UsageRecord{
+		{TenantID: "acme", Provider: "openai", Model: "gpt-4o", InputTokens: 1_000_000, Cost: 2.5, PricingVersion: PricingVersionOf(base)},
+		{TenantID: "acme", Provider: "openai", Model: "gpt-4o", Outcome: UsageDenied},
+		{TenantID: "beta", Provider: "other", Model: "custom", InputTokens: 10, Cost: 0.01},
+	})
+	if report.Total.Records != 3 || report.Total.Recorded != 2.51 || report.Total.Recomputed != 5.01 || report.Repriced != 1 || report.Unpriced != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.ByTenant["acme"].Delta != 2.5 || report.RecordedVersions[""] != 2 || report.RecordedVersions[PricingVersionOf(base)] != 1 {
+		t.Fatalf("unexpected breakdown %+v", report)
+	}
+}
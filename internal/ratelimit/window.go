@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+)
+
+// Window algorithms for RATE_LIMIT_WINDOW and limits.json "windows".
+const (
+	// WindowBuckets sums whole minute buckets: the window is the current minute
+	// plus the previous 60, so it reaches back up to 61 minutes and a burst at the
+	// edge is counted until the whole bucket ages out.
+	WindowBuckets = "buckets"
+	// WindowSliding is a sliding-window counter: the oldest bucket is weighted by
+	// how much of it still falls inside the last 3600 seconds, so spend ages out
+	// smoothly. It reads the same buckets, so it costs no extra Redis commands.
+	WindowSliding = "sliding"
+)
+
+// ValidateWindow reports whether w names a window algorithm ("" is the default).
+func ValidateWindow(w string) error {
+	switch w {
+	case "", WindowBuckets, WindowSliding:
+		return nil
+	}
+	return fmt.Errorf("unknown window %q (want %s or %s)", w, WindowBuckets, WindowSliding)
+}
+
+// windowFromEnv reads RATE_LIMIT_WINDOW (buckets or sliding; default buckets).
+func windowFromEnv() string {
+	if w := os.Getenv("RATE_LIMIT_WINDOW"); w == WindowSliding {
+		return w
+	}
+	return WindowBuckets
+}
+
+// SetWindowOverrides replaces the per-tenant window algorithms from limits.json.
+func (r *RateLimiter) SetWindowOverrides(windows map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.windows = windows
+	r.mu.Unlock()
+}
+
+// windowFor returns the tenant's window algorithm. It applies to every level of
+// the tenant's hierarchy checked on its requests.
+func (r *RateLimiter) windowFor(tenantID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if w, ok := r.windows[tenantID]; ok && w != "" {
+		return w
+	}
+	if r.window == "" {
+		return WindowBuckets
+	}
+	return r.window
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ScrubHandler redacts credential values from log messages and string and
+// error attributes before passing records to the wrapped handler.
+type ScrubHandler struct {
+	next  slog.Handler
+	store *Store
+}
+
+// NewScrubHandler wraps next, scrubbing the credentials known to store.
+func NewScrubHandler(next slog.Handler, store *Store) *ScrubHandler {
+	return &ScrubHandler{next: next, store: store}
+}
+
+func (h *ScrubHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *ScrubHandler) Handle(ctx context.Context, record slog.Record) error {
+	scrubbed := slog.NewRecord(record.Time, record.Level, h.store.Scrub(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		scrubbed.AddAttrs(h.scrubAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, scrubbed)
+}
+
+func (h *ScrubHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		scrubbed[i] = h.scrubAttr(attr)
+	}
+	return &ScrubHandler{next: h.next.WithAttrs(scrubbed), store: h.store}
+}
+
+func (h *ScrubHandler) WithGroup(name string) slog.Handler {
+	return &ScrubHandler{next: h.next.WithGroup(name), store: h.store}
+}
+
+func (h *ScrubHandler) scrubAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.store.Scrub(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		scrubbed := make([]any, len(group))
+		for i, a := range group {
+			scrubbed[i] = h.scrubAttr(a)
+		}
+		return slog.Group(attr.Key, scrubbed...)
+	case slog.KindAny:
+		// Errors often quote URLs or headers that carry keys.
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, h.store.Scrub(err.Error()))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,267 @@
+// Package secrets resolves credentials (provider API keys, the admin token)
+// from HashiCorp Vault or a mounted secrets directory instead of raw
+// environment variables, refreshes them periodically so rotations apply
+// without a restart, and scrubs their values from diagnostic output.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names lists the credentials resolved through the store. Their values, from
+// the source or the environment, are also scrubbed from logs.
+var Names = []string{
+	"OPENAI_API_KEY",
+	"ANTHROPIC_API_KEY",
+	"GEMINI_API_KEY",
+	"MIRROR_API_KEY",
+	"OPENAI_ADMIN_KEY",
+	"ANTHROPIC_ADMIN_KEY",
+	"ADMIN_TOKEN",
+}
+
+const defaultRefreshInterval = 5 * time.Minute
+
+// minScrubLength keeps short values (e.g. test placeholders) from redacting
+// ordinary words.
+const minScrubLength = 8
+
+// maxRetired bounds the replaced credential values kept for scrubbing.
+const maxRetired = 16
+
+// Redacted replaces secret values in scrubbed text.
+const Redacted = "[REDACTED]"
+
+// Source fetches credentials by name.
+type Source interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+	// String describes the source without revealing credentials.
+	String() string
+}
+
+// Store holds the credentials fetched from a Source or set through Set. Names
+// with neither fall back to the environment; a nil Store reads the environment
+// only. Safe for concurrent use.
+type Store struct {
+	source  Source
+	refresh time.Duration
+
+	mu sync.RWMutex
+	// values are the current credentials; fetched are the source's values as
+	// of the last refresh, so a refresh only replaces values it changed.
+	values   map[string]string
+	fetched  map[string]string
+	watchers map[string][]func(string)
+	// retired are replaced values, still scrubbed since requests in flight
+	// during a rotation may log them.
+	retired []string
+}
+
+// NewStore returns a store reading source every refresh interval (0 disables
+// refreshing). source may be nil, leaving credentials to the environment and
+// Set.
+func NewStore(source Source, refresh time.Duration) *Store {
+	return &Store{source: source, refresh: refresh, values: map[string]string{}, fetched: map[string]string{}, watchers: map[string][]func(string){}}
+}
+
+// FromEnv returns a store for SECRETS_SOURCE (vault or dir) refreshed every
+// SECRETS_REFRESH_SECONDS (default 300, 0 disables), or one without a source
+// when none is configured. Credentials are fetched once before it returns.
+func FromEnv(ctx context.Context) (*Store, error) {
+	var source Source
+	switch kind := strings.ToLower(os.Getenv("SECRETS_SOURCE")); kind {
+	case "":
+		return NewStore(nil, 0), nil
+	case "vault":
+		vault, err := VaultSourceFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		source = vault
+	case "dir":
+		dir := os.Getenv("SECRETS_DIR")
+		if dir == "" {
+			return nil, errors.New("SECRETS_SOURCE=dir requires SECRETS_DIR")
+		}
+		source = DirSource{Dir: dir}
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_SOURCE %q (want vault or dir)", kind)
+	}
+
+	refresh := defaultRefreshInterval
+	if v, err := strconv.Atoi(os.Getenv("SECRETS_REFRESH_SECONDS")); err == nil && v >= 0 {
+		refresh = time.Duration(v) * time.Second
+	}
+	s := NewStore(source, refresh)
+	if _, err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ErrUnknownCredential is returned by Set for names not in Names.
+var ErrUnknownCredential = errors.New("unknown credential")
+
+// Set replaces the named credential, e.g. for a rotation pushed through the
+// admin API. It holds until the source changes the same credential.
+func (s *Store) Set(name, value string) error {
+	if !slices.Contains(Names, name) {
+		return fmt.Errorf("%w %q", ErrUnknownCredential, name)
+	}
+	if value == "" {
+		return errors.New("credential value must not be empty")
+	}
+	s.mu.Lock()
+	changed := s.values[name] != value
+	if changed {
+		s.retire(name)
+	}
+	s.values[name] = value
+	watchers := slices.Clone(s.watchers[name])
+	s.mu.Unlock()
+	if changed {
+		for _, fn := range watchers {
+			fn(value)
+		}
+	}
+	return nil
+}
+
+// Get returns the named credential from the source, or from the environment
+// when the source does not provide it.
+func (s *Store) Get(name string) string {
+	if s != nil {
+		s.mu.RLock()
+		value, ok := s.values[name]
+		s.mu.RUnlock()
+		if ok {
+			return value
+		}
+	}
+	return os.Getenv(name)
+}
+
+// Watch calls fn with the new value whenever a refresh or Set changes the
+// named credential.
+func (s *Store) Watch(name string, fn func(value string)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.watchers[name] = append(s.watchers[name], fn)
+	s.mu.Unlock()
+}
+
+// Refresh fetches the credentials, applies those the source changed since the
+// last refresh and notifies their watchers. It returns the changed names. On
+// error the previous values are kept.
+func (s *Store) Refresh(ctx context.Context) ([]string, error) {
+	if s == nil || s.source == nil {
+		return nil, nil
+	}
+	fetched, err := s.source.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch secrets from %s: %w", s.source, err)
+	}
+
+	type change struct {
+		fn    func(string)
+		value string
+	}
+	var changed []string
+	var changes []change
+	s.mu.Lock()
+	for _, name := range Names {
+		value := fetched[name]
+		if value == s.fetched[name] {
+			continue
+		}
+		s.retire(name)
+		if value == "" {
+			delete(s.fetched, name)
+			delete(s.values, name)
+			value = os.Getenv(name)
+		} else {
+			s.fetched[name] = value
+			s.values[name] = value
+		}
+		changed = append(changed, name)
+		for _, fn := range s.watchers[name] {
+			changes = append(changes, change{fn, value})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range changes {
+		c.fn(c.value)
+	}
+	return changed, nil
+}
+
+// retire keeps the named credential's current value for scrubbing. Callers
+// hold s.mu.
+func (s *Store) retire(name string) {
+	if value, ok := s.values[name]; ok && !slices.Contains(s.retired, value) {
+		s.retired = append(s.retired, value)
+		if len(s.retired) > maxRetired {
+			s.retired = s.retired[1:]
+		}
+	}
+}
+
+// Run refreshes the credentials until ctx is cancelled. Failures are logged
+// and the previous values kept.
+func (s *Store) Run(ctx context.Context) {
+	if s == nil || s.refresh <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.Refresh(ctx); err != nil {
+			slog.Warn("Failed to refresh secrets", "error", err)
+		}
+	}
+}
+
+// String describes the store's source.
+func (s *Store) String() string {
+	if s == nil || s.source == nil {
+		return "environment"
+	}
+	return s.source.String()
+}
+
+// Scrub replaces every known credential value in text with Redacted: current
+// and recently replaced values, and those in the environment.
+func (s *Store) Scrub(text string) string {
+	var values []string
+	for _, name := range Names {
+		values = append(values, s.Get(name), os.Getenv(name))
+	}
+	if s != nil {
+		s.mu.RLock()
+		values = append(values, s.retired...)
+		s.mu.RUnlock()
+	}
+	for _, value := range values {
+		if len(value) >= minScrubLength {
+			text = strings.ReplaceAll(text, value, Redacted)
+		}
+	}
+	return text
+}
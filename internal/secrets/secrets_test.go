@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type staticSource map[string]string
+
+func (s staticSource) Fetch(context.Context) (map[string]string, error) { return s, nil }
+func (s staticSource) String() string                                   { return "static" }
+
+func TestVaultSourceReadsKVv2(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/sentinel" || r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"OPENAI_API_KEY":"sk-from-vault"},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	_ = os.WriteFile(tokenFile, []byte("root-token\n"), 0o600)
+	values, err := (&VaultSource{Addr: srv.URL, Path: "secret/data/sentinel", TokenFile: tokenFile}).Fetch(context.Background())
+	if err != nil || values["OPENAI_API_KEY"] != "sk-from-vault" {
+		t.Fatalf("unexpected values %v (%v)", values, err)
+	}
+	if _, err := (&VaultSource{Addr: srv.URL, Path: "secret/data/sentinel", Token: "wrong"}).Fetch(context.Background()); err == nil {
+		t.Fatal("expected a rejected token to fail")
+	}
+}
+
+func TestDirSourceSkipsHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "ANTHROPIC_API_KEY"), []byte("sk-ant-mounted\n"), 0o600)
+	_ = os.WriteFile(filepath.Join(dir, ".metadata"), []byte("x"), 0o600)
+	values, err := DirSource{Dir: dir}.Fetch(context.Background())
+	if err != nil || len(values) != 1 || values["ANTHROPIC_API_KEY"] != "sk-ant-mounted" {
+		t.Fatalf("unexpected values %v (%v)", values, err)
+	}
+}
+
+func TestRefreshNotifiesRotations(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "env-gemini-key")
+	source := staticSource{"OPENAI_API_KEY": "sk-first-key"}
+	s := NewStore(source, 0)
+	var rotated []string
+	s.Watch("OPENAI_API_KEY", func(key string) { rotated = append(rotated, key) })
+	if _, err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	source["OPENAI_API_KEY"] = "sk-second-key"
+	if _, err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rotated, ",") != "sk-first-key,sk-second-key" {
+		t.Fatalf("expected one notification per change, got %v", rotated)
+	}
+	if s.Get("OPENAI_API_KEY") != "sk-second-key" || s.Get("GEMINI_API_KEY") != "env-gemini-key" {
+		t.Fatal("expected source values with environment fallback")
+	}
+
+	// A pushed key holds until the source itself changes.
+	if err := s.Set("OPENAI_API_KEY", "sk-pushed-key"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Refresh(context.Background()); len(changed) != 0 || s.Get("OPENAI_API_KEY") != "sk-pushed-key" {
+		t.Fatalf("expected the pushed key to survive an unchanged refresh, got %v", changed)
+	}
+	source["OPENAI_API_KEY"] = "sk-third-key"
+	if changed, _ := s.Refresh(context.Background()); len(changed) != 1 || s.Get("OPENAI_API_KEY") != "sk-third-key" {
+		t.Fatalf("expected the source rotation to apply, got %v", changed)
+	}
+	if strings.Join(rotated, ",") != "sk-first-key,sk-second-key,sk-pushed-key,sk-third-key" {
+		t.Fatalf("unexpected notifications %v", rotated)
+	}
+	if s.Scrub("old sk-first-key, new sk-third-key") != "old [REDACTED], new [REDACTED]" {
+		t.Fatal("expected current and replaced keys scrubbed")
+	}
+	if err := s.Set("DATABASE_URL", "postgres://x"); !errors.Is(err, ErrUnknownCredential) {
+		t.Fatalf("expected unknown credential error, got %v", err)
+	}
+
+	var nilStore *Store
+	if nilStore.Get("GEMINI_API_KEY") != "env-gemini-key" {
+		t.Fatal("expected a nil store to read the environment")
+	}
+}
+
+func TestScrubHandlerRedactsCredentials(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "AIzaSecretValue")
+	var out bytes.Buffer
+	logger := slog.New(NewScrubHandler(slog.NewJSONHandler(&out, nil), nil)).With("url", "https://x/?key=AIzaSecretValue")
+	logger.Warn("request failed for AIzaSecretValue",
+		"error", errors.New(`Get "https://x/?key=AIzaSecretValue": timeout`),
+		slog.Group("upstream", "key", "AIzaSecretValue"),
+		"status", 502,
+	)
+	if strings.Contains(out.String(), "AIzaSecretValue") || strings.Count(out.String(), Redacted) != 4 {
+		t.Fatalf("expected every occurrence redacted, got %s", out.String())
+	}
+}
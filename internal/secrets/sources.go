@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VaultSource reads credentials from one HashiCorp Vault secret, with one
+// field per credential name (e.g. OPENAI_API_KEY). KV version 1 and 2 are
+// supported.
+type VaultSource struct {
+	// Addr is the Vault address, e.g. https://vault:8200.
+	Addr string
+	// Path is the secret's API path, e.g. secret/data/agent-sentinel for KV v2.
+	Path string
+	// Token authenticates to Vault. TokenFile, when set, is re-read on every
+	// fetch instead, so a token renewed by Vault Agent is picked up.
+	Token     string
+	TokenFile string
+	Namespace string
+	Client    *http.Client
+}
+
+// VaultSourceFromEnv reads VAULT_ADDR, VAULT_SECRET_PATH, VAULT_TOKEN or
+// VAULT_TOKEN_FILE, and VAULT_NAMESPACE.
+func VaultSourceFromEnv() (*VaultSource, error) {
+	v := &VaultSource{
+		Addr:      strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		Path:      strings.Trim(os.Getenv("VAULT_SECRET_PATH"), "/"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		TokenFile: os.Getenv("VAULT_TOKEN_FILE"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if v.Addr == "" || v.Path == "" {
+		return nil, errors.New("SECRETS_SOURCE=vault requires VAULT_ADDR and VAULT_SECRET_PATH")
+	}
+	if v.Token == "" && v.TokenFile == "" {
+		return nil, errors.New("SECRETS_SOURCE=vault requires VAULT_TOKEN or VAULT_TOKEN_FILE")
+	}
+	return v, nil
+}
+
+func (v *VaultSource) String() string {
+	return "vault " + v.Addr + "/v1/" + v.Path
+}
+
+func (v *VaultSource) Fetch(ctx context.Context) (map[string]string, error) {
+	token := v.Token
+	if v.TokenFile != "" {
+		data, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.Addr+"/v1/"+v.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("vault returned HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode vault response: %w", err)
+	}
+	fields := body.Data
+	// KV v2 nests the fields under data.data, next to data.metadata.
+	if nested, ok := body.Data["data"]; ok {
+		if _, v2 := body.Data["metadata"]; v2 {
+			fields = nil
+			if err := json.Unmarshal(nested, &fields); err != nil {
+				return nil, fmt.Errorf("decode vault secret: %w", err)
+			}
+		}
+	}
+	values := make(map[string]string, len(fields))
+	for name, raw := range fields {
+		var value string
+		if json.Unmarshal(raw, &value) == nil {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// DirSource reads credentials from files named after them (e.g.
+// /mnt/secrets/OPENAI_API_KEY), as mounted by the Secrets Store CSI driver or
+// the agents of AWS Secrets Manager, GCP Secret Manager, Azure Key Vault and
+// Vault. Hidden files are ignored.
+type DirSource struct {
+	Dir string
+}
+
+func (d DirSource) String() string {
+	return "dir " + d.Dir
+}
+
+func (d DirSource) Fetch(context.Context) (map[string]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		// Stat follows the symlinks CSI mounts use for atomic updates.
+		path := filepath.Join(d.Dir, name)
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = strings.TrimSpace(string(data))
+	}
+	return values, nil
+}
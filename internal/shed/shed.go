@@ -0,0 +1,262 @@
+// Package shed rejects low-priority traffic while the proxy is under pressure
+// (too many goroutines, a backed-up async queue or slow upstream responses),
+// so a provider brownout degrades service instead of collapsing the proxy.
+package shed
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"agent-sentinel/internal/async"
+)
+
+// Request priorities, from the X-Sentinel-Priority header.
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// Level is how much traffic is being shed.
+type Level int32
+
+const (
+	// LevelNone sheds nothing.
+	LevelNone Level = iota
+	// LevelLow sheds low-priority requests: a threshold is crossed.
+	LevelLow
+	// LevelNormal also sheds normal-priority requests: a threshold is exceeded
+	// by criticalFactor. High-priority requests are never shed.
+	LevelNormal
+)
+
+// criticalFactor is how far past a threshold pressure must go before
+// normal-priority traffic is shed as well.
+const criticalFactor = 1.5
+
+const (
+	defaultRetryAfter = 5 * time.Second
+	// latencyWindow and maxSamples bound the upstream latencies the P99 is
+	// computed over.
+	latencyWindow = time.Minute
+	maxSamples    = 1024
+	// minSamples avoids reacting to the P99 of a handful of requests.
+	minSamples = 20
+)
+
+// Config holds the pressure thresholds; zero disables a signal.
+type Config struct {
+	MaxGoroutines int
+	MaxQueueDepth int64
+	MaxP99        time.Duration
+	// RetryAfter is sent with shed responses.
+	RetryAfter time.Duration
+}
+
+// ConfigFromEnv reads SHED_MAX_GOROUTINES, SHED_MAX_QUEUE_DEPTH,
+// SHED_MAX_P99_MS and SHED_RETRY_AFTER_SECONDS. ok is false when no threshold
+// is set.
+func ConfigFromEnv() (cfg Config, ok bool) {
+	cfg.RetryAfter = defaultRetryAfter
+	if v, err := strconv.Atoi(os.Getenv("SHED_MAX_GOROUTINES")); err == nil && v > 0 {
+		cfg.MaxGoroutines = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("SHED_MAX_QUEUE_DEPTH"), 10, 64); err == nil && v > 0 {
+		cfg.MaxQueueDepth = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SHED_MAX_P99_MS")); err == nil && v > 0 {
+		cfg.MaxP99 = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.Atoi(os.Getenv("SHED_RETRY_AFTER_SECONDS")); err == nil && v > 0 {
+		cfg.RetryAfter = time.Duration(v) * time.Second
+	}
+	return cfg, cfg.MaxGoroutines > 0 || cfg.MaxQueueDepth > 0 || cfg.MaxP99 > 0
+}
+
+type sample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// Shedder tracks pressure and decides which requests to shed. Pressure is
+// re-evaluated by Run; Shed only reads the current level.
+type Shedder struct {
+	cfg        Config
+	goroutines func() int
+	queueDepth func() int64
+	now        func() time.Time
+
+	mu      sync.Mutex
+	samples []sample
+	next    int
+
+	level  atomic.Int32
+	reason atomic.Value // string
+}
+
+// New returns a shedder reading the process goroutine count and the async
+// queue depth.
+func New(cfg Config) *Shedder {
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = defaultRetryAfter
+	}
+	s := &Shedder{cfg: cfg, goroutines: runtime.NumGoroutine, queueDepth: async.QueueDepth, now: time.Now}
+	s.reason.Store("")
+	return s
+}
+
+// NewFromEnv returns a shedder configured by ConfigFromEnv, or nil when load
+// shedding is disabled.
+func NewFromEnv() *Shedder {
+	cfg, ok := ConfigFromEnv()
+	if !ok {
+		return nil
+	}
+	return New(cfg)
+}
+
+// RetryAfter is the back-off suggested to shed clients.
+func (s *Shedder) RetryAfter() time.Duration {
+	return s.cfg.RetryAfter
+}
+
+// Observe records an upstream response latency.
+func (s *Shedder) Observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, sample{at: s.now(), d: d})
+		return
+	}
+	s.samples[s.next] = sample{at: s.now(), d: d}
+	s.next = (s.next + 1) % maxSamples
+}
+
+// p99 returns the 99th percentile of the last minute's latencies, or 0 with
+// too few samples.
+func (s *Shedder) p99() time.Duration {
+	cutoff := s.now().Add(-latencyWindow)
+	s.mu.Lock()
+	recent := make([]time.Duration, 0, len(s.samples))
+	for _, smp := range s.samples {
+		if smp.at.After(cutoff) {
+			recent = append(recent, smp.d)
+		}
+	}
+	s.mu.Unlock()
+	if len(recent) < minSamples {
+		return 0
+	}
+	slices.Sort(recent)
+	return recent[(len(recent)*99-1)/100]
+}
+
+// Evaluate recomputes the shedding level from current pressure and returns it.
+func (s *Shedder) Evaluate() Level {
+	ratio, reason := 0.0, ""
+	check := func(value, limit float64, name string) {
+		if limit > 0 && value/limit > ratio {
+			ratio, reason = value/limit, name
+		}
+	}
+	check(float64(s.goroutines()), float64(s.cfg.MaxGoroutines), "goroutines")
+	check(float64(s.queueDepth()), float64(s.cfg.MaxQueueDepth), "queue_depth")
+	check(float64(s.p99()), float64(s.cfg.MaxP99), "upstream_latency")
+
+	level := LevelNone
+	switch {
+	case ratio >= criticalFactor:
+		level = LevelNormal
+	case ratio >= 1:
+		level = LevelLow
+	}
+	if previous := Level(s.level.Swap(int32(level))); previous != level {
+		if level == LevelNone {
+			slog.Info("Load shedding stopped")
+		} else {
+			slog.Warn("Load shedding", "level", level.String(), "reason", reason, "pressure", ratio)
+		}
+	}
+	s.reason.Store(reason)
+	return level
+}
+
+// Run re-evaluates pressure every interval until ctx is done.
+func (s *Shedder) Run(ctx context.Context, interval time.Duration) {
+	if s == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Evaluate()
+		}
+	}
+}
+
+// Shed reports whether a request of the given priority should be rejected, and
+// the pressure signal responsible. Unknown priorities count as normal.
+func (s *Shedder) Shed(priority string) (bool, string) {
+	level := Level(s.level.Load())
+	if level == LevelNone {
+		return false, ""
+	}
+	switch strings.ToLower(priority) {
+	case PriorityHigh:
+		return false, ""
+	case PriorityLow:
+	default:
+		if level < LevelNormal {
+			return false, ""
+		}
+	}
+	reason, _ := s.reason.Load().(string)
+	return true, reason
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelLow:
+		return "low"
+	case LevelNormal:
+		return "normal"
+	default:
+		return "none"
+	}
+}
+
+// Wrap times upstream responses (to headers) through base for the P99 signal.
+func (s *Shedder) Wrap(base http.RoundTripper) http.RoundTripper {
+	if s == nil {
+		return base
+	}
+	return &timedTransport{shedder: s, base: base}
+}
+
+type timedTransport struct {
+	shedder *Shedder
+	base    http.RoundTripper
+}
+
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	// Cancelled client requests say nothing about the provider.
+	if err == nil || req.Context().Err() == nil {
+		t.shedder.Observe(time.Since(start))
+	}
+	return resp, err
+}
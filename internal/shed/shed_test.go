@@ -0,0 +1,52 @@
+package shed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShedderLevels(t *testing.T) {
+	s := New(Config{MaxGoroutines: 100, MaxP99: time.Second})
+	goroutines := 50
+	s.goroutines = func() int { return goroutines }
+	s.queueDepth = func() int64 { return 0 }
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+
+	if level := s.Evaluate(); level != LevelNone {
+		t.Fatalf("expected no shedding, got %v", level)
+	}
+	if shed, _ := s.Shed(PriorityLow); shed {
+		t.Fatal("expected low priority admitted without pressure")
+	}
+
+	goroutines = 120
+	if level := s.Evaluate(); level != LevelLow {
+		t.Fatalf("expected low-priority shedding, got %v", level)
+	}
+	if shed, reason := s.Shed(PriorityLow); !shed || reason != "goroutines" {
+		t.Fatalf("expected low priority shed for goroutines, got %v %q", shed, reason)
+	}
+	if shed, _ := s.Shed(""); shed {
+		t.Fatal("expected normal priority admitted below the critical factor")
+	}
+
+	goroutines = 10
+	for range minSamples {
+		s.Observe(2 * time.Second)
+	}
+	if level := s.Evaluate(); level != LevelNormal {
+		t.Fatalf("expected normal-priority shedding at 2x the P99 threshold, got %v", level)
+	}
+	if shed, reason := s.Shed(PriorityNormal); !shed || reason != "upstream_latency" {
+		t.Fatalf("expected normal priority shed for latency, got %v %q", shed, reason)
+	}
+	if shed, _ := s.Shed(PriorityHigh); shed {
+		t.Fatal("expected high priority never shed")
+	}
+
+	now = now.Add(2 * latencyWindow)
+	if level := s.Evaluate(); level != LevelNone {
+		t.Fatalf("expected old latencies to age out, got %v", level)
+	}
+}
@@ -0,0 +1,29 @@
+package signing
+
+import (
+	"context"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+)
+
+// RedisNonceStore records nonces in Redis, so a replay is caught by any
+// replica.
+type RedisNonceStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisNonceStore returns a nonce store using client, or nil when client is
+// nil.
+func NewRedisNonceStore(client *ratelimit.RedisClient) *RedisNonceStore {
+	if client == nil {
+		return nil
+	}
+	return &RedisNonceStore{client: client}
+}
+
+// ClaimNonce records a signed request's nonce under nonce:{tenant}:{nonce} for
+// ttl and reports whether it was unused.
+func (s *RedisNonceStore) ClaimNonce(ctx context.Context, tenantID, nonce string, ttl time.Duration) (bool, error) {
+	return s.client.Client().SetNX(ctx, s.client.Key("nonce:"+tenantID+":"+nonce), 1, ttl).Result()
+}
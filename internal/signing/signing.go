@@ -0,0 +1,291 @@
+// Package signing verifies HMAC request signatures from tenants and rejects
+// replayed requests, for deployments that expose the proxy across untrusted
+// networks.
+//
+// A signed request carries a Unix timestamp, a unique nonce and a signature:
+//
+//	X-Sentinel-Timestamp: 1760000000
+//	X-Sentinel-Nonce:     6f1c0e0a-...
+//	X-Sentinel-Signature: hex(HMAC-SHA256(key, canonical))
+//
+// where canonical joins, with newlines, the method, the request URI (path and
+// query), the tenant ID, the timestamp, the nonce and hex(SHA-256(body)).
+package signing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature headers.
+const (
+	HeaderTimestamp = "X-Sentinel-Timestamp"
+	HeaderNonce     = "X-Sentinel-Nonce"
+	HeaderSignature = "X-Sentinel-Signature"
+)
+
+// Modes.
+const (
+	// ModeOptional verifies signed requests and lets unsigned ones through,
+	// for migrating clients.
+	ModeOptional = "optional"
+	// ModeRequired rejects unsigned requests.
+	ModeRequired = "required"
+)
+
+const (
+	defaultMaxSkew = 5 * time.Minute
+	maxNonceLength = 128
+	// maxLocalNonces bounds the in-memory fallback nonce set.
+	maxLocalNonces = 100000
+)
+
+// Verification failures.
+var (
+	ErrUnsigned      = errors.New("request is not signed")
+	ErrUnknownTenant = errors.New("no signing key for tenant")
+	ErrMalformed     = errors.New("malformed signature headers")
+	ErrExpired       = errors.New("signature timestamp outside the allowed window")
+	ErrBadSignature  = errors.New("signature does not match")
+	ErrReplayed      = errors.New("nonce already used")
+)
+
+// Reason returns a short metric label for a verification failure.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return "unsigned"
+	case errors.Is(err, ErrUnknownTenant):
+		return "unknown_tenant"
+	case errors.Is(err, ErrExpired):
+		return "expired"
+	case errors.Is(err, ErrBadSignature):
+		return "invalid"
+	case errors.Is(err, ErrReplayed):
+		return "replayed"
+	default:
+		return "malformed"
+	}
+}
+
+// NonceStore records used nonces across replicas.
+type NonceStore interface {
+	// ClaimNonce records nonce for ttl and reports whether it was unused.
+	ClaimNonce(ctx context.Context, tenantID, nonce string, ttl time.Duration) (bool, error)
+}
+
+// Sign returns the signature of a request. Clients compute the same value.
+func Sign(key []byte, method, requestURI, tenantID, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{method, requestURI, tenantID, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks request signatures against per-tenant keys.
+type Verifier struct {
+	mode    string
+	maxSkew time.Duration
+	// keys holds each tenant's accepted keys; several during a key rotation.
+	keys  map[string][][]byte
+	store NonceStore
+	local *localNonces
+	now   func() time.Time
+}
+
+// NewVerifier returns a verifier. store may be nil, in which case nonces are
+// only tracked by this replica.
+func NewVerifier(mode string, maxSkew time.Duration, keys map[string][]string, store NonceStore) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	v := &Verifier{mode: mode, maxSkew: maxSkew, keys: map[string][][]byte{}, store: store, local: newLocalNonces(), now: time.Now}
+	for tenant, tenantKeys := range keys {
+		for _, key := range tenantKeys {
+			v.keys[tenant] = append(v.keys[tenant], []byte(key))
+		}
+	}
+	return v
+}
+
+// FromEnv returns a verifier for REQUEST_SIGNING (optional or required) with
+// tenant keys from REQUEST_SIGNING_KEYS_FILE and a window of
+// REQUEST_SIGNING_MAX_SKEW_SECONDS (default 300), or nil when signing is off.
+func FromEnv(store NonceStore) (*Verifier, error) {
+	mode := strings.ToLower(os.Getenv("REQUEST_SIGNING"))
+	switch mode {
+	case "", "off", "false":
+		return nil, nil
+	case ModeOptional, ModeRequired:
+	default:
+		return nil, fmt.Errorf("unknown REQUEST_SIGNING %q (want optional or required)", mode)
+	}
+	path := os.Getenv("REQUEST_SIGNING_KEYS_FILE")
+	if path == "" {
+		return nil, errors.New("REQUEST_SIGNING requires REQUEST_SIGNING_KEYS_FILE")
+	}
+	keys, err := LoadKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	maxSkew := defaultMaxSkew
+	if v, err := strconv.Atoi(os.Getenv("REQUEST_SIGNING_MAX_SKEW_SECONDS")); err == nil && v > 0 {
+		maxSkew = time.Duration(v) * time.Second
+	}
+	return NewVerifier(mode, maxSkew, keys, store), nil
+}
+
+// LoadKeys reads a JSON object mapping tenants to a key, or to a list of keys
+// accepted side by side while rotating:
+//
+//	{"acme": "k1", "beta": ["k2-new", "k2-old"]}
+func LoadKeys(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	keys := make(map[string][]string, len(raw))
+	for tenant, value := range raw {
+		var list []string
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			list = []string{single}
+		} else if err := json.Unmarshal(value, &list); err != nil {
+			return nil, fmt.Errorf("%s: tenant %q: want a key or a list of keys", path, tenant)
+		}
+		for _, key := range list {
+			if key == "" {
+				return nil, fmt.Errorf("%s: tenant %q has an empty key", path, tenant)
+			}
+		}
+		keys[tenant] = list
+	}
+	return keys, nil
+}
+
+// Required reports whether unsigned requests are rejected.
+func (v *Verifier) Required() bool {
+	return v != nil && v.mode == ModeRequired
+}
+
+// Verify checks the signature of tenantID's request r with the given body and
+// claims its nonce. Unsigned requests pass in optional mode.
+func (v *Verifier) Verify(ctx context.Context, r *http.Request, tenantID string, body []byte) error {
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature := r.Header.Get(HeaderSignature)
+	if timestamp == "" && nonce == "" && signature == "" {
+		if v.Required() {
+			return ErrUnsigned
+		}
+		return nil
+	}
+	if timestamp == "" || nonce == "" || signature == "" || len(nonce) > maxNonceLength {
+		return ErrMalformed
+	}
+	keys, ok := v.keys[tenantID]
+	if !ok || tenantID == "" {
+		return ErrUnknownTenant
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMalformed
+	}
+	if skew := v.now().Sub(time.Unix(seconds, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return ErrExpired
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrMalformed
+	}
+	valid := false
+	for _, key := range keys {
+		want, _ := hex.DecodeString(Sign(key, r.Method, r.URL.RequestURI(), tenantID, timestamp, nonce, body))
+		if hmac.Equal(given, want) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrBadSignature
+	}
+
+	// A nonce only needs remembering while its timestamp is acceptable.
+	ttl := 2 * v.maxSkew
+	fresh, err := v.claim(ctx, tenantID, nonce, ttl)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// claim records the nonce in the shared store, falling back to this replica's
+// memory when the store is unavailable.
+func (v *Verifier) claim(ctx context.Context, tenantID, nonce string, ttl time.Duration) (bool, error) {
+	if v.store != nil {
+		fresh, err := v.store.ClaimNonce(ctx, tenantID, nonce, ttl)
+		if err == nil {
+			// Also remember locally, so a replay during a later store outage
+			// is still caught here.
+			v.local.claim(tenantID+"\x00"+nonce, v.now().Add(ttl), v.now())
+			return fresh, nil
+		}
+		slog.WarnContext(ctx, "Nonce store unavailable, checking replays on this replica only", "error", err)
+	}
+	return v.local.claim(tenantID+"\x00"+nonce, v.now().Add(ttl), v.now()), nil
+}
+
+// localNonces is an in-memory nonce set with expiry.
+type localNonces struct {
+	mu     sync.Mutex
+	expiry map[string]time.Time
+}
+
+func newLocalNonces() *localNonces {
+	return &localNonces{expiry: map[string]time.Time{}}
+}
+
+func (l *localNonces) claim(key string, expires, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if exp, ok := l.expiry[key]; ok && exp.After(now) {
+		return false
+	}
+	if len(l.expiry) >= maxLocalNonces {
+		for k, exp := range l.expiry {
+			if !exp.After(now) {
+				delete(l.expiry, k)
+			}
+		}
+		if len(l.expiry) >= maxLocalNonces {
+			// Still full of live nonces: drop arbitrary ones rather than grow.
+			for k := range l.expiry {
+				delete(l.expiry, k)
+				if len(l.expiry) < maxLocalNonces/2 {
+					break
+				}
+			}
+		}
+	}
+	l.expiry[key] = expires
+	return true
+}
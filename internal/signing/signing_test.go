@@ -0,0 +1,81 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	v := NewVerifier(ModeRequired, time.Minute, map[string][]string{"acme": {"new-key", "old-key"}}, nil)
+	v.now = func() time.Time { return now }
+
+	verify := func(key, body, nonce string, at time.Time, sentBody string) error {
+		req := httptest.NewRequest("POST", "/v1/chat/completions?x=1", strings.NewReader(sentBody))
+		ts := strconv.FormatInt(at.Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderNonce, nonce)
+		req.Header.Set(HeaderSignature, Sign([]byte(key), "POST", "/v1/chat/completions?x=1", "acme", ts, nonce, []byte(body)))
+		return v.Verify(context.Background(), req, "acme", []byte(sentBody))
+	}
+
+	if err := verify("old-key", `{"a":1}`, "n1", now.Add(-30*time.Second), `{"a":1}`); err != nil {
+		t.Fatalf("expected a valid signature with the previous key, got %v", err)
+	}
+	if err := verify("new-key", `{"a":1}`, "n1", now, `{"a":1}`); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected a replayed nonce to fail, got %v", err)
+	}
+	if err := verify("new-key", `{"a":1}`, "n2", now, `{"a":2}`); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected a tampered body to fail, got %v", err)
+	}
+	if err := verify("new-key", `{"a":1}`, "n3", now.Add(-2*time.Minute), `{"a":1}`); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected a stale timestamp to fail, got %v", err)
+	}
+	if err := v.Verify(context.Background(), httptest.NewRequest("GET", "/v1/models", nil), "acme", nil); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected an unsigned request to fail in required mode, got %v", err)
+	}
+	if err := verify("new-key", `{}`, "n4", now, `{}`); err != nil {
+		t.Fatal(err)
+	}
+
+	optional := NewVerifier(ModeOptional, 0, nil, nil)
+	if err := optional.Verify(context.Background(), httptest.NewRequest("GET", "/v1/models", nil), "acme", nil); err != nil {
+		t.Fatalf("expected unsigned requests to pass in optional mode, got %v", err)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) ClaimNonce(context.Context, string, string, time.Duration) (bool, error) {
+	return false, errors.New("redis down")
+}
+
+func TestClaimFallsBackToLocalNonces(t *testing.T) {
+	v := NewVerifier(ModeRequired, time.Minute, nil, failingStore{})
+	if fresh, err := v.claim(context.Background(), "acme", "n1", time.Minute); !fresh || err != nil {
+		t.Fatalf("expected the first claim to succeed locally, got %v %v", fresh, err)
+	}
+	if fresh, _ := v.claim(context.Background(), "acme", "n1", time.Minute); fresh {
+		t.Fatal("expected the replay to be caught locally")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	_ = os.WriteFile(path, []byte(`{"acme": "k1", "beta": ["k2", "k3"]}`), 0o600)
+	keys, err := LoadKeys(path)
+	if err != nil || len(keys["acme"]) != 1 || len(keys["beta"]) != 2 {
+		t.Fatalf("unexpected keys %v (%v)", keys, err)
+	}
+	_ = os.WriteFile(path, []byte(`{"acme": ""}`), 0o600)
+	if _, err := LoadKeys(path); err == nil {
+		t.Fatal("expected an empty key to be rejected")
+	}
+}
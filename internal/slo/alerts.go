@@ -0,0 +1,233 @@
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"agent-sentinel/internal/events"
+	"agent-sentinel/internal/telemetry"
+)
+
+// Alert states.
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Burn is one indicator's error-budget burn rate: the observed bad fraction
+// divided by the fraction the target allows. 1 spends the budget exactly on time.
+type Burn struct {
+	Indicator  string  `json:"indicator"`
+	Target     float64 `json:"target"`
+	ShortRate  float64 `json:"burn_rate_5m"`
+	LongRate   float64 `json:"burn_rate_1h"`
+	Requests1h int64   `json:"requests_1h"`
+	Firing     bool    `json:"firing"`
+}
+
+// Status is an objective with its current burn rates.
+type Status struct {
+	Objective
+	Indicators []Burn `json:"indicators"`
+}
+
+// Alert is sent to the webhook when an indicator starts or stops burning.
+type Alert struct {
+	SLO       string    `json:"slo"`
+	Provider  string    `json:"provider,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Indicator string    `json:"indicator"`
+	State     string    `json:"state"`
+	ShortRate float64   `json:"burn_rate_5m"`
+	LongRate  float64   `json:"burn_rate_1h"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// Alerter posts alerts as JSON to a webhook.
+type Alerter struct {
+	url    string
+	client *http.Client
+}
+
+// NewAlerter returns an alerter posting to url.
+func NewAlerter(url string, transport http.RoundTripper) *Alerter {
+	return &Alerter{url: url, client: &http.Client{Timeout: 5 * time.Second, Transport: transport}}
+}
+
+// AlerterFromEnv returns an alerter for SLO_ALERT_WEBHOOK_URL, or nil when unset.
+func AlerterFromEnv() *Alerter {
+	if url := os.Getenv("SLO_ALERT_WEBHOOK_URL"); url != "" {
+		return NewAlerter(url, nil)
+	}
+	return nil
+}
+
+// Send posts one alert.
+func (a *Alerter) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Status returns every objective's current burn rates, in configuration order.
+func (t *Tracker) Status() []Status {
+	if t == nil {
+		return nil
+	}
+	current := t.now().Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Status, 0, len(t.order))
+	for _, name := range t.order {
+		out = append(out, t.series[name].status(current))
+	}
+	return out
+}
+
+func (s *series) status(current int64) Status {
+	short, long := s.sum(current, ShortWindow), s.sum(current, LongWindow)
+	o := s.objective
+	st := Status{Objective: o}
+	if o.LatencyTarget > 0 {
+		st.Indicators = append(st.Indicators, s.burn(IndicatorLatency, o.LatencyTarget, short.slow, short.total, long.slow, long.total))
+	}
+	if o.ErrorTarget > 0 {
+		st.Indicators = append(st.Indicators, s.burn(IndicatorErrors, o.ErrorTarget, short.failed, short.total, long.failed, long.total))
+	}
+	return st
+}
+
+func (s *series) burn(indicator string, target float64, shortBad, shortTotal, longBad, longTotal int64) Burn {
+	return Burn{
+		Indicator:  indicator,
+		Target:     target,
+		ShortRate:  burnRate(shortBad, shortTotal, target),
+		LongRate:   burnRate(longBad, longTotal, target),
+		Requests1h: longTotal,
+		Firing:     s.firing[indicator],
+	}
+}
+
+func burnRate(bad, total int64, target float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - target)
+}
+
+// Evaluate updates burn-rate metrics and alert state, and returns the alerts
+// raised or resolved since the previous evaluation.
+func (t *Tracker) Evaluate(ctx context.Context) []Alert {
+	if t == nil {
+		return nil
+	}
+	current := t.now().Unix() / 60
+	t.mu.Lock()
+	var alerts []Alert
+	for _, name := range t.order {
+		s := t.series[name]
+		st := s.status(current)
+		threshold := s.objective.burnThreshold()
+		for _, b := range st.Indicators {
+			telemetry.RecordSLOBurnRate(ctx, name, b.Indicator, "5m", b.ShortRate)
+			telemetry.RecordSLOBurnRate(ctx, name, b.Indicator, "1h", b.LongRate)
+
+			burning := b.Requests1h >= s.objective.minRequests() && b.ShortRate >= threshold && b.LongRate >= threshold
+			if burning == s.firing[b.Indicator] {
+				continue
+			}
+			s.firing[b.Indicator] = burning
+			state := StateResolved
+			if burning {
+				state = StateFiring
+			}
+			alerts = append(alerts, Alert{
+				SLO:       name,
+				Provider:  s.objective.Provider,
+				Model:     s.objective.Model,
+				Indicator: b.Indicator,
+				State:     state,
+				ShortRate: b.ShortRate,
+				LongRate:  b.LongRate,
+				Threshold: threshold,
+				Time:      t.now().UTC(),
+			})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, a := range alerts {
+		t.notify(ctx, a)
+	}
+	return alerts
+}
+
+func (t *Tracker) notify(ctx context.Context, a Alert) {
+	attrs := []any{
+		"slo", a.SLO,
+		"indicator", a.Indicator,
+		"state", a.State,
+		"burn_rate_5m", a.ShortRate,
+		"burn_rate_1h", a.LongRate,
+		"threshold", a.Threshold,
+	}
+	if a.State == StateFiring {
+		slog.Warn("SLO error budget burning", attrs...)
+	} else {
+		slog.Info("SLO burn resolved", attrs...)
+	}
+	telemetry.IncSLOAlert(ctx, a.SLO, a.Indicator, a.State)
+	events.Record(events.TypeSLOAlert, "", map[string]any{
+		"slo":          a.SLO,
+		"provider":     a.Provider,
+		"model":        a.Model,
+		"indicator":    a.Indicator,
+		"state":        a.State,
+		"burn_rate_5m": a.ShortRate,
+		"burn_rate_1h": a.LongRate,
+	})
+	if t.alerter != nil {
+		if err := t.alerter.Send(ctx, a); err != nil {
+			slog.Warn("SLO alert webhook failed", "error", err, "slo", a.SLO)
+		}
+	}
+}
+
+// Run evaluates the objectives on every interval until ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	if t == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Evaluate(ctx)
+		}
+	}
+}
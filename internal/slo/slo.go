@@ -0,0 +1,215 @@
+// Package slo tracks provider latency and error-rate objectives and alerts when
+// their error budgets burn too fast.
+package slo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Indicators measured against an objective.
+const (
+	IndicatorLatency = "latency"
+	IndicatorErrors  = "errors"
+)
+
+// Burn-rate windows. An alert fires only while both the short and the long window
+// burn faster than the threshold, so brief spikes and stale incidents are ignored.
+const (
+	ShortWindow = 5 * time.Minute
+	LongWindow  = time.Hour
+
+	windowMinutes = int64(LongWindow / time.Minute)
+
+	// defaultBurnRate consumes a 30-day budget in about two days (the usual
+	// fast-burn page threshold).
+	defaultBurnRate    = 14.4
+	defaultMinRequests = 20
+)
+
+// Objective is a latency and/or error-rate SLO for a provider and model.
+type Objective struct {
+	Name string `json:"name"`
+	// Provider and Model restrict the objective; empty matches any.
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	// LatencyMs and LatencyTarget: at least LatencyTarget (e.g. 0.95) of requests
+	// complete within LatencyMs. Latency is measured to response headers.
+	LatencyMs     float64 `json:"latency_ms,omitempty"`
+	LatencyTarget float64 `json:"latency_target,omitempty"`
+	// ErrorTarget is the fraction of requests that must succeed (e.g. 0.99).
+	// Transport errors and 5xx responses count as failures.
+	ErrorTarget float64 `json:"error_target,omitempty"`
+	// BurnRate is the alert threshold (default 14.4).
+	BurnRate float64 `json:"burn_rate,omitempty"`
+	// MinRequests in the long window before alerting (default 20).
+	MinRequests int64 `json:"min_requests,omitempty"`
+}
+
+// Validate checks an objective definition.
+func (o Objective) Validate() error {
+	if o.Name == "" {
+		return errors.New("slo needs a name")
+	}
+	hasLatency := o.LatencyMs > 0 || o.LatencyTarget > 0
+	if hasLatency && (o.LatencyMs <= 0 || !validTarget(o.LatencyTarget)) {
+		return fmt.Errorf("slo %q: latency_ms must be positive and latency_target between 0 and 1", o.Name)
+	}
+	if o.ErrorTarget != 0 && !validTarget(o.ErrorTarget) {
+		return fmt.Errorf("slo %q: error_target must be between 0 and 1", o.Name)
+	}
+	if !hasLatency && o.ErrorTarget == 0 {
+		return fmt.Errorf("slo %q needs a latency or error target", o.Name)
+	}
+	if o.BurnRate < 0 || o.MinRequests < 0 {
+		return fmt.Errorf("slo %q: burn_rate and min_requests must not be negative", o.Name)
+	}
+	return nil
+}
+
+func validTarget(t float64) bool {
+	return t > 0 && t < 1
+}
+
+func (o Objective) matches(provider, model string) bool {
+	return (o.Provider == "" || o.Provider == provider) && (o.Model == "" || o.Model == model)
+}
+
+func (o Objective) burnThreshold() float64 {
+	if o.BurnRate > 0 {
+		return o.BurnRate
+	}
+	return defaultBurnRate
+}
+
+func (o Objective) minRequests() int64 {
+	if o.MinRequests > 0 {
+		return o.MinRequests
+	}
+	return defaultMinRequests
+}
+
+type bucket struct {
+	minute int64
+	total  int64
+	slow   int64
+	failed int64
+}
+
+type series struct {
+	objective Objective
+	buckets   [windowMinutes]bucket
+	// firing holds the indicators currently alerting.
+	firing map[string]bool
+}
+
+func (s *series) observe(minute int64, latency time.Duration, failed bool) {
+	b := &s.buckets[minute%windowMinutes]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+	if s.objective.LatencyMs > 0 && float64(latency.Microseconds())/1000 > s.objective.LatencyMs {
+		b.slow++
+	}
+}
+
+// sum totals the buckets of the last window minutes, including the current one.
+func (s *series) sum(current int64, window time.Duration) bucket {
+	var out bucket
+	for m := current - int64(window/time.Minute) + 1; m <= current; m++ {
+		b := s.buckets[m%windowMinutes]
+		if b.minute != m {
+			continue
+		}
+		out.total += b.total
+		out.slow += b.slow
+		out.failed += b.failed
+	}
+	return out
+}
+
+// Tracker records provider responses against the configured objectives. Safe for
+// concurrent use; a nil Tracker records nothing.
+type Tracker struct {
+	alerter *Alerter
+	now     func() time.Time
+
+	mu     sync.Mutex
+	series map[string]*series
+	order  []string
+}
+
+// NewTracker returns a tracker with no objectives. alerter may be nil.
+func NewTracker(alerter *Alerter) *Tracker {
+	return &Tracker{alerter: alerter, now: time.Now, series: make(map[string]*series)}
+}
+
+// Set replaces the objectives. History and alert state are kept for objectives
+// whose name and definition are unchanged.
+func (t *Tracker) Set(objectives []Objective) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next := make(map[string]*series, len(objectives))
+	t.order = t.order[:0]
+	for _, o := range objectives {
+		if s, ok := t.series[o.Name]; ok && s.objective == o {
+			next[o.Name] = s
+		} else {
+			next[o.Name] = &series{objective: o, firing: map[string]bool{}}
+		}
+		t.order = append(t.order, o.Name)
+	}
+	t.series = next
+}
+
+// Observe records one provider response.
+func (t *Tracker) Observe(provider, model string, latency time.Duration, failed bool) {
+	if t == nil {
+		return
+	}
+	minute := t.now().Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.series {
+		if s.objective.matches(provider, model) {
+			s.observe(minute, latency, failed)
+		}
+	}
+}
+
+// Wrap measures responses from base against the objectives. model extracts the
+// request's model (it may be in the body, which the transport cannot read).
+func (t *Tracker) Wrap(base http.RoundTripper, provider string, model func(*http.Request) string) http.RoundTripper {
+	if t == nil {
+		return base
+	}
+	return &trackedTransport{tracker: t, base: base, provider: provider, model: model}
+}
+
+type trackedTransport struct {
+	tracker  *Tracker
+	base     http.RoundTripper
+	provider string
+	model    func(*http.Request) string
+}
+
+func (t *trackedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	// Cancelled client requests say nothing about the provider.
+	if err == nil || req.Context().Err() == nil {
+		t.tracker.Observe(t.provider, t.model(req), time.Since(start), failed)
+	}
+	return resp, err
+}
@@ -0,0 +1,125 @@
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestTracker(alerter *Alerter, now *time.Time) *Tracker {
+	t := NewTracker(alerter)
+	t.now = func() time.Time { return *now }
+	return t
+}
+
+func TestEvaluateFiresAndResolves(t *testing.T) {
+	var got []Alert
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		_ = json.NewDecoder(r.Body).Decode(&a)
+		got = append(got, a)
+	}))
+	defer hook.Close()
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tracker := newTestTracker(NewAlerter(hook.URL, nil), &now)
+	tracker.Set([]Objective{{Name: "gpt-4o", Provider: "openai", Model: "gpt-4o", ErrorTarget: 0.99, MinRequests: 10}})
+
+	// 20% errors burns a 1% budget 20x: above the 14.4 threshold in both windows.
+	for i := range 50 {
+		tracker.Observe("openai", "gpt-4o", 100*time.Millisecond, i%5 == 0)
+	}
+	tracker.Observe("openai", "gpt-4o-mini", time.Millisecond, true) // other model, ignored
+
+	alerts := tracker.Evaluate(context.Background())
+	if len(alerts) != 1 || alerts[0].State != StateFiring || alerts[0].Indicator != IndicatorErrors {
+		t.Fatalf("expected a firing errors alert, got %+v", alerts)
+	}
+	if alerts[0].ShortRate < 19.9 || alerts[0].ShortRate > 20.1 {
+		t.Fatalf("expected burn rate 20, got %v", alerts[0].ShortRate)
+	}
+	if alerts := tracker.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("expected no repeat alert while firing, got %+v", alerts)
+	}
+
+	// Ten minutes of healthy traffic clears the short window.
+	now = now.Add(10 * time.Minute)
+	for range 50 {
+		tracker.Observe("openai", "gpt-4o", 100*time.Millisecond, false)
+	}
+	alerts = tracker.Evaluate(context.Background())
+	if len(alerts) != 1 || alerts[0].State != StateResolved {
+		t.Fatalf("expected a resolved alert, got %+v", alerts)
+	}
+	if len(got) != 2 || got[0].State != StateFiring || got[1].State != StateResolved {
+		t.Fatalf("expected webhook to receive firing then resolved, got %+v", got)
+	}
+}
+
+func TestStatusReportsLatencyBurn(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tracker := newTestTracker(nil, &now)
+	tracker.Set([]Objective{{Name: "latency", LatencyMs: 500, LatencyTarget: 0.9}})
+
+	for i := range 10 {
+		latency := 100 * time.Millisecond
+		if i < 2 {
+			latency = time.Second
+		}
+		tracker.Observe("anthropic", "claude", latency, false)
+	}
+	status := tracker.Status()
+	if len(status) != 1 || len(status[0].Indicators) != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	// 20% slow against a 10% allowance.
+	if b := status[0].Indicators[0]; b.Indicator != IndicatorLatency || b.LongRate < 1.99 || b.LongRate > 2.01 || b.Requests1h != 10 {
+		t.Fatalf("unexpected latency burn %+v", b)
+	}
+	if alerts := tracker.Evaluate(context.Background()); len(alerts) != 0 {
+		t.Fatalf("expected no alert below min_requests, got %+v", alerts)
+	}
+}
+
+func TestWrapObservesResponses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	now := time.Now()
+	tracker := newTestTracker(nil, &now)
+	tracker.Set([]Objective{{Name: "errors", Model: "gpt-4o", ErrorTarget: 0.99}})
+	client := &http.Client{Transport: tracker.Wrap(http.DefaultTransport, "openai", func(*http.Request) string { return "gpt-4o" })}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+
+	b := tracker.Status()[0].Indicators[0]
+	if b.Requests1h != 1 || b.LongRate < 99.9 {
+		t.Fatalf("expected one failed request, got %+v", b)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []Objective{
+		{Name: "none"},
+		{Name: "no-target", LatencyMs: 100},
+		{Name: "bad-error", ErrorTarget: 1},
+		{ErrorTarget: 0.99},
+	}
+	for _, o := range cases {
+		if err := o.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", o)
+		}
+	}
+	if err := (Objective{Name: "ok", LatencyMs: 100, LatencyTarget: 0.95}).Validate(); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
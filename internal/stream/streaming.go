@@ -7,22 +7,24 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/events"
 	"agent-sentinel/internal/providers"
 	"agent-sentinel/internal/ratelimit"
 	"agent-sentinel/internal/telemetry"
+
+	"embedding-sidecar/inflight"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TokenUsage = providers.TokenUsage
 
-type costAdjuster interface {
-	AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error
-	RefundEstimate(ctx context.Context, tenantID string, estimate float64) error
-}
-
 // IsStreamingResponse checks response headers for streaming content types.
 func IsStreamingResponse(resp *http.Response) bool {
 	contentType := resp.Header.Get("Content-Type")
@@ -31,64 +33,99 @@ func IsStreamingResponse(resp *http.Response) bool {
 		strings.Contains(contentType, "stream")
 }
 
+// StreamingResponseReader passes a streaming body through while extracting token
+// usage, and settles the reservation exactly once when the stream ends: on
+// [DONE], EOF or Close, whichever comes first.
 type StreamingResponseReader struct {
-	reader     io.ReadCloser
-	parseUsage func(map[string]any) providers.TokenUsage
-	usage      providers.TokenUsage
-	buffer     []byte
-	hasError   bool
-	tenantID   string
-	estimate   float64
-	pricing    ratelimit.Pricing
-	limiter    costAdjuster
-	provider   string
-	model      string
-	startTime  time.Time
-	firstToken time.Time
-	finalized  bool
+	reader      io.ReadCloser
+	parseUsage  func(map[string]any) providers.TokenUsage
+	usage       providers.TokenUsage
+	buffer      []byte
+	hasError    bool
+	tenantID    string
+	reservation string
+	estimate    float64
+	pricing     ratelimit.Pricing
+	limiter     ratelimit.CostSettler
+	provider    string
+	model       string
+	startTime   time.Time
+	firstToken  time.Time
+
+	// OnSettle, when set, is called once from the settlement goroutine with the
+	// usage and actual cost (zero when usage was not reported) and whether the
+	// stream failed.
+	OnSettle func(usage TokenUsage, actual float64, failed bool)
+	// ExtractText, when set, returns a chunk's generated text. The text is kept so
+	// that a stream ending without usage is charged for the output it actually
+	// streamed rather than the worst-case estimate.
+	ExtractText func(map[string]any) string
+	// InputTokens is the request's estimated input size, charged alongside the
+	// counted output. Content-based estimation is skipped when it is 0.
+	InputTokens int
+	// ExtractFinishReasons, when set, returns the finish reasons reported in a chunk.
+	ExtractFinishReasons func(map[string]any) []string
+	// Span is the request span. Usage and finish reasons are recorded on it at
+	// settlement, and metrics recorded afterwards carry it as their exemplar.
+	Span trace.Span
+	// Group is the request's async group (see async.WithGroup), which the
+	// settlement runs in; nil uses the process-wide one.
+	Group         *inflight.Group
+	text          strings.Builder
+	finishReasons []string
+
+	// mu guards the parse state above; Read and Close may race when the client
+	// disconnects mid-stream.
+	mu sync.Mutex
+	// settle makes settlement single-flight across [DONE], EOF and Close.
+	settle sync.Once
 }
 
-func NewStreamingResponseReader(reader io.ReadCloser, parseUsage func(map[string]any) providers.TokenUsage, tenantID string, estimate float64, pricing ratelimit.Pricing, limiter costAdjuster, provider string, model string, startTime time.Time) *StreamingResponseReader {
+func NewStreamingResponseReader(reader io.ReadCloser, parseUsage func(map[string]any) providers.TokenUsage, tenantID, reservationID string, estimate float64, pricing ratelimit.Pricing, limiter ratelimit.CostSettler, provider string, model string, startTime time.Time) *StreamingResponseReader {
 	return &StreamingResponseReader{
-		reader:     reader,
-		parseUsage: parseUsage,
-		tenantID:   tenantID,
-		estimate:   estimate,
-		pricing:    pricing,
-		limiter:    limiter,
-		provider:   provider,
-		model:      model,
-		startTime:  startTime,
-		buffer:     make([]byte, 0, 4096),
+		reader:      reader,
+		parseUsage:  parseUsage,
+		tenantID:    tenantID,
+		reservation: reservationID,
+		estimate:    estimate,
+		pricing:     pricing,
+		limiter:     limiter,
+		provider:    provider,
+		model:       model,
+		startTime:   startTime,
+		buffer:      make([]byte, 0, 4096),
 	}
 }
 
 func (s *StreamingResponseReader) Read(p []byte) (n int, err error) {
 	n, err = s.reader.Read(p)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if n > 0 {
 		s.processChunk(p[:n])
 	}
-	if err == io.EOF && !s.finalized {
-		if len(s.buffer) > 0 {
-			s.parseSSELine(s.buffer)
-		}
-		s.finalizeCost()
-		s.finalized = true
+	if err == io.EOF {
+		s.flush()
 	}
 	return n, err
 }
 
 func (s *StreamingResponseReader) Close() error {
-	if !s.finalized {
-		if len(s.buffer) > 0 {
-			s.parseSSELine(s.buffer)
-		}
-		s.finalizeCost()
-		s.finalized = true
-	}
+	s.mu.Lock()
+	s.flush()
+	s.mu.Unlock()
 	return s.reader.Close()
 }
 
+// flush parses any unterminated trailing line and settles. Caller holds mu.
+func (s *StreamingResponseReader) flush() {
+	if len(s.buffer) > 0 {
+		s.parseSSELine(s.buffer)
+		s.buffer = s.buffer[:0]
+	}
+	s.finalizeCost()
+}
+
 func (s *StreamingResponseReader) processChunk(data []byte) {
 	s.buffer = append(s.buffer, data...)
 
@@ -154,25 +191,66 @@ func (s *StreamingResponseReader) parseSSELine(line []byte) {
 		}
 		s.usage.Found = true
 	}
+	if s.ExtractText != nil && !s.usage.Found {
+		s.text.WriteString(s.ExtractText(chunk))
+	}
+	if s.ExtractFinishReasons != nil {
+		s.finishReasons = append(s.finishReasons, s.ExtractFinishReasons(chunk)...)
+	}
 }
 
+// finalizeCost settles the reservation from the usage seen so far. Only the first
+// call has any effect; later [DONE]/EOF/Close triggers are no-ops. Caller holds mu.
 func (s *StreamingResponseReader) finalizeCost() {
+	s.settle.Do(s.settleCost)
+}
+
+func (s *StreamingResponseReader) settleCost() {
+	// Settlement happens while the response is still being served, before the
+	// request span ends.
+	telemetry.SetGenAIResponse(s.Span, s.usage, s.finishReasons, nil)
 	if s.limiter == nil {
 		return
 	}
 
-	async.Run(func() {
-		bgCtx := context.Background()
+	// Snapshot parse state; the stream may keep delivering bytes after settlement.
+	usage, hasError, firstToken := s.usage, s.hasError, s.firstToken
+	finishReasons := slices.Clone(s.finishReasons)
+	var text string
+	if !usage.Found && !hasError && s.InputTokens > 0 {
+		text = s.text.String()
+	}
+	bgCtx := async.WithGroup(context.Background(), s.Group)
+	if s.Span != nil {
+		bgCtx = trace.ContextWithSpanContext(bgCtx, s.Span.SpanContext())
+	}
+	async.Go(bgCtx, func() {
 		if !s.startTime.IsZero() {
 			telemetry.ObserveStreamDuration(bgCtx, s.provider, s.model, s.tenantID, time.Since(s.startTime))
 		}
-		if !s.firstToken.IsZero() && !s.startTime.IsZero() && s.firstToken.After(s.startTime) {
-			telemetry.ObserveTTFT(bgCtx, s.provider, s.model, s.tenantID, s.firstToken.Sub(s.startTime))
+		if !firstToken.IsZero() && !s.startTime.IsZero() && firstToken.After(s.startTime) {
+			telemetry.ObserveTTFT(bgCtx, s.provider, s.model, s.tenantID, firstToken.Sub(s.startTime))
+		}
+		for _, reason := range finishReasons {
+			reason = providers.NormalizeFinishReason(reason)
+			telemetry.IncFinishReason(bgCtx, s.provider, s.model, s.tenantID, reason)
+			events.ObserveFinishReason(s.tenantID, reason)
+		}
+
+		// No usage chunk (e.g. stream_options.include_usage unset): count the
+		// streamed output instead of keeping the max-tokens estimate.
+		estimatedFromContent := false
+		if !usage.Found && text != "" {
+			usage = TokenUsage{InputTokens: s.InputTokens, OutputTokens: ratelimit.CountTokens(text, s.model), Found: true}
+			estimatedFromContent = true
 		}
 
-		if s.usage.Found {
-			actualCost := ratelimit.CalculateCost(s.usage.InputTokens, s.usage.OutputTokens, s.pricing)
-			if err := s.limiter.AdjustCost(bgCtx, s.tenantID, s.estimate, actualCost); err != nil {
+		if usage.Found {
+			actualCost := ratelimit.CalculateCost(usage.InputTokens, usage.OutputTokens, s.pricing)
+			if s.OnSettle != nil {
+				s.OnSettle(usage, actualCost, hasError)
+			}
+			if err := s.limiter.AdjustCost(bgCtx, s.tenantID, s.reservation, s.estimate, actualCost); err != nil {
 				slog.Warn("Failed to adjust cost from streaming response",
 					"error", err,
 					"tenant_id", s.tenantID,
@@ -180,17 +258,21 @@ func (s *StreamingResponseReader) finalizeCost() {
 					"actual", actualCost,
 				)
 			} else {
-				telemetry.ObserveCostDelta(bgCtx, s.provider, s.model, s.tenantID, actualCost-s.estimate)
+				telemetry.ObserveCostDelta(bgCtx, s.provider, s.model, s.tenantID, actualCost-s.estimate, estimatedFromContent)
 				slog.Debug("Cost adjusted from streaming response",
 					"tenant_id", s.tenantID,
 					"estimate", s.estimate,
 					"actual", actualCost,
-					"input_tokens", s.usage.InputTokens,
-					"output_tokens", s.usage.OutputTokens,
+					"input_tokens", usage.InputTokens,
+					"output_tokens", usage.OutputTokens,
+					"estimated_from_content", estimatedFromContent,
 				)
 			}
-		} else if s.hasError {
-			if err := s.limiter.RefundEstimate(bgCtx, s.tenantID, s.estimate); err != nil {
+		} else if hasError {
+			if s.OnSettle != nil {
+				s.OnSettle(usage, 0, true)
+			}
+			if err := s.limiter.RefundEstimate(bgCtx, s.tenantID, s.reservation, s.estimate); err != nil {
 				slog.Warn("Failed to refund estimate from streaming error",
 					"error", err,
 					"tenant_id", s.tenantID,
@@ -203,6 +285,19 @@ func (s *StreamingResponseReader) finalizeCost() {
 					"estimate", s.estimate,
 				)
 			}
+		} else {
+			// Nothing to count: keep the estimate rather than leave the
+			// reservation for the reconciler to refund.
+			if s.OnSettle != nil {
+				s.OnSettle(usage, s.estimate, false)
+			}
+			if err := s.limiter.AdjustCost(bgCtx, s.tenantID, s.reservation, s.estimate, s.estimate); err != nil {
+				slog.Warn("Failed to settle estimate from streaming response",
+					"error", err,
+					"tenant_id", s.tenantID,
+					"estimate", s.estimate,
+				)
+			}
 		}
 	})
 }
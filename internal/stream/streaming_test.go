@@ -17,14 +17,17 @@ type fakeLimiter struct {
 	adjustEstimate float64
 	adjustActual   float64
 	refundEstimate float64
+	adjustCalls    int
+	refundCalls    int
 	adjustCh       chan struct{}
 	refundCh       chan struct{}
 }
 
-func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID string, estimate, actual float64) error {
+func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID, reservationID string, estimate, actual float64) error {
 	f.mu.Lock()
 	f.adjustEstimate = estimate
 	f.adjustActual = actual
+	f.adjustCalls++
 	f.mu.Unlock()
 	if f.adjustCh != nil {
 		select {
@@ -35,9 +38,10 @@ func (f *fakeLimiter) AdjustCost(ctx context.Context, tenantID string, estimate,
 	return nil
 }
 
-func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID string, estimate float64) error {
+func (f *fakeLimiter) RefundEstimate(ctx context.Context, tenantID, reservationID string, estimate float64) error {
 	f.mu.Lock()
 	f.refundEstimate = estimate
+	f.refundCalls++
 	f.mu.Unlock()
 	if f.refundCh != nil {
 		select {
@@ -64,7 +68,7 @@ func TestStreamingAdjustsCostOnUsage(t *testing.T) {
 			}
 		}
 		return TokenUsage{}
-	}, "tenant", 1.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", start)
+	}, "tenant", "res-1", 1.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", start)
 
 	buf := make([]byte, 1024)
 	_, _ = reader.Read(buf)
@@ -92,7 +96,7 @@ func TestStreamingRefundsOnErrorNoUsage(t *testing.T) {
 	async.Init()
 	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), func(m map[string]any) TokenUsage {
 		return TokenUsage{}
-	}, "tenant", 2.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", start)
+	}, "tenant", "res-2", 2.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", start)
 
 	buf := make([]byte, 1024)
 	_, _ = reader.Read(buf)
@@ -110,3 +114,152 @@ func TestStreamingRefundsOnErrorNoUsage(t *testing.T) {
 	}
 	lim.mu.Unlock()
 }
+
+func TestStreamingKeepsEstimateWithoutUsageOrText(t *testing.T) {
+	streamData := "data: {\"type\": \"ping\"}\n\ndata: [DONE]\n\n"
+	lim := &fakeLimiter{}
+	lim.adjustCh = make(chan struct{}, 1)
+	async.Init()
+	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), func(m map[string]any) TokenUsage {
+		return TokenUsage{}
+	}, "tenant", "res-3", 2.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", time.Now())
+	var settled float64
+	reader.OnSettle = func(usage TokenUsage, actual float64, failed bool) { settled = actual }
+
+	_, _ = io.ReadAll(reader)
+	_ = reader.Close()
+
+	select {
+	case <-lim.adjustCh:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timed out waiting for the reservation to settle")
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if lim.adjustEstimate != 2.0 || lim.adjustActual != 2.0 || lim.refundCalls != 0 || settled != 2.0 {
+		t.Fatalf("expected the estimate kept, got adjust %v->%v, %d refunds, usage cost %v", lim.adjustEstimate, lim.adjustActual, lim.refundCalls, settled)
+	}
+}
+
+func TestStreamingEstimatesOutputFromContentWithoutUsage(t *testing.T) {
+	streamData := "data: {\"choices\": [{\"delta\": {\"content\": \"Hello\"}}]}\n\n" +
+		"data: {\"choices\": [{\"delta\": {\"content\": \" world\"}}]}\n\ndata: [DONE]\n\n"
+	lim := &fakeLimiter{}
+	lim.adjustCh = make(chan struct{}, 1)
+	async.Init()
+	pricing := ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}
+	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), parseTestUsage, "tenant", "res-3", 1.0, pricing, lim, "prov", "gpt-4o", time.Now())
+	reader.InputTokens = 10
+	reader.ExtractText = func(m map[string]any) string {
+		choices, _ := m["choices"].([]any)
+		if len(choices) == 0 {
+			return ""
+		}
+		delta, _ := choices[0].(map[string]any)["delta"].(map[string]any)
+		text, _ := delta["content"].(string)
+		return text
+	}
+
+	_, _ = io.ReadAll(reader)
+	_ = reader.Close()
+
+	select {
+	case <-lim.adjustCh:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for adjust")
+	}
+	want := ratelimit.CalculateCost(10, ratelimit.CountTokens("Hello world", "gpt-4o"), pricing)
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if lim.adjustActual != want || lim.refundCalls != 0 {
+		t.Fatalf("expected cost from streamed content %v, got %v (refunds %d)", want, lim.adjustActual, lim.refundCalls)
+	}
+}
+
+func parseTestUsage(m map[string]any) TokenUsage {
+	if usage, ok := m["usage"].(map[string]any); ok {
+		return TokenUsage{
+			InputTokens:  int(usage["prompt_tokens"].(float64)),
+			OutputTokens: int(usage["completion_tokens"].(float64)),
+			Found:        true,
+		}
+	}
+	return TokenUsage{}
+}
+
+func settlements(lim *fakeLimiter) (int, int) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	return lim.adjustCalls, lim.refundCalls
+}
+
+func TestStreamingSettlesOnceOnEOFThenClose(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	lim := &fakeLimiter{}
+	streamData := "data: {\"usage\": {\"prompt_tokens\": 2, \"completion_tokens\": 3}}\n\n"
+	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), parseTestUsage,
+		"tenant", "res-eof", 1.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", time.Now())
+
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = reader.Close()
+
+	if adjusts, refunds := settlements(lim); adjusts != 1 || refunds != 0 {
+		t.Fatalf("expected one adjustment, got adjusts=%d refunds=%d", adjusts, refunds)
+	}
+}
+
+func TestStreamingSettlesOnCloseWithoutEOF(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	lim := &fakeLimiter{}
+	// Client disconnects before EOF; the usage line is still unterminated in the buffer.
+	streamData := "data: {\"usage\": {\"prompt_tokens\": 2, \"completion_tokens\": 3}}"
+	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), parseTestUsage,
+		"tenant", "res-close", 1.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", time.Now())
+
+	buf := make([]byte, len(streamData))
+	if _, err := reader.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = reader.Close()
+	_ = reader.Close()
+
+	if adjusts, _ := settlements(lim); adjusts != 1 {
+		t.Fatalf("expected one adjustment, got %d", adjusts)
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if lim.adjustActual == 0 {
+		t.Fatalf("expected usage from the buffered line to be settled")
+	}
+}
+
+func TestStreamingSettlesOnceOnDoneWithTrailingBytes(t *testing.T) {
+	defer func() { async.RunOverride = nil }()
+	async.RunOverride = func(fn func()) { fn() }
+	lim := &fakeLimiter{}
+	// [DONE] settles; a late usage chunk and an unterminated tail must not settle again.
+	streamData := "data: {\"usage\": {\"prompt_tokens\": 2, \"completion_tokens\": 3}}\n\n" +
+		"data: [DONE]\n\n" +
+		"data: {\"usage\": {\"prompt_tokens\": 20, \"completion_tokens\": 30}}\n\n" +
+		"data: [DONE]"
+	reader := NewStreamingResponseReader(io.NopCloser(bytes.NewBufferString(streamData)), parseTestUsage,
+		"tenant", "res-done", 1.0, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}, lim, "prov", "model", time.Now())
+
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = reader.Close()
+
+	if adjusts, refunds := settlements(lim); adjusts != 1 || refunds != 0 {
+		t.Fatalf("expected one adjustment, got adjusts=%d refunds=%d", adjusts, refunds)
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	if want := ratelimit.CalculateCost(2, 3, ratelimit.Pricing{InputPrice: 1, OutputPrice: 1}); lim.adjustActual != want {
+		t.Fatalf("expected settlement from usage before [DONE] (%v), got %v", want, lim.adjustActual)
+	}
+}
@@ -0,0 +1,131 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+
+	"agent-sentinel/internal/providers"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry GenAI semantic convention attributes, so LLM observability
+// tools recognise proxy spans without custom mapping.
+const (
+	genAISystem                = attribute.Key("gen_ai.system")
+	genAIOperationName         = attribute.Key("gen_ai.operation.name")
+	genAIRequestModel          = attribute.Key("gen_ai.request.model")
+	genAIRequestMaxTokens      = attribute.Key("gen_ai.request.max_tokens")
+	genAIRequestTemperature    = attribute.Key("gen_ai.request.temperature")
+	genAIRequestTopP           = attribute.Key("gen_ai.request.top_p")
+	genAIResponseID            = attribute.Key("gen_ai.response.id")
+	genAIResponseModel         = attribute.Key("gen_ai.response.model")
+	genAIResponseFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	genAIUsageInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	genAIUsageOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+)
+
+type requestSpanKey struct{}
+
+// RequestSpan returns the llm_proxy_request span for ctx. Provider response
+// handlers run under the (already ended) provider.http span, so they use this
+// to annotate the request span instead.
+func RequestSpan(ctx context.Context) trace.Span {
+	if span, ok := ctx.Value(requestSpanKey{}).(trace.Span); ok {
+		return span
+	}
+	return trace.SpanFromContext(ctx)
+}
+
+// genAIAttributes describes a request from its provider and path alone; the
+// model is only known here when the provider puts it in the path (Gemini).
+func genAIAttributes(provider providers.Provider, path string) []attribute.KeyValue {
+	if provider == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{genAISystem.String(genAISystemName(provider.Name()))}
+	if op := genAIOperation(path); op != "" {
+		attrs = append(attrs, genAIOperationName.String(op))
+	}
+	if model := provider.ExtractModelFromPath(path); model != "" {
+		attrs = append(attrs, genAIRequestModel.String(model))
+	}
+	return attrs
+}
+
+func genAISystemName(provider string) string {
+	if provider == "gemini" {
+		return "gcp.gemini"
+	}
+	return provider
+}
+
+func genAIOperation(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/chat/completions"), strings.HasSuffix(path, "/responses"),
+		strings.HasSuffix(path, "/messages"), strings.HasSuffix(path, ":generateContent"),
+		strings.HasSuffix(path, ":streamGenerateContent"):
+		return "chat"
+	case strings.HasSuffix(path, "/completions"):
+		return "text_completion"
+	case strings.HasSuffix(path, "/embeddings"), strings.HasSuffix(path, ":embedContent"),
+		strings.HasSuffix(path, ":batchEmbedContents"):
+		return "embeddings"
+	}
+	return ""
+}
+
+// SetGenAIRequest records the requested model and sampling parameters from a
+// parsed request body on the request span.
+func SetGenAIRequest(ctx context.Context, model string, maxTokens int, data map[string]any) {
+	span := RequestSpan(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if model != "" {
+		span.SetAttributes(genAIRequestModel.String(model))
+	}
+	if maxTokens > 0 {
+		span.SetAttributes(genAIRequestMaxTokens.Int(maxTokens))
+	}
+	params := data
+	if config, ok := data["generationConfig"].(map[string]any); ok {
+		params = config
+	}
+	if v, ok := params["temperature"].(float64); ok {
+		span.SetAttributes(genAIRequestTemperature.Float64(v))
+	}
+	for _, key := range []string{"top_p", "topP"} {
+		if v, ok := params[key].(float64); ok {
+			span.SetAttributes(genAIRequestTopP.Float64(v))
+		}
+	}
+}
+
+// SetGenAIResponse records token usage, finish reasons and, when the full
+// response body is available, the response ID and model on span.
+func SetGenAIResponse(span trace.Span, usage providers.TokenUsage, finishReasons []string, data map[string]any) {
+	if span == nil || !span.IsRecording() {
+		return
+	}
+	if usage.Found {
+		span.SetAttributes(
+			genAIUsageInputTokens.Int(usage.InputTokens),
+			genAIUsageOutputTokens.Int(usage.OutputTokens),
+		)
+	}
+	if len(finishReasons) > 0 {
+		span.SetAttributes(genAIResponseFinishReasons.StringSlice(finishReasons))
+	}
+	for _, key := range []string{"id", "responseId"} {
+		if id, ok := data[key].(string); ok && id != "" {
+			span.SetAttributes(genAIResponseID.String(id))
+		}
+	}
+	for _, key := range []string{"model", "modelVersion"} {
+		if model, ok := data[key].(string); ok && model != "" {
+			span.SetAttributes(genAIResponseModel.String(model))
+		}
+	}
+}
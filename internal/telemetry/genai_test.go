@@ -0,0 +1,67 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/providers/gemini"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddlewareRecordsGenAIAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := tracer
+	tracer = tp.Tracer("test")
+	defer func() { tracer = prev }()
+
+	provider, _ := gemini.New("key")
+	handler := Middleware(provider, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{"generationConfig": map[string]any{"temperature": 0.2, "topP": 0.9}}
+		SetGenAIRequest(r.Context(), "gemini-2.0-flash", 256, body)
+		// Response handlers see a child span; attributes must still land on the request span.
+		ctx, child := StartSpan(r.Context(), "provider.http")
+		child.End()
+		response := map[string]any{"responseId": "resp-1", "modelVersion": "gemini-2.0-flash-001"}
+		SetGenAIResponse(RequestSpan(ctx), providers.TokenUsage{InputTokens: 12, OutputTokens: 34, Found: true}, []string{"STOP"}, response)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.0-flash:generateContent", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var span sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "llm_proxy_request" {
+			span = s
+		}
+	}
+	if span == nil {
+		t.Fatal("expected an llm_proxy_request span")
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	want := map[attribute.Key]string{
+		genAISystem:                "gcp.gemini",
+		genAIOperationName:         "chat",
+		genAIRequestModel:          "gemini-2.0-flash",
+		genAIRequestMaxTokens:      "256",
+		genAIRequestTemperature:    "0.2",
+		genAIRequestTopP:           "0.9",
+		genAIResponseID:            "resp-1",
+		genAIResponseModel:         "gemini-2.0-flash-001",
+		genAIResponseFinishReasons: `["STOP"]`,
+		genAIUsageInputTokens:      "12",
+		genAIUsageOutputTokens:     "34",
+	}
+	for key, value := range want {
+		if got := attrs[key].Emit(); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package telemetry
 
 import (
 	"net/http"
+	"net/http/httptrace"
 	"time"
 
+	"agent-sentinel/internal/events"
 	"agent-sentinel/internal/providers"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -44,7 +46,12 @@ func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, er
 			attribute.String("provider", providerName),
 			attribute.String("llm.model", model),
 		),
+		trace.WithAttributes(genAIAttributes(t.provider, req.URL.Path)...),
 	)
+	var reused bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	})
 	start := time.Now()
 	resp, err := t.base.RoundTrip(req.WithContext(ctx))
 	latency := time.Since(start)
@@ -53,6 +60,12 @@ func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, er
 	result := "ok"
 	if resp != nil {
 		status = resp.StatusCode
+		protocol, version := "http/1.1", "1.1"
+		if resp.ProtoMajor == 2 {
+			protocol, version = "h2", "2"
+		}
+		span.SetAttributes(attribute.String("network.protocol.version", version))
+		IncProviderConn(ctx, providerName, protocol, reused)
 	}
 	if err != nil {
 		result = "error"
@@ -63,6 +76,7 @@ func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, er
 	}
 
 	ObserveProviderHTTP(ctx, providerName, model, status, result, latency)
+	events.ObserveLatency(providerName, latency)
 	span.End()
 	return resp, err
 }
@@ -0,0 +1,320 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	logQueueSize     = 4096
+	logBatchSize     = 512
+	logFlushInterval = time.Second
+	logExportTimeout = 10 * time.Second
+)
+
+// InitLogs adds trace_id/span_id to every log record written with a context
+// carrying a span, and request_id to those carrying a request ID. When
+// OTEL_LOGS_EXPORTER=otlp, it also exports records over OTLP gRPC to
+// OTEL_EXPORTER_OTLP_LOGS_ENDPOINT (default OTEL_EXPORTER_OTLP_ENDPOINT).
+// Stdout logging is unchanged. The returned function flushes pending records.
+func InitLogs() func(context.Context) error {
+	base := slog.Default().Handler()
+	var exporter *logExporter
+	if os.Getenv("OTEL_LOGS_EXPORTER") == "otlp" {
+		exporter = newLogExporterFromEnv(base)
+	}
+	slog.SetDefault(slog.New(newLogHandler(base, exporter)))
+	if exporter == nil {
+		return func(context.Context) error { return nil }
+	}
+	slog.Info("OpenTelemetry log export enabled", "endpoint", exporter.endpoint)
+	return exporter.Shutdown
+}
+
+func newLogExporterFromEnv(base slog.Handler) *logExporter {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	// Exporter problems go straight to stdout so they are not fed back into the queue.
+	logger := slog.New(base)
+	if endpoint == "" {
+		logger.Warn("OTEL_LOGS_EXPORTER=otlp but no OTLP endpoint is set, log export disabled")
+		return nil
+	}
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		logger.Warn("Failed to create OTLP log exporter, log export disabled", "error", err, "endpoint", endpoint)
+		return nil
+	}
+	client := collogspb.NewLogsServiceClient(conn)
+	e := newLogExporter(func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		_, err := client.Export(ctx, req)
+		return err
+	}, logger)
+	e.endpoint = endpoint
+	e.conn = conn
+	return e
+}
+
+// logExporter batches records and sends them in the background. Records are
+// dropped rather than blocking the caller when the queue is full.
+type logExporter struct {
+	send     func(context.Context, *collogspb.ExportLogsServiceRequest) error
+	logger   *slog.Logger
+	endpoint string
+	conn     *grpc.ClientConn
+
+	queue chan *logspb.LogRecord
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+func newLogExporter(send func(context.Context, *collogspb.ExportLogsServiceRequest) error, logger *slog.Logger) *logExporter {
+	e := &logExporter{
+		send:   send,
+		logger: logger,
+		queue:  make(chan *logspb.LogRecord, logQueueSize),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *logExporter) enqueue(rec *logspb.LogRecord) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- rec:
+	default:
+		e.dropped++
+	}
+}
+
+func (e *logExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*logspb.LogRecord, 0, logBatchSize)
+	for {
+		select {
+		case rec, ok := <-e.queue:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) == logBatchSize {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (e *logExporter) export(batch []*logspb.LogRecord) {
+	e.mu.Lock()
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.logger.Warn("OTLP log queue full, records dropped", "dropped", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logExportTimeout)
+	defer cancel()
+	if err := e.send(ctx, newExportLogsRequest(slices.Clone(batch))); err != nil {
+		e.logger.Warn("OTLP log export failed", "error", err, "records", len(batch))
+	}
+}
+
+// Shutdown exports queued records and closes the connection.
+func (e *logExporter) Shutdown(ctx context.Context) error {
+	e.once.Do(func() {
+		e.mu.Lock()
+		e.closed = true
+		close(e.queue)
+		e.mu.Unlock()
+	})
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if e.conn != nil {
+		return e.conn.Close()
+	}
+	return nil
+}
+
+func newExportLogsRequest(records []*logspb.LogRecord) *collogspb.ExportLogsServiceRequest {
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringKeyValue("service.name", "agent-sentinel"),
+				stringKeyValue("service.version", "1.0.0"),
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: "agent-sentinel"},
+				LogRecords: records,
+			}},
+		}},
+	}
+}
+
+// logHandler wraps a slog handler, adding trace context to records and
+// copying them to an OTLP exporter when one is configured.
+type logHandler struct {
+	base     slog.Handler
+	exporter *logExporter
+	// attrs and group mirror WithAttrs/WithGroup for the exported copy.
+	attrs []*commonpb.KeyValue
+	group string
+}
+
+// newLogHandler returns a handler writing to base and, when exporter is non-nil,
+// exporting each record.
+func newLogHandler(base slog.Handler, exporter *logExporter) *logHandler {
+	return &logHandler{base: base, exporter: exporter}
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.base.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if h.exporter != nil {
+		h.exporter.enqueue(h.toOTLP(r, spanCtx))
+	}
+	if spanCtx.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String("trace_id", spanCtx.TraceID().String()),
+			slog.String("span_id", spanCtx.SpanID().String()),
+		)
+	}
+	return h.base.Handle(ctx, r)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.base = h.base.WithAttrs(attrs)
+	next.attrs = slices.Clone(h.attrs)
+	for _, a := range attrs {
+		next.attrs = appendAttr(next.attrs, h.group, a)
+	}
+	return &next
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.base = h.base.WithGroup(name)
+	next.group = h.group + name + "."
+	return &next
+}
+
+func (h *logHandler) toOTLP(r slog.Record, spanCtx trace.SpanContext) *logspb.LogRecord {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, a)
+		return true
+	})
+	rec := &logspb.LogRecord{
+		TimeUnixNano:         uint64(r.Time.UnixNano()),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       severityNumber(r.Level),
+		SeverityText:         r.Level.String(),
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: r.Message}},
+		Attributes:           attrs,
+	}
+	if spanCtx.IsValid() {
+		traceID, spanID := spanCtx.TraceID(), spanCtx.SpanID()
+		rec.TraceId = traceID[:]
+		rec.SpanId = spanID[:]
+		rec.Flags = uint32(spanCtx.TraceFlags())
+	}
+	return rec
+}
+
+// severityNumber maps slog levels onto the OTLP severity ranges (DEBUG 5-8,
+// INFO 9-12, WARN 13-16, ERROR 17-20).
+func severityNumber(level slog.Level) logspb.SeverityNumber {
+	n := int(logspb.SeverityNumber_SEVERITY_NUMBER_INFO) + int(level-slog.LevelInfo)
+	return logspb.SeverityNumber(max(int(logspb.SeverityNumber_SEVERITY_NUMBER_TRACE), min(n, int(logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4))))
+}
+
+// appendAttr flattens a slog attribute (including groups) into dotted keys.
+func appendAttr(dst []*commonpb.KeyValue, prefix string, a slog.Attr) []*commonpb.KeyValue {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			dst = appendAttr(dst, prefix, ga)
+		}
+		return dst
+	}
+	if a.Key == "" {
+		return dst
+	}
+	var av commonpb.AnyValue
+	switch v.Kind() {
+	case slog.KindString:
+		av.Value = &commonpb.AnyValue_StringValue{StringValue: v.String()}
+	case slog.KindInt64:
+		av.Value = &commonpb.AnyValue_IntValue{IntValue: v.Int64()}
+	case slog.KindUint64:
+		av.Value = &commonpb.AnyValue_IntValue{IntValue: int64(v.Uint64())}
+	case slog.KindFloat64:
+		av.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: v.Float64()}
+	case slog.KindBool:
+		av.Value = &commonpb.AnyValue_BoolValue{BoolValue: v.Bool()}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			av.Value = &commonpb.AnyValue_StringValue{StringValue: err.Error()}
+			break
+		}
+		av.Value = &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v.Any())}
+	default:
+		av.Value = &commonpb.AnyValue_StringValue{StringValue: v.String()}
+	}
+	return append(dst, &commonpb.KeyValue{Key: prefix + a.Key, Value: &av})
+}
+
+func stringKeyValue(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
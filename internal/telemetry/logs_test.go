@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+func TestLogHandlerExportsWithTraceContext(t *testing.T) {
+	var sent []*logspb.LogRecord
+	var out bytes.Buffer
+	base := slog.NewJSONHandler(&out, nil)
+	exporter := newLogExporter(func(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+		sent = append(sent, req.ResourceLogs[0].ScopeLogs[0].LogRecords...)
+		return nil
+	}, slog.New(base))
+	logger := slog.New(newLogHandler(base, exporter)).With("component", "proxy")
+
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanCtx)
+	logger.WarnContext(ctx, "Rate limit exceeded", "tenant_id", "acme", "limit", 10.5)
+
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("expected one exported record, got %d", len(sent))
+	}
+	rec := sent[0]
+	if rec.SeverityNumber != logspb.SeverityNumber_SEVERITY_NUMBER_WARN || rec.Body.GetStringValue() != "Rate limit exceeded" {
+		t.Fatalf("unexpected record %v", rec)
+	}
+	traceID, spanID := spanCtx.TraceID(), spanCtx.SpanID()
+	if !bytes.Equal(rec.TraceId, traceID[:]) || !bytes.Equal(rec.SpanId, spanID[:]) {
+		t.Fatalf("expected trace context on the record, got %x/%x", rec.TraceId, rec.SpanId)
+	}
+	attrs := map[string]string{}
+	for _, kv := range rec.Attributes {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	if len(attrs) != 3 || attrs["component"] == "" || attrs["tenant_id"] == "" || attrs["limit"] == "" {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("stdout record: %v", err)
+	}
+	if line["trace_id"] != traceID.String() {
+		t.Fatalf("expected trace_id on the stdout record, got %v", line)
+	}
+
+	// Records logged after shutdown are written but not exported.
+	logger.Info("after shutdown")
+	if len(sent) != 1 {
+		t.Fatalf("expected no export after shutdown, got %d records", len(sent))
+	}
+}
+
+func TestLogHandlerAddsRequestID(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(newLogHandler(slog.NewJSONHandler(&out, nil), nil))
+	logger.InfoContext(WithRequestID(context.Background(), "req-123"), "Request decisions")
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("stdout record: %v", err)
+	}
+	if line["request_id"] != "req-123" {
+		t.Fatalf("expected request_id on the record, got %v", line)
+	}
+}
@@ -20,14 +20,51 @@ var (
 	redisLatencyMs    metric.Float64Histogram
 	redisErrors       metric.Int64Counter
 	estimateLatencyMs metric.Float64Histogram
+	fairQueueWaitMs   metric.Float64Histogram
 	costDeltaUSD      metric.Float64Histogram
 	refundCounter     metric.Int64Counter
+	finishReasons     metric.Int64Counter
+	truncRetries      metric.Int64Counter
+	jsonViolations    metric.Int64Counter
+	jsonRetries       metric.Int64Counter
 	ttftMs            metric.Float64Histogram
 	streamDurationMs  metric.Float64Histogram
 	providerLatencyMs metric.Float64Histogram
 	providerErrors    metric.Int64Counter
+	providerConns     metric.Int64Counter
 	goroutinesGauge   metric.Int64ObservableGauge
 	asyncQueueGauge   metric.Int64ObservableGauge
+	asyncDropped      metric.Int64Counter
+	quotaDrift        metric.Float64Gauge
+	egressBlocked     metric.Int64Counter
+	mirrorRequests    metric.Int64Counter
+	sloBurnRate       metric.Float64Gauge
+	modelDowngrades   metric.Int64Counter
+	deprecatedModels  metric.Int64Counter
+	maxTokensAdjusted metric.Int64Counter
+	invalidRequests   metric.Int64Counter
+	sloAlerts         metric.Int64Counter
+	loadShed          metric.Int64Counter
+	affinityRequests  metric.Int64Counter
+	chaosFaults       metric.Int64Counter
+	probeLatencyMs    metric.Float64Histogram
+	loopBypass        metric.Int64Counter
+	toolCycles        metric.Int64Counter
+	signingRejected   metric.Int64Counter
+	betaDenied        metric.Int64Counter
+	toolsDenied       metric.Int64Counter
+	deadlineExceeded  metric.Int64Counter
+	modelCache        metric.Int64Counter
+	operations        metric.Int64Counter
+	batchJobs         metric.Int64Counter
+	fineTuneJobs      metric.Int64Counter
+	uploads           metric.Int64Counter
+	uploadBytes       metric.Int64Counter
+	deferredRequests  metric.Int64Counter
+	callbacks         metric.Int64Counter
+	mcpToolCalls      metric.Int64Counter
+	requestBytes      metric.Int64Histogram
+	responseBytes     metric.Int64Histogram
 	gaugeOnce         sync.Once
 	gaugeRegErr       error
 )
@@ -54,9 +91,21 @@ func initMeter() {
 		if costDeltaUSD, err = meter.Float64Histogram("ratelimit.cost.delta_usd"); err != nil {
 			slog.Warn("failed to create metric", "name", "ratelimit.cost.delta_usd", "error", err)
 		}
+		if finishReasons, err = meter.Int64Counter("proxy.response.finish_reasons"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.response.finish_reasons", "error", err)
+		}
+		if truncRetries, err = meter.Int64Counter("proxy.truncation_retries"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.truncation_retries", "error", err)
+		}
 		if refundCounter, err = meter.Int64Counter("ratelimit.cost.refunds"); err != nil {
 			slog.Warn("failed to create metric", "name", "ratelimit.cost.refunds", "error", err)
 		}
+		if jsonViolations, err = meter.Int64Counter("proxy.json_guard.violations"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.json_guard.violations", "error", err)
+		}
+		if jsonRetries, err = meter.Int64Counter("proxy.json_guard.retries"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.json_guard.retries", "error", err)
+		}
 		if ttftMs, err = meter.Float64Histogram("proxy.ttft_ms"); err != nil {
 			slog.Warn("failed to create metric", "name", "proxy.ttft_ms", "error", err)
 		}
@@ -69,12 +118,112 @@ func initMeter() {
 		if providerErrors, err = meter.Int64Counter("proxy.provider_http.errors"); err != nil {
 			slog.Warn("failed to create metric", "name", "proxy.provider_http.errors", "error", err)
 		}
+		if providerConns, err = meter.Int64Counter("proxy.provider_http.connections"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.provider_http.connections", "error", err)
+		}
 		if goroutinesGauge, err = meter.Int64ObservableGauge("proxy.runtime.goroutines"); err != nil {
 			slog.Warn("failed to create metric", "name", "proxy.runtime.goroutines", "error", err)
 		}
 		if asyncQueueGauge, err = meter.Int64ObservableGauge("proxy.async.queue_depth"); err != nil {
 			slog.Warn("failed to create metric", "name", "proxy.async.queue_depth", "error", err)
 		}
+		if asyncDropped, err = meter.Int64Counter("proxy.async.dropped"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.async.dropped", "error", err)
+		}
+		if quotaDrift, err = meter.Float64Gauge("ratelimit.quota_sync.drift_ratio"); err != nil {
+			slog.Warn("failed to create metric", "name", "ratelimit.quota_sync.drift_ratio", "error", err)
+		}
+		if egressBlocked, err = meter.Int64Counter("proxy.egress.blocked"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.egress.blocked", "error", err)
+		}
+		if mirrorRequests, err = meter.Int64Counter("proxy.mirror.requests"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.mirror.requests", "error", err)
+		}
+		if modelDowngrades, err = meter.Int64Counter("ratelimit.downgrades"); err != nil {
+			slog.Warn("failed to create metric", "name", "ratelimit.downgrades", "error", err)
+		}
+		if maxTokensAdjusted, err = meter.Int64Counter("proxy.max_tokens.adjusted"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.max_tokens.adjusted", "error", err)
+		}
+		if invalidRequests, err = meter.Int64Counter("proxy.requests.invalid"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.requests.invalid", "error", err)
+		}
+		if sloBurnRate, err = meter.Float64Gauge("proxy.slo.burn_rate"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.slo.burn_rate", "error", err)
+		}
+		if sloAlerts, err = meter.Int64Counter("proxy.slo.alerts"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.slo.alerts", "error", err)
+		}
+		if loadShed, err = meter.Int64Counter("proxy.load_shed.requests"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.load_shed.requests", "error", err)
+		}
+		if loopBypass, err = meter.Int64Counter("proxy.loop_detection.bypass"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.loop_detection.bypass", "error", err)
+		}
+		if signingRejected, err = meter.Int64Counter("proxy.request_signing.rejected"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.request_signing.rejected", "error", err)
+		}
+		if betaDenied, err = meter.Int64Counter("proxy.beta_features.denied"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.beta_features.denied", "error", err)
+		}
+		if toolsDenied, err = meter.Int64Counter("proxy.tools.denied"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.tools.denied", "error", err)
+		}
+		if deadlineExceeded, err = meter.Int64Counter("proxy.deadline.exceeded"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.deadline.exceeded", "error", err)
+		}
+		if modelCache, err = meter.Int64Counter("proxy.model_cache.requests"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.model_cache.requests", "error", err)
+		}
+		if fairQueueWaitMs, err = meter.Float64Histogram("proxy.fair_queue.wait_ms"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.fair_queue.wait_ms", "error", err)
+		}
+		if operations, err = meter.Int64Counter("proxy.operations"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.operations", "error", err)
+		}
+		if batchJobs, err = meter.Int64Counter("proxy.batches.jobs"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.batches.jobs", "error", err)
+		}
+		if fineTuneJobs, err = meter.Int64Counter("proxy.fine_tuning.jobs"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.fine_tuning.jobs", "error", err)
+		}
+		if uploads, err = meter.Int64Counter("proxy.uploads"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.uploads", "error", err)
+		}
+		if uploadBytes, err = meter.Int64Counter("proxy.uploads.bytes", metric.WithUnit("By")); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.uploads.bytes", "error", err)
+		}
+		if deferredRequests, err = meter.Int64Counter("proxy.deferred"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.deferred", "error", err)
+		}
+		if callbacks, err = meter.Int64Counter("proxy.callbacks"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.callbacks", "error", err)
+		}
+		if mcpToolCalls, err = meter.Int64Counter("proxy.mcp.tool_calls"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.mcp.tool_calls", "error", err)
+		}
+		if toolCycles, err = meter.Int64Counter("proxy.loop_detection.tool_cycles"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.loop_detection.tool_cycles", "error", err)
+		}
+		if deprecatedModels, err = meter.Int64Counter("proxy.models.deprecated"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.models.deprecated", "error", err)
+		}
+		if affinityRequests, err = meter.Int64Counter("proxy.affinity.requests"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.affinity.requests", "error", err)
+		}
+		if chaosFaults, err = meter.Int64Counter("proxy.chaos.injected"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.chaos.injected", "error", err)
+		}
+		if probeLatencyMs, err = meter.Float64Histogram("proxy.probe.latency_ms"); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.probe.latency_ms", "error", err)
+		}
+		sizeBuckets := metric.WithExplicitBucketBoundaries(1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20, 16<<20, 64<<20)
+		if requestBytes, err = meter.Int64Histogram("proxy.request.body_bytes", metric.WithUnit("By"), sizeBuckets); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.request.body_bytes", "error", err)
+		}
+		if responseBytes, err = meter.Int64Histogram("proxy.response.body_bytes", metric.WithUnit("By"), sizeBuckets); err != nil {
+			slog.Warn("failed to create metric", "name", "proxy.response.body_bytes", "error", err)
+		}
 	})
 }
 
@@ -122,9 +271,7 @@ func RecordRateLimitRequest(ctx context.Context, result, reason, provider, model
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	rateLimitRequests.Add(ctx, 1, metric.WithAttributes(attrs...))
 }
@@ -143,9 +290,7 @@ func ObserveRedisLatency(ctx context.Context, op, backend, result string, d time
 	if backend != "" {
 		attrs = append(attrs, attribute.String("backend", backend))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	redisLatencyMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
 }
@@ -163,9 +308,7 @@ func IncRedisError(ctx context.Context, op, backend, tenantID string) {
 	if backend != "" {
 		attrs = append(attrs, attribute.String("backend", backend))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	redisErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
 }
@@ -184,15 +327,15 @@ func ObserveEstimateLatency(ctx context.Context, provider, model, tenantID strin
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	estimateLatencyMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
 }
 
 // ObserveCostDelta records the difference between actual and estimated cost.
-func ObserveCostDelta(ctx context.Context, provider, model, tenantID string, delta float64) {
+// estimatedFromContent marks streams whose output was counted from the streamed
+// text because the provider reported no usage.
+func ObserveCostDelta(ctx context.Context, provider, model, tenantID string, delta float64, estimatedFromContent bool) {
 	initMeter()
 	if costDeltaUSD == nil {
 		return
@@ -205,8 +348,9 @@ func ObserveCostDelta(ctx context.Context, provider, model, tenantID string, del
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
+	attrs = appendTenant(attrs, tenantID)
+	if estimatedFromContent {
+		attrs = append(attrs, attribute.Bool("estimated_from_content", true))
 	}
 
 	costDeltaUSD.Record(ctx, delta, metric.WithAttributes(attrs...))
@@ -229,13 +373,449 @@ func IncRefund(ctx context.Context, provider, model, tenantID, reason string) {
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	refundCounter.Add(ctx, 1, metric.WithAttributes(attrs...))
 }
 
+// IncFinishReason counts a response choice by its normalized finish reason
+// (providers.Finish*).
+func IncFinishReason(ctx context.Context, provider, model, tenantID, reason string) {
+	initMeter()
+	if finishReasons == nil {
+		return
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("reason", reason)}
+	if provider != "" {
+		attrs = append(attrs, attribute.String("provider", provider))
+	}
+	if model != "" {
+		attrs = append(attrs, attribute.String("model", model))
+	}
+	attrs = appendTenant(attrs, tenantID)
+
+	finishReasons.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncTruncationRetry counts retries of truncated responses by outcome:
+// retried, denied (rate limited) or rejected (other errors).
+func IncTruncationRetry(ctx context.Context, provider, tenantID, outcome string) {
+	initMeter()
+	if truncRetries == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	truncRetries.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncJSONViolation counts responses that should have been JSON but did not
+// parse (reason "parse") or did not match the requested schema ("schema").
+func IncJSONViolation(ctx context.Context, provider, tenantID, reason string) {
+	initMeter()
+	if jsonViolations == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("reason", reason),
+	}, tenantID)
+	jsonViolations.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncJSONRetry counts requests retried after a JSON violation, by outcome.
+func IncJSONRetry(ctx context.Context, provider, tenantID, outcome string) {
+	initMeter()
+	if jsonRetries == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	jsonRetries.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// AddAsyncDropped counts async operations (cost adjustments, refunds) abandoned
+// before completion, e.g. still pending when the shutdown flush deadline expired.
+func AddAsyncDropped(ctx context.Context, n int64, reason string) {
+	initMeter()
+	if asyncDropped == nil || n <= 0 {
+		return
+	}
+	asyncDropped.Add(ctx, n, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// RecordQuotaDrift records the relative difference between sentinel-tracked and
+// provider-billed spend for the last complete day.
+func RecordQuotaDrift(ctx context.Context, provider string, drift float64) {
+	initMeter()
+	if quotaDrift == nil {
+		return
+	}
+	quotaDrift.Record(ctx, drift, metric.WithAttributes(attribute.String("provider", provider)))
+}
+
+// IncEgressBlocked counts outbound connections refused by the egress guard.
+func IncEgressBlocked(ctx context.Context, reason string) {
+	initMeter()
+	if egressBlocked == nil {
+		return
+	}
+	egressBlocked.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// IncMirrorRequest counts mirrored requests by result (sent, dropped, error) and,
+// when sent, the shadow backend's status code.
+func IncMirrorRequest(ctx context.Context, result string, status int) {
+	initMeter()
+	if mirrorRequests == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("result", result)}
+	if status > 0 {
+		attrs = append(attrs, attribute.Int("http.status_code", status))
+	}
+	mirrorRequests.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncModelDowngrade counts requests switched to a cheaper model by the downgrade policy.
+func IncModelDowngrade(ctx context.Context, provider, from, to, reason string) {
+	initMeter()
+	if modelDowngrades == nil {
+		return
+	}
+	modelDowngrades.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("model", from),
+		attribute.String("downgraded_to", to),
+		attribute.String("reason", reason),
+	))
+}
+
+// IncDeprecatedModel counts requests for a model with an announced retirement
+// date (action=warned|rewritten).
+func IncDeprecatedModel(ctx context.Context, provider, model, tenantID, action string) {
+	initMeter()
+	if deprecatedModels == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("model", model),
+		attribute.String("action", action),
+	}, tenantID)
+	deprecatedModels.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncMaxTokensAdjusted counts requests whose output token cap was injected or
+// clamped by the output token policy.
+func IncMaxTokensAdjusted(ctx context.Context, provider, model, action string) {
+	initMeter()
+	if maxTokensAdjusted == nil {
+		return
+	}
+	maxTokensAdjusted.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("model", model),
+		attribute.String("action", action),
+	))
+}
+
+// IncSigningRejected counts requests rejected for a missing, invalid, expired
+// or replayed signature.
+func IncSigningRejected(ctx context.Context, tenantID, reason string) {
+	initMeter()
+	if signingRejected == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.String("reason", reason)}, tenantID)
+	signingRejected.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncBetaDenied counts requests that asked for beta features the beta policy
+// does not allow, by action (strip or reject).
+func IncBetaDenied(ctx context.Context, tenantID, provider, action string) {
+	initMeter()
+	if betaDenied == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("action", action),
+	}, tenantID)
+	betaDenied.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncToolsDenied counts requests declaring tools the tool policy does not
+// allow, by action (strip or reject).
+func IncToolsDenied(ctx context.Context, tenantID, provider, action string) {
+	initMeter()
+	if toolsDenied == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("action", action),
+	}, tenantID)
+	toolsDenied.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncDeadlineExceeded counts requests whose X-Deadline-Ms budget ran out,
+// before reaching the provider (stage "proxy") or while waiting on it
+// ("upstream").
+func IncDeadlineExceeded(ctx context.Context, tenantID, stage string) {
+	initMeter()
+	if deadlineExceeded == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.String("stage", stage)}, tenantID)
+	deadlineExceeded.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncModelCache counts model list requests by result (hit, miss).
+func IncModelCache(ctx context.Context, provider, tenantID, result string) {
+	initMeter()
+	if modelCache == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("result", result),
+	}, tenantID)
+	modelCache.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// ObserveFairQueueWait records how long a request waited in the fair queue, by
+// tier and outcome (admitted, timeout, canceled).
+func ObserveFairQueueWait(ctx context.Context, tenantID, tier, outcome string, d time.Duration) {
+	initMeter()
+	if fairQueueWaitMs == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("tier", tier),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	fairQueueWaitMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
+}
+
+// IncOperation counts non-POST provider requests by method and outcome
+// (allowed, denied).
+func IncOperation(ctx context.Context, provider, tenantID, method, outcome string) {
+	initMeter()
+	if operations == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("method", method),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	operations.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncBatchJob counts provider batch jobs by outcome (submitted, denied,
+// settled, abandoned).
+func IncBatchJob(ctx context.Context, provider, tenantID, outcome string) {
+	initMeter()
+	if batchJobs == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	batchJobs.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncFineTuneJob counts fine-tuning jobs by outcome (submitted, not_allowed,
+// over_budget, settled, abandoned).
+func IncFineTuneJob(ctx context.Context, provider, tenantID, outcome string) {
+	initMeter()
+	if fineTuneJobs == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	fineTuneJobs.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncDeferred counts low-priority requests deferred by the spend-aware queue
+// and what became of them (queued, queue_full, replayed, requeued, expired).
+func IncDeferred(ctx context.Context, tenantID, outcome, reason string) {
+	initMeter()
+	if deferredRequests == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("outcome", outcome),
+		attribute.String("reason", reason),
+	}, tenantID)
+	deferredRequests.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncCallback counts attempts to deliver results to tenants' callback URLs by
+// outcome (delivered, retry, failed).
+func IncCallback(ctx context.Context, tenantID, event, outcome string) {
+	initMeter()
+	if callbacks == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("event", event),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	callbacks.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncMCPToolCall counts MCP tool calls by server, tool and outcome (allowed,
+// rate_limited, loop).
+func IncMCPToolCall(ctx context.Context, tenantID, server, tool, outcome string) {
+	initMeter()
+	if mcpToolCalls == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("server", server),
+		attribute.String("tool", tool),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	mcpToolCalls.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// RecordUpload counts file uploads by outcome (allowed, too_large,
+// content_type, quota, scan_rejected, scan_error, invalid) and the bytes of
+// the allowed ones.
+func RecordUpload(ctx context.Context, provider, tenantID, outcome string, bytes int64) {
+	initMeter()
+	attrs := appendTenant([]attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("outcome", outcome),
+	}, tenantID)
+	if uploads != nil {
+		uploads.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+	if uploadBytes != nil && outcome == "allowed" && bytes > 0 {
+		uploadBytes.Add(ctx, bytes, metric.WithAttributes(attrs...))
+	}
+}
+
+// IncInvalidRequest counts requests rejected by schema validation.
+func IncInvalidRequest(ctx context.Context, provider, model string) {
+	initMeter()
+	if invalidRequests == nil {
+		return
+	}
+	invalidRequests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("model", model),
+	))
+}
+
+// RecordSLOBurnRate records an SLO indicator's error-budget burn rate over a window.
+func RecordSLOBurnRate(ctx context.Context, slo, indicator, window string, rate float64) {
+	initMeter()
+	if sloBurnRate == nil {
+		return
+	}
+	sloBurnRate.Record(ctx, rate, metric.WithAttributes(
+		attribute.String("slo", slo),
+		attribute.String("indicator", indicator),
+		attribute.String("window", window),
+	))
+}
+
+// IncSLOAlert counts SLO alerts raised or resolved.
+func IncSLOAlert(ctx context.Context, slo, indicator, state string) {
+	initMeter()
+	if sloAlerts == nil {
+		return
+	}
+	sloAlerts.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("slo", slo),
+		attribute.String("indicator", indicator),
+		attribute.String("state", state),
+	))
+}
+
+// IncLoadShed counts a request rejected by load shedding.
+func IncLoadShed(ctx context.Context, priority, reason string) {
+	initMeter()
+	if loadShed == nil {
+		return
+	}
+	loadShed.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("priority", priority),
+		attribute.String("reason", reason),
+	))
+}
+
+// IncLoopBypass counts X-Sentinel-Loop-Bypass requests by whether the tenant
+// was granted the bypass.
+func IncLoopBypass(ctx context.Context, tenantID string, allowed bool) {
+	initMeter()
+	if loopBypass == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.Bool("allowed", allowed)}, tenantID)
+	loopBypass.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncToolCycle counts requests whose latest tool calls complete a repetition
+// cycle of period steps.
+func IncToolCycle(ctx context.Context, tenantID string, period int) {
+	initMeter()
+	if toolCycles == nil {
+		return
+	}
+	attrs := appendTenant([]attribute.KeyValue{attribute.Int("period", period)}, tenantID)
+	toolCycles.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// IncAffinityRequest counts a tenant request by whether this replica owns its
+// session (routed=owner|other).
+func IncAffinityRequest(ctx context.Context, routed string) {
+	initMeter()
+	if affinityRequests == nil {
+		return
+	}
+	affinityRequests.Add(ctx, 1, metric.WithAttributes(attribute.String("routed", routed)))
+}
+
+// IncChaosFault counts a fault injected by chaos mode.
+func IncChaosFault(ctx context.Context, fault string) {
+	initMeter()
+	if chaosFaults == nil {
+		return
+	}
+	chaosFaults.Add(ctx, 1, metric.WithAttributes(attribute.String("fault", fault)))
+}
+
+// ObserveBodySize records the size of a proxied request or response body
+// (direction=request|response).
+func ObserveBodySize(ctx context.Context, direction, provider, model, tenantID string, n int64) {
+	initMeter()
+	histogram := requestBytes
+	if direction == "response" {
+		histogram = responseBytes
+	}
+	if histogram == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("provider", provider)}
+	if model != "" {
+		attrs = append(attrs, attribute.String("model", model))
+	}
+	attrs = appendTenant(attrs, tenantID)
+	histogram.Record(ctx, n, metric.WithAttributes(attrs...))
+}
+
 // ObserveProviderHTTP records provider HTTP latency and errors with status/result attributes.
 func ObserveProviderHTTP(ctx context.Context, provider, model string, status int, result string, d time.Duration) {
 	initMeter()
@@ -260,6 +840,40 @@ func ObserveProviderHTTP(ctx context.Context, provider, model string, status int
 	}
 }
 
+// ObserveProbe records a synthetic provider probe (probe=models|canary) by
+// result and status.
+func ObserveProbe(ctx context.Context, provider, key, probe string, status int, result string, d time.Duration) {
+	initMeter()
+	if probeLatencyMs == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("provider", provider),
+		attribute.String("key", key),
+		attribute.String("probe", probe),
+		attribute.String("result", result),
+	}
+	if status > 0 {
+		attrs = append(attrs, attribute.Int("http.status_code", status))
+	}
+	probeLatencyMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
+}
+
+// IncProviderConn counts a provider request by the protocol it used and
+// whether it reused an existing connection. On HTTP/2 a reused connection
+// means the request was multiplexed rather than opening a new one.
+func IncProviderConn(ctx context.Context, provider, protocol string, reused bool) {
+	initMeter()
+	if providerConns == nil {
+		return
+	}
+	providerConns.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", provider),
+		attribute.String("protocol", protocol),
+		attribute.Bool("reused", reused),
+	))
+}
+
 // ObserveTTFT records time-to-first-token latency for streaming responses.
 func ObserveTTFT(ctx context.Context, provider, model, tenantID string, d time.Duration) {
 	initMeter()
@@ -274,9 +888,7 @@ func ObserveTTFT(ctx context.Context, provider, model, tenantID string, d time.D
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	ttftMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
 }
@@ -295,9 +907,7 @@ func ObserveStreamDuration(ctx context.Context, provider, model, tenantID string
 	if model != "" {
 		attrs = append(attrs, attribute.String("model", model))
 	}
-	if tenantID != "" {
-		attrs = append(attrs, attribute.String("tenant.id", tenantID))
-	}
+	attrs = appendTenant(attrs, tenantID)
 
 	streamDurationMs.Record(ctx, float64(d.Milliseconds()), metric.WithAttributes(attrs...))
 }
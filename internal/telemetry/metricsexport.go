@@ -0,0 +1,244 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"agent-sentinel/internal/async"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// InitMetrics exports the proxy's metrics over OTLP gRPC to
+// OTEL_EXPORTER_OTLP_ENDPOINT (every OTEL_METRIC_EXPORT_INTERVAL ms, default
+// 60000). Histogram samples recorded while a sampled span is active carry that
+// span as an exemplar (OTEL_METRICS_EXEMPLAR_FILTER, default trace_based), so a
+// latency bucket links to the request traces behind it.
+func InitMetrics() func(context.Context) error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		slog.Warn("Failed to create OTLP metric exporter, metrics disabled", "error", err, "endpoint", endpoint)
+		return func(context.Context) error { return nil }
+	}
+	exporter := &metricExporter{client: colmetricpb.NewMetricsServiceClient(conn), conn: conn}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(serviceResource(context.Background())),
+	)
+	otel.SetMeterProvider(mp)
+	slog.Info("OpenTelemetry metrics enabled", "endpoint", endpoint)
+	return mp.Shutdown
+}
+
+// Detach returns a background context carrying the request span from ctx, for
+// metrics recorded after the request has finished (e.g. cost settlement) so
+// their exemplars still point at the llm_proxy_request span. Background work
+// started from it stays in the request's async group.
+func Detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpanContext(context.Background(), RequestSpan(ctx).SpanContext())
+	return async.WithGroup(detached, async.GroupOf(ctx))
+}
+
+// metricExporter sends metric data over OTLP gRPC with the SDK's default
+// (cumulative) temporality and aggregations.
+type metricExporter struct {
+	client colmetricpb.MetricsServiceClient
+	conn   *grpc.ClientConn
+}
+
+func (e *metricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(kind)
+}
+
+func (e *metricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *metricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	_, err := e.client.Export(ctx, toOTLPMetrics(rm))
+	return err
+}
+
+func (e *metricExporter) ForceFlush(context.Context) error { return nil }
+
+func (e *metricExporter) Shutdown(context.Context) error {
+	return e.conn.Close()
+}
+
+func toOTLPMetrics(rm *metricdata.ResourceMetrics) *colmetricpb.ExportMetricsServiceRequest {
+	out := &metricpb.ResourceMetrics{Resource: &resourcepb.Resource{}}
+	if rm.Resource != nil {
+		out.Resource.Attributes = keyValues(rm.Resource.Attributes())
+		out.SchemaUrl = rm.Resource.SchemaURL()
+	}
+	for _, sm := range rm.ScopeMetrics {
+		scope := &metricpb.ScopeMetrics{
+			Scope:     &commonpb.InstrumentationScope{Name: sm.Scope.Name, Version: sm.Scope.Version},
+			SchemaUrl: sm.Scope.SchemaURL,
+		}
+		for _, m := range sm.Metrics {
+			if pm := toOTLPMetric(m); pm != nil {
+				scope.Metrics = append(scope.Metrics, pm)
+			}
+		}
+		out.ScopeMetrics = append(out.ScopeMetrics, scope)
+	}
+	return &colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: []*metricpb.ResourceMetrics{out}}
+}
+
+func toOTLPMetric(m metricdata.Metrics) *metricpb.Metric {
+	pm := &metricpb.Metric{Name: m.Name, Description: m.Description, Unit: m.Unit}
+	switch data := m.Data.(type) {
+	case metricdata.Sum[int64]:
+		pm.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			DataPoints:             numberPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+			IsMonotonic:            data.IsMonotonic,
+		}}
+	case metricdata.Sum[float64]:
+		pm.Data = &metricpb.Metric_Sum{Sum: &metricpb.Sum{
+			DataPoints:             numberPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+			IsMonotonic:            data.IsMonotonic,
+		}}
+	case metricdata.Gauge[int64]:
+		pm.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: numberPoints(data.DataPoints)}}
+	case metricdata.Gauge[float64]:
+		pm.Data = &metricpb.Metric_Gauge{Gauge: &metricpb.Gauge{DataPoints: numberPoints(data.DataPoints)}}
+	case metricdata.Histogram[int64]:
+		pm.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			DataPoints:             histogramPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+		}}
+	case metricdata.Histogram[float64]:
+		pm.Data = &metricpb.Metric_Histogram{Histogram: &metricpb.Histogram{
+			DataPoints:             histogramPoints(data.DataPoints),
+			AggregationTemporality: temporality(data.Temporality),
+		}}
+	default:
+		// The proxy only creates counters, gauges and explicit-bucket histograms.
+		return nil
+	}
+	return pm
+}
+
+func numberPoints[N int64 | float64](points []metricdata.DataPoint[N]) []*metricpb.NumberDataPoint {
+	out := make([]*metricpb.NumberDataPoint, 0, len(points))
+	for _, p := range points {
+		dp := &metricpb.NumberDataPoint{
+			Attributes:        keyValues(p.Attributes.ToSlice()),
+			StartTimeUnixNano: unixNano(p.StartTime.UnixNano()),
+			TimeUnixNano:      unixNano(p.Time.UnixNano()),
+			Exemplars:         exemplars(p.Exemplars),
+		}
+		switch v := any(p.Value).(type) {
+		case int64:
+			dp.Value = &metricpb.NumberDataPoint_AsInt{AsInt: v}
+		case float64:
+			dp.Value = &metricpb.NumberDataPoint_AsDouble{AsDouble: v}
+		}
+		out = append(out, dp)
+	}
+	return out
+}
+
+func histogramPoints[N int64 | float64](points []metricdata.HistogramDataPoint[N]) []*metricpb.HistogramDataPoint {
+	out := make([]*metricpb.HistogramDataPoint, 0, len(points))
+	for _, p := range points {
+		sum := float64(p.Sum)
+		dp := &metricpb.HistogramDataPoint{
+			Attributes:        keyValues(p.Attributes.ToSlice()),
+			StartTimeUnixNano: unixNano(p.StartTime.UnixNano()),
+			TimeUnixNano:      unixNano(p.Time.UnixNano()),
+			Count:             p.Count,
+			Sum:               &sum,
+			BucketCounts:      p.BucketCounts,
+			ExplicitBounds:    p.Bounds,
+			Exemplars:         exemplars(p.Exemplars),
+		}
+		if v, ok := p.Min.Value(); ok {
+			minValue := float64(v)
+			dp.Min = &minValue
+		}
+		if v, ok := p.Max.Value(); ok {
+			maxValue := float64(v)
+			dp.Max = &maxValue
+		}
+		out = append(out, dp)
+	}
+	return out
+}
+
+func exemplars[N int64 | float64](in []metricdata.Exemplar[N]) []*metricpb.Exemplar {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]*metricpb.Exemplar, 0, len(in))
+	for _, e := range in {
+		pe := &metricpb.Exemplar{
+			FilteredAttributes: keyValues(e.FilteredAttributes),
+			TimeUnixNano:       unixNano(e.Time.UnixNano()),
+			SpanId:             e.SpanID,
+			TraceId:            e.TraceID,
+		}
+		switch v := any(e.Value).(type) {
+		case int64:
+			pe.Value = &metricpb.Exemplar_AsInt{AsInt: v}
+		case float64:
+			pe.Value = &metricpb.Exemplar_AsDouble{AsDouble: v}
+		}
+		out = append(out, pe)
+	}
+	return out
+}
+
+func temporality(t metricdata.Temporality) metricpb.AggregationTemporality {
+	if t == metricdata.DeltaTemporality {
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+}
+
+func unixNano(n int64) uint64 {
+	if n < 0 {
+		return 0
+	}
+	return uint64(n)
+}
+
+func keyValues(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		var v commonpb.AnyValue
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			v.Value = &commonpb.AnyValue_BoolValue{BoolValue: kv.Value.AsBool()}
+		case attribute.INT64:
+			v.Value = &commonpb.AnyValue_IntValue{IntValue: kv.Value.AsInt64()}
+		case attribute.FLOAT64:
+			v.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: kv.Value.AsFloat64()}
+		default:
+			v.Value = &commonpb.AnyValue_StringValue{StringValue: kv.Value.Emit()}
+		}
+		out = append(out, &commonpb.KeyValue{Key: string(kv.Key), Value: &v})
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHistogramExemplarsCarryRequestTrace(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+	hist, err := mp.Meter("test").Float64Histogram("ratelimit.cost.delta_usd")
+	if err != nil {
+		t.Fatalf("histogram: %v", err)
+	}
+
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	reqCtx, cancel := context.WithCancel(trace.ContextWithSpanContext(context.Background(), spanCtx))
+	settleCtx := Detach(reqCtx)
+	cancel()
+	if settleCtx.Err() != nil {
+		t.Fatal("detached context should outlive the request")
+	}
+	hist.Record(settleCtx, 0.25)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	req := toOTLPMetrics(&rm)
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 1 || metrics[0].Name != "ratelimit.cost.delta_usd" {
+		t.Fatalf("unexpected metrics %v", metrics)
+	}
+	points := metrics[0].GetHistogram().DataPoints
+	if len(points) != 1 || points[0].Count != 1 || points[0].GetSum() != 0.25 {
+		t.Fatalf("unexpected data points %v", points)
+	}
+	exemplars := points[0].Exemplars
+	if len(exemplars) != 1 {
+		t.Fatalf("expected one exemplar, got %d", len(exemplars))
+	}
+	traceID, spanID := spanCtx.TraceID(), spanCtx.SpanID()
+	if !bytes.Equal(exemplars[0].TraceId, traceID[:]) || !bytes.Equal(exemplars[0].SpanId, spanID[:]) {
+		t.Fatalf("expected exemplar to link the request span, got %x/%x", exemplars[0].TraceId, exemplars[0].SpanId)
+	}
+	if exemplars[0].GetAsDouble() != 0.25 {
+		t.Fatalf("unexpected exemplar value %v", exemplars[0].GetAsDouble())
+	}
+}
+
+func TestHistogramWithoutSpanHasNoExemplar(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+	hist, _ := mp.Meter("test").Float64Histogram("proxy.ttft_ms")
+	hist.Record(Detach(context.Background()), 120)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	points := toOTLPMetrics(&rm).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetHistogram().DataPoints
+	if len(points[0].Exemplars) != 0 {
+		t.Fatalf("expected no exemplars outside a trace, got %v", points[0].Exemplars)
+	}
+}
@@ -0,0 +1,52 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTenantLabelerModes(t *testing.T) {
+	if got := newTenantLabeler("", nil, 0).label("acme"); got != "acme" {
+		t.Fatalf("default mode should label every tenant, got %q", got)
+	}
+	if got := newTenantLabeler(tenantModeNone, []string{"acme"}, 5).label("acme"); got != "" {
+		t.Fatalf("none mode should drop the tenant dimension, got %q", got)
+	}
+	allowOnly := newTenantLabeler(tenantModeTop, []string{"acme"}, 0)
+	if got := allowOnly.label("acme"); got != "acme" {
+		t.Fatalf("allowlisted tenant should keep its label, got %q", got)
+	}
+	if got := allowOnly.label("globex"); got != otherTenant {
+		t.Fatalf("other tenants should share the %q bucket, got %q", otherTenant, got)
+	}
+}
+
+func TestTenantLabelerTopN(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newTenantLabeler(tenantModeTop, nil, 2)
+	l.now = func() time.Time { return now }
+
+	for tenant, n := range map[string]int{"a": 5, "b": 3, "c": 1} {
+		for range n {
+			if got := l.label(tenant); got != otherTenant {
+				t.Fatalf("no tenant should be labeled before the first window ends, got %q", got)
+			}
+		}
+	}
+
+	now = now.Add(tenantTopWindow)
+	for tenant, want := range map[string]string{"a": "a", "b": "b", "c": otherTenant} {
+		if got := l.label(tenant); got != want {
+			t.Fatalf("label(%q) = %q, want %q", tenant, got, want)
+		}
+	}
+
+	// A quiet window drops tenants that stopped sending traffic.
+	for range 4 {
+		l.label("c")
+	}
+	now = now.Add(tenantTopWindow)
+	if got := l.label("c"); got != "c" {
+		t.Fatalf("busiest tenant of the last window should be labeled, got %q", got)
+	}
+}
@@ -58,20 +58,9 @@ func InitTracing() func(context.Context) error {
 		return func(context.Context) error { return nil }
 	}
 
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceName("agent-sentinel"),
-			semconv.ServiceVersion("1.0.0"),
-		),
-	)
-	if err != nil {
-		slog.Warn("Failed to create resource", "error", err)
-		res = resource.Default()
-	}
-
 	tp := sdktrace.NewTracerProvider(
 		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
+		sdktrace.WithResource(serviceResource(ctx)),
 	)
 
 	otel.SetTracerProvider(tp)
@@ -111,11 +100,16 @@ func Middleware(provider providers.Provider, next http.Handler) http.Handler {
 		if tenantID := r.Header.Get("X-Tenant-ID"); tenantID != "" {
 			span.SetAttributes(attribute.String("tenant.id", tenantID))
 		}
+		if id := RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
 		if provider != nil {
 			if model := provider.ExtractModelFromPath(r.URL.Path); model != "" {
 				span.SetAttributes(attribute.String("llm.model", model))
 			}
 		}
+		span.SetAttributes(genAIAttributes(provider, r.URL.Path)...)
+		ctx = context.WithValue(ctx, requestSpanKey{}, span)
 
 		// Wrap response writer to capture status.
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
@@ -137,3 +131,18 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// serviceResource identifies the proxy in exported traces and metrics.
+func serviceResource(ctx context.Context) *resource.Resource {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("agent-sentinel"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		slog.Warn("Failed to create resource", "error", err)
+		return resource.Default()
+	}
+	return res
+}
@@ -0,0 +1,298 @@
+// Package toolpolicy restricts the tools agents may declare in requests, e.g.
+// forbidding code execution or web browsing, since tool access is where an
+// agent's actions reach beyond the conversation.
+//
+// Tools are identified by their function name, or for provider built-ins by
+// their type: OpenAI "web_search_preview", "code_interpreter", "file_search";
+// Anthropic "web_search_20250305", "code_execution_20250522",
+// "computer_20250124"; Gemini "codeExecution", "googleSearch", "urlContext".
+package toolpolicy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// Actions.
+const (
+	// ActionStrip removes tools that are not allowed and forwards the request.
+	ActionStrip = "strip"
+	// ActionReject refuses requests declaring a tool that is not allowed.
+	ActionReject = "reject"
+)
+
+// Policy decides which tools tenants may declare. Entries match a tool
+// exactly, or by prefix with a trailing "*" (e.g. "code_execution*"); "*"
+// alone matches every tool. Block wins over Allow and Tenants.
+type Policy struct {
+	// Allow lists the tools every tenant may declare.
+	Allow []string `json:"allow,omitempty"`
+	// Block lists tools no tenant may declare.
+	Block []string `json:"block,omitempty"`
+	// Tenants lists tools allowed for specific tenants on top of Allow.
+	Tenants map[string][]string `json:"tenants,omitempty"`
+	// Action is ActionStrip (the default) or ActionReject.
+	Action string `json:"action,omitempty"`
+}
+
+// Validate checks a tool policy.
+func (p Policy) Validate() error {
+	switch p.Action {
+	case "", ActionStrip, ActionReject:
+	default:
+		return fmt.Errorf("tools action %q (want strip or reject)", p.Action)
+	}
+	lists := [][]string{p.Allow, p.Block}
+	for _, tools := range p.Tenants {
+		lists = append(lists, tools)
+	}
+	for _, tools := range lists {
+		if slices.Contains(tools, "") {
+			return errors.New("tools entries must not be empty")
+		}
+	}
+	return nil
+}
+
+// Allows reports whether tenantID may declare tool.
+func (p Policy) Allows(tenantID, tool string) bool {
+	if match(p.Block, tool) {
+		return false
+	}
+	return match(p.Allow, tool) || match(p.Tenants[tenantID], tool)
+}
+
+// Rejects reports whether requests with a denied tool are refused rather than
+// stripped.
+func (p Policy) Rejects() bool {
+	return p.Action == ActionReject
+}
+
+func match(patterns []string, tool string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(tool, prefix) {
+				return true
+			}
+		} else if pattern == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// policyFromEnv reads TOOLS_ALLOW and TOOLS_BLOCK (comma-separated) and
+// TOOLS_ACTION. It returns nil when neither list is set.
+func policyFromEnv() *Policy {
+	p := &Policy{
+		Allow:  splitList(os.Getenv("TOOLS_ALLOW")),
+		Block:  splitList(os.Getenv("TOOLS_BLOCK")),
+		Action: strings.ToLower(os.Getenv("TOOLS_ACTION")),
+	}
+	if len(p.Allow) == 0 && len(p.Block) == 0 {
+		return nil
+	}
+	if p.Validate() != nil {
+		p.Action = ActionStrip
+	}
+	return p
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Guard holds the active tool policy. Safe for concurrent use.
+type Guard struct {
+	active override.Value[Policy]
+}
+
+// NewGuard returns a guard using the TOOLS_* variables.
+func NewGuard() *Guard {
+	return &Guard{active: override.New(policyFromEnv())}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *Guard) Set(policy *Policy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy, or nil when tools are unrestricted.
+func (g *Guard) Policy() *Policy {
+	if g == nil {
+		return nil
+	}
+	return g.active.Active()
+}
+
+// Filter returns the tools declared in data, a parsed request body for
+// provider, that tenantID may not use. When strip is true they are removed
+// from data, along with a tool choice that named one of them and tool settings
+// left without tools; changed reports whether data was modified.
+func (p Policy) Filter(provider, tenantID string, data map[string]any, strip bool) (denied []string, changed bool) {
+	allowed := func(tool string) bool {
+		if p.Allows(tenantID, tool) {
+			return true
+		}
+		denied = append(denied, tool)
+		return false
+	}
+
+	if tools, ok := data["tools"].([]any); ok {
+		var kept []any
+		modified := false
+		for _, tool := range tools {
+			entry, ok := tool.(map[string]any)
+			if !ok {
+				kept = append(kept, tool)
+				continue
+			}
+			if provider == "gemini" {
+				keep, stripped := geminiTool(entry, allowed, strip)
+				if keep {
+					kept = append(kept, entry)
+				}
+				modified = modified || stripped
+				continue
+			}
+			if allowed(toolID(provider, entry)) {
+				kept = append(kept, entry)
+			}
+		}
+		if strip && (modified || len(kept) != len(tools)) {
+			changed = true
+			if len(kept) == 0 {
+				delete(data, "tools")
+			} else {
+				data["tools"] = kept
+			}
+		}
+	}
+
+	// Legacy OpenAI function calling.
+	if functions, ok := data["functions"].([]any); ok {
+		var kept []any
+		for _, fn := range functions {
+			entry, _ := fn.(map[string]any)
+			if name, _ := entry["name"].(string); entry == nil || allowed(name) {
+				kept = append(kept, fn)
+			}
+		}
+		if strip && len(kept) != len(functions) {
+			changed = true
+			if len(kept) == 0 {
+				delete(data, "functions")
+			} else {
+				data["functions"] = kept
+			}
+		}
+	}
+
+	if strip && changed {
+		clearToolChoice(data, denied)
+	}
+	return denied, changed
+}
+
+// toolID names an OpenAI or Anthropic tool declaration.
+func toolID(provider string, entry map[string]any) string {
+	toolType, _ := entry["type"].(string)
+	name, _ := entry["name"].(string)
+	if provider == "anthropic" {
+		if toolType != "" && toolType != "custom" {
+			return toolType
+		}
+		return name
+	}
+	if toolType != "function" && toolType != "" {
+		return toolType
+	}
+	// Chat Completions nests the function; the Responses API does not.
+	if fn, ok := entry["function"].(map[string]any); ok {
+		name, _ = fn["name"].(string)
+	}
+	return name
+}
+
+// geminiTool checks a Gemini tool object, which holds function declarations
+// and/or built-in tools keyed by name. It reports whether anything is left in
+// it and whether anything was stripped.
+func geminiTool(entry map[string]any, allowed func(string) bool, strip bool) (keep, stripped bool) {
+	for key, value := range entry {
+		if key != "functionDeclarations" && key != "function_declarations" {
+			if !allowed(key) && strip {
+				delete(entry, key)
+				stripped = true
+			}
+			continue
+		}
+		declarations, _ := value.([]any)
+		var kept []any
+		for _, declaration := range declarations {
+			fn, _ := declaration.(map[string]any)
+			if name, _ := fn["name"].(string); fn == nil || allowed(name) {
+				kept = append(kept, declaration)
+			}
+		}
+		if !strip || len(kept) == len(declarations) {
+			continue
+		}
+		stripped = true
+		if len(kept) == 0 {
+			delete(entry, key)
+		} else {
+			entry[key] = kept
+		}
+	}
+	return len(entry) > 0, stripped
+}
+
+// clearToolChoice removes a tool choice that forces a removed tool, and tool
+// settings the provider rejects without tools.
+func clearToolChoice(data map[string]any, removed []string) {
+	_, hasTools := data["tools"]
+	_, hasFunctions := data["functions"]
+	if !hasTools {
+		delete(data, "tool_choice")
+		delete(data, "tool_config")
+		delete(data, "toolConfig")
+		delete(data, "parallel_tool_calls")
+	} else if choice, ok := data["tool_choice"].(map[string]any); ok && slices.Contains(removed, choiceName(choice)) {
+		delete(data, "tool_choice")
+	}
+	if !hasFunctions {
+		delete(data, "function_call")
+	} else if choice, ok := data["function_call"].(map[string]any); ok && slices.Contains(removed, choiceName(choice)) {
+		delete(data, "function_call")
+	}
+}
+
+// choiceName returns the tool a tool choice forces, e.g. OpenAI's
+// {"type": "function", "function": {"name": ...}} or Anthropic's
+// {"type": "tool", "name": ...}.
+func choiceName(choice map[string]any) string {
+	if fn, ok := choice["function"].(map[string]any); ok {
+		name, _ := fn["name"].(string)
+		return name
+	}
+	if name, ok := choice["name"].(string); ok {
+		return name
+	}
+	toolType, _ := choice["type"].(string)
+	return toolType
+}
@@ -0,0 +1,66 @@
+package toolpolicy
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func parse(t *testing.T, body string) map[string]any {
+	t.Helper()
+	var data map[string]any
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFilterStripsPerProvider(t *testing.T) {
+	policy := Policy{
+		Allow:   []string{"*"},
+		Block:   []string{"code_execution*", "code_interpreter", "codeExecution", "web_search*", "googleSearch"},
+		Tenants: map[string][]string{"acme": {"web_search_preview"}},
+	}
+
+	openai := parse(t, `{"tools": [
+		{"type": "function", "function": {"name": "lookup"}},
+		{"type": "code_interpreter", "container": {"type": "auto"}}
+	], "tool_choice": {"type": "code_interpreter"}}`)
+	denied, changed := policy.Filter("openai", "acme", openai, true)
+	if !changed || !slices.Equal(denied, []string{"code_interpreter"}) || len(openai["tools"].([]any)) != 1 {
+		t.Fatalf("unexpected OpenAI result %v %v", denied, openai)
+	}
+	if _, ok := openai["tool_choice"]; ok {
+		t.Fatal("expected a tool choice forcing a stripped tool to be removed")
+	}
+
+	anthropic := parse(t, `{"tools": [
+		{"type": "code_execution_20250522", "name": "code_execution"}
+	], "tool_choice": {"type": "any"}}`)
+	if denied, _ := policy.Filter("anthropic", "acme", anthropic, true); len(denied) != 1 || anthropic["tools"] != nil || anthropic["tool_choice"] != nil {
+		t.Fatalf("expected the only tool and the tool choice removed, got %v %v", denied, anthropic)
+	}
+
+	gemini := parse(t, `{"tools": [{"functionDeclarations": [{"name": "lookup"}]}, {"googleSearch": {}}, {"codeExecution": {}}]}`)
+	denied, _ = policy.Filter("gemini", "acme", gemini, true)
+	if len(denied) != 2 || len(gemini["tools"].([]any)) != 1 {
+		t.Fatalf("unexpected Gemini result %v %v", denied, gemini)
+	}
+}
+
+func TestFilterTenantAllowlistAndReject(t *testing.T) {
+	policy := Policy{Tenants: map[string][]string{"acme": {"lookup"}}, Action: ActionReject}
+	body := `{"tools": [{"type": "function", "name": "lookup"}, {"type": "function", "name": "shell"}]}`
+
+	data := parse(t, body)
+	denied, changed := policy.Filter("openai", "acme", data, !policy.Rejects())
+	if changed || !slices.Equal(denied, []string{"shell"}) || len(data["tools"].([]any)) != 2 {
+		t.Fatalf("expected shell denied without modifying the body, got %v", denied)
+	}
+	if denied, _ := policy.Filter("openai", "other", parse(t, body), false); len(denied) != 2 {
+		t.Fatalf("expected every tool denied for a tenant without an allowlist, got %v", denied)
+	}
+	if denied, _ := policy.Filter("openai", "other", parse(t, `{"messages": []}`), false); len(denied) != 0 {
+		t.Fatal("expected requests without tools to pass")
+	}
+}
@@ -0,0 +1,314 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// geminiFileTTL is how long Gemini keeps an uploaded file before deleting it.
+const geminiFileTTL = 48 * time.Hour
+
+// Kind is the kind of an upload-related request.
+type Kind int
+
+const (
+	// None is any other request.
+	None Kind = iota
+	// Create uploads a whole file in one request.
+	Create
+	// Start declares a file whose content is sent in later requests (OpenAI's
+	// Uploads API, Gemini resumable uploads).
+	Start
+	// Part sends a chunk of a started upload.
+	Part
+	// Complete finishes a started upload, creating the file.
+	Complete
+	// Delete deletes a file.
+	Delete
+)
+
+// Request is what an upload-related request says about the file.
+type Request struct {
+	Kind        Kind
+	Filename    string
+	ContentType string
+	// Bytes is the file's size, declared or counted; -1 when unknown.
+	Bytes int64
+	// Data is the content sent in this request: the whole file for Create, a
+	// chunk for Part, nil otherwise.
+	Data []byte
+	// FileID is the file a Delete targets.
+	FileID string
+}
+
+// Classify returns the kind of a request to provider.
+func Classify(provider, method, urlPath string, header http.Header) Kind {
+	urlPath = strings.TrimSuffix(urlPath, "/")
+	switch provider {
+	case "openai", "anthropic":
+		switch {
+		case method == http.MethodPost && urlPath == "/v1/files":
+			return Create
+		case method == http.MethodDelete && strings.HasPrefix(urlPath, "/v1/files/"):
+			return Delete
+		case provider != "openai" || method != http.MethodPost:
+			return None
+		case urlPath == "/v1/uploads":
+			return Start
+		case strings.HasPrefix(urlPath, "/v1/uploads/") && strings.HasSuffix(urlPath, "/parts"):
+			return Part
+		case strings.HasPrefix(urlPath, "/v1/uploads/") && strings.HasSuffix(urlPath, "/complete"):
+			return Complete
+		}
+	case "gemini":
+		switch {
+		case method == http.MethodDelete && strings.HasPrefix(urlPath, "/v1beta/files/"):
+			return Delete
+		case (method != http.MethodPost && method != http.MethodPut) || !strings.HasSuffix(urlPath, "/files") || !strings.HasPrefix(urlPath, "/upload/"):
+			return None
+		}
+		command := strings.ToLower(header.Get("X-Goog-Upload-Command"))
+		switch {
+		case strings.Contains(command, "start"):
+			return Start
+		case strings.Contains(command, "upload"):
+			return Part
+		case command == "":
+			return Create
+		}
+	}
+	return None
+}
+
+// Parse reads the file's metadata, and content when it is sent, from a
+// request of kind to provider.
+func Parse(provider string, kind Kind, urlPath string, header http.Header, body []byte) (Request, error) {
+	req := Request{Kind: kind, Bytes: -1}
+	switch kind {
+	case Create:
+		if provider == "gemini" {
+			return parseGeminiMedia(req, header, body)
+		}
+		return parseFormFile(req, header, body, "file")
+	case Part:
+		if provider == "gemini" {
+			req.Data = body
+			req.Bytes = int64(len(body))
+			return req, nil
+		}
+		return parseFormFile(req, header, body, "data")
+	case Start:
+		if provider == "gemini" {
+			req.ContentType = header.Get("X-Goog-Upload-Header-Content-Type")
+			if n, err := strconv.ParseInt(header.Get("X-Goog-Upload-Header-Content-Length"), 10, 64); err == nil {
+				req.Bytes = n
+			}
+			req.Filename, _ = displayName(body)
+			return req, nil
+		}
+		var upload struct {
+			Bytes    *int64 `json:"bytes"`
+			Filename string `json:"filename"`
+			MimeType string `json:"mime_type"`
+		}
+		if err := json.Unmarshal(body, &upload); err != nil {
+			return req, err
+		}
+		req.Filename, req.ContentType = upload.Filename, upload.MimeType
+		if upload.Bytes != nil {
+			req.Bytes = *upload.Bytes
+		}
+		return req, nil
+	case Delete:
+		id := strings.TrimPrefix(strings.TrimSuffix(urlPath, "/"), "/v1/files/")
+		if provider == "gemini" {
+			id = strings.TrimPrefix(strings.TrimSuffix(urlPath, "/"), "/v1beta/")
+		}
+		req.FileID, _ = url.PathUnescape(id)
+		return req, nil
+	}
+	return req, nil
+}
+
+// parseFormFile reads the file in field of a multipart/form-data body.
+func parseFormFile(req Request, header http.Header, body []byte, field string) (Request, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return req, errors.New("upload is not multipart/form-data")
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return req, errors.New("upload has no " + field + " field")
+		}
+		if part.FormName() != field {
+			continue
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return req, err
+		}
+		req.Filename = part.FileName()
+		req.ContentType = contentType(part.Header.Get("Content-Type"), req.Filename)
+		req.Data = data
+		req.Bytes = int64(len(data))
+		return req, nil
+	}
+}
+
+// parseGeminiMedia reads a Gemini multipart (metadata then media) or raw
+// media upload.
+func parseGeminiMedia(req Request, header http.Header, body []byte) (Request, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		req.ContentType = header.Get("Content-Type")
+		req.Data = body
+		req.Bytes = int64(len(body))
+		return req, nil
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return req, errors.New("multipart upload has no media part")
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return req, err
+		}
+		partType := part.Header.Get("Content-Type")
+		if strings.HasPrefix(partType, "application/json") && req.Data == nil && req.Filename == "" {
+			if name, ok := displayName(data); ok {
+				req.Filename = name
+				continue
+			}
+		}
+		req.ContentType = contentType(partType, req.Filename)
+		req.Data = data
+		req.Bytes = int64(len(data))
+		return req, nil
+	}
+}
+
+// displayName reads the file name from Gemini upload metadata, which takes
+// either field name. It reports false when data is not metadata.
+func displayName(data []byte) (string, bool) {
+	var meta struct {
+		File struct {
+			DisplayName string `json:"displayName"`
+			Snake       string `json:"display_name"`
+		} `json:"file"`
+	}
+	if json.Unmarshal(data, &meta) != nil {
+		return "", false
+	}
+	if meta.File.DisplayName != "" {
+		return meta.File.DisplayName, true
+	}
+	return meta.File.Snake, true
+}
+
+// contentType returns declared, or the type of filename's extension when
+// the client declared none or a generic one.
+func contentType(declared, filename string) string {
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case "":
+	case ".jsonl":
+		return "application/jsonl"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+	}
+	if declared == "" {
+		return "application/octet-stream"
+	}
+	return declared
+}
+
+// Created is the file a successful upload created.
+type Created struct {
+	ID    string
+	Bytes int64
+	// Expires is when the provider deletes the file on its own; zero when it
+	// keeps it.
+	Expires time.Time
+}
+
+// ParseCreated reads the file created by a successful request of kind from
+// the provider's response. For a Gemini resumable start, which creates the
+// file only once its last chunk is sent, the upload ID stands in for the file
+// and req's declared size is used.
+func ParseCreated(provider string, kind Kind, req Request, header http.Header, body []byte, now time.Time) (Created, bool) {
+	if provider == "gemini" {
+		created := Created{Expires: now.Add(geminiFileTTL)}
+		if kind == Start {
+			u, err := url.Parse(header.Get("X-Goog-Upload-URL"))
+			if err != nil || u.Query().Get("upload_id") == "" || req.Bytes < 0 {
+				return Created{}, false
+			}
+			created.ID, created.Bytes = "upload:"+u.Query().Get("upload_id"), req.Bytes
+			return created, true
+		}
+		var resp struct {
+			File struct {
+				Name      string `json:"name"`
+				SizeBytes string `json:"sizeBytes"`
+			} `json:"file"`
+		}
+		if kind != Create || json.Unmarshal(body, &resp) != nil || resp.File.Name == "" {
+			return Created{}, false
+		}
+		created.ID = resp.File.Name
+		created.Bytes, _ = strconv.ParseInt(resp.File.SizeBytes, 10, 64)
+		if created.Bytes == 0 {
+			created.Bytes = req.Bytes
+		}
+		return created, true
+	}
+
+	type file struct {
+		ID        string `json:"id"`
+		Bytes     int64  `json:"bytes"`
+		SizeBytes int64  `json:"size_bytes"`
+	}
+	var f file
+	switch kind {
+	case Create:
+		if json.Unmarshal(body, &f) != nil {
+			return Created{}, false
+		}
+	case Complete:
+		var upload struct {
+			File *file `json:"file"`
+		}
+		if json.Unmarshal(body, &upload) != nil || upload.File == nil {
+			return Created{}, false
+		}
+		f = *upload.File
+	default:
+		return Created{}, false
+	}
+	if f.ID == "" {
+		return Created{}, false
+	}
+	created := Created{ID: f.ID, Bytes: max(f.Bytes, f.SizeBytes)}
+	if created.Bytes == 0 && req.Bytes > 0 {
+		created.Bytes = req.Bytes
+	}
+	return created, true
+}
@@ -0,0 +1,74 @@
+package uploads
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"agent-sentinel/internal/ratelimit"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// filesKey is a hash of a tenant's uploaded files, keyed by file ID.
+func filesKey(tenantID string) string {
+	return "uploads:" + tenantID
+}
+
+// RedisStore is a Store in Redis, shared by every proxy instance.
+type RedisStore struct {
+	client *ratelimit.RedisClient
+}
+
+// NewRedisStore returns a store using client.
+func NewRedisStore(client *ratelimit.RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// TrackUpload records an uploaded file.
+func (s *RedisStore) TrackUpload(ctx context.Context, file Upload) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return s.client.Client().HSet(ctx, s.client.Key(filesKey(file.TenantID)), file.ID, data).Err()
+}
+
+// Uploads returns the tenant's live uploaded files. Expired entries are
+// removed as they are found; entries that fail to decode are skipped.
+func (s *RedisStore) Uploads(ctx context.Context, tenantID string) ([]Upload, error) {
+	key := s.client.Key(filesKey(tenantID))
+	entries, err := s.client.Client().HGetAll(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	now := time.Now()
+	files := make([]Upload, 0, len(entries))
+	var expired []string
+	for id, data := range entries {
+		var file Upload
+		if err := json.Unmarshal([]byte(data), &file); err != nil {
+			slog.Warn("Skipping unreadable uploaded file", "error", err, "file_id", id, "tenant_id", tenantID)
+			continue
+		}
+		if !file.Live(now) {
+			expired = append(expired, id)
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(expired) > 0 {
+		if err := s.client.Client().HDel(ctx, key, expired...).Err(); err != nil {
+			slog.Warn("Failed to remove expired uploads", "error", err, "tenant_id", tenantID)
+		}
+	}
+	return files, nil
+}
+
+// RemoveUpload stops counting a deleted file. It reports whether the file was
+// tracked.
+func (s *RedisStore) RemoveUpload(ctx context.Context, tenantID, id string) (bool, error) {
+	n, err := s.client.Client().HDel(ctx, s.client.Key(filesKey(tenantID)), id).Result()
+	return n > 0, err
+}
@@ -0,0 +1,96 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultScanTimeout bounds one call to the scanning hook.
+const defaultScanTimeout = 10 * time.Second
+
+// File is an upload's content as passed to a Scanner.
+type File struct {
+	TenantID    string
+	Provider    string
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Verdict is a scanner's decision on a file.
+type Verdict struct {
+	Allowed bool `json:"allowed"`
+	// Reason explains a rejection, e.g. "malware: Eicar-Test-Signature" or
+	// "pii: credit card number"; it is returned to the client.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Scanner inspects an upload's content before it is forwarded, e.g. for
+// malware or personal data.
+type Scanner interface {
+	Scan(ctx context.Context, file File) (Verdict, error)
+}
+
+// HTTPScanner posts each file to a scanning service. The file is the request
+// body, with its content type and the X-Tenant-ID, X-Upload-Provider and
+// X-Upload-Filename headers; the service answers 200 with a Verdict.
+type HTTPScanner struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPScanner returns a scanner calling url.
+func NewHTTPScanner(url string, timeout time.Duration) *HTTPScanner {
+	return &HTTPScanner{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// ScannerFromEnv returns an HTTPScanner for UPLOAD_SCAN_URL, with
+// UPLOAD_SCAN_TIMEOUT_MS (default 10000), or nil when unset.
+func ScannerFromEnv() Scanner {
+	url := os.Getenv("UPLOAD_SCAN_URL")
+	if url == "" {
+		return nil
+	}
+	timeout := defaultScanTimeout
+	if v, err := strconv.Atoi(os.Getenv("UPLOAD_SCAN_TIMEOUT_MS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Millisecond
+	}
+	return NewHTTPScanner(url, timeout)
+}
+
+// Scan sends file to the scanning service.
+func (s *HTTPScanner) Scan(ctx context.Context, file File) (Verdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(file.Data))
+	if err != nil {
+		return Verdict{}, err
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Tenant-ID", file.TenantID)
+	req.Header.Set("X-Upload-Provider", file.Provider)
+	req.Header.Set("X-Upload-Filename", file.Filename)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Verdict{}, fmt.Errorf("scanner returned HTTP %d: %s", resp.StatusCode, body)
+	}
+	var verdict Verdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return Verdict{}, fmt.Errorf("decode scanner verdict: %w", err)
+	}
+	return verdict, nil
+}
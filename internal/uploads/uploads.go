@@ -0,0 +1,245 @@
+// Package uploads governs files uploaded to providers through the proxy
+// (OpenAI and Anthropic /v1/files, OpenAI's Uploads API, Gemini media
+// uploads). Uploads carry no tokens, so spend limits never see them, yet a
+// file can hold anything an agent found on disk. Uploads are checked against
+// size, content-type and per-tenant storage limits, optionally passed to a
+// scanning hook, and recorded in the audit log before they are forwarded.
+package uploads
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/override"
+)
+
+// Policy limits what tenants may upload.
+type Policy struct {
+	// MaxBytes caps the size of one file; 0 means no cap.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// ContentTypes allowlists media types, e.g. "application/jsonl" or
+	// "image/*". Empty allows any type.
+	ContentTypes []string `json:"content_types,omitempty"`
+	// QuotaBytes caps the total size of a tenant's live uploaded files; 0
+	// means no quota.
+	QuotaBytes int64 `json:"quota_bytes,omitempty"`
+	// TenantQuotas overrides QuotaBytes per tenant; 0 removes the quota.
+	TenantQuotas map[string]int64 `json:"tenant_quotas,omitempty"`
+}
+
+// Validate checks an uploads policy.
+func (p Policy) Validate() error {
+	if p.MaxBytes < 0 || p.QuotaBytes < 0 {
+		return fmt.Errorf("uploads max_bytes and quota_bytes must not be negative")
+	}
+	for tenant, q := range p.TenantQuotas {
+		if q < 0 {
+			return fmt.Errorf("uploads quota for %q must not be negative", tenant)
+		}
+	}
+	for _, t := range p.ContentTypes {
+		if _, _, ok := strings.Cut(t, "/"); !ok {
+			return fmt.Errorf("uploads content type %q (want type/subtype or type/*)", t)
+		}
+	}
+	return nil
+}
+
+// AllowsType reports whether a file of contentType may be uploaded. Parameters
+// such as charset are ignored.
+func (p Policy) AllowsType(contentType string) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range p.ContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Quota returns the tenant's storage quota in bytes, 0 when it has none.
+func (p Policy) Quota(tenantID string) int64 {
+	if q, ok := p.TenantQuotas[tenantID]; ok {
+		return q
+	}
+	return p.QuotaBytes
+}
+
+// policyFromEnv reads UPLOAD_MAX_BYTES, UPLOAD_CONTENT_TYPES (comma-separated)
+// and UPLOAD_QUOTA_BYTES.
+func policyFromEnv() Policy {
+	var p Policy
+	if v, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		p.MaxBytes = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("UPLOAD_QUOTA_BYTES"), 10, 64); err == nil && v > 0 {
+		p.QuotaBytes = v
+	}
+	for _, t := range strings.Split(os.Getenv("UPLOAD_CONTENT_TYPES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			p.ContentTypes = append(p.ContentTypes, t)
+		}
+	}
+	return p
+}
+
+// Upload is a file a tenant uploaded to the provider through the proxy,
+// counted against the tenant's storage quota until it is deleted or expires.
+type Upload struct {
+	ID          string    `json:"id"`
+	Provider    string    `json:"provider"`
+	TenantID    string    `json:"tenant_id"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	Uploaded    time.Time `json:"uploaded"`
+	// Expires is when the provider deletes the file on its own (Gemini keeps
+	// files for 48 hours); zero when it keeps it until deleted.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Live reports whether the file still counts against the quota at now.
+func (u Upload) Live(now time.Time) bool {
+	return u.Expires.IsZero() || now.Before(u.Expires)
+}
+
+// Store keeps the files each tenant has uploaded. RedisStore shares them
+// between proxy instances; MemoryStore serves the other limiter backends.
+type Store interface {
+	TrackUpload(ctx context.Context, file Upload) error
+	// Uploads returns the tenant's files that have not expired.
+	Uploads(ctx context.Context, tenantID string) ([]Upload, error)
+	RemoveUpload(ctx context.Context, tenantID, id string) (bool, error)
+}
+
+// MemoryStore is a Store for a single proxy instance; files are forgotten on
+// restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	files map[string]map[string]Upload
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{files: make(map[string]map[string]Upload)}
+}
+
+// TrackUpload records file.
+func (s *MemoryStore) TrackUpload(ctx context.Context, file Upload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files[file.TenantID] == nil {
+		s.files[file.TenantID] = make(map[string]Upload)
+	}
+	s.files[file.TenantID][file.ID] = file
+	return nil
+}
+
+// Uploads returns the tenant's live files.
+func (s *MemoryStore) Uploads(ctx context.Context, tenantID string) ([]Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var files []Upload
+	for id, file := range s.files[tenantID] {
+		if !file.Live(now) {
+			delete(s.files[tenantID], id)
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// RemoveUpload forgets the tenant's file with id.
+func (s *MemoryStore) RemoveUpload(ctx context.Context, tenantID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.files[tenantID][id]
+	delete(s.files[tenantID], id)
+	return ok, nil
+}
+
+// Governor holds the active uploads policy, the store counting tenants'
+// storage and the optional scanner. Safe for concurrent use.
+type Governor struct {
+	store    Store
+	scanner  Scanner
+	failOpen bool
+	active   override.Value[Policy]
+}
+
+// New returns a governor using the UPLOAD_* variables. scanner may be nil.
+func New(store Store, scanner Scanner) *Governor {
+	failOpen, _ := strconv.ParseBool(os.Getenv("UPLOAD_SCAN_FAIL_OPEN"))
+	env := policyFromEnv()
+	return &Governor{store: store, scanner: scanner, failOpen: failOpen, active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *Governor) Set(policy *Policy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (g *Governor) Policy() Policy {
+	if g == nil {
+		return Policy{}
+	}
+	return g.active.Get()
+}
+
+// Scanner returns the scanning hook, or nil when uploads are not scanned.
+func (g *Governor) Scanner() Scanner {
+	return g.scanner
+}
+
+// ScanFailOpen reports whether uploads are forwarded when they cannot be
+// scanned (UPLOAD_SCAN_FAIL_OPEN); by default they are rejected.
+func (g *Governor) ScanFailOpen() bool {
+	return g.failOpen
+}
+
+// Usage returns the total size of the tenant's live uploaded files.
+func (g *Governor) Usage(ctx context.Context, tenantID string) (int64, error) {
+	files, err := g.store.Uploads(ctx, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Bytes
+	}
+	return total, nil
+}
+
+// Track counts an uploaded file against its tenant's quota.
+func (g *Governor) Track(ctx context.Context, file Upload) error {
+	return g.store.TrackUpload(ctx, file)
+}
+
+// Remove stops counting a deleted file.
+func (g *Governor) Remove(ctx context.Context, tenantID, id string) (bool, error) {
+	return g.store.RemoveUpload(ctx, tenantID, id)
+}
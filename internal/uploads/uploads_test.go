@@ -0,0 +1,198 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+// formUpload returns a multipart/form-data body with a purpose field and the
+// file in field.
+func formUpload(t *testing.T, field, filename, contentType, content string) (http.Header, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("purpose", "batch")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="`+field+`"; filename="`+filename+`"`)
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	part, _ := mw.CreatePart(h)
+	_, _ = part.Write([]byte(content))
+	_ = mw.Close()
+	header := http.Header{}
+	header.Set("Content-Type", mw.FormDataContentType())
+	return header, buf.Bytes()
+}
+
+func TestPolicyAllowsType(t *testing.T) {
+	p := Policy{ContentTypes: []string{"application/jsonl", "image/*"}}
+	for contentType, want := range map[string]bool{
+		"application/jsonl":                true,
+		"image/png":                        true,
+		"application/jsonl; charset=utf-8": true,
+		"application/pdf":                  false,
+		"":                                 false,
+	} {
+		if got := p.AllowsType(contentType); got != want {
+			t.Errorf("AllowsType(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+	if !(Policy{}).AllowsType("application/x-anything") {
+		t.Error("expected any type allowed without an allowlist")
+	}
+	if err := (Policy{ContentTypes: []string{"pdf"}}).Validate(); err == nil {
+		t.Error("expected a content type without a subtype to be rejected")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		provider, method, path, command string
+		want                            Kind
+	}{
+		{"openai", http.MethodPost, "/v1/files", "", Create},
+		{"anthropic", http.MethodPost, "/v1/files", "", Create},
+		{"openai", http.MethodDelete, "/v1/files/file-abc", "", Delete},
+		{"openai", http.MethodGet, "/v1/files/file-abc", "", None},
+		{"openai", http.MethodPost, "/v1/uploads", "", Start},
+		{"openai", http.MethodPost, "/v1/uploads/upload_1/parts", "", Part},
+		{"openai", http.MethodPost, "/v1/uploads/upload_1/complete", "", Complete},
+		{"anthropic", http.MethodPost, "/v1/uploads", "", None},
+		{"gemini", http.MethodPost, "/upload/v1beta/files", "start", Start},
+		{"gemini", http.MethodPost, "/upload/v1beta/files", "upload, finalize", Part},
+		{"gemini", http.MethodPost, "/upload/v1beta/files", "", Create},
+		{"gemini", http.MethodDelete, "/v1beta/files/abc", "", Delete},
+		{"gemini", http.MethodPost, "/v1beta/models/gemini-2.5-flash:generateContent", "", None},
+	}
+	for _, tc := range cases {
+		header := http.Header{}
+		if tc.command != "" {
+			header.Set("X-Goog-Upload-Command", tc.command)
+		}
+		if got := Classify(tc.provider, tc.method, tc.path, header); got != tc.want {
+			t.Errorf("Classify(%s %s %s) = %v, want %v", tc.provider, tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	header, body := formUpload(t, "file", "batch.jsonl", "application/octet-stream", `{"custom_id":"1"}`)
+	req, err := Parse("openai", Create, "/v1/files", header, body)
+	if err != nil || req.Filename != "batch.jsonl" || req.ContentType != "application/jsonl" || req.Bytes != 17 || string(req.Data) != `{"custom_id":"1"}` {
+		t.Fatalf("Parse = %+v, %v", req, err)
+	}
+	if _, err := Parse("openai", Create, "/v1/files", http.Header{"Content-Type": {"application/json"}}, []byte(`{}`)); err == nil {
+		t.Fatal("expected an error for a non-multipart upload")
+	}
+
+	start, err := Parse("openai", Start, "/v1/uploads", http.Header{}, []byte(`{"bytes":2147483648,"filename":"train.jsonl","mime_type":"text/jsonl","purpose":"fine-tune"}`))
+	if err != nil || start.Bytes != 2147483648 || start.ContentType != "text/jsonl" || start.Data != nil {
+		t.Fatalf("Parse = %+v, %v", start, err)
+	}
+
+	gemini := http.Header{}
+	gemini.Set("X-Goog-Upload-Header-Content-Length", "1048576")
+	gemini.Set("X-Goog-Upload-Header-Content-Type", "video/mp4")
+	resumable, err := Parse("gemini", Start, "/upload/v1beta/files", gemini, []byte(`{"file":{"display_name":"clip.mp4"}}`))
+	if err != nil || resumable.Bytes != 1048576 || resumable.ContentType != "video/mp4" || resumable.Filename != "clip.mp4" {
+		t.Fatalf("Parse = %+v, %v", resumable, err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	meta, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	_, _ = meta.Write([]byte(`{"file":{"displayName":"notes.pdf"}}`))
+	media, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/pdf"}})
+	_, _ = media.Write([]byte("%PDF-1.7"))
+	_ = mw.Close()
+	related := http.Header{"Content-Type": {"multipart/related; boundary=" + mw.Boundary()}}
+	multi, err := Parse("gemini", Create, "/upload/v1beta/files", related, buf.Bytes())
+	if err != nil || multi.Filename != "notes.pdf" || multi.ContentType != "application/pdf" || string(multi.Data) != "%PDF-1.7" {
+		t.Fatalf("Parse = %+v, %v", multi, err)
+	}
+
+	del, _ := Parse("gemini", Delete, "/v1beta/files/abc-123", http.Header{}, nil)
+	if del.FileID != "files/abc-123" {
+		t.Fatalf("FileID = %q, want files/abc-123", del.FileID)
+	}
+}
+
+func TestParseCreated(t *testing.T) {
+	now := time.Now()
+	created, ok := ParseCreated("openai", Create, Request{Bytes: 10}, http.Header{}, []byte(`{"id":"file-abc","object":"file","bytes":120}`), now)
+	if !ok || created.ID != "file-abc" || created.Bytes != 120 || !created.Expires.IsZero() {
+		t.Fatalf("ParseCreated = %+v, %v", created, ok)
+	}
+	created, ok = ParseCreated("anthropic", Create, Request{}, http.Header{}, []byte(`{"id":"file_011","size_bytes":64}`), now)
+	if !ok || created.Bytes != 64 {
+		t.Fatalf("ParseCreated = %+v, %v", created, ok)
+	}
+	created, ok = ParseCreated("openai", Complete, Request{}, http.Header{}, []byte(`{"id":"upload_1","status":"completed","file":{"id":"file-xyz","bytes":2048}}`), now)
+	if !ok || created.ID != "file-xyz" || created.Bytes != 2048 {
+		t.Fatalf("ParseCreated = %+v, %v", created, ok)
+	}
+	header := http.Header{"X-Goog-Upload-Url": {"https://generativelanguage.googleapis.com/upload/v1beta/files?upload_id=u1&upload_protocol=resumable"}}
+	created, ok = ParseCreated("gemini", Start, Request{Bytes: 500}, header, nil, now)
+	if !ok || created.ID != "upload:u1" || created.Bytes != 500 || !created.Expires.Equal(now.Add(geminiFileTTL)) {
+		t.Fatalf("ParseCreated = %+v, %v", created, ok)
+	}
+	if _, ok := ParseCreated("openai", Create, Request{}, http.Header{}, []byte(`{"error":{}}`), now); ok {
+		t.Fatal("expected no file from an error body")
+	}
+}
+
+func TestHTTPScanner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Tenant-ID") != "acme" || r.Header.Get("Content-Type") != "text/plain" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Verdict{Allowed: !bytes.Contains(data, []byte("4111 1111 1111 1111")), Reason: "pii: card number"})
+	}))
+	defer srv.Close()
+	scanner := NewHTTPScanner(srv.URL, time.Second)
+	ctx := context.Background()
+
+	verdict, err := scanner.Scan(ctx, File{TenantID: "acme", ContentType: "text/plain", Data: []byte("card 4111 1111 1111 1111")})
+	if err != nil || verdict.Allowed || verdict.Reason != "pii: card number" {
+		t.Fatalf("Scan = %+v, %v; want a rejection", verdict, err)
+	}
+	if verdict, err := scanner.Scan(ctx, File{TenantID: "acme", ContentType: "text/plain", Data: []byte("hello")}); err != nil || !verdict.Allowed {
+		t.Fatalf("Scan = %+v, %v; want the file allowed", verdict, err)
+	}
+	if _, err := scanner.Scan(ctx, File{TenantID: "other", ContentType: "text/plain"}); err == nil {
+		t.Fatal("expected an error when the scanner fails")
+	}
+}
+
+func TestGovernorUsage(t *testing.T) {
+	store := NewMemoryStore()
+	g := New(store, nil)
+	ctx := context.Background()
+	_ = g.Track(ctx, fileOf("t1", "a", 100, time.Time{}))
+	_ = g.Track(ctx, fileOf("t1", "b", 50, time.Now().Add(-time.Minute)))
+	_ = g.Track(ctx, fileOf("t2", "c", 70, time.Time{}))
+	if used, _ := g.Usage(ctx, "t1"); used != 100 {
+		t.Fatalf("usage = %d, want only the live file counted", used)
+	}
+	if removed, _ := g.Remove(ctx, "t1", "a"); !removed {
+		t.Fatal("expected the file removed")
+	}
+	if used, _ := g.Usage(ctx, "t1"); used != 0 {
+		t.Fatalf("usage = %d, want 0 after the delete", used)
+	}
+}
+
+func fileOf(tenantID, id string, size int64, expires time.Time) Upload {
+	return Upload{ID: id, TenantID: tenantID, Bytes: size, Uploaded: time.Now(), Expires: expires}
+}
@@ -0,0 +1,184 @@
+// Package upstream tracks rate limits reported by the provider so the proxy can
+// back off before sending more traffic to an API key that is already limited.
+package upstream
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits are the provider-reported limits from one response. Remaining counts are
+// -1 when the provider did not report them.
+type Limits struct {
+	RemainingRequests int
+	RemainingTokens   int
+	// ResetRequests/ResetTokens are how long until the respective budget refills.
+	ResetRequests time.Duration
+	ResetTokens   time.Duration
+	// RetryAfter is the provider's explicit back-off request (Retry-After).
+	RetryAfter time.Duration
+}
+
+// ParseHeaders reads OpenAI (x-ratelimit-*), Anthropic (anthropic-ratelimit-*)
+// and Retry-After headers.
+func ParseHeaders(h http.Header, now time.Time) Limits {
+	l := Limits{RemainingRequests: -1, RemainingTokens: -1}
+
+	// OpenAI: counts plus Go-style reset durations ("1s", "6m0s", "20ms").
+	l.RemainingRequests = parseCount(h.Get("x-ratelimit-remaining-requests"), l.RemainingRequests)
+	l.RemainingTokens = parseCount(h.Get("x-ratelimit-remaining-tokens"), l.RemainingTokens)
+	l.ResetRequests = parseDuration(h.Get("x-ratelimit-reset-requests"))
+	l.ResetTokens = parseDuration(h.Get("x-ratelimit-reset-tokens"))
+
+	// Anthropic: counts plus RFC 3339 reset timestamps.
+	l.RemainingRequests = parseCount(h.Get("anthropic-ratelimit-requests-remaining"), l.RemainingRequests)
+	l.RemainingTokens = parseCount(h.Get("anthropic-ratelimit-tokens-remaining"), l.RemainingTokens)
+	if d := parseResetTime(h.Get("anthropic-ratelimit-requests-reset"), now); d > 0 {
+		l.ResetRequests = d
+	}
+	if d := parseResetTime(h.Get("anthropic-ratelimit-tokens-reset"), now); d > 0 {
+		l.ResetTokens = d
+	}
+
+	if ms, err := strconv.Atoi(h.Get("retry-after-ms")); err == nil && ms > 0 {
+		l.RetryAfter = time.Duration(ms) * time.Millisecond
+	} else {
+		l.RetryAfter = parseRetryAfter(h.Get("Retry-After"), now)
+	}
+	return l
+}
+
+func parseCount(v string, fallback int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+func parseDuration(v string) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+func parseResetTime(v string, now time.Time) time.Duration {
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(v)); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// parseRetryAfter accepts delay-seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+const (
+	defaultMaxBackoff = 60 * time.Second
+	// defaultLimitedBackoff applies to a 429 that carries no reset hint.
+	defaultLimitedBackoff = time.Second
+)
+
+// Backoff is a circuit breaker for the provider API key: it opens when the
+// provider reports the key is limited (429, or a remaining count of zero) and
+// stays open until the reported reset. Safe for concurrent use.
+type Backoff struct {
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	openUntil time.Time
+	last      Limits
+}
+
+// NewBackoff returns a breaker that never stays open longer than maxBackoff
+// (defaults to 60s when <= 0).
+func NewBackoff(maxBackoff time.Duration) *Backoff {
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	return &Backoff{maxBackoff: maxBackoff, now: time.Now, last: Limits{RemainingRequests: -1, RemainingTokens: -1}}
+}
+
+// NewBackoffFromEnv builds a breaker from PROVIDER_BACKOFF_ENABLED (default true)
+// and PROVIDER_BACKOFF_MAX_SECONDS. Returns nil when disabled.
+func NewBackoffFromEnv() *Backoff {
+	if v := os.Getenv("PROVIDER_BACKOFF_ENABLED"); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil && !enabled {
+			return nil
+		}
+	}
+	maxBackoff := defaultMaxBackoff
+	if v := os.Getenv("PROVIDER_BACKOFF_MAX_SECONDS"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			maxBackoff = time.Duration(secs) * time.Second
+		}
+	}
+	return NewBackoff(maxBackoff)
+}
+
+// Observe records the limits from a provider response and opens the breaker
+// when the key is exhausted. Returns the parsed limits.
+func (b *Backoff) Observe(status int, h http.Header) Limits {
+	now := b.now()
+	l := ParseHeaders(h, now)
+
+	var wait time.Duration
+	switch {
+	case status == http.StatusTooManyRequests:
+		wait = max(l.RetryAfter, defaultLimitedBackoff)
+		if l.RetryAfter == 0 {
+			wait = max(wait, l.ResetRequests, l.ResetTokens)
+		}
+	case l.RetryAfter > 0 && status == http.StatusServiceUnavailable:
+		wait = l.RetryAfter
+	case l.RemainingRequests == 0:
+		wait = l.ResetRequests
+	case l.RemainingTokens == 0:
+		wait = l.ResetTokens
+	}
+	wait = min(wait, b.maxBackoff)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = l
+	if wait > 0 {
+		if until := now.Add(wait); until.After(b.openUntil) {
+			b.openUntil = until
+		}
+	}
+	return l
+}
+
+// Allow reports whether a request may be sent now; when not, it returns how long
+// until the provider limit is expected to reset.
+func (b *Backoff) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait := b.openUntil.Sub(b.now()); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// Last returns the limits reported by the most recent provider response.
+func (b *Backoff) Last() Limits {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
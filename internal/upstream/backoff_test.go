@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseHeadersOpenAI(t *testing.T) {
+	h := http.Header{}
+	h.Set("x-ratelimit-remaining-requests", "0")
+	h.Set("x-ratelimit-remaining-tokens", "1500")
+	h.Set("x-ratelimit-reset-requests", "6m0s")
+	h.Set("x-ratelimit-reset-tokens", "20ms")
+
+	l := ParseHeaders(h, time.Now())
+	if l.RemainingRequests != 0 || l.RemainingTokens != 1500 {
+		t.Fatalf("unexpected remaining %+v", l)
+	}
+	if l.ResetRequests != 6*time.Minute || l.ResetTokens != 20*time.Millisecond {
+		t.Fatalf("unexpected resets %+v", l)
+	}
+}
+
+func TestParseHeadersAnthropicAndRetryAfterDate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-remaining", "12")
+	h.Set("anthropic-ratelimit-tokens-reset", now.Add(30*time.Second).Format(time.RFC3339))
+	h.Set("Retry-After", now.Add(5*time.Second).Format(http.TimeFormat))
+
+	l := ParseHeaders(h, now)
+	if l.RemainingRequests != 12 || l.RemainingTokens != -1 {
+		t.Fatalf("unexpected remaining %+v", l)
+	}
+	if l.ResetTokens != 30*time.Second || l.RetryAfter != 5*time.Second {
+		t.Fatalf("unexpected timings %+v", l)
+	}
+}
+
+func TestBackoffOpensOn429UntilRetryAfter(t *testing.T) {
+	now := time.Now()
+	b := NewBackoff(time.Minute)
+	b.now = func() time.Time { return now }
+
+	h := http.Header{}
+	h.Set("Retry-After", "10")
+	b.Observe(http.StatusTooManyRequests, h)
+	if ok, wait := b.Allow(); ok || wait != 10*time.Second {
+		t.Fatalf("expected 10s backoff, got ok=%v wait=%v", ok, wait)
+	}
+
+	now = now.Add(11 * time.Second)
+	if ok, _ := b.Allow(); !ok {
+		t.Fatalf("expected breaker to close after retry-after")
+	}
+}
+
+func TestBackoffOpensWhenRemainingIsZeroAndCaps(t *testing.T) {
+	now := time.Now()
+	b := NewBackoff(30 * time.Second)
+	b.now = func() time.Time { return now }
+
+	h := http.Header{}
+	h.Set("x-ratelimit-remaining-requests", "5")
+	h.Set("x-ratelimit-reset-requests", "1s")
+	b.Observe(http.StatusOK, h)
+	if ok, _ := b.Allow(); !ok {
+		t.Fatalf("expected requests allowed while budget remains")
+	}
+
+	h.Set("x-ratelimit-remaining-requests", "0")
+	h.Set("x-ratelimit-reset-requests", "6m0s")
+	b.Observe(http.StatusOK, h)
+	if ok, wait := b.Allow(); ok || wait != 30*time.Second {
+		t.Fatalf("expected backoff capped at 30s, got ok=%v wait=%v", ok, wait)
+	}
+}
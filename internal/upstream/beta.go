@@ -0,0 +1,176 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// BetaHeaders opt requests into provider beta features. Each carries a
+// comma-separated list of features, e.g. "anthropic-beta:
+// computer-use-2025-01-24,prompt-caching-2024-07-31" or "OpenAI-Beta:
+// assistants=v2".
+var BetaHeaders = []string{"Anthropic-Beta", "Openai-Beta"}
+
+// Beta policy actions.
+const (
+	// BetaActionStrip removes features that are not allowed and forwards the rest.
+	BetaActionStrip = "strip"
+	// BetaActionReject refuses requests asking for a feature that is not allowed.
+	BetaActionReject = "reject"
+)
+
+// BetaPolicy decides which beta features tenants may opt into. Entries match
+// a feature exactly, or by prefix with a trailing "*" (e.g. "computer-use-*");
+// "*" alone matches every feature. Block wins over Allow and Tenants. Without
+// a policy every beta header passes through.
+type BetaPolicy struct {
+	// Allow lists the features every tenant may use.
+	Allow []string `json:"allow,omitempty"`
+	// Block lists features no tenant may use, e.g. ones not yet risk-assessed.
+	Block []string `json:"block,omitempty"`
+	// Tenants lists features allowed for specific tenants on top of Allow.
+	Tenants map[string][]string `json:"tenants,omitempty"`
+	// Action is BetaActionStrip (the default) or BetaActionReject.
+	Action string `json:"action,omitempty"`
+}
+
+// Validate checks a beta policy.
+func (p BetaPolicy) Validate() error {
+	switch p.Action {
+	case "", BetaActionStrip, BetaActionReject:
+	default:
+		return fmt.Errorf("beta_features action %q (want strip or reject)", p.Action)
+	}
+	lists := [][]string{p.Allow, p.Block}
+	for _, features := range p.Tenants {
+		lists = append(lists, features)
+	}
+	for _, features := range lists {
+		if slices.Contains(features, "") {
+			return errors.New("beta_features entries must not be empty")
+		}
+	}
+	return nil
+}
+
+// Allows reports whether tenantID may use feature.
+func (p BetaPolicy) Allows(tenantID, feature string) bool {
+	if matchFeature(p.Block, feature) {
+		return false
+	}
+	return matchFeature(p.Allow, feature) || matchFeature(p.Tenants[tenantID], feature)
+}
+
+func matchFeature(patterns []string, feature string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(feature, prefix) {
+				return true
+			}
+		} else if pattern == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// betaPolicyFromEnv reads BETA_FEATURES_ALLOW and BETA_FEATURES_BLOCK
+// (comma-separated) and BETA_FEATURES_ACTION. It returns nil when neither list
+// is set.
+func betaPolicyFromEnv() *BetaPolicy {
+	p := &BetaPolicy{
+		Allow:  splitList(os.Getenv("BETA_FEATURES_ALLOW")),
+		Block:  splitList(os.Getenv("BETA_FEATURES_BLOCK")),
+		Action: strings.ToLower(os.Getenv("BETA_FEATURES_ACTION")),
+	}
+	if len(p.Allow) == 0 && len(p.Block) == 0 {
+		return nil
+	}
+	if p.Validate() != nil {
+		p.Action = BetaActionStrip
+	}
+	return p
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// BetaGuard holds the active beta policy. Safe for concurrent use.
+type BetaGuard struct {
+	active override.Value[BetaPolicy]
+}
+
+// NewBetaGuard returns a guard using the BETA_FEATURES_* variables.
+func NewBetaGuard() *BetaGuard {
+	return &BetaGuard{active: override.New(betaPolicyFromEnv())}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (g *BetaGuard) Set(policy *BetaPolicy) {
+	if g == nil {
+		return
+	}
+	g.active.Set(policy)
+}
+
+func (g *BetaGuard) policy() *BetaPolicy {
+	return g.active.Active()
+}
+
+// Filter checks the beta features requested in header by tenantID and returns
+// those that are not allowed. With the strip action they are removed from
+// header, dropping a header left empty; with reject, reject is true and header
+// is left unchanged.
+func (g *BetaGuard) Filter(tenantID string, header http.Header) (denied []string, reject bool) {
+	if g == nil {
+		return nil, false
+	}
+	policy := g.policy()
+	if policy == nil {
+		return nil, false
+	}
+	reject = policy.Action == BetaActionReject
+
+	for _, name := range BetaHeaders {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		var kept []string
+		for _, value := range values {
+			for _, feature := range strings.Split(value, ",") {
+				if feature = strings.TrimSpace(feature); feature == "" {
+					continue
+				}
+				if policy.Allows(tenantID, feature) {
+					kept = append(kept, feature)
+				} else {
+					denied = append(denied, feature)
+				}
+			}
+		}
+		if reject {
+			continue
+		}
+		if len(kept) == 0 {
+			header.Del(name)
+		} else {
+			header.Set(name, strings.Join(kept, ","))
+		}
+	}
+	return denied, reject && len(denied) > 0
+}
@@ -0,0 +1,54 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBetaGuardStripsUnallowedFeatures(t *testing.T) {
+	g := NewBetaGuard()
+	g.Set(&BetaPolicy{
+		Allow:   []string{"prompt-caching-*", "assistants=v2"},
+		Block:   []string{"computer-use-*"},
+		Tenants: map[string][]string{"acme": {"files-api-2025-04-14", "computer-use-2025-01-24"}},
+	})
+
+	header := http.Header{}
+	header.Set("anthropic-beta", "prompt-caching-2024-07-31, computer-use-2025-01-24,files-api-2025-04-14")
+	denied, reject := g.Filter("acme", header)
+	if reject || len(denied) != 1 || denied[0] != "computer-use-2025-01-24" {
+		t.Fatalf("expected only the blocked feature denied, got %v (reject %v)", denied, reject)
+	}
+	if got := header.Get("anthropic-beta"); got != "prompt-caching-2024-07-31,files-api-2025-04-14" {
+		t.Fatalf("unexpected forwarded features %q", got)
+	}
+
+	header = http.Header{}
+	header.Set("anthropic-beta", "files-api-2025-04-14")
+	header.Set("OpenAI-Beta", "assistants=v2")
+	if denied, _ := g.Filter("beta", header); len(denied) != 1 || header.Get("anthropic-beta") != "" || header.Get("OpenAI-Beta") != "assistants=v2" {
+		t.Fatalf("expected the tenant-only feature stripped for other tenants, got %v %v", denied, header)
+	}
+}
+
+func TestBetaGuardRejectLeavesHeaders(t *testing.T) {
+	g := NewBetaGuard()
+	g.Set(&BetaPolicy{Allow: []string{"*"}, Block: []string{"computer-use-*"}, Action: BetaActionReject})
+
+	header := http.Header{}
+	header.Set("anthropic-beta", "computer-use-2025-01-24,token-efficient-tools-2025-02-19")
+	if denied, reject := g.Filter("acme", header); !reject || len(denied) != 1 {
+		t.Fatalf("expected a rejection, got %v (reject %v)", denied, reject)
+	}
+	if header.Get("anthropic-beta") == "" {
+		t.Fatal("expected headers unchanged on reject")
+	}
+
+	g.Set(nil)
+	if denied, _ := g.Filter("acme", header); len(denied) != 0 {
+		t.Fatal("expected passthrough without a policy")
+	}
+	if err := (BetaPolicy{Action: "drop"}).Validate(); err == nil {
+		t.Fatal("expected an unknown action to be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+package upstream
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// protectedHeaders carry credentials or framing and cannot be configured.
+var protectedHeaders = []string{
+	"Authorization",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Cookie",
+	"Host",
+	"Content-Length",
+	"Content-Type",
+	"Content-Encoding",
+	"Transfer-Encoding",
+	"Connection",
+}
+
+// HeaderPolicy adds headers to upstream requests, e.g. OpenAI-Organization,
+// OpenAI-Project or Helicone-User-Id, so provider-side attribution matches
+// sentinel tenants. Values are text/templates rendered with HeaderData.
+// Provider headers extend Default, and tenant headers extend both; an empty
+// value removes a header set by a less specific level.
+type HeaderPolicy struct {
+	Default   map[string]string            `json:"default,omitempty"`
+	Providers map[string]map[string]string `json:"providers,omitempty"`
+	Tenants   map[string]map[string]string `json:"tenants,omitempty"`
+}
+
+// HeaderData is what header templates can reference.
+type HeaderData struct {
+	Tenant string
+	// Session is the X-Sentinel-Session header, if any.
+	Session  string
+	Provider string
+}
+
+// Validate checks header names and that every value template parses.
+func (p HeaderPolicy) Validate() error {
+	levels := map[string]map[string]string{"default": p.Default}
+	for name, headers := range p.Providers {
+		levels["provider "+name] = headers
+	}
+	for name, headers := range p.Tenants {
+		levels["tenant "+name] = headers
+	}
+	for level, headers := range levels {
+		for name, value := range headers {
+			if _, err := parseHeader(level, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseHeader(level, name, value string) (*template.Template, error) {
+	canonical := http.CanonicalHeaderKey(name)
+	if name == "" || strings.ContainsAny(name, " :\r\n") {
+		return nil, fmt.Errorf("upstream_headers %s: invalid header name %q", level, name)
+	}
+	if slices.Contains(protectedHeaders, canonical) {
+		return nil, fmt.Errorf("upstream_headers %s: %s cannot be set", level, canonical)
+	}
+	tmpl, err := template.New(canonical).Option("missingkey=zero").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("upstream_headers %s: %w", level, err)
+	}
+	return tmpl, nil
+}
+
+type parsedHeaders map[string]*template.Template
+
+func parseHeaders(level string, headers map[string]string) parsedHeaders {
+	parsed := make(parsedHeaders, len(headers))
+	for name, value := range headers {
+		if tmpl, err := parseHeader(level, name, value); err == nil {
+			parsed[http.CanonicalHeaderKey(name)] = tmpl
+		}
+	}
+	return parsed
+}
+
+type parsedHeaderPolicy struct {
+	fallback  parsedHeaders
+	providers map[string]parsedHeaders
+	tenants   map[string]parsedHeaders
+}
+
+// Headers applies the active header policy. Safe for concurrent use.
+type Headers struct {
+	mu     sync.RWMutex
+	policy *parsedHeaderPolicy
+}
+
+// NewHeaders returns Headers with no policy.
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+// Set replaces the policy; nil removes it. The policy must have passed Validate.
+func (h *Headers) Set(policy *HeaderPolicy) {
+	if h == nil {
+		return
+	}
+	var parsed *parsedHeaderPolicy
+	if policy != nil {
+		parsed = &parsedHeaderPolicy{
+			fallback:  parseHeaders("default", policy.Default),
+			providers: map[string]parsedHeaders{},
+			tenants:   map[string]parsedHeaders{},
+		}
+		for name, headers := range policy.Providers {
+			parsed.providers[name] = parseHeaders("provider "+name, headers)
+		}
+		for name, headers := range policy.Tenants {
+			parsed.tenants[name] = parseHeaders("tenant "+name, headers)
+		}
+	}
+	h.mu.Lock()
+	h.policy = parsed
+	h.mu.Unlock()
+}
+
+// Apply sets the headers configured for data.Tenant and data.Provider on
+// header, replacing any the client sent. A template that fails to execute
+// skips its header.
+func (h *Headers) Apply(header http.Header, data HeaderData) {
+	if h == nil {
+		return
+	}
+	h.mu.RLock()
+	policy := h.policy
+	h.mu.RUnlock()
+	if policy == nil {
+		return
+	}
+
+	merged := parsedHeaders{}
+	for _, level := range []parsedHeaders{policy.fallback, policy.providers[data.Provider], policy.tenants[data.Tenant]} {
+		for name, tmpl := range level {
+			merged[name] = tmpl
+		}
+	}
+	for name, tmpl := range merged {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			slog.Warn("Failed to render upstream header", "header", name, "error", err)
+			continue
+		}
+		// Header values cannot span lines; drop anything that would.
+		rendered := value.String()
+		switch {
+		case strings.ContainsAny(rendered, "\r\n"):
+			slog.Warn("Skipping upstream header with a line break", "header", name, "tenant_id", data.Tenant)
+		case rendered == "":
+			header.Del(name)
+		default:
+			header.Set(name, rendered)
+		}
+	}
+}
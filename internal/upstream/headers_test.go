@@ -0,0 +1,60 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeadersMergeLevelsAndRenderTemplates(t *testing.T) {
+	policy := &HeaderPolicy{
+		Default:   map[string]string{"Helicone-User-Id": "{{.Tenant}}", "X-Project": "shared"},
+		Providers: map[string]map[string]string{"openai": {"openai-organization": "org-default"}},
+		Tenants: map[string]map[string]string{"acme": {
+			"OpenAI-Organization": "org-acme",
+			"X-Project":           "",
+			"Helicone-Session-Id": "{{.Tenant}}/{{.Session}}",
+		}},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHeaders()
+	h.Set(policy)
+
+	header := http.Header{}
+	header.Set("X-Project", "from-client")
+	h.Apply(header, HeaderData{Tenant: "acme", Session: "run-7", Provider: "openai"})
+	if header.Get("OpenAI-Organization") != "org-acme" || header.Get("Helicone-User-Id") != "acme" || header.Get("Helicone-Session-Id") != "acme/run-7" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+	if _, ok := header["X-Project"]; ok {
+		t.Fatal("expected an empty tenant value to remove the header")
+	}
+
+	header = http.Header{}
+	h.Apply(header, HeaderData{Tenant: "beta", Provider: "anthropic"})
+	if header.Get("X-Project") != "shared" || header.Get("OpenAI-Organization") != "" {
+		t.Fatalf("expected only default headers for another provider, got %v", header)
+	}
+
+	h.Apply(header, HeaderData{Tenant: "evil\r\nX-Injected: 1", Provider: "anthropic"})
+	if header.Get("Helicone-User-Id") != "beta" {
+		t.Fatalf("expected a value with a line break to be skipped, got %v", header)
+	}
+
+	var nilHeaders *Headers
+	nilHeaders.Apply(header, HeaderData{})
+}
+
+func TestHeaderPolicyValidate(t *testing.T) {
+	for _, policy := range []HeaderPolicy{
+		{Default: map[string]string{"Authorization": "Bearer x"}},
+		{Tenants: map[string]map[string]string{"acme": {"x-api-key": "k"}}},
+		{Providers: map[string]map[string]string{"openai": {"Bad Name": "v"}}},
+		{Default: map[string]string{"X-Tag": "{{.Tenant"}},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", policy)
+		}
+	}
+}
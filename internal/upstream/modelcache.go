@@ -0,0 +1,122 @@
+package upstream
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxModelCacheEntries bounds the cache; keys differ by query (e.g. page
+	// tokens) and by the headers in modelCacheVary.
+	maxModelCacheEntries = 256
+	// MaxModelCacheBody is the largest model list response that is cached.
+	MaxModelCacheBody = 4 << 20
+)
+
+// modelCacheVary lists request headers that change which models the provider
+// lists, e.g. an organization injected per tenant.
+var modelCacheVary = []string{"Openai-Organization", "Openai-Project", "Anthropic-Version", "Anthropic-Beta", "Openai-Beta"}
+
+// CachedResponse is a model list response held by the cache.
+type CachedResponse struct {
+	ContentType string
+	Body        []byte
+	Stored      time.Time
+}
+
+// ModelCache holds model list responses (OpenAI and Anthropic GET /v1/models,
+// Gemini GET /v1beta/models) so frequent polling by agent frameworks is
+// answered locally. Safe for concurrent use.
+type ModelCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*CachedResponse
+}
+
+// NewModelCache returns a cache whose entries live for ttl.
+func NewModelCache(ttl time.Duration) *ModelCache {
+	return &ModelCache{ttl: ttl, now: time.Now, entries: make(map[string]*CachedResponse)}
+}
+
+// NewModelCacheFromEnv builds a cache from MODEL_CACHE_TTL_SECONDS. Returns nil
+// when unset or not positive.
+func NewModelCacheFromEnv() *ModelCache {
+	secs, err := strconv.Atoi(os.Getenv("MODEL_CACHE_TTL_SECONDS"))
+	if err != nil || secs <= 0 {
+		return nil
+	}
+	return NewModelCache(time.Duration(secs) * time.Second)
+}
+
+// IsModelListPath reports whether path lists models or describes one, e.g.
+// "/v1/models", "/v1/models/gpt-4o" or "/v1beta/models/gemini-2.0-flash".
+func IsModelListPath(path string) bool {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 || len(segments) > 3 || !strings.HasPrefix(segments[0], "v1") || segments[1] != "models" {
+		return false
+	}
+	return len(segments) == 2 || segments[2] != ""
+}
+
+// ModelCacheKey identifies the response to r: its path, query and the
+// headers that change the listing.
+func ModelCacheKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.URL.Path)
+	b.WriteByte('?')
+	b.WriteString(r.URL.RawQuery)
+	for _, name := range modelCacheVary {
+		if values := r.Header.Values(name); len(values) > 0 {
+			b.WriteString("\n" + name + ": " + strings.Join(values, ","))
+		}
+	}
+	return b.String()
+}
+
+// Get returns the unexpired response stored under key.
+func (c *ModelCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.now().Sub(entry.Stored) >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Age returns how long ago entry was stored.
+func (c *ModelCache) Age(entry *CachedResponse) time.Duration {
+	return c.now().Sub(entry.Stored)
+}
+
+// Put stores a response under key. When the cache is full, expired entries
+// are dropped first; if none are, the response is not stored.
+func (c *ModelCache) Put(key, contentType string, body []byte) {
+	if len(body) > MaxModelCacheBody {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxModelCacheEntries {
+		for k, entry := range c.entries {
+			if now.Sub(entry.Stored) >= c.ttl {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxModelCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = &CachedResponse{ContentType: contentType, Body: body, Stored: now}
+}
@@ -0,0 +1,48 @@
+package upstream
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsModelListPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/v1/models":                        true,
+		"/v1/models/gpt-4o":                 true,
+		"/v1beta/models":                    true,
+		"/v1beta/models/gemini-2.0-flash":   true,
+		"/v1/models/ft:gpt-4o:acme::abc123": true,
+		"/v1/chat/completions":              false,
+		"/v1/models/gpt-4o/extra":           false,
+		"/models":                           false,
+	} {
+		if got := IsModelListPath(path); got != want {
+			t.Errorf("IsModelListPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestModelCacheExpiresAndVariesByHeaders(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := NewModelCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	req := httptest.NewRequest("GET", "/v1/models?limit=20", nil)
+	key := ModelCacheKey(req)
+	cache.Put(key, "application/json", []byte(`{"data":[]}`))
+
+	req.Header.Set("OpenAI-Organization", "org-acme")
+	if _, ok := cache.Get(ModelCacheKey(req)); ok {
+		t.Fatal("expected a different organization to miss the cache")
+	}
+	now = now.Add(30 * time.Second)
+	entry, ok := cache.Get(key)
+	if !ok || string(entry.Body) != `{"data":[]}` || cache.Age(entry) != 30*time.Second {
+		t.Fatalf("expected a fresh entry, got %+v (found %v)", entry, ok)
+	}
+	now = now.Add(30 * time.Second)
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("expected the entry to expire after the TTL")
+	}
+}
@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Input modalities, as named in the model metadata table.
+const (
+	ModalityText     = "text"
+	ModalityImage    = "image"
+	ModalityAudio    = "audio"
+	ModalityVideo    = "video"
+	ModalityDocument = "document"
+)
+
+// Model is what validation knows about the requested model. Zero values mean
+// unknown and are not checked.
+type Model struct {
+	MaxOutput  int
+	Modalities []string
+}
+
+// outputCapFields are the request fields holding an output token cap.
+var outputCapFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens", "generationConfig.maxOutputTokens"}
+
+// ValidateModel checks a parsed request body against the limits of the model it
+// asks for: output token caps above the model's maximum, and input of a
+// modality the model does not accept.
+func ValidateModel(data map[string]any, model Model) []FieldError {
+	c := &checker{data: data}
+	if model.MaxOutput > 0 {
+		for _, field := range outputCapFields {
+			if n, ok := lookup(data, field).(float64); ok && n > float64(model.MaxOutput) {
+				c.fail(field, fmt.Sprintf("exceeds the model's maximum output of %d tokens", model.MaxOutput))
+			}
+		}
+	}
+	if len(model.Modalities) > 0 {
+		for _, modality := range InputModalities(data) {
+			if !slices.Contains(model.Modalities, modality) {
+				c.fail("", "model does not accept "+modality+" input")
+			}
+		}
+	}
+	return c.errs
+}
+
+func lookup(data map[string]any, field string) any {
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		var ok bool
+		if data, ok = data[part].(map[string]any); !ok {
+			return nil
+		}
+	}
+	return data[parts[len(parts)-1]]
+}
+
+// InputModalities returns the non-text modalities present in a request body,
+// in a fixed order: OpenAI image_url, input_image, input_audio and file parts,
+// Anthropic image and document blocks, and Gemini inline or file data by MIME
+// type.
+func InputModalities(data map[string]any) []string {
+	found := map[string]bool{}
+	walkParts(data, found)
+	var modalities []string
+	for _, m := range []string{ModalityImage, ModalityAudio, ModalityVideo, ModalityDocument} {
+		if found[m] {
+			modalities = append(modalities, m)
+		}
+	}
+	return modalities
+}
+
+func walkParts(v any, found map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		if m := partModality(v); m != "" {
+			found[m] = true
+		}
+		for _, child := range v {
+			walkParts(child, found)
+		}
+	case []any:
+		for _, child := range v {
+			walkParts(child, found)
+		}
+	}
+}
+
+func partModality(part map[string]any) string {
+	switch part["type"] {
+	case "image_url", "input_image", "image":
+		return ModalityImage
+	case "input_audio":
+		return ModalityAudio
+	case "file", "input_file", "document":
+		return ModalityDocument
+	}
+	for _, key := range []string{"inlineData", "inline_data", "fileData", "file_data"} {
+		blob, ok := part[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		mime, _ := blob["mimeType"].(string)
+		if mime == "" {
+			mime, _ = blob["mime_type"].(string)
+		}
+		switch {
+		case strings.HasPrefix(mime, "image/"):
+			return ModalityImage
+		case strings.HasPrefix(mime, "audio/"):
+			return ModalityAudio
+		case strings.HasPrefix(mime, "video/"):
+			return ModalityVideo
+		case mime != "":
+			return ModalityDocument
+		}
+	}
+	return ""
+}
@@ -0,0 +1,297 @@
+// Package validation checks request bodies against the shape each provider
+// endpoint expects, so malformed requests are rejected at the proxy instead of
+// reaching the provider and skewing spend estimates.
+package validation
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"agent-sentinel/internal/override"
+)
+
+// Policy configures request validation.
+type Policy struct {
+	Enabled bool `json:"enabled"`
+	// ForbiddenFields are rejected wherever validation applies, e.g. "logit_bias"
+	// or a nested "generationConfig.responseLogprobs".
+	ForbiddenFields []string `json:"forbidden_fields,omitempty"`
+}
+
+// FieldError is one problem with a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// policyFromEnv reads REQUEST_VALIDATION (true enables) and REQUEST_FORBIDDEN_FIELDS
+// (comma-separated).
+func policyFromEnv() Policy {
+	p := Policy{Enabled: os.Getenv("REQUEST_VALIDATION") == "true"}
+	for _, f := range strings.Split(os.Getenv("REQUEST_FORBIDDEN_FIELDS"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			p.ForbiddenFields = append(p.ForbiddenFields, f)
+		}
+	}
+	return p
+}
+
+// Validator holds the active policy. Safe for concurrent use.
+type Validator struct {
+	active override.Value[Policy]
+}
+
+// NewValidator returns a validator using REQUEST_VALIDATION and REQUEST_FORBIDDEN_FIELDS.
+func NewValidator() *Validator {
+	env := policyFromEnv()
+	return &Validator{active: override.New(&env)}
+}
+
+// Set replaces the environment policy with a file-configured one; nil restores
+// the environment settings.
+func (v *Validator) Set(policy *Policy) {
+	if v == nil {
+		return
+	}
+	v.active.Set(policy)
+}
+
+// Policy returns the active policy.
+func (v *Validator) Policy() Policy {
+	if v == nil {
+		return Policy{}
+	}
+	return v.active.Get()
+}
+
+// Validate checks a parsed request body for provider at path. Paths without a
+// known schema are only checked for forbidden fields.
+func Validate(provider, path string, data map[string]any, policy Policy) []FieldError {
+	c := &checker{data: data}
+	for _, field := range policy.ForbiddenFields {
+		if present(data, field) {
+			c.fail(field, "is not allowed by policy")
+		}
+	}
+	if schema := schemaFor(provider, path); schema != nil {
+		schema(c)
+	}
+	return c.errs
+}
+
+func present(data map[string]any, field string) bool {
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		v, ok := data[part]
+		if !ok {
+			return false
+		}
+		if i == len(parts)-1 {
+			return true
+		}
+		if data, ok = v.(map[string]any); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// HasSchema reports whether requests for provider at path are checked against a schema.
+func HasSchema(provider, path string) bool {
+	return schemaFor(provider, path) != nil
+}
+
+func schemaFor(provider, path string) func(*checker) {
+	switch provider {
+	case "anthropic":
+		if strings.HasSuffix(path, "/messages") {
+			return anthropicMessages
+		}
+	case "gemini":
+		if strings.HasSuffix(path, ":generateContent") || strings.HasSuffix(path, ":streamGenerateContent") {
+			return geminiGenerateContent
+		}
+	default:
+		switch {
+		case strings.HasSuffix(path, "/chat/completions"):
+			return openAIChat
+		case strings.HasSuffix(path, "/responses"):
+			return openAIResponses
+		case strings.HasSuffix(path, "/embeddings"):
+			return openAIEmbeddings
+		}
+	}
+	return nil
+}
+
+var (
+	openAIRoles    = []string{"system", "developer", "user", "assistant", "tool", "function"}
+	anthropicRoles = []string{"user", "assistant"}
+	geminiRoles    = []string{"user", "model"}
+)
+
+func openAIChat(c *checker) {
+	c.requireString("model")
+	c.messages("messages", openAIRoles, true)
+	c.number("temperature", 0, 2)
+	c.number("top_p", 0, 1)
+	c.number("presence_penalty", -2, 2)
+	c.number("frequency_penalty", -2, 2)
+	c.positiveInt("n")
+	c.positiveInt("max_tokens")
+	c.positiveInt("max_completion_tokens")
+}
+
+func openAIResponses(c *checker) {
+	c.requireString("model")
+	switch c.data["input"].(type) {
+	case string, []any:
+	case nil:
+		c.fail("input", "is required")
+	default:
+		c.fail("input", "must be a string or an array")
+	}
+	c.number("temperature", 0, 2)
+	c.number("top_p", 0, 1)
+	c.positiveInt("max_output_tokens")
+}
+
+func openAIEmbeddings(c *checker) {
+	c.requireString("model")
+	if c.data["input"] == nil {
+		c.fail("input", "is required")
+	}
+}
+
+func anthropicMessages(c *checker) {
+	c.requireString("model")
+	if c.data["max_tokens"] == nil {
+		c.fail("max_tokens", "is required")
+	}
+	c.positiveInt("max_tokens")
+	c.messages("messages", anthropicRoles, false)
+	switch c.data["system"].(type) {
+	case nil, string, []any:
+	default:
+		c.fail("system", "must be a string or an array of content blocks")
+	}
+	c.number("temperature", 0, 1)
+	c.number("top_p", 0, 1)
+	c.positiveInt("top_k")
+}
+
+func geminiGenerateContent(c *checker) {
+	contents, ok := c.data["contents"].([]any)
+	if !ok || len(contents) == 0 {
+		c.fail("contents", "must be a non-empty array")
+		return
+	}
+	for i, item := range contents {
+		field := fmt.Sprintf("contents[%d]", i)
+		content, ok := item.(map[string]any)
+		if !ok {
+			c.fail(field, "must be an object")
+			continue
+		}
+		if role, ok := content["role"]; ok {
+			if s, _ := role.(string); !slices.Contains(geminiRoles, s) {
+				c.fail(field+".role", "must be one of "+strings.Join(geminiRoles, ", "))
+			}
+		}
+		if parts, ok := content["parts"].([]any); !ok || len(parts) == 0 {
+			c.fail(field+".parts", "must be a non-empty array")
+		}
+	}
+	if config, ok := c.data["generationConfig"].(map[string]any); ok {
+		sub := &checker{data: config, prefix: "generationConfig."}
+		sub.number("temperature", 0, 2)
+		sub.number("topP", 0, 1)
+		sub.positiveInt("maxOutputTokens")
+		sub.positiveInt("candidateCount")
+		c.errs = append(c.errs, sub.errs...)
+	}
+}
+
+type checker struct {
+	data   map[string]any
+	prefix string
+	errs   []FieldError
+}
+
+func (c *checker) fail(field, message string) {
+	c.errs = append(c.errs, FieldError{Field: c.prefix + field, Message: message})
+}
+
+// requireString checks that field is a non-empty string. The model may also be
+// given in the path (Gemini), so schemas that need it call this explicitly.
+func (c *checker) requireString(field string) {
+	if s, ok := c.data[field].(string); !ok || s == "" {
+		c.fail(field, "must be a non-empty string")
+	}
+}
+
+// number checks that field, when present, is a number within [min, max].
+func (c *checker) number(field string, min, max float64) {
+	v, ok := c.data[field]
+	if !ok || v == nil {
+		return
+	}
+	n, ok := v.(float64)
+	if !ok {
+		c.fail(field, "must be a number")
+		return
+	}
+	if n < min || n > max {
+		c.fail(field, fmt.Sprintf("must be between %g and %g", min, max))
+	}
+}
+
+// positiveInt checks that field, when present, is a whole number of at least 1.
+func (c *checker) positiveInt(field string) {
+	v, ok := c.data[field]
+	if !ok || v == nil {
+		return
+	}
+	if n, ok := v.(float64); !ok || n < 1 || n != float64(int64(n)) {
+		c.fail(field, "must be a positive integer")
+	}
+}
+
+// messages checks a chat-style message array: each entry needs a known role and
+// string, array or (when allowed, e.g. assistant tool calls) null content.
+func (c *checker) messages(field string, roles []string, nullContent bool) {
+	messages, ok := c.data[field].([]any)
+	if !ok || len(messages) == 0 {
+		c.fail(field, "must be a non-empty array")
+		return
+	}
+	for i, item := range messages {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		msg, ok := item.(map[string]any)
+		if !ok {
+			c.fail(path, "must be an object")
+			continue
+		}
+		if role, _ := msg["role"].(string); !slices.Contains(roles, role) {
+			c.fail(path+".role", "must be one of "+strings.Join(roles, ", "))
+		}
+		switch msg["content"].(type) {
+		case string, []any:
+		case nil:
+			if !nullContent {
+				c.fail(path+".content", "is required")
+			}
+		default:
+			c.fail(path+".content", "must be a string or an array of content parts")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func parse(t *testing.T, body string) map[string]any {
+	t.Helper()
+	var data map[string]any
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return data
+}
+
+func fields(errs []FieldError) []string {
+	out := make([]string, len(errs))
+	for i, e := range errs {
+		out[i] = e.Field
+	}
+	return out
+}
+
+func TestValidateSchemas(t *testing.T) {
+	cases := []struct {
+		name     string
+		provider string
+		path     string
+		body     string
+		want     []string
+	}{
+		{"valid chat", "openai", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":null,"tool_calls":[]}]}`, nil},
+		{"bad chat", "openai", "/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"robot","content":5}],"temperature":3,"n":1.5}`,
+			[]string{"messages[0].role", "messages[0].content", "temperature", "n"}},
+		{"missing messages", "openai", "/v1/chat/completions", `{"model":"gpt-4o"}`, []string{"messages"}},
+		{"responses", "openai", "/v1/responses", `{"model":"gpt-4o"}`, []string{"input"}},
+		{"anthropic", "anthropic", "/v1/messages", `{"model":"claude","messages":[{"role":"system","content":"hi"}],"temperature":1.5}`,
+			[]string{"max_tokens", "messages[0].role", "temperature"}},
+		{"gemini", "gemini", "/v1beta/models/gemini-2.0-flash:generateContent", `{"contents":[{"role":"user","parts":[]}],"generationConfig":{"topP":2}}`,
+			[]string{"contents[0].parts", "generationConfig.topP"}},
+		{"unknown path", "openai", "/v1/files", `{"purpose":"batch"}`, nil},
+	}
+	for _, tc := range cases {
+		got := fields(Validate(tc.provider, tc.path, parse(t, tc.body), Policy{Enabled: true}))
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: expected errors on %v, got %v", tc.name, tc.want, got)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: expected errors on %v, got %v", tc.name, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestValidateForbiddenFields(t *testing.T) {
+	policy := Policy{Enabled: true, ForbiddenFields: []string{"logit_bias", "generationConfig.responseLogprobs"}}
+
+	errs := Validate("openai", "/v1/chat/completions", parse(t, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"logit_bias":{"50256":-100}}`), policy)
+	if len(errs) != 1 || errs[0].Field != "logit_bias" {
+		t.Fatalf("expected logit_bias to be rejected, got %v", errs)
+	}
+
+	errs = Validate("gemini", "/v1beta/models/gemini-2.0-flash:generateContent", parse(t, `{"contents":[{"parts":[{"text":"hi"}]}],"generationConfig":{"responseLogprobs":true}}`), policy)
+	if len(errs) != 1 || errs[0].Field != "generationConfig.responseLogprobs" {
+		t.Fatalf("expected nested field to be rejected, got %v", errs)
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	model := Model{MaxOutput: 4096, Modalities: []string{ModalityText, ModalityImage}}
+
+	errs := ValidateModel(parse(t, `{"model":"m","max_tokens":8000,"messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},
+		{"type":"input_audio","input_audio":{"data":"AA==","format":"wav"}}]}]}`), model)
+	if got := fields(errs); len(got) != 2 || got[0] != "max_tokens" || got[1] != "" {
+		t.Fatalf("expected the cap and the audio input rejected, got %v", errs)
+	}
+
+	errs = ValidateModel(parse(t, `{"contents":[{"parts":[{"inline_data":{"mime_type":"video/mp4","data":"AA=="}}]}],
+		"generationConfig":{"maxOutputTokens":1024}}`), model)
+	if len(errs) != 1 || errs[0].Message != "model does not accept video input" {
+		t.Fatalf("expected the video input rejected, got %v", errs)
+	}
+
+	if errs := ValidateModel(parse(t, `{"max_tokens":8000,"messages":[{"role":"user","content":[{"type":"document"}]}]}`), Model{}); len(errs) != 0 {
+		t.Fatalf("expected unknown limits unchecked, got %v", errs)
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -12,89 +16,190 @@ import (
 	"syscall"
 	"time"
 
+	"agent-sentinel/internal/admin"
+	"agent-sentinel/internal/affinity"
 	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/batches"
+	"agent-sentinel/internal/callbacks"
+	"agent-sentinel/internal/chaos"
 	"agent-sentinel/internal/config"
+	"agent-sentinel/internal/dashboard"
+	"agent-sentinel/internal/deferred"
+	"agent-sentinel/internal/doctor"
+	"agent-sentinel/internal/egress"
+	"agent-sentinel/internal/experiments"
+	"agent-sentinel/internal/fairness"
+	"agent-sentinel/internal/finetune"
+	"agent-sentinel/internal/grpcingress"
 	"agent-sentinel/internal/handlers"
+	"agent-sentinel/internal/jsonguard"
 	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/mcp"
 	"agent-sentinel/internal/middleware"
+	"agent-sentinel/internal/mirror"
+	"agent-sentinel/internal/operations"
+	"agent-sentinel/internal/probe"
+	"agent-sentinel/internal/provenance"
 	"agent-sentinel/internal/providers"
 	"agent-sentinel/internal/providers/anthropic"
 	"agent-sentinel/internal/providers/gemini"
 	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/quotasync"
 	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/secrets"
+	"agent-sentinel/internal/shed"
+	"agent-sentinel/internal/signing"
+	"agent-sentinel/internal/slo"
 	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/toolpolicy"
+	"agent-sentinel/internal/uploads"
+	"agent-sentinel/internal/upstream"
+	"agent-sentinel/internal/validation"
+
+	"embedding-sidecar/envelope"
+
+	"google.golang.org/grpc"
 )
 
-// initProvider initializes the LLM provider based on TARGET_API env var or auto-detection.
-func initProvider() providers.Provider {
+// providerKeyNames maps providers to the credential holding their API key.
+var providerKeyNames = map[string]string{
+	"openai":    "OPENAI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+	"gemini":    "GEMINI_API_KEY",
+}
+
+// initSecrets loads credentials from SECRETS_SOURCE when configured. Exits on
+// failure rather than falling back to stale or missing keys.
+func initSecrets() *secrets.Store {
+	store, err := secrets.FromEnv(context.Background())
+	if err != nil {
+		slog.Error("Failed to load secrets", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Credentials loaded", "source", store.String())
+	return store
+}
+
+// initProvider initializes the LLM provider based on TARGET_API env var or
+// auto-detection, and keeps its API key current as the secret source rotates it.
+func initProvider(store *secrets.Store) providers.Provider {
+	p, err := resolveProvider(store)
+	if err != nil {
+		slog.Error("Failed to init provider", "error", err)
+		os.Exit(1)
+	}
+	watchAPIKey(store, p, providerKeyNames[p.Name()])
+	return p
+}
+
+// watchAPIKey applies rotations of the named credential to provider.
+func watchAPIKey(store *secrets.Store, provider providers.Provider, name string) {
+	rotator, ok := provider.(providers.KeyRotator)
+	if !ok {
+		return
+	}
+	store.Watch(name, func(key string) {
+		if key == "" {
+			slog.Warn("Ignoring removal of API key; keeping the previous one", "name", name)
+			return
+		}
+		rotator.SetAPIKey(key)
+		slog.Info("Rotated API key", "name", name, "provider", provider.Name())
+	})
+}
+
+// resolveProvider builds the provider selected by TARGET_API (or auto-detected from keys).
+func resolveProvider(store *secrets.Store) (providers.Provider, error) {
 	targetAPI := strings.ToLower(os.Getenv("TARGET_API"))
-	openAIKey := os.Getenv("OPENAI_API_KEY")
-	geminiKey := os.Getenv("GEMINI_API_KEY")
-	anthropicKey := os.Getenv("ANTHROPIC_API_KEY")
+	openAIKey := store.Get("OPENAI_API_KEY")
+	geminiKey := store.Get("GEMINI_API_KEY")
+	anthropicKey := store.Get("ANTHROPIC_API_KEY")
 
 	switch targetAPI {
 	case "openai":
-		return mustInitOpenAI(openAIKey)
+		return newOpenAI(openAIKey)
 	case "anthropic":
-		return mustInitAnthropic(anthropicKey)
+		return newAnthropic(anthropicKey)
 	case "gemini":
-		return mustInitGemini(geminiKey)
+		return newGemini(geminiKey)
 	default:
 		// Auto-detect based on available keys (backwards compatible)
 		if geminiKey != "" {
-			return mustInitGemini(geminiKey)
+			return newGemini(geminiKey)
 		}
 		if openAIKey != "" && anthropicKey == "" {
-			return mustInitOpenAI(openAIKey)
+			return newOpenAI(openAIKey)
 		}
-		slog.Error("TARGET_API not set and no API key detected. Set TARGET_API to 'openai', 'gemini', or 'anthropic'")
-		os.Exit(1)
-		return nil
+		return nil, errors.New("TARGET_API not set and no API key detected. Set TARGET_API to 'openai', 'gemini', or 'anthropic'")
 	}
 }
 
-func mustInitOpenAI(apiKey string) providers.Provider {
+// newProvider builds the named provider with apiKey.
+func newProvider(name, apiKey string) (providers.Provider, error) {
+	switch name {
+	case "openai":
+		return newOpenAI(apiKey)
+	case "anthropic":
+		return newAnthropic(apiKey)
+	case "gemini":
+		return newGemini(apiKey)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
+func newOpenAI(apiKey string) (providers.Provider, error) {
 	if apiKey == "" {
-		slog.Error("OPENAI_API_KEY environment variable is not set")
-		os.Exit(1)
+		return nil, errors.New("OPENAI_API_KEY environment variable is not set")
 	}
 	p, err := openai.New(apiKey)
 	if err != nil {
-		slog.Error("Failed to init OpenAI provider", "error", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("init OpenAI provider: %w", err)
 	}
-	return p
+	return p, nil
 }
 
-func mustInitAnthropic(apiKey string) providers.Provider {
+func newAnthropic(apiKey string) (providers.Provider, error) {
 	if apiKey == "" {
-		slog.Error("ANTHROPIC_API_KEY environment variable is not set")
-		os.Exit(1)
+		return nil, errors.New("ANTHROPIC_API_KEY environment variable is not set")
 	}
 	p, err := anthropic.New(apiKey)
 	if err != nil {
-		slog.Error("Failed to init Anthropic provider", "error", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("init Anthropic provider: %w", err)
 	}
-	return p
+	return p, nil
 }
 
-func mustInitGemini(apiKey string) providers.Provider {
+func newGemini(apiKey string) (providers.Provider, error) {
 	if apiKey == "" {
-		slog.Error("GEMINI_API_KEY environment variable is not set")
-		os.Exit(1)
+		return nil, errors.New("GEMINI_API_KEY environment variable is not set")
 	}
 	p, err := gemini.New(apiKey)
 	if err != nil {
-		slog.Error("Failed to init Gemini provider", "error", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("init Gemini provider: %w", err)
 	}
-	return p
+	return p, nil
+}
+
+// initChaos configures fault injection (CHAOS_MODE). Returns nil when disabled
+// or misconfigured.
+func initChaos() *chaos.Injector {
+	cfg, ok, err := chaos.ConfigFromEnv()
+	if err != nil {
+		slog.Warn("Chaos mode disabled", "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	slog.Warn("CHAOS MODE ENABLED: injecting faults; never run this in production", "faults", cfg.String())
+	return chaos.New(cfg)
 }
 
 // initRateLimiter initializes rate limiting via Redis if available.
-// Returns nil if Redis is unavailable or initialization fails.
-func initRateLimiter() *ratelimit.RateLimiter {
+// Returns nil if Redis is unavailable or initialization fails. Chaos mode
+// faults apply once migrations have run.
+func initRateLimiter(faults *chaos.Injector) *ratelimit.RateLimiter {
 	redisClient := ratelimit.NewRedisClient()
 	if redisClient == nil {
 		slog.Info("Rate limiting disabled (Redis not available)")
@@ -106,18 +211,151 @@ func initRateLimiter() *ratelimit.RateLimiter {
 		slog.Info("Rate limiting disabled (RateLimiter initialization failed)")
 		return nil
 	}
+	// Exit rather than silently writing plaintext when encryption is misconfigured.
+	keyring, err := envelope.FromEnv(redisClient.Client(), redisClient.Namespace())
+	if err != nil {
+		slog.Error("Failed to configure usage log encryption", "error", err)
+		os.Exit(1)
+	}
+	if keyring != nil {
+		rl.SetKeyring(keyring)
+		slog.Info("Usage log encryption enabled")
+	}
+
+	rl.MigrateOnStart()
+	if faults != nil {
+		redisClient.Client().AddHook(faults.RedisHook())
+	}
 
 	slog.Info("Rate limiting enabled via Redis")
 	return rl
 }
 
+// initSigning configures tenant request signing (REQUEST_SIGNING). Nonces are
+// shared through Redis when available. Exits on invalid settings so an exposed
+// proxy never silently accepts unsigned traffic.
+func initSigning(rateLimiter *ratelimit.RateLimiter) *signing.Verifier {
+	var nonces signing.NonceStore
+	if rateLimiter != nil {
+		nonces = signing.NewRedisNonceStore(rateLimiter.Redis())
+	}
+	verifier, err := signing.FromEnv(nonces)
+	if err != nil {
+		slog.Error("Failed to configure request signing", "error", err)
+		os.Exit(1)
+	}
+	if verifier != nil {
+		slog.Info("Request signing enabled", "required", verifier.Required(), "shared_nonces", nonces != nil)
+	}
+	return verifier
+}
+
+// initRequestLimiter returns the limiter used on the request path: the Redis
+// limiter when available, otherwise the one selected by RATE_LIMIT_BACKEND
+// (memory: single instance only; etcd: shared through ETCD_ENDPOINT), otherwise nil.
+func initRequestLimiter(rateLimiter *ratelimit.RateLimiter) ratelimit.Limiter {
+	if rateLimiter != nil {
+		return rateLimiter
+	}
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "memory":
+		slog.Warn("Rate limiting enabled in memory; spend is per instance and lost on restart")
+		return ratelimit.NewMemoryLimiter()
+	case "etcd":
+		store, err := ratelimit.EtcdStoreFromEnv()
+		if err != nil {
+			slog.Warn("etcd rate limiting disabled", "error", err)
+			return nil
+		}
+		slog.Info("Rate limiting enabled on etcd", "endpoint", os.Getenv("ETCD_ENDPOINT"))
+		return ratelimit.NewStoreLimiter(store)
+	}
+	return nil
+}
+
+// initTransport builds the transport to target, routed through the provider's egress
+// proxy, trusting EGRESS_CA_FILE and restricted by the egress guard when configured.
+// Exits on invalid settings so traffic never bypasses a required proxy or allowlist.
+func initTransport(provider providers.Provider, target *url.URL) http.RoundTripper {
+	transport, err := egress.NewTransport(provider.Name())
+	if err != nil {
+		slog.Error("Failed to configure egress", "error", err, "provider", provider.Name())
+		os.Exit(1)
+	}
+	guard, err := egress.GuardFromEnv(target)
+	if err != nil {
+		slog.Error("Failed to configure egress guard", "error", err)
+		os.Exit(1)
+	}
+	return guard.Wrap(transport, target)
+}
+
+// initMirror configures request mirroring to MIRROR_URL. Returns nil when
+// disabled or misconfigured (mirroring is optional).
+func initMirror(provider providers.Provider, store *secrets.Store) *mirror.Mirror {
+	cfg, ok, err := mirror.ConfigFromEnv()
+	if err != nil {
+		slog.Warn("Request mirroring disabled", "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	cfg.Provider = provider
+	cfg.Transport = initTransport(provider, cfg.Target)
+	if key := store.Get("MIRROR_API_KEY"); key != "" {
+		mirrorProvider, err := newProvider(provider.Name(), key)
+		if err != nil {
+			slog.Warn("Request mirroring disabled", "error", err)
+			return nil
+		}
+		watchAPIKey(store, mirrorProvider, "MIRROR_API_KEY")
+		cfg.Prepare = mirrorProvider.PrepareRequest
+	}
+
+	slog.Info("Request mirroring enabled",
+		"target", cfg.Target.Redacted(),
+		"sample_percent", cfg.Percent,
+		"model", cfg.Model,
+	)
+	return mirror.New(cfg)
+}
+
+// initProber configures synthetic provider probes (PROBE_ENABLED or
+// PROBE_INTERVAL_SECONDS) for the proxied key. Probe responses feed the provider
+// backoff. Returns nil when disabled.
+func initProber(provider providers.Provider, transport http.RoundTripper, backoff *upstream.Backoff) *probe.Prober {
+	cfg, ok := probe.ConfigFromEnv()
+	if !ok {
+		return nil
+	}
+	prober := probe.New(cfg, probe.Target{
+		Key:       providerKeyNames[provider.Name()],
+		Provider:  provider,
+		Transport: transport,
+		Backoff:   backoff,
+	})
+	slog.Info("Provider probes enabled",
+		"provider", provider.Name(),
+		"interval", cfg.Interval,
+		"canary_model", cfg.CanaryModel,
+		"fail_fast", cfg.FailFast,
+	)
+	return prober
+}
+
+// loopSidecarUDS returns the embedding sidecar socket path.
+func loopSidecarUDS() string {
+	if v := os.Getenv("LOOP_EMBEDDING_SIDECAR_UDS"); v != "" {
+		return v
+	}
+	return "/sockets/embedding-sidecar.sock"
+}
+
 // initLoopClient initializes the loop detection gRPC client.
 // Returns nil if initialization fails (fail-open).
-func initLoopClient() *loopdetect.Client {
-	loopUDS := os.Getenv("LOOP_EMBEDDING_SIDECAR_UDS")
-	if loopUDS == "" {
-		loopUDS = "/sockets/embedding-sidecar.sock"
-	}
+func initLoopClient(faults *chaos.Injector) *loopdetect.Client {
+	loopUDS := loopSidecarUDS()
 
 	loopTimeoutMs := 1000
 	if v := os.Getenv("LOOP_EMBEDDING_SIDECAR_TIMEOUT_MS"); v != "" {
@@ -126,7 +364,7 @@ func initLoopClient() *loopdetect.Client {
 		}
 	}
 
-	client, err := loopdetect.New(loopUDS, time.Duration(loopTimeoutMs)*time.Millisecond)
+	client, err := loopdetect.New(loopUDS, time.Duration(loopTimeoutMs)*time.Millisecond, faults.SidecarDialOptions()...)
 	if err != nil {
 		slog.Warn("Loop detection client init failed (fail-open)", "error", err)
 		return nil
@@ -140,17 +378,154 @@ func main() {
 	config.ConfigureLogging()
 	_ = config.LoadEnvFile(".env")
 
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor())
+	}
+	secretStore := initSecrets()
+
 	// Initialize async operations (semaphore + completion tracking)
 	async.Init()
 
 	// Initialize OpenTelemetry tracing (optional, based on env)
 	shutdownTracing := telemetry.InitTracing()
+	shutdownMetrics := telemetry.InitMetrics()
+	shutdownLogs := telemetry.InitLogs()
+	// Outermost, so exported logs are scrubbed too.
+	slog.SetDefault(slog.New(secrets.NewScrubHandler(slog.Default().Handler(), secretStore)))
 	telemetry.RegisterRuntimeGauges(async.QueueDepth)
 
 	// Initialize components
-	rateLimiter := initRateLimiter()
-	provider := initProvider()
-	loopClient := initLoopClient()
+	faults := initChaos()
+	rateLimiter := initRateLimiter(faults)
+	requestLimiter := initRequestLimiter(rateLimiter)
+	provider := initProvider(secretStore)
+	loopClient := initLoopClient(faults)
+
+	// Background jobs stop when shutdown begins.
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go secretStore.Run(backgroundCtx)
+	if loopClient != nil {
+		// The sidecar may still be warming up, so verify it in the background.
+		go func() {
+			ctx, cancel := context.WithTimeout(backgroundCtx, 2*time.Minute)
+			defer cancel()
+			if err := loopClient.Verify(ctx); err != nil {
+				if errors.Is(err, loopdetect.ErrIncompatibleSidecar) {
+					slog.Error("Loop detection disabled: sidecar is incompatible with this proxy", "error", err)
+					return
+				}
+				slog.Warn("Could not verify loop detection sidecar (fail-open)", "error", err)
+			}
+		}()
+	}
+	if rateLimiter != nil {
+		reconcileInterval := 60 * time.Second
+		if v := os.Getenv("RESERVATION_RECONCILE_INTERVAL_SECONDS"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+				reconcileInterval = time.Duration(parsed) * time.Second
+			}
+		}
+		go rateLimiter.RunReconciler(backgroundCtx, reconcileInterval)
+
+		dlqInterval := 30 * time.Second
+		if v := os.Getenv("DLQ_RETRY_INTERVAL_SECONDS"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+				dlqInterval = time.Duration(parsed) * time.Second
+			}
+		}
+		go rateLimiter.RunDeadLetterRetrier(backgroundCtx, dlqInterval)
+
+		replicaCheckInterval := 5 * time.Second
+		if v := os.Getenv("REDIS_REPLICA_CHECK_INTERVAL_SECONDS"); v != "" {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+				replicaCheckInterval = time.Duration(parsed) * time.Second
+			}
+		}
+		go rateLimiter.RunReplicaHealthChecks(backgroundCtx, replicaCheckInterval)
+		go rateLimiter.RunLimitInvalidator(backgroundCtx)
+
+		startQuotaSync(backgroundCtx, rateLimiter, provider, secretStore)
+	}
+
+	// Experiment outcomes are stored alongside spend; without Redis only the
+	// assignment (and its tagging) applies.
+	var experimentStore experiments.Store
+	if rateLimiter != nil {
+		experimentStore = rateLimiter
+	}
+	registry := experiments.NewRegistry(experimentStore)
+	tracker := slo.NewTracker(slo.AlerterFromEnv())
+	outputTokens := ratelimit.NewOutputTokenGuard()
+	models := ratelimit.NewModelCatalog()
+	truncationRetry := ratelimit.NewTruncationRetryGuard()
+	jsonGuard := jsonguard.NewGuard()
+	validator := validation.NewValidator()
+	loopBypass := loopdetect.NewBypassGuard()
+	loopHints := loopdetect.NewHints(os.Getenv("LOOP_INTERVENTION_HINT"))
+	loopCanon := loopdetect.NewCanonicalizer()
+	loopAnalytics := loopdetect.AnalyticsFromEnv()
+	upstreamHeaders := upstream.NewHeaders()
+	betaFeatures := upstream.NewBetaGuard()
+	tools := toolpolicy.NewGuard()
+	providerBackoff := upstream.NewBackoffFromEnv()
+	var providerLimits fairness.Limits
+	if providerBackoff != nil {
+		providerLimits = func() (int, int) {
+			last := providerBackoff.Last()
+			return last.RemainingRequests, last.RemainingTokens
+		}
+	}
+	scheduler := fairness.NewScheduler(providerLimits)
+	// Probes skip the SLO tracker and load shedder so they only reflect the provider.
+	prober := initProber(provider, faults.Wrap(initTransport(provider, provider.BaseURL())), providerBackoff)
+	go prober.Run(backgroundCtx)
+	smoothing := ratelimit.NewSmoothingGuard()
+	mcpGuard := mcp.NewGuard()
+	ops := operations.NewGuard()
+	callbackDispatcher := initCallbacks(rateLimiter)
+	batchAccountant := initBatchAccounting(backgroundCtx, provider, rateLimiter, requestLimiter, callbackDispatcher)
+	fineTuneAccountant := initFineTuneAccounting(backgroundCtx, provider, rateLimiter)
+	uploadGovernor := initUploads(rateLimiter)
+	deferQueue := initDeferral(rateLimiter, callbackDispatcher)
+	provenanceSigner := provenance.FromEnv()
+	if provenanceSigner != nil {
+		slog.Info("Response provenance stamping enabled", "key_id", os.Getenv("PROVENANCE_KEY_ID"), "stream_event", provenanceSigner.StreamEvent())
+	}
+	guards := &reloadable{
+		registry:        registry,
+		tracker:         tracker,
+		outputTokens:    outputTokens,
+		models:          models,
+		truncationRetry: truncationRetry,
+		jsonGuard:       jsonGuard,
+		validator:       validator,
+		loopBypass:      loopBypass,
+		loopHints:       loopHints,
+		loopCanon:       loopCanon,
+		upstreamHeaders: upstreamHeaders,
+		betaFeatures:    betaFeatures,
+		tools:           tools,
+		ops:             ops,
+		scheduler:       scheduler,
+		smoothing:       smoothing,
+		mcp:             mcpGuard,
+		fineTune:        fineTuneAccountant,
+		uploads:         uploadGovernor,
+		deferQueue:      deferQueue,
+		signer:          provenanceSigner,
+	}
+	if configDir := os.Getenv("CONFIG_DIR"); configDir != "" {
+		initFileConfig(backgroundCtx, configDir, rateLimiter, guards)
+	}
+	sloInterval := 30 * time.Second
+	if v := os.Getenv("SLO_EVAL_INTERVAL_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			sloInterval = time.Duration(parsed) * time.Second
+		}
+	}
+	go tracker.Run(backgroundCtx, sloInterval)
+	shedder := shed.NewFromEnv()
+	go shedder.Run(backgroundCtx, time.Second)
 
 	// Configure reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(provider.BaseURL())
@@ -159,30 +534,102 @@ func main() {
 		originalDirector(req)
 		provider.PrepareRequest(req)
 	}
-	proxy.Transport = telemetry.NewInstrumentedTransport(provider, proxy.Transport)
-	proxy.ModifyResponse = handlers.CreateModifyResponse(rateLimiter, provider)
-	proxy.ErrorHandler = handlers.CreateErrorHandler(rateLimiter)
+	upstreamTransport := tracker.Wrap(shedder.Wrap(faults.Wrap(initTransport(provider, provider.BaseURL()))), provider.Name(), func(req *http.Request) string {
+		if model, ok := req.Context().Value(middleware.ContextKeyModel).(string); ok && model != "" {
+			return model
+		}
+		return provider.ExtractModelFromPath(req.URL.Path)
+	})
+	proxy.Transport = telemetry.NewInstrumentedTransport(provider, upstreamTransport)
+	compressMinBytes := 0
+	if v := os.Getenv("RESPONSE_COMPRESSION_MIN_BYTES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			compressMinBytes = parsed
+		}
+	}
+	proxy.ModifyResponse = handlers.ChainModifyResponse(
+		handlers.CreateSizeMetrics(provider),
+		handlers.CreateProviderLimitsResponse(providerBackoff),
+		handlers.CreateModifyResponse(requestLimiter, provider),
+		handlers.CreateCompressResponse(compressMinBytes),
+	)
+	proxy.ErrorHandler = handlers.CreateErrorHandler(requestLimiter)
 
 	// Configure middleware
 	rateLimitHeader := os.Getenv("RATE_LIMIT_HEADER")
 	if rateLimitHeader == "" {
 		rateLimitHeader = "X-Tenant-ID"
 	}
-	loopHint := os.Getenv("LOOP_INTERVENTION_HINT")
-	if loopHint == "" {
-		loopHint = "System: break the loop and respond with a new approach."
+
+	var deadlineMax time.Duration
+	if v, err := strconv.Atoi(os.Getenv("DEADLINE_MAX_MS")); err == nil && v > 0 {
+		deadlineMax = time.Duration(v) * time.Millisecond
 	}
 
-	// Build middleware chain (order: tracing -> rate limiting -> loop detection -> logging -> proxy)
+	// Build middleware chain (order: request ID -> tracing -> decision trace -> load shedding -> latency budget -> request signing -> affinity -> deferral -> beta features -> operations -> uploads -> upstream headers -> model cache -> model deprecation -> validation -> tool policy -> provider health -> provider backoff -> experiments -> request smoothing -> fair queueing -> json guard -> truncation retry -> output tokens -> rate limiting -> batch accounting -> fine-tune accounting -> mirror -> loop detection -> provenance -> logging -> proxy)
 	var handler http.Handler = proxy
-	handler = middleware.Logging(provider, handler)
-	if loopClient != nil {
-		handler = middleware.LoopDetection(loopClient, provider, rateLimitHeader, loopHint)(handler)
+	handler = middleware.TraceStage("upstream", middleware.Logging(provider, handler))
+	handler = middleware.Traced("provenance", middleware.Provenance(provenanceSigner, provider, rateLimitHeader))(handler)
+	if toolCycles := loopdetect.ToolCyclesFromEnv(loopCanon); loopClient != nil || toolCycles != nil {
+		var sidecar middleware.LoopClient
+		if loopClient != nil {
+			sidecar = loopClient
+		}
+		handler = middleware.Traced(middleware.StageLoopDetection, middleware.LoopDetection(sidecar, provider, rateLimitHeader, loopHints, loopBypass, loopCanon, toolCycles, loopAnalytics))(handler)
 	}
+	handler = middleware.Traced("mirror", middleware.Mirror(initMirror(provider, secretStore)))(handler)
+	handler = middleware.Traced("fine_tune_accounting", middleware.FineTuneAccounting(fineTuneAccountant, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("batch_accounting", middleware.BatchAccounting(batchAccountant, requestLimiter, provider, rateLimitHeader, models))(handler)
+	if requestLimiter != nil {
+		handler = middleware.Traced(middleware.StageRateLimit, middleware.RateLimiting(requestLimiter, provider, rateLimitHeader, models))(handler)
+	}
+	handler = middleware.Traced(middleware.StageOutputTokens, middleware.OutputTokens(outputTokens, provider))(handler)
+	handler = middleware.Traced("truncation_retry", middleware.TruncationRetry(truncationRetry, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("json_guard", middleware.JSONGuard(jsonGuard, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("fairness", middleware.Fairness(scheduler, rateLimitHeader))(handler)
+	var buckets middleware.TokenBucket
 	if rateLimiter != nil {
-		handler = middleware.RateLimiting(rateLimiter, provider, rateLimitHeader)(handler)
+		buckets = rateLimiter
+	} else if _, ok := smoothing.Policy().For(""); ok {
+		slog.Warn("Request smoothing needs Redis; bursts will not be throttled")
 	}
+	handler = middleware.Traced("smoothing", middleware.Smoothing(smoothing, buckets, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced(middleware.StageExperiments, middleware.Experiments(registry, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("provider_backoff", middleware.ProviderBackoff(providerBackoff, provider))(handler)
+	handler = middleware.Traced("provider_health", middleware.ProviderHealth(prober, provider))(handler)
+	handler = middleware.Traced(middleware.StageToolPolicy, middleware.ToolPolicy(tools, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("validation", middleware.Validation(validator, provider, models))(handler)
+	handler = middleware.Traced(middleware.StageModelDeprecation, middleware.ModelDeprecation(models, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced(middleware.StageModelCache, middleware.ModelCache(upstream.NewModelCacheFromEnv(), provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("upstream_headers", middleware.UpstreamHeaders(upstreamHeaders, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("uploads", middleware.Uploads(uploadGovernor, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("operations", middleware.Operations(ops, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("beta_features", middleware.BetaFeatures(betaFeatures, provider, rateLimitHeader))(handler)
+	handler = middleware.Traced("deferral", middleware.Deferral(deferQueue, rateLimitHeader))(handler)
+	handler = middleware.Traced("affinity", middleware.Affinity(affinity.FromEnv(), rateLimitHeader))(handler)
+	signer := initSigning(rateLimiter)
+	handler = middleware.Traced("signing", middleware.RequestSigning(signer, rateLimitHeader))(handler)
+	handler = middleware.Traced("latency_budget", middleware.LatencyBudget(deadlineMax))(handler)
+	handler = middleware.Traced("load_shedding", middleware.LoadShedding(shedder))(handler)
+	handler = middleware.Decisions(os.Getenv("DECISION_TRACE"))(handler)
 	handler = telemetry.Middleware(provider, handler)
+	handler = middleware.RequestID()(handler)
+	handler = initMCP(handler, guards, rateLimiter, signer, rateLimitHeader)
+
+	if deferQueue != nil {
+		interval := 30 * time.Second
+		if v, err := strconv.Atoi(os.Getenv("DEFER_POLL_INTERVAL_SECONDS")); err == nil && v > 0 {
+			interval = time.Duration(v) * time.Second
+		}
+		go deferQueue.Run(backgroundCtx, interval)
+	}
+	if callbackDispatcher != nil {
+		interval := 5 * time.Second
+		if v, err := strconv.Atoi(os.Getenv("CALLBACK_POLL_INTERVAL_SECONDS")); err == nil && v > 0 {
+			interval = time.Duration(v) * time.Second
+		}
+		go callbackDispatcher.Run(backgroundCtx, interval)
+	}
 
 	// Start server
 	port := ":8080"
@@ -193,7 +640,13 @@ func main() {
 	)
 
 	server := &http.Server{Addr: port, Handler: handler}
-	go gracefulShutdown(server, shutdownTracing)
+	adminOpts := admin.Options{Experiments: registry, SLO: tracker, Health: prober, Models: models, LoopAnalytics: loopAnalytics, FineTune: fineTuneAccountant}
+	if loopClient != nil {
+		adminOpts.LoopCalibration = loopClient
+	}
+	auxServers := startAdminServers(rateLimiter, secretStore, adminOpts)
+	grpcServer := startGRPCServer(handler)
+	go gracefulShutdown(server, grpcServer, shutdownTracing, shutdownMetrics, shutdownLogs, stopBackground, auxServers...)
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("Server failed to start", "error", err, "port", port)
@@ -201,11 +654,354 @@ func main() {
 	}
 }
 
-func gracefulShutdown(server *http.Server, shutdownTracing func(context.Context) error) {
+// reloadable holds the guards whose policies the files in CONFIG_DIR override.
+// Each starts from its environment variables, which apply again when the files
+// drop its policy.
+type reloadable struct {
+	registry        *experiments.Registry
+	tracker         *slo.Tracker
+	outputTokens    *ratelimit.OutputTokenGuard
+	models          *ratelimit.ModelCatalog
+	truncationRetry *ratelimit.TruncationRetryGuard
+	jsonGuard       *jsonguard.Guard
+	validator       *validation.Validator
+	loopBypass      *loopdetect.BypassGuard
+	loopHints       *loopdetect.Hints
+	loopCanon       *loopdetect.Canonicalizer
+	upstreamHeaders *upstream.Headers
+	betaFeatures    *upstream.BetaGuard
+	tools           *toolpolicy.Guard
+	ops             *operations.Guard
+	scheduler       *fairness.Scheduler
+	smoothing       *ratelimit.SmoothingGuard
+	mcp             *mcp.Guard
+	fineTune        *finetune.Accountant
+	uploads         *uploads.Governor
+	deferQueue      *deferred.Queue
+	signer          *provenance.Signer
+}
+
+// apply sets every guard's policy from files.
+func (g *reloadable) apply(files *config.Files) {
+	g.registry.Set(files.Experiments)
+	g.tracker.Set(files.SLOs)
+	g.outputTokens.Set(files.Policies.MaxTokens)
+	g.models.Set(files.Models)
+	g.truncationRetry.Set(files.Policies.TruncationRetry)
+	g.jsonGuard.Set(files.Policies.JSONGuard)
+	g.validator.Set(files.Policies.Validation)
+	g.loopBypass.Set(files.Policies.LoopBypass)
+	g.loopHints.Set(files.Policies.LoopHints)
+	g.loopCanon.Set(files.Policies.LoopCanonicalize)
+	g.upstreamHeaders.Set(files.Policies.UpstreamHeaders)
+	g.betaFeatures.Set(files.Policies.BetaFeatures)
+	g.tools.Set(files.Policies.Tools)
+	g.ops.Set(files.Policies.Operations)
+	g.scheduler.Set(files.Policies.Fairness)
+	g.smoothing.Set(files.Policies.Smoothing)
+	g.mcp.Set(files.Policies.MCP)
+	g.fineTune.SetBudgets(files.Limits.FineTune)
+	g.uploads.Set(files.Policies.Uploads)
+	g.deferQueue.Set(files.Policies.Deferral)
+	g.signer.SetPolicyVersion(files.PolicyVersion())
+}
+
+// initFileConfig applies pricing, model metadata, limits, policies, experiments
+// and SLOs from configDir and reloads them when the files change (e.g. a mounted
+// ConfigMap is updated).
+func initFileConfig(ctx context.Context, configDir string, rateLimiter *ratelimit.RateLimiter, guards *reloadable) {
+	apply := func(files *config.Files) {
+		guards.apply(files)
+		if rateLimiter == nil {
+			return
+		}
+		files.ApplyLimits(ctx, rateLimiter)
+	}
+
+	files, err := config.LoadDir(configDir)
+	if err != nil {
+		slog.Warn("Failed to load config files, using defaults", "error", err, "dir", configDir)
+	} else {
+		apply(files)
+		slog.Info("Config files loaded", "dir", configDir, "tenant_limits", len(files.Limits.Tenants), "experiments", len(files.Experiments), "slos", len(files.SLOs), "policy_version", files.PolicyVersion())
+	}
+
+	if err := config.WatchDir(ctx, configDir, apply); err != nil {
+		slog.Warn("Config reload disabled", "error", err, "dir", configDir)
+	}
+}
+
+// initBatchAccounting charges batch jobs submitted through the proxy and settles
+// them in the background once they end (BATCH_POLL_INTERVAL_SECONDS, default
+// 60). Jobs are tracked in Redis when available, otherwise per instance.
+// Settlements are sent to tenants' callback URLs when callbacks are on.
+func initBatchAccounting(ctx context.Context, provider providers.Provider, rateLimiter *ratelimit.RateLimiter, requestLimiter ratelimit.Limiter, dispatcher *callbacks.Dispatcher) *batches.Accountant {
+	if requestLimiter == nil {
+		return nil
+	}
+	var store batches.Store = batches.NewMemoryStore()
+	if rateLimiter != nil {
+		store = batches.NewRedisStore(rateLimiter.Redis())
+	}
+	accountant := batches.FromEnv(provider, initTransport(provider, provider.BaseURL()), store, requestLimiter)
+	if accountant == nil {
+		return nil
+	}
+	if dispatcher != nil {
+		accountant.OnSettle = dispatcher.BatchSettled
+	}
+	interval := 60 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("BATCH_POLL_INTERVAL_SECONDS")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+	go accountant.Run(ctx, interval)
+	slog.Info("Batch accounting enabled", "provider", provider.Name(), "poll_interval", interval, "shared", rateLimiter != nil)
+	return accountant
+}
+
+// initFineTuneAccounting holds fine-tuning jobs created through the proxy to
+// tenants' monthly allowances and settles them in the background once they end
+// (FINE_TUNE_POLL_INTERVAL_SECONDS, default 300). Spend and jobs are kept in
+// Redis when available, otherwise per instance.
+func initFineTuneAccounting(ctx context.Context, provider providers.Provider, rateLimiter *ratelimit.RateLimiter) *finetune.Accountant {
+	var store finetune.Store = finetune.NewMemoryStore()
+	if rateLimiter != nil {
+		store = finetune.NewRedisStore(rateLimiter.Redis())
+	}
+	accountant := finetune.FromEnv(provider, initTransport(provider, provider.BaseURL()), store)
+	if accountant == nil {
+		return nil
+	}
+	interval := 300 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("FINE_TUNE_POLL_INTERVAL_SECONDS")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+	go accountant.Run(ctx, interval)
+	slog.Info("Fine-tuning accounting enabled", "provider", provider.Name(), "poll_interval", interval, "shared", rateLimiter != nil)
+	return accountant
+}
+
+// initUploads governs file uploads (UPLOAD_* variables or policies.json
+// uploads), scanning them when UPLOAD_SCAN_URL is set. Uploaded files count
+// against storage quotas in Redis when available, otherwise per instance.
+func initUploads(rateLimiter *ratelimit.RateLimiter) *uploads.Governor {
+	var store uploads.Store = uploads.NewMemoryStore()
+	if rateLimiter != nil {
+		store = uploads.NewRedisStore(rateLimiter.Redis())
+	}
+	scanner := uploads.ScannerFromEnv()
+	if scanner != nil {
+		slog.Info("Upload scanning enabled", "url", os.Getenv("UPLOAD_SCAN_URL"))
+	}
+	return uploads.New(store, scanner)
+}
+
+// initDeferral returns the queue for deferred low-priority requests, which
+// lives in Redis so any replica can replay or answer for a request. Without
+// Redis, low-priority requests are denied as usual.
+func initDeferral(rateLimiter *ratelimit.RateLimiter, dispatcher *callbacks.Dispatcher) *deferred.Queue {
+	if rateLimiter == nil {
+		if enabled, _ := strconv.ParseBool(os.Getenv("DEFER_LOW_PRIORITY")); enabled {
+			slog.Warn("Deferred requests need Redis; low-priority requests will be denied as usual")
+		}
+		return nil
+	}
+	queue := deferred.New(deferred.NewRedisStore(rateLimiter.Redis()), rateLimiter)
+	if dispatcher != nil {
+		queue.OnComplete = dispatcher.DeferredCompleted
+	}
+	if p := queue.Policy(); p.Enabled {
+		slog.Info("Low-priority request deferral enabled", "soft_limit_percent", p.SoftLimitPercent)
+	}
+	return queue
+}
+
+// initMCP serves the MCP servers in MCP_SERVERS under /mcp/{name} beside
+// handler, with per-tool rate limits (which need Redis), loop detection and
+// audit logging on their tool calls. It returns handler unchanged when no
+// servers are configured, and exits on invalid ones.
+func initMCP(handler http.Handler, guards *reloadable, rateLimiter *ratelimit.RateLimiter, signer *signing.Verifier, headerName string) http.Handler {
+	servers, err := mcp.ServersFromEnv()
+	if err != nil {
+		slog.Error("Failed to configure MCP servers", "error", err)
+		os.Exit(1)
+	}
+	if len(servers) == 0 {
+		return handler
+	}
+	transport, err := egress.NewTransport("mcp")
+	if err != nil {
+		slog.Error("Failed to configure egress", "error", err, "provider", "mcp")
+		os.Exit(1)
+	}
+	var buckets middleware.ToolBucket
+	if rateLimiter != nil {
+		buckets = rateLimiter
+	} else if p := guards.mcp.Policy(); p.Default.Burst > 0 || len(p.Tools) > 0 {
+		slog.Warn("MCP tool rate limits need Redis; tool calls will not be throttled")
+	}
+
+	cycles := mcp.LoopsFromEnv(guards.loopCanon)
+
+	// Order: request ID -> request signing -> MCP (tool rate limits, loop detection, audit) -> MCP server
+	var gateway http.Handler = mcp.NewProxy(servers, transport)
+	gateway = middleware.Traced("mcp", middleware.MCP(guards.mcp, buckets, cycles, guards.loopHints, guards.loopBypass, headerName))(gateway)
+	gateway = middleware.Traced("signing", middleware.RequestSigning(signer, headerName))(gateway)
+	gateway = middleware.RequestID()(gateway)
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	slog.Info("MCP gateway enabled", "servers", names, "loop_detection", cycles != nil)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := mcp.Route(r.URL.Path); ok {
+			gateway.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// initCallbacks returns the dispatcher delivering deferred results and batch
+// settlements to the callback URLs in CALLBACKS_FILE, or nil when none are
+// configured. Deliveries are queued in Redis so any instance can retry them.
+func initCallbacks(rateLimiter *ratelimit.RateLimiter) *callbacks.Dispatcher {
+	if os.Getenv("CALLBACKS_FILE") == "" {
+		return nil
+	}
+	if rateLimiter == nil {
+		slog.Warn("Callbacks need Redis; results will only be available by polling")
+		return nil
+	}
+	dispatcher, err := callbacks.FromEnv(callbacks.NewRedisStore(rateLimiter.Redis()))
+	if err != nil {
+		slog.Error("Failed to load callback endpoints", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Callback delivery enabled", "file", os.Getenv("CALLBACKS_FILE"))
+	return dispatcher
+}
+
+// startQuotaSync reconciles tracked spend against the provider's billing API when
+// an admin key for the proxied provider is configured.
+func startQuotaSync(ctx context.Context, rateLimiter *ratelimit.RateLimiter, provider providers.Provider, store *secrets.Store) {
+	source := quotasync.SourceFromEnv(provider.Name(), provider.BaseURL(), initTransport(provider, provider.BaseURL()), store.Get)
+	if source == nil {
+		return
+	}
+	rateLimiter.TrackDailyUsage(provider.Name())
+
+	interval := time.Hour
+	if v, err := strconv.Atoi(os.Getenv("QUOTA_SYNC_INTERVAL_SECONDS")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+	threshold := 0.1
+	if v, err := strconv.ParseFloat(os.Getenv("QUOTA_SYNC_DRIFT_THRESHOLD"), 64); err == nil && v > 0 {
+		threshold = v
+	}
+	go quotasync.New(source, rateLimiter, threshold).Run(ctx, interval)
+	slog.Info("Quota sync enabled", "provider", provider.Name(), "interval", interval, "drift_threshold", threshold)
+}
+
+// startAdminServers starts the admin API (ADMIN_PORT) and dashboard (DASHBOARD_PORT)
+// listeners when configured. Both are disabled by default.
+func startAdminServers(rateLimiter *ratelimit.RateLimiter, secretStore *secrets.Store, opts admin.Options) []*http.Server {
+	var store admin.SpendStore
+	if rateLimiter != nil {
+		store = rateLimiter
+	}
+	opts.TokenFunc = func() string { return secretStore.Get("ADMIN_TOKEN") }
+	opts.Secrets = secretStore
+	if currency, ok, err := ratelimit.DisplayCurrencyFromEnv(); err != nil {
+		slog.Warn("Display currency disabled", "error", err)
+	} else if ok {
+		opts.Currency = &currency
+	}
+
+	var servers []*http.Server
+	if addr := listenAddr(os.Getenv("ADMIN_PORT")); addr != "" {
+		if opts.TokenFunc() == "" {
+			slog.Warn("ADMIN_TOKEN is not set; the admin API is read-only and unauthenticated", "addr", addr)
+		}
+		servers = append(servers, startAuxServer("Admin API", addr, admin.NewHandler(store, nil, opts)))
+	}
+	if addr := listenAddr(os.Getenv("DASHBOARD_PORT")); addr != "" {
+		dashboardOpts := opts
+		dashboardOpts.ReadOnly = true
+		servers = append(servers, startAuxServer("Dashboard", addr, dashboard.NewHandler(admin.NewHandler(store, nil, dashboardOpts))))
+	}
+	return servers
+}
+
+func startAuxServer(name, addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error(name+" server failed", "error", err, "port", addr)
+		}
+	}()
+	slog.Info(name+" started", "port", addr)
+	return server
+}
+
+// startGRPCServer serves the proxy over gRPC on GRPC_PORT, running calls through
+// the same handler as HTTP requests. Disabled by default.
+func startGRPCServer(handler http.Handler) *grpc.Server {
+	addr := listenAddr(os.Getenv("GRPC_PORT"))
+	if addr == "" {
+		return nil
+	}
+	maxMessage := 32 << 20
+	if v, err := strconv.Atoi(os.Getenv("GRPC_MAX_MESSAGE_BYTES")); err == nil && v > 0 {
+		maxMessage = v
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("gRPC server failed", "error", err, "port", addr)
+		return nil
+	}
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxMessage), grpc.MaxSendMsgSize(maxMessage))
+	grpcingress.New(handler).Register(server)
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			slog.Error("gRPC server failed", "error", err, "port", addr)
+		}
+	}()
+	slog.Info("gRPC server started", "port", addr)
+	return server
+}
+
+// listenAddr accepts either a bare port ("9090") or a listen address (":9090", "127.0.0.1:9090").
+func listenAddr(v string) string {
+	if v == "" || strings.Contains(v, ":") {
+		return v
+	}
+	return ":" + v
+}
+
+// runDoctor prints a readiness report for the current configuration.
+func runDoctor() int {
+	store, secretsErr := secrets.FromEnv(context.Background())
+	provider, providerErr := resolveProvider(store)
+	return doctor.Run(context.Background(), doctor.Options{
+		Secrets:           store,
+		SecretsErr:        secretsErr,
+		Provider:          provider,
+		ProviderErr:       providerErr,
+		RedisURL:          os.Getenv("REDIS_URL"),
+		EmbeddingRedisURL: os.Getenv("EMBEDDING_REDIS_URL"),
+		SidecarUDS:        loopSidecarUDS(),
+		ConfigDir:         os.Getenv("CONFIG_DIR"),
+	}, os.Stdout)
+}
+
+func gracefulShutdown(server *http.Server, grpcServer *grpc.Server, shutdownTracing, shutdownMetrics, shutdownLogs func(context.Context) error, stopBackground context.CancelFunc, auxServers ...*http.Server) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	slog.Info("Shutting down gracefully...")
+	stopBackground()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -213,10 +1009,39 @@ func gracefulShutdown(server *http.Server, shutdownTracing func(context.Context)
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Warn("Server shutdown error", "error", err)
 	}
+	for _, aux := range auxServers {
+		if err := aux.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Server shutdown error", "error", err, "port", aux.Addr)
+		}
+	}
+	if grpcServer != nil {
+		// GracefulStop waits for streams to finish; cut them off at the
+		// shutdown deadline like the HTTP server does.
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+	}
 
-	slog.Info("Waiting for in-flight operations to complete...")
-	remaining := async.Wait(shutdownCtx)
+	// Drain pending cost adjustments on their own deadline so a slow server
+	// shutdown does not eat the flush budget.
+	flushTimeout := 10 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("ASYNC_FLUSH_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		flushTimeout = time.Duration(v) * time.Second
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancelFlush()
+
+	slog.Info("Waiting for in-flight operations to complete...", "pending", async.QueueDepth(), "timeout", flushTimeout)
+	remaining := async.Wait(flushCtx)
 	if remaining > 0 {
+		telemetry.AddAsyncDropped(context.Background(), int64(remaining), "shutdown_timeout")
 		slog.Warn("Some async operations did not complete", "remaining", remaining)
 	} else {
 		slog.Info("All async operations completed")
@@ -225,6 +1050,14 @@ func gracefulShutdown(server *http.Server, shutdownTracing func(context.Context)
 	if err := shutdownTracing(shutdownCtx); err != nil {
 		slog.Warn("Tracing shutdown error", "error", err)
 	}
+	// After the async drain so the final cost deltas are exported.
+	if err := shutdownMetrics(shutdownCtx); err != nil {
+		slog.Warn("Metrics shutdown error", "error", err)
+	}
 
 	slog.Info("Shutdown complete")
+	// Flushed last so the shutdown messages above are exported too.
+	if err := shutdownLogs(shutdownCtx); err != nil {
+		slog.Warn("Log export shutdown error", "error", err)
+	}
 }
@@ -0,0 +1,309 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v5.26.1
+// source: sentinel.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ForwardRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// HTTP method; POST when empty.
+	Method string `protobuf:"bytes,1,opt,name=method,proto3" json:"method,omitempty"`
+	// Provider API path and query, e.g. "/v1/chat/completions".
+	Path string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	// Request headers. gRPC metadata is applied as headers too, so the tenant
+	// header may be sent either way; these take precedence.
+	Headers map[string]string `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Request body, usually the provider's JSON payload.
+	Body          []byte `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardRequest) Reset() {
+	*x = ForwardRequest{}
+	mi := &file_sentinel_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardRequest) ProtoMessage() {}
+
+func (x *ForwardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sentinel_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardRequest.ProtoReflect.Descriptor instead.
+func (*ForwardRequest) Descriptor() ([]byte, []int) {
+	return file_sentinel_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ForwardRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ForwardRequest) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ForwardRequest) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type ForwardResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// HTTP status of the provider's response.
+	Status        int32             `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	Headers       map[string]string `protobuf:"bytes,2,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body          []byte            `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardResponse) Reset() {
+	*x = ForwardResponse{}
+	mi := &file_sentinel_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardResponse) ProtoMessage() {}
+
+func (x *ForwardResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_sentinel_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardResponse.ProtoReflect.Descriptor instead.
+func (*ForwardResponse) Descriptor() ([]byte, []int) {
+	return file_sentinel_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ForwardResponse) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *ForwardResponse) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ForwardResponse) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type ForwardChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// HTTP status, on the first message only.
+	Status int32 `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	// Response headers, on the first message only.
+	Headers       map[string]string `protobuf:"bytes,2,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Data          []byte            `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardChunk) Reset() {
+	*x = ForwardChunk{}
+	mi := &file_sentinel_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardChunk) ProtoMessage() {}
+
+func (x *ForwardChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_sentinel_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardChunk.ProtoReflect.Descriptor instead.
+func (*ForwardChunk) Descriptor() ([]byte, []int) {
+	return file_sentinel_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ForwardChunk) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *ForwardChunk) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ForwardChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_sentinel_proto protoreflect.FileDescriptor
+
+const file_sentinel_proto_rawDesc = "" +
+	"\n" +
+	"\x0esentinel.proto\x12\bsentinel\"\xcd\x01\n" +
+	"\x0eForwardRequest\x12\x16\n" +
+	"\x06method\x18\x01 \x01(\tR\x06method\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12?\n" +
+	"\aheaders\x18\x03 \x03(\v2%.sentinel.ForwardRequest.HeadersEntryR\aheaders\x12\x12\n" +
+	"\x04body\x18\x04 \x01(\fR\x04body\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xbb\x01\n" +
+	"\x0fForwardResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\x05R\x06status\x12@\n" +
+	"\aheaders\x18\x02 \x03(\v2&.sentinel.ForwardResponse.HeadersEntryR\aheaders\x12\x12\n" +
+	"\x04body\x18\x03 \x01(\fR\x04body\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb5\x01\n" +
+	"\fForwardChunk\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\x05R\x06status\x12=\n" +
+	"\aheaders\x18\x02 \x03(\v2#.sentinel.ForwardChunk.HeadersEntryR\aheaders\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x96\x01\n" +
+	"\x0fSentinelService\x12>\n" +
+	"\aForward\x12\x18.sentinel.ForwardRequest\x1a\x19.sentinel.ForwardResponse\x12C\n" +
+	"\rForwardStream\x12\x18.sentinel.ForwardRequest\x1a\x16.sentinel.ForwardChunk0\x01B\x1cZ\x1aagent-sentinel/proto;protob\x06proto3"
+
+var (
+	file_sentinel_proto_rawDescOnce sync.Once
+	file_sentinel_proto_rawDescData []byte
+)
+
+func file_sentinel_proto_rawDescGZIP() []byte {
+	file_sentinel_proto_rawDescOnce.Do(func() {
+		file_sentinel_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_sentinel_proto_rawDesc), len(file_sentinel_proto_rawDesc)))
+	})
+	return file_sentinel_proto_rawDescData
+}
+
+var file_sentinel_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_sentinel_proto_goTypes = []any{
+	(*ForwardRequest)(nil),  // 0: sentinel.ForwardRequest
+	(*ForwardResponse)(nil), // 1: sentinel.ForwardResponse
+	(*ForwardChunk)(nil),    // 2: sentinel.ForwardChunk
+	nil,                     // 3: sentinel.ForwardRequest.HeadersEntry
+	nil,                     // 4: sentinel.ForwardResponse.HeadersEntry
+	nil,                     // 5: sentinel.ForwardChunk.HeadersEntry
+}
+var file_sentinel_proto_depIdxs = []int32{
+	3, // 0: sentinel.ForwardRequest.headers:type_name -> sentinel.ForwardRequest.HeadersEntry
+	4, // 1: sentinel.ForwardResponse.headers:type_name -> sentinel.ForwardResponse.HeadersEntry
+	5, // 2: sentinel.ForwardChunk.headers:type_name -> sentinel.ForwardChunk.HeadersEntry
+	0, // 3: sentinel.SentinelService.Forward:input_type -> sentinel.ForwardRequest
+	0, // 4: sentinel.SentinelService.ForwardStream:input_type -> sentinel.ForwardRequest
+	1, // 5: sentinel.SentinelService.Forward:output_type -> sentinel.ForwardResponse
+	2, // 6: sentinel.SentinelService.ForwardStream:output_type -> sentinel.ForwardChunk
+	5, // [5:7] is the sub-list for method output_type
+	3, // [3:5] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_sentinel_proto_init() }
+func file_sentinel_proto_init() {
+	if File_sentinel_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_sentinel_proto_rawDesc), len(file_sentinel_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sentinel_proto_goTypes,
+		DependencyIndexes: file_sentinel_proto_depIdxs,
+		MessageInfos:      file_sentinel_proto_msgTypes,
+	}.Build()
+	File_sentinel_proto = out.File
+	file_sentinel_proto_goTypes = nil
+	file_sentinel_proto_depIdxs = nil
+}
@@ -0,0 +1,355 @@
+// Package sentinel enforces Agent Sentinel's budgets and policies inside a Go
+// application instead of in front of it. Transport wraps an
+// http.RoundTripper: requests the application sends to its provider run
+// through the same middleware the proxy uses (tool policy, request
+// validation, output token caps, spend limits and loop detection), and their
+// cost is settled from the provider's response. With RedisURL pointing at the
+// proxy's Redis, spend is shared with proxies and other embedded clients:
+//
+//	transport, err := sentinel.New(ctx, sentinel.Options{
+//		Provider: "openai",
+//		RedisURL: os.Getenv("REDIS_URL"),
+//		Tenant:   "research-agent",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer transport.Close()
+//	client := openai.NewClient(option.WithHTTPClient(&http.Client{Transport: transport}))
+//
+// Denied requests get the same responses the proxy sends, e.g. a 429 with
+// code rate_limit_exceeded.
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-sentinel/internal/async"
+	"agent-sentinel/internal/config"
+	"agent-sentinel/internal/handlers"
+	"agent-sentinel/internal/loopdetect"
+	"agent-sentinel/internal/middleware"
+	"agent-sentinel/internal/providers"
+	"agent-sentinel/internal/providers/anthropic"
+	"agent-sentinel/internal/providers/gemini"
+	"agent-sentinel/internal/providers/openai"
+	"agent-sentinel/internal/ratelimit"
+	"agent-sentinel/internal/telemetry"
+	"agent-sentinel/internal/toolpolicy"
+	"agent-sentinel/internal/validation"
+
+	"embedding-sidecar/envelope"
+	"embedding-sidecar/inflight"
+	"embedding-sidecar/redisconf"
+)
+
+// defaultFlushTimeout bounds how long Close waits for pending cost
+// settlements.
+const defaultFlushTimeout = 10 * time.Second
+
+// Options configures a Transport.
+type Options struct {
+	// Provider is the API the application calls: "openai", "anthropic" or
+	// "gemini". It selects how models, prompts and usage are read.
+	Provider string
+	// RedisURL is the Redis holding spend, limits and reservations, usually the
+	// one the proxy uses. The REDIS_* variables (namespace, TLS, pool) apply as
+	// for the proxy, and New migrates its key layout as the proxy does at
+	// start. Empty disables spend limits.
+	RedisURL string
+	// TenantHeader names the tenant header (default X-Tenant-ID).
+	TenantHeader string
+	// Tenant is sent as the tenant header on requests that do not set one.
+	Tenant string
+	// ConfigDir holds pricing.json, limits.json, policies.json and models.json
+	// as for the proxy's CONFIG_DIR. They are reloaded when they change.
+	ConfigDir string
+	// LoopSidecarUDS is the embedding sidecar's socket; empty disables
+	// semantic loop detection. LOOP_TOOL_CYCLE_DETECTION applies without it.
+	LoopSidecarUDS string
+	// LoopTimeout bounds each loop check (default 1s).
+	LoopTimeout time.Duration
+	// Base sends the requests (default http.DefaultTransport).
+	Base http.RoundTripper
+}
+
+// Transport is an http.RoundTripper enforcing budgets and policies before
+// passing requests to its base transport. Safe for concurrent use.
+type Transport struct {
+	handler      http.Handler
+	tenantHeader string
+	tenant       string
+
+	redis *ratelimit.RedisClient
+	loop  *loopdetect.Client
+	stop  context.CancelFunc
+	// pending tracks this transport's cost settlements, which Close drains.
+	pending *inflight.Group
+}
+
+// New returns a Transport for opts. Background work (reservation
+// reconciliation, limit invalidation and config reloads) runs until Close.
+func New(ctx context.Context, opts Options) (*Transport, error) {
+	provider, err := newProvider(opts.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TenantHeader == "" {
+		opts.TenantHeader = "X-Tenant-ID"
+	}
+	if opts.LoopTimeout <= 0 {
+		opts.LoopTimeout = time.Second
+	}
+	if opts.Base == nil {
+		opts.Base = http.DefaultTransport
+	}
+	t := &Transport{tenantHeader: opts.TenantHeader, tenant: opts.Tenant, pending: async.NewGroup()}
+	background, stop := context.WithCancel(context.Background())
+	t.stop = stop
+
+	var rateLimiter *ratelimit.RateLimiter
+	var limiter ratelimit.Limiter
+	if opts.RedisURL != "" {
+		client, err := ratelimit.ConnectRedis(ctx, opts.RedisURL, redisconf.FromEnv("REDIS_"))
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("connect to Redis: %w", err)
+		}
+		t.redis = client
+		// Usage records are encrypted like the proxy's, so both can read them.
+		keyring, err := envelope.FromEnv(client.Client(), client.Namespace())
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("usage log encryption: %w", err)
+		}
+		// Like the proxy, run without spend limits when Redis is unavailable.
+		if rateLimiter = ratelimit.NewRateLimiter(client); rateLimiter != nil {
+			if keyring != nil {
+				rateLimiter.SetKeyring(keyring)
+			}
+			rateLimiter.MigrateOnStart()
+			limiter = rateLimiter
+			go rateLimiter.RunReconciler(background, time.Minute)
+			go rateLimiter.RunLimitInvalidator(background)
+		} else {
+			slog.Warn("Spend limits disabled: Redis is not available")
+		}
+	}
+
+	outputTokens := ratelimit.NewOutputTokenGuard()
+	models := ratelimit.NewModelCatalog()
+	validator := validation.NewValidator()
+	tools := toolpolicy.NewGuard()
+	loopBypass := loopdetect.NewBypassGuard()
+	loopHints := loopdetect.NewHints(os.Getenv("LOOP_INTERVENTION_HINT"))
+	loopCanon := loopdetect.NewCanonicalizer()
+	if opts.ConfigDir != "" {
+		apply := func(files *config.Files) {
+			outputTokens.Set(files.Policies.MaxTokens)
+			models.Set(files.Models)
+			validator.Set(files.Policies.Validation)
+			tools.Set(files.Policies.Tools)
+			loopBypass.Set(files.Policies.LoopBypass)
+			loopHints.Set(files.Policies.LoopHints)
+			loopCanon.Set(files.Policies.LoopCanonicalize)
+			if rateLimiter != nil {
+				files.ApplyLimits(background, rateLimiter)
+			}
+		}
+		files, err := config.LoadDir(opts.ConfigDir)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		apply(files)
+		if err := config.WatchDir(background, opts.ConfigDir, apply); err != nil {
+			slog.Warn("Config reload disabled", "error", err, "dir", opts.ConfigDir)
+		}
+	}
+	if opts.LoopSidecarUDS != "" {
+		t.loop, err = loopdetect.New(opts.LoopSidecarUDS, opts.LoopTimeout)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("loop detection: %w", err)
+		}
+	}
+
+	// The request already names its destination; the proxy only forwards it
+	// and settles its cost from the response.
+	proxy := &httputil.ReverseProxy{
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: telemetry.NewInstrumentedTransport(provider, opts.Base),
+		ModifyResponse: handlers.ChainModifyResponse(
+			handlers.CreateModifyResponse(limiter, provider),
+		),
+		ErrorHandler: handlers.CreateErrorHandler(limiter),
+	}
+
+	// Order as in the proxy: request ID -> validation -> tool policy -> output tokens -> rate limiting -> loop detection -> upstream
+	var handler http.Handler = proxy
+	if toolCycles := loopdetect.ToolCyclesFromEnv(loopCanon); t.loop != nil || toolCycles != nil {
+		var sidecar middleware.LoopClient
+		if t.loop != nil {
+			sidecar = t.loop
+		}
+		handler = middleware.Traced(middleware.StageLoopDetection, middleware.LoopDetection(sidecar, provider, opts.TenantHeader, loopHints, loopBypass, loopCanon, toolCycles, nil))(handler)
+	}
+	if limiter != nil {
+		handler = middleware.Traced(middleware.StageRateLimit, middleware.RateLimiting(limiter, provider, opts.TenantHeader, models))(handler)
+	}
+	handler = middleware.Traced(middleware.StageOutputTokens, middleware.OutputTokens(outputTokens, provider))(handler)
+	handler = middleware.Traced(middleware.StageToolPolicy, middleware.ToolPolicy(tools, provider, opts.TenantHeader))(handler)
+	handler = middleware.Traced("validation", middleware.Validation(validator, provider, models))(handler)
+	handler = telemetry.Middleware(provider, handler)
+	t.handler = middleware.RequestID()(handler)
+	return t, nil
+}
+
+// RoundTrip runs req through the enforcement chain. Requests the chain denies
+// get the proxy's error response rather than an error; the error is only set
+// when req's context ends before the response starts.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	r := req.Clone(async.WithGroup(ctx, t.pending))
+	if t.tenant != "" && r.Header.Get(t.tenantHeader) == "" {
+		r.Header.Set(t.tenantHeader, t.tenant)
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	// A client request's ContentLength of 0 with a body means the length is
+	// unknown, but the proxy chain reads 0 as no body. Buffer it to know.
+	if r.ContentLength == 0 && r.Body != http.NoBody {
+		data, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.ContentLength = int64(len(data))
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+
+	body, pw := io.Pipe()
+	w := &pipeWriter{header: http.Header{}, pw: pw, started: make(chan struct{})}
+	go func() {
+		defer w.finish()
+		t.handler.ServeHTTP(w, r)
+	}()
+	select {
+	case <-w.started:
+	case <-ctx.Done():
+		_ = body.CloseWithError(ctx.Err())
+		return nil, ctx.Err()
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          body,
+		ContentLength: contentLength(w.sent),
+		Request:       req,
+	}, nil
+}
+
+// contentLength returns the length the chain declared for its response, or -1
+// when it streams one of unknown length.
+func contentLength(h http.Header) int64 {
+	n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// Close stops background work, waits for pending cost settlements (up to
+// ASYNC_FLUSH_TIMEOUT_SECONDS, default 10) and closes the connections.
+func (t *Transport) Close() error {
+	t.stop()
+	flushTimeout := defaultFlushTimeout
+	if v, err := strconv.Atoi(os.Getenv("ASYNC_FLUSH_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		flushTimeout = time.Duration(v) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if remaining := t.pending.Wait(ctx); remaining > 0 {
+		slog.Warn("Some cost settlements did not complete", "remaining", remaining)
+	}
+	var errs []error
+	if t.loop != nil {
+		errs = append(errs, t.loop.Close())
+	}
+	if t.redis != nil {
+		errs = append(errs, t.redis.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// newProvider returns the provider adapter by name. The application supplies
+// its own credentials, so the adapter gets none.
+func newProvider(name string) (providers.Provider, error) {
+	switch strings.ToLower(name) {
+	case "openai":
+		return openai.New("")
+	case "anthropic":
+		return anthropic.New("")
+	case "gemini":
+		return gemini.New("")
+	}
+	return nil, fmt.Errorf("unknown provider %q (want openai, anthropic or gemini)", name)
+}
+
+// pipeWriter streams the chain's response into the body RoundTrip returns.
+// started is closed once the status is known.
+type pipeWriter struct {
+	header http.Header
+	pw     *io.PipeWriter
+
+	once    sync.Once
+	started chan struct{}
+	status  int
+	sent    http.Header
+}
+
+func (w *pipeWriter) Header() http.Header { return w.header }
+
+func (w *pipeWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = w.header.Clone()
+		close(w.started)
+	})
+}
+
+func (w *pipeWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+// Flush starts the response; writes reach the reader as they happen.
+func (w *pipeWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// finish ends the body once the chain returns.
+func (w *pipeWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	_ = w.pw.Close()
+}
@@ -0,0 +1,180 @@
+package sentinel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-sentinel/internal/async"
+)
+
+func newTransport(t *testing.T, opts Options) *Transport {
+	t.Helper()
+	transport, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func TestRoundTrip(t *testing.T) {
+	var gotTenant, gotAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, gotAuth = r.Header.Get("X-Tenant-ID"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","usage":{"prompt_tokens":3,"completion_tokens":2}}`))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: newTransport(t, Options{Provider: "openai", Tenant: "agent-1"})}
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	req.Header.Set("Authorization", "Bearer sk-app")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "chatcmpl-1") || resp.Header.Get("X-Request-ID") == "" {
+		t.Fatalf("response = %d %v %s", resp.StatusCode, resp.Header, body)
+	}
+	if gotTenant != "agent-1" || gotAuth != "Bearer sk-app" {
+		t.Fatalf("upstream saw tenant %q, auth %q", gotTenant, gotAuth)
+	}
+	if req.Header.Get("X-Tenant-ID") != "" {
+		t.Fatal("expected the caller's request not to be modified")
+	}
+}
+
+func TestRoundTripPolicyDenied(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "policies.json"), []byte(`{"tools":{"block":["shell"],"action":"reject"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: newTransport(t, Options{Provider: "openai", Tenant: "agent-1", ConfigDir: dir})}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"gpt-4o","messages":[],"tools":[{"type":"function","function":{"name":"shell"}}]}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 400 || !strings.Contains(string(body), `"error"`) || calls != 0 {
+		t.Fatalf("response = %d %s after %d upstream calls, want a denial", resp.StatusCode, body, calls)
+	}
+}
+
+func TestRoundTripValidationBeforeToolPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policies := `{"validation":{"enabled":true},"tools":{"block":["shell"],"action":"reject"}}`
+	if err := os.WriteFile(filepath.Join(dir, "policies.json"), []byte(policies), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// The request fails both checks; as in the proxy, validation answers first.
+	client := &http.Client{Transport: newTransport(t, Options{Provider: "openai", Tenant: "agent-1", ConfigDir: dir})}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"gpt-4o","messages":[],"temperature":5,"tools":[{"type":"function","function":{"name":"shell"}}]}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), `"invalid_request"`) {
+		t.Fatalf("response = %d %s, want the validation error", resp.StatusCode, body)
+	}
+}
+
+func TestRoundTripStreams(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: newTransport(t, Options{Provider: "openai"})}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt-4o","stream":true}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, len("data: 1\n\n"))
+	if _, err := io.ReadFull(resp.Body, first); err != nil || string(first) != "data: 1\n\n" {
+		t.Fatalf("first event = %q, %v; want it before the stream ends", first, err)
+	}
+	close(release)
+	rest, _ := io.ReadAll(resp.Body)
+	if string(rest) != "data: [DONE]\n\n" {
+		t.Fatalf("rest = %q", rest)
+	}
+}
+
+func TestRoundTripContentLength(t *testing.T) {
+	var got string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		got = string(raw)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	}))
+	defer upstream.Close()
+
+	// A reader of unknown length leaves the request's ContentLength at 0.
+	const body = `{"model":"gpt-4o","messages":[]}`
+	client := &http.Client{Transport: newTransport(t, Options{Provider: "openai"})}
+	resp, err := client.Post(upstream.URL+"/v1/chat/completions", "application/json", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got != body {
+		t.Fatalf("upstream got body %q, want %q", got, body)
+	}
+	if resp.ContentLength != int64(len(data)) {
+		t.Fatalf("ContentLength = %d, want %d", resp.ContentLength, len(data))
+	}
+}
+
+func TestCloseWaitsOnlyForOwnWork(t *testing.T) {
+	// Work elsewhere in the process, e.g. another transport's settlements.
+	release := make(chan struct{})
+	defer close(release)
+	async.Run(func() { <-release })
+
+	transport, err := New(context.Background(), Options{Provider: "openai"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Setenv("ASYNC_FLUSH_TIMEOUT_SECONDS", "5")
+	start := time.Now()
+	transport.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close took %v waiting for work that is not its own", elapsed)
+	}
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	if _, err := New(context.Background(), Options{Provider: "mistral"}); err == nil {
+		t.Fatal("expected an unknown provider to be rejected")
+	}
+}